@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/server"
+	"github.com/google/subcommands"
+
+	"github.com/sirupsen/logrus"
+)
+
+type backupCmd struct {
+	driver     string
+	dsn        string
+	blobDir    string
+	clickhouse string
+	output     string
+}
+
+func (*backupCmd) Name() string     { return "backup" }
+func (*backupCmd) Synopsis() string { return "Backup users, settings and records." }
+func (*backupCmd) Usage() string {
+	return `backup [-o file]:
+  dump users, settings, records and captured files to a portable archive, restore by godnslog restore -backup.
+`
+}
+
+func (p *backupCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.output, "o", "godnslog-backup.tar.gz", "set backup file, option")
+	f.StringVar(&p.blobDir, "blob", "blob", "set directory of captured files, option")
+	f.StringVar(&p.clickhouse, "clickhouse", "", "set clickhouse http url of dns and http records, option")
+	f.StringVar(&p.dsn, "dsn", "file:godnslog.db?cache=shared&mode=rwc", "set database source name, option")
+	f.StringVar(&p.driver, "driver", "sqlite3", "set database driver, [sqlite3/mysql/postgres], option")
+}
+
+func (p *backupCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	var clickhouse *server.ClickHouse
+	var err error
+	if p.clickhouse != "" {
+		clickhouse, err = server.NewClickHouse(p.clickhouse)
+		if err != nil {
+			fmt.Printf("clickhouse: %v\n", err)
+			return subcommands.ExitUsageError
+		}
+	}
+
+	store := cache.NewCache(24*3600*time.Second, 10*time.Minute)
+
+	web, err := server.NewWebServer(&server.WebServerConfig{
+		Driver:                       p.driver,
+		Dsn:                          p.dsn,
+		Domain:                       "example.com",
+		IP:                           "127.0.0.1",
+		Listen:                       ":8080",
+		Swagger:                      false,
+		BlobDir:                      p.blobDir,
+		ClickHouse:                   clickhouse,
+		AuthExpire:                   AuthExpire,
+		DefaultCleanInterval:         DefaultCleanInterval,
+		DefaultQueryApiMaxItem:       DefaultQueryApiMaxItem,
+		DefaultMaxCallbackErrorCount: DefaultMaxCallbackErrorCount,
+		DefaultLanguage:              DefaultLanguage,
+		DefaultMaxBodySize:           DefaultMaxBodySize,
+		DefaultPayloadQuota:          DefaultPayloadQuota,
+		MaxPayloadFileSize:           DefaultMaxPayloadFileSize,
+	}, store)
+	if err != nil {
+		logrus.Fatalf("[backup.go::Execute] NewWebServer: %v", err)
+	}
+
+	fp, err := os.Create(p.output)
+	if err != nil {
+		fmt.Printf("create: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	err = web.Backup(fp)
+	if err == nil {
+		err = fp.Close()
+	} else {
+		fp.Close()
+	}
+	if err != nil {
+		os.Remove(p.output)
+		fmt.Printf("backup: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	fmt.Printf("backup saved to %v\n", p.output)
+	return subcommands.ExitSuccess
+}
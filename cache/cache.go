@@ -1,9 +1,14 @@
 package cache
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	gocache "github.com/patrickmn/go-cache"
+	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -12,30 +17,276 @@ const (
 	// passing in the same expiration duration as was given to New() or
 	// NewFrom() when the cache was created (e.g. 5 minutes.)
 	DefaultExpiration = gocache.DefaultExpiration
+
+	DEFAULT_QUEUE_SIZE = 10000
+	QUEUE_WAIT         = time.Second //max wait of Push if queue is full
 )
 
+// QueueStats is state of record queue
+type QueueStats struct {
+	Depth     int   `json:"depth"`            //records in queue
+	Size      int   `json:"size"`             //capacity of queue
+	Shared    int64 `json:"shared,omitempty"` //records in shared queue
+	Overflows int64 `json:"overflows"`        //pushes waited for full queue
+	Dropped   int64 `json:"dropped"`          //records dropped after wait
+}
+
+// Queue is queue of records shared by processes, eg. Redis, NATS or Kafka
+type Queue interface {
+	Push(x interface{}) error
+	Unpop(x interface{}) error //put back a popped record
+	Pop() (interface{}, error) //nil if no record for a while
+	Len() (int64, error)       //records in queue
+	Close() error
+}
+
+// Backend is storage of keys shared by processes and queue of records, eg. Redis
+type Backend interface {
+	Get(k string) (interface{}, bool)
+	Set(k string, x interface{}, d time.Duration)
+	Add(k string, x interface{}, d time.Duration) error
+	Delete(k string)
+	IncrementInt64(k string, n int64) (int64, error)
+
+	Queue
+}
+
+// NewQueue connect queue of records by url, eg. nats:// or kafka:// of REST Proxy
+func NewQueue(rawurl string) (Queue, error) {
+	switch {
+	case strings.HasPrefix(rawurl, "nats://"):
+		return NewNats(rawurl)
+	case strings.HasPrefix(rawurl, "kafka://"), strings.HasPrefix(rawurl, "kafka+https://"):
+		return NewKafka(rawurl)
+	case strings.HasPrefix(rawurl, "redis://"):
+		return NewRedis(rawurl)
+	}
+	return nil, fmt.Errorf("unsupported queue: %v", rawurl)
+}
+
 type Cache struct {
+	overflows int64 //atomic, first for alignment
+	dropped   int64
+
 	*gocache.Cache
 	rcdCh chan interface{}
+
+	//records pushed after Close are dropped
+	closeLock sync.RWMutex
+	closed    bool
+
+	//shared keys and records, local if nil
+	def      time.Duration
+	shared   Backend
+	queue    Queue
+	suffixes []string
+	outCh    chan interface{}
+	quit     chan struct{}
+	wg       sync.WaitGroup
 }
 
 func NewCache(def, interval time.Duration) *Cache {
+	return NewCacheWithQueue(def, interval, DEFAULT_QUEUE_SIZE)
+}
+
+// NewCacheWithQueue create cache with record queue of size
+func NewCacheWithQueue(def, interval time.Duration, size int) *Cache {
 	var c Cache
 	{
 		c.Cache = gocache.New(def, interval)
-		c.rcdCh = make(chan interface{}, 128)
+		c.rcdCh = make(chan interface{}, size)
 	}
 	return &c
 }
 
+// NewSharedCache keep keys with suffixes and records in shared backend, other keys are local
+func NewSharedCache(def, interval time.Duration, size int, shared Backend, suffixes ...string) *Cache {
+	return NewQueueCache(def, interval, size, shared, shared, suffixes...)
+}
+
+// NewQueueCache keep records in queue, keys with suffixes are kept in shared backend if not nil
+func NewQueueCache(def, interval time.Duration, size int, queue Queue, shared Backend, suffixes ...string) *Cache {
+	c := NewCacheWithQueue(def, interval, size)
+	c.def = def
+	c.shared = shared
+	c.queue = queue
+	c.suffixes = suffixes
+	c.outCh = make(chan interface{})
+	c.quit = make(chan struct{})
+
+	c.wg.Add(2)
+	go c.push()
+	go c.pop()
+	return c
+}
+
+func (self *Cache) isShared(k string) bool {
+	if self.shared == nil {
+		return false
+	}
+	for _, suffix := range self.suffixes {
+		if strings.HasSuffix(k, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *Cache) expiration(d time.Duration) time.Duration {
+	if d == DefaultExpiration {
+		return self.def
+	}
+	return d
+}
+
+func (self *Cache) Get(k string) (interface{}, bool) {
+	if self.isShared(k) {
+		return self.shared.Get(k)
+	}
+	return self.Cache.Get(k)
+}
+
+func (self *Cache) Set(k string, x interface{}, d time.Duration) {
+	if self.isShared(k) {
+		self.shared.Set(k, x, self.expiration(d))
+		return
+	}
+	self.Cache.Set(k, x, d)
+}
+
+func (self *Cache) Add(k string, x interface{}, d time.Duration) error {
+	if self.isShared(k) {
+		return self.shared.Add(k, x, self.expiration(d))
+	}
+	return self.Cache.Add(k, x, d)
+}
+
+func (self *Cache) Delete(k string) {
+	if self.isShared(k) {
+		self.shared.Delete(k)
+		return
+	}
+	self.Cache.Delete(k)
+}
+
+func (self *Cache) IncrementInt64(k string, n int64) (int64, error) {
+	if self.isShared(k) {
+		return self.shared.IncrementInt64(k, n)
+	}
+	return self.Cache.IncrementInt64(k, n)
+}
+
+// push move input records to shared queue until input is closed
+func (self *Cache) push() {
+	defer self.wg.Done()
+	for rcd := range self.rcdCh {
+		for {
+			err := self.queue.Push(rcd)
+			if err == nil {
+				break
+			}
+			logrus.Errorf("[cache.go::push] Push: %v", err)
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// pop move records of shared queue to output until Close, queued records are kept
+func (self *Cache) pop() {
+	defer self.wg.Done()
+	defer close(self.outCh)
+	for {
+		select {
+		case <-self.quit:
+			return
+		default:
+		}
+		rcd, err := self.queue.Pop()
+		if err != nil {
+			logrus.Errorf("[cache.go::pop] Pop: %v", err)
+			time.Sleep(time.Second)
+			continue
+		} else if rcd == nil {
+			continue
+		}
+		select {
+		case self.outCh <- rcd:
+		case <-self.quit:
+			if err = self.queue.Unpop(rcd); err != nil {
+				logrus.Errorf("[cache.go::pop] Unpop: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// Close input of records, output is closed after queued records
 func (self *Cache) Close() {
+	self.closeLock.Lock()
+	if self.closed {
+		self.closeLock.Unlock()
+		return
+	}
+	self.closed = true
 	close(self.rcdCh)
+	self.closeLock.Unlock()
+
+	if self.queue != nil {
+		close(self.quit)
+		self.wg.Wait()
+		self.queue.Close()
+	}
+	if self.shared != nil && Queue(self.shared) != self.queue {
+		self.shared.Close()
+	}
 }
 
-func (self *Cache) Input() chan<- interface{} {
-	return self.rcdCh
+// Push queue record, wait a while if queue is full, false if record is dropped or cache is closed
+func (self *Cache) Push(rcd interface{}) bool {
+	self.closeLock.RLock()
+	defer self.closeLock.RUnlock()
+	if self.closed {
+		return false
+	}
+	select {
+	case self.rcdCh <- rcd:
+		return true
+	default:
+	}
+	atomic.AddInt64(&self.overflows, 1)
+	timer := time.NewTimer(QUEUE_WAIT)
+	defer timer.Stop()
+	select {
+	case self.rcdCh <- rcd:
+		return true
+	case <-timer.C:
+	}
+	if n := atomic.AddInt64(&self.dropped, 1); n%1000 == 1 {
+		logrus.Warnf("[cache.go::Push] queue is full, %v records dropped", n)
+	}
+	return false
+}
+
+func (self *Cache) Stats() QueueStats {
+	stats := QueueStats{
+		Depth:     len(self.rcdCh),
+		Size:      cap(self.rcdCh),
+		Overflows: atomic.LoadInt64(&self.overflows),
+		Dropped:   atomic.LoadInt64(&self.dropped),
+	}
+	if self.queue != nil {
+		n, err := self.queue.Len()
+		if err != nil {
+			logrus.Errorf("[cache.go::Stats] Len: %v", err)
+		}
+		stats.Shared = n
+	}
+	return stats
 }
 
 func (self *Cache) Output() <-chan interface{} {
+	if self.queue != nil {
+		return self.outCh
+	}
 	return self.rcdCh
 }
@@ -0,0 +1,348 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Minimal redis client as Backend of shared keys and record queue
+	redis://:PASSWORD@127.0.0.1:6379/0?prefix=godnslog:
+values are gob encoded interface, concrete types should be registered by gob.Register,
+int64 is kept as decimal for INCRBY. records are queued in list ${prefix}queue
+*/
+
+const (
+	REDIS_TIMEOUT   = 5 * time.Second
+	REDIS_POP_WAIT  = 1 //seconds of BRPOP
+	REDIS_MAX_IDLE  = 16
+	REDIS_QUEUE_KEY = "queue"
+)
+
+// increment existing key only, like go-cache
+const redisIncrScript = `if redis.call('EXISTS', KEYS[1]) == 1 then return redis.call('INCRBY', KEYS[1], ARGV[1]) end return false`
+
+var errRedisNil = errors.New("redis: nil")
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+type Redis struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+
+	lock sync.Mutex
+	idle []*redisConn
+}
+
+func NewRedis(rawurl string) (*Redis, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported scheme: %v", u.Scheme)
+	}
+	r := &Redis{
+		addr:   u.Host,
+		prefix: u.Query().Get("prefix"),
+	}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.password, _ = u.User.Password()
+	}
+	if s := strings.Trim(u.Path, "/"); s != "" {
+		r.db, err = strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid db: %v", s)
+		}
+	}
+	//check connection
+	if _, err = r.do("PING"); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Redis) dial() (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", r.addr, REDIS_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{Conn: conn, r: bufio.NewReader(conn)}
+	if r.password != "" {
+		if _, err = c.do(REDIS_TIMEOUT, "AUTH", r.password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err = c.do(REDIS_TIMEOUT, "SELECT", r.db); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *redisConn) do(timeout time.Duration, args ...interface{}) (interface{}, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case []byte:
+			s = string(v)
+		default:
+			s = fmt.Sprint(v)
+		}
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(s), s)
+	}
+	c.SetDeadline(time.Now().Add(timeout))
+	if _, err := c.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read a reply, error reply is returned as redisError
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: invalid reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := 0; i < n; i++ {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: invalid reply %q", line)
+}
+
+// do send command by an idle connection, nil reply is errRedisNil
+func (r *Redis) do(args ...interface{}) (interface{}, error) {
+	return r.doTimeout(REDIS_TIMEOUT, args...)
+}
+
+func (r *Redis) doTimeout(timeout time.Duration, args ...interface{}) (interface{}, error) {
+	r.lock.Lock()
+	var c *redisConn
+	if n := len(r.idle); n > 0 {
+		c = r.idle[n-1]
+		r.idle = r.idle[:n-1]
+	}
+	r.lock.Unlock()
+	if c == nil {
+		var err error
+		if c, err = r.dial(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.do(timeout, args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		//broken connection
+		c.Close()
+		return nil, err
+	}
+	r.lock.Lock()
+	if len(r.idle) < REDIS_MAX_IDLE {
+		r.idle = append(r.idle, c)
+		c = nil
+	}
+	r.lock.Unlock()
+	if c != nil {
+		c.Close()
+	}
+	if err == nil && reply == nil {
+		err = errRedisNil
+	}
+	return reply, err
+}
+
+func encodeValue(x interface{}) ([]byte, error) {
+	if n, ok := x.(int64); ok {
+		return []byte(strconv.FormatInt(n, 10)), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(0)
+	if err := gob.NewEncoder(&buf).Encode(&x); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeValue(data []byte) (interface{}, error) {
+	if len(data) == 0 || data[0] != 0 {
+		return strconv.ParseInt(string(data), 10, 64)
+	}
+	var x interface{}
+	err := gob.NewDecoder(bytes.NewReader(data[1:])).Decode(&x)
+	return x, err
+}
+
+func redisExpire(args []interface{}, d time.Duration) []interface{} {
+	if d > 0 {
+		args = append(args, "PX", int64(d/time.Millisecond))
+	}
+	return args
+}
+
+func (r *Redis) Get(k string) (interface{}, bool) {
+	reply, err := r.do("GET", r.prefix+k)
+	if err != nil {
+		if err != errRedisNil {
+			logrus.Errorf("[redis.go::Get] %v: %v", k, err)
+		}
+		return nil, false
+	}
+	x, err := decodeValue(reply.([]byte))
+	if err != nil {
+		logrus.Errorf("[redis.go::Get] decode %v: %v", k, err)
+		return nil, false
+	}
+	return x, true
+}
+
+func (r *Redis) Set(k string, x interface{}, d time.Duration) {
+	data, err := encodeValue(x)
+	if err == nil {
+		_, err = r.do(redisExpire([]interface{}{"SET", r.prefix + k, data}, d)...)
+	}
+	if err != nil {
+		logrus.Errorf("[redis.go::Set] %v: %v", k, err)
+	}
+}
+
+func (r *Redis) Add(k string, x interface{}, d time.Duration) error {
+	data, err := encodeValue(x)
+	if err != nil {
+		return err
+	}
+	_, err = r.do(append(redisExpire([]interface{}{"SET", r.prefix + k, data}, d), "NX")...)
+	if err == errRedisNil {
+		return fmt.Errorf("Item %s already exists", k)
+	}
+	return err
+}
+
+func (r *Redis) Delete(k string) {
+	if _, err := r.do("DEL", r.prefix+k); err != nil {
+		logrus.Errorf("[redis.go::Delete] %v: %v", k, err)
+	}
+}
+
+func (r *Redis) IncrementInt64(k string, n int64) (int64, error) {
+	reply, err := r.do("EVAL", redisIncrScript, 1, r.prefix+k, n)
+	if err == errRedisNil {
+		return 0, fmt.Errorf("Item %s not found", k)
+	} else if err != nil {
+		return 0, err
+	}
+	return reply.(int64), nil
+}
+
+func (r *Redis) Push(x interface{}) error {
+	data, err := encodeValue(x)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("LPUSH", r.prefix+REDIS_QUEUE_KEY, data)
+	return err
+}
+
+// Unpop put back popped record to head of queue
+func (r *Redis) Unpop(x interface{}) error {
+	data, err := encodeValue(x)
+	if err != nil {
+		return err
+	}
+	_, err = r.do("RPUSH", r.prefix+REDIS_QUEUE_KEY, data)
+	return err
+}
+
+// Pop wait a record for a while, nil if queue is empty
+func (r *Redis) Pop() (interface{}, error) {
+	reply, err := r.doTimeout(REDIS_TIMEOUT+REDIS_POP_WAIT*time.Second, "BRPOP", r.prefix+REDIS_QUEUE_KEY, REDIS_POP_WAIT)
+	if err == errRedisNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	items := reply.([]interface{})
+	if len(items) != 2 {
+		return nil, fmt.Errorf("redis: invalid BRPOP reply")
+	}
+	return decodeValue(items[1].([]byte))
+}
+
+func (r *Redis) Len() (int64, error) {
+	reply, err := r.do("LLEN", r.prefix+REDIS_QUEUE_KEY)
+	if err != nil {
+		return 0, err
+	}
+	return reply.(int64), nil
+}
+
+func (r *Redis) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, c := range r.idle {
+		c.Close()
+	}
+	r.idle = nil
+	return nil
+}
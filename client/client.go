@@ -141,3 +141,84 @@ func (self *Client) QueryHttp(varirable string, blur bool) ([]models.HttpRecord,
 	txt, _ := ioutil.ReadAll(resp.Body)
 	return rcds, json.Unmarshal(txt, &cr)
 }
+
+func (self *Client) QueryHttpBody(id int64) ([]byte, error) {
+	c := self.Client
+
+	querys := make(url.Values)
+	querys.Set("t", fmt.Sprintf("%v", time.Now().Unix()))
+
+	hash := self.Hash(querys)
+	querys.Set("hash", hash)
+
+	u := fmt.Sprintf("%v/data/http/%v/body?%v", self.host, id, querys.Encode())
+	req, err := http.NewRequest("GET", u, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		var cr models.CR
+		txt, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(txt, &cr)
+		return nil, fmt.Errorf("code(%v), Reason:%v", resp.StatusCode, cr.Message)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (self *Client) QueryHttpFrames(id int64) ([]models.HttpFrame, error) {
+	c := self.Client
+
+	querys := make(url.Values)
+	querys.Set("t", fmt.Sprintf("%v", time.Now().Unix()))
+
+	hash := self.Hash(querys)
+	querys.Set("hash", hash)
+
+	u := fmt.Sprintf("%v/data/http/%v/frames?%v", self.host, id, querys.Encode())
+	req, err := http.NewRequest("GET", u, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		var cr models.CR
+		txt, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(txt, &cr)
+		return nil, fmt.Errorf("code(%v), Reason:%v", resp.StatusCode, cr.Message)
+	}
+
+	var frames []models.HttpFrame
+	var cr models.CR
+	cr.Result = &frames
+
+	txt, _ := ioutil.ReadAll(resp.Body)
+	return frames, json.Unmarshal(txt, &cr)
+}
+
+// Version get version and enabled features of server, eg. to detect apis supported
+func (self *Client) Version() (*models.VersionInfo, error) {
+	c := self.Client
+
+	u := fmt.Sprintf("%v/api/version", self.host)
+	resp, err := c.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		var cr models.CR
+		txt, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(txt, &cr)
+		return nil, fmt.Errorf("code(%v), Reason:%v", resp.StatusCode, cr.Message)
+	}
+
+	var info models.VersionInfo
+	var cr models.CR
+	cr.Result = &info
+
+	txt, _ := ioutil.ReadAll(resp.Body)
+	return &info, json.Unmarshal(txt, &cr)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+Config file of serve and ingest, keys are names of flags
+	godnslog serve -config /etc/godnslog.yaml
+	# /etc/godnslog.yaml
+	domain: example.com
+	"4": 1.2.3.4
+	driver: mysql
+	dsn: godnslog:PASSWORD@tcp(127.0.0.1:3306)/godnslog
+	smtp: [":25", ":587"]
+	telegram-token: "123:ABC"
+	clean-interval: 48h
+environment variables GODNSLOG_<NAME>, name of flag in upper case and - as _, eg. GODNSLOG_DSN, GODNSLOG_TELEGRAM_TOKEN,
+override the file, flags of command line override both. GODNSLOG_CONFIG is path of config file if -config is not set
+*/
+
+const CONFIG_ENV_PREFIX = "GODNSLOG_"
+
+// configEnv is name of environment variable of flag
+func configEnv(name string) string {
+	return CONFIG_ENV_PREFIX + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// configValue is value of flag, lists are comma separated
+func configValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, 0, len(x))
+		for _, item := range x {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// loadConfig set flags not set by command line from config file and environment variables
+func loadConfig(f *flag.FlagSet, path string) error {
+	explicit := map[string]bool{"config": true}
+	f.Visit(func(fl *flag.Flag) {
+		explicit[fl.Name] = true
+	})
+	if path == "" {
+		path = os.Getenv(configEnv("config"))
+	}
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("config: %v", err)
+		}
+		var values map[interface{}]interface{}
+		if err = yaml.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("config %v: %v", path, err)
+		}
+		names := make([]string, 0, len(values))
+		settings := make(map[string]interface{}, len(values))
+		for k, v := range values {
+			name := fmt.Sprint(k)
+			names = append(names, name)
+			settings[name] = v
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if f.Lookup(name) == nil || name == "config" {
+				return fmt.Errorf("config %v: unknown option %q, options are names of flags, see -h", path, name)
+			} else if explicit[name] {
+				continue
+			}
+			if err = f.Set(name, configValue(settings[name])); err != nil {
+				return fmt.Errorf("config %v: invalid %v: %v", path, name, err)
+			}
+		}
+	}
+
+	var err error
+	f.VisitAll(func(fl *flag.Flag) {
+		env := configEnv(fl.Name)
+		v, exist := os.LookupEnv(env)
+		if !exist || explicit[fl.Name] || err != nil {
+			return
+		}
+		if e := f.Set(fl.Name, v); e != nil {
+			err = fmt.Errorf("env %v: %v", env, e)
+		}
+	})
+	return err
+}
+
+// configErrors is problems of options found by validation, all are reported at once
+type configErrors []string
+
+func (e *configErrors) add(format string, args ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+func (e configErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid options:\n  %v", strings.Join(e, "\n  "))
+}
+
+func (e *configErrors) domain(name, domain string) {
+	if domain == "" {
+		e.add("%v required", name)
+	} else if _, ok := dns.IsDomainName(domain); !ok || strings.Contains(domain, "/") {
+		e.add("%v: %q is not a domain name, eg. example.com", name, domain)
+	}
+}
+
+func (e *configErrors) ipv4(name, ip string) {
+	if ip == "" {
+		e.add("%v required, public IPv4 of server", name)
+	} else if x := net.ParseIP(ip); x == nil || x.To4() == nil {
+		e.add("%v: %q is not an IPv4 address", name, ip)
+	}
+}
+
+// listen check comma separated listen addresses, empty is disabled
+func (e *configErrors) listen(name, addrs string) {
+	if addrs == "" {
+		return
+	}
+	for _, addr := range strings.Split(addrs, ",") {
+		_, port, err := net.SplitHostPort(addr)
+		if err == nil {
+			_, err = net.LookupPort("tcp", port)
+		}
+		if err != nil {
+			e.add("%v: %q is not a listen address, eg. :8080 or 127.0.0.1:8080", name, addr)
+		}
+	}
+}
+
+// splitList split comma separated values, empty ones are dropped
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// cidrs check comma separated ip or cidr, empty is disabled
+func (e *configErrors) cidrs(name, s string) {
+	for _, item := range splitList(s) {
+		if _, _, err := net.ParseCIDR(item); err != nil && net.ParseIP(item) == nil {
+			e.add("%v: %q is not an ip or cidr, eg. 10.0.0.0/8", name, item)
+		}
+	}
+}
+
+// origins check comma separated origins of CORS, eg. https://ui.example.com, https://*.example.com or *
+func (e *configErrors) origins(name, s string) {
+	for _, origin := range splitList(s) {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			e.add("%v: %q is not an origin, eg. https://ui.example.com", name, origin)
+		}
+	}
+}
+
+// methods check comma separated http methods
+func (e *configErrors) methods(name, s string) {
+	methods := splitList(s)
+	if len(methods) == 0 {
+		e.add("%v required", name)
+	}
+	for _, m := range methods {
+		switch m {
+		case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS":
+		default:
+			e.add("%v: %q is not a http method, eg. GET", name, m)
+		}
+	}
+}
+
+// url check url of schemes, empty is disabled
+func (e *configErrors) url(name, rawurl string, schemes ...string) {
+	if rawurl == "" {
+		return
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		e.add("%v: %q is not an url", name, rawurl)
+		return
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return
+		}
+	}
+	e.add("%v: scheme %q not supported, should be one of %v", name, u.Scheme, strings.Join(schemes, ", "))
+}
+
+// file check file exists, empty is disabled
+func (e *configErrors) file(name, path string) {
+	if path == "" {
+		return
+	}
+	if st, err := os.Stat(path); err != nil {
+		e.add("%v: %v", name, err)
+	} else if st.IsDir() {
+		e.add("%v: %v is a directory", name, path)
+	}
+}
+
+func (e *configErrors) positive(name string, v int64) {
+	if v <= 0 {
+		e.add("%v: should be positive, got %v", name, v)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build embed
+// +build embed
+
+package frontend
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed dist
+var dist embed.FS
+
+func init() {
+	Dist, _ = fs.Sub(dist, "dist")
+}
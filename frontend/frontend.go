@@ -0,0 +1,9 @@
+// Package frontend is web ui built by yarn, embedded in binary when built with tag embed
+//
+//	cd frontend && yarn build && cd .. && go build -tags embed
+package frontend
+
+import "io/fs"
+
+// Dist is files of dist, nil if not embedded
+var Dist fs.FS
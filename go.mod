@@ -1,6 +1,6 @@
 module github.com/chennqqi/godnslog
 
-go 1.14
+go 1.16
 
 require (
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751
@@ -9,10 +9,13 @@ require (
 	github.com/gin-contrib/static v0.0.0-20200815103939-31fb0c56a3d1
 	github.com/gin-gonic/gin v1.6.3
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/golang/protobuf v1.4.3
 	github.com/google/subcommands v1.2.0
-	github.com/hashicorp/go-retryablehttp v0.6.7
+	github.com/graphql-go/graphql v0.7.9
+	github.com/lib/pq v1.7.0
 	github.com/mattn/go-sqlite3 v1.14.2
 	github.com/miekg/dns v1.1.31
+	github.com/oschwald/maxminddb-golang v1.8.0
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/sirupsen/logrus v1.6.0
@@ -21,5 +24,11 @@ require (
 	github.com/swaggo/gin-swagger v1.2.0
 	github.com/swaggo/swag v1.6.7
 	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
+	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
+	golang.org/x/sys v0.0.0-20200323222414-85ca7c5b95cd
+	google.golang.org/grpc v1.33.2
+	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v2 v2.2.8
+	xorm.io/builder v0.3.7
 	xorm.io/xorm v1.0.3
 )
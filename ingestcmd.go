@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/server"
+	"github.com/google/subcommands"
+
+	"github.com/sirupsen/logrus"
+)
+
+type ingestCmd struct {
+	config     string
+	domain     string
+	ipv4       string
+	central    string
+	key        string
+	httpListen string
+	queueSize  int
+	sync       time.Duration
+	batch      int
+	flush      time.Duration
+
+	trustedProxies string
+	proxyProtocol  bool
+}
+
+func (*ingestCmd) Name() string { return "ingest" }
+func (*ingestCmd) Synopsis() string {
+	return "Capture dns/http hits and forward them to central instance."
+}
+func (*ingestCmd) Usage() string {
+	return `ingest -central <url> -key <key> -domain <domain> -4 <ip>:
+  run dns and http listeners without database, records are forwarded to serve -ingest-key of central.
+`
+}
+
+func (p *ingestCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.config, "config", "", "set yaml config file, keys are names of flags, env GODNSLOG_<NAME> overrides it, option")
+	f.StringVar(&p.domain, "domain", "example.com", "set domain, required")
+	f.StringVar(&p.ipv4, "4", "", "set public IPv4 of node, required")
+	f.StringVar(&p.central, "central", "", "set url of central instance, eg. https://log.example.com, required")
+	f.StringVar(&p.key, "key", "", "set ingest key of central instance, required")
+	f.StringVar(&p.httpListen, "http", ":8080", "set http listen, empty to capture dns only, option")
+	f.StringVar(&p.trustedProxies, "trusted-proxies", "", "set comma separated ip or cidr of proxies, their X-Forwarded-For and X-Real-IP are honored, eg. 10.0.0.0/8,127.0.0.1, option")
+	f.BoolVar(&p.proxyProtocol, "proxy-protocol", false, "accept PROXY protocol v1/v2 header of trusted proxies on http listener, option")
+	f.IntVar(&p.queueSize, "queue-size", cache.DEFAULT_QUEUE_SIZE, "set max records waiting to be forwarded, more are dropped, option")
+	f.DurationVar(&p.sync, "sync", server.DEFAULT_INGEST_SYNC, "set interval to sync users from central, option")
+	f.IntVar(&p.batch, "batch", server.DEFAULT_INGEST_BATCH, "set max records of a forward, option")
+	f.DurationVar(&p.flush, "flush", server.DEFAULT_INGEST_FLUSH, "set max delay of records before forward, option")
+}
+
+func (p *ingestCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	sigCh := make(chan os.Signal, 1)
+	stopped := serveService(sigCh)
+	defer stopped()
+
+	if err := loadConfig(f, p.config); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitUsageError
+	}
+	var e configErrors
+	e.domain("domain", p.domain)
+	e.ipv4("4", p.ipv4)
+	if p.central == "" {
+		e.add("central required, url of central instance")
+	}
+	e.url("central", p.central, "https", "http")
+	if p.key == "" {
+		e.add("key required, ingest key of central instance")
+	}
+	e.listen("http", p.httpListen)
+	e.cidrs("trusted-proxies", p.trustedProxies)
+	if p.proxyProtocol && strings.TrimSpace(p.trustedProxies) == "" {
+		e.add("proxy-protocol: trusted-proxies required, headers of other peers are not accepted")
+	}
+	e.positive("queue-size", int64(p.queueSize))
+	e.positive("batch", int64(p.batch))
+	if err := e.err(); err != nil {
+		fmt.Println(err)
+		return subcommands.ExitUsageError
+	}
+
+	var wg sync.WaitGroup
+	store := cache.NewCacheWithQueue(24*3600*time.Second, 10*time.Minute, p.queueSize)
+
+	//validated already, nil if empty
+	proxies, _ := server.ParseTrustedProxies(p.trustedProxies)
+	if proxies != nil {
+		proxies.ProxyProtocol = p.proxyProtocol
+	}
+	node, err := server.NewIngestNode(&server.IngestNodeConfig{
+		Central:     p.central,
+		Key:         p.key,
+		Domain:      p.domain,
+		Listen:      p.httpListen,
+		MaxBodySize: DefaultMaxBodySize,
+		Sync:        p.sync,
+		Batch:       p.batch,
+		Flush:       p.flush,
+
+		TrustedProxies: proxies,
+	}, store)
+	if err != nil {
+		logrus.Fatalf("[ingestcmd.go::Execute] NewIngestNode: %v", err)
+	}
+
+	dns, err := server.NewDnsServer(&server.DnsServerConfig{
+		Domain:   p.domain,
+		RTimeout: 3 * time.Second,
+		WTimeout: 3 * time.Second,
+		V4:       net.ParseIP(p.ipv4),
+
+		// custom resolve
+		Fixed: []server.Resolve{
+			{Name: "www", Type: "A", Value: p.ipv4, Ttl: 600},
+			{Name: "api", Type: "A", Value: p.ipv4, Ttl: 600},
+		},
+	}, store)
+	if err != nil {
+		logrus.Fatalf("[ingestcmd.go::Execute] NewDnsServer: %v", err)
+	}
+
+	quit := make(chan struct{})
+
+	//run user sync routine
+	{
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.RunSync(quit)
+		}()
+	}
+
+	//run forward routine
+	{
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.RunForward()
+		}()
+	}
+
+	//run dns server
+	{
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dns.Run()
+		}()
+	}
+
+	//run http capture
+	if p.httpListen != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := node.Run(); err != nil {
+				logrus.Errorf("[ingestcmd.go::Execute] Run: %v", err)
+			}
+		}()
+	}
+
+	signal.Notify(sigCh, os.Kill, os.Interrupt)
+	if err := sdNotify("READY=1"); err != nil {
+		logrus.Warnf("[ingestcmd.go::Execute] sdNotify: %v", err)
+	}
+	<-sigCh
+	sdNotify("STOPPING=1")
+
+	dns.Shutdown()
+	node.Shutdown()
+	close(quit)
+	store.Close()
+
+	wg.Wait()
+
+	fmt.Println()
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+Log file rotated by size and age, rotated files are renamed with time, eg. godnslog.log.20201015-134600,
+the oldest ones beyond keep are removed
+	godnslog -log /var/log/godnslog.log -log-max-size 100 -log-rotate 24h -log-keep 7 serve ...
+*/
+
+type rotateWriter struct {
+	path    string
+	maxSize int64         //bytes, 0 is unlimited
+	maxAge  time.Duration //0 is disabled
+	keep    int           //rotated files, 0 keeps all
+
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func newRotateWriter(path string, maxSize int64, maxAge time.Duration, keep int) (*rotateWriter, error) {
+	w := &rotateWriter{path: path, maxSize: maxSize, maxAge: maxAge, keep: keep}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotateWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file, w.size, w.opened = f, st.Size(), time.Now()
+	return nil
+}
+
+// rotate rename current file and open a new one, remove old files beyond keep
+func (w *rotateWriter) rotate() error {
+	w.file.Close()
+	name := fmt.Sprintf("%v.%v", w.path, time.Now().Format("20060102-150405"))
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%v.%v.%v", w.path, time.Now().Format("20060102-150405"), i)
+	}
+	if err := os.Rename(w.path, name); err != nil {
+		fmt.Fprintf(os.Stderr, "rotate log: %v\n", err)
+	}
+	if w.keep > 0 {
+		olds, _ := filepath.Glob(w.path + ".*")
+		sort.Strings(olds)
+		for i := 0; i < len(olds)-w.keep; i++ {
+			os.Remove(olds[i])
+		}
+	}
+	return w.open()
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if (w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize) ||
+		(w.maxAge > 0 && time.Since(w.opened) >= w.maxAge) {
+		if err := w.rotate(); err != nil {
+			w.file = nil
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotateWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
@@ -1,10 +1,9 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"flag"
-	"log"
+	"fmt"
 	"os"
 	"strings"
 	"time"
@@ -19,53 +18,70 @@ const (
 	DefaultLanguage              = "en-US"
 	DefaultQueryApiMaxItem       = 20
 	DefaultMaxCallbackErrorCount = 5
+	DefaultMaxBodySize           = 1024 * 1024 //bytes
+	DefaultPayloadQuota          = 10 * 1024 * 1024
+	DefaultMaxPayloadFileSize    = 1024 * 1024
+	DefaultShutdownTimeout       = 30 * time.Second
 )
 
 func main() {
 	var (
-		logFile, logLevel string
+		logFile, logLevel, logFormat string
+		logMaxSize                   int64
+		logRotate                    time.Duration
+		logKeep                      int
 	)
 
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(&servePwCmd{}, "")
 	subcommands.Register(&resetPwCmd{}, "")
+	subcommands.Register(&backupCmd{}, "")
+	subcommands.Register(&restoreCmd{}, "")
+	subcommands.Register(&migrateCmd{}, "")
+	subcommands.Register(&ingestCmd{}, "")
+	subcommands.Register(&versionCmd{}, "")
+	subcommands.Register(&installCmd{}, "")
+	subcommands.Register(&uninstallCmd{}, "")
+	subcommands.Register(&userCmd{}, "")
 
 	//https://github.com/mattn/go-sqlite3/issues/39
 	flag.StringVar(&logFile, "log", "", "set log file, option")
-	flag.StringVar(&logLevel, "level", "WARN", "set loglevel, option")
+	flag.StringVar(&logLevel, "level", "WARN", "set loglevel, [TRACE/DEBUG/INFO/WARN/ERROR], option")
+	flag.StringVar(&logFormat, "log-format", "json", "set log format, [json/text], option")
+	flag.Int64Var(&logMaxSize, "log-max-size", 100, "set max MB of log file before rotation, 0 is unlimited, option")
+	flag.DurationVar(&logRotate, "log-rotate", 24*time.Hour, "set interval of log file rotation, 0 is disabled, option")
+	flag.IntVar(&logKeep, "log-keep", 7, "set count of rotated log files to keep, 0 keeps all, option")
 	flag.Parse()
 
-	switch strings.ToUpper(logLevel) {
-	case "DEBUG":
-		logrus.SetLevel(logrus.DebugLevel)
-	case "WARN":
-		logrus.SetLevel(logrus.WarnLevel)
-	case "INFO":
-		logrus.SetLevel(logrus.InfoLevel)
-	default:
-		logrus.SetLevel(logrus.WarnLevel)
-	}
 	// log & log level
 	{
+		level, err := logrus.ParseLevel(logLevel)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(int(subcommands.ExitUsageError))
+		}
+		logrus.SetLevel(level)
+
+		switch strings.ToLower(logFormat) {
+		case "json":
+			logrus.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+		case "text":
+			logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+		default:
+			fmt.Printf("invalid log format: %v\n", logFormat)
+			os.Exit(int(subcommands.ExitUsageError))
+		}
+
 		if logFile != "" {
-			f, err := os.Create(logFile)
+			w, err := newRotateWriter(logFile, logMaxSize<<20, logRotate, logKeep)
 			if err != nil {
-				log.Panicf("Open", logFile, err)
+				fmt.Printf("open log %v: %v\n", logFile, err)
+				os.Exit(1)
 			}
-			defer f.Close()
-			buf := bufio.NewWriter(f)
-			//async flush
-			go func() {
-				for {
-					time.Sleep(60 * time.Second)
-					buf.Flush()
-				}
-			}()
-			logrus.SetOutput(buf)
-			defer buf.Flush()
+			defer w.Close()
+			logrus.SetOutput(w)
 		}
-
 	}
 
 	ctx := context.Background()
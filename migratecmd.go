@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/chennqqi/godnslog/server"
+	"github.com/google/subcommands"
+)
+
+type migrateCmd struct {
+	driver string
+	dsn    string
+	down   int
+	list   bool
+}
+
+func (*migrateCmd) Name() string     { return "migrate" }
+func (*migrateCmd) Synopsis() string { return "Migrate database schema." }
+func (*migrateCmd) Usage() string {
+	return `migrate [-down n] [-list]:
+  apply pending schema migrations, or roll back the last n applied ones.
+`
+}
+
+func (p *migrateCmd) SetFlags(f *flag.FlagSet) {
+	f.IntVar(&p.down, "down", 0, "roll back the last n applied migrations, option")
+	f.BoolVar(&p.list, "list", false, "list applied and pending versions, option")
+	f.StringVar(&p.dsn, "dsn", "file:godnslog.db?cache=shared&mode=rwc", "set database source name, option")
+	f.StringVar(&p.driver, "driver", "sqlite3", "set database driver, [sqlite3/mysql/postgres], option")
+}
+
+func (p *migrateCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	migrator, err := server.NewSchemaMigrator(p.driver, p.dsn)
+	if err != nil {
+		fmt.Printf("open database: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	defer migrator.Close()
+
+	switch {
+	case p.list:
+	case p.down > 0:
+		err = migrator.Down(p.down)
+	default:
+		err = migrator.Up()
+	}
+	if err != nil {
+		fmt.Printf("migrate: %v\n", err)
+		return subcommands.ExitFailure
+	}
+
+	applied, pending, err := migrator.Versions()
+	if err != nil {
+		fmt.Printf("versions: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	fmt.Printf("applied: %v\n", strings.Join(applied, ", "))
+	fmt.Printf("pending: %v\n", strings.Join(pending, ", "))
+	return subcommands.ExitSuccess
+}
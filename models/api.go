@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -16,10 +17,14 @@ const (
 	CodeServerInternal = 5
 	CodeNoData         = 6
 	CodeExpire         = 7
+	CodeQuota          = 8
+	CodeTotpRequired   = 9 //password is right, code of two-factor login is required
 
-	RoleSuper  = 0
-	RoleAdmin  = 1
-	RoleNormal = 2
+	RoleSuper    = 0
+	RoleAdmin    = 1 //manage users
+	RoleOperator = 2 //manage own records and settings
+	RoleViewer   = 3 //read own records, security of own account only
+	RoleNormal   = RoleOperator
 
 	GODNS_RFI_KEY   = "GODNSLOG"
 	GODNS_RFI_VALUE = "694ef536e5d0245f203a1bcf8cbf3294" // md5sum($GODNS_RFI_KEY)
@@ -29,11 +34,44 @@ type LoginRequest struct {
 	Email    string `json:"email"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Code     string `json:"code"` //totp or recovery code, required if two-factor login is enabled
+
+	//assertion of webauthn second factor, instead of code
+	Webauthn *WebauthnCredential `json:"webauthn,omitempty"`
+
+	//stateless jwt with refresh token instead of login session, requires jwt key of server
+	Stateless bool `json:"stateless"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// SecondFactor is result of login with CodeTotpRequired, methods are totp and webauthn
+type SecondFactor struct {
+	Methods  []string                `json:"methods"`
+	Webauthn *WebauthnRequestOptions `json:"webauthn,omitempty"`
+}
+
+// ForgotPasswordRequest mails a reset link to the user of username or email
+type ForgotPasswordRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
 }
 
 type LoginResponse struct {
 	Islogin bool   `json:"isLogin"`
 	Token   string `json:"token"`
+
+	//stateless jwt, token expires in seconds
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ExpiresIn    int64  `json:"expiresIn,omitempty"`
+
 	//TODO:
 	Username string `json:"username"`
 	RoleId   string `json:"roleId"`
@@ -67,16 +105,20 @@ type UserInfo struct {
 	Avatar   string    `json:"avatar"`
 	Language string    `json:"lang"`
 	Role     Role      `json:"role"`
+	Disabled bool      `json:"disabled"`
 	Utime    time.Time `json:"utime"`
 }
 
 type UserRequest struct {
-	Id       int64  `json:"id"`
-	Name     string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Role     int    `json:"role"`
-	Language string `json:"lang"`
+	Id           int64  `json:"id"`
+	Name         string `json:"username"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	Role         int    `json:"role"`
+	Language     string `json:"lang"`
+	PayloadQuota int64  `json:"payloadQuota"`
+	RecordQuota  int64  `json:"recordQuota"` //-1: unlimited
+	Disabled     *bool  `json:"disabled"`    //nil: unchanged
 }
 
 type DnsRecordResp struct {
@@ -95,25 +137,280 @@ type UserListResp struct {
 }
 
 type AppSetting struct {
-	Callback  string   `json:"callback"`
-	CleanHour int64    `json:"cleanHour"`
-	Rebind    []string `json:"rebind"`
+	Callback    string   `json:"callback"`
+	CleanHour   int64    `json:"cleanHour"`
+	Rebind      []string `json:"rebind"`
+	MaxBodySize int64    `json:"maxBodySize"`
+
+	CallbackTemplate string `json:"callbackTemplate"`
+	CallbackSecret   string `json:"callbackSecret,omitempty"` //read only
+
+	MailAlert int `json:"mailAlert"` //0: off, 1: every hit, 2: first hit of token
+
+	Digest     int `json:"digest"`     //0: off, 1: daily, 2: weekly
+	DigestHour int `json:"digestHour"` //local hour of server
+
+	DedupWindow  int64 `json:"dedupWindow"` //seconds, 0: off
+	HideScanners bool  `json:"hideScanners"`
+
+	Retention    map[string]int64 `json:"retention"`              //hours by record type, missing: cleanHour
+	RetentionMax map[string]int64 `json:"retentionMax,omitempty"` //read only, hours by record type set by admin
+	RecordQuota  int64            `json:"recordQuota"`            //read only, max stored records, 0: unlimited
+}
+
+type PurgeRequest struct {
+	Password string `json:"password"` //password of user again
 }
 
 type DeleteRecordRequest struct {
 	Ids []int64 `json:"ids"`
+
+	//filters of bulk delete, dns and http only
+	From  string `json:"from,omitempty"` //RFC3339 or unix timestamp
+	To    string `json:"to,omitempty"`
+	Token string `json:"token,omitempty"`
+	Ip    string `json:"ip,omitempty"` //ip or CIDR aligned to octet, eg. 10.1.0.0/16
+}
+
+type TagRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type NoteRequest struct {
+	Note string `json:"note"`
 }
 
 type AppSecurity struct {
-	Token    string `json:"token"`
-	DnsAddr  string `json:"dns_addr"`
-	HttpAddr string `json:"http_addr"`
+	Token          string `json:"token"`
+	DnsAddr        string `json:"dns_addr"`
+	HttpAddr       string `json:"http_addr"`
+	TelegramChatId string `json:"telegram_chat_id"`
+	Totp           bool   `json:"totp"`           //two-factor login enabled
+	RecoveryCodes  int    `json:"recovery_codes"` //unused recovery codes of two-factor login
+	Passkeys       int    `json:"passkeys"`       //webauthn credentials for login and second factor
 }
 
-type AppSecuritySet struct {
+// TotpEnroll is secret of two-factor login to be confirmed, uri is content of QR code for authenticator apps
+type TotpEnroll struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+type TotpRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"` //required to disable
+}
+
+// TotpRecovery is shown once, each code can be used once instead of totp code
+type TotpRecovery struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// WebauthnCredential is PublicKeyCredential of navigator.credentials.create() or get() in json, binary fields are base64url
+type WebauthnCredential struct {
+	Id       string `json:"id"`
+	RawId    string `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject,omitempty"` //create
+		AuthenticatorData string `json:"authenticatorData,omitempty"` //get
+		Signature         string `json:"signature,omitempty"`         //get
+		UserHandle        string `json:"userHandle,omitempty"`        //get
+	} `json:"response"`
+}
+
+type WebauthnRp struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type WebauthnUser struct {
+	Id          string `json:"id"` //base64url
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type WebauthnParam struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type WebauthnDescriptor struct {
+	Type string `json:"type"`
+	Id   string `json:"id"` //base64url
+}
+
+type WebauthnSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// WebauthnCreationOptions is publicKey of navigator.credentials.create(), binary fields are base64url
+type WebauthnCreationOptions struct {
+	Challenge              string               `json:"challenge"`
+	Rp                     WebauthnRp           `json:"rp"`
+	User                   WebauthnUser         `json:"user"`
+	PubKeyCredParams       []WebauthnParam      `json:"pubKeyCredParams"`
+	Timeout                int64                `json:"timeout"` //milliseconds
+	ExcludeCredentials     []WebauthnDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection WebauthnSelection    `json:"authenticatorSelection"`
+	Attestation            string               `json:"attestation"`
+}
+
+// WebauthnRequestOptions is publicKey of navigator.credentials.get(), binary fields are base64url
+type WebauthnRequestOptions struct {
+	Challenge        string               `json:"challenge"`
+	RpId             string               `json:"rpId"`
+	Timeout          int64                `json:"timeout"` //milliseconds
+	AllowCredentials []WebauthnDescriptor `json:"allowCredentials"`
+	UserVerification string               `json:"userVerification"`
+}
+
+// WebauthnRequest register a credential, or rename one by id
+type WebauthnRequest struct {
+	Id         int64               `json:"id"`
+	Name       string              `json:"name"`
+	Credential *WebauthnCredential `json:"credential"`
+}
+
+// WebauthnLogin login by a passkey alone, username is optional for discoverable credentials
+type WebauthnLogin struct {
+	Username   string              `json:"username"`
+	Credential *WebauthnCredential `json:"credential"`
+}
+
+type Team struct {
+	Id      int64        `json:"id"`
+	Name    string       `json:"name"`
+	Uid     int64        `json:"uid"` //owner
+	Members []TeamMember `json:"members"`
+	Atime   time.Time    `json:"atime"`
+}
+
+// TeamMember is member of team, domain is payload domain of member
+type TeamMember struct {
+	Uid      int64     `json:"uid"`
+	Username string    `json:"username"`
+	Email    string    `json:"email,omitempty"` //add by email instead of username
+	Domain   string    `json:"domain"`
+	Write    bool      `json:"write"`
+	Accepted bool      `json:"accepted"` //false if invited, not yet a member
+	Atime    time.Time `json:"atime"`
+}
+
+// SignupInfo is public signup of login page, enabled by serve -signup and the mail server of admin
+type SignupInfo struct {
+	Enabled bool `json:"enabled"`
+}
+
+type SignupRequest struct {
+	Invite   string `json:"invite"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
 	Password string `json:"password"`
 }
 
+type SignupVerifyRequest struct {
+	Code string `json:"code"`
+}
+
+type Invite struct {
+	Id      int64     `json:"id"`
+	Code    string    `json:"code"` //random if empty
+	Note    string    `json:"note"`
+	MaxUses int       `json:"maxUses"` //default 1
+	Uses    int       `json:"uses"`
+	Expire  time.Time `json:"expire"` //zero: never
+	Uid     int64     `json:"uid"`
+	Atime   time.Time `json:"atime"`
+}
+
+// BurpClient is biid of burp collaborator polling, pending until approved by user
+type BurpClient struct {
+	Id    int64     `json:"id"`
+	Biid  string    `json:"biid"`
+	Ip    string    `json:"ip"`
+	Bound bool      `json:"bound"`
+	Atime time.Time `json:"atime"`
+	Btime time.Time `json:"btime"`
+}
+
+// CustomDomain is domain of user, verified by NS records to Ns or a host resolving to Ip,
+// or by TXT record TxtName of TxtValue
+type CustomDomain struct {
+	Id       int64     `json:"id"`
+	Domain   string    `json:"domain"`
+	Verified bool      `json:"verified"`
+	Method   string    `json:"method"`
+	Ns       string    `json:"ns"`
+	Ip       string    `json:"ip"`
+	TxtName  string    `json:"txtName"`
+	TxtValue string    `json:"txtValue"`
+	Vtime    time.Time `json:"vtime"`
+	Atime    time.Time `json:"atime"`
+}
+
+// CustomDomainRequest add or verify custom domain
+type CustomDomainRequest struct {
+	Id     int64  `json:"id"`
+	Domain string `json:"domain"`
+}
+
+// PasswordHash is argon2id parameters of password hashes, memory is KiB
+type PasswordHash struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// LoginSession is login of web ui, current is the session of request
+type LoginSession struct {
+	Id      int64     `json:"id"`
+	Ip      string    `json:"ip"`
+	Ua      string    `json:"ua"`
+	Current bool      `json:"current"`
+	Ctime   time.Time `json:"ctime"`
+	Atime   time.Time `json:"atime"` //last seen
+	Etime   time.Time `json:"etime"`
+}
+
+// Audit is entry of audit log, status is http status of action
+type Audit struct {
+	Id     int64     `json:"id"`
+	Uid    int64     `json:"uid"`
+	Name   string    `json:"name"`
+	Action string    `json:"action"`
+	Status int       `json:"status"`
+	Ip     string    `json:"ip"`
+	Ua     string    `json:"ua"`
+	Detail string    `json:"detail,omitempty"`
+	Ctime  time.Time `json:"ctime"`
+}
+
+type AuditResp struct {
+	Pagination
+	Data []Audit `json:"data"`
+}
+
+// OidcInfo is single sign-on of login page, url starts authorization by the provider
+type OidcInfo struct {
+	Enabled bool   `json:"enabled"`
+	Url     string `json:"url,omitempty"`
+}
+
+type Passkey struct {
+	Id    int64     `json:"id"`
+	Name  string    `json:"name"`
+	Ctime time.Time `json:"ctime"`
+	Atime time.Time `json:"atime"` //last login, zero if never
+}
+
+type AppSecuritySet struct {
+	Password       string  `json:"password"`
+	TelegramChatId *string `json:"telegram_chat_id"` //nil: keep current
+}
+
 type DnsRecord struct {
 	Id       int64     `json:"id,omitempty"`
 	Uid      int64     `json:"-"`
@@ -121,22 +418,410 @@ type DnsRecord struct {
 	Var      string    `json:"-"`
 	Domain   string    `json:"domain"`
 	Ip       string    `json:"addr"`
+	Qtype    string    `json:"qtype,omitempty"`
+	Hits     int64     `json:"hits,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	Note     string    `json:"note,omitempty"`
 	Ctime    time.Time `json:"ctime"`
+	Ptr      string    `json:"ptr,omitempty"`
+	Scanner  string    `json:"scanner,omitempty"`
+	Geo
 }
 
 type HttpRecord struct {
+	Id         int64     `json:"id,omitempty"`
+	Uid        int64     `json:"-"`
+	Callback   string    `json:"-"`
+	Host       string    `json:"host,omitempty"`
+	Path       string    `json:"path"`
+	Ip         string    `json:"addr"`
+	Method     string    `json:"method"`
+	Proto      string    `json:"proto"`
+	Data       string    `json:"data"`
+	Ctype      string    `json:"ctype"`
+	Ua         string    `json:"ua"`
+	Size       int64     `json:"size"`
+	Truncated  bool      `json:"truncated"`
+	Sni        string    `json:"sni,omitempty"`
+	TlsVersion string    `json:"tlsVersion,omitempty"`
+	TlsCipher  string    `json:"tlsCipher,omitempty"`
+	Ja3        string    `json:"ja3,omitempty"`
+	Ja3Hash    string    `json:"ja3Hash,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	Ctime      time.Time `json:"ctime"`
+	Ptr        string    `json:"ptr,omitempty"`
+	Scanner    string    `json:"scanner,omitempty"`
+	Geo
+}
+
+type SmtpRecord struct {
+	Id        int64     `json:"id,omitempty"`
+	Uid       int64     `json:"-"`
+	Var       string    `json:"-"`
+	Ip        string    `json:"addr"`
+	Helo      string    `json:"helo"`
+	From      string    `json:"from"`
+	To        []string  `json:"to"`
+	Subject   string    `json:"subject"`
+	Headers   string    `json:"headers"`
+	Body      string    `json:"body"`
+	Size      int64     `json:"size"`
+	Truncated bool      `json:"truncated"`
+	Note      string    `json:"note,omitempty"`
+	Ctime     time.Time `json:"ctime"`
+}
+
+type SmtpRecordResp struct {
+	Pagination
+	Data []SmtpRecord `json:"data"`
+}
+
+type LdapRecord struct {
 	Id       int64     `json:"id,omitempty"`
 	Uid      int64     `json:"-"`
 	Callback string    `json:"-"`
-	Path     string    `json:"path"`
+	Var      string    `json:"-"`
 	Ip       string    `json:"addr"`
-	Method   string    `json:"method"`
-	Data     string    `json:"data"`
-	Ctype    string    `json:"ctype"`
-	Ua       string    `json:"ua"`
+	Op       string    `json:"op"`
+	Dn       string    `json:"dn"`
+	Password string    `json:"password,omitempty"`
+	Note     string    `json:"note,omitempty"`
 	Ctime    time.Time `json:"ctime"`
 }
 
+type LdapRecordResp struct {
+	Pagination
+	Data []LdapRecord `json:"data"`
+}
+
+type FtpRecord struct {
+	Id       int64     `json:"id,omitempty"`
+	Uid      int64     `json:"-"`
+	Var      string    `json:"-"`
+	Ip       string    `json:"addr"`
+	User     string    `json:"user"`
+	Password string    `json:"password"`
+	Paths    []string  `json:"paths"`
+	Commands string    `json:"commands"`
+	Note     string    `json:"note,omitempty"`
+	Ctime    time.Time `json:"ctime"`
+}
+
+type FtpRecordResp struct {
+	Pagination
+	Data []FtpRecord `json:"data"`
+}
+
+type TcpRecord struct {
+	Id    int64     `json:"id,omitempty"`
+	Uid   int64     `json:"-"`
+	Var   string    `json:"-"`
+	Ip    string    `json:"addr"`
+	Port  int       `json:"port"`
+	Data  string    `json:"data"`
+	Size  int64     `json:"size"`
+	Note  string    `json:"note,omitempty"`
+	Ctime time.Time `json:"ctime"`
+}
+
+type TcpRecordResp struct {
+	Pagination
+	Data []TcpRecord `json:"data"`
+}
+
+type IcmpRecord struct {
+	Id     int64     `json:"id,omitempty"`
+	Uid    int64     `json:"-"`
+	Var    string    `json:"-"`
+	Ip     string    `json:"addr"`
+	EchoId int       `json:"echoId"`
+	Seq    int       `json:"seq"`
+	Data   string    `json:"data"`
+	Size   int64     `json:"size"`
+	Note   string    `json:"note,omitempty"`
+	Ctime  time.Time `json:"ctime"`
+}
+
+type IcmpRecordResp struct {
+	Pagination
+	Data []IcmpRecord `json:"data"`
+}
+
+type SmbRecord struct {
+	Id     int64     `json:"id,omitempty"`
+	Uid    int64     `json:"-"`
+	Var    string    `json:"-"`
+	Ip     string    `json:"addr"`
+	Host   string    `json:"host"`
+	Domain string    `json:"domain"`
+	User   string    `json:"user"`
+	Target string    `json:"target"`
+	Os     string    `json:"os"`
+	Hash   string    `json:"hash"`
+	Note   string    `json:"note,omitempty"`
+	Ctime  time.Time `json:"ctime"`
+}
+
+type SmbRecordResp struct {
+	Pagination
+	Data []SmbRecord `json:"data"`
+}
+
+type RmiRecord struct {
+	Id    int64     `json:"id,omitempty"`
+	Uid   int64     `json:"-"`
+	Var   string    `json:"-"`
+	Ip    string    `json:"addr"`
+	Host  string    `json:"host"`
+	Name  string    `json:"name"`
+	Note  string    `json:"note,omitempty"`
+	Ctime time.Time `json:"ctime"`
+}
+
+type RmiRecordResp struct {
+	Pagination
+	Data []RmiRecord `json:"data"`
+}
+
+// SessionEvent is a record of any protocol in session timeline
+type SessionEvent struct {
+	Type  string      `json:"type"` //dns, http, smtp, ldap, ftp, tcp, icmp, smb, rmi
+	Id    int64       `json:"id"`
+	Var   string      `json:"var"`
+	Ip    string      `json:"addr"`
+	Ctime time.Time   `json:"ctime"`
+	Data  interface{} `json:"data"`
+}
+
+// Session is hits of all protocols sharing the same token
+type Session struct {
+	Token     string         `json:"token"`
+	Count     int            `json:"count"`
+	First     time.Time      `json:"first"`
+	Last      time.Time      `json:"last"`
+	Protocols []string       `json:"protocols"`
+	Events    []SessionEvent `json:"events"`
+}
+
+type StatsBucket struct {
+	Time  string `json:"time"` //2006-01-02 15:00 or 2006-01-02
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+}
+
+type StatsTop struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// Stats is aggregation of records in [from, to)
+type Stats struct {
+	From      time.Time        `json:"from"`
+	To        time.Time        `json:"to"`
+	Bucket    string           `json:"bucket"` //hour, day
+	Total     int64            `json:"total"`
+	Counts    map[string]int64 `json:"counts"` //record type => count
+	Buckets   []StatsBucket    `json:"buckets"`
+	TopIps    []StatsTop       `json:"topIps"`
+	TopTokens []StatsTop       `json:"topTokens"`
+}
+
+type Rollup struct {
+	Day  string `json:"day"` //2006-01-02
+	Type string `json:"type"`
+	Hits int64  `json:"hits"`
+}
+
+// SearchResp is full-text search hits, newest first
+type SearchResp struct {
+	Pagination
+	Data []SessionEvent `json:"data"`
+}
+
+type TcpPort struct {
+	Id       int64  `json:"id"`
+	Port     int    `json:"port"`
+	Banner   string `json:"banner"`
+	MaxBytes int    `json:"maxBytes"`
+}
+
+type MailServer struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	User string `json:"user"`
+	Pass string `json:"pass,omitempty"` //write only, empty: keep current
+	From string `json:"from"`
+	Tls  bool   `json:"tls"`
+}
+
+// LdapAuth is ldap login setting of admin
+type LdapAuth struct {
+	Url        string `json:"url"`
+	StartTls   bool   `json:"start_tls"`
+	SkipVerify bool   `json:"skip_verify"`
+	BindDn     string `json:"bind_dn"`
+	BindPass   string `json:"bind_pass,omitempty"` //write only, empty: keep current
+	BaseDn     string `json:"base_dn"`
+	UserFilter string `json:"user_filter"`
+	AdminGroup string `json:"admin_group"`
+	AutoCreate bool   `json:"auto_create"`
+}
+
+// LdapAccount is directory account of a login by ldap test
+type LdapAccount struct {
+	Dn    string `json:"dn"`
+	Email string `json:"email"`
+	Admin bool   `json:"admin"`
+}
+
+type HttpFile struct {
+	N        int    `json:"n"`
+	Field    string `json:"field"`
+	Filename string `json:"filename"`
+	Ctype    string `json:"ctype"`
+	Size     int64  `json:"size"`
+}
+
+type HttpFrame struct {
+	N      int       `json:"n"`
+	Binary bool      `json:"binary"`
+	Data   string    `json:"data"`
+	Size   int64     `json:"size"`
+	Ctime  time.Time `json:"ctime"`
+}
+
+type HttpReplayRequest struct {
+	Url     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers"`
+	Body    *string           `json:"body"` //nil: use captured body
+}
+
+type HttpReplay struct {
+	Id        int64               `json:"id"`
+	Url       string              `json:"url"`
+	Method    string              `json:"method"`
+	Status    int                 `json:"status"`
+	Headers   map[string][]string `json:"headers"`
+	Body      string              `json:"body"` //base64
+	Size      int64               `json:"size"`
+	Truncated bool                `json:"truncated"`
+	Elapsed   int64               `json:"elapsed"`
+	Error     string              `json:"error,omitempty"`
+	Ctime     time.Time           `json:"ctime"`
+}
+
+type HttpRule struct {
+	Id       int64             `json:"id"`
+	Priority int               `json:"priority"`
+	Method   string            `json:"method"`
+	Path     string            `json:"path"`
+	Header   string            `json:"header"`
+	HeaderRe string            `json:"headerRe"`
+	Status   int               `json:"status"`
+	Headers  map[string]string `json:"headers"`
+	Body     string            `json:"body"`
+	Delay    int64             `json:"delay"`
+}
+
+type ApiToken struct {
+	Id     int64     `json:"id"`
+	Label  string    `json:"label"`
+	Token  string    `json:"token"`
+	Scopes []string  `json:"scopes"`
+	Ltime  time.Time `json:"ltime"`
+	Etime  time.Time `json:"etime"` //zero: never expire
+	Atime  time.Time `json:"atime"`
+}
+
+type Webhook struct {
+	Id       int64    `json:"id"`
+	Name     string   `json:"name"`
+	Url      string   `json:"url"`
+	Types    []string `json:"types"`
+	Prefix   string   `json:"prefix"`
+	Cidrs    []string `json:"cidrs"`
+	Disabled bool     `json:"disabled"`
+	Kind     string   `json:"kind"`   //empty: signed json, dingtalk, wecom, feishu, slack, discord, godnslog
+	Secret   string   `json:"secret"` //signing secret of dingtalk and feishu, relay token of godnslog peer
+}
+
+// RelayRecord is a hit relayed from another godnslog instance, record is row of the type
+type RelayRecord struct {
+	Type   string          `json:"type"`
+	Record json.RawMessage `json:"record"`
+}
+
+// VersionInfo is build of a godnslog instance, features are optional ones enabled, eg. https, clickhouse
+type VersionInfo struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildDate string   `json:"buildDate"`
+	GoVersion string   `json:"goVersion"`
+	Schema    string   `json:"schema"` //latest schema migration of build
+	Features  []string `json:"features"`
+}
+
+type AlertRule struct {
+	Id       int64    `json:"id"`
+	Priority int      `json:"priority"`
+	Name     string   `json:"name"`
+	Types    []string `json:"types"`
+	DomainRe string   `json:"domainRe"`
+	Cidrs    []string `json:"cidrs"`
+	Ua       string   `json:"ua"`
+	Channels []string `json:"channels"`
+}
+
+type IpFilter struct {
+	Id     int64     `json:"id"`
+	Action string    `json:"action"`
+	Cidr   string    `json:"cidr"`
+	Note   string    `json:"note"`
+	Atime  time.Time `json:"atime"`
+}
+
+type Delivery struct {
+	Id       int64     `json:"id"`
+	Hid      int64     `json:"hid"`
+	Url      string    `json:"url"`
+	Type     string    `json:"type"`
+	Rid      int64     `json:"rid"`
+	Body     string    `json:"body"`
+	Attempt  int       `json:"attempt"`
+	Status   int       `json:"status"`
+	Elapsed  int64     `json:"elapsed"`
+	Response string    `json:"response"`
+	Error    string    `json:"error,omitempty"`
+	Ctime    time.Time `json:"ctime"`
+}
+
+type DeliveryResp struct {
+	Pagination
+	Data []Delivery `json:"data"`
+}
+
+type RotateTokenRequest struct {
+	Id    int64 `json:"id"`    //0: token of user
+	Grace int64 `json:"grace"` //seconds the old token keeps valid
+}
+
+type PayloadFile struct {
+	Id    int64     `json:"id"`
+	Name  string    `json:"name"`
+	Ctype string    `json:"ctype"`
+	Size  int64     `json:"size"`
+	Url   string    `json:"url"`
+	Utime time.Time `json:"utime"`
+}
+
+type PayloadFileResp struct {
+	Quota int64         `json:"quota"`
+	Used  int64         `json:"used"`
+	Data  []PayloadFile `json:"data"`
+}
+
 // commone response
 type CR struct {
 	Message   string      `json:"message"`
@@ -151,6 +836,10 @@ type Pagination struct {
 	PageSize   int `json:"pageSize"`
 	TotalCount int `json:"totalCount"`
 	TotalPage  int `json:"totalPage"`
+
+	//keyset pagination
+	AfterId int64 `json:"afterId,omitempty"`
+	NextId  int64 `json:"nextId,omitempty"`
 }
 
 type Resolv struct {
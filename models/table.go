@@ -19,24 +19,58 @@ type TblUser struct {
 	Pass    string `xorm:"varchar(128) notnull"`
 
 	//settings
-	Lang            string   `xorm:"varchar(16) default('en-US') notnull"`
-	Callback        string   `xorm:"text"`
-	CallbackMessage string   `xorm:"text"`
-	Rebind          []string `xorm:"json"`
-	CleanInterval   int64    `xorm:"default 3600"`
+	Lang             string           `xorm:"varchar(16) default('en-US') notnull"`
+	Callback         string           `xorm:"text"`
+	CallbackSecret   string           `xorm:"varchar(64)"` //hmac key of callback signature
+	CallbackTemplate string           `xorm:"text"`        //go template of callback body, empty: record json
+	CallbackMessage  string           `xorm:"text"`
+	MailAlert        int              `xorm:"default 0"`     //0: off, 1: every hit, 2: first hit of token
+	TelegramChatId   string           `xorm:"varchar(64)"`   //empty: telegram notification disabled
+	Digest           int              `xorm:"default 0"`     //0: off, 1: daily, 2: weekly
+	DigestHour       int              `xorm:"default 9"`     //local hour to send digest
+	DigestTime       time.Time        `xorm:"datetime"`      //end of last digest
+	DedupWindow      int64            `xorm:"default 0"`     //seconds to collapse identical dns hits, 0: off
+	HideScanners     bool             `xorm:"default false"` //hide hits of known scanners
+	Rebind           []string         `xorm:"json"`
+	CleanInterval    int64            `xorm:"default 3600"`
+	Retention        map[string]int64 `xorm:"json"`               //seconds by record type, missing: CleanInterval
+	MaxBodySize      int64            `xorm:"default 0"`          //0: use server default
+	PayloadQuota     int64            `xorm:"default 0"`          //0: use server default
+	RecordQuota      int64            `xorm:"default 0"`          //max stored records, 0: use server default, -1: unlimited
+	Disabled         bool             `xorm:"default false"`      //login and api are denied, hits are still recorded
+	TotpSecret       string           `xorm:"varchar(64)"`        //base32 secret of two-factor login, empty: off
+	TotpRecovery     []string         `xorm:"json"`               //sha256 of unused recovery codes
+	OidcSub          string           `xorm:"varchar(255) index"` //subject of single sign-on account, empty: not linked
+	LdapDn           string           `xorm:"varchar(255) index"` //dn of directory account, password is checked by ldap bind, empty: local
+	TokenVersion     int64            `xorm:"default 0"`          //bumped by password change and logout, older refresh tokens are denied
 
 	Atime time.Time `xorm:"datetime created"`
 	Utime time.Time `xorm:"datetime updated"`
 }
 
+// Geo is location of source address by geoip database, embedded in records
+type Geo struct {
+	Country string `xorm:"varchar(2)" json:"country,omitempty"` //iso code
+	City    string `xorm:"varchar(64)" json:"city,omitempty"`
+	Asn     int64  `xorm:"default 0" json:"asn,omitempty"`
+	AsOrg   string `xorm:"varchar(128)" json:"asOrg,omitempty"`
+}
+
 type TblDns struct {
-	Id     int64     `xorm:"pk autoincr"`
-	Uid    int64     `xorm:"notnull"` //TblUser.Id fk
-	Domain string    `xorm:"varchar(255) notnull"`
-	Var    string    `xorm:"varchar(255) index"`
-	Ip     string    `xorm:"varchar(16) notnull"`
-	Ctime  time.Time `xorm:"datetime"`
-	Atime  time.Time `xorm:"datetime created"`
+	Id      int64     `xorm:"pk autoincr"`
+	Uid     int64     `xorm:"notnull"` //TblUser.Id fk
+	Domain  string    `xorm:"varchar(255) notnull"`
+	Var     string    `xorm:"varchar(255) index"`
+	Ip      string    `xorm:"varchar(16) notnull"`
+	Qtype   string    `xorm:"varchar(16)"` //A, AAAA
+	Hits    int64     `xorm:"default 1"`   //identical hits collapsed in dedup window
+	Tags    []string  `xorm:"json"`
+	Note    string    `xorm:"text"`
+	Ctime   time.Time `xorm:"datetime"`
+	Atime   time.Time `xorm:"datetime created"`
+	Ptr     string    `xorm:"varchar(255)"`           //hostname of source ip by reverse dns
+	Scanner string    `xorm:"varchar(64) default ''"` //name of known scanner, empty: unknown
+	Geo     `xorm:"extends"`
 }
 
 type TblHttp struct {
@@ -44,11 +78,444 @@ type TblHttp struct {
 	Uid    int64     `xorm:"notnull"` //TblUser.Id fk
 	Ip     string    `xorm:"varchar(16) notnull"`
 	Var    string    `xorm:"varchar(255) index"`
+	Host   string    `xorm:"varchar(255)"`
 	Path   string    `xorm:"text notnull"`
 	Method string    `xorm:"varchar(16)"`
+	Proto  string    `xorm:"varchar(16)"`
 	Data   string    `xorm:"mediumtext"`
 	Ctype  string    `xorm:"varchar(64)"`
 	Ua     string    `xorm:"text"`
+	Tags   []string  `xorm:"json"`
+	Note   string    `xorm:"text"`
 	Ctime  time.Time `xorm:"datetime"`
 	Atime  time.Time `xorm:"datetime created"`
+
+	Body      string `xorm:"mediumtext"` //base64 raw body
+	Size      int64  `xorm:"default 0"`  //original body size
+	Truncated bool   `xorm:"default false"`
+
+	//tls handshake
+	Sni        string `xorm:"varchar(255)"`
+	TlsVersion string `xorm:"varchar(16)"`
+	TlsCipher  string `xorm:"varchar(64)"`
+	Ja3        string `xorm:"text"`
+	Ja3Hash    string `xorm:"varchar(32) index"`
+
+	Ptr     string `xorm:"varchar(255)"`           //hostname of source ip by reverse dns
+	Scanner string `xorm:"varchar(64) default ''"` //name of known scanner, empty: unknown
+	Geo     `xorm:"extends"`
+}
+
+type TblSmtp struct {
+	Id        int64     `xorm:"pk autoincr"`
+	Uid       int64     `xorm:"notnull"` //TblUser.Id fk
+	Ip        string    `xorm:"varchar(64) notnull"`
+	Var       string    `xorm:"varchar(255) index"`
+	Helo      string    `xorm:"varchar(255)"`
+	MailFrom  string    `xorm:"varchar(255)"`
+	RcptTo    string    `xorm:"text"` //comma separated
+	Subject   string    `xorm:"varchar(255)"`
+	Headers   string    `xorm:"mediumtext"`
+	Body      string    `xorm:"mediumtext"`
+	Size      int64     `xorm:"default 0"` //original message size
+	Truncated bool      `xorm:"default false"`
+	Note      string    `xorm:"text"`
+	Ctime     time.Time `xorm:"datetime"`
+	Atime     time.Time `xorm:"datetime created"`
+}
+
+type TblLdap struct {
+	Id       int64     `xorm:"pk autoincr"`
+	Uid      int64     `xorm:"notnull"` //TblUser.Id fk
+	Ip       string    `xorm:"varchar(64) notnull"`
+	Var      string    `xorm:"varchar(255) index"`
+	Op       string    `xorm:"varchar(16)"` //bind, search
+	Dn       string    `xorm:"text"`        //bind name or search base object
+	Password string    `xorm:"text"`        //simple bind password
+	Note     string    `xorm:"text"`
+	Ctime    time.Time `xorm:"datetime"`
+	Atime    time.Time `xorm:"datetime created"`
+}
+
+type TblFtp struct {
+	Id       int64     `xorm:"pk autoincr"`
+	Uid      int64     `xorm:"notnull"` //TblUser.Id fk
+	Ip       string    `xorm:"varchar(64) notnull"`
+	Var      string    `xorm:"varchar(255) index"`
+	User     string    `xorm:"varchar(255)"`
+	Password string    `xorm:"varchar(255)"`
+	Paths    []string  `xorm:"json"` //requested paths
+	Commands string    `xorm:"mediumtext"`
+	Note     string    `xorm:"text"`
+	Ctime    time.Time `xorm:"datetime"`
+	Atime    time.Time `xorm:"datetime created"`
+}
+
+type TblTcp struct {
+	Id    int64     `xorm:"pk autoincr"`
+	Uid   int64     `xorm:"notnull"` //TblUser.Id fk
+	Ip    string    `xorm:"varchar(64) notnull"`
+	Port  int       `xorm:"notnull"`
+	Var   string    `xorm:"varchar(255) index"`
+	Data  string    `xorm:"mediumtext"` //hex dump of received bytes
+	Size  int64     `xorm:"default 0"`
+	Note  string    `xorm:"text"`
+	Ctime time.Time `xorm:"datetime"`
+	Atime time.Time `xorm:"datetime created"`
+}
+
+type TblIcmp struct {
+	Id     int64     `xorm:"pk autoincr"`
+	Uid    int64     `xorm:"notnull"` //TblUser.Id fk
+	Ip     string    `xorm:"varchar(64) notnull"`
+	Var    string    `xorm:"varchar(255) index"`
+	EchoId int       `xorm:"default 0"`
+	Seq    int       `xorm:"default 0"`
+	Data   string    `xorm:"text"` //hex dump of echo data
+	Size   int64     `xorm:"default 0"`
+	Note   string    `xorm:"text"`
+	Ctime  time.Time `xorm:"datetime"`
+	Atime  time.Time `xorm:"datetime created"`
+}
+
+type TblSmb struct {
+	Id     int64     `xorm:"pk autoincr"`
+	Uid    int64     `xorm:"notnull"` //TblUser.Id fk
+	Ip     string    `xorm:"varchar(64) notnull"`
+	Var    string    `xorm:"varchar(255) index"`
+	Host   string    `xorm:"varchar(255)"` //client workstation
+	Domain string    `xorm:"varchar(255)"`
+	User   string    `xorm:"varchar(255)"`
+	Target string    `xorm:"varchar(255)"` //spn, eg. cifs/host
+	Os     string    `xorm:"varchar(64)"`
+	Hash   string    `xorm:"text"` //NetNTLM response
+	Note   string    `xorm:"text"`
+	Ctime  time.Time `xorm:"datetime"`
+	Atime  time.Time `xorm:"datetime created"`
+}
+
+type TblRmi struct {
+	Id    int64     `xorm:"pk autoincr"`
+	Uid   int64     `xorm:"notnull"` //TblUser.Id fk
+	Ip    string    `xorm:"varchar(64) notnull"`
+	Var   string    `xorm:"varchar(255) index"`
+	Host  string    `xorm:"varchar(255)"` //client endpoint of JRMP handshake
+	Name  string    `xorm:"text"`         //requested object name
+	Note  string    `xorm:"text"`
+	Ctime time.Time `xorm:"datetime"`
+	Atime time.Time `xorm:"datetime created"`
+}
+
+// daily hits of user, kept after records are cleaned
+type TblRollup struct {
+	Id   int64  `xorm:"pk autoincr"`
+	Uid  int64  `xorm:"notnull unique(rollup)"`
+	Day  string `xorm:"varchar(10) notnull unique(rollup)"` //2006-01-02
+	Type string `xorm:"varchar(16) notnull unique(rollup)"`
+	Hits int64  `xorm:"default 0"`
+}
+
+// last record id rolled up of each type
+type TblRollupMark struct {
+	Type   string `xorm:"varchar(16) pk"`
+	LastId int64  `xorm:"default 0"`
+}
+
+// burp collaborator client polling host of user, biid is bound at first poll
+type TblBurpClient struct {
+	Id     int64     `xorm:"pk autoincr"`
+	Uid    int64     `xorm:"notnull index"` //TblUser.Id fk
+	Biid   string    `xorm:"varchar(255) notnull unique"`
+	Ip     string    `xorm:"varchar(64)"`   //ip of first poll
+	Bound  bool      `xorm:"default false"` //approved by user, pending if false
+	DnsId  int64     `xorm:"default 0"`     //last polled record id
+	HttpId int64     `xorm:"default 0"`
+	Atime  time.Time `xorm:"datetime created"`
+	Btime  time.Time `xorm:"datetime"`
+}
+
+// interactsh client registration, interactions of ${correlationId}${nonce} are saved as records of user
+type TblInteractsh struct {
+	Id            int64     `xorm:"pk autoincr"`
+	Uid           int64     `xorm:"notnull index"` //TblUser.Id fk
+	CorrelationId string    `xorm:"varchar(64) notnull unique"`
+	SecretKey     string    `xorm:"varchar(64) notnull"`
+	PublicKey     string    `xorm:"text notnull"` //base64 pem
+	DnsId         int64     `xorm:"default 0"`    //last polled record id
+	HttpId        int64     `xorm:"default 0"`
+	Atime         time.Time `xorm:"datetime created"`
+}
+
+// scoped api token of user, TblUser.Token is kept as token of all read scopes
+type TblToken struct {
+	Id     int64     `xorm:"pk autoincr"`
+	Uid    int64     `xorm:"notnull index"` //TblUser.Id fk
+	Label  string    `xorm:"varchar(64)"`
+	Token  string    `xorm:"varchar(128) notnull unique"`
+	Scopes []string  `xorm:"json"`
+	Ltime  time.Time `xorm:"datetime"` //last used
+	Etime  time.Time `xorm:"datetime"` //expire time, zero: never
+	Atime  time.Time `xorm:"datetime created"`
+}
+
+// webhook of user, receives records matching filters
+type TblWebhook struct {
+	Id       int64     `xorm:"pk autoincr"`
+	Uid      int64     `xorm:"notnull index"` //TblUser.Id fk
+	Name     string    `xorm:"varchar(64)"`
+	Url      string    `xorm:"text notnull"`
+	Types    []string  `xorm:"json"`         //record types, empty: any
+	Prefix   string    `xorm:"varchar(255)"` //token prefix, empty: any
+	Cidrs    []string  `xorm:"json"`         //source ip or cidr, empty: any
+	Disabled bool      `xorm:"default false"`
+	Kind     string    `xorm:"varchar(16)"`  //empty: signed json, dingtalk, wecom, feishu, slack, discord, godnslog
+	Secret   string    `xorm:"varchar(128)"` //signing secret of dingtalk and feishu, relay token of godnslog peer
+	Atime    time.Time `xorm:"datetime created"`
+	Utime    time.Time `xorm:"datetime updated"`
+}
+
+// alert rule of user, the first matched rule decides notification channels of a record
+type TblAlertRule struct {
+	Id       int64     `xorm:"pk autoincr"`
+	Uid      int64     `xorm:"notnull index"` //TblUser.Id fk
+	Priority int       `xorm:"default 0"`     //bigger first
+	Name     string    `xorm:"varchar(64)"`
+	Types    []string  `xorm:"json"`         //record types, empty: any
+	DomainRe string    `xorm:"varchar(255)"` //regexp of domain, host of http, empty: any
+	Cidrs    []string  `xorm:"json"`         //source ip or cidr, empty: any
+	Ua       string    `xorm:"varchar(255)"` //substring of http user agent, empty: any
+	Channels []string  `xorm:"json"`         //callback, webhooks, webhook:${id}, mail, telegram, empty: mute
+	Atime    time.Time `xorm:"datetime created"`
+	Utime    time.Time `xorm:"datetime updated"`
+}
+
+// source ip filter of recording
+type TblIpFilter struct {
+	Id     int64     `xorm:"pk autoincr"`
+	Uid    int64     `xorm:"notnull index"` //TblUser.Id fk, 0: global
+	Action string    `xorm:"varchar(8)"`    //allow, deny
+	Cidr   string    `xorm:"varchar(64)"`
+	Note   string    `xorm:"varchar(255)"`
+	Atime  time.Time `xorm:"datetime created"`
+}
+
+// delivery attempt of callback or webhook
+type TblDelivery struct {
+	Id       int64     `xorm:"pk autoincr"`
+	Uid      int64     `xorm:"notnull index"` //TblUser.Id fk
+	Hid      int64     `xorm:"notnull index"` //TblWebhook.Id fk, 0: callback of app setting
+	Url      string    `xorm:"text notnull"`
+	Type     string    `xorm:"varchar(16)"` //record type
+	Rid      int64     `xorm:"default 0"`   //record id
+	Body     string    `xorm:"mediumtext"`
+	Attempt  int       `xorm:"default 1"`
+	Status   int       `xorm:"default 0"` //0: request failed
+	Elapsed  int64     `xorm:"default 0"` //milliseconds
+	Response string    `xorm:"text"`      //leading bytes of response body
+	Error    string    `xorm:"text"`
+	Ctime    time.Time `xorm:"datetime created"`
+}
+
+// tcp port catcher, configured by admin
+type TblTcpPort struct {
+	Id       int64     `xorm:"pk autoincr"`
+	Port     int       `xorm:"notnull unique"`
+	Banner   string    `xorm:"text"`
+	MaxBytes int       `xorm:"default 1024"` //bytes to log
+	Atime    time.Time `xorm:"datetime created"`
+	Utime    time.Time `xorm:"datetime updated"`
+}
+
+// smtp client of email alerts, configured by admin, single row
+type TblMailServer struct {
+	Id    int64     `xorm:"pk"`
+	Host  string    `xorm:"varchar(255)"` //empty: email alerts disabled
+	Port  int       `xorm:"default 25"`
+	User  string    `xorm:"varchar(255)"` //empty: no auth
+	Pass  string    `xorm:"varchar(255)"`
+	From  string    `xorm:"varchar(255) notnull"`
+	Tls   bool      `xorm:"default false"` //implicit tls, eg. port 465, otherwise starttls if supported
+	Utime time.Time `xorm:"datetime updated"`
+}
+
+// ldap directory of login, configured by admin, single row
+type TblLdapAuth struct {
+	Id         int64     `xorm:"pk"`
+	Url        string    `xorm:"varchar(255)"` //ldap://host:389 or ldaps://host:636, empty: disabled
+	StartTls   bool      `xorm:"default false"`
+	SkipVerify bool      `xorm:"default false"` //skip verification of server certificate
+	BindDn     string    `xorm:"varchar(255)"`  //service account to search users, empty: anonymous
+	BindPass   string    `xorm:"varchar(255)"`
+	BaseDn     string    `xorm:"varchar(255)"`
+	UserFilter string    `xorm:"varchar(255)"`  //{username} is replaced by escaped login name
+	AdminGroup string    `xorm:"varchar(255)"`  //dn of group mapped to admin role, empty: role is not synchronized
+	AutoCreate bool      `xorm:"default false"` //create users of directory accounts at first login
+	Utime      time.Time `xorm:"datetime updated"`
+}
+
+// webauthn credential of user, eg. security key or passkey, for login alone or as second factor
+type TblWebauthn struct {
+	Id        int64     `xorm:"pk autoincr"`
+	Uid       int64     `xorm:"notnull index"` //TblUser.Id fk
+	Name      string    `xorm:"varchar(64)"`
+	CredId    string    `xorm:"varchar(255) notnull unique"` //base64url of credential id
+	PublicKey []byte    `xorm:"blob notnull"`                //COSE key
+	SignCount int64     `xorm:"default 0"`
+	Ctime     time.Time `xorm:"datetime created"`
+	Atime     time.Time `xorm:"datetime"` //last login
+}
+
+// invite code of public signup, created by admin
+type TblInvite struct {
+	Id      int64     `xorm:"pk autoincr"`
+	Code    string    `xorm:"varchar(64) notnull unique"`
+	Note    string    `xorm:"varchar(255)"`
+	MaxUses int       `xorm:"default 1"` //accounts created by the code
+	Uses    int       `xorm:"default 0"`
+	Expire  time.Time `xorm:"datetime"`      //zero: never
+	Uid     int64     `xorm:"notnull index"` //TblUser.Id fk of creator
+	Atime   time.Time `xorm:"datetime created"`
+}
+
+// team of users sharing records, eg. an engagement team
+type TblTeam struct {
+	Id    int64     `xorm:"pk autoincr"`
+	Name  string    `xorm:"varchar(64) notnull unique"`
+	Uid   int64     `xorm:"notnull index"` //TblUser.Id fk of owner
+	Atime time.Time `xorm:"datetime created"`
+}
+
+// member of team, members read records of each other
+type TblTeamMember struct {
+	Id       int64     `xorm:"pk autoincr"`
+	Tid      int64     `xorm:"notnull unique(member)"`       //TblTeam.Id fk
+	Uid      int64     `xorm:"notnull unique(member) index"` //TblUser.Id fk
+	Write    bool      `xorm:"default false"`                //delete, tag and note records of other members
+	Accepted bool      `xorm:"default false"`                //invitation accepted by the member
+	Atime    time.Time `xorm:"datetime created"`
+}
+
+// custom domain of user, hits of the domain and its subdomains are of user after verified
+type TblDomain struct {
+	Id       int64     `xorm:"pk autoincr"`
+	Uid      int64     `xorm:"notnull index"`               //TblUser.Id fk
+	Domain   string    `xorm:"varchar(253) notnull unique"` //lower case, no trailing dot
+	Token    string    `xorm:"varchar(64) notnull"`         //value of TXT verification record
+	Verified bool      `xorm:"default false"`
+	Method   string    `xorm:"varchar(8)"` //ns, txt
+	Vtime    time.Time `xorm:"datetime"`
+	Atime    time.Time `xorm:"datetime created"`
+}
+
+// argon2id parameters of password hashes set by admin, a single row of id 1
+type TblPasswordHash struct {
+	Id      int64     `xorm:"pk"`
+	Time    uint32    `xorm:"default 2"`
+	Memory  uint32    `xorm:"default 19456"` //KiB
+	Threads uint8     `xorm:"default 1"`
+	Utime   time.Time `xorm:"datetime updated"`
+}
+
+// login session of web ui, seed of jwt token
+type TblLogin struct {
+	Id    int64     `xorm:"pk autoincr"`
+	Uid   int64     `xorm:"notnull index"`              //TblUser.Id fk
+	Seed  string    `xorm:"varchar(64) notnull unique"` //MyClaims.Seed
+	Ip    string    `xorm:"varchar(64)"`
+	Ua    string    `xorm:"varchar(255)"`
+	Ctime time.Time `xorm:"datetime created"`
+	Atime time.Time `xorm:"datetime"` //last seen
+	Etime time.Time `xorm:"datetime index"`
+}
+
+// audit log of security relevant actions, append only
+type TblAudit struct {
+	Id     int64     `xorm:"pk autoincr"`
+	Uid    int64     `xorm:"default 0 index"`            //TblUser.Id of actor, 0: unknown, eg. failed login of no user
+	Name   string    `xorm:"varchar(64)"`                //username of actor or login name
+	Action string    `xorm:"varchar(128) notnull index"` //method and route, eg. POST /api/auth/login
+	Status int       `xorm:"default 0"`                  //http status, failed if >= 400
+	Ip     string    `xorm:"varchar(64)"`
+	Ua     string    `xorm:"varchar(255)"`
+	Detail string    `xorm:"text"`
+	Ctime  time.Time `xorm:"datetime created index"`
+}
+
+// max retention of a record type set by admin, overrides longer settings of users
+type TblRetention struct {
+	Type        string    `xorm:"varchar(16) pk"` //dns, http, smtp, ...
+	MaxInterval int64     `xorm:"default 0"`      //seconds, 0: unlimited
+	Utime       time.Time `xorm:"datetime updated"`
+}
+
+// multipart parts of TblHttp, content saved in blob store
+type TblHttpFile struct {
+	Id       int64     `xorm:"pk autoincr"`
+	Uid      int64     `xorm:"notnull"`       //TblUser.Id fk
+	Rid      int64     `xorm:"notnull index"` //TblHttp.Id fk
+	N        int       `xorm:"notnull"`       //part index
+	Field    string    `xorm:"varchar(255)"`
+	Filename string    `xorm:"varchar(255)"`
+	Ctype    string    `xorm:"varchar(128)"`
+	Size     int64     `xorm:"default 0"`
+	Blob     string    `xorm:"varchar(255) notnull"` //blob store key
+	Atime    time.Time `xorm:"datetime created"`
+}
+
+// websocket frames received after handshake of TblHttp
+type TblHttpFrame struct {
+	Id     int64     `xorm:"pk autoincr"`
+	Uid    int64     `xorm:"notnull"`       //TblUser.Id fk
+	Rid    int64     `xorm:"notnull index"` //TblHttp.Id fk
+	N      int       `xorm:"notnull"`       //frame index
+	Binary bool      `xorm:"default false"`
+	Data   string    `xorm:"mediumtext"` //text, base64 if binary
+	Size   int64     `xorm:"default 0"`
+	Ctime  time.Time `xorm:"datetime created"`
+}
+
+// response of replayed TblHttp
+type TblHttpReplay struct {
+	Id        int64               `xorm:"pk autoincr"`
+	Uid       int64               `xorm:"notnull"`       //TblUser.Id fk
+	Rid       int64               `xorm:"notnull index"` //TblHttp.Id fk
+	Url       string              `xorm:"text notnull"`
+	Method    string              `xorm:"varchar(16)"`
+	Status    int                 `xorm:"default 0"` //0: request failed
+	Headers   map[string][]string `xorm:"json"`
+	Body      string              `xorm:"mediumtext"` //base64 raw body
+	Size      int64               `xorm:"default 0"`
+	Truncated bool                `xorm:"default false"`
+	Elapsed   int64               `xorm:"default 0"` //milliseconds
+	Error     string              `xorm:"text"`
+	Ctime     time.Time           `xorm:"datetime created"`
+}
+
+// custom response rule of /log
+type TblHttpRule struct {
+	Id       int64             `xorm:"pk autoincr"`
+	Uid      int64             `xorm:"notnull index"` //TblUser.Id fk
+	Priority int               `xorm:"default 0"`     //bigger first
+	Method   string            `xorm:"varchar(16)"`   //empty: any
+	Path     string            `xorm:"varchar(255)"`  //regexp
+	Header   string            `xorm:"varchar(64)"`   //header name
+	HeaderRe string            `xorm:"varchar(255)"`  //regexp of header value
+	Status   int               `xorm:"default 200"`
+	Headers  map[string]string `xorm:"json"`
+	Body     string            `xorm:"mediumtext"`
+	Delay    int64             `xorm:"default 0"` //milliseconds
+	Atime    time.Time         `xorm:"datetime created"`
+	Utime    time.Time         `xorm:"datetime updated"`
+}
+
+// user uploaded payload file, content saved in blob store
+type TblPayloadFile struct {
+	Id    int64     `xorm:"pk autoincr"`
+	Uid   int64     `xorm:"notnull unique(uid_name)"` //TblUser.Id fk
+	Name  string    `xorm:"varchar(128) notnull unique(uid_name)"`
+	Ctype string    `xorm:"varchar(128)"`
+	Size  int64     `xorm:"default 0"`
+	Blob  string    `xorm:"varchar(255) notnull"` //blob store key
+	Atime time.Time `xorm:"datetime created"`
+	Utime time.Time `xorm:"datetime updated"`
 }
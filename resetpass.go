@@ -31,7 +31,7 @@ func (*resetPwCmd) Usage() string {
 func (p *resetPwCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&p.user, "u", "admin", "set user name")
 	f.StringVar(&p.dsn, "dsn", "file:godnslog.db?cache=shared&mode=rwc", "set database source name, option")
-	f.StringVar(&p.driver, "driver", "sqlite3", "set database driver, [sqlite3/mysql], option")
+	f.StringVar(&p.driver, "driver", "sqlite3", "set database driver, [sqlite3/mysql/postgres], option")
 }
 
 func (p *resetPwCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
@@ -53,11 +53,15 @@ func (p *resetPwCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{
 		IP:                           "127.0.0.1",
 		Listen:                       ":8080",
 		Swagger:                      false,
+		BlobDir:                      "blob",
 		AuthExpire:                   AuthExpire,
 		DefaultCleanInterval:         DefaultCleanInterval,
 		DefaultQueryApiMaxItem:       DefaultQueryApiMaxItem,
 		DefaultMaxCallbackErrorCount: DefaultMaxCallbackErrorCount,
 		DefaultLanguage:              DefaultLanguage,
+		DefaultMaxBodySize:           DefaultMaxBodySize,
+		DefaultPayloadQuota:          DefaultPayloadQuota,
+		MaxPayloadFileSize:           DefaultMaxPayloadFileSize,
 	}, store)
 	if err != nil {
 		logrus.Fatalf("[main.go::main] NewWebServer: %v", err)
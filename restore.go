@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/server"
+	"github.com/google/subcommands"
+
+	"github.com/sirupsen/logrus"
+)
+
+type restoreCmd struct {
+	driver     string
+	dsn        string
+	blobDir    string
+	clickhouse string
+	archive    string
+	prefix     string
+	backup     string
+}
+
+func (*restoreCmd) Name() string     { return "restore" }
+func (*restoreCmd) Synopsis() string { return "Restore archived records or a backup." }
+func (*restoreCmd) Usage() string {
+	return `restore -archive <s3 url> [-prefix uid/type/] | -backup <file>:
+  restore records archived by serve -archive, existing records are skipped.
+  or replace all data by a backup of godnslog backup.
+`
+}
+
+func (p *restoreCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.archive, "archive", "", "set s3 url of archive")
+	f.StringVar(&p.prefix, "prefix", "", "set key prefix of objects to restore, eg. 1/dns/2020, option")
+	f.StringVar(&p.backup, "backup", "", "set backup file to restore, all data is replaced")
+	f.StringVar(&p.blobDir, "blob", "blob", "set directory of captured files, option")
+	f.StringVar(&p.clickhouse, "clickhouse", "", "set clickhouse http url of dns and http records, option")
+	f.StringVar(&p.dsn, "dsn", "file:godnslog.db?cache=shared&mode=rwc", "set database source name, option")
+	f.StringVar(&p.driver, "driver", "sqlite3", "set database driver, [sqlite3/mysql/postgres], option")
+}
+
+func (p *restoreCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if p.archive == "" && p.backup == "" {
+		fmt.Println("archive or backup required")
+		return subcommands.ExitUsageError
+	}
+	var archive *server.S3Client
+	var err error
+	if p.archive != "" {
+		archive, err = server.NewS3Client(p.archive)
+		if err != nil {
+			fmt.Printf("archive: %v\n", err)
+			return subcommands.ExitUsageError
+		}
+	}
+	var clickhouse *server.ClickHouse
+	if p.clickhouse != "" {
+		clickhouse, err = server.NewClickHouse(p.clickhouse)
+		if err != nil {
+			fmt.Printf("clickhouse: %v\n", err)
+			return subcommands.ExitUsageError
+		}
+	}
+
+	store := cache.NewCache(24*3600*time.Second, 10*time.Minute)
+
+	web, err := server.NewWebServer(&server.WebServerConfig{
+		Driver:                       p.driver,
+		Dsn:                          p.dsn,
+		Domain:                       "example.com",
+		IP:                           "127.0.0.1",
+		Listen:                       ":8080",
+		Swagger:                      false,
+		BlobDir:                      p.blobDir,
+		ClickHouse:                   clickhouse,
+		AuthExpire:                   AuthExpire,
+		DefaultCleanInterval:         DefaultCleanInterval,
+		DefaultQueryApiMaxItem:       DefaultQueryApiMaxItem,
+		DefaultMaxCallbackErrorCount: DefaultMaxCallbackErrorCount,
+		DefaultLanguage:              DefaultLanguage,
+		DefaultMaxBodySize:           DefaultMaxBodySize,
+		DefaultPayloadQuota:          DefaultPayloadQuota,
+		MaxPayloadFileSize:           DefaultMaxPayloadFileSize,
+		Archive:                      archive,
+	}, store)
+	if err != nil {
+		logrus.Fatalf("[restore.go::Execute] NewWebServer: %v", err)
+	}
+
+	if p.backup != "" {
+		fp, err := os.Open(p.backup)
+		if err != nil {
+			fmt.Printf("backup: %v\n", err)
+			return subcommands.ExitUsageError
+		}
+		defer fp.Close()
+
+		counts, err := web.RestoreBackup(fp)
+		tables := make([]string, 0, len(counts))
+		for table := range counts {
+			tables = append(tables, table)
+		}
+		sort.Strings(tables)
+		for _, table := range tables {
+			fmt.Printf("%v: %v rows restored\n", table, counts[table])
+		}
+		if err != nil {
+			fmt.Printf("restore: %v\n", err)
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
+
+	count, err := web.RestoreArchive(p.prefix)
+	fmt.Printf("%v records restored\n", count)
+	if err != nil {
+		fmt.Printf("restore: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net"
+	"os"
+)
+
+// sdNotify send state to systemd if started by a unit of Type=notify, eg. READY=1, no-op if not
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,423 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	LetsEncryptURL = acme.LetsEncryptURL
+
+	ACME_CHECK_INTERVAL = 12 * time.Hour
+	ACME_RENEW_BEFORE   = 30 * 24 * time.Hour
+	ACME_TIMEOUT        = 10 * time.Minute
+)
+
+// ChallengeProvider publish dns-01 challenge records, implemented by DnsServer
+type ChallengeProvider interface {
+	SetTXT(name string, values ...string)
+	DelTXT(name string)
+}
+
+type CertManagerConfig struct {
+	Domain       string
+	Email        string
+	DirectoryURL string
+	CacheDir     string
+
+	//hosts of web console, eg. log.example.org, hosts out of *.Domain share a certificate by http-01 challenge
+	Hosts []string
+}
+
+// CertManager obtain and renew wildcard certificate *.Domain by dns-01 challenge,
+// and certificate of Hosts by http-01 challenge
+type CertManager struct {
+	CertManagerConfig
+	provider ChallengeProvider
+
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	hostCert *tls.Certificate
+	tokens   map[string]string //http-01 token => key authorization
+
+	quit chan struct{}
+}
+
+func NewCertManager(cfg *CertManagerConfig, provider ChallengeProvider) (*CertManager, error) {
+	m := &CertManager{
+		CertManagerConfig: *cfg,
+		provider:          provider,
+		tokens:            make(map[string]string),
+		quit:              make(chan struct{}),
+	}
+	m.Domain = strings.TrimSuffix(m.Domain, ".")
+	m.Hosts = nil
+	for _, host := range cfg.Hosts {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if host == "" || m.covered(host) || m.isHost(host) {
+			continue
+		}
+		m.Hosts = append(m.Hosts, host)
+	}
+	if m.DirectoryURL == "" {
+		m.DirectoryURL = LetsEncryptURL
+	}
+	err := os.MkdirAll(m.CacheDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := m.load(m.Domain)
+	if err == nil {
+		m.cert = cert
+	} else if !os.IsNotExist(err) {
+		logrus.Warnf("[acme.go::NewCertManager] load cached certificate: %v", err)
+	}
+	if len(m.Hosts) > 0 {
+		cert, err = m.load(m.Hosts[0])
+		if err == nil {
+			m.hostCert = cert
+		} else if !os.IsNotExist(err) {
+			logrus.Warnf("[acme.go::NewCertManager] load cached certificate(%v): %v", m.Hosts[0], err)
+		}
+	}
+	return m, nil
+}
+
+// covered host is matched by wildcard certificate *.Domain
+func (m *CertManager) covered(host string) bool {
+	if host == m.Domain {
+		return true
+	}
+	prefix := strings.TrimSuffix(host, "."+m.Domain)
+	return prefix != host && !strings.Contains(prefix, ".")
+}
+
+func (m *CertManager) isHost(host string) bool {
+	for _, h := range m.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// certFile of certificate named by domain, or first host
+func (m *CertManager) certFile(name string) string {
+	return filepath.Join(m.CacheDir, name+".crt")
+}
+
+func (m *CertManager) keyFile(name string) string {
+	return filepath.Join(m.CacheDir, name+".key")
+}
+
+func (m *CertManager) load(name string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(m.certFile(name), m.keyFile(name))
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (m *CertManager) accountFile() string {
+	return filepath.Join(m.CacheDir, "account.key")
+}
+
+// GetCertificate for tls.Config
+func (m *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.lock.RLock()
+	cert := m.cert
+	if m.hostCert != nil && (cert == nil || m.isHost(hello.ServerName)) {
+		cert = m.hostCert
+	}
+	m.lock.RUnlock()
+	if cert == nil {
+		return nil, errors.New("certificate not ready")
+	}
+	return cert, nil
+}
+
+// HTTPChallenge return key authorization of http-01 challenge token in progress
+func (m *CertManager) HTTPChallenge(token string) (string, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	v, ok := m.tokens[token]
+	return v, ok
+}
+
+func (m *CertManager) setToken(token, keyAuth string) {
+	m.lock.Lock()
+	m.tokens[token] = keyAuth
+	m.lock.Unlock()
+}
+
+func (m *CertManager) delToken(token string) {
+	m.lock.Lock()
+	delete(m.tokens, token)
+	m.lock.Unlock()
+}
+
+func needRenew(cert *tls.Certificate) bool {
+	if cert == nil {
+		return true
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return true
+	}
+	return time.Now().Add(ACME_RENEW_BEFORE).After(leaf.NotAfter)
+}
+
+// Run check and renew certificate until Shutdown
+func (m *CertManager) Run() {
+	ticker := time.NewTicker(ACME_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		m.lock.RLock()
+		cert, hostCert := m.cert, m.hostCert
+		m.lock.RUnlock()
+
+		if needRenew(cert) {
+			m.renew([]string{"*." + m.Domain, m.Domain}, "dns-01")
+		}
+		if len(m.Hosts) > 0 && needRenew(hostCert) {
+			m.renew(m.Hosts, "http-01")
+		}
+
+		select {
+		case <-ticker.C:
+		case <-m.quit:
+			return
+		}
+	}
+}
+
+func (m *CertManager) renew(ids []string, challenge string) {
+	ctx, cancel := context.WithTimeout(context.Background(), ACME_TIMEOUT)
+	defer cancel()
+	err := m.obtain(ctx, ids, challenge)
+	if err != nil {
+		logrus.Errorf("[acme.go::renew] obtain certificate(%v): %v", ids[0], err)
+	} else {
+		logrus.Infof("[acme.go::renew] obtain certificate(%v) success", ids[0])
+	}
+}
+
+// Reload load certificates of cache directory, eg. replaced by operator, current ones are kept if failed
+func (m *CertManager) Reload() error {
+	cert, err := m.load(m.Domain)
+	if err != nil {
+		return err
+	}
+	var hostCert *tls.Certificate
+	if len(m.Hosts) > 0 {
+		hostCert, err = m.load(m.Hosts[0])
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	m.lock.Lock()
+	m.cert = cert
+	if hostCert != nil {
+		m.hostCert = hostCert
+	}
+	m.lock.Unlock()
+	return nil
+}
+
+func (m *CertManager) Shutdown() {
+	close(m.quit)
+}
+
+func (m *CertManager) accountKey() (crypto.Signer, error) {
+	txt, err := ioutil.ReadFile(m.accountFile())
+	if err == nil {
+		block, _ := pem.Decode(txt)
+		if block == nil {
+			return nil, fmt.Errorf("invalid account key: %v", m.accountFile())
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	txt = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return key, ioutil.WriteFile(m.accountFile(), txt, 0600)
+}
+
+// obtain certificate of ids by challenge dns-01 or http-01, first id is the common name
+func (m *CertManager) obtain(ctx context.Context, ids []string, challenge string) error {
+	akey, err := m.accountKey()
+	if err != nil {
+		return err
+	}
+	client := &acme.Client{
+		Key:          akey,
+		DirectoryURL: m.DirectoryURL,
+	}
+
+	var account acme.Account
+	if m.Email != "" {
+		account.Contact = []string{"mailto:" + m.Email}
+	}
+	_, err = client.Register(ctx, &account, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return fmt.Errorf("register: %v", err)
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(ids...))
+	if err != nil {
+		return fmt.Errorf("authorize order: %v", err)
+	}
+
+	var records []string
+	var challenges []*acme.Challenge
+	for _, u := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, u)
+		if err != nil {
+			return fmt.Errorf("get authorization: %v", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		var chal *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == challenge {
+				chal = c
+				break
+			}
+		}
+		if chal == nil {
+			return fmt.Errorf("no %v challenge for %v", challenge, authz.Identifier.Value)
+		}
+		if challenge == "http-01" {
+			keyAuth, err := client.HTTP01ChallengeResponse(chal.Token)
+			if err != nil {
+				return err
+			}
+			m.setToken(chal.Token, keyAuth)
+			defer m.delToken(chal.Token)
+		} else {
+			record, err := client.DNS01ChallengeRecord(chal.Token)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		challenges = append(challenges, chal)
+	}
+	if len(records) > 0 {
+		// both *.domain and domain are validated by _acme-challenge.domain
+		name := "_acme-challenge." + m.Domain
+		m.provider.SetTXT(name, records...)
+		defer m.provider.DelTXT(name)
+	}
+
+	for _, chal := range challenges {
+		_, err = client.Accept(ctx, chal)
+		if err != nil {
+			return fmt.Errorf("accept challenge: %v", err)
+		}
+	}
+	for _, u := range order.AuthzURLs {
+		_, err = client.WaitAuthorization(ctx, u)
+		if err != nil {
+			return fmt.Errorf("wait authorization: %v", err)
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return fmt.Errorf("wait order: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: ids[0]},
+		DNSNames: ids,
+	}, key)
+	if err != nil {
+		return err
+	}
+	der, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("create cert: %v", err)
+	}
+	return m.save(ids[0], der, key)
+}
+
+// save certificate of common name, wildcard one is named by domain and others by first host
+func (m *CertManager) save(cn string, der [][]byte, key *ecdsa.PrivateKey) error {
+	name := strings.TrimPrefix(cn, "*.")
+	var certPEM []byte
+	for _, b := range der {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+	kder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(m.keyFile(name), keyPEM, 0600)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(m.certFile(name), certPEM, 0644)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	if name == m.Domain {
+		m.cert = &cert
+	} else {
+		m.hostCert = &cert
+	}
+	m.lock.Unlock()
+	return nil
+}
+
+// GET /.well-known/acme-challenge/:token
+func (self *WebServer) acmeChallenge(c *gin.Context) {
+	keyAuth, ok := self.HTTPChallenge(c.Param("token"))
+	if !ok {
+		c.String(404, "not found")
+		return
+	}
+	c.String(200, keyAuth)
+}
@@ -0,0 +1,402 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Alert rules of user decide which channels notify a record
+	GET|PUT|POST|DELETE /api/setting/alertrules
+rules are matched by priority, the first matched rule decides channels, eg. mute scanners, mail dns hits
+	{"name": "scanner", "priority": 10, "ua": "Nuclei", "channels": []}
+	{"name": "dns", "types": ["dns"], "domainRe": "^prod-", "channels": ["mail", "webhook:2"]}
+records are not notified if no rule matched, without rules all channels are notified
+*/
+
+const (
+	MAX_USER_ALERT_RULES = 64
+	MAX_ALERT_RULE_NAME  = 64
+
+	ALERT_CALLBACK = "callback"
+	ALERT_WEBHOOKS = "webhooks" //all webhooks, by filters of each webhook
+	ALERT_WEBHOOK  = "webhook:" //prefix of a webhook, eg. webhook:1
+	ALERT_MAIL     = "mail"
+	ALERT_TELEGRAM = "telegram"
+)
+
+var alertChannels = map[string]bool{
+	ALERT_CALLBACK: true,
+	ALERT_WEBHOOKS: true,
+	ALERT_MAIL:     true,
+	ALERT_TELEGRAM: true,
+}
+
+type alertRule struct {
+	models.TblAlertRule
+	domain *regexp.Regexp
+	nets   []*net.IPNet
+}
+
+// alertTarget is fields of record matched by alert rules
+type alertTarget struct {
+	typ    string
+	domain string
+	ip     string
+	ua     string
+}
+
+// alert is channels of record decided by alert rules, nil: all channels
+type alert struct {
+	channels map[string]bool
+}
+
+func (a *alert) Allow(channel string) bool {
+	return a == nil || a.channels[channel]
+}
+
+func (a *alert) AllowWebhook(id int64) bool {
+	return a.Allow(ALERT_WEBHOOKS) || a.channels[ALERT_WEBHOOK+strconv.FormatInt(id, 10)]
+}
+
+func compileAlertRule(item *models.TblAlertRule) (*alertRule, error) {
+	r := &alertRule{TblAlertRule: *item}
+	if item.DomainRe != "" {
+		exp, err := regexp.Compile(item.DomainRe)
+		if err != nil {
+			return nil, fmt.Errorf("domainRe: %v", err)
+		}
+		r.domain = exp
+	}
+	for _, s := range item.Cidrs {
+		ipnet, err := parseCidr(s)
+		if err != nil {
+			return nil, err
+		}
+		r.nets = append(r.nets, ipnet)
+	}
+	return r, nil
+}
+
+func (r *alertRule) Match(t *alertTarget) bool {
+	if len(r.Types) > 0 {
+		found := false
+		for _, typ := range r.Types {
+			if typ == t.typ {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.domain != nil && !r.domain.MatchString(t.domain) {
+		return false
+	}
+	//user agent of http only
+	if r.Ua != "" && !strings.Contains(strings.ToLower(t.ua), strings.ToLower(r.Ua)) {
+		return false
+	}
+	if len(r.nets) > 0 {
+		ip := net.ParseIP(t.ip)
+		if ip == nil {
+			return false
+		}
+		for _, ipnet := range r.nets {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func validAlertRule(req *AlertRule) error {
+	if utf8.RuneCountInString(req.Name) > MAX_ALERT_RULE_NAME {
+		return fmt.Errorf("name should be at most %v characters", MAX_ALERT_RULE_NAME)
+	}
+	for _, typ := range req.Types {
+		if _, exist := exporters[typ]; !exist {
+			return fmt.Errorf("invalid type: %v", typ)
+		}
+	}
+	for _, ch := range req.Channels {
+		if alertChannels[ch] {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(ch, ALERT_WEBHOOK), 10, 64)
+		if !strings.HasPrefix(ch, ALERT_WEBHOOK) || err != nil || id < 1 {
+			return fmt.Errorf("invalid channel: %v", ch)
+		}
+	}
+	if req.Channels == nil {
+		req.Channels = []string{}
+	}
+	_, err := compileAlertRule(&models.TblAlertRule{DomainRe: req.DomainRe, Cidrs: req.Cidrs})
+	return err
+}
+
+// alertRules get compiled rules of user, cached until rules changed
+func (self *WebServer) alertRules(uid int64) ([]*alertRule, error) {
+	store := self.store
+	key := fmt.Sprintf("%v.alertrules", uid)
+	v, exist := store.Get(key)
+	if exist {
+		return v.([]*alertRule), nil
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblAlertRule
+	err := session.Where(`uid=?`, uid).Desc("priority").Asc("id").Find(&items)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]*alertRule, 0, len(items))
+	for i := 0; i < len(items); i++ {
+		r, err := compileAlertRule(&items[i])
+		if err != nil {
+			logrus.Warnf("[alertrule.go::alertRules] compile rule(id=%v): %v", items[i].Id, err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	store.Set(key, rules, cache.NoExpiration)
+	return rules, nil
+}
+
+// recordAlert decide channels of stored record by alert rules of user
+func (self *WebServer) recordAlert(uid int64, typ string, bean interface{}) *alert {
+	if uid == 0 {
+		return nil
+	}
+	rules, err := self.alertRules(uid)
+	if err != nil {
+		logrus.Errorf("[alertrule.go::recordAlert] alertRules: %v", err)
+		return nil
+	} else if len(rules) == 0 {
+		return nil
+	}
+
+	t := &alertTarget{typ: typ}
+	if exp, exist := exporters[typ]; exist {
+		t.ip, t.domain, _ = exp.row(bean)
+	}
+	if item, ok := bean.(*models.TblHttp); ok {
+		t.ua = item.Ua
+	}
+	a := &alert{channels: make(map[string]bool)}
+	for _, r := range rules {
+		if r.Match(t) {
+			for _, ch := range r.Channels {
+				a.channels[ch] = true
+			}
+			break
+		}
+	}
+	return a
+}
+
+func (self *WebServer) getAlertRules(c *gin.Context) {
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblAlertRule
+	err := session.Where(`uid=?`, id).Desc("priority").Asc("id").Find(&items)
+	if err != nil {
+		logrus.Errorf("[alertrule.go::getAlertRules] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	rules := make([]AlertRule, len(items))
+	for i := 0; i < len(items); i++ {
+		item := &items[i]
+		rules[i] = AlertRule{
+			Id:       item.Id,
+			Priority: item.Priority,
+			Name:     item.Name,
+			Types:    item.Types,
+			DomainRe: item.DomainRe,
+			Cidrs:    item.Cidrs,
+			Ua:       item.Ua,
+			Channels: item.Channels,
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  rules,
+	})
+}
+
+func (self *WebServer) addAlertRule(c *gin.Context) {
+	var req AlertRule
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		logrus.Infof("[alertrule.go::addAlertRule] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if err = validAlertRule(&req); err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	count, err := session.Where(`uid=?`, id).Count(&models.TblAlertRule{})
+	if err != nil {
+		logrus.Errorf("[alertrule.go::addAlertRule] orm.Count: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if count >= MAX_USER_ALERT_RULES {
+		self.resp(c, 400, &CR{
+			Message: "Too many alert rules",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	item := models.TblAlertRule{
+		Uid:      id,
+		Priority: req.Priority,
+		Name:     req.Name,
+		Types:    req.Types,
+		DomainRe: req.DomainRe,
+		Cidrs:    req.Cidrs,
+		Ua:       req.Ua,
+		Channels: req.Channels,
+	}
+	_, err = session.InsertOne(&item)
+	if err != nil {
+		logrus.Errorf("[alertrule.go::addAlertRule] orm.InsertOne: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Delete(fmt.Sprintf("%v.alertrules", id))
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  item.Id,
+	})
+}
+
+func (self *WebServer) setAlertRule(c *gin.Context) {
+	var req AlertRule
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.Id < 1 {
+		logrus.Infof("[alertrule.go::setAlertRule] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if err = validAlertRule(&req); err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	affected, err := session.ID(req.Id).And(`uid=?`, id).AllCols().Omit("id", "uid", "atime").
+		Update(&models.TblAlertRule{
+			Priority: req.Priority,
+			Name:     req.Name,
+			Types:    req.Types,
+			DomainRe: req.DomainRe,
+			Cidrs:    req.Cidrs,
+			Ua:       req.Ua,
+			Channels: req.Channels,
+		})
+	if err != nil {
+		logrus.Errorf("[alertrule.go::setAlertRule] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if affected == 0 {
+		self.resp(c, 404, &CR{
+			Message: "No such alert rule",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	self.store.Delete(fmt.Sprintf("%v.alertrules", id))
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func (self *WebServer) delAlertRules(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[alertrule.go::delAlertRules] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	params := make([]interface{}, len(req.Ids))
+	for i := 0; i < len(req.Ids); i++ {
+		params[i] = req.Ids[i]
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	_, err = session.Where(`uid=?`, id).In("id", params...).Delete(&models.TblAlertRule{})
+	if err != nil {
+		logrus.Errorf("[alertrule.go::delAlertRules] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Delete(fmt.Sprintf("%v.alertrules", id))
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
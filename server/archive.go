@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Archive records to S3 compatible storage before they are cleaned, eg.
+	godnslog serve -archive https://AK:SK@minio.example.com:9000/godnslog/archive ...
+objects are gzip compressed jsonl of table rows, named ${uid}/${type}/${time}-${firstId}-${lastId}.jsonl.gz
+records of user are cleaned only if archived, restore by
+	godnslog restore -archive https://AK:SK@minio.example.com:9000/godnslog/archive -prefix 1/dns/
+*/
+
+const (
+	MAX_ARCHIVE_ITEMS = 10000 //records of each object
+)
+
+// recordId is Id of table row
+func recordId(bean interface{}) int64 {
+	return reflect.ValueOf(bean).Elem().FieldByName("Id").Int()
+}
+
+// archiveType upload records of type of user before t
+func (self *WebServer) archiveType(uid int64, typ string, t time.Time) error {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	exp := exporters[typ]
+	var lastId int64
+	for {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		enc := json.NewEncoder(gz)
+		var firstId int64
+		n := 0
+		err := self.iterateHits(session.Where(`uid=?`, uid).And(`ctime<?`, t).And(`id>?`, lastId),
+			exp.bean(), true, MAX_ARCHIVE_ITEMS, func(bean interface{}) error {
+				if err := enc.Encode(bean); err != nil {
+					return err
+				}
+				if n == 0 {
+					firstId = recordId(bean)
+				}
+				lastId = recordId(bean)
+				n++
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if err = gz.Close(); err != nil {
+			return err
+		}
+
+		key := fmt.Sprintf("%v/%v/%v-%v-%v.jsonl.gz", uid, typ, time.Now().Format("20060102T150405"), firstId, lastId)
+		if err = self.Archive.Put(key, buf.Bytes()); err != nil {
+			return err
+		}
+		logrus.Infof("[archive.go::archiveType] %v records archived to %v%v", n, self.Archive, key)
+		if n < MAX_ARCHIVE_ITEMS {
+			return nil
+		}
+	}
+}
+
+// RestoreArchive insert archived records with key prefix back, existing records are skipped
+func (self *WebServer) RestoreArchive(prefix string) (int, error) {
+	keys, err := self.Archive.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	count := 0
+	for _, key := range keys {
+		//${uid}/${type}/xxx.jsonl.gz
+		paths := strings.Split(key, "/")
+		if len(paths) != 3 || !strings.HasSuffix(key, ".jsonl.gz") {
+			continue
+		}
+		exp, ok := exporters[paths[1]]
+		if !ok {
+			continue
+		}
+
+		data, err := self.Archive.Get(key)
+		if err != nil {
+			return count, err
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return count, fmt.Errorf("%v: %v", key, err)
+		}
+		dec := json.NewDecoder(gz)
+		for {
+			bean := exp.bean()
+			err = dec.Decode(bean)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return count, fmt.Errorf("%v: %v", key, err)
+			}
+			exist, err := session.ID(recordId(bean)).Exist(exp.bean())
+			if err != nil {
+				return count, err
+			} else if exist {
+				continue
+			}
+			if _, err = session.InsertOne(bean); err != nil {
+				return count, err
+			}
+			self.indexRecord(bean)
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,325 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/chennqqi/goutils/ginutils"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"xorm.io/xorm"
+)
+
+/*
+Audit log of security relevant actions, rows are appended and never changed nor deleted by godnslog
+	GET /api/admin/audit?uid=2&action=user&failed=1&from=2026-01-01T00:00:00Z&pageNo=1&pageSize=20
+	GET /api/admin/audit/export?format=csv|json|xlsx, same filters
+changes of /api, ie. methods other than GET, are logged with route, status, user and source address,
+logins and failed logins with login name, and reads in AUDIT_READS, eg. backups and exports.
+ids of deletions are logged, and handlers add details by auditDetail, eg. changes of users.
+*/
+
+const (
+	MAX_AUDIT_DETAIL = 1024
+	AUDIT_UID_KEY    = "audit_uid"
+	AUDIT_NAME_KEY   = "audit_name"
+	AUDIT_DETAIL_KEY = "audit_detail"
+)
+
+var (
+	//reads which are audited, by route
+	AUDIT_READS = map[string]bool{
+		"/api/auth/oidc/callback": true,
+		"/api/admin/backup":       true,
+		"/api/admin/audit/export": true,
+		"/api/data/export/:type":  true,
+	}
+
+	//changes which are not audited, by route
+	AUDIT_SKIPS = map[string]bool{
+		"/api/ingest/records":             true, //records of ingest nodes
+		"/api/graphql":                    true, //queries
+		"/api/auth/webauthn/challenge":    true,
+		"/api/setting/webauthn/challenge": true,
+	}
+
+	auditHeader = []string{"id", "ctime", "uid", "name", "action", "status", "ip", "ua", "detail"}
+)
+
+// auditUser set user of unauthenticated request, eg. login
+func auditUser(c *gin.Context, user *models.TblUser) {
+	c.Set(AUDIT_UID_KEY, user.Id)
+	c.Set(AUDIT_NAME_KEY, user.Name)
+}
+
+// auditDetail add detail of action to audit log of request
+func auditDetail(c *gin.Context, format string, args ...interface{}) {
+	detail := fmt.Sprintf(format, args...)
+	if prev := c.GetString(AUDIT_DETAIL_KEY); prev != "" {
+		detail = prev + "; " + detail
+	}
+	c.Set(AUDIT_DETAIL_KEY, detail)
+}
+
+// auditUserRequest add changed fields of user by admin, password is never logged
+func auditUserRequest(c *gin.Context, req *UserRequest) {
+	var fields []string
+	if req.Id != 0 {
+		fields = append(fields, fmt.Sprintf("id=%v", req.Id))
+	}
+	if req.Name != "" {
+		fields = append(fields, fmt.Sprintf("username=%q", req.Name))
+	}
+	if req.Email != "" {
+		fields = append(fields, fmt.Sprintf("email=%q", req.Email))
+	}
+	if req.Role != 0 {
+		fields = append(fields, "role="+RoleName(req.Role))
+	}
+	if req.Password != "" {
+		fields = append(fields, "password=set")
+	}
+	if req.Disabled != nil {
+		fields = append(fields, fmt.Sprintf("disabled=%v", *req.Disabled))
+	}
+	auditDetail(c, "%v", strings.Join(fields, " "))
+}
+
+// auditIds add ids of deletion, body is read again by handler
+func auditIds(c *gin.Context) {
+	if c.Request.Body == nil || c.Request.ContentLength <= 0 || c.Request.ContentLength > MAX_AUDIT_DETAIL {
+		return
+	}
+	body, err := ioutil.ReadAll(c.Request.Body)
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	var req DeleteRecordRequest
+	if json.Unmarshal(body, &req) == nil && len(req.Ids) > 0 {
+		auditDetail(c, "ids=%v", req.Ids)
+	}
+}
+
+// auditLog append action of request to audit log after it is handled
+func (self *WebServer) auditLog(c *gin.Context) {
+	if c.Request.Method == "DELETE" {
+		auditIds(c)
+	}
+	c.Next()
+
+	route := c.FullPath()
+	switch c.Request.Method {
+	case "GET", "HEAD", "OPTIONS":
+		if !AUDIT_READS[route] {
+			return
+		}
+	}
+	if route == "" || AUDIT_SKIPS[route] {
+		return
+	}
+
+	uid, name := c.GetInt64("id"), c.GetString("username")
+	if uid == 0 {
+		uid, name = c.GetInt64(AUDIT_UID_KEY), c.GetString(AUDIT_NAME_KEY)
+	}
+	detail := c.GetString(AUDIT_DETAIL_KEY)
+	if len(c.Params) > 0 {
+		params := make([]string, len(c.Params))
+		for i, p := range c.Params {
+			params[i] = p.Key + "=" + p.Value
+		}
+		if detail != "" {
+			detail = strings.Join(params, " ") + "; " + detail
+		} else {
+			detail = strings.Join(params, " ")
+		}
+	}
+	if len(detail) > MAX_AUDIT_DETAIL {
+		detail = detail[:MAX_AUDIT_DETAIL]
+	}
+	ua := c.Request.UserAgent()
+	if len(ua) > 255 {
+		ua = ua[:255]
+	}
+	item := &models.TblAudit{
+		Uid:    uid,
+		Name:   name,
+		Action: c.Request.Method + " " + route,
+		Status: c.Writer.Status(),
+		Ip:     self.TrustedProxies.ClientIP(c.Request),
+		Ua:     ua,
+		Detail: detail,
+	}
+	if _, err := self.orm.InsertOne(item); err != nil {
+		reqLog(c).Errorf("[audit.go::auditLog] orm.InsertOne(%v): %v", item.Action, err)
+	}
+}
+
+// auditCond filters of audit query
+func (self *WebServer) auditCond(c *gin.Context, session *xorm.Session) (*xorm.Session, error) {
+	session = session.Where(`id>0`)
+	if v := c.Query("uid"); v != "" {
+		uid, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid: %v", v)
+		}
+		session = session.And(`uid=?`, uid)
+	}
+	if v := c.Query("name"); v != "" {
+		session = session.And(`name=?`, v)
+	}
+	if v := c.Query("action"); v != "" {
+		session = session.And(`action like ?`, "%"+v+"%")
+	}
+	if v := c.Query("ip"); v != "" {
+		session = session.And(`ip=?`, v)
+	}
+	if c.Query("failed") == "1" {
+		session = session.And(`status>=?`, 400)
+	}
+	for _, k := range []string{"from", "to"} {
+		v := c.Query(k)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("%v should be RFC3339 time", k)
+		}
+		if k == "from" {
+			session = session.And(`ctime>=?`, self.sqlTime(t))
+		} else {
+			session = session.And(`ctime<?`, self.sqlTime(t))
+		}
+	}
+	return session, nil
+}
+
+func auditRow(item *models.TblAudit) []string {
+	return []string{strconv.FormatInt(item.Id, 10), exportTime(item.Ctime), strconv.FormatInt(item.Uid, 10), item.Name,
+		item.Action, strconv.Itoa(item.Status), item.Ip, item.Ua, item.Detail}
+}
+
+// GET /api/admin/audit
+func (self *WebServer) getAuditLog(c *gin.Context) {
+	pageNo, pageNoErr := ginutils.GetQueryInt(c, "pageNo")
+	if pageNoErr != nil || pageNo <= 0 {
+		pageNo = 1
+	}
+	pageSize, pageSizeErr := ginutils.GetQueryInt(c, "pageSize")
+	if pageSizeErr != nil || pageSize <= 0 {
+		pageSize = 20
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	session, err := self.auditCond(c, session)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	var items []models.TblAudit
+	count, err := session.Desc("id").Limit(pageSize, (pageNo-1)*pageSize).FindAndCount(&items)
+	if err != nil {
+		logrus.Errorf("[audit.go::getAuditLog] orm.FindAndCount: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	var resp models.AuditResp
+	resp.TotalCount = int(count)
+	resp.PageSize = pageSize
+	resp.PageNo = pageNo
+	resp.TotalPage = (resp.TotalCount + (pageSize - 1)) / pageSize
+	resp.Data = make([]models.Audit, len(items))
+	for i := 0; i < len(items); i++ {
+		item := &items[i]
+		resp.Data[i] = models.Audit{
+			Id:     item.Id,
+			Uid:    item.Uid,
+			Name:   item.Name,
+			Action: item.Action,
+			Status: item.Status,
+			Ip:     item.Ip,
+			Ua:     item.Ua,
+			Detail: item.Detail,
+			Ctime:  item.Ctime,
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  &resp,
+	})
+}
+
+// GET /api/admin/audit/export, newest first
+func (self *WebServer) exportAuditLog(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" && format != "xlsx" {
+		self.resp(c, 400, &CR{
+			Message: "format should be csv, json or xlsx",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	session, err := self.auditCond(c, session)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	name := fmt.Sprintf("audit-%v.%v", time.Now().Format("20060102150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, name))
+	var w exportWriter
+	switch format {
+	case "json":
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		w = &jsonWriter{w: c.Writer}
+	case "xlsx":
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w = newXlsxWriter(c.Writer, "audit")
+	default:
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w = &csvWriter{csv.NewWriter(c.Writer)}
+	}
+	c.Status(200)
+
+	if err = w.Write(append([]string{}, auditHeader...)); err != nil {
+		return
+	}
+	var werr error
+	err = session.Desc("id").Limit(MAX_EXPORT_ITEMS).Iterate(new(models.TblAudit), func(i int, bean interface{}) error {
+		werr = w.Write(auditRow(bean.(*models.TblAudit)))
+		return werr
+	})
+	if werr != nil {
+		return
+	} else if err != nil {
+		logrus.Errorf("[audit.go::exportAuditLog] orm.Iterate: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		logrus.Infof("[audit.go::exportAuditLog] Close: %v", err)
+	}
+}
@@ -0,0 +1,363 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Backup users, settings, records and captured files to a portable archive
+	godnslog backup -o godnslog.tar.gz
+	godnslog restore -backup godnslog.tar.gz -driver mysql -dsn "user:pass@tcp(127.0.0.1:3306)/godnslog"
+	curl -H "Access-Token: ${SUPER_TOKEN}" -o godnslog.tar.gz http://127.0.0.1:8080/api/admin/backup
+	curl -H "Access-Token: ${SUPER_TOKEN}" --data-binary @godnslog.tar.gz http://127.0.0.1:8080/api/admin/restore
+archive is tar.gz of manifest.json, tables/${table}.jsonl and blobs/${key}, rows are json of models,
+so a backup of sqlite restores into mysql or postgres. restore replaces all rows of tables in archive,
+ids are kept, search index is rebuilt. super admin only by api
+*/
+
+const (
+	BACKUP_VERSION    = 1
+	BACKUP_MANIFEST   = "manifest.json"
+	BACKUP_BATCH      = 500     //rows of a restore insert
+	BACKUP_BATCH_SIZE = 4 << 20 //bytes of rows of a restore insert, below max_allowed_packet of mysql
+)
+
+type backupManifest struct {
+	Version int       `json:"version"`
+	Driver  string    `json:"driver"`
+	Schema  []string  `json:"schema"` //applied migrations
+	Ctime   time.Time `json:"ctime"`
+}
+
+func newBean(bean interface{}) interface{} {
+	return reflect.New(reflect.TypeOf(bean).Elem()).Interface()
+}
+
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+// Backup write archive of all tables and blobs to w
+func (self *WebServer) Backup(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	migrator := &SchemaMigrator{orm: self.orm, m: newMigrate(self.orm)}
+	applied, _, err := migrator.Versions()
+	if err != nil {
+		return err
+	}
+	manifest, _ := json.Marshal(&backupManifest{
+		Version: BACKUP_VERSION,
+		Driver:  self.orm.DriverName(),
+		Schema:  applied,
+		Ctime:   time.Now(),
+	})
+	err = writeTarFile(tw, BACKUP_MANIFEST, int64(len(manifest)), bytes.NewReader(manifest))
+	if err != nil {
+		return err
+	}
+
+	var blobs []string
+	for _, bean := range schemaBeans {
+		//login sessions are not portable
+		if _, ok := bean.(*models.TblLogin); ok {
+			continue
+		}
+		table := self.orm.TableName(bean)
+		keys, err := self.backupTable(tw, bean)
+		if err != nil {
+			return fmt.Errorf("%v: %v", table, err)
+		}
+		blobs = append(blobs, keys...)
+	}
+	for _, key := range blobs {
+		data, err := self.blob.Get(key)
+		if os.IsNotExist(err) {
+			logrus.Warnf("[backup.go::Backup] blob %v not found", key)
+			continue
+		} else if err != nil {
+			return err
+		}
+		err = writeTarFile(tw, "blobs/"+key, int64(len(data)), bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// backupTable write rows of table as jsonl, blob keys of rows are returned
+func (self *WebServer) backupTable(tw *tar.Writer, bean interface{}) ([]string, error) {
+	//size of tar entry is required before content
+	tmp, err := ioutil.TempFile("", "godnslog-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var blobs []string
+	enc := json.NewEncoder(tmp)
+	encode := func(row interface{}) error {
+		switch item := row.(type) {
+		case *models.TblHttpFile:
+			blobs = append(blobs, item.Blob)
+		case *models.TblPayloadFile:
+			blobs = append(blobs, item.Blob)
+		}
+		return enc.Encode(row)
+	}
+	if isHit(bean) {
+		err = self.iterateHits(session, newBean(bean), true, 0, encode)
+	} else {
+		//not all tables have id, eg. tbl_rollup_mark
+		err = session.Iterate(newBean(bean), func(idx int, row interface{}) error {
+			return encode(row)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return blobs, writeTarFile(tw, "tables/"+self.orm.TableName(bean)+".jsonl", size, tmp)
+}
+
+// RestoreBackup replace tables and blobs by archive of Backup, count of rows by table is returned
+func (self *WebServer) RestoreBackup(r io.Reader) (map[string]int, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+
+	//check manifest before any change
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	} else if hdr.Name != BACKUP_MANIFEST {
+		return nil, fmt.Errorf("not a backup of godnslog")
+	}
+	var manifest backupManifest
+	if err = json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("manifest: %v", err)
+	} else if manifest.Version != BACKUP_VERSION {
+		return nil, fmt.Errorf("unsupported backup version: %v", manifest.Version)
+	}
+
+	tables := make(map[string]interface{})
+	for _, bean := range schemaBeans {
+		tables[self.orm.TableName(bean)] = bean
+	}
+	//audit log is append only, never replaced by backup
+	auditTable := self.orm.TableName(&models.TblAudit{})
+	_, err = self.orm.Exec(`DELETE FROM tbl_search`)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for {
+		hdr, err = tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return counts, err
+		}
+
+		switch {
+		case strings.HasPrefix(hdr.Name, "tables/") && strings.HasSuffix(hdr.Name, ".jsonl"):
+			table := strings.TrimSuffix(strings.TrimPrefix(hdr.Name, "tables/"), ".jsonl")
+			if table == auditTable {
+				continue
+			}
+			bean, ok := tables[table]
+			if !ok {
+				logrus.Warnf("[backup.go::RestoreBackup] unknown table %v", table)
+				continue
+			}
+			n, err := self.restoreTable(tr, bean)
+			counts[table] = n
+			if err != nil {
+				return counts, fmt.Errorf("%v: %v", table, err)
+			}
+		case strings.HasPrefix(hdr.Name, "blobs/"):
+			key := strings.TrimPrefix(hdr.Name, "blobs/")
+			if key == "" || path.Clean(key) != key || strings.HasPrefix(key, "../") || path.IsAbs(key) {
+				return counts, fmt.Errorf("invalid blob: %v", hdr.Name)
+			}
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return counts, err
+			}
+			if err = self.blob.Put(key, data); err != nil {
+				return counts, err
+			}
+		}
+	}
+
+	//sessions of replaced users, logout all
+	if _, err = self.orm.Where(`id>0`).Delete(&models.TblLogin{}); err != nil {
+		return counts, err
+	}
+	//cached users, settings and rules of replaced rows
+	self.store.Flush()
+	self.loadCache()
+	return counts, nil
+}
+
+// restoreTable replace rows of table by jsonl, ids are kept
+func (self *WebServer) restoreTable(r io.Reader, bean interface{}) (int, error) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	_, err := self.deleteHits(session.Where(`1=1`), newBean(bean))
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var offset int64
+	dec := json.NewDecoder(r)
+	rows := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(bean)), 0, BACKUP_BATCH)
+	for {
+		row := newBean(bean)
+		err = dec.Decode(row)
+		if err != nil && err != io.EOF {
+			return count, err
+		}
+		if err == nil {
+			rows = reflect.Append(rows, reflect.ValueOf(row))
+		}
+		full := rows.Len() == BACKUP_BATCH || dec.InputOffset()-offset >= BACKUP_BATCH_SIZE
+		if full || (err == io.EOF && rows.Len() > 0) {
+			if err := self.restoreRows(rows.Interface()); err != nil {
+				return count, err
+			}
+			for i := 0; i < rows.Len(); i++ {
+				self.indexRecord(rows.Index(i).Interface())
+			}
+			count += rows.Len()
+			offset = dec.InputOffset()
+			rows = rows.Slice(0, 0)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if self.orm.DriverName() == "postgres" && !self.inClickHouse(recordType(bean)) {
+		info, err := self.orm.TableInfo(bean)
+		if err != nil || info.AutoIncrement == "" {
+			return count, err
+		}
+		//sequence continues after kept ids
+		_, err = self.orm.Exec(fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('%v', '%v'), COALESCE(MAX(%v), 0) + 1, false) FROM %v`,
+			info.Name, info.AutoIncrement, info.AutoIncrement, info.Name))
+		return count, err
+	}
+	return count, nil
+}
+
+// restoreRows insert rows of a slice with ids and times of backup
+func (self *WebServer) restoreRows(rowsSlice interface{}) error {
+	rows := reflect.ValueOf(rowsSlice)
+	if self.inClickHouse(recordType(rows.Index(0).Interface())) {
+		for i := 0; i < rows.Len(); i++ {
+			if err := self.ClickHouse.Insert(self.orm, rows.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		self.ClickHouse.Flush()
+		return nil
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return err
+	}
+	if _, err := session.NoAutoTime().Insert(rowsSlice); err != nil {
+		return err
+	}
+	return session.Commit()
+}
+
+// recordType is dns or http of hits, empty for other tables
+func recordType(bean interface{}) string {
+	switch bean.(type) {
+	case *models.TblDns:
+		return "dns"
+	case *models.TblHttp:
+		return "http"
+	}
+	return ""
+}
+
+// GET /api/admin/backup
+func (self *WebServer) getBackup(c *gin.Context) {
+	c.Header("Content-Type", "application/gzip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="godnslog-%v.tar.gz"`, time.Now().Format("20060102T150405")))
+	err := self.Backup(c.Writer)
+	if err != nil {
+		//archive is truncated, status has been sent
+		logrus.Errorf("[backup.go::getBackup] Backup: %v", err)
+	}
+}
+
+// POST /api/admin/restore, body is archive of backup
+func (self *WebServer) restoreBackup(c *gin.Context) {
+	counts, err := self.RestoreBackup(c.Request.Body)
+	if err != nil {
+		logrus.Errorf("[backup.go::restoreBackup] RestoreBackup: %v", err)
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+			Result:  counts,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  counts,
+	})
+}
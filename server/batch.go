@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/sirupsen/logrus"
+	"xorm.io/xorm"
+)
+
+/*
+Records of the store routine are inserted in batches, a multi-row insert per table
+	godnslog serve -store-batch 500 -store-flush 100ms
+records are kept until the batch is full or flush interval is passed, then notified in order.
+identical dns hits of dedup window in a batch are collapsed into the first one.
+with a durable queue, failed inserts are retried and the queue keeps records meanwhile
+*/
+
+const (
+	DEFAULT_STORE_BATCH = 500
+	DEFAULT_STORE_FLUSH = 100 * time.Millisecond
+	MAX_STORE_BATCH     = 1000             //placeholders of a statement are limited by databases
+	MAX_STORE_RETRY     = 30 * time.Second //max interval of retries
+)
+
+type batchRecord struct {
+	bean  interface{}
+	added func() //called after bean is inserted and id is set
+}
+
+type recordBatch struct {
+	records []batchRecord
+	dedup   map[string]*models.TblDns //dedup key => dns hit in batch
+}
+
+func newRecordBatch(size int) *recordBatch {
+	return &recordBatch{
+		records: make([]batchRecord, 0, size),
+		dedup:   make(map[string]*models.TblDns),
+	}
+}
+
+func (b *recordBatch) add(bean interface{}, added func()) {
+	b.records = append(b.records, batchRecord{bean: bean, added: added})
+}
+
+func (b *recordBatch) len() int {
+	return len(b.records)
+}
+
+// insertMulti insert beans of a table by a multi-row insert in transaction, ids are set in order.
+// ids of a statement are consecutive, table is locked in postgres, mysql requires auto_increment_increment=1
+func insertMulti(orm *xorm.Engine, beans []interface{}) error {
+	session := orm.NewSession()
+	defer session.Close()
+
+	if len(beans) == 0 {
+		return nil
+	} else if len(beans) == 1 {
+		_, err := session.InsertOne(beans[0])
+		return err
+	}
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+	driver := orm.DriverName()
+	if driver != "sqlite3" && driver != "mysql" && driver != "postgres" {
+		//ids of multi-row insert are unknown
+		for _, bean := range beans {
+			if _, err = session.InsertOne(bean); err != nil {
+				return err
+			}
+		}
+		return session.Commit()
+	}
+
+	table := orm.TableName(beans[0], true)
+	if driver == "postgres" {
+		_, err = session.Exec(fmt.Sprintf(`LOCK TABLE %v IN SHARE ROW EXCLUSIVE MODE`, table))
+		if err != nil {
+			return err
+		}
+	}
+	//typed slice, created time is set to elements on commit
+	rows := reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(beans[0])), 0, len(beans))
+	for _, bean := range beans {
+		rows = reflect.Append(rows, reflect.ValueOf(bean))
+	}
+	_, err = session.Insert(rows.Interface())
+	if err != nil {
+		return err
+	}
+
+	var id int64
+	switch driver {
+	case "sqlite3":
+		_, err = session.SQL(`select last_insert_rowid()`).Get(&id)
+		id -= int64(len(beans) - 1)
+	case "mysql":
+		//id of the first row
+		_, err = session.SQL(`select last_insert_id()`).Get(&id)
+	case "postgres":
+		_, err = session.SQL(fmt.Sprintf(`select currval(pg_get_serial_sequence('%v', 'id'))`, table)).Get(&id)
+		id -= int64(len(beans) - 1)
+	}
+	if err != nil {
+		return err
+	}
+	for i, bean := range beans {
+		reflect.ValueOf(bean).Elem().FieldByName("Id").SetInt(id + int64(i))
+	}
+	return session.Commit()
+}
+
+// retryStore call store until it succeeds if records are kept in durable queue, eg. database is down
+func (self *WebServer) retryStore(name string, store func() error) error {
+	wait := time.Second
+	err := store()
+	for err != nil && self.DurableQueue {
+		logrus.Errorf("[batch.go::retryStore] %v: %v, retry in %v", name, err, wait)
+		time.Sleep(wait)
+		if wait *= 2; wait > MAX_STORE_RETRY {
+			wait = MAX_STORE_RETRY
+		}
+		err = store()
+	}
+	return err
+}
+
+// flushRecords insert records of batch by tables and notify inserted ones
+func (self *WebServer) flushRecords(b *recordBatch) {
+	if b.len() == 0 {
+		return
+	}
+	var tables []reflect.Type
+	groups := make(map[reflect.Type][]interface{})
+	for _, r := range b.records {
+		t := reflect.TypeOf(r.bean)
+		if _, exist := groups[t]; !exist {
+			tables = append(tables, t)
+		}
+		groups[t] = append(groups[t], r.bean)
+	}
+
+	ctx, span := self.Tracer.Start(context.Background(), "store flush", SPAN_INTERNAL)
+	defer span.End()
+	span.SetAttr("records", b.len())
+
+	failed := make(map[reflect.Type]bool)
+	for _, t := range tables {
+		beans := groups[t]
+		_, insert := self.Tracer.Start(ctx, "insert "+tableType(t), SPAN_INTERNAL)
+		insert.SetAttr("rows", len(beans))
+		if _, ok := beans[0].(*models.TblDns); ok {
+			items := make([]*models.TblDns, len(beans))
+			for i, bean := range beans {
+				items[i] = bean.(*models.TblDns)
+			}
+			err := self.retryStore("Store.InsertDNS", func() error {
+				return timeInsert("dns", func() error {
+					return self.Store.InsertDNS(items...)
+				})
+			})
+			insert.SetError(err)
+			insert.End()
+			if err != nil {
+				//records of batch are lost, the store routine keeps running
+				logrus.WithFields(logrus.Fields{"type": "dns", "records": len(items)}).Errorf("[batch.go::flushRecords] Store.InsertDNS: %v", err)
+				failed[t] = true
+			}
+			continue
+		}
+		err := self.retryStore("orm.Insert", func() error {
+			return timeInsert(tableType(t), func() error {
+				return insertMulti(self.orm, beans)
+			})
+		})
+		insert.SetError(err)
+		insert.End()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"type": tableType(t), "records": len(beans)}).Errorf("[batch.go::flushRecords] orm.Insert(%v): %v", t.Elem().Name(), err)
+			failed[t] = true
+		}
+	}
+
+	for _, r := range b.records {
+		if !failed[reflect.TypeOf(r.bean)] {
+			r.added()
+		}
+	}
+	b.records = b.records[:0]
+	b.dedup = make(map[string]*models.TblDns)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// BlobStore keep large captured data(e.g. uploaded files) out of database
+type BlobStore struct {
+	dir string
+}
+
+func NewBlobStore(dir string) (*BlobStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &BlobStore{dir: dir}, nil
+}
+
+func (self *BlobStore) path(key string) string {
+	return filepath.Join(self.dir, filepath.FromSlash(key))
+}
+
+func (self *BlobStore) Put(key string, data []byte) error {
+	p := self.path(key)
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, data, 0644)
+}
+
+func (self *BlobStore) Get(key string) ([]byte, error) {
+	return ioutil.ReadFile(self.path(key))
+}
+
+func (self *BlobStore) Delete(key string) error {
+	err := os.Remove(self.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func httpFileKey(uid, rid int64, n int) string {
+	return fmt.Sprintf("http/%v/%v/%v", uid, rid, n)
+}
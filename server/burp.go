@@ -0,0 +1,347 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"xorm.io/xorm"
+)
+
+/*
+Burp collaborator compatible polling, configure burp with
+	Server location:  ${shortId}.godnslog.com
+	Polling location: ${shortId}.godnslog.com, poll over unencrypted http if no certificate
+payloads of burp are ${interactionId}.${shortId}.godnslog.com, dns and http interactions are returned by
+	GET /burpresults?biid=${biid}
+biid of first poll is pending, it is bound after approved by user, interactions after approval are returned once
+	GET    /api/setting/burp                     biid and ip of pending and bound clients
+	POST   /api/setting/burp/approve {"id":1}
+	DELETE /api/setting/burp {"ids":[1]}
+encrypted results are not supported
+*/
+
+const (
+	MAX_BURP_RESULTS = 1000 //of each protocol per poll
+	MAX_BURP_PENDING = 16   //pending clients of a user, oldest are dropped
+)
+
+const interactionBody = `<html><body>godnslog</body></html>`
+
+type burpResult struct {
+	Protocol          string                 `json:"protocol"`
+	OpCode            string                 `json:"opCode"`
+	InteractionString string                 `json:"interactionString"`
+	ClientPart        string                 `json:"clientPart"`
+	Data              map[string]interface{} `json:"data"`
+	Time              string                 `json:"time"`
+	Client            string                 `json:"client"`
+}
+
+// burpInteraction is interaction id of payload, the label before shortId
+func burpInteraction(prefix string) string {
+	return prefix[strings.LastIndexByte(prefix, '.')+1:]
+}
+
+// interactionLog log any request to subdomain of user or interactsh client, as collaborator server
+func (self *WebServer) interactionLog(c *gin.Context) {
+	path := c.Request.URL.Path
+	if strings.HasPrefix(path, "/log/") || strings.HasPrefix(path, "/payload/") {
+		return
+	}
+	prefix, shortId, _ := parseUserDomain(self.store, requestHost(c), self.Domain)
+	var user *models.TblUser
+	variable := path
+	if v, exist := self.store.Get(shortId + ".suser"); exist && prefix != "" {
+		user = v.(*models.TblUser)
+	} else {
+		user, variable = interactshUser(self.store, shortId)
+	}
+	if user == nil {
+		return
+	}
+	if _, err := self.logHttp(c, user, variable); err != nil && err != ErrRecordQuota {
+		reqLog(c).Errorf("[burp.go::interactionLog] logHttp: %v", err)
+	}
+	c.Data(200, "text/html", []byte(interactionBody))
+	c.Abort()
+}
+
+// GET http://${shortId}.godnslog.com/burpresults?biid=${biid}
+func (self *WebServer) burpResults(c *gin.Context) {
+	biid := c.Query("biid")
+	user := self.hostUser(c)
+	if user == nil || biid == "" {
+		c.JSON(200, gin.H{})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var client models.TblBurpClient
+	exist, err := session.Where(`biid=?`, biid).Get(&client)
+	if err != nil {
+		logrus.Errorf("[burp.go::burpResults] orm.Get(client): %v", err)
+		c.JSON(502, gin.H{})
+		return
+	}
+	if !exist {
+		//pending until approved by user in web ui, shortId in payloads is public
+		self.addBurpPending(session, user, biid, c.ClientIP())
+		c.JSON(200, gin.H{})
+		return
+	}
+	if client.Uid != user.Id || !client.Bound {
+		c.JSON(200, gin.H{})
+		return
+	}
+
+	var results []burpResult
+	var dnsItems []models.TblDns
+	err = self.findHits(session.Where(`uid=?`, user.Id).And(`id>?`, client.DnsId), &dnsItems, true, MAX_BURP_RESULTS, 0)
+	if err != nil {
+		logrus.Errorf("[burp.go::burpResults] orm.Find(dns): %v", err)
+		c.JSON(502, gin.H{})
+		return
+	}
+	for i := 0; i < len(dnsItems); i++ {
+		item := &dnsItems[i]
+		client.DnsId = item.Id
+		id := burpInteraction(item.Var)
+		if id == "" {
+			continue
+		}
+		qtype := dns.StringToType[item.Qtype]
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(item.Domain), qtype)
+		raw, _ := msg.Pack()
+		results = append(results, burpResult{
+			Protocol:          "dns",
+			OpCode:            "0",
+			InteractionString: id,
+			ClientPart:        id,
+			Data: map[string]interface{}{
+				"subDomain":  item.Domain,
+				"type":       qtype,
+				"rawRequest": base64.StdEncoding.EncodeToString(raw),
+			},
+			Time:   strconv.FormatInt(item.Ctime.UnixNano()/1e6, 10),
+			Client: item.Ip,
+		})
+	}
+
+	var httpItems []models.TblHttp
+	err = self.findHits(session.Where(`uid=?`, user.Id).And(`id>?`, client.HttpId), &httpItems, true, MAX_BURP_RESULTS, 0)
+	if err != nil {
+		logrus.Errorf("[burp.go::burpResults] orm.Find(http): %v", err)
+		c.JSON(502, gin.H{})
+		return
+	}
+	for i := 0; i < len(httpItems); i++ {
+		item := &httpItems[i]
+		client.HttpId = item.Id
+		host := item.Host
+		if strings.Contains(host, ":") {
+			host = host[:strings.LastIndexByte(host, ':')]
+		}
+		prefix, _, _ := parseUserDomain(self.store, host, self.Domain)
+		id := burpInteraction(prefix)
+		if id == "" {
+			continue
+		}
+		protocol := "http"
+		if item.TlsVersion != "" {
+			protocol = "https"
+		}
+		results = append(results, burpResult{
+			Protocol:          protocol,
+			OpCode:            "0",
+			InteractionString: id,
+			ClientPart:        id,
+			Data: map[string]interface{}{
+				"request":  base64.StdEncoding.EncodeToString(rawHttpRequest(item)),
+				"response": base64.StdEncoding.EncodeToString(rawHttpResponse()),
+			},
+			Time:   strconv.FormatInt(item.Ctime.UnixNano()/1e6, 10),
+			Client: item.Ip,
+		})
+	}
+
+	if len(dnsItems) > 0 || len(httpItems) > 0 {
+		_, err = session.ID(client.Id).Cols("dns_id", "http_id").Update(&client)
+		if err != nil {
+			logrus.Errorf("[burp.go::burpResults] orm.Update(client): %v", err)
+			c.JSON(502, gin.H{})
+			return
+		}
+	}
+	if len(results) == 0 {
+		c.JSON(200, gin.H{})
+		return
+	}
+	c.JSON(200, gin.H{"responses": results})
+}
+
+// addBurpPending save biid of first poll as pending client of user
+func (self *WebServer) addBurpPending(session *xorm.Session, user *models.TblUser, biid, ip string) {
+	var pending []models.TblBurpClient
+	err := session.Where(`uid=? AND bound=?`, user.Id, false).Desc("id").Cols("id").Find(&pending)
+	if err != nil {
+		logrus.Errorf("[burp.go::addBurpPending] orm.Find: %v", err)
+		return
+	}
+	if len(pending) >= MAX_BURP_PENDING {
+		ids := make([]interface{}, 0, len(pending))
+		for i := MAX_BURP_PENDING - 1; i < len(pending); i++ {
+			ids = append(ids, pending[i].Id)
+		}
+		session.In("id", ids...).Delete(&models.TblBurpClient{})
+	}
+	_, err = session.InsertOne(&models.TblBurpClient{Uid: user.Id, Biid: biid, Ip: ip})
+	if err != nil {
+		logrus.Errorf("[burp.go::addBurpPending] orm.InsertOne(%v): %v", biid, err)
+		return
+	}
+	logrus.Infof("[burp.go::addBurpPending] biid %v of %v pending for user %v", biid, ip, user.Name)
+}
+
+// GET /api/setting/burp
+func (self *WebServer) getBurpClients(c *gin.Context) {
+	var items []models.TblBurpClient
+	err := self.orm.Where(`uid=?`, c.GetInt64("id")).Desc("id").Find(&items)
+	if err != nil {
+		logrus.Errorf("[burp.go::getBurpClients] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	clients := make([]models.BurpClient, len(items))
+	for i := 0; i < len(items); i++ {
+		item := &items[i]
+		clients[i] = models.BurpClient{
+			Id:    item.Id,
+			Biid:  item.Biid,
+			Ip:    item.Ip,
+			Bound: item.Bound,
+			Atime: item.Atime,
+			Btime: item.Btime,
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  clients,
+	})
+}
+
+// POST /api/setting/burp/approve, interactions before approval are not returned
+func (self *WebServer) approveBurpClient(c *gin.Context) {
+	var req models.BurpClient
+	if err := c.ShouldBindJSON(&req); err != nil || req.Id == 0 {
+		logrus.Infof("[burp.go::approveBurpClient] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "id required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	uid := c.GetInt64("id")
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var client models.TblBurpClient
+	exist, err := session.Where(`id=? AND uid=?`, req.Id, uid).Get(&client)
+	if err == nil && exist && !client.Bound {
+		var dnsItem models.TblDns
+		var httpItem models.TblHttp
+		if _, err = session.Where(`uid=?`, uid).Desc("id").Cols("id").Get(&dnsItem); err == nil {
+			_, err = session.Where(`uid=?`, uid).Desc("id").Cols("id").Get(&httpItem)
+		}
+		if err == nil {
+			client.Bound, client.Btime = true, time.Now()
+			client.DnsId, client.HttpId = dnsItem.Id, httpItem.Id
+			_, err = session.ID(client.Id).Cols("bound", "btime", "dns_id", "http_id").Update(&client)
+		}
+	}
+	if err != nil {
+		logrus.Errorf("[burp.go::approveBurpClient] approve %v: %v", req.Id, err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if !exist {
+		self.resp(c, 404, &CR{
+			Message: "client not found",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	auditDetail(c, "biid=%v ip=%v", client.Biid, client.Ip)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// DELETE /api/setting/burp
+func (self *WebServer) delBurpClients(c *gin.Context) {
+	var req DeleteRecordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[burp.go::delBurpClients] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	params := make([]interface{}, len(req.Ids))
+	for i := 0; i < len(req.Ids); i++ {
+		params[i] = req.Ids[i]
+	}
+	_, err := self.orm.Where(`uid=?`, c.GetInt64("id")).In("id", params...).Delete(&models.TblBurpClient{})
+	if err != nil {
+		logrus.Errorf("[burp.go::delBurpClients] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// rawHttpRequest rebuild raw request from logged fields, other headers are not kept
+func rawHttpRequest(item *models.TblHttp) []byte {
+	body, _ := base64.StdEncoding.DecodeString(item.Body)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v %v %v\r\nHost: %v\r\n", item.Method, item.Path, item.Proto, item.Host)
+	if item.Ua != "" {
+		fmt.Fprintf(&b, "User-Agent: %v\r\n", item.Ua)
+	}
+	if item.Ctype != "" {
+		fmt.Fprintf(&b, "Content-Type: %v\r\n", item.Ctype)
+	}
+	if item.Size > 0 {
+		fmt.Fprintf(&b, "Content-Length: %v\r\n", item.Size)
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+	return []byte(b.String())
+}
+
+func rawHttpResponse() []byte {
+	return []byte(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: %v\r\n\r\n%v",
+		len(interactionBody), interactionBody))
+}
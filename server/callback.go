@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Callbacks are signed by callback secret of user, receivers verify
+	X-Godnslog-Timestamp: ${unix seconds}
+	X-Godnslog-Signature: sha256=${hex(hmac_sha256(secret, timestamp + "." + body))}
+and reject old timestamps to prevent replay. the secret is shown in app setting, reset by
+	POST /api/setting/callback/secret
+body is the record as json, or callback template of user executed with callbackData, eg.
+	{"summary": {{json (printf "%s hit from %s" .Type .Record.Ip)}}, "record": {{.Json}}}
+*/
+
+const (
+	CALLBACK_SECRET_LEN       = 32
+	CALLBACK_TIMESTAMP_HEADER = "X-Godnslog-Timestamp"
+	CALLBACK_SIGNATURE_HEADER = "X-Godnslog-Signature"
+	MAX_CALLBACK_TEMPLATE     = 64 * 1024
+)
+
+// callbackData is data of callback template
+type callbackData struct {
+	Type   string      //dns, ldap
+	User   string      //name of user
+	Record interface{} //*DnsRecord, *LdapRecord
+	Json   string      //record as json
+}
+
+var callbackFuncs = template.FuncMap{
+	//json encode value, eg. {{json .Record.Domain}}
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+func parseCallbackTemplate(text string) (*template.Template, error) {
+	return template.New("callback").Funcs(callbackFuncs).Option("missingkey=error").Parse(text)
+}
+
+// callbackBody is record json, or executed template of user
+func callbackBody(user *models.TblUser, typ string, rcd interface{}) ([]byte, error) {
+	data, err := json.Marshal(rcd)
+	if err != nil || user.CallbackTemplate == "" {
+		return data, err
+	}
+	tmpl, err := parseCallbackTemplate(user.CallbackTemplate)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, &callbackData{
+		Type:   typ,
+		User:   user.Name,
+		Record: rcd,
+		Json:   string(data),
+	})
+	return buf.Bytes(), err
+}
+
+func signCallback(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newDelivery make delivery of record, hid is id of webhook, 0 for callback of app setting
+func (self *WebServer) newDelivery(uid, hid int64, url, typ string, rid int64, rcd interface{}) (*models.TblDelivery, error) {
+	v, exist := self.store.Get(fmt.Sprintf("%v.user", uid))
+	if !exist {
+		return nil, fmt.Errorf("user %v not found", uid)
+	}
+	body, err := callbackBody(v.(*models.TblUser), typ, rcd)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TblDelivery{
+		Uid:  uid,
+		Hid:  hid,
+		Url:  url,
+		Type: typ,
+		Rid:  rid,
+		Body: string(body),
+	}, nil
+}
+
+// newCallbackRequest make request of delivery signed by current secret of user
+func (self *WebServer) newCallbackRequest(d *models.TblDelivery) (*http.Request, error) {
+	v, exist := self.store.Get(fmt.Sprintf("%v.user", d.Uid))
+	if !exist {
+		return nil, fmt.Errorf("user %v not found", d.Uid)
+	}
+	req, err := http.NewRequest("POST", d.Url, strings.NewReader(d.Body))
+	if err != nil {
+		return nil, err
+	}
+	if json.Valid([]byte(d.Body)) {
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(CALLBACK_TIMESTAMP_HEADER, timestamp)
+	req.Header.Set(CALLBACK_SIGNATURE_HEADER, signCallback(v.(*models.TblUser).CallbackSecret, timestamp, []byte(d.Body)))
+	return req, nil
+}
+
+// initCallbackSecrets generate secret of users created before callbacks are signed
+func (self *WebServer) initCallbackSecrets() error {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var users []models.TblUser
+	err := session.Where(`callback_secret is null or callback_secret=''`).Cols("id").Find(&users)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(users); i++ {
+		_, err = session.ID(users[i].Id).Cols("callback_secret").
+			Update(&models.TblUser{CallbackSecret: genRandomString(CALLBACK_SECRET_LEN)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// POST /api/setting/callback/secret, old secret is invalid at once
+func (self *WebServer) resetCallbackSecret(c *gin.Context) {
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var user models.TblUser
+	exist, err := session.ID(id).Get(&user)
+	if err != nil || !exist {
+		logrus.Errorf("[callback.go::resetCallbackSecret] orm.Get(%v): %v, %v", id, exist, err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	user.CallbackSecret = genRandomString(CALLBACK_SECRET_LEN)
+	_, err = session.ID(id).Cols("callback_secret").Update(&user)
+	if err != nil {
+		logrus.Errorf("[callback.go::resetCallbackSecret] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	//update cache
+	if v, exist := self.store.Get(fmt.Sprintf("%v.user", id)); exist {
+		secret := user.CallbackSecret
+		user = *v.(*models.TblUser)
+		user.CallbackSecret = secret
+	}
+	self.store.Set(fmt.Sprintf("%v.user", id), &user, cache.NoExpiration)
+	self.store.Set(fmt.Sprintf("%v.suser", user.ShortId), &user, cache.NoExpiration)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  user.CallbackSecret,
+	})
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+)
+
+// coseKeyCbor is an ES256 COSE_Key {1: 2, 3: -7, -1: 1, -2: x, -3: y}
+func coseKeyCbor() []byte {
+	b := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	b = append(b, bytes.Repeat([]byte{0x11}, 32)...)
+	b = append(b, 0x22, 0x58, 0x20)
+	return append(b, bytes.Repeat([]byte{0x22}, 32)...)
+}
+
+func TestDecodeCbor(t *testing.T) {
+	var tests = []struct {
+		Input  []byte
+		Expect interface{}
+		Error  bool
+	}{
+		{[]byte{0x00}, int64(0), false},
+		{[]byte{0x18, 0x64}, int64(100), false},
+		{[]byte{0x39, 0x01, 0x00}, int64(-257), false},
+		{[]byte{0x43, 0x01, 0x02, 0x03}, []byte{1, 2, 3}, false},
+		{[]byte{0x62, 'o', 'k'}, "ok", false},
+		{[]byte{0xf5}, true, false},
+		{[]byte{0xf6}, nil, false},
+		{[]byte{0xc2, 0x41, 0x01}, []byte{1}, false},
+		{[]byte{}, nil, true},
+		{[]byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, nil, true},
+		{[]byte{0x5f}, nil, true},                                     //indefinite length
+		{[]byte{0x5b, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}, nil, true}, //length over data
+		{[]byte{0x9b, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff}, nil, true}, //array over data
+		{[]byte{0xa1, 0x41, 0x00, 0x00}, nil, true},                   //bytes key
+		{[]byte{0xf9, 0x3c, 0x00}, nil, true},                         //float
+		{append(bytes.Repeat([]byte{0x81}, MAX_CBOR_DEPTH+1), 0x00), nil, true},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		test := &tests[i]
+		v, rest, err := decodeCbor(test.Input)
+		if test.Error {
+			if err == nil {
+				t.Fatalf("test %v decodeCbor(%x) should fail, got %v", i, test.Input, v)
+			}
+			continue
+		}
+		if err != nil || len(rest) != 0 {
+			t.Fatalf("test %v decodeCbor(%x): %v, rest %x", i, test.Input, err, rest)
+		}
+		if b, ok := test.Expect.([]byte); ok {
+			if !bytes.Equal(v.([]byte), b) {
+				t.Fatalf("test %v decodeCbor(%x)=%x!=expect(%x)", i, test.Input, v, b)
+			}
+		} else if v != test.Expect {
+			t.Fatalf("test %v decodeCbor(%x)=%v!=expect(%v)", i, test.Input, v, test.Expect)
+		}
+	}
+
+	//every truncation of a complete item is refused
+	key := coseKeyCbor()
+	if _, rest, err := decodeCbor(append(key, 0xff)); err != nil || !bytes.Equal(rest, []byte{0xff}) {
+		t.Fatalf("test decodeCbor(cose key): %v, rest %x", err, rest)
+	}
+	for n := 0; n < len(key); n++ {
+		if _, _, err := decodeCbor(key[:n]); err == nil {
+			t.Fatalf("test decodeCbor(cose key[:%v]) should fail", n)
+		}
+	}
+}
+
+func TestParseAuthData(t *testing.T) {
+	key := coseKeyCbor()
+	credId := []byte("credential")
+	var ad []byte
+	ad = append(ad, bytes.Repeat([]byte{0xaa}, 32)...)
+	ad = append(ad, WEBAUTHN_FLAG_UP|WEBAUTHN_FLAG_AT, 0, 0, 0, 7)
+	ad = append(ad, make([]byte, 16)...)
+	ad = append(ad, 0, byte(len(credId)))
+	ad = append(ad, credId...)
+	ad = append(ad, key...)
+
+	r, err := parseAuthData(ad)
+	if err != nil {
+		t.Fatalf("parseAuthData: %v", err)
+	}
+	if r.signCount != 7 || !bytes.Equal(r.credId, credId) || !bytes.Equal(r.publicKey, key) {
+		t.Fatalf("parseAuthData(%v, %q, %x) invalid", r.signCount, r.credId, r.publicKey)
+	}
+	if _, _, err = parseCoseKey(r.publicKey); err == nil {
+		t.Fatalf("parseCoseKey should fail with point not on curve")
+	}
+
+	for n := 0; n < len(ad); n++ {
+		if _, err := parseAuthData(ad[:n]); err == nil {
+			t.Fatalf("test parseAuthData(ad[:%v]) should fail", n)
+		}
+	}
+
+	//assertion has no attested credential
+	assertion := append([]byte{}, ad[:37]...)
+	assertion[32] = WEBAUTHN_FLAG_UP
+	if r, err = parseAuthData(assertion); err != nil || r.credId != nil || r.signCount != 7 {
+		t.Fatalf("parseAuthData(assertion): %v", err)
+	}
+
+	//zero length credential id
+	empty := append([]byte{}, ad[:53]...)
+	empty = append(empty, 0, 0)
+	empty = append(empty, key...)
+	if _, err = parseAuthData(empty); err == nil {
+		t.Fatalf("parseAuthData should fail with empty credential id")
+	}
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+)
+
+/*
+Webhooks of chat robots, kind of webhook is
+	dingtalk: https://oapi.dingtalk.com/robot/send?access_token=XXX, secret of "sign" security setting
+	wecom:    https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=XXX, no secret
+	feishu:   https://open.feishu.cn/open-apis/bot/v2/hook/XXX, secret of "signature verification"
+	slack:    https://hooks.slack.com/services/XXX, incoming webhook, no secret
+	discord:  https://discord.com/api/webhooks/XXX/YYY, no secret
+body is a message of the record with link to web ui, signed when sent, keyword of robot can be "godnslog"
+*/
+
+const (
+	CHAT_DINGTALK = "dingtalk"
+	CHAT_WECOM    = "wecom"
+	CHAT_FEISHU   = "feishu"
+	CHAT_SLACK    = "slack"
+	CHAT_DISCORD  = "discord"
+
+	MAX_CHAT_RECORD = 1024 //leading bytes of record json in message
+)
+
+var chatKinds = map[string]bool{
+	CHAT_DINGTALK: true,
+	CHAT_WECOM:    true,
+	CHAT_FEISHU:   true,
+	CHAT_SLACK:    true,
+	CHAT_DISCORD:  true,
+}
+
+// retryable error codes of chat robots, eg. send too fast
+var chatRetryCodes = map[string][]int{
+	CHAT_DINGTALK: {130101},
+	CHAT_WECOM:    {45009},
+	CHAT_FEISHU:   {9499, 11232},
+}
+
+// uiUrl is base url of web ui, by -ui-url or public ip and port of web listener
+func (self *WebServer) uiUrl() string {
+	if uiUrl := self.notify().UiUrl; uiUrl != "" {
+		return strings.TrimSuffix(uiUrl, "/")
+	}
+	_, port, err := net.SplitHostPort(self.Listen)
+	if err != nil || self.IP == "" {
+		return ""
+	} else if port == "80" {
+		return "http://" + self.IP
+	}
+	return "http://" + net.JoinHostPort(self.IP, port)
+}
+
+// recordLink is page of record in web ui, empty for records without page
+func (self *WebServer) recordLink(event *models.SessionEvent) string {
+	base := self.uiUrl()
+	if base == "" {
+		return ""
+	}
+	switch rcd := event.Data.(type) {
+	case models.DnsRecord:
+		return base + "/record/dns?domain=" + url.QueryEscape(rcd.Domain)
+	case models.HttpRecord:
+		return base + "/record/http?ip=" + url.QueryEscape(rcd.Ip)
+	}
+	return ""
+}
+
+// chatBody make message of record event for robot of kind
+func (self *WebServer) chatBody(kind string, event *models.SessionEvent) ([]byte, error) {
+	title := fmt.Sprintf("[godnslog] %v hit", event.Type)
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return nil, err
+	}
+	rcd := string(data)
+	if len(rcd) > MAX_CHAT_RECORD {
+		rcd = strings.ToValidUTF8(rcd[:MAX_CHAT_RECORD], "") + "..."
+	}
+	token := strings.TrimPrefix(event.Var, "/")
+	ctime := event.Ctime.Format(time.RFC3339)
+	link := self.recordLink(event)
+	lines := []string{
+		fmt.Sprintf("token: %v", token),
+		fmt.Sprintf("from: %v", event.Ip),
+		fmt.Sprintf("time: %v", ctime),
+	}
+
+	var msg interface{}
+	switch kind {
+	case CHAT_DINGTALK:
+		if link != "" {
+			lines = append(lines, fmt.Sprintf("[view in godnslog](%v)", link))
+		}
+		msg = map[string]interface{}{
+			"msgtype": "markdown",
+			"markdown": map[string]string{
+				"title": title,
+				"text":  fmt.Sprintf("### %v\n\n%v\n\n> %v", title, strings.Join(lines, "\n\n"), rcd),
+			},
+		}
+	case CHAT_WECOM:
+		if link != "" {
+			lines = append(lines, fmt.Sprintf("[view in godnslog](%v)", link))
+		}
+		msg = map[string]interface{}{
+			"msgtype": "markdown",
+			"markdown": map[string]string{
+				"content": fmt.Sprintf("**%v**\n%v\n> %v", title, strings.Join(lines, "\n"), rcd),
+			},
+		}
+	case CHAT_FEISHU:
+		if link != "" {
+			lines = append(lines, link)
+		}
+		msg = map[string]interface{}{
+			"msg_type": "text",
+			"content": map[string]string{
+				"text": fmt.Sprintf("%v\n%v\n%v", title, strings.Join(lines, "\n"), rcd),
+			},
+		}
+	case CHAT_SLACK:
+		fields := []map[string]string{
+			{"type": "mrkdwn", "text": "*Type*\n" + slackEscape(event.Type)},
+			{"type": "mrkdwn", "text": "*Token*\n" + slackEscape(token)},
+			{"type": "mrkdwn", "text": "*Source IP*\n" + slackEscape(event.Ip)},
+			{"type": "mrkdwn", "text": "*Time*\n" + ctime},
+		}
+		text := fmt.Sprintf("*%v*", slackEscape(title))
+		if link != "" {
+			text += fmt.Sprintf(" <%v|view in godnslog>", link)
+		}
+		msg = map[string]interface{}{
+			"text": title, //notification fallback
+			"blocks": []interface{}{
+				map[string]interface{}{
+					"type":   "section",
+					"text":   map[string]string{"type": "mrkdwn", "text": text},
+					"fields": fields,
+				},
+				map[string]interface{}{
+					"type": "section",
+					"text": map[string]string{"type": "mrkdwn", "text": "```" + slackEscape(rcd) + "```"},
+				},
+			},
+		}
+	case CHAT_DISCORD:
+		embed := map[string]interface{}{
+			"title":       title,
+			"description": "```json\n" + strings.ReplaceAll(rcd, "```", "`\u200b``") + "\n```",
+			"color":       0x1890ff,
+			"fields": []map[string]interface{}{
+				{"name": "Type", "value": event.Type, "inline": true},
+				{"name": "Token", "value": discordValue(token), "inline": true},
+				{"name": "Source IP", "value": discordValue(event.Ip), "inline": true},
+			},
+			"timestamp": ctime,
+		}
+		if link != "" {
+			embed["url"] = link
+		}
+		msg = map[string]interface{}{
+			"username": "godnslog",
+			"embeds":   []interface{}{embed},
+		}
+	default:
+		return nil, fmt.Errorf("invalid kind: %v", kind)
+	}
+	return json.Marshal(msg)
+}
+
+// slackEscape escape control characters of slack mrkdwn
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// discordValue is value of embed field, which should not be empty
+func discordValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func chatSign(key, msg string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(msg))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newChatRequest make request of delivery, signed by timestamp of now
+func newChatRequest(hook *webhook, d *models.TblDelivery) (*http.Request, error) {
+	u, body := d.Url, []byte(d.Body)
+	switch {
+	case hook.Kind == CHAT_DINGTALK && hook.Secret != "":
+		timestamp := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+		sep := "?"
+		if strings.Contains(u, "?") {
+			sep = "&"
+		}
+		u += sep + "timestamp=" + timestamp + "&sign=" + url.QueryEscape(chatSign(hook.Secret, timestamp+"\n"+hook.Secret))
+	case hook.Kind == CHAT_FEISHU && hook.Secret != "":
+		var msg map[string]interface{}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return nil, err
+		}
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		msg["timestamp"] = timestamp
+		msg["sign"] = chatSign(timestamp+"\n"+hook.Secret, "")
+		body, _ = json.Marshal(msg)
+	}
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// chatResult check error code in response of robot
+func chatResult(kind string, resp []byte) (retry bool, err error) {
+	if kind == CHAT_SLACK || kind == CHAT_DISCORD {
+		//errors are responsed with status code
+		return false, nil
+	}
+	var r struct {
+		Errcode int    `json:"errcode"` //dingtalk, wecom
+		Errmsg  string `json:"errmsg"`
+		Code    int    `json:"code"` //feishu
+		Msg     string `json:"msg"`
+	}
+	if err = json.Unmarshal(resp, &r); err != nil {
+		return false, fmt.Errorf("invalid response")
+	}
+	code, msg := r.Errcode, r.Errmsg
+	if kind == CHAT_FEISHU {
+		code, msg = r.Code, r.Msg
+	}
+	if code == 0 {
+		return false, nil
+	}
+	for _, c := range chatRetryCodes[kind] {
+		if c == code {
+			retry = true
+		}
+	}
+	return retry, fmt.Errorf("error %v: %v", code, msg)
+}
+
+// chatHook is webhook of delivery if it is a chat robot
+func (self *WebServer) chatHook(d *models.TblDelivery) *webhook {
+	if d.Hid == 0 {
+		return nil
+	}
+	hooks, err := self.webhooks(d.Uid)
+	if err != nil {
+		return nil
+	}
+	for _, h := range hooks {
+		if h.Id == d.Hid && h.Kind != "" {
+			return h
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+CORS policy of /api and /data, for web ui hosted separately or browser extensions
+	-cors-origins https://ui.example.com,https://*.example.org,chrome-extension://abcdefg
+	-cors-methods GET,POST,PUT,DELETE
+origins are matched exactly or by wildcard subdomain, * allows any origin. authentication is by
+Access-Token or Authorization header, so credentials of browser are not allowed.
+*/
+
+const (
+	CORS_DEFAULT_METHODS = "GET,POST,PUT,DELETE,HEAD"
+	CORS_MAX_AGE         = "600"
+	CORS_EXPOSE_HEADERS  = REQUEST_ID_HEADER + ", Content-Disposition"
+)
+
+// allowOrigin match origin of request with -cors-origins
+func (self *WebServer) allowOrigin(origin string) bool {
+	for _, allowed := range self.CorsOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		//scheme://*.domain matches subdomains of domain
+		i := strings.Index(allowed, "://*.")
+		if i < 0 {
+			continue
+		}
+		scheme, suffix := allowed[:i+3], allowed[i+4:]
+		if len(origin) > len(scheme)+len(suffix) &&
+			strings.EqualFold(origin[:len(scheme)], scheme) &&
+			strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsHandler add CORS headers to responses of /api and /data, and answer preflight requests
+func (self *WebServer) corsHandler(c *gin.Context) {
+	path := c.Request.URL.Path
+	if !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/data/") {
+		return
+	}
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		return
+	}
+	c.Writer.Header().Add("Vary", "Origin")
+	if !self.allowOrigin(origin) {
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", origin)
+	if c.Request.Method != http.MethodOptions || c.GetHeader("Access-Control-Request-Method") == "" {
+		c.Header("Access-Control-Expose-Headers", CORS_EXPOSE_HEADERS)
+		return
+	}
+
+	//preflight
+	c.Header("Access-Control-Allow-Methods", strings.Join(self.CorsMethods, ", "))
+	if headers := c.GetHeader("Access-Control-Request-Headers"); headers != "" {
+		c.Header("Access-Control-Allow-Headers", headers)
+	}
+	c.Header("Access-Control-Max-Age", CORS_MAX_AGE)
+	c.AbortWithStatus(204)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+CSRF protection of state changing /api routes, by Origin and Sec-Fetch-Site headers of browsers
+	POST /api/setting/app from https://evil.example.net => 403
+api is authenticated by Access-Token header instead of cookies, so browsers never attach credentials
+to forged requests, and json bodies of text/plain forms are rejected here too. origins of the web ui,
+-ui-url and -cors-origins are allowed, clients without the headers, eg. curl and scripts, are not affected.
+*/
+
+// sameOrigin is true if origin is the host of request or web ui
+func (self *WebServer) sameOrigin(c *gin.Context, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, c.Request.Host) {
+		return true
+	}
+	if uiUrl := self.notify().UiUrl; uiUrl != "" {
+		if ui, err := url.Parse(uiUrl); err == nil && strings.EqualFold(u.Host, ui.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// csrfHandler deny changes of cross site requests of browsers
+func (self *WebServer) csrfHandler(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	origin := c.GetHeader("Origin")
+	switch {
+	case origin != "" && origin != "null":
+		if self.sameOrigin(c, origin) || self.allowOrigin(origin) {
+			return
+		}
+	case c.GetHeader("Sec-Fetch-Site") == "cross-site":
+		//origin is hidden, eg. by referrer policy
+	case origin == "":
+		return
+	}
+	reqLog(c).Infof("[csrf.go::csrfHandler] cross site %v %v of origin %q denied", c.Request.Method, c.Request.URL.Path, origin)
+	self.resp(c, 403, &CR{
+		Message: "cross site request denied",
+		Code:    CodeNoPermission,
+	})
+	c.Abort()
+}
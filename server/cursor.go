@@ -0,0 +1,74 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+/*
+Keyset pagination, records are ordered by id desc, eg.
+	GET /api/record/dns?limit=50
+	GET /api/record/dns?after_id=1234&limit=50
+records with id < after_id are returned, nextId of response is after_id of next page.
+total count is not calculated with cursor
+*/
+
+const (
+	MAX_CURSOR_LIMIT = 1000
+)
+
+type cursor struct {
+	afterId int64
+	limit   int
+}
+
+// parseCursor return nil if neither after_id nor limit is set
+func parseCursor(c *gin.Context, limit int) (*cursor, error) {
+	afterId, afterIdExist := c.GetQuery("after_id")
+	limitStr, limitExist := c.GetQuery("limit")
+	if !afterIdExist && !limitExist {
+		return nil, nil
+	}
+	cur := &cursor{limit: limit}
+	var err error
+	if afterIdExist {
+		cur.afterId, err = strconv.ParseInt(afterId, 10, 64)
+		if err != nil || cur.afterId < 0 {
+			return nil, fmt.Errorf("invalid after_id: %v", afterId)
+		}
+	}
+	if limitExist {
+		cur.limit, err = strconv.Atoi(limitStr)
+		if err != nil || cur.limit <= 0 {
+			return nil, fmt.Errorf("invalid limit: %v", limitStr)
+		}
+	}
+	if cur.limit > MAX_CURSOR_LIMIT {
+		cur.limit = MAX_CURSOR_LIMIT
+	}
+	return cur, nil
+}
+
+// after select records after cursor
+func (cur *cursor) after(session *xorm.Session) *xorm.Session {
+	if cur.afterId > 0 {
+		session = session.And(`id < ?`, cur.afterId)
+	}
+	return session
+}
+
+// page select a page after cursor
+func (cur *cursor) page(session *xorm.Session) *xorm.Session {
+	return cur.after(session).Desc("id").Limit(cur.limit)
+}
+
+// next is after_id of next page, 0 if no more records
+func (cur *cursor) next(n int, lastId int64) int64 {
+	if n < cur.limit {
+		return 0
+	}
+	return lastId
+}
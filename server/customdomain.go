@@ -0,0 +1,403 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Custom domains of users, white-label domains of out-of-band payloads answered by dns server of godnslog
+	PUT    /api/setting/domains {"domain":"oob.acme.com"}
+	POST   /api/setting/domains/verify {"id":1}
+	GET    /api/setting/domains
+	DELETE /api/setting/domains {"ids":[1]}
+a domain is verified by NS records to ns.example.com, a host under domain of godnslog or resolving to its IP,
+or by TXT record _godnslog.oob.acme.com of godnslog-verify=<token>. add the domain before NS records are
+changed, NS queries of it are answered while pending. after verified x.oob.acme.com is same as
+x.userXXXX.example.com, eg. r.oob.acme.com is rebinding. domains under domain of godnslog, parents and
+subdomains of other domains are denied, pending domains of other users expire after a day.
+*/
+
+const (
+	MAX_USER_DOMAINS      = 8
+	DOMAIN_TOKEN_LEN      = 32
+	DOMAIN_TXT_PREFIX     = "_godnslog."
+	DOMAIN_TXT_VALUE      = "godnslog-verify="
+	DOMAIN_VERIFY_TIMEOUT = 10 * time.Second
+	DOMAIN_PENDING_EXPIRE = 24 * time.Hour
+
+	DOMAIN_VERIFY_NS  = "ns"
+	DOMAIN_VERIFY_TXT = "txt"
+)
+
+var domainLabelRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// lookupCustomDomain get custom domain of name or its parents, prefix is labels before the domain
+func lookupCustomDomain(store *cache.Cache, name string) (*models.TblDomain, string) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for suffix := name; strings.Contains(suffix, "."); suffix = suffix[strings.IndexByte(suffix, '.')+1:] {
+		if v, exist := store.Get(suffix + ".cdomain"); exist {
+			prefix := strings.TrimSuffix(strings.TrimSuffix(name, suffix), ".")
+			return v.(*models.TblDomain), prefix
+		}
+	}
+	return nil, ""
+}
+
+// parseUserDomain is parseDomain of root, or of verified custom domains of users
+func parseUserDomain(store *cache.Cache, name, root string) (prefix, shortId string, rebind bool) {
+	lower := strings.ToLower(strings.TrimSuffix(name, "."))
+	if lower == root || strings.HasSuffix(lower, "."+root) {
+		return parseDomain(name, root)
+	}
+	d, prefix := lookupCustomDomain(store, lower)
+	if d == nil || !d.Verified {
+		return "", "", false
+	}
+	v, exist := store.Get(fmt.Sprintf("%v.user", d.Uid))
+	if !exist {
+		return "", "", false
+	}
+	rebind = prefix == "r" || strings.HasSuffix(prefix, ".r")
+	return prefix, v.(*models.TblUser).ShortId, rebind
+}
+
+// domainParents is domain and its parents, eg. a.b.c => a.b.c, b.c, c
+func domainParents(domain string) []interface{} {
+	var parents []interface{}
+	for suffix := domain; ; suffix = suffix[strings.IndexByte(suffix, '.')+1:] {
+		parents = append(parents, suffix)
+		if !strings.Contains(suffix, ".") {
+			return parents
+		}
+	}
+}
+
+// validCustomDomain is lower case domain of at least two labels
+func validCustomDomain(domain string) bool {
+	labels := strings.Split(domain, ".")
+	if len(domain) > 253 || len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if !domainLabelRegexp.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *WebServer) customDomain(item *models.TblDomain) *models.CustomDomain {
+	return &models.CustomDomain{
+		Id:       item.Id,
+		Domain:   item.Domain,
+		Verified: item.Verified,
+		Method:   item.Method,
+		Ns:       "ns." + self.Domain,
+		Ip:       self.IP,
+		TxtName:  DOMAIN_TXT_PREFIX + item.Domain,
+		TxtValue: DOMAIN_TXT_VALUE + item.Token,
+		Vtime:    item.Vtime,
+		Atime:    item.Atime,
+	}
+}
+
+// domainResolver is resolver of -domain-resolver, or of system
+func (self *WebServer) domainResolver() *net.Resolver {
+	if self.DomainResolver == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, self.DomainResolver)
+		},
+	}
+}
+
+// verifyCustomDomain check NS records of domain, then TXT record of token
+func (self *WebServer) verifyCustomDomain(item *models.TblDomain) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DOMAIN_VERIFY_TIMEOUT)
+	defer cancel()
+	r := self.domainResolver()
+
+	nss, _ := r.LookupNS(ctx, item.Domain)
+	for _, ns := range nss {
+		host := strings.ToLower(strings.TrimSuffix(ns.Host, "."))
+		if host == self.Domain || strings.HasSuffix(host, "."+self.Domain) {
+			return DOMAIN_VERIFY_NS, nil
+		}
+		addrs, _ := r.LookupHost(ctx, host)
+		for _, addr := range addrs {
+			if addr == self.IP {
+				return DOMAIN_VERIFY_NS, nil
+			}
+		}
+	}
+	txts, _ := r.LookupTXT(ctx, DOMAIN_TXT_PREFIX+item.Domain)
+	for _, txt := range txts {
+		if txt == DOMAIN_TXT_VALUE+item.Token {
+			return DOMAIN_VERIFY_TXT, nil
+		}
+	}
+	return "", fmt.Errorf("neither NS records to ns.%v nor TXT record %v%v found", self.Domain, DOMAIN_TXT_PREFIX, item.Domain)
+}
+
+// loadCustomDomains cache custom domains of database
+func (self *WebServer) loadCustomDomains() {
+	err := self.orm.Iterate(new(models.TblDomain), func(idx int, bean interface{}) error {
+		item := bean.(*models.TblDomain)
+		self.store.Set(item.Domain+".cdomain", item, cache.NoExpiration)
+		return nil
+	})
+	if err != nil {
+		logrus.Errorf("[customdomain.go::loadCustomDomains] orm.Iterate: %v", err)
+	}
+}
+
+// deleteCustomDomains delete custom domains of users
+func (self *WebServer) deleteCustomDomains(uids ...interface{}) {
+	var items []models.TblDomain
+	if err := self.orm.In("uid", uids...).Find(&items); err != nil {
+		logrus.Errorf("[customdomain.go::deleteCustomDomains] orm.Find: %v", err)
+		return
+	}
+	for i := 0; i < len(items); i++ {
+		self.store.Delete(items[i].Domain + ".cdomain")
+	}
+	self.orm.In("uid", uids...).Delete(&models.TblDomain{})
+}
+
+// GET /api/setting/domains
+func (self *WebServer) getCustomDomains(c *gin.Context) {
+	var items []models.TblDomain
+	err := self.orm.Where(`uid=?`, c.GetInt64("id")).Asc("id").Find(&items)
+	if err != nil {
+		logrus.Errorf("[customdomain.go::getCustomDomains] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	domains := make([]*models.CustomDomain, len(items))
+	for i := 0; i < len(items); i++ {
+		domains[i] = self.customDomain(&items[i])
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  domains,
+	})
+}
+
+// PUT /api/setting/domains, domain is pending until verified
+func (self *WebServer) addCustomDomain(c *gin.Context) {
+	var req models.CustomDomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logrus.Infof("[customdomain.go::addCustomDomain] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	uid := c.GetInt64("id")
+	domain := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(req.Domain), "."))
+	if !validCustomDomain(domain) {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("invalid domain: %q", req.Domain),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if domain == self.Domain || strings.HasSuffix(domain, "."+self.Domain) || strings.HasSuffix(self.Domain, "."+domain) {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("domain overlaps %v", self.Domain),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	count, err := session.Where(`uid=?`, uid).Count(&models.TblDomain{})
+	if err != nil {
+		logrus.Errorf("[customdomain.go::addCustomDomain] orm.Count: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if count >= MAX_USER_DOMAINS {
+		self.resp(c, 400, &CR{
+			Message: "Too many domains",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	//parents and subdomains
+	var others []models.TblDomain
+	err = session.In("domain", domainParents(domain)...).Or(`domain LIKE ?`, "%."+domain).Find(&others)
+	if err != nil {
+		logrus.Errorf("[customdomain.go::addCustomDomain] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	for i := 0; i < len(others); i++ {
+		other := &others[i]
+		if other.Uid != uid && !other.Verified && time.Since(other.Atime) > DOMAIN_PENDING_EXPIRE {
+			//expired claim of another user
+			session.ID(other.Id).Delete(&models.TblDomain{})
+			self.store.Delete(other.Domain + ".cdomain")
+			continue
+		}
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("domain overlaps %v", other.Domain),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	item := models.TblDomain{
+		Uid:    uid,
+		Domain: domain,
+		Token:  genRandomString(DOMAIN_TOKEN_LEN),
+	}
+	if _, err = session.InsertOne(&item); err != nil {
+		logrus.Errorf("[customdomain.go::addCustomDomain] orm.InsertOne: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Set(item.Domain+".cdomain", &item, cache.NoExpiration)
+	auditDetail(c, "domain=%v", domain)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  self.customDomain(&item),
+	})
+}
+
+// POST /api/setting/domains/verify
+func (self *WebServer) verifyDomain(c *gin.Context) {
+	var req models.CustomDomainRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Id == 0 {
+		logrus.Infof("[customdomain.go::verifyDomain] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "id required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	var item models.TblDomain
+	exist, err := self.orm.Where(`id=? AND uid=?`, req.Id, c.GetInt64("id")).Get(&item)
+	if err != nil {
+		logrus.Errorf("[customdomain.go::verifyDomain] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if !exist {
+		self.resp(c, 404, &CR{
+			Message: "domain not found",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	auditDetail(c, "domain=%v", item.Domain)
+	if item.Verified {
+		self.resp(c, 200, &CR{
+			Message: "OK",
+			Result:  self.customDomain(&item),
+		})
+		return
+	}
+
+	method, err := self.verifyCustomDomain(&item)
+	if err != nil {
+		reqLog(c).Infof("[customdomain.go::verifyDomain] %v not verified: %v", item.Domain, err)
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	item.Verified = true
+	item.Method = method
+	item.Vtime = time.Now()
+	_, err = self.orm.ID(item.Id).Cols("verified", "method", "vtime").Update(&item)
+	if err != nil {
+		logrus.Errorf("[customdomain.go::verifyDomain] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Set(item.Domain+".cdomain", &item, cache.NoExpiration)
+	reqLog(c).Infof("[customdomain.go::verifyDomain] %v verified by %v", item.Domain, method)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  self.customDomain(&item),
+	})
+}
+
+// DELETE /api/setting/domains
+func (self *WebServer) delCustomDomains(c *gin.Context) {
+	var req DeleteRecordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[customdomain.go::delCustomDomains] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	params := make([]interface{}, len(req.Ids))
+	for i := 0; i < len(req.Ids); i++ {
+		params[i] = req.Ids[i]
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblDomain
+	err := session.Where(`uid=?`, c.GetInt64("id")).In("id", params...).Find(&items)
+	if err == nil && len(items) > 0 {
+		_, err = session.Where(`uid=?`, c.GetInt64("id")).In("id", params...).Delete(&models.TblDomain{})
+	}
+	if err != nil {
+		logrus.Errorf("[customdomain.go::delCustomDomains] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	for i := 0; i < len(items); i++ {
+		self.store.Delete(items[i].Domain + ".cdomain")
+	}
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
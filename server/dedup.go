@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Dedup window of dns hits, enabled in app setting
+	{"dedupWindow": 60}
+identical (domain, ip, qtype) hits within the window after a stored record are counted in hits of it,
+collapsed hits are not notified, eg. retrying resolvers won't storm webhooks
+*/
+
+const (
+	MAX_DEDUP_WINDOW = 3600
+)
+
+// dnsDedupKey is cache key of identical dns hits and dedup window of user, empty key if disabled
+func (self *WebServer) dnsDedupKey(d *DnsRecord) (string, time.Duration) {
+	if d.Uid == 0 || self.ClickHouse != nil {
+		return "", 0
+	}
+	v, exist := self.store.Get(fmt.Sprintf("%v.user", d.Uid))
+	if !exist {
+		return "", 0
+	}
+	window := v.(*models.TblUser).DedupWindow
+	if window <= 0 {
+		return "", 0
+	}
+	key := fmt.Sprintf("%v.dedup.dns.%v|%v|%v", d.Uid, strings.ToLower(d.Domain), d.Ip, d.Qtype)
+	return key, time.Duration(window) * time.Second
+}
+
+// dedupHit count hit in record of key if it is in dedup window, true if collapsed
+func (self *WebServer) dedupHit(key string, bean interface{}) bool {
+	v, exist := self.store.Get(key)
+	if !exist {
+		return false
+	}
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	affected, err := session.ID(v.(int64)).Incr("hits").Update(bean)
+	if err != nil {
+		logrus.Errorf("[dedup.go::dedupHit] orm.Update: %v", err)
+		return false
+	} else if affected == 0 {
+		//record was deleted
+		self.store.Delete(key)
+		return false
+	}
+	return true
+}
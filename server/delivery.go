@@ -0,0 +1,296 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Every attempt of callbacks and webhooks is saved as a delivery, listed by id of webhook, 0 for callback
+	GET /api/setting/webhooks/${id}/deliveries?after_id=&limit=
+and sent again, to the current url of the webhook, by
+	POST /api/setting/webhooks/${id}/deliveries/${did}/redeliver
+failed attempts are retried on error, 5xx and 429, deliveries are kept for DELIVERY_KEEP
+*/
+
+const (
+	MAX_DELIVERY_ATTEMPTS = 4
+	MAX_DELIVERY_RESPONSE = 1024 //saved leading bytes of response body
+	DELIVERY_TIMEOUT      = 10 * time.Second
+	DELIVERY_RETRY_WAIT   = 5 * time.Second //doubled each retry
+	MAX_DELIVERY_WAIT     = 60 * time.Second
+	DELIVERY_KEEP         = 7 * 24 * time.Hour
+	DEFAULT_DELIVERY_PAGE = 50
+)
+
+// newDeliveryClient is client of webhooks and callbacks, responses are saved in deliveries
+func newDeliveryClient(allowPrivate bool) *http.Client {
+	return &http.Client{Timeout: DELIVERY_TIMEOUT, Transport: targetTransport(allowPrivate)}
+}
+
+// deliverOnce send delivery and fill result of the attempt, retry is true if the attempt may succeed later
+func (self *WebServer) deliverOnce(ctx context.Context, d *models.TblDelivery) (retry bool, err error) {
+	_, span := self.Tracer.Start(ctx, "webhook attempt", SPAN_CLIENT)
+	defer func() {
+		span.SetAttr("attempt", d.Attempt)
+		span.SetAttr("http.status_code", d.Status)
+		span.SetError(err)
+		span.End()
+	}()
+
+	hook := self.chatHook(d)
+	var req *http.Request
+	switch {
+	case hook != nil && hook.Kind == FEDERATION_KIND:
+		req, err = newRelayRequest(hook, d)
+	case hook != nil:
+		req, err = newChatRequest(hook, d)
+	default:
+		req, err = self.newCallbackRequest(d)
+	}
+	if err != nil {
+		d.Error = err.Error()
+		return false, err
+	}
+	if span != nil {
+		span.SetAttr("net.peer.name", req.URL.Host)
+		req.Header.Set(TRACEPARENT_HEADER, span.Traceparent())
+	}
+	start := time.Now()
+	resp, err := self.deliveryClient.Do(req.WithContext(ctx))
+	d.Elapsed = int64(time.Since(start) / time.Millisecond)
+	if err != nil {
+		d.Error = err.Error()
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	snippet, _ := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_DELIVERY_RESPONSE))
+	d.Status = resp.StatusCode
+	d.Response = strings.ToValidUTF8(string(snippet), "")
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("status %v", resp.StatusCode)
+		d.Error = err.Error()
+		return resp.StatusCode >= 500 || resp.StatusCode == 429, err
+	}
+	if hook != nil && hook.Kind != FEDERATION_KIND {
+		//chat robots response errors with status 200
+		if retry, err = chatResult(hook.Kind, snippet); err != nil {
+			d.Error = err.Error()
+		}
+		return retry, err
+	}
+	return false, nil
+}
+
+func (self *WebServer) saveDelivery(d *models.TblDelivery) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	d.Id = 0
+	if _, err := session.InsertOne(d); err != nil {
+		logrus.Errorf("[delivery.go::saveDelivery] orm.InsertOne: %v", err)
+	}
+}
+
+// deliver send delivery until success or attempts are used up, every attempt is saved
+func (self *WebServer) deliver(d *models.TblDelivery) (err error) {
+	ctx, span := self.Tracer.Start(self.deliverCtx, "webhook deliver", SPAN_INTERNAL)
+	span.SetAttr("webhook.id", d.Hid)
+	span.SetAttr("record.type", d.Type)
+	span.SetAttr("record.id", d.Rid)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	wait := DELIVERY_RETRY_WAIT
+	for attempt := 1; ; attempt++ {
+		d.Attempt, d.Status, d.Elapsed, d.Response, d.Error = attempt, 0, 0, "", ""
+		start := time.Now()
+		var retry bool
+		retry, err = self.deliverOnce(ctx, d)
+		self.saveDelivery(d)
+		result := "success"
+		if err != nil {
+			result = "failure"
+		}
+		metricDeliverySecs.observe(result, time.Since(start))
+		if err == nil || !retry || attempt >= MAX_DELIVERY_ATTEMPTS {
+			metricDeliveries.inc(result)
+			return err
+		}
+		select {
+		case <-time.After(wait):
+		case <-self.storeQuit:
+			return err
+		}
+		if wait *= 2; wait > MAX_DELIVERY_WAIT {
+			wait = MAX_DELIVERY_WAIT
+		}
+	}
+}
+
+// cleanDeliveries remove deliveries older than DELIVERY_KEEP
+func (self *WebServer) cleanDeliveries() {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	t := self.sqlTime(time.Now().Add(-DELIVERY_KEEP))
+	if _, err := session.Where(`ctime<?`, t).Delete(&models.TblDelivery{}); err != nil {
+		logrus.Errorf("[delivery.go::cleanDeliveries] orm.Delete: %v", err)
+	}
+}
+
+func deliveryResp(item *models.TblDelivery) models.Delivery {
+	return models.Delivery{
+		Id:       item.Id,
+		Hid:      item.Hid,
+		Url:      item.Url,
+		Type:     item.Type,
+		Rid:      item.Rid,
+		Body:     item.Body,
+		Attempt:  item.Attempt,
+		Status:   item.Status,
+		Elapsed:  item.Elapsed,
+		Response: item.Response,
+		Error:    item.Error,
+		Ctime:    item.Ctime,
+	}
+}
+
+// GET /api/setting/webhooks/:id/deliveries
+func (self *WebServer) getDeliveries(c *gin.Context) {
+	hid, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || hid < 0 {
+		self.resp(c, 400, &CR{
+			Message: "invalid id",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	cur, err := parseCursor(c, DEFAULT_DELIVERY_PAGE)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	} else if cur == nil {
+		cur = &cursor{limit: DEFAULT_DELIVERY_PAGE}
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblDelivery
+	err = cur.page(session.Where(`uid=?`, id).And(`hid=?`, hid)).Find(&items)
+	if err != nil {
+		logrus.Errorf("[delivery.go::getDeliveries] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	var resp DeliveryResp
+	resp.AfterId = cur.afterId
+	resp.Data = make([]models.Delivery, len(items))
+	for i := 0; i < len(items); i++ {
+		resp.Data[i] = deliveryResp(&items[i])
+	}
+	if len(items) > 0 {
+		resp.NextId = cur.next(len(items), items[len(items)-1].Id)
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  resp,
+	})
+}
+
+// POST /api/setting/webhooks/:id/deliveries/:did/redeliver, one attempt to the current url
+func (self *WebServer) redeliver(c *gin.Context) {
+	hid, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	did, err2 := strconv.ParseInt(c.Param("did"), 10, 64)
+	if err != nil || err2 != nil || hid < 0 {
+		self.resp(c, 400, &CR{
+			Message: "invalid id",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var d models.TblDelivery
+	exist, err := session.ID(did).And(`uid=?`, id).And(`hid=?`, hid).Get(&d)
+	if err != nil {
+		logrus.Errorf("[delivery.go::redeliver] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if !exist {
+		self.resp(c, 404, &CR{
+			Message: "No such delivery",
+			Code:    CodeNoData,
+		})
+		return
+	}
+
+	//url may have been fixed since
+	if hid == 0 {
+		v, exist := self.store.Get(fmt.Sprintf("%v.user", id))
+		if exist {
+			d.Url = v.(*models.TblUser).Callback
+		}
+	} else {
+		var hook models.TblWebhook
+		exist, err = session.ID(hid).And(`uid=?`, id).Get(&hook)
+		if err != nil {
+			logrus.Errorf("[delivery.go::redeliver] orm.Get(webhook): %v", err)
+			self.resp(c, 502, &CR{
+				Message: "Failed",
+				Code:    CodeServerInternal,
+			})
+			return
+		} else if !exist {
+			self.resp(c, 404, &CR{
+				Message: "No such webhook",
+				Code:    CodeNoData,
+			})
+			return
+		}
+		d.Url = hook.Url
+	}
+	if d.Url == "" {
+		self.resp(c, 400, &CR{
+			Message: "No url",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	d.Attempt, d.Status, d.Elapsed, d.Response, d.Error = 1, 0, 0, "", ""
+	self.deliverOnce(c.Request.Context(), &d)
+	self.saveDelivery(&d)
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  deliveryResp(&d),
+	})
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Digest of hits since the last digest, enabled in app setting
+	{"digest": 1, "digestHour": 9} daily at 09:00, {"digest": 2} weekly on monday
+counts of each type, new tokens, top sources and tokens are sent by mail and telegram of user,
+a digest is sent even without hits, so quiet engagements are known to be alive
+*/
+
+const (
+	DIGEST_OFF    = 0
+	DIGEST_DAILY  = 1
+	DIGEST_WEEKLY = 2
+
+	DIGEST_TOP = 10
+)
+
+type digestReport struct {
+	From      time.Time
+	To        time.Time
+	Counts    map[string]int64
+	Total     int64
+	NewTokens []models.StatsTop
+	TopIps    []models.StatsTop
+	TopTokens []models.StatsTop
+}
+
+// nextDigest is time of the digest after last, at hour of next day or next monday
+func nextDigest(last time.Time, digest, hour int) time.Time {
+	last = last.Local()
+	t := time.Date(last.Year(), last.Month(), last.Day(), hour, 0, 0, 0, time.Local)
+	if !t.After(last) {
+		t = t.AddDate(0, 0, 1)
+	}
+	if digest == DIGEST_WEEKLY {
+		for t.Weekday() != time.Monday {
+			t = t.AddDate(0, 0, 1)
+		}
+	}
+	return t
+}
+
+func (self *WebServer) digestReport(uid int64, from, to time.Time) (*digestReport, error) {
+	r := &digestReport{From: from, To: to}
+	fromStr, toStr := statsTime(from), statsTime(to)
+	var err error
+	if r.Counts, r.Total, err = self.statsCounts(uid, fromStr, toStr); err != nil {
+		return nil, err
+	}
+	if r.NewTokens, err = self.statsNewTokens(uid, fromStr, toStr, DIGEST_TOP); err != nil {
+		return nil, err
+	}
+	if r.TopIps, err = self.statsTop(`ip`, uid, fromStr, toStr, DIGEST_TOP); err != nil {
+		return nil, err
+	}
+	if r.TopTokens, err = self.statsTop(self.tokenExpr(), uid, fromStr, toStr, DIGEST_TOP); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *digestReport) Text() string {
+	const layout = "2006-01-02 15:04"
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "%v - %v\n", r.From.Local().Format(layout), r.To.Local().Format(layout))
+	fmt.Fprintf(&buf, "Total hits: %v\n", r.Total)
+	for _, typ := range searchTypes {
+		if r.Counts[typ] > 0 {
+			fmt.Fprintf(&buf, "  %v: %v\n", typ, r.Counts[typ])
+		}
+	}
+	tops := []struct {
+		title string
+		items []models.StatsTop
+	}{
+		{"New tokens", r.NewTokens},
+		{"Top sources", r.TopIps},
+		{"Top tokens", r.TopTokens},
+	}
+	for _, top := range tops {
+		if len(top.items) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "\n%v:\n", top.title)
+		for _, item := range top.items {
+			fmt.Fprintf(&buf, "  %v (%v)\n", item.Value, item.Count)
+		}
+	}
+	return buf.String()
+}
+
+// sendDigest send digest of [from, to) by mail and telegram of user
+func (self *WebServer) sendDigest(user *models.TblUser, from, to time.Time) error {
+	r, err := self.digestReport(user.Id, from, to)
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("[godnslog] digest of %v, %v hits", user.Name, r.Total)
+	text := r.Text()
+
+	if srv, err := self.mailServer(); err != nil {
+		logrus.Errorf("[digest.go::sendDigest] mailServer: %v", err)
+	} else if srv.Host != "" && user.Email != "" {
+		if err = sendMail(srv, user.Email, subject, text); err != nil {
+			logrus.Infof("[digest.go::sendDigest] sendMail(%v): %v", user.Email, err)
+		}
+	}
+	if self.notify().TelegramToken != "" && user.TelegramChatId != "" {
+		msg := fmt.Sprintf("<b>%v</b>\n<pre>%v</pre>", html.EscapeString(subject), html.EscapeString(text))
+		if err = self.sendTelegram(user.TelegramChatId, msg); err != nil {
+			logrus.Infof("[digest.go::sendDigest] user(id=%v): %v", user.Id, err)
+		}
+	}
+	return nil
+}
+
+// doDigest send due digests of users
+func (self *WebServer) doDigest() {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var users []models.TblUser
+	err := session.Where(`digest>?`, DIGEST_OFF).Find(&users)
+	if err != nil {
+		logrus.Errorf("[digest.go::doDigest] orm.Find: %v", err)
+		return
+	}
+	now := time.Now()
+	for i := 0; i < len(users); i++ {
+		user := &users[i]
+		from := user.DigestTime
+		if from.IsZero() {
+			from = now.AddDate(0, 0, -1)
+			if user.Digest == DIGEST_WEEKLY {
+				from = now.AddDate(0, 0, -7)
+			}
+		} else if now.Before(nextDigest(from, user.Digest, user.DigestHour)) {
+			continue
+		}
+		if err = self.sendDigest(user, from, now); err != nil {
+			logrus.Errorf("[digest.go::doDigest] sendDigest(%v): %v", user.Id, err)
+			continue
+		}
+		_, err = session.ID(user.Id).Cols("digest_time").Update(&models.TblUser{DigestTime: now})
+		if err != nil {
+			logrus.Errorf("[digest.go::doDigest] orm.Update: %v", err)
+			continue
+		}
+
+		//update cache
+		if v, exist := self.store.Get(fmt.Sprintf("%v.user", user.Id)); exist {
+			dupUser := *v.(*models.TblUser)
+			dupUser.DigestTime = now
+			self.store.Set(fmt.Sprintf("%v.user", user.Id), &dupUser, cache.NoExpiration)
+			self.store.Set(fmt.Sprintf("%v.suser", user.ShortId), &dupUser, cache.NoExpiration)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"net"
 	"regexp"
 	"strings"
@@ -28,6 +29,9 @@ import (
 		127.0.0.1
 	dig r.userXXXX.exmaple.com
 		127.0.0.2
+6. 自定义域名，添加并验证后同业务域名
+	oob.acme.com NS => ns.example.com
+	dig `whoami`.oob.acme.com
 
 TODO:
 1. 支持IPv6
@@ -56,6 +60,9 @@ type DnsServerConfig struct {
 	RTimeout, WTimeout time.Duration
 	V4, V6             net.IP
 	Fixed              []Resolve
+
+	//tracing of queries, disabled if nil
+	Tracer *Tracer
 }
 
 type DnsServer struct {
@@ -70,6 +77,9 @@ type DnsServer struct {
 	handler dns.Handler
 
 	fixed map[string][]Resolve
+
+	txtLock sync.RWMutex
+	txt     map[string][]string //dynamic TXT records, eg. acme challenge
 }
 
 func NewDnsServer(cfg *DnsServerConfig, store *cache.Cache) (*DnsServer, error) {
@@ -114,9 +124,11 @@ func NewDnsServer(cfg *DnsServerConfig, store *cache.Cache) (*DnsServer, error)
 			WriteTimeout: cfg.WTimeout,
 		},
 		fixed: fixed,
+		txt:   make(map[string][]string),
 	}
 	s.ipv4Regexp = regexp.MustCompile(ipv4Exp)
 	handler.HandleFunc(domain, s.Do)
+	handler.HandleFunc(".", s.doCustom)
 	return s, nil
 }
 
@@ -143,14 +155,8 @@ func (s *DnsServer) Shutdown() {
 }
 
 func (s *DnsServer) log(rcd *DnsRecord) {
-	s.wg.Add(1)
-
-	//async log
-	go func() {
-		defer s.wg.Done()
-		store := s.store
-		store.Input() <- rcd
-	}()
+	//wait a while if queue is full, dropped records are counted in queue stats
+	s.store.Push(rcd)
 }
 
 func (h *DnsServer) Do(w dns.ResponseWriter, req *dns.Msg) {
@@ -163,10 +169,21 @@ func (h *DnsServer) Do(w dns.ResponseWriter, req *dns.Msg) {
 		dns.HandleFailed(w, req)
 		return
 	}
+	metricDnsQueries.inc(dns.Type(q.Qtype).String())
+	_, span := h.Tracer.Start(context.Background(), "dns query", SPAN_SERVER)
+	defer span.End()
+	span.SetAttr("dns.qname", q.Name)
+	span.SetAttr("dns.qtype", dns.Type(q.Qtype).String())
+	span.SetAttr("net.peer.addr", w.RemoteAddr().String())
+
+	if q.Qtype == dns.TypeTXT && h.doTXT(w, req) {
+		return
+	}
 
 	//variables
 	var remoteIp net.IP
 	var uid int64
+	var callback string
 	var ttl uint32
 	var ip net.IP
 	var prefix, shortId string
@@ -194,18 +211,20 @@ func (h *DnsServer) Do(w dns.ResponseWriter, req *dns.Msg) {
 
 		if (t == dns.TypeA || t == dns.TypeAAAA) && ttl == LOG_TTL {
 			h.log(&DnsRecord{
-				Uid:    uid,
-				Domain: strings.TrimSuffix(q.Name, "."),
-				Var:    prefix,
-				Ctime:  time.Now(),
-				Ip:     remoteIp.String(),
+				Uid:      uid,
+				Callback: callback,
+				Domain:   strings.TrimSuffix(q.Name, "."),
+				Qtype:    dns.TypeToString[t],
+				Var:      prefix,
+				Ctime:    time.Now(),
+				Ip:       remoteIp.String(),
 			})
 		}
 		return
 	}
 
 	//r.u3yszl9nidbsx8p9.example.com.
-	prefix, shortId, isRebind := parseDomain(q.Name, h.Domain)
+	prefix, shortId, isRebind := parseUserDomain(store, q.Name, h.Domain)
 	if prefix == "" {
 		ttl = DEFAULT_TTL // improve performance
 	}
@@ -225,7 +244,12 @@ func (h *DnsServer) Do(w dns.ResponseWriter, req *dns.Msg) {
 	var user *models.TblUser
 	if exist {
 		user = v.(*models.TblUser)
+	} else if user, prefix = interactshUser(store, shortId); user != nil {
+		exist = true
+	}
+	if exist {
 		uid = user.Id
+		callback = user.Callback
 		ttl = LOG_TTL
 		ip = h.V4
 		if isRebind && len(user.Rebind) > 0 {
@@ -271,6 +295,32 @@ func (h *DnsServer) Do(w dns.ResponseWriter, req *dns.Msg) {
 	dns.HandleFailed(w, req)
 }
 
+// doCustom answer queries of custom domains of users, NS of a pending domain is answered for verification
+func (h *DnsServer) doCustom(w dns.ResponseWriter, req *dns.Msg) {
+	q := req.Question[0]
+	d, prefix := lookupCustomDomain(h.store, q.Name)
+	if d == nil || q.Qclass != dns.ClassINET {
+		dns.HandleFailed(w, req)
+		return
+	}
+	if prefix == "" && q.Qtype == dns.TypeNS {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Authoritative = true
+		m.Answer = append(m.Answer, &dns.NS{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: NS_TTL},
+			Ns:  dns.Fqdn("ns." + h.Domain),
+		})
+		w.WriteMsg(m)
+		return
+	}
+	if !d.Verified {
+		dns.HandleFailed(w, req)
+		return
+	}
+	h.Do(w, req)
+}
+
 func (self *DnsServer) Update(rr []Resolve) {
 	fixed := make(map[string][]Resolve)
 	for i := 0; i < len(rr); i++ {
@@ -285,3 +335,45 @@ func (self *DnsServer) Update(rr []Resolve) {
 	}
 	self.fixed = fixed
 }
+
+// SetTXT set dynamic TXT record of name
+func (self *DnsServer) SetTXT(name string, values ...string) {
+	name = strings.ToLower(dns.Fqdn(name))
+	self.txtLock.Lock()
+	self.txt[name] = values
+	self.txtLock.Unlock()
+}
+
+func (self *DnsServer) DelTXT(name string) {
+	name = strings.ToLower(dns.Fqdn(name))
+	self.txtLock.Lock()
+	delete(self.txt, name)
+	self.txtLock.Unlock()
+}
+
+func (self *DnsServer) doTXT(w dns.ResponseWriter, req *dns.Msg) bool {
+	q := req.Question[0]
+	self.txtLock.RLock()
+	values, exist := self.txt[strings.ToLower(q.Name)]
+	self.txtLock.RUnlock()
+	if !exist {
+		return false
+	}
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+	for _, v := range values {
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    LOG_TTL,
+			},
+			Txt: []string{v},
+		})
+	}
+	w.WriteMsg(m)
+	return true
+}
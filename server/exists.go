@@ -0,0 +1,93 @@
+package server
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"xorm.io/xorm"
+)
+
+/*
+Lightweight verdicts for scanners
+	curl http://${shortId}.godnslog.com/data/dns?q=token&count=true
+	curl -I http://${shortId}.godnslog.com/data/exists/dns?q=token
+exists answers 200 or 404 to HEAD, true or false to GET
+*/
+
+// countFiltered count filtered records of type, records filtered in go are scanned by ip and domain only
+func (self *WebServer) countFiltered(session *xorm.Session, filter *recordFilter, typ string) (int64, error) {
+	exp := exporters[typ]
+	if !filter.post {
+		return self.countHits(session, exp.bean())
+	}
+	var count int64
+	err := self.iterateHits(session, exp.bean(), true, 0, func(bean interface{}) error {
+		ip, domain, _ := exp.row(bean)
+		if filter.match(ip, domain) {
+			count++
+		}
+		return nil
+	}, "ip", exp.domain)
+	return count, err
+}
+
+func (self *WebServer) countRecord(c *gin.Context, session *xorm.Session, filter *recordFilter, typ string) {
+	count, err := self.countFiltered(session, filter, typ)
+	if err != nil {
+		logrus.Errorf("[exists.go::countRecord] orm.Count(%v): %v", typ, err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  count,
+	})
+}
+
+func isCountQuery(c *gin.Context) bool {
+	count, _ := strconv.ParseBool(c.Query("count"))
+	return count
+}
+
+// curl http://${shortId}.godnslog.com/data/exists/${type}?q=${q}
+func (self *WebServer) queryRecordExists(c *gin.Context) {
+	exp, ok := exporters[c.Param("type")]
+	q := c.Query("q")
+	if !ok || q == "" {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	//variable of http is /token
+	exist, err := self.existHit(session.Where(`uid=?`, c.GetInt64("uid")).In(`var`, q, "/"+q), exp.bean())
+	if err != nil {
+		logrus.Errorf("[exists.go::queryRecordExists] orm.Exist: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	if c.Request.Method == "HEAD" {
+		if exist {
+			c.Status(200)
+		} else {
+			c.Status(404)
+		}
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  exist,
+	})
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Export records for reports, filters are the same as data api
+	GET /api/data/export/dns?format=csv&from=2020-05-01T00:00:00Z&tag=confirmed
+	GET /api/data/export/http?format=xlsx&q=token
+records are streamed, newest first
+*/
+
+const (
+	MAX_EXPORT_ITEMS = 100000
+)
+
+// exporter is columns of record type
+type exporter struct {
+	domain string //column filtered by domain and re
+	bean   func() interface{}
+	header []string
+	row    func(bean interface{}) (ip, domain string, cells []string)
+}
+
+func exportTime(t time.Time) string {
+	return t.Format(time.RFC3339)
+}
+
+var exporters = map[string]exporter{
+	"dns": {"domain", func() interface{} { return new(models.TblDns) },
+		[]string{"id", "domain", "qtype", "addr", "hits", "var", "tags", "note", "ctime"},
+		func(bean interface{}) (string, string, []string) {
+			item := bean.(*models.TblDns)
+			return item.Ip, item.Domain, []string{strconv.FormatInt(item.Id, 10), item.Domain, item.Qtype,
+				item.Ip, strconv.FormatInt(item.Hits, 10), item.Var, strings.Join(item.Tags, ","), item.Note, exportTime(item.Ctime)}
+		}},
+	"http": {"host", func() interface{} { return new(models.TblHttp) },
+		[]string{"id", "method", "host", "path", "addr", "ua", "ctype", "data", "var", "tags", "note", "ctime"},
+		func(bean interface{}) (string, string, []string) {
+			item := bean.(*models.TblHttp)
+			return item.Ip, item.Host, []string{strconv.FormatInt(item.Id, 10), item.Method, item.Host, item.Path,
+				item.Ip, item.Ua, item.Ctype, item.Data, item.Var, strings.Join(item.Tags, ","), item.Note,
+				exportTime(item.Ctime)}
+		}},
+	"smtp": {"rcpt_to", func() interface{} { return new(models.TblSmtp) },
+		[]string{"id", "addr", "helo", "from", "to", "subject", "size", "var", "note", "ctime"},
+		func(bean interface{}) (string, string, []string) {
+			item := bean.(*models.TblSmtp)
+			return item.Ip, item.RcptTo, []string{strconv.FormatInt(item.Id, 10), item.Ip, item.Helo, item.MailFrom,
+				item.RcptTo, item.Subject, strconv.FormatInt(item.Size, 10), item.Var, item.Note,
+				exportTime(item.Ctime)}
+		}},
+	"ldap": {"dn", func() interface{} { return new(models.TblLdap) },
+		[]string{"id", "addr", "op", "dn", "password", "var", "note", "ctime"},
+		func(bean interface{}) (string, string, []string) {
+			item := bean.(*models.TblLdap)
+			return item.Ip, item.Dn, []string{strconv.FormatInt(item.Id, 10), item.Ip, item.Op, item.Dn,
+				item.Password, item.Var, item.Note, exportTime(item.Ctime)}
+		}},
+	"ftp": {"var", func() interface{} { return new(models.TblFtp) },
+		[]string{"id", "addr", "user", "password", "paths", "var", "note", "ctime"},
+		func(bean interface{}) (string, string, []string) {
+			item := bean.(*models.TblFtp)
+			return item.Ip, item.Var, []string{strconv.FormatInt(item.Id, 10), item.Ip, item.User, item.Password,
+				strings.Join(item.Paths, ","), item.Var, item.Note, exportTime(item.Ctime)}
+		}},
+	"tcp": {"var", func() interface{} { return new(models.TblTcp) },
+		[]string{"id", "addr", "port", "size", "data", "var", "note", "ctime"},
+		func(bean interface{}) (string, string, []string) {
+			item := bean.(*models.TblTcp)
+			return item.Ip, item.Var, []string{strconv.FormatInt(item.Id, 10), item.Ip, strconv.Itoa(item.Port),
+				strconv.FormatInt(item.Size, 10), item.Data, item.Var, item.Note, exportTime(item.Ctime)}
+		}},
+	"icmp": {"var", func() interface{} { return new(models.TblIcmp) },
+		[]string{"id", "addr", "echoId", "seq", "size", "data", "var", "note", "ctime"},
+		func(bean interface{}) (string, string, []string) {
+			item := bean.(*models.TblIcmp)
+			return item.Ip, item.Var, []string{strconv.FormatInt(item.Id, 10), item.Ip, strconv.Itoa(item.EchoId),
+				strconv.Itoa(item.Seq), strconv.FormatInt(item.Size, 10), item.Data, item.Var, item.Note,
+				exportTime(item.Ctime)}
+		}},
+	"smb": {"target", func() interface{} { return new(models.TblSmb) },
+		[]string{"id", "addr", "host", "domain", "user", "target", "os", "hash", "var", "note", "ctime"},
+		func(bean interface{}) (string, string, []string) {
+			item := bean.(*models.TblSmb)
+			return item.Ip, item.Target, []string{strconv.FormatInt(item.Id, 10), item.Ip, item.Host, item.Domain,
+				item.User, item.Target, item.Os, item.Hash, item.Var, item.Note, exportTime(item.Ctime)}
+		}},
+	"rmi": {"name", func() interface{} { return new(models.TblRmi) },
+		[]string{"id", "addr", "host", "name", "var", "note", "ctime"},
+		func(bean interface{}) (string, string, []string) {
+			item := bean.(*models.TblRmi)
+			return item.Ip, item.Name, []string{strconv.FormatInt(item.Id, 10), item.Ip, item.Host, item.Name,
+				item.Var, item.Note, exportTime(item.Ctime)}
+		}},
+}
+
+// exportWriter write rows of format
+type exportWriter interface {
+	Write(cells []string) error
+	Close() error
+}
+
+// csvWriter escape leading =+-@ of captured data, avoid formula injection in spreadsheet
+type csvWriter struct {
+	*csv.Writer
+}
+
+func (w *csvWriter) Write(cells []string) error {
+	for i, cell := range cells {
+		if cell != "" && strings.ContainsRune("=+-@", rune(cell[0])) {
+			cells[i] = "'" + cell
+		}
+	}
+	return w.Writer.Write(cells)
+}
+
+func (w *csvWriter) Close() error {
+	w.Flush()
+	return w.Error()
+}
+
+// jsonWriter write array of objects, keys are header
+type jsonWriter struct {
+	w      gin.ResponseWriter
+	header []string
+	n      int
+}
+
+func (w *jsonWriter) Write(cells []string) error {
+	if w.n == 0 { //header
+		w.header = cells
+		w.n++
+		_, err := w.w.WriteString("[")
+		return err
+	}
+	var buf bytes.Buffer
+	if w.n > 1 {
+		buf.WriteString(",")
+	}
+	w.n++
+	buf.WriteString("{")
+	for i, cell := range cells {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, _ := json.Marshal(w.header[i])
+		value, _ := json.Marshal(cell)
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+	_, err := w.w.Write(buf.Bytes())
+	return err
+}
+
+func (w *jsonWriter) Close() error {
+	_, err := w.w.WriteString("]")
+	return err
+}
+
+func (self *WebServer) exportRecord(c *gin.Context) {
+	typ := c.Param("type")
+	exp, ok := exporters[typ]
+	if !ok {
+		self.resp(c, 404, &CR{
+			Message: "No such record type",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" && format != "xlsx" {
+		self.resp(c, 400, &CR{
+			Message: "format should be csv, json or xlsx",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	filter, err := self.parseFilter(c)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.In("uid", 0, id)
+	default:
+		session = session.In("uid", self.teamUids(id, false))
+	}
+	if q := c.Query("q"); q != "" {
+		session = session.And(`var like ?`, "%"+q+"%")
+	}
+	if tag := c.Query("tag"); tag != "" && (typ == "dns" || typ == "http") {
+		session = tagCond(session, tag)
+	}
+	if filter.method != "" && typ == "http" {
+		session = session.And(`method = ?`, filter.method)
+	}
+	if filter.qtype != "" && typ == "dns" {
+		session = session.And(`qtype = ?`, filter.qtype)
+	}
+	session = filter.apply(session, exp.domain)
+	if typ == "http" {
+		session = session.Omit("body")
+	}
+
+	name := fmt.Sprintf("%v-%v.%v", typ, time.Now().Format("20060102150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, name))
+	var w exportWriter
+	switch format {
+	case "json":
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		w = &jsonWriter{w: c.Writer}
+	case "xlsx":
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w = newXlsxWriter(c.Writer, typ)
+	default:
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w = &csvWriter{csv.NewWriter(c.Writer)}
+	}
+	c.Status(200)
+
+	if err = w.Write(append([]string{}, exp.header...)); err != nil {
+		return
+	}
+	var werr error
+	err = self.iterateHits(session, exp.bean(), false, MAX_EXPORT_ITEMS, func(bean interface{}) error {
+		ip, domain, cells := exp.row(bean)
+		if filter.post && !filter.match(ip, domain) {
+			return nil
+		}
+		werr = w.Write(cells)
+		return werr
+	})
+	if werr != nil {
+		return
+	} else if err != nil {
+		logrus.Errorf("[export.go::exportRecord] orm.Iterate: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		logrus.Infof("[export.go::exportRecord] Close: %v", err)
+	}
+}
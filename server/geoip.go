@@ -0,0 +1,177 @@
+package server
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+GeoIP enrichment of source addresses by MaxMind GeoLite2 databases
+	godnslog serve -geoip-city GeoLite2-City.mmdb -geoip-asn GeoLite2-ASN.mmdb
+country, city and asn are saved with dns and http records,
+databases updated by geoipupdate are reloaded, files are checked every -geoip-reload
+*/
+
+const (
+	DEFAULT_GEOIP_RELOAD = time.Hour
+)
+
+type GeoIPConfig struct {
+	CityPath string //GeoLite2-City or GeoLite2-Country, option
+	AsnPath  string //GeoLite2-ASN, option
+	Reload   time.Duration
+}
+
+type geoDB struct {
+	path  string
+	mtime time.Time
+	db    *maxminddb.Reader
+}
+
+// GeoIP lookup location of ip, databases are reloaded when files are modified
+type GeoIP struct {
+	GeoIPConfig
+
+	lock sync.RWMutex
+	city geoDB
+	asn  geoDB
+
+	quit chan struct{}
+}
+
+type geoCityRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+}
+
+type geoAsnRecord struct {
+	Number uint   `maxminddb:"autonomous_system_number"`
+	Org    string `maxminddb:"autonomous_system_organization"`
+}
+
+func NewGeoIP(cfg *GeoIPConfig) (*GeoIP, error) {
+	g := &GeoIP{
+		GeoIPConfig: *cfg,
+		city:        geoDB{path: cfg.CityPath},
+		asn:         geoDB{path: cfg.AsnPath},
+		quit:        make(chan struct{}),
+	}
+	if g.Reload <= 0 {
+		g.Reload = DEFAULT_GEOIP_RELOAD
+	}
+	for _, d := range []*geoDB{&g.city, &g.asn} {
+		if _, err := g.load(d); err != nil {
+			g.close()
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// load open database of d if file is modified, true if reloaded
+func (g *GeoIP) load(d *geoDB) (bool, error) {
+	if d.path == "" {
+		return false, nil
+	}
+	st, err := os.Stat(d.path)
+	if err != nil {
+		return false, err
+	} else if st.ModTime().Equal(d.mtime) {
+		return false, nil
+	}
+	db, err := maxminddb.Open(d.path)
+	if err != nil {
+		return false, err
+	}
+
+	g.lock.Lock()
+	old := d.db
+	d.db = db
+	d.mtime = st.ModTime()
+	g.lock.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return true, nil
+}
+
+func (g *GeoIP) close() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	for _, d := range []*geoDB{&g.city, &g.asn} {
+		if d.db != nil {
+			d.db.Close()
+			d.db = nil
+		}
+	}
+}
+
+// Lookup location of ip, empty if not found or g is nil
+func (g *GeoIP) Lookup(ip string) models.Geo {
+	var geo models.Geo
+	addr := net.ParseIP(ip)
+	if g == nil || addr == nil {
+		return geo
+	}
+
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	if g.city.db != nil {
+		var r geoCityRecord
+		if err := g.city.db.Lookup(addr, &r); err == nil {
+			geo.Country = r.Country.IsoCode
+			if geo.Country == "" {
+				geo.Country = r.RegisteredCountry.IsoCode
+			}
+			geo.City = r.City.Names["en"]
+		}
+	}
+	if g.asn.db != nil {
+		var r geoAsnRecord
+		if err := g.asn.db.Lookup(addr, &r); err == nil {
+			geo.Asn = int64(r.Number)
+			geo.AsOrg = r.Org
+		}
+	}
+	return geo
+}
+
+// Run reload modified databases until Shutdown
+func (g *GeoIP) Run() {
+	ticker := time.NewTicker(g.Reload)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-g.quit:
+			g.close()
+			return
+		}
+		for _, d := range []*geoDB{&g.city, &g.asn} {
+			reloaded, err := g.load(d)
+			if err != nil {
+				logrus.Errorf("[geoip.go::Run] load(%v): %v", d.path, err)
+			} else if reloaded {
+				logrus.Infof("[geoip.go::Run] reload %v", d.path)
+			}
+		}
+	}
+}
+
+func (g *GeoIP) Shutdown() {
+	close(g.quit)
+}
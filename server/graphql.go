@@ -0,0 +1,419 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+GraphQL query of users, records and settings, read only, eg.
+	POST /api/graphql
+	{"query": "{ http(q: \"/token\", limit: 10) { id path addr tags ctime user { name email } } }"}
+normal users only see themselves, admins can query any user by uid
+*/
+
+type graphqlUserKey struct{}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// graphqlViewer is user of request
+type graphqlViewer struct {
+	id   int64
+	role int
+	team []int64 //users of teams, viewer included
+}
+
+func (v *graphqlViewer) canSee(uid int64) bool {
+	if v.role == roleAdmin || v.role == roleSuper {
+		return true
+	}
+	for _, member := range v.team {
+		if member == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func graphqlViewerOf(ctx context.Context) *graphqlViewer {
+	return ctx.Value(graphqlUserKey{}).(*graphqlViewer)
+}
+
+// record arguments, as data api
+var graphqlRecordArgs = graphql.FieldConfigArgument{
+	"q":       &graphql.ArgumentConfig{Type: graphql.String},
+	"blur":    &graphql.ArgumentConfig{Type: graphql.Boolean},
+	"tag":     &graphql.ArgumentConfig{Type: graphql.String},
+	"from":    &graphql.ArgumentConfig{Type: graphql.String},
+	"to":      &graphql.ArgumentConfig{Type: graphql.String},
+	"ip":      &graphql.ArgumentConfig{Type: graphql.String},
+	"domain":  &graphql.ArgumentConfig{Type: graphql.String},
+	"re":      &graphql.ArgumentConfig{Type: graphql.String},
+	"qtype":   &graphql.ArgumentConfig{Type: graphql.String},
+	"method":  &graphql.ArgumentConfig{Type: graphql.String},
+	"afterId": &graphql.ArgumentConfig{Type: graphql.Int},
+	"limit":   &graphql.ArgumentConfig{Type: graphql.Int},
+}
+
+// graphqlUser get user by id, nil if not visible
+func (self *WebServer) graphqlUser(ctx context.Context, uid int64) (map[string]interface{}, error) {
+	if !graphqlViewerOf(ctx).canSee(uid) {
+		return nil, nil
+	}
+	if v, exist := self.store.Get(fmt.Sprintf("%v.user", uid)); exist {
+		return graphqlUserMap(v.(*models.TblUser)), nil
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	user := new(models.TblUser)
+	exist, err := session.ID(uid).Get(user)
+	if err != nil {
+		logrus.Errorf("[graphql.go::graphqlUser] orm.Get: %v", err)
+		return nil, fmt.Errorf("failed")
+	} else if !exist {
+		return nil, nil
+	}
+	return graphqlUserMap(user), nil
+}
+
+func graphqlUserMap(user *models.TblUser) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      user.Id,
+		"name":    user.Name,
+		"email":   user.Email,
+		"role":    user.Role,
+		"shortId": user.ShortId,
+		"user":    user, //not exported
+	}
+}
+
+// graphqlRecords query records of type
+func (self *WebServer) graphqlRecords(p graphql.ResolveParams, uid int64, typ string) (interface{}, error) {
+	if !graphqlViewerOf(p.Context).canSee(uid) {
+		return nil, fmt.Errorf("permission denied")
+	}
+	query := &recordQuery{
+		uid:    uid,
+		params: make(map[string]string),
+	}
+	for key, value := range p.Args {
+		switch key {
+		case "q":
+			query.q = value.(string)
+		case "blur":
+			query.blur = value.(bool)
+		case "tag":
+			query.tag = value.(string)
+		case "afterId":
+			query.afterId = int64(value.(int))
+		case "limit":
+			query.limit = value.(int)
+		default:
+			query.params[key] = value.(string)
+		}
+	}
+	cur, err := self.queryCursor(query)
+	if err != nil {
+		return nil, err
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	session, filter, err := self.recordSession(session, typ, query)
+	if err != nil {
+		return nil, err
+	}
+	beans, _, err := self.scanRecords(session, filter, typ, cur)
+	if err != nil {
+		logrus.Errorf("[graphql.go::graphqlRecords] scanRecords(%v): %v", typ, err)
+		return nil, fmt.Errorf("failed")
+	}
+	items := make([]map[string]interface{}, 0, len(beans))
+	for _, bean := range beans {
+		var item map[string]interface{}
+		switch rcd := bean.(type) {
+		case *models.TblDns:
+			item = map[string]interface{}{
+				"id":      rcd.Id,
+				"uid":     rcd.Uid,
+				"domain":  rcd.Domain,
+				"addr":    rcd.Ip,
+				"qtype":   rcd.Qtype,
+				"hits":    rcd.Hits,
+				"var":     rcd.Var,
+				"tags":    rcd.Tags,
+				"note":    rcd.Note,
+				"ctime":   rcd.Ctime,
+				"ptr":     rcd.Ptr,
+				"scanner": rcd.Scanner,
+				"geo":     graphqlGeo(&rcd.Geo),
+			}
+		case *models.TblHttp:
+			item = map[string]interface{}{
+				"id":         rcd.Id,
+				"uid":        rcd.Uid,
+				"host":       rcd.Host,
+				"path":       rcd.Path,
+				"addr":       rcd.Ip,
+				"method":     rcd.Method,
+				"proto":      rcd.Proto,
+				"data":       rcd.Data,
+				"ctype":      rcd.Ctype,
+				"ua":         rcd.Ua,
+				"size":       rcd.Size,
+				"truncated":  rcd.Truncated,
+				"sni":        rcd.Sni,
+				"tlsVersion": rcd.TlsVersion,
+				"tlsCipher":  rcd.TlsCipher,
+				"ja3Hash":    rcd.Ja3Hash,
+				"var":        rcd.Var,
+				"tags":       rcd.Tags,
+				"note":       rcd.Note,
+				"ctime":      rcd.Ctime,
+				"ptr":        rcd.Ptr,
+				"scanner":    rcd.Scanner,
+				"geo":        graphqlGeo(&rcd.Geo),
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func graphqlGeo(geo *models.Geo) map[string]interface{} {
+	return map[string]interface{}{
+		"country": geo.Country,
+		"city":    geo.City,
+		"asn":     geo.Asn,
+		"asOrg":   geo.AsOrg,
+	}
+}
+
+func (self *WebServer) newGraphqlSchema() (graphql.Schema, error) {
+	settingsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Settings",
+		Fields: graphql.Fields{
+			"callback":    &graphql.Field{Type: graphql.String},
+			"cleanHour":   &graphql.Field{Type: graphql.Int},
+			"rebind":      &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"maxBodySize": &graphql.Field{Type: graphql.Int},
+			"dnsAddr":     &graphql.Field{Type: graphql.String},
+			"httpAddr":    &graphql.Field{Type: graphql.String},
+		},
+	})
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":      &graphql.Field{Type: graphql.Int},
+			"name":    &graphql.Field{Type: graphql.String},
+			"email":   &graphql.Field{Type: graphql.String},
+			"role":    &graphql.Field{Type: graphql.Int},
+			"shortId": &graphql.Field{Type: graphql.String},
+		},
+	})
+	//user of record
+	recordUser := &graphql.Field{
+		Type: userType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return self.graphqlUser(p.Context, p.Source.(map[string]interface{})["uid"].(int64))
+		},
+	}
+	geoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Geo",
+		Fields: graphql.Fields{
+			"country": &graphql.Field{Type: graphql.String},
+			"city":    &graphql.Field{Type: graphql.String},
+			"asn":     &graphql.Field{Type: graphql.Int},
+			"asOrg":   &graphql.Field{Type: graphql.String},
+		},
+	})
+	dnsType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Dns",
+		Fields: graphql.Fields{
+			"id":      &graphql.Field{Type: graphql.Int},
+			"domain":  &graphql.Field{Type: graphql.String},
+			"addr":    &graphql.Field{Type: graphql.String},
+			"qtype":   &graphql.Field{Type: graphql.String},
+			"hits":    &graphql.Field{Type: graphql.Int},
+			"var":     &graphql.Field{Type: graphql.String},
+			"tags":    &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"note":    &graphql.Field{Type: graphql.String},
+			"ctime":   &graphql.Field{Type: graphql.DateTime},
+			"ptr":     &graphql.Field{Type: graphql.String},
+			"scanner": &graphql.Field{Type: graphql.String},
+			"geo":     &graphql.Field{Type: geoType},
+			"user":    recordUser,
+		},
+	})
+	httpType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Http",
+		Fields: graphql.Fields{
+			"id":         &graphql.Field{Type: graphql.Int},
+			"host":       &graphql.Field{Type: graphql.String},
+			"path":       &graphql.Field{Type: graphql.String},
+			"addr":       &graphql.Field{Type: graphql.String},
+			"method":     &graphql.Field{Type: graphql.String},
+			"proto":      &graphql.Field{Type: graphql.String},
+			"data":       &graphql.Field{Type: graphql.String},
+			"ctype":      &graphql.Field{Type: graphql.String},
+			"ua":         &graphql.Field{Type: graphql.String},
+			"size":       &graphql.Field{Type: graphql.Int},
+			"truncated":  &graphql.Field{Type: graphql.Boolean},
+			"sni":        &graphql.Field{Type: graphql.String},
+			"tlsVersion": &graphql.Field{Type: graphql.String},
+			"tlsCipher":  &graphql.Field{Type: graphql.String},
+			"ja3Hash":    &graphql.Field{Type: graphql.String},
+			"var":        &graphql.Field{Type: graphql.String},
+			"tags":       &graphql.Field{Type: graphql.NewList(graphql.String)},
+			"note":       &graphql.Field{Type: graphql.String},
+			"ctime":      &graphql.Field{Type: graphql.DateTime},
+			"ptr":        &graphql.Field{Type: graphql.String},
+			"scanner":    &graphql.Field{Type: graphql.String},
+			"geo":        &graphql.Field{Type: geoType},
+			"user":       recordUser,
+		},
+	})
+	userType.AddFieldConfig("settings", &graphql.Field{
+		Type: settingsType,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			user := p.Source.(map[string]interface{})["user"].(*models.TblUser)
+			return map[string]interface{}{
+				"callback":    user.Callback,
+				"cleanHour":   user.CleanInterval / 3600,
+				"rebind":      user.Rebind,
+				"maxBodySize": self.maxBodySize(user),
+				"dnsAddr":     user.ShortId + "." + self.Domain,
+				"httpAddr":    fmt.Sprintf("http://%v/log/%v/", self.IP, user.ShortId),
+			}, nil
+		},
+	})
+	userType.AddFieldConfig("dns", &graphql.Field{
+		Type: graphql.NewList(dnsType),
+		Args: graphqlRecordArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return self.graphqlRecords(p, p.Source.(map[string]interface{})["id"].(int64), "dns")
+		},
+	})
+	userType.AddFieldConfig("http", &graphql.Field{
+		Type: graphql.NewList(httpType),
+		Args: graphqlRecordArgs,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return self.graphqlRecords(p, p.Source.(map[string]interface{})["id"].(int64), "http")
+		},
+	})
+
+	//records of viewer, or user of uid for admins
+	recordArgs := graphql.FieldConfigArgument{"uid": &graphql.ArgumentConfig{Type: graphql.Int}}
+	for key, arg := range graphqlRecordArgs {
+		recordArgs[key] = arg
+	}
+	recordUid := func(p graphql.ResolveParams) int64 {
+		if uid, ok := p.Args["uid"].(int); ok {
+			return int64(uid)
+		}
+		return graphqlViewerOf(p.Context).id
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": &graphql.Field{
+				Type: userType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return self.graphqlUser(p.Context, graphqlViewerOf(p.Context).id)
+				},
+			},
+			"user": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return self.graphqlUser(p.Context, int64(p.Args["id"].(int)))
+				},
+			},
+			"users": &graphql.Field{
+				Type: graphql.NewList(userType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					viewer := graphqlViewerOf(p.Context)
+					session := self.orm.NewSession()
+					defer session.Close()
+
+					var users []models.TblUser
+					if viewer.role != roleAdmin && viewer.role != roleSuper {
+						session = session.Where(`id=?`, viewer.id)
+					}
+					if err := session.Asc("id").Find(&users); err != nil {
+						logrus.Errorf("[graphql.go::users] orm.Find: %v", err)
+						return nil, fmt.Errorf("failed")
+					}
+					items := make([]map[string]interface{}, len(users))
+					for i := 0; i < len(users); i++ {
+						items[i] = graphqlUserMap(&users[i])
+					}
+					return items, nil
+				},
+			},
+			"dns": &graphql.Field{
+				Type: graphql.NewList(dnsType),
+				Args: recordArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return self.graphqlRecords(p, recordUid(p), "dns")
+				},
+			},
+			"http": &graphql.Field{
+				Type: graphql.NewList(httpType),
+				Args: recordArgs,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return self.graphqlRecords(p, recordUid(p), "http")
+				},
+			},
+		},
+	})
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query})
+}
+
+// POST /api/graphql, GET /api/graphql?query=
+func (self *WebServer) graphql(c *gin.Context) {
+	var req graphqlRequest
+	if c.Request.Method == "GET" {
+		req.Query = c.Query("query")
+		req.OperationName = c.Query("operationName")
+		if s := c.Query("variables"); s != "" {
+			if err := json.Unmarshal([]byte(s), &req.Variables); err != nil {
+				c.JSON(400, gin.H{"errors": []gin.H{{"message": "invalid variables"}}})
+				return
+			}
+		}
+	} else if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"errors": []gin.H{{"message": "invalid request"}}})
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), graphqlUserKey{}, &graphqlViewer{
+		id:   c.GetInt64("id"),
+		role: c.GetInt("role"),
+		team: self.teamUids(c.GetInt64("id"), false),
+	})
+	result := graphql.Do(graphql.Params{
+		Schema:         self.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	})
+	c.JSON(200, result)
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/chennqqi/godnslog/rpc"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+/*
+gRPC api mirrors data api, service is defined in rpc/godnslog.proto, enabled by
+	godnslog serve -grpc :9090 ...
+token of user is passed by metadata, eg.
+	authorization: ${token}
+*/
+
+type grpcUidKey struct{}
+
+type grpcServer struct {
+	rpc.UnimplementedGoDnsLogServer
+	web *WebServer
+}
+
+// grpcAuth put uid of token in context, scopes of token are checked for method
+func (self *WebServer) grpcAuth(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get("authorization")
+	if len(tokens) == 0 || tokens[0] == "" {
+		return nil, status.Error(codes.Unauthenticated, "token required")
+	}
+	user, scopes, err := self.tokenUser(tokens[0])
+	if err != nil {
+		logrus.Errorf("[grpc.go::grpcAuth] tokenUser: %v", err)
+		return nil, status.Error(codes.Internal, "failed")
+	} else if user == nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	required, exist := grpcScopes[method]
+	if !exist {
+		required = readScopes
+	}
+	if !hasScopes(scopes, required...) {
+		return nil, status.Error(codes.PermissionDenied, "scope required")
+	}
+	return context.WithValue(ctx, grpcUidKey{}, user.Id), nil
+}
+
+type grpcAuthStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcAuthStream) Context() context.Context {
+	return s.ctx
+}
+
+func (self *WebServer) newGrpcServer() *grpc.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := self.grpcAuth(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := self.grpcAuth(ss.Context(), info.FullMethod)
+			if err != nil {
+				return err
+			}
+			return handler(srv, &grpcAuthStream{ss, ctx})
+		}),
+	)
+	rpc.RegisterGoDnsLogServer(s, &grpcServer{web: self})
+	return s
+}
+
+func grpcUid(ctx context.Context) int64 {
+	uid, _ := ctx.Value(grpcUidKey{}).(int64)
+	return uid
+}
+
+func grpcEvent(event *models.SessionEvent) *rpc.Event {
+	data, _ := json.Marshal(event.Data)
+	return &rpc.Event{
+		Type:  event.Type,
+		Id:    event.Id,
+		Var:   event.Var,
+		Addr:  event.Ip,
+		Ctime: timestamppb.New(event.Ctime),
+		Data:  data,
+	}
+}
+
+// grpcTypes is types of request, validated
+func grpcTypes(types []string) (map[string]bool, error) {
+	m := make(map[string]bool)
+	for _, typ := range types {
+		if _, exist := exporters[typ]; !exist {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid type: %v", typ)
+		}
+		m[typ] = true
+	}
+	return m, nil
+}
+
+// recordQuery of request, as data api q is required
+func grpcQuery(ctx context.Context, req *rpc.QueryRequest) (*recordQuery, error) {
+	if req.Q == "" {
+		return nil, status.Error(codes.InvalidArgument, "q required")
+	}
+	return &recordQuery{
+		uid:  grpcUid(ctx),
+		q:    req.Q,
+		blur: req.Blur,
+		tag:  req.Tag,
+		params: map[string]string{
+			"from":   req.From,
+			"to":     req.To,
+			"ip":     req.Ip,
+			"domain": req.Domain,
+			"re":     req.Re,
+			"qtype":  req.Qtype,
+			"method": req.Method,
+		},
+		afterId: req.AfterId,
+		limit:   int(req.Limit),
+	}, nil
+}
+
+func (s *grpcServer) find(ctx context.Context, req *rpc.QueryRequest, typ string) ([]interface{}, int64, error) {
+	query, err := grpcQuery(ctx, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	cur, err := s.web.queryCursor(query)
+	if err != nil {
+		return nil, 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	session := s.web.orm.NewSession()
+	defer session.Close()
+
+	session, filter, err := s.web.recordSession(session, typ, query)
+	if err != nil {
+		return nil, 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+	beans, nextId, err := s.web.scanRecords(session, filter, typ, cur)
+	if err != nil {
+		logrus.Errorf("[grpc.go::find] scanRecords(%v): %v", typ, err)
+		return nil, 0, status.Error(codes.Internal, "failed")
+	}
+	return beans, nextId, nil
+}
+
+func (s *grpcServer) QueryDns(ctx context.Context, req *rpc.QueryRequest) (*rpc.DnsRecords, error) {
+	beans, nextId, err := s.find(ctx, req, "dns")
+	if err != nil {
+		return nil, err
+	}
+	resp := &rpc.DnsRecords{NextId: nextId}
+	for _, bean := range beans {
+		item := bean.(*models.TblDns)
+		resp.Items = append(resp.Items, &rpc.DnsRecord{
+			Id:     item.Id,
+			Domain: item.Domain,
+			Addr:   item.Ip,
+			Qtype:  item.Qtype,
+			Tags:   item.Tags,
+			Note:   item.Note,
+			Ctime:  timestamppb.New(item.Ctime),
+		})
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) QueryHttp(ctx context.Context, req *rpc.QueryRequest) (*rpc.HttpRecords, error) {
+	beans, nextId, err := s.find(ctx, req, "http")
+	if err != nil {
+		return nil, err
+	}
+	resp := &rpc.HttpRecords{NextId: nextId}
+	for _, bean := range beans {
+		item := bean.(*models.TblHttp)
+		resp.Items = append(resp.Items, &rpc.HttpRecord{
+			Id:         item.Id,
+			Host:       item.Host,
+			Path:       item.Path,
+			Addr:       item.Ip,
+			Method:     item.Method,
+			Proto:      item.Proto,
+			Data:       item.Data,
+			Ctype:      item.Ctype,
+			Ua:         item.Ua,
+			Size:       item.Size,
+			Truncated:  item.Truncated,
+			Sni:        item.Sni,
+			TlsVersion: item.TlsVersion,
+			TlsCipher:  item.TlsCipher,
+			Ja3Hash:    item.Ja3Hash,
+			Tags:       item.Tags,
+			Note:       item.Note,
+			Ctime:      timestamppb.New(item.Ctime),
+		})
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) Count(ctx context.Context, req *rpc.QueryRequest) (*rpc.CountResponse, error) {
+	if req.Type != "dns" && req.Type != "http" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid type: %v", req.Type)
+	}
+	query, err := grpcQuery(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	session := s.web.orm.NewSession()
+	defer session.Close()
+
+	session, filter, err := s.web.recordSession(session, req.Type, query)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	count, err := s.web.countFiltered(session, filter, req.Type)
+	if err != nil {
+		logrus.Errorf("[grpc.go::Count] countFiltered(%v): %v", req.Type, err)
+		return nil, status.Error(codes.Internal, "failed")
+	}
+	return &rpc.CountResponse{Count: count}, nil
+}
+
+func (s *grpcServer) QuerySession(ctx context.Context, req *rpc.SessionRequest) (*rpc.Events, error) {
+	result, err := s.web.querySession([]int64{grpcUid(ctx)}, req.Token, false)
+	if err != nil {
+		logrus.Errorf("[grpc.go::QuerySession] querySession: %v", err)
+		return nil, status.Error(codes.Internal, "failed")
+	}
+	resp := &rpc.Events{}
+	for i := range result.Events {
+		resp.Items = append(resp.Items, grpcEvent(&result.Events[i]))
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) Wait(ctx context.Context, req *rpc.WaitRequest) (*rpc.WaitResponse, error) {
+	if req.Q == "" || req.Timeout < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid q or timeout")
+	}
+	types := req.Types
+	if len(types) == 0 {
+		types = []string{"dns", "http"}
+	}
+	sub := &realtimeSub{uids: []int64{grpcUid(ctx)}, token: req.Q}
+	var err error
+	if sub.types, err = grpcTypes(types); err != nil {
+		return nil, err
+	}
+	timeout := DEFAULT_WAIT_TIMEOUT
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+	if timeout > MAX_WAIT_TIMEOUT {
+		timeout = MAX_WAIT_TIMEOUT
+	}
+
+	event, err := s.web.waitHit(ctx, grpcUid(ctx), sub, timeout)
+	if err == context.Canceled {
+		return nil, status.Error(codes.Canceled, err.Error())
+	} else if err == context.DeadlineExceeded {
+		return nil, status.Error(codes.DeadlineExceeded, err.Error())
+	} else if err != nil {
+		logrus.Errorf("[grpc.go::Wait] waitHit: %v", err)
+		return nil, status.Error(codes.Internal, "failed")
+	} else if event == nil {
+		return &rpc.WaitResponse{Timeout: true}, nil
+	}
+	return &rpc.WaitResponse{Event: grpcEvent(event)}, nil
+}
+
+func (s *grpcServer) Watch(req *rpc.WatchRequest, stream rpc.GoDnsLog_WatchServer) error {
+	ctx := stream.Context()
+	sub := &realtimeSub{uids: []int64{grpcUid(ctx)}, token: req.Q}
+	var err error
+	if sub.types, err = grpcTypes(req.Types); err != nil {
+		return err
+	}
+	ch := s.web.realtime.subscribe(sub)
+	defer s.web.realtime.unsubscribe(ch)
+
+	for {
+		select {
+		case event := <-ch:
+			if err = stream.Send(grpcEvent(&event)); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"reflect"
+
+	"github.com/chennqqi/godnslog/models"
+	"xorm.io/builder"
+	"xorm.io/xorm"
+)
+
+/*
+Storage of dns and http hits by Store, sql by default or clickhouse for high volume deployments
+	godnslog serve -clickhouse http://default:@127.0.0.1:8123/godnslog
+users, settings and other records are kept in sql. handlers build conditions by xorm sessions,
+conditions of hits are passed to Store, other records are queried by the session.
+tags, notes, dedup, ptr, search and stats are not supported of hits in clickhouse
+*/
+
+// inClickHouse is true if records of typ are stored in clickhouse
+func (self *WebServer) inClickHouse(typ string) bool {
+	return self.ClickHouse != nil && (typ == "dns" || typ == "http")
+}
+
+func isHit(bean interface{}) bool {
+	switch bean.(type) {
+	case *models.TblDns, *models.TblHttp, *[]models.TblDns, *[]models.TblHttp:
+		return true
+	}
+	return false
+}
+
+// hitConds take conditions of session and reset it for next query
+func hitConds(session *xorm.Session) builder.Cond {
+	cond := session.Conds()
+	//Insert of no bean resets statement without query
+	session.Insert()
+	return cond
+}
+
+// hitQuery take conditions of session as query of hits
+func hitQuery(session *xorm.Session, asc bool, limit, offset int, cols ...string) *HitQuery {
+	return &HitQuery{
+		Cond:   hitConds(session),
+		Cols:   cols,
+		Asc:    asc,
+		Limit:  limit,
+		Offset: offset,
+	}
+}
+
+// insertHit insert record, dns and http records are inserted to Store, id of bean is set
+func (self *WebServer) insertHit(session *xorm.Session, bean interface{}) error {
+	switch item := bean.(type) {
+	case *models.TblDns:
+		return self.Store.InsertDNS(item)
+	case *models.TblHttp:
+		return self.Store.InsertHTTP(item)
+	}
+	_, err := session.InsertOne(bean)
+	return err
+}
+
+// findHits find records of session conditions ordered by id, limit 0 is unlimited
+func (self *WebServer) findHits(session *xorm.Session, rowsSlicePtr interface{}, asc bool, limit, offset int) error {
+	if !isHit(rowsSlicePtr) {
+		if asc {
+			session = session.Asc("id")
+		} else {
+			session = session.Desc("id")
+		}
+		if limit > 0 {
+			session = session.Limit(limit, offset)
+		}
+		return session.Find(rowsSlicePtr)
+	}
+	return self.Store.Query(rowsSlicePtr, hitQuery(session, asc, limit, offset))
+}
+
+// iterateHits iterate records of session conditions ordered by id, limit 0 is unlimited, all columns if cols is empty
+func (self *WebServer) iterateHits(session *xorm.Session, bean interface{}, asc bool, limit int, fn func(bean interface{}) error, cols ...string) error {
+	if !isHit(bean) {
+		if len(cols) > 0 {
+			session = session.Cols(cols...)
+		}
+		if asc {
+			session = session.Asc("id")
+		} else {
+			session = session.Desc("id")
+		}
+		if limit > 0 {
+			session = session.Limit(limit)
+		}
+		return session.Iterate(bean, func(idx int, bean interface{}) error {
+			return fn(bean)
+		})
+	}
+	return self.Store.Iterate(bean, hitQuery(session, asc, limit, 0, cols...), fn)
+}
+
+// findAndCountHits find a page of records by id desc and count all of them
+func (self *WebServer) findAndCountHits(session *xorm.Session, rowsSlicePtr interface{}, limit, offset int) (int64, error) {
+	if !isHit(rowsSlicePtr) {
+		return session.Desc("id").Limit(limit, offset).FindAndCount(rowsSlicePtr)
+	}
+	q := hitQuery(session, false, limit, offset)
+	bean := reflect.New(reflect.TypeOf(rowsSlicePtr).Elem().Elem()).Interface()
+	count, err := self.Store.Count(bean, q.Cond)
+	if err != nil {
+		return 0, err
+	}
+	return count, self.Store.Query(rowsSlicePtr, q)
+}
+
+func (self *WebServer) countHits(session *xorm.Session, bean interface{}) (int64, error) {
+	if !isHit(bean) {
+		return session.Count(bean)
+	}
+	return self.Store.Count(bean, hitConds(session))
+}
+
+func (self *WebServer) existHit(session *xorm.Session, bean interface{}) (bool, error) {
+	if !isHit(bean) {
+		return session.Exist(bean)
+	}
+	count, err := self.countHits(session, bean)
+	return count > 0, err
+}
+
+// getHit get last record of session conditions into bean
+func (self *WebServer) getHit(session *xorm.Session, bean interface{}) (bool, error) {
+	if !isHit(bean) {
+		return session.Get(bean)
+	}
+	var found bool
+	var err error
+	switch item := bean.(type) {
+	case *models.TblDns:
+		var items []models.TblDns
+		err = self.Store.Query(&items, hitQuery(session, false, 1, 0))
+		if found = len(items) > 0; found {
+			*item = items[0]
+		}
+	case *models.TblHttp:
+		var items []models.TblHttp
+		err = self.Store.Query(&items, hitQuery(session, false, 1, 0))
+		if found = len(items) > 0; found {
+			*item = items[0]
+		}
+	}
+	return found, err
+}
+
+// deleteHits delete records of session conditions, count of deleted records is returned
+func (self *WebServer) deleteHits(session *xorm.Session, bean interface{}) (int64, error) {
+	if !isHit(bean) {
+		return session.Delete(bean)
+	}
+	return self.Store.Clean(bean, hitConds(session))
+}
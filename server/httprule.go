@@ -0,0 +1,351 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	MAX_RULE_DELAY = 60 * 1000 //milliseconds
+)
+
+type httpRule struct {
+	models.TblHttpRule
+	path   *regexp.Regexp
+	header *regexp.Regexp
+}
+
+func compileHttpRule(rule *models.TblHttpRule) (*httpRule, error) {
+	r := &httpRule{TblHttpRule: *rule}
+	if rule.Path != "" {
+		exp, err := regexp.Compile(rule.Path)
+		if err != nil {
+			return nil, fmt.Errorf("path: %v", err)
+		}
+		r.path = exp
+	}
+	if rule.HeaderRe != "" {
+		exp, err := regexp.Compile(rule.HeaderRe)
+		if err != nil {
+			return nil, fmt.Errorf("headerRe: %v", err)
+		}
+		r.header = exp
+	}
+	return r, nil
+}
+
+func (r *httpRule) Match(method, path string, header http.Header) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+	if r.path != nil && !r.path.MatchString(path) {
+		return false
+	}
+	if r.Header != "" {
+		values, exist := header[http.CanonicalHeaderKey(r.Header)]
+		if !exist {
+			return false
+		}
+		if r.header != nil {
+			for _, v := range values {
+				if r.header.MatchString(v) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// deniedRuleHeader is true of headers reaching beyond the log host, cookies of parent domain and cors
+func deniedRuleHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	return name == "Set-Cookie" || strings.HasPrefix(name, "Access-Control-")
+}
+
+func validHttpRule(rule *HttpRule) error {
+	if rule.Status < 100 || rule.Status > 599 {
+		return fmt.Errorf("status should be in [100, 599]")
+	}
+	if rule.Delay < 0 || rule.Delay > MAX_RULE_DELAY {
+		return fmt.Errorf("delay should be in [0, %v]", MAX_RULE_DELAY)
+	}
+	if rule.HeaderRe != "" && rule.Header == "" {
+		return fmt.Errorf("header required with headerRe")
+	}
+	for k := range rule.Headers {
+		if deniedRuleHeader(k) {
+			return fmt.Errorf("header %v not allowed", k)
+		}
+	}
+	_, err := compileHttpRule(&models.TblHttpRule{
+		Path:     rule.Path,
+		HeaderRe: rule.HeaderRe,
+	})
+	return err
+}
+
+// httpRules get compiled rules of user, cached until rules changed
+func (self *WebServer) httpRules(uid int64) ([]*httpRule, error) {
+	store := self.store
+	key := fmt.Sprintf("%v.httprules", uid)
+	v, exist := store.Get(key)
+	if exist {
+		return v.([]*httpRule), nil
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblHttpRule
+	err := session.Where(`uid=?`, uid).Desc("priority").Asc("id").Find(&items)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]*httpRule, 0, len(items))
+	for i := 0; i < len(items); i++ {
+		r, err := compileHttpRule(&items[i])
+		if err != nil {
+			logrus.Warnf("[httprule.go::httpRules] compile rule(id=%v): %v", items[i].Id, err)
+			continue
+		}
+		rules = append(rules, r)
+	}
+	store.Set(key, rules, cache.NoExpiration)
+	return rules, nil
+}
+
+// respHttpRule response by the first matched rule on log host of user, return false if no rule matched
+func (self *WebServer) respHttpRule(c *gin.Context, uid int64, path string) bool {
+	if uid == 0 {
+		return false
+	}
+	//not on host of web ui, or log host of another user
+	if user := self.hostUser(c); user == nil || user.Id != uid {
+		return false
+	}
+	rules, err := self.httpRules(uid)
+	if err != nil {
+		logrus.Errorf("[httprule.go::respHttpRule] httpRules: %v", err)
+		return false
+	}
+
+	req := c.Request
+	for _, r := range rules {
+		if !r.Match(req.Method, path, req.Header) {
+			continue
+		}
+		if r.Delay > 0 {
+			select {
+			case <-time.After(time.Duration(r.Delay) * time.Millisecond):
+			case <-req.Context().Done():
+				return true
+			}
+		}
+		for k, v := range r.Headers {
+			if !deniedRuleHeader(k) {
+				c.Header(k, v)
+			}
+		}
+		ctype := c.Writer.Header().Get("Content-Type")
+		if ctype == "" {
+			ctype = "text/html; charset=utf-8"
+		}
+		c.Data(r.Status, ctype, []byte(r.Body))
+		return true
+	}
+	return false
+}
+
+//==============================================================================
+// http rule api
+//==============================================================================
+func (self *WebServer) getHttpRules(c *gin.Context) {
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblHttpRule
+	err := session.Where(`uid=?`, id).Desc("priority").Asc("id").Find(&items)
+	if err != nil {
+		logrus.Errorf("[httprule.go::getHttpRules] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	rules := make([]models.HttpRule, len(items))
+	for i := 0; i < len(items); i++ {
+		rule := &rules[i]
+		item := &items[i]
+		rule.Id = item.Id
+		rule.Priority = item.Priority
+		rule.Method = item.Method
+		rule.Path = item.Path
+		rule.Header = item.Header
+		rule.HeaderRe = item.HeaderRe
+		rule.Status = item.Status
+		rule.Headers = item.Headers
+		rule.Body = item.Body
+		rule.Delay = item.Delay
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  rules,
+	})
+}
+
+func (self *WebServer) addHttpRule(c *gin.Context) {
+	var req HttpRule
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		logrus.Infof("[httprule.go::addHttpRule] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if err = validHttpRule(&req); err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	item := models.TblHttpRule{
+		Uid:      id,
+		Priority: req.Priority,
+		Method:   req.Method,
+		Path:     req.Path,
+		Header:   req.Header,
+		HeaderRe: req.HeaderRe,
+		Status:   req.Status,
+		Headers:  req.Headers,
+		Body:     req.Body,
+		Delay:    req.Delay,
+	}
+	_, err = session.InsertOne(&item)
+	if err != nil {
+		logrus.Errorf("[httprule.go::addHttpRule] orm.InsertOne: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Delete(fmt.Sprintf("%v.httprules", id))
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  item.Id,
+	})
+}
+
+func (self *WebServer) setHttpRule(c *gin.Context) {
+	var req HttpRule
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.Id < 1 {
+		logrus.Infof("[httprule.go::setHttpRule] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if err = validHttpRule(&req); err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	affected, err := session.ID(req.Id).And(`uid=?`, id).AllCols().Omit("id", "uid", "atime").
+		Update(&models.TblHttpRule{
+			Priority: req.Priority,
+			Method:   req.Method,
+			Path:     req.Path,
+			Header:   req.Header,
+			HeaderRe: req.HeaderRe,
+			Status:   req.Status,
+			Headers:  req.Headers,
+			Body:     req.Body,
+			Delay:    req.Delay,
+		})
+	if err != nil {
+		logrus.Errorf("[httprule.go::setHttpRule] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if affected == 0 {
+		self.resp(c, 404, &CR{
+			Message: "No such rule",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	self.store.Delete(fmt.Sprintf("%v.httprules", id))
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func (self *WebServer) delHttpRules(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[httprule.go::delHttpRules] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	params := make([]interface{}, len(req.Ids))
+	for i := 0; i < len(req.Ids); i++ {
+		params[i] = req.Ids[i]
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	_, err = session.Where(`uid=?`, id).In("id", params...).Delete(&models.TblHttpRule{})
+	if err != nil {
+		logrus.Errorf("[httprule.go::delHttpRules] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Delete(fmt.Sprintf("%v.httprules", id))
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
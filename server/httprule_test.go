@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/chennqqi/godnslog/models"
+)
+
+func TestHttpRuleMatch(t *testing.T) {
+	var tests = []struct {
+		Rule   models.TblHttpRule
+		Method string
+		Path   string
+		Header http.Header
+		Expect bool
+	}{
+		{models.TblHttpRule{}, "GET", "/a", nil, true},
+		{models.TblHttpRule{Method: "post"}, "GET", "/a", nil, false},
+		{models.TblHttpRule{Method: "post"}, "POST", "/a", nil, true},
+		{models.TblHttpRule{Path: `^/api/v\d+/`}, "GET", "/api/v1/user", nil, true},
+		{models.TblHttpRule{Path: `^/api/v\d+/`}, "GET", "/x/api/v1/", nil, false},
+		{models.TblHttpRule{Header: "x-token"}, "GET", "/", http.Header{"X-Token": {"1"}}, true},
+		{models.TblHttpRule{Header: "x-token"}, "GET", "/", http.Header{"X-Other": {"1"}}, false},
+		{models.TblHttpRule{Header: "User-Agent", HeaderRe: `(?i)java`}, "GET", "/", http.Header{"User-Agent": {"Java/1.8"}}, true},
+		{models.TblHttpRule{Header: "User-Agent", HeaderRe: `(?i)java`}, "GET", "/", http.Header{"User-Agent": {"curl"}}, false},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		test := &tests[i]
+		r, err := compileHttpRule(&test.Rule)
+		if err != nil {
+			t.Fatalf("compileHttpRule(%v): %v", i, err)
+		}
+		if r.Match(test.Method, test.Path, test.Header) != test.Expect {
+			t.Fatalf("test %v match!=expect(%v)", i, test.Expect)
+		}
+	}
+}
+
+func TestDeniedRuleHeader(t *testing.T) {
+	var tests = map[string]bool{
+		"set-cookie":                   true,
+		"Access-Control-Allow-Origin":  true,
+		"access-control-allow-headers": true,
+		"Content-Type":                 false,
+		"X-Access-Control":             false,
+	}
+	for name, expect := range tests {
+		if deniedRuleHeader(name) != expect {
+			t.Fatalf("deniedRuleHeader(%v)!=%v", name, expect)
+		}
+	}
+}
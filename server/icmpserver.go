@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+/*
+ICMP echo catcher, raw socket requires root or CAP_NET_RAW
+	ping -c1 -p $(printf token.userXXXX. | xxd -p) example.com
+ping has no host name, put `token.userXXXX.` in pattern (at most 16 bytes), echo reply is sent by kernel
+*/
+
+const (
+	MAX_ICMP_DATA = 256
+)
+
+type IcmpServerConfig struct {
+	Listen string //eg. 0.0.0.0
+}
+
+type IcmpServer struct {
+	IcmpServerConfig
+	tcpServer
+
+	conn *icmp.PacketConn
+}
+
+func NewIcmpServer(cfg *IcmpServerConfig, store *cache.Cache) (*IcmpServer, error) {
+	s := &IcmpServer{
+		IcmpServerConfig: *cfg,
+	}
+	s.name = "icmp"
+	s.store = store
+	conn, err := icmp.ListenPacket("ip4:icmp", s.Listen)
+	if err != nil {
+		return nil, err
+	}
+	s.conn = conn
+	return s, nil
+}
+
+func (s *IcmpServer) Run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, peer, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			s.lock.Lock()
+			quit := s.quit
+			s.lock.Unlock()
+			if !quit {
+				logrus.Errorf("[icmpserver.go::Run] ReadFrom: %v", err)
+			}
+			return
+		}
+		msg, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), buf[:n])
+		if err != nil || msg.Type != ipv4.ICMPTypeEcho {
+			continue
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok {
+			continue
+		}
+		s.logEcho(peer.String(), echo)
+	}
+}
+
+func (s *IcmpServer) Shutdown() {
+	s.lock.Lock()
+	s.quit = true
+	s.lock.Unlock()
+	s.conn.Close()
+	s.wg.Wait()
+}
+
+func (s *IcmpServer) logEcho(ip string, echo *icmp.Echo) {
+	data := echo.Data
+	if len(data) > MAX_ICMP_DATA {
+		data = data[:MAX_ICMP_DATA]
+	}
+	variable, user := s.lookupToken(string(data))
+	rcd := &IcmpRecord{
+		Ip:     ip,
+		EchoId: echo.ID,
+		Seq:    echo.Seq,
+		Data:   hex.Dump(data),
+		Size:   int64(len(echo.Data)),
+		Ctime:  time.Now(),
+	}
+	if user != nil {
+		//pattern is repeated after timestamp, only the label before shortId is variable
+		rcd.Uid = user.Id
+		rcd.Var = variable[strings.LastIndexByte(variable, '.')+1:]
+	}
+	s.log(rcd)
+}
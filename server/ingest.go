@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/gob"
+	"io"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Central instance of ingest nodes, nodes capture dns/http hits and forward records to it
+	godnslog serve -ingest-key SECRET ...
+	godnslog ingest -central https://log.example.com -key SECRET -domain example.com -4 NODE_IP
+internal api is authenticated by key in X-Ingest-Key header, bodies are gob, use https between nodes
+	GET /api/ingest/users      shortIds and interactsh registrations to resolve hits of users
+	POST /api/ingest/records   records captured by node, stored as local ones
+*/
+
+const (
+	INGEST_KEY_HEADER   = "X-Ingest-Key"
+	INGEST_CONTENT_TYPE = "application/x-gob"
+	MAX_INGEST_BODY     = 64 << 20
+)
+
+// IngestUsers is what an ingest node knows of users, secrets are not included
+type IngestUsers struct {
+	Users      []models.TblUser
+	Interactsh []models.TblInteractsh
+	Domains    []models.TblDomain //verified custom domains
+}
+
+func init() {
+	//http records built by nodes, others are registered for shared queue
+	gob.Register(&models.TblHttp{})
+}
+
+func (self *WebServer) verifyIngestKey(c *gin.Context) {
+	key := c.GetHeader(INGEST_KEY_HEADER)
+	if self.IngestKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(self.IngestKey)) != 1 {
+		self.resp(c, 401, &CR{
+			Message: "bad ingest key",
+			Code:    CodeNoAuth,
+		})
+		c.Abort()
+	}
+}
+
+// GET /api/ingest/users
+func (self *WebServer) ingestUsers(c *gin.Context) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var resp IngestUsers
+	err := session.Cols("id", "short_id", "callback", "rebind", "max_body_size").Find(&resp.Users)
+	if err == nil {
+		err = session.Cols("id", "uid", "correlation_id").Find(&resp.Interactsh)
+	}
+	if err == nil {
+		err = session.Where(`verified=?`, true).Cols("id", "uid", "domain", "verified").Find(&resp.Domains)
+	}
+	if err != nil {
+		logrus.Errorf("[ingest.go::ingestUsers] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	c.Header("Content-Type", INGEST_CONTENT_TYPE)
+	if err = gob.NewEncoder(c.Writer).Encode(&resp); err != nil {
+		logrus.Errorf("[ingest.go::ingestUsers] gob.Encode: %v", err)
+	}
+}
+
+// POST /api/ingest/records, count of handled records is returned, node retries the rest if failed
+func (self *WebServer) ingestRecords(c *gin.Context) {
+	var records []interface{}
+	err := gob.NewDecoder(io.LimitReader(c.Request.Body, MAX_INGEST_BODY)).Decode(&records)
+	if err != nil {
+		logrus.Infof("[ingest.go::ingestRecords] gob.Decode: %v", err)
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	for i, rcd := range records {
+		switch item := rcd.(type) {
+		case *models.TblHttp:
+			item.Id = 0
+			if self.DurableQueue {
+				self.store.Push(item)
+				break
+			}
+			body, _ := base64.StdEncoding.DecodeString(item.Body)
+			err = self.addHttp(item, body)
+			if err != nil && err != ErrRecordQuota {
+				logrus.Errorf("[ingest.go::ingestRecords] addHttp: %v", err)
+				self.resp(c, 502, &CR{
+					Message: "Failed",
+					Code:    CodeServerInternal,
+					Result:  i,
+				})
+				return
+			}
+		case *DnsRecord, *SmtpRecord, *LdapRecord, *FtpRecord, *TcpRecord, *IcmpRecord, *SmbRecord, *RmiRecord:
+			//dropped records of full queue are counted in queue stats
+			self.store.Push(rcd)
+		default:
+			logrus.Warnf("[ingest.go::ingestRecords] unknown record %T", rcd)
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  len(records),
+	})
+}
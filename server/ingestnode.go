@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Ingest node, a lightweight listener of dns/http hits without database, records are forwarded to central instance
+	godnslog ingest -central https://log.example.com -key SECRET -domain example.com -4 NODE_IP -http :80
+users are synced from central every -sync, hits of unknown users are answered as serve does.
+records are queued and posted in batches, kept and retried if central is unavailable, the queue drops
+records when it is full. http rules, websocket and tls are served by central only
+*/
+
+const (
+	DEFAULT_INGEST_SYNC  = 30 * time.Second
+	DEFAULT_INGEST_FLUSH = time.Second
+	DEFAULT_INGEST_BATCH = 500
+	MAX_INGEST_RETRY     = 30 * time.Second //max interval of retries
+)
+
+type IngestNodeConfig struct {
+	Central     string //base url of central instance
+	Key         string
+	Domain      string
+	Listen      string //http listen, empty: dns only
+	MaxBodySize int64  //default of users
+	Sync        time.Duration
+	Batch       int
+	Flush       time.Duration
+
+	//peers allowed to forward client ip by headers or PROXY protocol, none if nil
+	TrustedProxies *TrustedProxies
+}
+
+type IngestNode struct {
+	IngestNodeConfig
+	store  *cache.Cache
+	client *http.Client
+	s      *http.Server
+}
+
+func NewIngestNode(cfg *IngestNodeConfig, store *cache.Cache) (*IngestNode, error) {
+	u, err := url.Parse(cfg.Central)
+	if err != nil {
+		return nil, err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid central url: %v", cfg.Central)
+	} else if cfg.Key == "" {
+		return nil, fmt.Errorf("ingest key required")
+	}
+	node := &IngestNode{
+		IngestNodeConfig: *cfg,
+		store:            store,
+		client:           &http.Client{Timeout: 30 * time.Second},
+	}
+	node.Central = strings.TrimSuffix(cfg.Central, "/")
+	if node.Sync <= 0 {
+		node.Sync = DEFAULT_INGEST_SYNC
+	}
+	if node.Batch <= 0 {
+		node.Batch = DEFAULT_INGEST_BATCH
+	}
+	if node.Flush <= 0 {
+		node.Flush = DEFAULT_INGEST_FLUSH
+	}
+	return node, nil
+}
+
+func (n *IngestNode) request(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, n.Central+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(INGEST_KEY_HEADER, n.Key)
+	req.Header.Set("Content-Type", INGEST_CONTENT_TYPE)
+	return n.client.Do(req)
+}
+
+// syncUsers cache users of central, deleted users expire after a few syncs
+func (n *IngestNode) syncUsers() error {
+	resp, err := n.request("GET", "/api/ingest/users", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("status %v", resp.Status)
+	}
+
+	var users IngestUsers
+	if err = gob.NewDecoder(resp.Body).Decode(&users); err != nil {
+		return err
+	}
+	expire := 3 * n.Sync
+	for i := 0; i < len(users.Users); i++ {
+		user := &users.Users[i]
+		n.store.Set(fmt.Sprintf("%v.user", user.Id), user, expire)
+		n.store.Set(user.ShortId+".suser", user, expire)
+	}
+	for i := 0; i < len(users.Interactsh); i++ {
+		reg := &users.Interactsh[i]
+		n.store.Set(reg.CorrelationId+".interactsh", reg, expire)
+	}
+	for i := 0; i < len(users.Domains); i++ {
+		item := &users.Domains[i]
+		n.store.Set(item.Domain+".cdomain", item, expire)
+	}
+	return nil
+}
+
+// RunSync sync users from central until quit is closed
+func (n *IngestNode) RunSync(quit <-chan struct{}) {
+	ticker := time.NewTicker(n.Sync)
+	defer ticker.Stop()
+	for {
+		if err := n.syncUsers(); err != nil {
+			logrus.Errorf("[ingestnode.go::RunSync] syncUsers: %v", err)
+		}
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// post send records to central, count of records handled by central is returned
+func (n *IngestNode) post(records []interface{}) (int, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(records); err != nil {
+		return 0, err
+	}
+	resp, err := n.request("POST", "/api/ingest/records", &buf)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var cr struct {
+		Message string `json:"message"`
+		Result  int    `json:"result"`
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	json.Unmarshal(data, &cr)
+	if resp.StatusCode != 200 {
+		return cr.Result, fmt.Errorf("status %v: %v", resp.Status, cr.Message)
+	}
+	return len(records), nil
+}
+
+// forward post records until all are handled, retried with backoff
+func (n *IngestNode) forward(records []interface{}) {
+	wait := time.Second
+	for len(records) > 0 {
+		handled, err := n.post(records)
+		if handled > len(records) {
+			handled = len(records)
+		}
+		records = records[handled:]
+		if err == nil {
+			return
+		}
+		logrus.Errorf("[ingestnode.go::forward] post %v records: %v, retry in %v", len(records), err, wait)
+		time.Sleep(wait)
+		if wait *= 2; wait > MAX_INGEST_RETRY {
+			wait = MAX_INGEST_RETRY
+		}
+	}
+}
+
+// RunForward post queued records to central in batches until queue is closed
+func (n *IngestNode) RunForward() {
+	flush := time.NewTicker(n.Flush)
+	defer flush.Stop()
+
+	batch := make([]interface{}, 0, n.Batch)
+	for {
+		select {
+		case <-flush.C:
+			n.forward(batch)
+			batch = batch[:0]
+
+		case rcd, ok := <-n.store.Output():
+			if !ok {
+				//closed, no retry
+				if _, err := n.post(batch); err != nil && len(batch) > 0 {
+					logrus.Errorf("[ingestnode.go::RunForward] post %v records: %v", len(batch), err)
+				}
+				return
+			}
+			batch = append(batch, rcd)
+			if len(batch) >= n.Batch {
+				n.forward(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+func (n *IngestNode) maxBodySize(user *models.TblUser) int64 {
+	if user != nil && user.MaxBodySize > 0 {
+		return user.MaxBodySize
+	}
+	return n.MaxBodySize
+}
+
+// capture queue request to /log/${shortId}/ or subdomain of user and its custom domains as http record
+func (n *IngestNode) capture(c *gin.Context) {
+	var user *models.TblUser
+	variable := c.Request.URL.Path
+	logPath := strings.HasPrefix(variable, "/log/")
+	if logPath {
+		variable = c.Param("any")
+		if v, exist := n.store.Get(c.Param("shortId") + ".suser"); exist {
+			user = v.(*models.TblUser)
+		}
+	} else {
+		prefix, shortId, _ := parseUserDomain(n.store, requestHost(c), n.Domain)
+		if v, exist := n.store.Get(shortId + ".suser"); exist && prefix != "" {
+			user = v.(*models.TblUser)
+		} else {
+			user, variable = interactshUser(n.store, shortId)
+		}
+		if user == nil {
+			c.Data(404, "text/plain", []byte("404 page not found"))
+			return
+		}
+	}
+
+	var uid int64
+	if user != nil {
+		uid = user.Id
+	}
+	rcd, _ := newHttpRecord(c, n.TrustedProxies.ClientIP(c.Request), uid, variable, n.maxBodySize(user))
+	n.store.Push(rcd)
+	if logPath {
+		c.JSON(200, CR{Message: "OK", Timestamp: time.Now().Unix()})
+	} else {
+		c.Data(200, "text/html", []byte(interactionBody))
+	}
+}
+
+// Run serve http capture of node
+func (n *IngestNode) Run() error {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.Any("/log/:shortId", n.capture)
+	r.Any("/log/:shortId/*any", n.capture)
+	r.NoRoute(n.capture)
+
+	n.s = &http.Server{
+		Addr:    n.Listen,
+		Handler: r,
+	}
+	l, err := net.Listen("tcp", n.Listen)
+	if err != nil {
+		return err
+	}
+	err = n.s.Serve(n.TrustedProxies.Listener(l))
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (n *IngestNode) Shutdown() {
+	if n.s != nil {
+		n.s.Close()
+	}
+}
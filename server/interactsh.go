@@ -0,0 +1,332 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+interactsh server compatible api, interactsh-client and nuclei work with token of user
+	interactsh-client -s godnslog.com -t ${token}
+	nuclei -iserver godnslog.com -itoken ${token} ...
+payloads are ${correlationId}${nonce}.godnslog.com, dns and http interactions are saved as records of user,
+polled interactions are encrypted by aes key of each poll, which is encrypted by rsa public key of client
+	POST /register, GET /poll?id=${correlationId}&secret=${secretKey}, POST /deregister
+*/
+
+const (
+	INTERACTSH_CID_LEN   = 20 //default correlation id length of interactsh-client
+	INTERACTSH_NONCE_LEN = 13
+	MAX_INTERACTSH_ITEMS = 1000 //of each protocol per poll
+)
+
+type interactshRegister struct {
+	PublicKey     string `json:"public-key"`
+	SecretKey     string `json:"secret-key"`
+	CorrelationId string `json:"correlation-id"`
+}
+
+type interactshInteraction struct {
+	Protocol      string    `json:"protocol"`
+	UniqueId      string    `json:"unique-id"`
+	FullId        string    `json:"full-id"`
+	QType         string    `json:"q-type,omitempty"`
+	RawRequest    string    `json:"raw-request,omitempty"`
+	RawResponse   string    `json:"raw-response,omitempty"`
+	RemoteAddress string    `json:"remote-address"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// interactshUser get user of registered correlation id in label, label is unique id of interaction
+func interactshUser(store *cache.Cache, label string) (*models.TblUser, string) {
+	label = strings.ToLower(label)
+	if len(label) < INTERACTSH_CID_LEN+INTERACTSH_NONCE_LEN {
+		return nil, ""
+	}
+	v, exist := store.Get(label[:INTERACTSH_CID_LEN] + ".interactsh")
+	if !exist {
+		return nil, ""
+	}
+	v, exist = store.Get(fmt.Sprintf("%v.user", v.(*models.TblInteractsh).Uid))
+	if !exist {
+		return nil, ""
+	}
+	return v.(*models.TblUser), label
+}
+
+func interactshError(c *gin.Context, status int, message string) {
+	c.JSON(status, gin.H{"error": message})
+}
+
+// parseInteractshKey parse base64 pem of rsa public key
+func parseInteractshKey(s string) (*rsa.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid pem")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("not rsa key")
+	}
+	return pub, nil
+}
+
+// POST /register, Authorization: ${token}
+func (self *WebServer) interactshRegister(c *gin.Context) {
+	token := c.GetHeader("Authorization")
+	if token == "" {
+		interactshError(c, 401, "token required")
+		return
+	}
+	var req interactshRegister
+	if err := c.BindJSON(&req); err != nil {
+		interactshError(c, 400, "could not decode json body")
+		return
+	}
+	req.CorrelationId = strings.ToLower(req.CorrelationId)
+	if len(req.CorrelationId) != INTERACTSH_CID_LEN || req.SecretKey == "" {
+		interactshError(c, 400, "invalid correlation-id or secret-key")
+		return
+	}
+	if _, err := parseInteractshKey(req.PublicKey); err != nil {
+		interactshError(c, 400, "invalid public-key")
+		return
+	}
+
+	user, scopes, err := self.tokenUser(token)
+	if err != nil {
+		logrus.Errorf("[interactsh.go::interactshRegister] tokenUser: %v", err)
+		interactshError(c, 502, "failed")
+		return
+	} else if user == nil {
+		interactshError(c, 401, "invalid token")
+		return
+	} else if !hasScopes(scopes, readScopes...) {
+		interactshError(c, 403, "token scope required")
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	//client may register again with the same keys
+	var reg models.TblInteractsh
+	exist, err := session.Where(`correlation_id=?`, req.CorrelationId).Get(&reg)
+	if err == nil && exist {
+		if reg.Uid != user.Id || reg.SecretKey != req.SecretKey {
+			interactshError(c, 400, "correlation-id provided already exists")
+			return
+		}
+		reg.PublicKey = req.PublicKey
+		_, err = session.ID(reg.Id).Cols("public_key").Update(&reg)
+	} else if err == nil {
+		//interactions before registration are not returned
+		var dnsItem models.TblDns
+		var httpItem models.TblHttp
+		if _, err = session.Where(`uid=?`, user.Id).Desc("id").Cols("id").Get(&dnsItem); err == nil {
+			_, err = session.Where(`uid=?`, user.Id).Desc("id").Cols("id").Get(&httpItem)
+		}
+		reg = models.TblInteractsh{
+			Uid:           user.Id,
+			CorrelationId: req.CorrelationId,
+			SecretKey:     req.SecretKey,
+			PublicKey:     req.PublicKey,
+			DnsId:         dnsItem.Id,
+			HttpId:        httpItem.Id,
+		}
+		if err == nil {
+			_, err = session.InsertOne(&reg)
+		}
+	}
+	if err != nil {
+		logrus.Errorf("[interactsh.go::interactshRegister] register %v: %v", req.CorrelationId, err)
+		interactshError(c, 502, "failed")
+		return
+	}
+	self.store.Set(reg.CorrelationId+".interactsh", &reg, cache.NoExpiration)
+	c.JSON(200, gin.H{"message": "registration successful"})
+}
+
+// interactshClient get copy of registration by correlation id and secret key
+func (self *WebServer) interactshClient(id, secret string) (*models.TblInteractsh, bool) {
+	v, exist := self.store.Get(strings.ToLower(id) + ".interactsh")
+	if !exist {
+		return nil, false
+	}
+	reg := *v.(*models.TblInteractsh)
+	if subtle.ConstantTimeCompare([]byte(reg.SecretKey), []byte(secret)) != 1 {
+		return nil, false
+	}
+	return &reg, true
+}
+
+// POST /deregister
+func (self *WebServer) interactshDeregister(c *gin.Context) {
+	var req interactshRegister
+	if err := c.BindJSON(&req); err != nil {
+		interactshError(c, 400, "could not decode json body")
+		return
+	}
+	reg, ok := self.interactshClient(req.CorrelationId, req.SecretKey)
+	if !ok {
+		interactshError(c, 400, "could not get correlation-id")
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	_, err := session.ID(reg.Id).Delete(&models.TblInteractsh{})
+	if err != nil {
+		logrus.Errorf("[interactsh.go::interactshDeregister] orm.Delete: %v", err)
+		interactshError(c, 502, "failed")
+		return
+	}
+	self.store.Delete(reg.CorrelationId + ".interactsh")
+	c.JSON(200, gin.H{"message": "deregistration successful"})
+}
+
+// GET /poll?id=${correlationId}&secret=${secretKey}
+func (self *WebServer) interactshPoll(c *gin.Context) {
+	reg, ok := self.interactshClient(c.Query("id"), c.Query("secret"))
+	if !ok {
+		interactshError(c, 400, "could not get correlation-id")
+		return
+	}
+	pub, err := parseInteractshKey(reg.PublicKey)
+	if err != nil {
+		interactshError(c, 400, "invalid public-key")
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []*interactshInteraction
+	var dnsItems []models.TblDns
+	err = self.findHits(session.Where(`uid=?`, reg.Uid).And(`id>?`, reg.DnsId).And(`var like ?`, reg.CorrelationId+"%"),
+		&dnsItems, true, MAX_INTERACTSH_ITEMS, 0)
+	if err != nil {
+		logrus.Errorf("[interactsh.go::interactshPoll] orm.Find(dns): %v", err)
+		interactshError(c, 502, "failed")
+		return
+	}
+	for i := 0; i < len(dnsItems); i++ {
+		item := &dnsItems[i]
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(item.Domain), dns.StringToType[item.Qtype])
+		items = append(items, &interactshInteraction{
+			Protocol:      "dns",
+			UniqueId:      item.Var,
+			FullId:        strings.TrimSuffix(strings.ToLower(item.Domain), "."+self.Domain),
+			QType:         item.Qtype,
+			RawRequest:    msg.String(),
+			RemoteAddress: item.Ip,
+			Timestamp:     item.Ctime,
+		})
+		reg.DnsId = item.Id
+	}
+
+	var httpItems []models.TblHttp
+	err = self.findHits(session.Where(`uid=?`, reg.Uid).And(`id>?`, reg.HttpId).And(`var like ?`, reg.CorrelationId+"%"),
+		&httpItems, true, MAX_INTERACTSH_ITEMS, 0)
+	if err != nil {
+		logrus.Errorf("[interactsh.go::interactshPoll] orm.Find(http): %v", err)
+		interactshError(c, 502, "failed")
+		return
+	}
+	for i := 0; i < len(httpItems); i++ {
+		item := &httpItems[i]
+		host := item.Host
+		if strings.Contains(host, ":") {
+			host = host[:strings.LastIndexByte(host, ':')]
+		}
+		items = append(items, &interactshInteraction{
+			Protocol:      "http",
+			UniqueId:      item.Var,
+			FullId:        strings.TrimSuffix(strings.ToLower(host), "."+self.Domain),
+			RawRequest:    string(rawHttpRequest(item)),
+			RawResponse:   string(rawHttpResponse()),
+			RemoteAddress: item.Ip,
+			Timestamp:     item.Ctime,
+		})
+		reg.HttpId = item.Id
+	}
+
+	//aes key of this poll
+	key := make([]byte, 32)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		interactshError(c, 502, "failed")
+		return
+	}
+	encKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		interactshError(c, 400, "could not encrypt aes key")
+		return
+	}
+	data := make([]string, 0, len(items))
+	for _, item := range items {
+		plain, _ := json.Marshal(item)
+		enc, err := interactshEncrypt(key, plain)
+		if err != nil {
+			interactshError(c, 502, "failed")
+			return
+		}
+		data = append(data, enc)
+	}
+
+	if len(dnsItems) > 0 || len(httpItems) > 0 {
+		_, err = session.ID(reg.Id).Cols("dns_id", "http_id").Update(reg)
+		if err != nil {
+			logrus.Errorf("[interactsh.go::interactshPoll] orm.Update: %v", err)
+			interactshError(c, 502, "failed")
+			return
+		}
+		self.store.Set(reg.CorrelationId+".interactsh", reg, cache.NoExpiration)
+	}
+	c.JSON(200, gin.H{
+		"data":    data,
+		"aes_key": base64.StdEncoding.EncodeToString(encKey),
+	})
+}
+
+// interactshEncrypt is base64 of iv and aes-cfb cipher text
+func interactshEncrypt(key, plain []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	out := make([]byte, aes.BlockSize+len(plain))
+	iv := out[:aes.BlockSize]
+	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(out[aes.BlockSize:], plain)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
@@ -0,0 +1,275 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"unicode/utf8"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Source ip filters of dns and http recording, global filters by admin and filters of each user
+	GET|PUT|DELETE /api/admin/ipfilters
+	GET|PUT|DELETE /api/setting/ipfilters
+	{"action": "deny", "cidr": "66.249.64.0/19", "note": "googlebot"}
+a hit is recorded if it passes both global filters and filters of user, it passes filters if
+it matches an allow entry or there is no allow entry, and it matches no deny entry.
+filtered hits are still answered
+*/
+
+const (
+	MAX_USER_IP_FILTERS = 256
+	MAX_IP_FILTER_NOTE  = 255
+
+	IP_FILTER_ALLOW = "allow"
+	IP_FILTER_DENY  = "deny"
+)
+
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func matchNets(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *ipFilter) Pass(ip net.IP) bool {
+	if len(f.allow) > 0 && !matchNets(f.allow, ip) {
+		return false
+	}
+	return !matchNets(f.deny, ip)
+}
+
+// ipFilter get compiled filters of user, uid 0 is global, cached until filters changed
+func (self *WebServer) ipFilter(uid int64) (*ipFilter, error) {
+	store := self.store
+	key := fmt.Sprintf("%v.ipfilters", uid)
+	v, exist := store.Get(key)
+	if exist {
+		return v.(*ipFilter), nil
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblIpFilter
+	err := session.Where(`uid=?`, uid).Find(&items)
+	if err != nil {
+		return nil, err
+	}
+	f := &ipFilter{}
+	for i := 0; i < len(items); i++ {
+		ipnet, err := parseCidr(items[i].Cidr)
+		if err != nil {
+			logrus.Warnf("[ipfilter.go::ipFilter] compile filter(id=%v): %v", items[i].Id, err)
+			continue
+		}
+		if items[i].Action == IP_FILTER_ALLOW {
+			f.allow = append(f.allow, ipnet)
+		} else {
+			f.deny = append(f.deny, ipnet)
+		}
+	}
+	store.Set(key, f, cache.NoExpiration)
+	return f, nil
+}
+
+// ipRecordable is true if hit of user from ip passes global filters and filters of user
+func (self *WebServer) ipRecordable(uid int64, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return true
+	}
+	ids := []int64{0}
+	if uid != 0 {
+		ids = append(ids, uid)
+	}
+	for _, id := range ids {
+		f, err := self.ipFilter(id)
+		if err != nil {
+			logrus.Errorf("[ipfilter.go::ipRecordable] ipFilter(%v): %v", id, err)
+			continue
+		}
+		if !f.Pass(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *WebServer) listIpFilters(c *gin.Context, uid int64) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblIpFilter
+	err := session.Where(`uid=?`, uid).Asc("id").Find(&items)
+	if err != nil {
+		logrus.Errorf("[ipfilter.go::listIpFilters] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	filters := make([]IpFilter, len(items))
+	for i := 0; i < len(items); i++ {
+		item := &items[i]
+		filters[i] = IpFilter{
+			Id:     item.Id,
+			Action: item.Action,
+			Cidr:   item.Cidr,
+			Note:   item.Note,
+			Atime:  item.Atime,
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  filters,
+	})
+}
+
+func (self *WebServer) putIpFilter(c *gin.Context, uid int64) {
+	var req IpFilter
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		logrus.Infof("[ipfilter.go::putIpFilter] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.Action != IP_FILTER_ALLOW && req.Action != IP_FILTER_DENY {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("action should be %v or %v", IP_FILTER_ALLOW, IP_FILTER_DENY),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	ipnet, err := parseCidr(req.Cidr)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("invalid cidr: %v", req.Cidr),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if utf8.RuneCountInString(req.Note) > MAX_IP_FILTER_NOTE {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("note should be at most %v characters", MAX_IP_FILTER_NOTE),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	count, err := session.Where(`uid=?`, uid).Count(&models.TblIpFilter{})
+	if err != nil {
+		logrus.Errorf("[ipfilter.go::putIpFilter] orm.Count: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if count >= MAX_USER_IP_FILTERS {
+		self.resp(c, 400, &CR{
+			Message: "Too many ip filters",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	item := models.TblIpFilter{
+		Uid:    uid,
+		Action: req.Action,
+		Cidr:   ipnet.String(),
+		Note:   req.Note,
+	}
+	_, err = session.InsertOne(&item)
+	if err != nil {
+		logrus.Errorf("[ipfilter.go::putIpFilter] orm.InsertOne: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Delete(fmt.Sprintf("%v.ipfilters", uid))
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  item.Id,
+	})
+}
+
+func (self *WebServer) removeIpFilters(c *gin.Context, uid int64) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[ipfilter.go::removeIpFilters] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	params := make([]interface{}, len(req.Ids))
+	for i := 0; i < len(req.Ids); i++ {
+		params[i] = req.Ids[i]
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	_, err = session.Where(`uid=?`, uid).In("id", params...).Delete(&models.TblIpFilter{})
+	if err != nil {
+		logrus.Errorf("[ipfilter.go::removeIpFilters] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Delete(fmt.Sprintf("%v.ipfilters", uid))
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func (self *WebServer) getIpFilters(c *gin.Context) {
+	self.listIpFilters(c, c.GetInt64("id"))
+}
+
+func (self *WebServer) addIpFilter(c *gin.Context) {
+	self.putIpFilter(c, c.GetInt64("id"))
+}
+
+func (self *WebServer) delIpFilters(c *gin.Context) {
+	self.removeIpFilters(c, c.GetInt64("id"))
+}
+
+func (self *WebServer) getGlobalIpFilters(c *gin.Context) {
+	self.listIpFilters(c, 0)
+}
+
+func (self *WebServer) addGlobalIpFilter(c *gin.Context) {
+	self.putIpFilter(c, 0)
+}
+
+func (self *WebServer) delGlobalIpFilters(c *gin.Context) {
+	self.removeIpFilters(c, 0)
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	MAX_HELLO_SIZE = 16 * 1024
+)
+
+var errShortHello = errors.New("short client hello")
+
+// helloListener record raw ClientHello of each connection for JA3
+type helloListener struct {
+	net.Listener
+	hellos *sync.Map
+}
+
+func (l *helloListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &helloConn{Conn: c, hellos: l.hellos, recording: true}, nil
+}
+
+type helloConn struct {
+	net.Conn
+	hellos *sync.Map
+
+	lock      sync.Mutex
+	recording bool
+	buf       []byte
+}
+
+func (c *helloConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.lock.Lock()
+	if c.recording && n > 0 {
+		c.buf = append(c.buf, p[:n]...)
+		if len(c.buf) > MAX_HELLO_SIZE {
+			c.recording = false
+			c.buf = nil
+		}
+	}
+	c.lock.Unlock()
+	return n, err
+}
+
+func (c *helloConn) Close() error {
+	c.hellos.Delete(c.RemoteAddr().String())
+	return c.Conn.Close()
+}
+
+// finish stop recording and return the recorded hello
+func (c *helloConn) finish() []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	buf := c.buf
+	c.recording = false
+	c.buf = nil
+	return buf
+}
+
+// getConfigForClient calculate JA3 of the ClientHello, keep default config
+func (self *WebServer) getConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	c, ok := hello.Conn.(*helloConn)
+	if !ok {
+		return nil, nil
+	}
+	ja3, err := parseJA3(c.finish())
+	if err == nil {
+		c.hellos.Store(c.RemoteAddr().String(), ja3)
+	}
+	return nil, nil
+}
+
+// lookupJA3 by remote address of request
+func (self *WebServer) lookupJA3(remoteAddr string) string {
+	v, exist := self.hellos.Load(remoteAddr)
+	if !exist {
+		return ""
+	}
+	return v.(string)
+}
+
+func ja3Hash(ja3 string) string {
+	if ja3 == "" {
+		return ""
+	}
+	sum := md5.Sum([]byte(ja3))
+	return hex.EncodeToString(sum[:])
+}
+
+// https://tools.ietf.org/html/rfc8701
+func isGrease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func joinUint16(vs []uint16) string {
+	var b strings.Builder
+	for _, v := range vs {
+		if isGrease(v) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(strconv.Itoa(int(v)))
+	}
+	return b.String()
+}
+
+// parseJA3 parse raw tls records which contain a ClientHello
+// JA3 = SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats
+func parseJA3(records []byte) (string, error) {
+	//reassemble handshake message from records
+	var msg []byte
+	for len(records) >= 5 {
+		if records[0] != 22 { //handshake
+			return "", errors.New("not handshake record")
+		}
+		n := int(binary.BigEndian.Uint16(records[3:5]))
+		if len(records) < 5+n {
+			break
+		}
+		msg = append(msg, records[5:5+n]...)
+		records = records[5+n:]
+	}
+	if len(msg) < 4 || msg[0] != 1 { //client_hello
+		return "", errShortHello
+	}
+	n := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+	if len(msg) < 4+n {
+		return "", errShortHello
+	}
+	p := msg[4 : 4+n]
+
+	//client_version + random
+	if len(p) < 2+32+1 {
+		return "", errShortHello
+	}
+	version := binary.BigEndian.Uint16(p)
+	p = p[2+32:]
+
+	//session_id
+	n = int(p[0])
+	if len(p) < 1+n+2 {
+		return "", errShortHello
+	}
+	p = p[1+n:]
+
+	//cipher_suites
+	n = int(binary.BigEndian.Uint16(p))
+	if len(p) < 2+n+1 {
+		return "", errShortHello
+	}
+	var ciphers []uint16
+	for i := 0; i+1 < n; i += 2 {
+		ciphers = append(ciphers, binary.BigEndian.Uint16(p[2+i:]))
+	}
+	p = p[2+n:]
+
+	//compression_methods
+	n = int(p[0])
+	if len(p) < 1+n {
+		return "", errShortHello
+	}
+	p = p[1+n:]
+
+	//extensions
+	var exts, curves []uint16
+	var points []uint16
+	if len(p) >= 2 {
+		n = int(binary.BigEndian.Uint16(p))
+		p = p[2:]
+		if len(p) < n {
+			return "", errShortHello
+		}
+		p = p[:n]
+		for len(p) >= 4 {
+			typ := binary.BigEndian.Uint16(p)
+			n = int(binary.BigEndian.Uint16(p[2:]))
+			if len(p) < 4+n {
+				return "", errShortHello
+			}
+			data := p[4 : 4+n]
+			p = p[4+n:]
+			exts = append(exts, typ)
+
+			switch typ {
+			case 10: //supported_groups
+				if len(data) >= 2 {
+					m := int(binary.BigEndian.Uint16(data))
+					for i := 0; i+1 < m && 2+i+1 < len(data); i += 2 {
+						curves = append(curves, binary.BigEndian.Uint16(data[2+i:]))
+					}
+				}
+			case 11: //ec_point_formats
+				if len(data) >= 1 {
+					m := int(data[0])
+					for i := 0; i < m && 1+i < len(data); i++ {
+						points = append(points, uint16(data[1+i]))
+					}
+				}
+			}
+		}
+	}
+
+	return strings.Join([]string{
+		strconv.Itoa(int(version)),
+		joinUint16(ciphers),
+		joinUint16(exts),
+		joinUint16(curves),
+		joinUint16(points),
+	}, ","), nil
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS1.0"
+	case tls.VersionTLS11:
+		return "TLS1.1"
+	case tls.VersionTLS12:
+		return "TLS1.2"
+	case tls.VersionTLS13:
+		return "TLS1.3"
+	case 0:
+		return ""
+	}
+	return "0x" + strconv.FormatUint(uint64(v), 16)
+}
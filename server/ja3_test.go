@@ -0,0 +1,68 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJA3(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	go func() {
+		tls.Client(c1, &tls.Config{
+			ServerName:   "a.godnslog.com",
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA},
+		}).Handshake()
+	}()
+
+	c2.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, MAX_HELLO_SIZE)
+	n, err := c2.Read(buf)
+	if err != nil {
+		t.Fatalf("read client hello: %v", err)
+	}
+
+	ja3, err := parseJA3(buf[:n])
+	if err != nil {
+		t.Fatalf("parseJA3: %v", err)
+	}
+	fields := strings.Split(ja3, ",")
+	if len(fields) != 5 {
+		t.Fatalf("ja3(%v) should have 5 fields", ja3)
+	}
+	if fields[0] != "771" {
+		t.Fatalf("ja3 version(%v)!=expect(771)", fields[0])
+	}
+	if !strings.HasPrefix(fields[1], "49199-47") {
+		t.Fatalf("ja3 ciphers(%v)!=expect(49199-47...)", fields[1])
+	}
+	if !strings.HasPrefix(fields[2], "0-") {
+		t.Fatalf("ja3 extensions(%v) should start with server_name", fields[2])
+	}
+	if len(ja3Hash(ja3)) != 32 {
+		t.Fatalf("ja3Hash(%v) invalid", ja3)
+	}
+
+	if _, err = parseJA3(buf[:n/2]); err == nil {
+		t.Fatalf("parseJA3 should fail with short hello")
+	}
+}
+
+func TestIsGrease(t *testing.T) {
+	for _, v := range []uint16{0x0a0a, 0x1a1a, 0xfafa} {
+		if !isGrease(v) {
+			t.Fatalf("%x should be grease", v)
+		}
+	}
+	for _, v := range []uint16{0x0a1a, 0x002f, 0xc02f} {
+		if isGrease(v) {
+			t.Fatalf("%x should not be grease", v)
+		}
+	}
+}
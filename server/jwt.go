@@ -0,0 +1,203 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Stateless jwt, access tokens are verified by signature without login session, for scaled frontends and jobs
+	godnslog serve -jwt-key "$(cat jwt.key)" -jwt-expire 15m
+	POST /api/auth/login {"username":"ci","password":"...","stateless":true}  => token, refreshToken, expiresIn
+	POST /api/auth/refresh {"refreshToken":"..."}                              => new token and refreshToken
+all processes share -jwt-key, it signs jwt of login sessions as well. access tokens are not revoked,
+they expire after -jwt-expire. refresh tokens expire after AuthExpire like login sessions, they are denied
+if the user is disabled, deleted, its password is changed or it logs out by an access token.
+*/
+
+const (
+	JWT_ACCESS         = "access"
+	JWT_REFRESH        = "refresh"
+	DEFAULT_JWT_EXPIRE = 15 * time.Minute
+	MIN_JWT_KEY_SIZE   = 32
+)
+
+// signKey is key of jwt, shared by processes if set
+func (self *WebServer) signKey() []byte {
+	if self.JwtKey != "" {
+		return []byte(self.JwtKey)
+	}
+	return []byte(self.verifyKey)
+}
+
+// bumpTokenVersion deny refresh tokens issued to users before, on logout of stateless jwt
+func (self *WebServer) bumpTokenVersion(uids ...int64) error {
+	_, err := self.orm.In("id", uids).SetExpr(`token_version`, `token_version+1`).Update(&models.TblUser{})
+	return err
+}
+
+// newStatelessToken sign access and refresh tokens of user
+func (self *WebServer) newStatelessToken(user *models.TblUser) (*models.LoginResponse, error) {
+	now := time.Now()
+	claims := func(typ string, expire time.Duration, version int64) MyClaims {
+		return MyClaims{
+			Type:    typ,
+			Version: version,
+			StandardClaims: jwt.StandardClaims{
+				Id:        fmt.Sprintf("%v", user.Id),
+				Audience:  user.Name,
+				Subject:   user.Email,
+				ExpiresAt: now.Add(expire).Unix(),
+				IssuedAt:  now.Unix(),
+				Issuer:    self.Domain,
+			},
+		}
+	}
+	access, err := jwt.NewWithClaims(jwt.SigningMethodHS384, claims(JWT_ACCESS, self.JwtExpire, 0)).
+		SignedString(self.signKey())
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := jwt.NewWithClaims(jwt.SigningMethodHS384, claims(JWT_REFRESH, self.AuthExpire, user.TokenVersion)).
+		SignedString(self.signKey())
+	if err != nil {
+		return nil, err
+	}
+	return &models.LoginResponse{
+		Islogin:      true,
+		Token:        access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(self.JwtExpire / time.Second),
+	}, nil
+}
+
+// issueStatelessToken login user with stateless jwt instead of login session
+func (self *WebServer) issueStatelessToken(c *gin.Context, user *models.TblUser) {
+	auditUser(c, user)
+	if self.JwtKey == "" {
+		self.resp(c, 403, &CR{
+			Message: "stateless jwt disabled",
+			Code:    CodeNoPermission,
+		})
+		return
+	}
+	resp, err := self.newStatelessToken(user)
+	if err != nil {
+		logrus.Errorf("[jwt.go::issueStatelessToken] newStatelessToken: %v", err)
+		self.respData(c, 502, CodeServerInternal, "bad service", nil)
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  resp,
+	})
+}
+
+// statelessAuth authorize request of a valid access token by cached user
+func (self *WebServer) statelessAuth(c *gin.Context, claim *MyClaims) {
+	var uid int64
+	fmt.Sscanf(claim.Id, "%d", &uid)
+	if claim.Type != JWT_ACCESS || self.JwtKey == "" {
+		c.JSON(401, CR{
+			Message: "Token invalid",
+			Code:    CodeNoAuth,
+		})
+		c.Abort()
+		return
+	}
+
+	var user *models.TblUser
+	if v, exist := self.store.Get(fmt.Sprintf("%v.user", uid)); exist {
+		user = v.(*models.TblUser)
+	} else {
+		//users of another process
+		user = new(models.TblUser)
+		exist, err := self.orm.ID(uid).Get(user)
+		if err != nil {
+			logrus.Errorf("[jwt.go::statelessAuth] orm.Get: %v", err)
+			self.respData(c, 502, CodeServerInternal, "bad service", nil)
+			c.Abort()
+			return
+		} else if !exist {
+			c.JSON(401, CR{
+				Message: "not login",
+				Code:    CodeNoAuth,
+			})
+			c.Abort()
+			return
+		}
+		self.store.Set(fmt.Sprintf("%v.user", uid), user, cache.NoExpiration)
+	}
+	if user.Disabled {
+		c.JSON(401, CR{
+			Message: "user disabled",
+			Code:    CodeNoAuth,
+		})
+		c.Abort()
+		return
+	}
+
+	c.Set("id", uid)
+	c.Set("username", claim.Audience)
+	c.Set("email", claim.Subject)
+	c.Set("role", user.Role)
+}
+
+// POST /api/auth/refresh, refresh token is rotated
+func (self *WebServer) refreshToken(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.RefreshToken == "" {
+		self.resp(c, 400, &CR{
+			Message: "refreshToken required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if self.JwtKey == "" {
+		self.resp(c, 403, &CR{
+			Message: "stateless jwt disabled",
+			Code:    CodeNoPermission,
+		})
+		return
+	}
+
+	var claim MyClaims
+	token, err := jwt.ParseWithClaims(req.RefreshToken, &claim, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return self.signKey(), nil
+	})
+	if err != nil || !token.Valid || claim.Type != JWT_REFRESH {
+		logrus.Infof("[jwt.go::refreshToken] invalid refresh token: %v", err)
+		self.resp(c, 401, &CR{
+			Message: "Token invalid",
+			Code:    CodeNoAuth,
+		})
+		return
+	}
+
+	var uid int64
+	fmt.Sscanf(claim.Id, "%d", &uid)
+	user := new(models.TblUser)
+	exist, err := self.orm.ID(uid).Get(user)
+	if err != nil {
+		logrus.Errorf("[jwt.go::refreshToken] orm.Get: %v", err)
+		self.respData(c, 502, CodeServerInternal, "bad service", nil)
+		return
+	} else if !exist || user.Disabled || claim.Version != user.TokenVersion {
+		logrus.Infof("[jwt.go::refreshToken] refresh of user(id=%v) denied", uid)
+		self.resp(c, 401, &CR{
+			Message: "Token Expire",
+			Code:    CodeNoAuth,
+		})
+		return
+	}
+	self.issueStatelessToken(c, user)
+}
@@ -0,0 +1,677 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Login by ldap bind of directory accounts, eg. Active Directory or OpenLDAP, configured by super admin
+	GET  /api/admin/ldap
+	POST /api/admin/ldap {"url":"ldaps://dc.corp.local","bind_dn":"CN=svc,...","bind_pass":"xxx","base_dn":"DC=corp,DC=local",
+		"user_filter":"(sAMAccountName={username})","admin_group":"CN=RedTeam,OU=Groups,DC=corp,DC=local","auto_create":true}
+	POST /api/admin/ldap/test {"username":"alice","password":"xxx"} => {"dn":"...","email":"...","admin":true}
+the account of login name is searched by the service account, then its dn is bound with the password. users
+linked to a dn are always checked by ldap, unknown names are created with auto_create. with admin_group, role
+of users is admin if they are members of it, operator otherwise, by memberOf or member of the group.
+*/
+
+const (
+	LDAP_AUTH_KEY       = "ldap.auth"
+	LDAP_AUTH_TIMEOUT   = 10 * time.Second
+	LDAP_DEFAULT_FILTER = "(|(uid={username})(sAMAccountName={username}))"
+	MAX_LDAP_FILTER     = 16 //depth of nested filters
+
+	ldapExtendedStartTls = "1.3.6.1.4.1.1466.20037"
+	ldapSearchEntry      = 0x64
+	ldapSearchReference  = 0x73
+
+	ldapScopeBase    = 0
+	ldapScopeSubtree = 2
+)
+
+var (
+	errLdapFilter = errors.New("invalid ldap filter")
+	errLdapAuth   = errors.New("ldap login failed")
+)
+
+// ldapAuth get ldap login setting of admin
+func (self *WebServer) ldapAuth() (*models.TblLdapAuth, error) {
+	v, exist := self.store.Get(LDAP_AUTH_KEY)
+	if exist {
+		return v.(*models.TblLdapAuth), nil
+	}
+	var cfg models.TblLdapAuth
+	if _, err := self.orm.ID(1).Get(&cfg); err != nil {
+		return nil, err
+	}
+	self.store.Set(LDAP_AUTH_KEY, &cfg, cache.NoExpiration)
+	return &cfg, nil
+}
+
+// ldapEscape escape value of filter, RFC 4515
+func ldapEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func ldapUnescape(s string) ([]byte, error) {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+		if i+3 > len(s) {
+			return nil, errLdapFilter
+		}
+		c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return nil, errLdapFilter
+		}
+		b = append(b, byte(c))
+		i += 2
+	}
+	return b, nil
+}
+
+// ldapFilter encode filter string of and, or, not, equality, presence and substrings
+func ldapFilter(s string) ([]byte, error) {
+	f, rest, err := parseLdapFilter(strings.TrimSpace(s), 0)
+	if err == nil && rest != "" {
+		err = errLdapFilter
+	}
+	return f, err
+}
+
+func parseLdapFilter(s string, depth int) ([]byte, string, error) {
+	if depth > MAX_LDAP_FILTER || len(s) < 3 || s[0] != '(' {
+		return nil, "", errLdapFilter
+	}
+	if i := strings.IndexByte("&|!", s[1]); i >= 0 {
+		tag := byte(0xa0 + i)
+		var items []byte
+		n := 0
+		for s = s[2:]; len(s) > 0 && s[0] == '('; n++ {
+			f, rest, err := parseLdapFilter(s, depth+1)
+			if err != nil {
+				return nil, "", err
+			}
+			items = append(items, f...)
+			s = rest
+		}
+		if len(s) == 0 || s[0] != ')' || n == 0 || (tag == 0xa2 && n != 1) {
+			return nil, "", errLdapFilter
+		}
+		return berTLV(tag, items), s[1:], nil
+	}
+
+	end := strings.IndexByte(s, ')')
+	if end < 0 {
+		return nil, "", errLdapFilter
+	}
+	item, rest := s[1:end], s[end+1:]
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, "", errLdapFilter
+	}
+	attr, value := item[:eq], item[eq+1:]
+	if strings.ContainsAny(attr, "<>~:() ") {
+		return nil, "", fmt.Errorf("unsupported ldap filter: %v", item)
+	}
+	if value == "*" {
+		return berTLV(0x87, []byte(attr)), rest, nil
+	}
+	if !strings.Contains(value, "*") {
+		v, err := ldapUnescape(value)
+		if err != nil {
+			return nil, "", err
+		}
+		return berTLV(0xa3, append(berTLV(0x04, []byte(attr)), berTLV(0x04, v)...)), rest, nil
+	}
+	parts := strings.Split(value, "*")
+	var subs []byte
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		v, err := ldapUnescape(part)
+		if err != nil {
+			return nil, "", err
+		}
+		tag := byte(0x81) //any
+		if i == 0 {
+			tag = 0x80 //initial
+		} else if i == len(parts)-1 {
+			tag = 0x82 //final
+		}
+		subs = append(subs, berTLV(tag, v)...)
+	}
+	return berTLV(0xa4, append(berTLV(0x04, []byte(attr)), berTLV(0x30, subs)...)), rest, nil
+}
+
+// ldapClient is a connection of simple bind and search, RFC 4511
+type ldapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	id   int
+}
+
+type ldapEntry struct {
+	dn    string
+	attrs map[string][]string //lower case names
+}
+
+func dialLdap(cfg *models.TblLdapAuth) (*ldapClient, error) {
+	u, err := url.Parse(cfg.Url)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid ldap url: %v", cfg.Url)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: cfg.SkipVerify}
+	dialer := &net.Dialer{Timeout: LDAP_AUTH_TIMEOUT}
+	var conn net.Conn
+	switch u.Scheme {
+	case "ldap":
+		conn, err = dialer.Dial("tcp", net.JoinHostPort(u.Hostname(), portOr(u.Port(), "389")))
+	case "ldaps":
+		conn, err = tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(u.Hostname(), portOr(u.Port(), "636")), tlsConfig)
+	default:
+		return nil, fmt.Errorf("unsupported ldap scheme: %v", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(LDAP_AUTH_TIMEOUT))
+	l := &ldapClient{conn: conn, r: bufio.NewReader(conn)}
+	if cfg.StartTls && u.Scheme == "ldap" {
+		if err = l.startTls(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func portOr(port, def string) string {
+	if port == "" {
+		return def
+	}
+	return port
+}
+
+func (l *ldapClient) Close() error {
+	l.send(berTLV(ldapUnbindRequest, nil))
+	return l.conn.Close()
+}
+
+func (l *ldapClient) send(op []byte) error {
+	l.id++
+	_, err := l.conn.Write(berTLV(0x30, append(berEncodeInt(0x02, l.id), op...)))
+	return err
+}
+
+// recv next response of current request
+func (l *ldapClient) recv() (byte, []byte, error) {
+	for {
+		packet, err := berReadPacket(l.r, MAX_LDAP_PACKET)
+		if err != nil {
+			return 0, nil, err
+		}
+		id, op, content, err := ldapParseMessage(packet)
+		if err != nil {
+			return 0, nil, err
+		}
+		//unsolicited notification, eg. notice of disconnection
+		if id == 0 {
+			return 0, nil, errors.New("ldap server closed connection")
+		}
+		if id == l.id {
+			return op, content, nil
+		}
+	}
+}
+
+// ldapParseResult parse LDAPResult ::= { resultCode, matchedDN, diagnosticMessage }
+func ldapParseResult(content []byte) error {
+	tag, code, rest, err := berParse(content)
+	if err != nil || tag != 0x0a {
+		return errBadBer
+	}
+	if berInt(code) == ldapSuccess {
+		return nil
+	}
+	var diag []byte
+	if _, _, rest, err = berParse(rest); err == nil {
+		_, diag, _, _ = berParse(rest)
+	}
+	return fmt.Errorf("ldap result %v: %s", berInt(code), diag)
+}
+
+func (l *ldapClient) startTls(cfg *tls.Config) error {
+	if err := l.send(berTLV(ldapExtendedRequest, berTLV(0x80, []byte(ldapExtendedStartTls)))); err != nil {
+		return err
+	}
+	op, content, err := l.recv()
+	if err != nil {
+		return err
+	} else if op != ldapExtendedResp {
+		return errBadBer
+	}
+	if err = ldapParseResult(content); err != nil {
+		return err
+	}
+	conn := tls.Client(l.conn, cfg)
+	if err = conn.Handshake(); err != nil {
+		return err
+	}
+	l.conn, l.r = conn, bufio.NewReader(conn)
+	return nil
+}
+
+// bind simple, empty password is refused as it is unauthenticated bind of any dn
+func (l *ldapClient) bind(dn, password string) error {
+	if password == "" {
+		return errLdapAuth
+	}
+	req := berEncodeInt(0x02, 3)
+	req = append(req, berTLV(0x04, []byte(dn))...)
+	req = append(req, berTLV(0x80, []byte(password))...)
+	if err := l.send(berTLV(ldapBindRequest, req)); err != nil {
+		return err
+	}
+	op, content, err := l.recv()
+	if err != nil {
+		return err
+	} else if op != ldapBindResponse {
+		return errBadBer
+	}
+	return ldapParseResult(content)
+}
+
+func (l *ldapClient) search(base string, scope int, filter []byte, attrs ...string) ([]ldapEntry, error) {
+	req := berTLV(0x04, []byte(base))
+	req = append(req, berEncodeInt(0x0a, scope)...)
+	req = append(req, berEncodeInt(0x0a, 0)...) //never deref aliases
+	req = append(req, berEncodeInt(0x02, 2)...) //size limit, more than one user is ambiguous
+	req = append(req, berEncodeInt(0x02, int(LDAP_AUTH_TIMEOUT/time.Second))...)
+	req = append(req, berTLV(0x01, []byte{0})...)
+	req = append(req, filter...)
+	var list []byte
+	for _, attr := range attrs {
+		list = append(list, berTLV(0x04, []byte(attr))...)
+	}
+	req = append(req, berTLV(0x30, list)...)
+	if err := l.send(berTLV(ldapSearchRequest, req)); err != nil {
+		return nil, err
+	}
+
+	var entries []ldapEntry
+	for {
+		op, content, err := l.recv()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case ldapSearchDone:
+			if err = ldapParseResult(content); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		case ldapSearchEntry:
+			entry, err := ldapParseEntry(content)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, *entry)
+		case ldapSearchReference:
+		default:
+			return nil, errBadBer
+		}
+	}
+}
+
+// ldapParseEntry parse SearchResultEntry ::= { objectName, attributes SEQUENCE OF { type, vals SET OF value } }
+func ldapParseEntry(content []byte) (*ldapEntry, error) {
+	_, dn, rest, err := berParse(content)
+	if err != nil {
+		return nil, err
+	}
+	_, attrs, _, err := berParse(rest)
+	if err != nil {
+		return nil, err
+	}
+	entry := &ldapEntry{dn: string(dn), attrs: make(map[string][]string)}
+	for len(attrs) > 0 {
+		var attr, name, vals, val []byte
+		if _, attr, attrs, err = berParse(attrs); err != nil {
+			return nil, err
+		}
+		if _, name, attr, err = berParse(attr); err != nil {
+			return nil, err
+		}
+		if _, vals, _, err = berParse(attr); err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(string(name))
+		for len(vals) > 0 {
+			if _, val, vals, err = berParse(vals); err != nil {
+				return nil, err
+			}
+			entry.attrs[key] = append(entry.attrs[key], string(val))
+		}
+	}
+	return entry, nil
+}
+
+// ldapAuthenticate search account of name by service account, check membership of admin group, then bind it
+func ldapAuthenticate(cfg *models.TblLdapAuth, name, password string) (*models.LdapAccount, error) {
+	if name == "" || password == "" {
+		return nil, errLdapAuth
+	}
+	template := cfg.UserFilter
+	if template == "" {
+		template = LDAP_DEFAULT_FILTER
+	}
+	filter, err := ldapFilter(strings.ReplaceAll(template, "{username}", ldapEscape(name)))
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := dialLdap(cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	if cfg.BindDn != "" {
+		if err = l.bind(cfg.BindDn, cfg.BindPass); err != nil {
+			return nil, fmt.Errorf("bind of service account: %v", err)
+		}
+	}
+	entries, err := l.search(cfg.BaseDn, ldapScopeSubtree, filter, "mail", "memberOf")
+	if err != nil {
+		return nil, err
+	} else if len(entries) != 1 {
+		return nil, fmt.Errorf("%v accounts of %v found", len(entries), name)
+	}
+	entry := entries[0]
+	account := &models.LdapAccount{Dn: entry.dn}
+	if mails := entry.attrs["mail"]; len(mails) > 0 {
+		account.Email = mails[0]
+	}
+
+	if cfg.AdminGroup != "" {
+		for _, group := range entry.attrs["memberof"] {
+			account.Admin = account.Admin || strings.EqualFold(group, cfg.AdminGroup)
+		}
+		//groups of member, uniqueMember or posix memberUid if memberOf is not maintained
+		if !account.Admin {
+			filter, err := ldapFilter(fmt.Sprintf("(|(member=%v)(uniqueMember=%v)(memberUid=%v))",
+				ldapEscape(entry.dn), ldapEscape(entry.dn), ldapEscape(name)))
+			if err != nil {
+				return nil, err
+			}
+			groups, err := l.search(cfg.AdminGroup, ldapScopeBase, filter, "1.1")
+			if err != nil {
+				logrus.Warnf("[ldapauth.go::ldapAuthenticate] search group %v: %v", cfg.AdminGroup, err)
+			}
+			account.Admin = len(groups) > 0
+		}
+	}
+
+	if err = l.bind(entry.dn, password); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// ldapLogin check password of name by ldap, user is the local one of name or nil, it is created if allowed
+func (self *WebServer) ldapLogin(user *models.TblUser, name, password string) (*models.TblUser, error) {
+	cfg, err := self.ldapAuth()
+	if err != nil {
+		return nil, err
+	} else if cfg.Url == "" {
+		return nil, errors.New("ldap login disabled")
+	}
+	account, err := ldapAuthenticate(cfg, name, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if user != nil && !strings.EqualFold(user.LdapDn, account.Dn) {
+		return nil, fmt.Errorf("dn of %v changed to %v", user.Name, account.Dn)
+	}
+	if user == nil {
+		user = new(models.TblUser)
+		exist, err := self.orm.Where(`ldap_dn=?`, account.Dn).Get(user)
+		if err != nil {
+			return nil, err
+		}
+		if !exist {
+			if !cfg.AutoCreate {
+				return nil, fmt.Errorf("no user of %v", account.Dn)
+			}
+			return self.ldapCreateUser(cfg, name, account)
+		}
+	}
+
+	//viewers and operators set by admin are kept unless they are members of admin group
+	role := user.Role
+	if account.Admin {
+		role = roleAdmin
+	} else if role == roleAdmin {
+		role = roleOperator
+	}
+	if cfg.AdminGroup != "" && user.Role != roleSuper && user.Role != role {
+		user.Role = role
+		if _, err = self.orm.ID(user.Id).Cols("role").Update(user); err != nil {
+			return nil, err
+		}
+		logrus.Infof("[ldapauth.go::ldapLogin] role of %v changed to %v", user.Name, role)
+	}
+	return user, nil
+}
+
+func (self *WebServer) ldapCreateUser(cfg *models.TblLdapAuth, name string, account *models.LdapAccount) (*models.TblUser, error) {
+	name = oidcNameRegexp.ReplaceAllString(name, "")
+	if name == "" || len(name) > 64 {
+		return nil, fmt.Errorf("invalid user name of %v", account.Dn)
+	}
+	u, _ := url.Parse(cfg.Url)
+	email := account.Email
+	if email == "" {
+		email = name + "@" + u.Hostname()
+	} else if exist, err := self.orm.Where(`email=?`, email).Exist(&models.TblUser{}); err != nil {
+		return nil, err
+	} else if exist {
+		email = name + "@" + u.Hostname()
+	}
+	role := roleOperator
+	if account.Admin {
+		role = roleAdmin
+	}
+	user := self.newExternalUser(name, email, role)
+	user.LdapDn = account.Dn
+	if _, err := self.orm.InsertOne(user); err != nil {
+		return nil, err
+	}
+	logrus.Infof("[ldapauth.go::ldapCreateUser] user %v of %v created", user.Name, account.Dn)
+	return user, nil
+}
+
+// validLdapAuth check url and filter of setting
+func validLdapAuth(req *models.LdapAuth) error {
+	if req.Url == "" {
+		return nil
+	}
+	u, err := url.Parse(req.Url)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+		return errors.New("url should be ldap://host[:port] or ldaps://host[:port]")
+	}
+	if req.BaseDn == "" {
+		return errors.New("base dn required")
+	}
+	if req.UserFilter != "" {
+		if !strings.Contains(req.UserFilter, "{username}") {
+			return errors.New("user filter should contain {username}")
+		}
+		if _, err := ldapFilter(strings.ReplaceAll(req.UserFilter, "{username}", "x")); err != nil {
+			return fmt.Errorf("user filter: %v", err)
+		}
+	}
+	return nil
+}
+
+// GET /api/admin/ldap
+func (self *WebServer) getLdapAuth(c *gin.Context) {
+	cfg, err := self.ldapAuth()
+	if err != nil {
+		logrus.Errorf("[ldapauth.go::getLdapAuth] ldapAuth: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	filter := cfg.UserFilter
+	if filter == "" {
+		filter = LDAP_DEFAULT_FILTER
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result: models.LdapAuth{
+			Url:        cfg.Url,
+			StartTls:   cfg.StartTls,
+			SkipVerify: cfg.SkipVerify,
+			BindDn:     cfg.BindDn,
+			BaseDn:     cfg.BaseDn,
+			UserFilter: filter,
+			AdminGroup: cfg.AdminGroup,
+			AutoCreate: cfg.AutoCreate,
+		},
+	})
+}
+
+// POST /api/admin/ldap
+func (self *WebServer) setLdapAuth(c *gin.Context) {
+	var req models.LdapAuth
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		logrus.Infof("[ldapauth.go::setLdapAuth] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if err = validLdapAuth(&req); err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var cfg models.TblLdapAuth
+	exist, err := session.ID(1).Get(&cfg)
+	if err != nil {
+		logrus.Errorf("[ldapauth.go::setLdapAuth] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	cfg.Id = 1
+	cfg.Url = req.Url
+	cfg.StartTls = req.StartTls
+	cfg.SkipVerify = req.SkipVerify
+	cfg.BindDn = req.BindDn
+	cfg.BaseDn = req.BaseDn
+	cfg.UserFilter = req.UserFilter
+	cfg.AdminGroup = req.AdminGroup
+	cfg.AutoCreate = req.AutoCreate
+	if req.BindPass != "" || req.BindDn == "" {
+		cfg.BindPass = req.BindPass
+	}
+	if exist {
+		_, err = session.ID(1).AllCols().Update(&cfg)
+	} else {
+		_, err = session.InsertOne(&cfg)
+	}
+	if err != nil {
+		logrus.Errorf("[ldapauth.go::setLdapAuth] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Delete(LDAP_AUTH_KEY)
+	reqLog(c).Infof("[ldapauth.go::setLdapAuth] ldap login set to %v", req.Url)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// POST /api/admin/ldap/test, login of account without user
+func (self *WebServer) testLdapAuth(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Username == "" || req.Password == "" {
+		self.resp(c, 400, &CR{
+			Message: "username and password required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	cfg, err := self.ldapAuth()
+	if err != nil {
+		logrus.Errorf("[ldapauth.go::testLdapAuth] ldapAuth: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if cfg.Url == "" {
+		self.resp(c, 400, &CR{
+			Message: "ldap login not configured",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	account, err := ldapAuthenticate(cfg, req.Username, req.Password)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("login failed: %v", err),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  account,
+	})
+}
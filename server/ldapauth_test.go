@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestLdapEscape(t *testing.T) {
+	var tests = []struct {
+		Input  string
+		Expect string
+	}{
+		{"alice", "alice"},
+		{"*", `\2a`},
+		{"a)(uid=*", `a\29\28uid=\2a`},
+		{`a\b`, `a\5cb`},
+		{"a\x00b", `a\00b`},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		test := &tests[i]
+		if r := ldapEscape(test.Input); r != test.Expect {
+			t.Fatalf("test %q escape(%v)!=expect(%v)", test.Input, r, test.Expect)
+		}
+		//escaped value is a single equality of the original bytes
+		f, err := ldapFilter("(uid=" + ldapEscape(test.Input) + ")")
+		if err != nil {
+			t.Fatalf("test %q ldapFilter: %v", test.Input, err)
+		}
+		expect := berTLV(0xa3, append(berTLV(0x04, []byte("uid")), berTLV(0x04, []byte(test.Input))...))
+		if !bytes.Equal(f, expect) {
+			t.Fatalf("test %q filter(%x)!=expect(%x)", test.Input, f, expect)
+		}
+	}
+}
+
+func TestLdapFilter(t *testing.T) {
+	uid := berTLV(0xa3, append(berTLV(0x04, []byte("uid")), berTLV(0x04, []byte("a"))...))
+	sam := berTLV(0xa3, append(berTLV(0x04, []byte("sAMAccountName")), berTLV(0x04, []byte("a"))...))
+	var tests = []struct {
+		Input  string
+		Expect []byte
+		Error  bool
+	}{
+		{"(uid=a)", uid, false},
+		{" (uid=a) ", uid, false},
+		{"(uid=*)", berTLV(0x87, []byte("uid")), false},
+		{"(|(uid=a)(sAMAccountName=a))", berTLV(0xa1, append(append([]byte{}, uid...), sam...)), false},
+		{"(&(uid=a))", berTLV(0xa0, uid), false},
+		{"(!(uid=a))", berTLV(0xa2, uid), false},
+		{"(cn=a*b*c)", berTLV(0xa4, append(berTLV(0x04, []byte("cn")),
+			berTLV(0x30, append(append(berTLV(0x80, []byte("a")), berTLV(0x81, []byte("b"))...), berTLV(0x82, []byte("c"))...))...)), false},
+		{"(cn=*b*)", berTLV(0xa4, append(berTLV(0x04, []byte("cn")), berTLV(0x30, berTLV(0x81, []byte("b")))...)), false},
+		{"", nil, true},
+		{"uid=a", nil, true},
+		{"(uid=a", nil, true},
+		{"(uid=a))", nil, true},
+		{"(=a)", nil, true},
+		{"(uid>=a)", nil, true},
+		{"(uid:dn:=a)", nil, true},
+		{"(uid=\\2)", nil, true},
+		{"(uid=\\zz)", nil, true},
+		{"(&)", nil, true},
+		{"(!(uid=a)(uid=a))", nil, true},
+		{"(|(uid=a)", nil, true},
+		{strings.Repeat("(&", MAX_LDAP_FILTER+1) + "(uid=a)" + strings.Repeat(")", MAX_LDAP_FILTER+1), nil, true},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		test := &tests[i]
+		f, err := ldapFilter(test.Input)
+		if test.Error {
+			if err == nil {
+				t.Fatalf("test %q should fail, got %x", test.Input, f)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("test %q ldapFilter: %v", test.Input, err)
+		}
+		if !bytes.Equal(f, test.Expect) {
+			t.Fatalf("test %q filter(%x)!=expect(%x)", test.Input, f, test.Expect)
+		}
+	}
+}
+
+func TestLdapBind(t *testing.T) {
+	var tests = []struct {
+		Password string
+		Resp     func(id int) []byte
+		Error    bool
+	}{
+		{"secret", func(id int) []byte { return ldapResult(id, ldapBindResponse, ldapSuccess) }, false},
+		{"secret", func(id int) []byte { return ldapResult(id, ldapBindResponse, 49) }, true},
+		{"secret", func(id int) []byte { return ldapResult(id, ldapSearchDone, ldapSuccess) }, true},
+		{"secret", func(id int) []byte { return ldapResult(0, ldapBindResponse, ldapSuccess) }, true},
+		{"secret", func(id int) []byte { return []byte{0x30, 0x03, 0x02, 0x01} }, true},
+		{"", nil, true},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		test := &tests[i]
+		client, server := net.Pipe()
+		l := &ldapClient{conn: client, r: bufio.NewReader(client)}
+		go func() {
+			defer server.Close()
+			if test.Resp == nil {
+				return
+			}
+			packet, err := berReadPacket(bufio.NewReader(server), MAX_LDAP_PACKET)
+			if err != nil {
+				return
+			}
+			id, _, _, _ := ldapParseMessage(packet)
+			server.Write(test.Resp(id))
+		}()
+		err := l.bind("uid=alice,dc=example,dc=com", test.Password)
+		client.Close()
+		if test.Error && err == nil {
+			t.Fatalf("test %v bind should fail", i)
+		} else if !test.Error && err != nil {
+			t.Fatalf("test %v bind: %v", i, err)
+		}
+	}
+}
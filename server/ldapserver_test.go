@@ -0,0 +1,40 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestLdapMessage(t *testing.T) {
+	//bind request of java jndi: version 3, anonymous
+	bind := []byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x60, 0x07, 0x02, 0x01, 0x03, 0x04, 0x00, 0x80, 0x00}
+	packet, err := berReadPacket(bufio.NewReader(bytes.NewReader(bind)), MAX_LDAP_PACKET)
+	if err != nil {
+		t.Fatalf("berReadPacket: %v", err)
+	}
+	id, op, content, err := ldapParseMessage(packet)
+	if err != nil {
+		t.Fatalf("ldapParseMessage: %v", err)
+	}
+	if id != 1 || op != ldapBindRequest {
+		t.Fatalf("id(%v), op(%x)!=expect(1, %x)", id, op, ldapBindRequest)
+	}
+	name, password, err := ldapParseBind(content)
+	if err != nil || name != "" || password != "" {
+		t.Fatalf("ldapParseBind(%v, %v, %v) should be anonymous", name, password, err)
+	}
+
+	resp := ldapResult(300, ldapBindResponse, ldapSuccess)
+	id, op, content, err = ldapParseMessage(resp)
+	if err != nil || id != 300 || op != ldapBindResponse {
+		t.Fatalf("ldapResult parsed(%v, %x, %v)", id, op, err)
+	}
+	if !bytes.Equal(content, []byte{0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00}) {
+		t.Fatalf("ldapResult content(%x) invalid", content)
+	}
+
+	if _, err = berReadPacket(bufio.NewReader(bytes.NewReader(bind[:8])), MAX_LDAP_PACKET); err == nil {
+		t.Fatalf("berReadPacket should fail with short packet")
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Graceful shutdown in stages, stops of a stage run concurrently, stages run in order
+	listeners  dns, smtp ... stop, http servers finish in-flight requests
+	store      queue of records is closed and drained, pending batch is flushed
+	workers    in-flight webhook deliveries and background jobs finish, retries are cancelled
+	sinks      clickhouse, elastic and tracer export buffered data
+	close      database is closed
+all stages share deadline of ctx, -shutdown-timeout of serve. at deadline in-flight webhook attempts
+are cancelled, a stage is waited SHUTDOWN_GRACE more for cancelled work, eg. results of deliveries,
+then stops not returned are abandoned and later stages still run
+*/
+
+const SHUTDOWN_GRACE = time.Second
+
+const (
+	STAGE_LISTENERS = iota
+	STAGE_STORE
+	STAGE_WORKERS
+	STAGE_SINKS
+	STAGE_CLOSE
+	stageCount
+)
+
+var stageNames = []string{"listeners", "store", "workers", "sinks", "close"}
+
+type lifecycleStop struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+type Lifecycle struct {
+	stages [stageCount][]lifecycleStop
+}
+
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+// Add stop of name to stage, stop should return when ctx is done if it may block
+func (l *Lifecycle) Add(stage int, name string, stop func(ctx context.Context) error) {
+	l.stages[stage] = append(l.stages[stage], lifecycleStop{name, stop})
+}
+
+// AddFunc add stop without context, eg. Shutdown of listeners
+func (l *Lifecycle) AddFunc(stage int, name string, stop func()) {
+	l.Add(stage, name, func(context.Context) error {
+		stop()
+		return nil
+	})
+}
+
+// Shutdown run stages in order until all are stopped or ctx is done
+func (l *Lifecycle) Shutdown(ctx context.Context) {
+	for stage, stops := range l.stages {
+		if len(stops) == 0 {
+			continue
+		}
+		start := time.Now()
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		pending := make(map[string]bool)
+		for _, s := range stops {
+			s := s
+			pending[s.name] = true
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := s.stop(ctx); err != nil {
+					logrus.Errorf("[lifecycle.go::Shutdown] stop %v: %v", s.name, err)
+				}
+				lock.Lock()
+				delete(pending, s.name)
+				lock.Unlock()
+			}()
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			logrus.Infof("[lifecycle.go::Shutdown] %v stopped in %v", stageNames[stage], time.Since(start))
+		case <-ctx.Done():
+			select {
+			case <-done:
+				logrus.Warnf("[lifecycle.go::Shutdown] %v stopped after deadline", stageNames[stage])
+				continue
+			case <-time.After(SHUTDOWN_GRACE):
+			}
+			lock.Lock()
+			var names []string
+			for name := range pending {
+				names = append(names, name)
+			}
+			lock.Unlock()
+			logrus.Warnf("[lifecycle.go::Shutdown] %v: %v not stopped before deadline", stageNames[stage], strings.Join(names, ", "))
+		}
+	}
+}
+
+// AddStops add stops of web server, http listeners, store routine, workers and database
+func (self *WebServer) AddStops(l *Lifecycle) {
+	l.Add(STAGE_LISTENERS, "web", self.stopListeners)
+	l.Add(STAGE_STORE, "store", self.stopStore)
+	l.Add(STAGE_WORKERS, "workers", self.stopWorkers)
+	l.AddFunc(STAGE_CLOSE, "database", func() {
+		self.orm.Close()
+	})
+}
+
+// stopListeners stop http, https, grpc and pprof servers, in-flight requests are finished
+func (self *WebServer) stopListeners(ctx context.Context) error {
+	if self.gs != nil {
+		//streams of watch never end, not graceful
+		self.gs.Stop()
+	}
+	if self.ps != nil {
+		//profiles may last for seconds, not graceful
+		self.ps.Close()
+	}
+	var tserr error
+	if self.ts != nil {
+		tserr = self.ts.Shutdown(ctx)
+	}
+	if self.s == nil {
+		return tserr
+	}
+	if err := self.s.Shutdown(ctx); err != nil {
+		return err
+	}
+	return tserr
+}
+
+// stopStore close queue of records, wait store routine to drain it and flush batch
+func (self *WebServer) stopStore(ctx context.Context) error {
+	self.store.Close()
+	select {
+	case <-self.storeQuit:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stopWorkers wait webhook deliveries and background jobs, in-flight attempts are cancelled at deadline
+func (self *WebServer) stopWorkers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		self.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		//results of cancelled attempts are saved
+		self.cancelDeliveries()
+		<-done
+		return ctx.Err()
+	}
+}
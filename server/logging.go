@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Structured logs of requests, a request is identified by X-Request-Id of request or a random one, returned in response
+	{"level":"info","msg":"GET /api/record/dns","request_id":"k3x...","uid":1,"status":200,"latency_ms":3,...}
+requests are logged at info level, 5xx responses at error level with message of response.
+logs of a handler may be written by reqLog(c) to carry request_id and uid
+*/
+
+const (
+	REQUEST_ID_HEADER = "X-Request-Id"
+	REQUEST_ID_LEN    = 16
+	MAX_REQUEST_ID    = 64
+)
+
+// reqLog is logger of request with request id and user if authenticated
+func reqLog(c *gin.Context) *logrus.Entry {
+	fields := logrus.Fields{"request_id": c.GetString("request_id")}
+	if uid := c.GetInt64("id"); uid != 0 {
+		fields["uid"] = uid
+	} else if uid := c.GetInt64("uid"); uid != 0 {
+		fields["uid"] = uid
+	}
+	return logrus.WithFields(fields)
+}
+
+// accessLog log request when it is done, instead of logger of gin
+func (self *WebServer) accessLog(c *gin.Context) {
+	start := time.Now()
+	id := c.GetHeader(REQUEST_ID_HEADER)
+	if id == "" || len(id) > MAX_REQUEST_ID {
+		id = genRandomString(REQUEST_ID_LEN)
+	}
+	c.Set("request_id", id)
+	c.Header(REQUEST_ID_HEADER, id)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	entry := reqLog(c).WithFields(logrus.Fields{
+		"method":     c.Request.Method,
+		"path":       c.Request.URL.Path,
+		"host":       c.Request.Host,
+		"ip":         self.TrustedProxies.ClientIP(c.Request),
+		"status":     status,
+		"size":       c.Writer.Size(),
+		"latency_ms": time.Since(start).Milliseconds(),
+	})
+	if route := c.FullPath(); route != "" {
+		entry = entry.WithField("route", route)
+	}
+	if span := spanFrom(c.Request.Context()); span != nil {
+		entry = entry.WithField("trace_id", hex.EncodeToString(span.traceId[:]))
+	}
+	msg := c.Request.Method + " " + c.Request.URL.Path
+	if status >= 500 {
+		entry.WithField("error", c.GetString("error")).Error(msg)
+	} else {
+		entry.Info(msg)
+	}
+}
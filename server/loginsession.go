@@ -0,0 +1,287 @@
+package server
+
+import (
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Login sessions of web ui, every login is a session with its own jwt token
+	GET    /api/setting/sessions               sessions of user, current is the session of request
+	DELETE /api/setting/sessions {"ids":[2]}   revoke sessions, logout
+	DELETE /api/setting/sessions/others        revoke all sessions but current
+	POST   /api/admin/user/logout {"ids":[3]}  admin revokes all sessions of users
+seed of jwt token is the session id, sessions are saved in database and cached by <seed>.login
+*/
+
+const (
+	LOGIN_TOUCH_INTERVAL = time.Minute //last seen time is saved at most once per interval
+)
+
+// loginSession is cached session of jwt seed
+type loginSession struct {
+	Id    int64
+	Uid   int64
+	Atime time.Time
+	Etime time.Time
+}
+
+func loginKey(seed string) string {
+	return seed + ".login"
+}
+
+// newLogin save session of jwt seed, expired sessions of all users are removed
+func (self *WebServer) newLogin(c *gin.Context, uid int64, seed string) error {
+	now := time.Now()
+	ua := c.Request.UserAgent()
+	if len(ua) > 255 {
+		ua = ua[:255]
+	}
+	item := &models.TblLogin{
+		Uid:   uid,
+		Seed:  seed,
+		Ip:    self.TrustedProxies.ClientIP(c.Request),
+		Ua:    ua,
+		Atime: now,
+		Etime: now.Add(self.AuthExpire),
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	if _, err := session.InsertOne(item); err != nil {
+		return err
+	}
+	if _, err := session.Where(`etime<?`, self.sqlTime(now)).Delete(&models.TblLogin{}); err != nil {
+		logrus.Errorf("[loginsession.go::newLogin] orm.Delete(expired): %v", err)
+	}
+	self.store.Set(loginKey(seed), &loginSession{item.Id, uid, now, item.Etime}, self.AuthExpire)
+	return nil
+}
+
+// getLogin get session of jwt seed, nil if revoked or expired
+func (self *WebServer) getLogin(seed string) *loginSession {
+	if v, exist := self.store.Get(loginKey(seed)); exist {
+		return v.(*loginSession)
+	}
+	//not cached, eg. by another process of shared database
+	var item models.TblLogin
+	exist, err := self.orm.Where(`seed=?`, seed).And(`etime>?`, self.sqlTime(time.Now())).Get(&item)
+	if err != nil {
+		logrus.Errorf("[loginsession.go::getLogin] orm.Get: %v", err)
+		return nil
+	} else if !exist {
+		return nil
+	}
+	login := &loginSession{item.Id, item.Uid, item.Atime, item.Etime}
+	self.store.Set(loginKey(seed), login, time.Until(item.Etime))
+	return login
+}
+
+// touchLogin save last seen time of session
+func (self *WebServer) touchLogin(seed string, login *loginSession) {
+	now := time.Now()
+	if now.Sub(login.Atime) < LOGIN_TOUCH_INTERVAL {
+		return
+	}
+	//cached session is shared by requests
+	touched := *login
+	touched.Atime = now
+	self.store.Set(loginKey(seed), &touched, time.Until(login.Etime))
+
+	_, err := self.orm.ID(login.Id).Cols("atime").Update(&models.TblLogin{Atime: now})
+	if err != nil {
+		logrus.Errorf("[loginsession.go::touchLogin] orm.Update: %v", err)
+	}
+}
+
+// revokeLogin delete session of jwt seed, logout
+func (self *WebServer) revokeLogin(seed string) error {
+	self.store.Delete(loginKey(seed))
+	_, err := self.orm.Where(`seed=?`, seed).Delete(&models.TblLogin{})
+	return err
+}
+
+// revokeLogins delete sessions of user by ids, all if ids is empty, except session of seed keep
+func (self *WebServer) revokeLogins(uid int64, ids []int64, keep string) (int, error) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	session = session.Where(`uid=?`, uid)
+	if len(ids) > 0 {
+		session = session.In("id", ids)
+	}
+	if keep != "" {
+		session = session.And(`seed<>?`, keep)
+	}
+	var items []models.TblLogin
+	if err := session.Cols("id", "seed").Find(&items); err != nil {
+		return 0, err
+	}
+	if len(items) == 0 {
+		return 0, nil
+	}
+	ids = make([]int64, len(items))
+	for i := 0; i < len(items); i++ {
+		ids[i] = items[i].Id
+		self.store.Delete(loginKey(items[i].Seed))
+	}
+	_, err := self.orm.In("id", ids).Delete(&models.TblLogin{})
+	return len(items), err
+}
+
+// logoutUser revoke all sessions and refresh tokens of users, eg. disabled or password reset
+func (self *WebServer) logoutUser(uids ...int64) {
+	for _, uid := range uids {
+		if _, err := self.revokeLogins(uid, nil, ""); err != nil {
+			logrus.Errorf("[loginsession.go::logoutUser] revokeLogins(%v): %v", uid, err)
+		}
+	}
+	if len(uids) > 0 {
+		if err := self.bumpTokenVersion(uids...); err != nil {
+			logrus.Errorf("[loginsession.go::logoutUser] bumpTokenVersion: %v", err)
+		}
+	}
+}
+
+// GET /api/setting/sessions
+func (self *WebServer) getLogins(c *gin.Context) {
+	id := c.GetInt64("id")
+	seed := c.GetString("seed")
+
+	var items []models.TblLogin
+	err := self.orm.Where(`uid=?`, id).And(`etime>?`, self.sqlTime(time.Now())).Desc("id").Find(&items)
+	if err != nil {
+		logrus.Errorf("[loginsession.go::getLogins] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	logins := make([]models.LoginSession, len(items))
+	for i := 0; i < len(items); i++ {
+		item := &items[i]
+		logins[i] = models.LoginSession{
+			Id:      item.Id,
+			Ip:      item.Ip,
+			Ua:      item.Ua,
+			Current: item.Seed == seed,
+			Ctime:   item.Ctime,
+			Atime:   item.Atime,
+			Etime:   item.Etime,
+		}
+		//last seen is saved once per LOGIN_TOUCH_INTERVAL
+		if logins[i].Current {
+			logins[i].Atime = time.Now()
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  logins,
+	})
+}
+
+// DELETE /api/setting/sessions
+func (self *WebServer) delLogins(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[loginsession.go::delLogins] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	id := c.GetInt64("id")
+	n, err := self.revokeLogins(id, req.Ids, "")
+	if err != nil {
+		logrus.Errorf("[loginsession.go::delLogins] revokeLogins: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  n,
+	})
+}
+
+// DELETE /api/setting/sessions/others
+func (self *WebServer) delOtherLogins(c *gin.Context) {
+	id := c.GetInt64("id")
+	n, err := self.revokeLogins(id, nil, c.GetString("seed"))
+	if err != nil {
+		logrus.Errorf("[loginsession.go::delOtherLogins] revokeLogins: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	auditDetail(c, "revoked=%v", n)
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  n,
+	})
+}
+
+// POST /api/admin/user/logout, sessions of super admin are revoked by super admin only
+func (self *WebServer) logoutUsers(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[loginsession.go::logoutUsers] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	if c.GetInt("role") != roleSuper {
+		n, err := self.orm.In("id", req.Ids).And(`role=?`, roleSuper).Count(&models.TblUser{})
+		if err != nil {
+			logrus.Errorf("[loginsession.go::logoutUsers] orm.Count: %v", err)
+			self.resp(c, 502, &CR{
+				Message: "Failed",
+				Code:    CodeServerInternal,
+			})
+			return
+		} else if n > 0 {
+			self.resp(c, 403, &CR{
+				Message: "bad permission",
+				Code:    CodeNoPermission,
+			})
+			return
+		}
+	}
+
+	var total int
+	for _, uid := range req.Ids {
+		n, err := self.revokeLogins(uid, nil, "")
+		if err != nil {
+			logrus.Errorf("[loginsession.go::logoutUsers] revokeLogins(%v): %v", uid, err)
+			self.resp(c, 502, &CR{
+				Message: "Failed",
+				Code:    CodeServerInternal,
+			})
+			return
+		}
+		total += n
+	}
+	reqLog(c).Infof("[loginsession.go::logoutUsers] %v sessions of users %v revoked", total, req.Ids)
+	auditDetail(c, "revoked=%v", total)
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  total,
+	})
+}
@@ -0,0 +1,336 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Email alerts, smtp client is configured by admin
+	GET|POST /api/admin/mail
+	POST /api/admin/mail/test, send test mail to admin
+each user chooses alerts in app setting, mails are sent to email of user
+	{"mailAlert": 1} every hit, {"mailAlert": 2} first hit of each token
+*/
+
+const (
+	MAIL_ALERT_OFF   = 0
+	MAIL_ALERT_EVERY = 1
+	MAIL_ALERT_FIRST = 2
+
+	MAX_USER_MAILS     = 60 //mails of user in MAIL_RATE_INTERVAL, more alerts are dropped
+	MAIL_RATE_INTERVAL = time.Hour
+	MAIL_TIMEOUT       = 30 * time.Second
+	MAIL_SERVER_KEY    = "mail.server"
+)
+
+// mailServer get smtp client setting, empty host if not configured
+func (self *WebServer) mailServer() (*models.TblMailServer, error) {
+	v, exist := self.store.Get(MAIL_SERVER_KEY)
+	if exist {
+		return v.(*models.TblMailServer), nil
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var srv models.TblMailServer
+	if _, err := session.ID(1).Get(&srv); err != nil {
+		return nil, err
+	}
+	self.store.Set(MAIL_SERVER_KEY, &srv, cache.NoExpiration)
+	return &srv, nil
+}
+
+// sendMail send plain text mail by smtp client setting
+func sendMail(srv *models.TblMailServer, to, subject, body string) error {
+	addr := net.JoinHostPort(srv.Host, strconv.Itoa(srv.Port))
+	dialer := &net.Dialer{Timeout: MAIL_TIMEOUT}
+	var conn net.Conn
+	var err error
+	if srv.Tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: srv.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(MAIL_TIMEOUT))
+
+	c, err := smtp.NewClient(conn, srv.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && !srv.Tls {
+		if err = c.StartTLS(&tls.Config{ServerName: srv.Host}); err != nil {
+			return err
+		}
+	}
+	if srv.User != "" {
+		//PlainAuth refuses to send password without tls, except to localhost
+		if err = c.Auth(smtp.PlainAuth("", srv.User, srv.Pass, srv.Host)); err != nil {
+			return err
+		}
+	}
+	from, err := mail.ParseAddress(srv.From)
+	if err != nil {
+		return err
+	}
+	if err = c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err = c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %v\r\n", srv.From)
+	fmt.Fprintf(&buf, "To: %v\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	if _, err = w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// firstHit is true if no record of the token was captured before event
+func (self *WebServer) firstHit(uid int64, event *models.SessionEvent) (bool, error) {
+	token := strings.TrimPrefix(event.Var, "/")
+	if token == "" {
+		return false, nil
+	}
+	//ctime is saved in seconds, the event itself is not before
+	const layout = "2006-01-02 15:04:05"
+	t := event.Ctime.Local().Format(layout)
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	for _, typ := range searchTypes {
+		s := session.Where(`uid=?`, uid).And(`ctime<?`, t)
+		if typ == "http" {
+			s = s.In(`var`, token, "/"+token)
+		} else {
+			s = s.In(`var`, token)
+		}
+		count, err := s.Count(exporters[typ].bean())
+		if err != nil {
+			return false, err
+		} else if count > 0 {
+			return false, nil
+		}
+	}
+	//hits of the same second, eg. A and AAAA queries
+	err := self.store.Add(fmt.Sprintf("%v.first.%v", uid, token), true, MAIL_RATE_INTERVAL)
+	return err == nil, nil
+}
+
+// mailAlert send record event to email of user, by mail alert of user
+func (self *WebServer) mailAlert(uid int64, event *models.SessionEvent) {
+	if uid == 0 {
+		return
+	}
+	v, exist := self.store.Get(fmt.Sprintf("%v.user", uid))
+	if !exist {
+		return
+	}
+	user := v.(*models.TblUser)
+	if user.MailAlert == MAIL_ALERT_OFF || user.Email == "" {
+		return
+	}
+
+	self.wg.Add(1)
+	go func() {
+		defer self.wg.Done()
+		srv, err := self.mailServer()
+		if err != nil {
+			logrus.Errorf("[mail.go::mailAlert] mailServer: %v", err)
+			return
+		} else if srv.Host == "" {
+			return
+		}
+		if user.MailAlert == MAIL_ALERT_FIRST {
+			first, err := self.firstHit(uid, event)
+			if err != nil {
+				logrus.Errorf("[mail.go::mailAlert] firstHit: %v", err)
+				return
+			} else if !first {
+				return
+			}
+		}
+
+		key := fmt.Sprintf("%v.mails", uid)
+		self.store.Add(key, int64(0), MAIL_RATE_INTERVAL)
+		if n, err := self.store.IncrementInt64(key, 1); err != nil || n > MAX_USER_MAILS {
+			if n == MAX_USER_MAILS+1 {
+				logrus.Infof("[mail.go::mailAlert] user(id=%v) reached %v mails, alerts are dropped", uid, MAX_USER_MAILS)
+			}
+			return
+		}
+
+		token := strings.TrimPrefix(event.Var, "/")
+		subject := fmt.Sprintf("[godnslog] %v hit from %v", event.Type, event.Ip)
+		if token != "" {
+			subject = fmt.Sprintf("[godnslog] %v hit of %v from %v", event.Type, token, event.Ip)
+		}
+		data, _ := json.MarshalIndent(event.Data, "", "  ")
+		body := fmt.Sprintf("Type: %v\nToken: %v\nFrom: %v\nTime: %v\n\n%s\n",
+			event.Type, token, event.Ip, event.Ctime.Format(time.RFC3339), data)
+		if err = sendMail(srv, user.Email, subject, body); err != nil {
+			logrus.Infof("[mail.go::mailAlert] sendMail(%v): %v", user.Email, err)
+		}
+	}()
+}
+
+func (self *WebServer) getMailServer(c *gin.Context) {
+	srv, err := self.mailServer()
+	if err != nil {
+		logrus.Errorf("[mail.go::getMailServer] mailServer: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result: MailServer{
+			Host: srv.Host,
+			Port: srv.Port,
+			User: srv.User,
+			From: srv.From,
+			Tls:  srv.Tls,
+		},
+	})
+}
+
+func (self *WebServer) setMailServer(c *gin.Context) {
+	var req MailServer
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		logrus.Infof("[mail.go::setMailServer] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.Host != "" {
+		if req.Port <= 0 || req.Port > 65535 {
+			self.resp(c, 400, &CR{
+				Message: "port should be in [1, 65535]",
+				Code:    CodeBadData,
+			})
+			return
+		}
+		if _, err = mail.ParseAddress(req.From); err != nil {
+			self.resp(c, 400, &CR{
+				Message: "invalid from address",
+				Code:    CodeBadData,
+			})
+			return
+		}
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var srv models.TblMailServer
+	exist, err := session.ID(1).Get(&srv)
+	if err != nil {
+		logrus.Errorf("[mail.go::setMailServer] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	srv.Id = 1
+	srv.Host = req.Host
+	srv.Port = req.Port
+	srv.User = req.User
+	srv.From = req.From
+	srv.Tls = req.Tls
+	if req.Pass != "" || req.User == "" {
+		srv.Pass = req.Pass
+	}
+	if exist {
+		_, err = session.ID(1).AllCols().Update(&srv)
+	} else {
+		_, err = session.InsertOne(&srv)
+	}
+	if err != nil {
+		logrus.Errorf("[mail.go::setMailServer] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Delete(MAIL_SERVER_KEY)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// POST /api/admin/mail/test, send test mail to email of admin
+func (self *WebServer) testMailServer(c *gin.Context) {
+	srv, err := self.mailServer()
+	if err != nil {
+		logrus.Errorf("[mail.go::testMailServer] mailServer: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if srv.Host == "" {
+		self.resp(c, 400, &CR{
+			Message: "mail server not configured",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	err = sendMail(srv, c.GetString("email"), "[godnslog] test mail",
+		fmt.Sprintf("Mail server of %v is working.\n", self.Domain))
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("send failed: %v", err),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
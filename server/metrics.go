@@ -0,0 +1,193 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Prometheus metrics in text format, enabled by -metrics-token
+	GET /metrics, Authorization: Bearer ${TOKEN}
+	scrape_configs:
+	- job_name: godnslog
+	  scheme: https
+	  authorization: {credentials: "${TOKEN}"}
+	  static_configs: [{targets: ["log.example.com"]}]
+counters are of the process since start, queue depth is of shared queue if any.
+growing godnslog_store_queue_dropped_total or godnslog_store_insert_errors_total means records are lost or delayed
+*/
+
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// latencyBuckets is upper bounds of latency histograms, in seconds
+var latencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var processStart = time.Now()
+
+var (
+	metricDnsQueries    = newCounterVec("godnslog_dns_queries_total", "DNS queries by qtype.", "qtype")
+	metricHttpHits      = newCounterVec("godnslog_http_hits_total", "HTTP hits of users by scheme.", "scheme")
+	metricRecords       = newCounterVec("godnslog_records_stored_total", "Records stored by type.", "type")
+	metricInsertErrors  = newCounterVec("godnslog_store_insert_errors_total", "Failed inserts of records by type, retries included.", "type")
+	metricInsertSeconds = newHistogramVec("godnslog_store_insert_duration_seconds", "Latency of inserts of records by type.", "type")
+	metricDeliveries    = newCounterVec("godnslog_webhook_deliveries_total", "Webhook deliveries by result, after retries.", "result")
+	metricRateLimited   = newCounterVec("godnslog_api_rate_limited_total", "Api requests rejected by rate limit, by key of user or ip.", "key")
+	metricDeliverySecs  = newHistogramVec("godnslog_webhook_attempt_duration_seconds", "Latency of webhook attempts by result.", "result")
+)
+
+// counterVec is counter by value of a label
+type counterVec struct {
+	name, help, label string
+
+	lock   sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	return &counterVec{name: name, help: help, label: label, values: make(map[string]float64)}
+}
+
+func (v *counterVec) inc(value string) {
+	v.lock.Lock()
+	v.values[value]++
+	v.lock.Unlock()
+}
+
+func (v *counterVec) write(w io.Writer) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v counter\n", v.name, v.help, v.name)
+	for _, value := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%v{%v=%v} %v\n", v.name, v.label, strconv.Quote(value), v.values[value])
+	}
+}
+
+type histogram struct {
+	counts []uint64 //by bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// histogramVec is histogram of latencies by value of a label
+type histogramVec struct {
+	name, help, label string
+
+	lock   sync.Mutex
+	values map[string]*histogram
+}
+
+func newHistogramVec(name, help, label string) *histogramVec {
+	return &histogramVec{name: name, help: help, label: label, values: make(map[string]*histogram)}
+}
+
+func (v *histogramVec) observe(value string, d time.Duration) {
+	secs := d.Seconds()
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	h, exist := v.values[value]
+	if !exist {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		v.values[value] = h
+	}
+	if i := sort.SearchFloat64s(latencyBuckets, secs); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+	h.sum += secs
+	h.count++
+}
+
+func (v *histogramVec) write(w io.Writer) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v histogram\n", v.name, v.help, v.name)
+	values := make([]string, 0, len(v.values))
+	for value := range v.values {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		h := v.values[value]
+		label := fmt.Sprintf("%v=%v", v.label, strconv.Quote(value))
+		var n uint64
+		for i, le := range latencyBuckets {
+			n += h.counts[i]
+			fmt.Fprintf(w, "%v_bucket{%v,le=\"%v\"} %v\n", v.name, label, le, n)
+		}
+		fmt.Fprintf(w, "%v_bucket{%v,le=\"+Inf\"} %v\n", v.name, label, h.count)
+		fmt.Fprintf(w, "%v_sum{%v} %v\n", v.name, label, h.sum)
+		fmt.Fprintf(w, "%v_count{%v} %v\n", v.name, label, h.count)
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeMetric(w io.Writer, typ, name, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n%v %v\n", name, help, name, typ, name, value)
+}
+
+// tableType is type of record of table, eg. dns of TblDns
+func tableType(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return strings.TrimPrefix(strings.ToLower(t.Name()), "tbl")
+}
+
+// timeInsert observe latency and error of insert of records
+func timeInsert(typ string, insert func() error) error {
+	start := time.Now()
+	err := insert()
+	metricInsertSeconds.observe(typ, time.Since(start))
+	if err != nil {
+		metricInsertErrors.inc(typ)
+	}
+	return err
+}
+
+// GET /metrics
+func (self *WebServer) getMetrics(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(self.MetricsToken)) != 1 {
+		self.resp(c, 401, &CR{
+			Message: "bad metrics token",
+			Code:    CodeNoAuth,
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, v := range []*counterVec{metricDnsQueries, metricHttpHits, metricRecords, metricInsertErrors, metricDeliveries, metricRateLimited} {
+		v.write(&buf)
+	}
+	for _, v := range []*histogramVec{metricInsertSeconds, metricDeliverySecs} {
+		v.write(&buf)
+	}
+	stats := self.store.Stats()
+	writeMetric(&buf, "gauge", "godnslog_store_queue_depth", "Records waiting to be stored.", stats.Depth)
+	writeMetric(&buf, "gauge", "godnslog_store_queue_size", "Capacity of queue of records.", stats.Size)
+	writeMetric(&buf, "gauge", "godnslog_store_queue_shared", "Records in shared queue.", stats.Shared)
+	writeMetric(&buf, "counter", "godnslog_store_queue_overflows_total", "Pushes waited for full queue.", stats.Overflows)
+	writeMetric(&buf, "counter", "godnslog_store_queue_dropped_total", "Records dropped by full queue.", stats.Dropped)
+	writeMetric(&buf, "gauge", "godnslog_realtime_subscribers", "Active realtime sessions of web ui and clients.", self.realtime.count())
+	writeMetric(&buf, "gauge", "go_goroutines", "Number of goroutines.", runtime.NumGoroutine())
+	writeMetric(&buf, "gauge", "process_start_time_seconds", "Start time of the process since unix epoch in seconds.", processStart.Unix())
+	c.Data(200, METRICS_CONTENT_TYPE, buf.Bytes())
+}
@@ -0,0 +1,352 @@
+package server
+
+import (
+	"github.com/chennqqi/godnslog/models"
+	"xorm.io/xorm"
+	"xorm.io/xorm/migrate"
+)
+
+/*
+Versioned schema of the database, versions of applied migrations are recorded in table schema_version
+	godnslog migrate -list        # applied and pending versions
+	godnslog migrate              # apply pending migrations, serve applies them at start too
+	godnslog migrate -down 1      # roll back the last applied migration
+a new or unversioned database is synced to the latest models and recorded at the latest version.
+schema changes are appended to migrations with the next version and a rollback if possible, eg.
+	{
+		ID:       "0002",
+		Migrate:  func(orm *xorm.Engine) error { return orm.Sync(&models.TblDns{}) }, //new column of model
+		Rollback: func(orm *xorm.Engine) error { _, err := orm.Exec(`ALTER TABLE tbl_dns DROP COLUMN col`); return err },
+	},
+*/
+
+const (
+	SCHEMA_VERSION_TABLE  = "schema_version"
+	SCHEMA_VERSION_COLUMN = "version"
+)
+
+// tables of models, tbl_search is created by initSearch
+var schemaBeans = []interface{}{
+	&models.TblDns{}, &models.TblHttp{}, &models.TblUser{}, &models.TblHttpFile{},
+	&models.TblHttpRule{}, &models.TblPayloadFile{}, &models.TblHttpFrame{},
+	&models.TblHttpReplay{}, &models.TblSmtp{}, &models.TblLdap{},
+	&models.TblFtp{},
+	&models.TblTcp{},
+	&models.TblTcpPort{},
+	&models.TblIcmp{},
+	&models.TblSmb{},
+	&models.TblRmi{},
+	&models.TblRollup{},
+	&models.TblRollupMark{},
+	&models.TblBurpClient{},
+	&models.TblInteractsh{},
+	&models.TblToken{},
+	&models.TblWebhook{},
+	&models.TblAlertRule{},
+	&models.TblIpFilter{},
+	&models.TblDelivery{},
+	&models.TblMailServer{},
+	&models.TblRetention{},
+	&models.TblWebauthn{},
+	&models.TblLdapAuth{},
+	&models.TblInvite{},
+	&models.TblTeam{},
+	&models.TblTeamMember{},
+	&models.TblAudit{},
+	&models.TblLogin{},
+	&models.TblPasswordHash{},
+	&models.TblDomain{},
+}
+
+// migrations in order of version, applied versions must not be changed
+var migrations = []*migrate.Migration{
+	{
+		//baseline, tables synced before versioned schema
+		ID:      "0001",
+		Migrate: syncSchema,
+	},
+	{
+		//retention by record type
+		ID: "0002",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblUser{}, &models.TblRetention{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			if err := orm.DropTables(&models.TblRetention{}); err != nil {
+				return err
+			} else if orm.DriverName() == "sqlite3" {
+				//drop column is not supported by bundled sqlite, column is ignored by older versions
+				return nil
+			}
+			_, err := orm.Exec(`ALTER TABLE tbl_user DROP COLUMN retention`)
+			return err
+		},
+	},
+	{
+		//record quota of user
+		ID: "0003",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblUser{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			if orm.DriverName() == "sqlite3" {
+				return nil
+			}
+			_, err := orm.Exec(`ALTER TABLE tbl_user DROP COLUMN record_quota`)
+			return err
+		},
+	},
+	{
+		//disabled user
+		ID: "0004",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblUser{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			if orm.DriverName() == "sqlite3" {
+				return nil
+			}
+			_, err := orm.Exec(`ALTER TABLE tbl_user DROP COLUMN disabled`)
+			return err
+		},
+	},
+	{
+		//two-factor login
+		ID: "0005",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblUser{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			if orm.DriverName() == "sqlite3" {
+				return nil
+			}
+			_, err := orm.Exec(`ALTER TABLE tbl_user DROP COLUMN totp_secret, DROP COLUMN totp_recovery`)
+			return err
+		},
+	},
+	{
+		//webauthn credentials
+		ID: "0006",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblWebauthn{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			return orm.DropTables(&models.TblWebauthn{})
+		},
+	},
+	{
+		//single sign-on accounts
+		ID: "0007",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblUser{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			if orm.DriverName() == "sqlite3" {
+				return nil
+			}
+			_, err := orm.Exec(`ALTER TABLE tbl_user DROP COLUMN oidc_sub`)
+			return err
+		},
+	},
+	{
+		//ldap login
+		ID: "0008",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblUser{}, &models.TblLdapAuth{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			if err := orm.DropTables(&models.TblLdapAuth{}); err != nil {
+				return err
+			}
+			if orm.DriverName() == "sqlite3" {
+				return nil
+			}
+			_, err := orm.Exec(`ALTER TABLE tbl_user DROP COLUMN ldap_dn`)
+			return err
+		},
+	},
+	{
+		//invite codes of public signup
+		ID: "0009",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblInvite{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			return orm.DropTables(&models.TblInvite{})
+		},
+	},
+	{
+		//teams sharing records
+		ID: "0010",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblTeam{}, &models.TblTeamMember{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			return orm.DropTables(&models.TblTeam{}, &models.TblTeamMember{})
+		},
+	},
+	{
+		ID: "0011",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblAudit{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			return orm.DropTables(&models.TblAudit{})
+		},
+	},
+	{
+		ID: "0012",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblLogin{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			return orm.DropTables(&models.TblLogin{})
+		},
+	},
+	{
+		ID: "0013",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblPasswordHash{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			return orm.DropTables(&models.TblPasswordHash{})
+		},
+	},
+	{
+		ID: "0014",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblDomain{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			return orm.DropTables(&models.TblDomain{})
+		},
+	},
+	{
+		//pending and approved burp clients, existing ones are pending
+		ID: "0015",
+		Migrate: func(orm *xorm.Engine) error {
+			return orm.Sync(&models.TblBurpClient{})
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			if orm.DriverName() == "sqlite3" {
+				return nil
+			}
+			_, err := orm.Exec(`ALTER TABLE tbl_burp_client DROP COLUMN ip, DROP COLUMN bound, DROP COLUMN btime`)
+			return err
+		},
+	},
+	{
+		//team members are invited, owners are accepted and existing members are invited again
+		ID: "0016",
+		Migrate: func(orm *xorm.Engine) error {
+			if err := orm.Sync(&models.TblTeamMember{}); err != nil {
+				return err
+			}
+			_, err := orm.Exec(`UPDATE tbl_team_member SET accepted=? WHERE EXISTS `+
+				`(SELECT 1 FROM tbl_team WHERE tbl_team.id=tbl_team_member.tid AND tbl_team.uid=tbl_team_member.uid)`, true)
+			return err
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			if orm.DriverName() == "sqlite3" {
+				return nil
+			}
+			_, err := orm.Exec(`ALTER TABLE tbl_team_member DROP COLUMN accepted`)
+			return err
+		},
+	},
+	{
+		//refresh tokens are versioned instead of password print, tokens issued before are denied
+		ID: "0017",
+		Migrate: func(orm *xorm.Engine) error {
+			if err := orm.Sync(&models.TblUser{}); err != nil {
+				return err
+			}
+			_, err := orm.Exec(`UPDATE tbl_user SET token_version=1`)
+			return err
+		},
+		Rollback: func(orm *xorm.Engine) error {
+			if orm.DriverName() == "sqlite3" {
+				return nil
+			}
+			_, err := orm.Exec(`ALTER TABLE tbl_user DROP COLUMN token_version`)
+			return err
+		},
+	},
+}
+
+func syncSchema(orm *xorm.Engine) error {
+	return orm.Sync(schemaBeans...)
+}
+
+func newMigrate(orm *xorm.Engine) *migrate.Migrate {
+	m := migrate.New(orm, &migrate.Options{
+		TableName:    SCHEMA_VERSION_TABLE,
+		IDColumnName: SCHEMA_VERSION_COLUMN,
+	}, migrations)
+	m.InitSchema(syncSchema)
+	return m
+}
+
+type SchemaMigrator struct {
+	orm *xorm.Engine
+	m   *migrate.Migrate
+}
+
+func NewSchemaMigrator(driver, dsn string) (*SchemaMigrator, error) {
+	orm, err := xorm.NewEngine(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = orm.Ping()
+	if err != nil {
+		orm.Close()
+		return nil, err
+	}
+	return &SchemaMigrator{orm: orm, m: newMigrate(orm)}, nil
+}
+
+// Up apply pending migrations
+func (s *SchemaMigrator) Up() error {
+	return s.m.Migrate()
+}
+
+// Down roll back last n applied migrations
+func (s *SchemaMigrator) Down(n int) error {
+	for i := 0; i < n; i++ {
+		if err := s.m.RollbackLast(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Versions is applied and pending versions, all are pending if schema is not versioned
+func (s *SchemaMigrator) Versions() (applied, pending []string, err error) {
+	exist, err := s.orm.IsTableExist(SCHEMA_VERSION_TABLE)
+	if err != nil {
+		return nil, nil, err
+	}
+	done := make(map[string]bool)
+	if exist {
+		var versions []string
+		err = s.orm.Table(SCHEMA_VERSION_TABLE).Cols(SCHEMA_VERSION_COLUMN).Find(&versions)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, v := range versions {
+			done[v] = true
+		}
+	}
+	for _, mig := range migrations {
+		if done[mig.ID] {
+			applied = append(applied, mig.ID)
+		} else {
+			pending = append(pending, mig.ID)
+		}
+	}
+	return applied, pending, nil
+}
+
+func (s *SchemaMigrator) Close() error {
+	return s.orm.Close()
+}
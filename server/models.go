@@ -16,12 +16,15 @@ const (
 	CodeServerInternal = models.CodeServerInternal
 	CodeNoData         = models.CodeNoData
 	CodeExpire         = models.CodeExpire
+	CodeQuota          = models.CodeQuota
+	CodeTotpRequired   = models.CodeTotpRequired
 )
 
 const (
-	roleSuper  = models.RoleSuper
-	roleAdmin  = models.RoleAdmin
-	roleNormal = models.RoleNormal
+	roleSuper    = models.RoleSuper
+	roleAdmin    = models.RoleAdmin
+	roleOperator = models.RoleOperator
+	roleViewer   = models.RoleViewer
 )
 
 type LoginRequest models.LoginRequest
@@ -36,10 +39,48 @@ type HttpRecordResp models.HttpRecordResp
 type UserListResp models.UserListResp
 type AppSetting models.AppSetting
 type DeleteRecordRequest models.DeleteRecordRequest
+type PurgeRequest models.PurgeRequest
+type TagRequest models.TagRequest
+type NoteRequest models.NoteRequest
 type AppSecurity models.AppSecurity
 type AppSecuritySet models.AppSecuritySet
 type DnsRecord models.DnsRecord
 type HttpRecord models.HttpRecord
+type HttpFile models.HttpFile
+type SmtpRecord models.SmtpRecord
+type SmtpRecordResp models.SmtpRecordResp
+type LdapRecord models.LdapRecord
+type LdapRecordResp models.LdapRecordResp
+type FtpRecord models.FtpRecord
+type FtpRecordResp models.FtpRecordResp
+type TcpRecord models.TcpRecord
+type TcpRecordResp models.TcpRecordResp
+type TcpPort models.TcpPort
+type MailServer models.MailServer
+type IcmpRecord models.IcmpRecord
+type IcmpRecordResp models.IcmpRecordResp
+type SmbRecord models.SmbRecord
+type SmbRecordResp models.SmbRecordResp
+type RmiRecord models.RmiRecord
+type RmiRecordResp models.RmiRecordResp
+type SearchResp models.SearchResp
+type HttpFrame models.HttpFrame
+type HttpReplayRequest models.HttpReplayRequest
+type HttpReplay models.HttpReplay
+type HttpRule models.HttpRule
+type ApiToken models.ApiToken
+type RotateTokenRequest models.RotateTokenRequest
+type Webhook models.Webhook
+type RelayRecord models.RelayRecord
+type AlertRule models.AlertRule
+type IpFilter models.IpFilter
+type Invite models.Invite
+type Team models.Team
+type TeamMember models.TeamMember
+type Delivery models.Delivery
+type DeliveryResp models.DeliveryResp
+type PayloadFile models.PayloadFile
+type PayloadFileResp models.PayloadFileResp
 
 // commone response
 type CR models.CR
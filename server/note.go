@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Record notes, eg. which target and parameter produced the hit
+	PUT /api/data/http/:id/note {"note":"login.php?next="}
+*/
+
+const (
+	MAX_NOTE_SIZE = 4096
+)
+
+// noteTables is record type => table with note to update
+var noteTables = map[string]func(string) interface{}{
+	"dns":  func(note string) interface{} { return &models.TblDns{Note: note} },
+	"http": func(note string) interface{} { return &models.TblHttp{Note: note} },
+	"smtp": func(note string) interface{} { return &models.TblSmtp{Note: note} },
+	"ldap": func(note string) interface{} { return &models.TblLdap{Note: note} },
+	"ftp":  func(note string) interface{} { return &models.TblFtp{Note: note} },
+	"tcp":  func(note string) interface{} { return &models.TblTcp{Note: note} },
+	"icmp": func(note string) interface{} { return &models.TblIcmp{Note: note} },
+	"smb":  func(note string) interface{} { return &models.TblSmb{Note: note} },
+	"rmi":  func(note string) interface{} { return &models.TblRmi{Note: note} },
+}
+
+// setRecordNote replace note of record, empty note remove it
+func (self *WebServer) setRecordNote(bean func(string) interface{}) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rid, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			self.resp(c, 400, &CR{
+				Message: "invalid Param",
+				Code:    CodeBadData,
+			})
+			return
+		}
+		var req NoteRequest
+		err = c.ShouldBindJSON(&req)
+		if err != nil {
+			self.resp(c, 400, &CR{
+				Message: "invalid Param",
+				Code:    CodeBadData,
+			})
+			return
+		}
+		if len(req.Note) > MAX_NOTE_SIZE {
+			self.resp(c, 400, &CR{
+				Message: fmt.Sprintf("note should be less than %v bytes", MAX_NOTE_SIZE),
+				Code:    CodeBadData,
+			})
+			return
+		}
+
+		if !self.updateRecord(c, rid, bean(""), bean(req.Note), "note") {
+			return
+		}
+		self.resp(c, 200, &CR{
+			Message: "OK",
+		})
+	}
+}
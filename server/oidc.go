@@ -0,0 +1,649 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Single sign-on by an OpenID Connect provider, eg. Keycloak, Google, Azure AD, alongside local passwords
+	-oidc-issuer https://keycloak.example.com/realms/ops -oidc-client-id godnslog -oidc-client-secret xxx
+	-oidc-role-claim groups -oidc-roles godnslog-admins=admin,godnslog-users=operator -oidc-auto-create
+	GET /api/auth/oidc           => {"enabled":true,"url":"/api/auth/oidc/login"}
+	GET /api/auth/oidc/login     => redirect to provider, authorization code flow with PKCE
+	GET /api/auth/oidc/callback  => page saves token of console, then redirect to it
+	POST /api/setting/security/oidc => url of provider linking the account of logged in user
+state is bound to the browser by a cookie of the callback path, callbacks of logins started elsewhere are denied.
+accounts are linked by subject, users of same verified email are linked at first login unless they have a second
+factor, those link from their logged in session as the second factor is left to provider. others are created
+with -oidc-auto-create. with -oidc-roles, role of users is synchronized by values of the role claim at login,
+users without a mapped value are denied. super admin is never changed.
+*/
+
+const (
+	OIDC_TIMEOUT        = 10 * time.Second
+	OIDC_LOGIN_EXPIRE   = 10 * time.Minute
+	OIDC_JWKS_REFRESH   = time.Minute //min interval to refetch keys of unknown kid
+	OIDC_CALLBACK_PATH  = "/api/auth/oidc/callback"
+	OIDC_STATE_COOKIE   = "oidc_state" //binds state to the browser starting the login
+	OIDC_DEFAULT_SCOPES = "openid,email,profile"
+	OIDC_DEFAULT_CLAIM  = "groups"
+)
+
+var (
+	oidcClient = &http.Client{Timeout: OIDC_TIMEOUT}
+
+	oidcNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+	oidcPage = template.Must(template.New("oidc").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>godnslog</title></head><body><script>
+localStorage.setItem("Access-Token", JSON.stringify({{.Token}}));
+location.replace({{.Url}});
+</script></body></html>
+`))
+)
+
+type OidcConfig struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string //empty for public client, PKCE only
+	RedirectUrl  string //empty: callback of -ui-url or host of request
+	Scopes       []string
+
+	//dotted path of claim with roles, eg. groups, realm_access.roles
+	RoleClaim string
+	//values of role claim => role, role is not synchronized if empty
+	Roles map[string]int
+	//create users of unknown accounts
+	AutoCreate bool
+}
+
+// ParseOidcRoles parse comma separated value=role, role is admin, operator or viewer
+func ParseOidcRoles(s string) (map[string]int, error) {
+	roles := make(map[string]int)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("%q is not value=role", item)
+		}
+		role, err := ParseRole(strings.TrimSpace(item[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		roles[strings.TrimSpace(item[:i])] = role
+	}
+	return roles, nil
+}
+
+// OidcProvider is discovery and keys of issuer, fetched at first login
+type OidcProvider struct {
+	OidcConfig
+
+	lock      sync.Mutex
+	authUrl   string
+	tokenUrl  string
+	jwksUrl   string
+	keys      map[string]crypto.PublicKey
+	refreshed time.Time
+}
+
+func NewOidcProvider(cfg *OidcConfig) *OidcProvider {
+	p := &OidcProvider{OidcConfig: *cfg}
+	p.Issuer = strings.TrimSuffix(p.Issuer, "/")
+	if len(p.Scopes) == 0 {
+		p.Scopes = strings.Split(OIDC_DEFAULT_SCOPES, ",")
+	}
+	if p.RoleClaim == "" {
+		p.RoleClaim = OIDC_DEFAULT_CLAIM
+	}
+	return p
+}
+
+// oidcLogin is state of an authorization request, used once
+type oidcLogin struct {
+	Nonce       string
+	Verifier    string
+	RedirectUrl string
+	Link        int64 //uid of logged in user linking its account, 0 of login
+}
+
+func getJson(rawurl string, v interface{}) error {
+	resp, err := oidcClient.Get(rawurl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v: %v", rawurl, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover endpoints of issuer, once succeeded
+func (p *OidcProvider) discover() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.tokenUrl != "" {
+		return nil
+	}
+	var doc struct {
+		Issuer   string `json:"issuer"`
+		AuthUrl  string `json:"authorization_endpoint"`
+		TokenUrl string `json:"token_endpoint"`
+		JwksUrl  string `json:"jwks_uri"`
+	}
+	if err := getJson(p.Issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return err
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.Issuer {
+		return fmt.Errorf("issuer of discovery not match: %v", doc.Issuer)
+	}
+	if doc.AuthUrl == "" || doc.TokenUrl == "" || doc.JwksUrl == "" {
+		return errors.New("endpoints missing in discovery")
+	}
+	p.authUrl, p.tokenUrl, p.jwksUrl = doc.AuthUrl, doc.TokenUrl, doc.JwksUrl
+	return nil
+}
+
+// key of kid, keys are refetched if kid is unknown
+func (p *OidcProvider) key(kid string) (crypto.PublicKey, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if key, exist := p.keys[kid]; exist {
+		return key, nil
+	}
+	if time.Since(p.refreshed) < OIDC_JWKS_REFRESH {
+		return nil, fmt.Errorf("unknown key id: %v", kid)
+	}
+	p.refreshed = time.Now()
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJson(p.jwksUrl, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := decodeBase64url(k.N)
+			e, err2 := decodeBase64url(k.E)
+			if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			var exp int
+			for _, c := range e {
+				exp = exp<<8 | int(c)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err1 := decodeBase64url(k.X)
+			y, err2 := decodeBase64url(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if curve.IsOnCurve(pub.X, pub.Y) {
+				keys[k.Kid] = pub
+			}
+		}
+	}
+	p.keys = keys
+	if key, exist := keys[kid]; exist {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id: %v", kid)
+}
+
+// exchange code for id token
+func (p *OidcProvider) exchange(code, redirectUrl, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectUrl},
+		"code_verifier": {verifier},
+		"client_id":     {p.ClientId},
+	}
+	req, err := http.NewRequest("POST", p.tokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientId), url.QueryEscape(p.ClientSecret))
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	var result struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	json.Unmarshal(body, &result)
+	if result.Error != "" {
+		return "", fmt.Errorf("token endpoint: %v %v", result.Error, result.ErrorDescription)
+	} else if resp.StatusCode != 200 || result.IdToken == "" {
+		return "", fmt.Errorf("token endpoint: %v, no id token", resp.Status)
+	}
+	return result.IdToken, nil
+}
+
+// verify signature, issuer, audience, expiration and nonce of id token
+func (p *OidcProvider) verify(idToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodRSAPSS:
+		default:
+			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+		}
+		kid, _ := t.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.Issuer {
+		return nil, fmt.Errorf("issuer not match: %v", iss)
+	}
+	if !oidcHasValue(claims["aud"], p.ClientId) {
+		return nil, fmt.Errorf("audience not match: %v", claims["aud"])
+	}
+	if _, exist := claims["exp"]; !exist {
+		return nil, errors.New("expiration missing")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("nonce not match")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("subject missing")
+	}
+	return claims, nil
+}
+
+// oidcClaim get value of dotted path, eg. realm_access.roles
+func oidcClaim(claims map[string]interface{}, path string) interface{} {
+	var v interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// oidcHasValue check claim of a string or array of strings contains s
+func oidcHasValue(claim interface{}, s string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == s
+	case []interface{}:
+		for _, item := range v {
+			if str, ok := item.(string); ok && str == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// role mapped by role claim, highest of matched values
+func (p *OidcProvider) role(claims jwt.MapClaims) (int, bool) {
+	claim := oidcClaim(claims, p.RoleClaim)
+	role, matched := roleViewer, false
+	for value, r := range p.Roles {
+		if oidcHasValue(claim, value) {
+			matched = true
+			if r < role {
+				role = r
+			}
+		}
+	}
+	return role, matched
+}
+
+// oidcRedirectUrl is callback url of -oidc-redirect-url, -ui-url or host of request
+func (self *WebServer) oidcRedirectUrl(c *gin.Context) string {
+	if self.Oidc.RedirectUrl != "" {
+		return self.Oidc.RedirectUrl
+	}
+	if uiUrl := self.notify().UiUrl; uiUrl != "" {
+		return strings.TrimSuffix(uiUrl, "/") + OIDC_CALLBACK_PATH
+	}
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	} else if host, _, err := net.SplitHostPort(c.Request.RemoteAddr); err == nil &&
+		self.TrustedProxies.Trusted(net.ParseIP(host)) && c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + OIDC_CALLBACK_PATH
+}
+
+// GET /api/auth/oidc
+func (self *WebServer) oidcInfo(c *gin.Context) {
+	info := models.OidcInfo{Enabled: self.Oidc != nil}
+	if info.Enabled {
+		info.Url = "/api/auth/oidc/login"
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  info,
+	})
+}
+
+// oidcAuthUrl start login of provider, link is uid of logged in user linking its account. state is set as cookie
+func (self *WebServer) oidcAuthUrl(c *gin.Context, link int64) (string, bool) {
+	if self.Oidc == nil {
+		self.resp(c, 404, &CR{
+			Message: "single sign-on not enabled",
+			Code:    CodeNoData,
+		})
+		return "", false
+	}
+	if err := self.Oidc.discover(); err != nil {
+		logrus.Errorf("[oidc.go::oidcAuthUrl] discover: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "provider not available",
+			Code:    CodeServerInternal,
+		})
+		return "", false
+	}
+
+	state, nonce, verifier := genRandomString(32), genRandomString(32), genRandomString(64)
+	login := &oidcLogin{
+		Nonce:       nonce,
+		Verifier:    verifier,
+		RedirectUrl: self.oidcRedirectUrl(c),
+		Link:        link,
+	}
+	self.store.Set(state+".oidc", login, OIDC_LOGIN_EXPIRE)
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     OIDC_STATE_COOKIE,
+		Value:    state,
+		Path:     OIDC_CALLBACK_PATH,
+		MaxAge:   int(OIDC_LOGIN_EXPIRE / time.Second),
+		Secure:   strings.HasPrefix(login.RedirectUrl, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {self.Oidc.ClientId},
+		"redirect_uri":          {login.RedirectUrl},
+		"scope":                 {strings.Join(self.Oidc.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {webauthnEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	authUrl := self.Oidc.authUrl
+	if strings.Contains(authUrl, "?") {
+		authUrl += "&" + q.Encode()
+	} else {
+		authUrl += "?" + q.Encode()
+	}
+	return authUrl, true
+}
+
+// GET /api/auth/oidc/login
+func (self *WebServer) oidcLogin(c *gin.Context) {
+	if authUrl, ok := self.oidcAuthUrl(c, 0); ok {
+		c.Redirect(302, authUrl)
+	}
+}
+
+// POST /api/setting/security/oidc, url of provider linking logged in user, state cookie is set for its browser
+func (self *WebServer) oidcLink(c *gin.Context) {
+	if authUrl, ok := self.oidcAuthUrl(c, c.GetInt64("id")); ok {
+		self.resp(c, 200, &CR{
+			Message: "OK",
+			Result:  authUrl,
+		})
+	}
+}
+
+// hasSecondFactor is true if user logs in with totp or passkeys
+func (self *WebServer) hasSecondFactor(user *models.TblUser) (bool, error) {
+	if user.TotpSecret != "" {
+		return true, nil
+	}
+	return self.orm.Where(`uid=?`, user.Id).Exist(&models.TblWebauthn{})
+}
+
+// oidcUser find user of subject, link or create it, role is synchronized. link is uid of logged in user to link
+func (self *WebServer) oidcUser(claims jwt.MapClaims, link int64) (*models.TblUser, error) {
+	sub, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	verified, _ := claims["email_verified"].(bool)
+	role, matched := self.Oidc.role(claims)
+	if len(self.Oidc.Roles) > 0 && !matched {
+		return nil, fmt.Errorf("no role of %v in claim %v", sub, self.Oidc.RoleClaim)
+	}
+
+	user := new(models.TblUser)
+	exist, err := self.orm.Where(`oidc_sub=?`, sub).Get(user)
+	if err != nil {
+		return nil, err
+	}
+	if link > 0 {
+		if exist && user.Id != link {
+			return nil, fmt.Errorf("%v linked to user %v", sub, user.Name)
+		}
+		user = new(models.TblUser)
+		if exist, err = self.orm.ID(link).Get(user); err != nil {
+			return nil, err
+		} else if !exist {
+			return nil, fmt.Errorf("no user of id %v", link)
+		}
+		if user.OidcSub != sub {
+			if user.OidcSub != "" {
+				return nil, fmt.Errorf("user %v linked to other account", user.Name)
+			}
+			user.OidcSub = sub
+			if _, err = self.orm.ID(user.Id).Cols("oidc_sub").Update(user); err != nil {
+				return nil, err
+			}
+			logrus.Infof("[oidc.go::oidcUser] user %v linked to %v by its session", user.Name, sub)
+		}
+	} else if !exist && email != "" && verified {
+		exist, err = self.orm.Where(`email=?`, email).Get(user)
+		if err != nil {
+			return nil, err
+		} else if exist {
+			if user.OidcSub != "" {
+				return nil, fmt.Errorf("user %v linked to other account", user.Name)
+			}
+			//the second factor would be skipped by whoever controls the email at provider
+			if mfa, err := self.hasSecondFactor(user); err != nil {
+				return nil, err
+			} else if mfa {
+				return nil, fmt.Errorf("user %v has a second factor, link from its settings", user.Name)
+			}
+			user.OidcSub = sub
+			if _, err = self.orm.ID(user.Id).Cols("oidc_sub").Update(user); err != nil {
+				return nil, err
+			}
+			logrus.Infof("[oidc.go::oidcUser] user %v linked to %v", user.Name, sub)
+		}
+	}
+
+	if exist {
+		if matched && user.Role != roleSuper && user.Role != role {
+			user.Role = role
+			if _, err = self.orm.ID(user.Id).Cols("role").Update(user); err != nil {
+				return nil, err
+			}
+			logrus.Infof("[oidc.go::oidcUser] role of %v changed to %v", user.Name, role)
+		}
+		return user, nil
+	}
+	if !self.Oidc.AutoCreate {
+		return nil, fmt.Errorf("no user of %v(%v)", sub, email)
+	}
+
+	name, _ := claims["preferred_username"].(string)
+	if name == "" && email != "" {
+		name = strings.SplitN(email, "@", 2)[0]
+	}
+	name = oidcNameRegexp.ReplaceAllString(name, "")
+	if name == "" {
+		name = "sso-" + genRandomString(8)
+	} else if len(name) > 48 {
+		name = name[:48]
+	}
+	if exist, err := self.orm.Where(`name=?`, name).Exist(&models.TblUser{}); err != nil {
+		return nil, err
+	} else if exist {
+		name += "-" + genRandomString(4)
+	}
+	if email == "" || !verified {
+		//email is unique, unverified ones could take address of others
+		u, _ := url.Parse(self.Oidc.Issuer)
+		email = name + "@" + u.Hostname()
+	}
+	user = self.newExternalUser(name, email, role)
+	user.OidcSub = sub
+	if _, err = self.orm.InsertOne(user); err != nil {
+		return nil, err
+	}
+	logrus.Infof("[oidc.go::oidcUser] user %v of %v created", user.Name, sub)
+	return user, nil
+}
+
+// GET /api/auth/oidc/callback
+func (self *WebServer) oidcCallback(c *gin.Context) {
+	if self.Oidc == nil {
+		self.resp(c, 404, &CR{
+			Message: "single sign-on not enabled",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	if e := c.Query("error"); e != "" {
+		logrus.Infof("[oidc.go::oidcCallback] provider error: %v %v", e, c.Query("error_description"))
+		self.respData(c, 401, CodeBadData, "single sign-on failed", nil)
+		return
+	}
+	state := c.Query("state")
+	cookie, _ := c.Cookie(OIDC_STATE_COOKIE)
+	http.SetCookie(c.Writer, &http.Cookie{Name: OIDC_STATE_COOKIE, Path: OIDC_CALLBACK_PATH, MaxAge: -1})
+	if state == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(state)) != 1 {
+		logrus.Infof("[oidc.go::oidcCallback] state not of this browser")
+		self.respData(c, 401, CodeBadData, "single sign-on failed", nil)
+		return
+	}
+	key := state + ".oidc"
+	v, exist := self.store.Get(key)
+	if !exist {
+		self.respData(c, 401, CodeExpire, "login expired, try again", nil)
+		return
+	}
+	self.store.Delete(key)
+	login := v.(*oidcLogin)
+
+	if err := self.Oidc.discover(); err != nil {
+		logrus.Errorf("[oidc.go::oidcCallback] discover: %v", err)
+		self.respData(c, 502, CodeServerInternal, "provider not available", nil)
+		return
+	}
+	idToken, err := self.Oidc.exchange(c.Query("code"), login.RedirectUrl, login.Verifier)
+	if err != nil {
+		logrus.Errorf("[oidc.go::oidcCallback] exchange: %v", err)
+		self.respData(c, 401, CodeBadData, "single sign-on failed", nil)
+		return
+	}
+	claims, err := self.Oidc.verify(idToken, login.Nonce)
+	if err != nil {
+		logrus.Infof("[oidc.go::oidcCallback] verify: %v", err)
+		self.respData(c, 401, CodeBadData, "single sign-on failed", nil)
+		return
+	}
+	user, err := self.oidcUser(claims, login.Link)
+	if err != nil {
+		logrus.Infof("[oidc.go::oidcCallback] oidcUser: %v", err)
+		self.respData(c, 403, CodeNoPermission, "no permission", nil)
+		return
+	}
+	auditUser(c, user)
+	if user.Disabled {
+		logrus.Infof("[oidc.go::oidcCallback] user %v disabled", user.Name)
+		self.respData(c, 403, CodeNoPermission, "user disabled", nil)
+		return
+	}
+
+	token, err := self.newToken(c, user)
+	if err != nil {
+		logrus.Errorf("[oidc.go::oidcCallback] newToken: %v", err)
+		self.respData(c, 502, CodeServerInternal, "bad service", nil)
+		return
+	}
+	logrus.Infof("[oidc.go::oidcCallback] user %v login by single sign-on", user.Name)
+	home := "/"
+	if uiUrl := self.notify().UiUrl; uiUrl != "" {
+		home = uiUrl
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(200)
+	oidcPage.Execute(c.Writer, map[string]string{"Token": token, "Url": home})
+}
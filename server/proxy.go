@@ -0,0 +1,262 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Real source ip of clients behind nginx, cdn or l4 balancer
+	-trusted-proxies 10.0.0.0/8,127.0.0.1
+X-Forwarded-For and X-Real-IP of http requests are honored only if the peer is a trusted proxy,
+the client ip is the rightmost untrusted address of X-Forwarded-For.
+With -proxy-protocol, connections of trusted proxies to http and tcp listeners may start with a
+PROXY protocol v1 or v2 header, eg. haproxy send-proxy, nginx proxy_protocol on,
+	PROXY TCP4 203.0.113.7 192.0.2.1 51234 25\r\n
+other peers are never parsed, their headers can not forge source ip.
+*/
+
+const (
+	PROXY_HEADER_TIMEOUT = 5 * time.Second
+	MAX_PROXY_V1_HEADER  = 107
+)
+
+var (
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errProxyHeader = errors.New("invalid proxy protocol header")
+)
+
+// TrustedProxies are peers allowed to forward source ip of clients, nil trusts none
+type TrustedProxies struct {
+	nets []*net.IPNet
+
+	//accept PROXY protocol header of trusted proxies on listeners
+	ProxyProtocol bool
+}
+
+// ParseTrustedProxies parse comma separated ip or cidr, nil if empty
+func ParseTrustedProxies(s string) (*TrustedProxies, error) {
+	var p TrustedProxies
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ipnet, err := parseCidr(item)
+		if err != nil {
+			return nil, err
+		}
+		p.nets = append(p.nets, ipnet)
+	}
+	if len(p.nets) == 0 {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+func (p *TrustedProxies) Trusted(ip net.IP) bool {
+	if p == nil || ip == nil {
+		return false
+	}
+	return matchNets(p.nets, ip)
+}
+
+// ClientIP of request, forwarded headers are honored if the peer is trusted
+func (p *TrustedProxies) ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	if !p.Trusted(net.ParseIP(remote)) {
+		return remote
+	}
+
+	//rightmost untrusted hop, leftmost one if all of them are trusted
+	var client string
+	if header := strings.Join(r.Header.Values("X-Forwarded-For"), ","); header != "" {
+		hops := strings.Split(header, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			client = ip.String()
+			if !p.Trusted(ip) {
+				break
+			}
+		}
+	}
+	if client != "" {
+		return client
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return remote
+}
+
+// Listener accept PROXY protocol header of trusted proxies if enabled, l itself otherwise
+func (p *TrustedProxies) Listener(l net.Listener) net.Listener {
+	if p == nil || !p.ProxyProtocol {
+		return l
+	}
+	return &proxyListener{Listener: l, proxies: p}
+}
+
+type proxyListener struct {
+	net.Listener
+	proxies *TrustedProxies
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	addr, ok := c.RemoteAddr().(*net.TCPAddr)
+	if !ok || !l.proxies.Trusted(addr.IP) {
+		return c, nil
+	}
+	return &proxyConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// proxyConn read header at first Read or RemoteAddr, in goroutine of connection instead of Accept
+type proxyConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.remote = c.Conn.RemoteAddr()
+	c.Conn.SetReadDeadline(time.Now().Add(PROXY_HEADER_TIMEOUT))
+	addr, err := readProxyHeader(c.r)
+	c.Conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		logrus.Warnf("[proxy.go::init] %v from %v", err, c.remote)
+		c.err = err
+		return
+	}
+	if addr != nil {
+		c.remote = addr
+	}
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.init)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.init)
+	return c.remote
+}
+
+// readProxyHeader read v1 or v2 header, source address is nil if there is no header, or of LOCAL and UNKNOWN
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		//closed without data, or nothing sent by client of server first protocol
+		return nil, nil
+	}
+	switch b[0] {
+	case 'P':
+		if b, err := r.Peek(6); err != nil || string(b) != "PROXY " {
+			return nil, nil
+		}
+		return readProxyV1(r)
+	case '\r':
+		if b, err := r.Peek(len(proxyV2Signature)); err != nil || !bytes.Equal(b, proxyV2Signature) {
+			return nil, nil
+		}
+		return readProxyV2(r)
+	}
+	return nil, nil
+}
+
+// PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < MAX_PROXY_V1_HEADER {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, errProxyHeader
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// signature(12) version|command(1) family|protocol(1) length(2) addresses tlvs
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, errProxyHeader
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported proxy protocol version: %v", hdr[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, errProxyHeader
+	}
+
+	//LOCAL command is health check of proxy itself
+	if hdr[12]&0xf == 0 {
+		return nil, nil
+	}
+	var iplen int
+	switch hdr[13] >> 4 {
+	case 1:
+		iplen = net.IPv4len
+	case 2:
+		iplen = net.IPv6len
+	default:
+		//AF_UNSPEC and AF_UNIX
+		return nil, nil
+	}
+	if len(body) < 2*iplen+4 {
+		return nil, errProxyHeader
+	}
+	ip := make(net.IP, iplen)
+	copy(ip, body)
+	port := binary.BigEndian.Uint16(body[2*iplen:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
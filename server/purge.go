@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Purge all data of the calling user, for end-of-engagement cleanup, password is required again
+	DELETE /api/data/all {"password": "..."}
+records of all types, captured and uploaded files, websocket frames, replays, webhook deliveries,
+rollups and search index are deleted in a transaction. settings, rules, tokens and webhooks are kept.
+hits in clickhouse and files of blob store are deleted after commit
+*/
+
+// tables of user data purged besides records
+var purgeBeans = []interface{}{
+	&models.TblHttpFile{},
+	&models.TblHttpFrame{},
+	&models.TblHttpReplay{},
+	&models.TblPayloadFile{},
+	&models.TblDelivery{},
+	&models.TblRollup{},
+}
+
+// purgeUser delete all data of user, count of deleted rows by table is returned
+func (self *WebServer) purgeUser(uid int64) (map[string]int64, error) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var blobs, files []string
+	err := session.Table(&models.TblHttpFile{}).Where(`uid=?`, uid).Cols("blob").Find(&blobs)
+	if err != nil {
+		return nil, err
+	}
+	err = session.Table(&models.TblPayloadFile{}).Where(`uid=?`, uid).Cols("blob").Find(&files)
+	if err != nil {
+		return nil, err
+	}
+	blobs = append(blobs, files...)
+
+	if err = session.Begin(); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64)
+	for _, typ := range searchTypes {
+		if self.inClickHouse(typ) {
+			continue
+		}
+		bean := exporters[typ].bean()
+		n, err := session.Where(`uid=?`, uid).Delete(bean)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", typ, err)
+		}
+		counts[self.orm.TableName(bean)] = n
+	}
+	for _, bean := range purgeBeans {
+		n, err := session.Where(`uid=?`, uid).Delete(bean)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", self.orm.TableName(bean), err)
+		}
+		counts[self.orm.TableName(bean)] = n
+	}
+	if _, err = session.Exec(`DELETE FROM tbl_search WHERE uid = ?`, uid); err != nil {
+		return nil, fmt.Errorf("tbl_search: %v", err)
+	}
+	if err = session.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, typ := range searchTypes {
+		if !self.inClickHouse(typ) {
+			continue
+		}
+		bean := exporters[typ].bean()
+		n, err := self.deleteHits(session.Where(`uid=?`, uid), bean)
+		if err != nil {
+			return counts, fmt.Errorf("%v: %v", typ, err)
+		}
+		counts[self.orm.TableName(bean)] = n
+	}
+	for _, key := range blobs {
+		if err = self.blob.Delete(key); err != nil {
+			logrus.Errorf("[purge.go::purgeUser] blob.Delete(%v): %v", key, err)
+		}
+	}
+	self.store.Delete(fmt.Sprintf("%v.overquota", uid))
+	return counts, nil
+}
+
+// DELETE /api/data/all
+func (self *WebServer) purgeData(c *gin.Context) {
+	var req PurgeRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.Password == "" {
+		logrus.Infof("[purge.go::purgeData] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "password required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var user models.TblUser
+	exist, err := session.ID(id).Get(&user)
+	if err != nil {
+		logrus.Errorf("[purge.go::purgeData] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if !exist || comparePassword(req.Password, user.Pass) != nil {
+		logrus.Infof("[purge.go::purgeData] password not match of user(id=%v)", id)
+		self.resp(c, 403, &CR{
+			Message: "password not match",
+			Code:    CodeBadPermission,
+		})
+		return
+	}
+
+	counts, err := self.purgeUser(id)
+	if err != nil {
+		logrus.Errorf("[purge.go::purgeData] purgeUser(%v): %v", id, err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+			Result:  counts,
+		})
+		return
+	}
+	logrus.Infof("[purge.go::purgeData] all data of user(id=%v) purged: %v", id, counts)
+	auditDetail(c, "purged=%v", counts)
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  counts,
+	})
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Reset of lost password by a link mailed by smtp client setting of admin
+	POST /api/auth/password/forgot {"username":"alice"} or {"email":"alice@example.com"}
+	GET  /api/auth/password/reset?token=xxx                          => page to set new password
+	POST /api/auth/password/reset {"token":"xxx","password":"yyy"}
+token is uid.expire.hmac of current password hash, it is valid for PASSWORD_RESET_EXPIRE until password is changed
+or server is restarted. response of forgot is same for unknown users, users of ldap and disabled users get no mail.
+*/
+
+const (
+	PASSWORD_RESET_EXPIRE   = 30 * time.Minute
+	PASSWORD_RESET_INTERVAL = time.Minute //min interval of mails to a user
+)
+
+var passwordResetPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>godnslog - reset password</title></head><body>
+<form id="reset" style="max-width:320px;margin:80px auto;font-family:sans-serif">
+<h3>Reset password</h3>
+<p><input id="password" type="password" placeholder="new password" minlength="6" required style="width:100%"></p>
+<p><input id="confirm" type="password" placeholder="confirm password" minlength="6" required style="width:100%"></p>
+<p><button type="submit">Reset</button> <span id="message"></span></p>
+</form>
+<script>
+document.getElementById("reset").onsubmit = function (e) {
+  e.preventDefault();
+  var password = document.getElementById("password").value, message = document.getElementById("message");
+  if (password !== document.getElementById("confirm").value) {
+    message.textContent = "passwords not match";
+    return;
+  }
+  fetch(location.pathname, {method: "POST", headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({token: {{.Token}}, password: password})})
+    .then(function (r) { return r.json() })
+    .then(function (r) {
+      message.textContent = r.message;
+      if (r.code === 0) { setTimeout(function () { location.replace({{.Home}}) }, 1000) }
+    });
+};
+</script></body></html>
+`))
+
+// passwordResetToken sign uid and expiration with current password, used tokens are invalid after reset
+func (self *WebServer) passwordResetToken(user *models.TblUser, expire int64) string {
+	mac := hmac.New(sha256.New, []byte(self.verifyKey))
+	fmt.Fprintf(mac, "reset|%v|%v|%v", user.Id, expire, user.Pass)
+	return fmt.Sprintf("%v.%v.%v", user.Id, expire, webauthnEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// verifyPasswordResetToken get user of unexpired token
+func (self *WebServer) verifyPasswordResetToken(token string) (*models.TblUser, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid token")
+	}
+	uid, err1 := strconv.ParseInt(parts[0], 10, 64)
+	expire, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid token")
+	} else if time.Now().Unix() > expire {
+		return nil, fmt.Errorf("token expired")
+	}
+	user := new(models.TblUser)
+	exist, err := self.orm.ID(uid).Get(user)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, fmt.Errorf("no user of token")
+	}
+	if !hmac.Equal([]byte(token), []byte(self.passwordResetToken(user, expire))) {
+		return nil, fmt.Errorf("token not match, or password changed")
+	}
+	return user, nil
+}
+
+// POST /api/auth/password/forgot
+func (self *WebServer) forgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Username == "" && req.Email == "") {
+		self.resp(c, 400, &CR{
+			Message: "username or email required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	srv, err := self.mailServer()
+	if err != nil {
+		logrus.Errorf("[pwreset.go::forgotPassword] mailServer: %v", err)
+		self.respData(c, 502, CodeServerInternal, "bad service", nil)
+		return
+	} else if srv.Host == "" {
+		self.resp(c, 400, &CR{
+			Message: "mail server not configured, ask admin to reset password",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	user := new(models.TblUser)
+	session := self.orm.NewSession()
+	defer session.Close()
+	if req.Username != "" {
+		session = session.Where(`name=?`, req.Username)
+	} else {
+		session = session.Where(`email=?`, req.Email)
+	}
+	exist, err := session.Get(user)
+	if err != nil {
+		logrus.Errorf("[pwreset.go::forgotPassword] orm.Get: %v", err)
+		self.respData(c, 502, CodeServerInternal, "bad service", nil)
+		return
+	}
+	//same response whether the user exists or not
+	defer self.resp(c, 200, &CR{
+		Message: "OK, a mail is sent if the account exists",
+	})
+	if !exist || user.Disabled || user.LdapDn != "" {
+		logrus.Infof("[pwreset.go::forgotPassword] no reset of %v%v, exist: %v", req.Username, req.Email, exist)
+		return
+	}
+	if err = self.store.Add(fmt.Sprintf("%v.pwreset", user.Id), int64(1), PASSWORD_RESET_INTERVAL); err != nil {
+		logrus.Infof("[pwreset.go::forgotPassword] reset of %v requested again in %v", user.Name, PASSWORD_RESET_INTERVAL)
+		return
+	}
+
+	token := self.passwordResetToken(user, time.Now().Add(PASSWORD_RESET_EXPIRE).Unix())
+	link := self.uiUrl() + "/api/auth/password/reset?token=" + token
+	ip := self.TrustedProxies.ClientIP(c.Request)
+	body := fmt.Sprintf("A password reset of %v on %v was requested from %v.\n\n"+
+		"Open the link in %v to set a new password:\n%v\n\n"+
+		"Ignore this mail if you did not request it, the password is not changed.\n",
+		user.Name, self.Domain, ip, PASSWORD_RESET_EXPIRE, link)
+	go func() {
+		if err := sendMail(srv, user.Email, "[godnslog] reset password", body); err != nil {
+			logrus.Errorf("[pwreset.go::forgotPassword] sendMail(%v): %v", user.Email, err)
+			return
+		}
+		logrus.Infof("[pwreset.go::forgotPassword] reset mail of %v sent, requested from %v", user.Name, ip)
+	}()
+}
+
+// GET /api/auth/password/reset
+func (self *WebServer) passwordResetForm(c *gin.Context) {
+	home := self.uiUrl()
+	if home == "" {
+		home = "/"
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(200)
+	passwordResetPage.Execute(c.Writer, map[string]string{"Token": c.Query("token"), "Home": home})
+}
+
+// POST /api/auth/password/reset
+func (self *WebServer) resetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" {
+		self.resp(c, 400, &CR{
+			Message: "token required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if isWeakPass(req.Password) {
+		self.resp(c, 400, &CR{
+			Message: "password too weak",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	user, err := self.verifyPasswordResetToken(req.Token)
+	if err != nil {
+		logrus.Infof("[pwreset.go::resetPassword] verifyPasswordResetToken: %v", err)
+		self.resp(c, 400, &CR{
+			Message: "invalid or expired link",
+			Code:    CodeExpire,
+		})
+		return
+	}
+
+	_, err = self.orm.ID(user.Id).SetExpr(`pass`, customQuote(makePassword(req.Password, self.passwordHash()))).
+		SetExpr(`token_version`, `token_version+1`).Update(&models.TblUser{})
+	if err != nil {
+		logrus.Errorf("[pwreset.go::resetPassword] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "update Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	//logout sessions with the old password
+	self.logoutUser(user.Id)
+	logrus.Infof("[pwreset.go::resetPassword] password of %v reset from %v", user.Name, self.TrustedProxies.ClientIP(c.Request))
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
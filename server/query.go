@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+
+	"xorm.io/xorm"
+)
+
+/*
+Record query shared by grpc and graphql, the same as data api
+	/data/dns?q=token&blur=1&tag=confirmed&ip=10.0.0.0/8&after_id=1234&limit=50
+*/
+
+type recordQuery struct {
+	uid     int64
+	q       string //all records if empty
+	blur    bool   //q is substring of var
+	tag     string
+	params  map[string]string //filters of parseFilter
+	afterId int64
+	limit   int //DefaultQueryApiMaxItem if 0
+}
+
+// recordSession build conditions of query, error is invalid parameter
+func (self *WebServer) recordSession(session *xorm.Session, typ string, query *recordQuery) (*xorm.Session, *recordFilter, error) {
+	session = session.Where(`uid=?`, query.uid)
+	if query.q != "" && !query.blur {
+		session = session.And(`var = ?`, query.q)
+	} else if query.q != "" {
+		session = session.And(`var like ?`, "%"+query.q+"%")
+	}
+	if query.tag != "" {
+		session = tagCond(session, query.tag)
+	}
+	filter, err := self.makeFilter(func(key string) string {
+		return query.params[key]
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	session = filter.apply(session, exporters[typ].domain)
+	if typ == "dns" && filter.qtype != "" {
+		session = session.And(`qtype = ?`, filter.qtype)
+	}
+	if typ == "http" && filter.method != "" {
+		session = session.And(`method = ?`, filter.method)
+	}
+	return session, filter, nil
+}
+
+// queryCursor is cursor of query, error is invalid parameter
+func (self *WebServer) queryCursor(query *recordQuery) (*cursor, error) {
+	if query.afterId < 0 || query.limit < 0 {
+		return nil, fmt.Errorf("invalid after_id or limit")
+	}
+	cur := &cursor{afterId: query.afterId, limit: query.limit}
+	if cur.limit == 0 {
+		cur.limit = self.DefaultQueryApiMaxItem
+	} else if cur.limit > MAX_CURSOR_LIMIT {
+		cur.limit = MAX_CURSOR_LIMIT
+	}
+	return cur, nil
+}
+
+// scanRecords scan records by id, records filtered in go are skipped, return next after_id
+func (self *WebServer) scanRecords(session *xorm.Session, filter *recordFilter, typ string, cur *cursor) ([]interface{}, int64, error) {
+	cond := session.Conds()
+	exp := exporters[typ]
+	var beans []interface{}
+	batchCur := *cur
+	for n := 0; n < MAX_FILTER_SCAN && len(beans) < cur.limit; n++ {
+		batch := batchCur.after(session.Where(cond))
+		if typ == "http" {
+			batch = batch.Omit("body")
+		}
+		count := 0
+		err := self.iterateHits(batch, exp.bean(), false, batchCur.limit, func(bean interface{}) error {
+			count++
+			batchCur.afterId = recordId(bean)
+			ip, domain, _ := exp.row(bean)
+			if len(beans) < cur.limit && filter.match(ip, domain) {
+				beans = append(beans, bean)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		if !filter.post || count < cur.limit {
+			break
+		}
+	}
+	var nextId int64
+	if len(beans) > 0 {
+		nextId = cur.next(len(beans), recordId(beans[len(beans)-1]))
+	}
+	return beans, nextId, nil
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Queue of captured records waiting to be stored, bounded by -queue-size
+	curl -H "Access-Token: ${ADMIN_TOKEN}" http://127.0.0.1:8080/api/admin/queue
+	{"depth": 12, "size": 10000, "shared": 0, "overflows": 3, "dropped": 0}
+servers wait a while if queue is full, then records are dropped.
+growing overflows or dropped means the store layer is falling behind
+*/
+
+func (self *WebServer) getQueueStats(c *gin.Context) {
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  self.store.Stats(),
+	})
+}
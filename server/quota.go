@@ -0,0 +1,188 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Quota of stored records of each user, records of all types are counted
+	godnslog serve -record-quota 100000                       # default of users, 0 is unlimited
+	godnslog serve -record-quota 100000 -record-quota-reject  # reject new records instead of eviction
+admin sets quota of a user, -1 is unlimited
+	POST /api/admin/user {"id": 2, "recordQuota": 500000}
+users over quota are checked every minute, oldest records are evicted down to quota.
+if rejected, records of users over quota are dropped and http hits are answered with 507 until records are cleaned
+*/
+
+const (
+	QUOTA_INTERVAL  = time.Minute
+	MAX_EVICT_ITEMS = 10000 //records evicted of a user each time, the rest are evicted next time
+)
+
+var ErrRecordQuota = errors.New("record quota exceeded")
+
+// recordQuota is max stored records of user, 0 is unlimited
+func (self *WebServer) recordQuota(user *models.TblUser) int64 {
+	if user != nil && user.RecordQuota > 0 {
+		return user.RecordQuota
+	} else if user != nil && user.RecordQuota < 0 {
+		return 0
+	}
+	return self.DefaultRecordQuota
+}
+
+// overQuota is true if new records of user are rejected
+func (self *WebServer) overQuota(uid int64) bool {
+	if !self.RecordQuotaReject || uid == 0 {
+		return false
+	}
+	_, exist := self.store.Get(fmt.Sprintf("%v.overquota", uid))
+	return exist
+}
+
+// recordUid is Uid of queued record
+func recordUid(rcd interface{}) int64 {
+	v := reflect.ValueOf(rcd)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return 0
+	}
+	if uid := v.Elem().FieldByName("Uid"); uid.IsValid() && uid.Kind() == reflect.Int64 {
+		return uid.Int()
+	}
+	return 0
+}
+
+// countRecords count stored records of all types of user
+func (self *WebServer) countRecords(uid int64) (int64, error) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var total int64
+	for _, typ := range searchTypes {
+		count, err := self.countHits(session.Where(`uid=?`, uid), exporters[typ].bean())
+		if err != nil {
+			return 0, fmt.Errorf("%v: %v", typ, err)
+		}
+		total += count
+	}
+	return total, nil
+}
+
+type evictItem struct {
+	typ   string
+	id    int64
+	ctime time.Time
+}
+
+// evictRecords delete oldest n records of all types of user
+func (self *WebServer) evictRecords(uid int64, n int) (int, error) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []evictItem
+	for _, typ := range searchTypes {
+		err := self.iterateHits(session.Where(`uid=?`, uid), exporters[typ].bean(), true, n, func(bean interface{}) error {
+			v := reflect.ValueOf(bean).Elem()
+			items = append(items, evictItem{
+				typ:   typ,
+				id:    v.FieldByName("Id").Int(),
+				ctime: v.FieldByName("Ctime").Interface().(time.Time),
+			})
+			return nil
+		}, "id", "ctime")
+		if err != nil {
+			return 0, fmt.Errorf("%v: %v", typ, err)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].ctime.Before(items[j].ctime)
+	})
+	if len(items) > n {
+		items = items[:n]
+	}
+
+	ids := make(map[string][]int64)
+	for _, item := range items {
+		ids[item.typ] = append(ids[item.typ], item.id)
+	}
+	evicted := 0
+	for typ, typIds := range ids {
+		count, err := self.deleteHits(session.Where(`uid=?`, uid).In("id", typIds), exporters[typ].bean())
+		if err != nil {
+			return evicted, fmt.Errorf("%v: %v", typ, err)
+		}
+		evicted += int(count)
+	}
+	return evicted, nil
+}
+
+// doQuota evict oldest records or flag users over quota
+func (self *WebServer) doQuota() {
+	if !atomic.CompareAndSwapInt32(&self.quotaBusy, 0, 1) {
+		//last check is not finished
+		return
+	}
+	defer atomic.StoreInt32(&self.quotaBusy, 0)
+
+	var ids []int64
+	err := self.orm.Table(&models.TblUser{}).Cols("id").Find(&ids)
+	if err != nil {
+		logrus.Errorf("[quota.go::doQuota] orm.Find: %v", err)
+		return
+	}
+
+	evicted := 0
+	for _, id := range ids {
+		v, exist := self.store.Get(fmt.Sprintf("%v.user", id))
+		if !exist {
+			continue
+		}
+		quota := self.recordQuota(v.(*models.TblUser))
+		overKey := fmt.Sprintf("%v.overquota", id)
+		if quota <= 0 {
+			self.store.Delete(overKey)
+			continue
+		}
+		count, err := self.countRecords(id)
+		if err != nil {
+			logrus.Errorf("[quota.go::doQuota] countRecords(%v): %v", id, err)
+			continue
+		}
+
+		if self.RecordQuotaReject {
+			if count >= quota {
+				self.store.Set(overKey, count, cache.NoExpiration)
+			} else {
+				self.store.Delete(overKey)
+			}
+			continue
+		} else if count <= quota {
+			continue
+		}
+		n := count - quota
+		if n > MAX_EVICT_ITEMS {
+			n = MAX_EVICT_ITEMS
+		}
+		m, err := self.evictRecords(id, int(n))
+		if err != nil {
+			logrus.Errorf("[quota.go::doQuota] evictRecords(%v): %v", id, err)
+		}
+		if m > 0 {
+			logrus.Infof("[quota.go::doQuota] %v records of user %v evicted, quota: %v", m, id, quota)
+		}
+		evicted += m
+	}
+	if evicted > 0 {
+		self.cleanHttpFiles()
+		self.cleanSearch()
+	}
+}
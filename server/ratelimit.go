@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Rate limit of /api and /data by token bucket, enabled by -rate-limit (requests per minute) and -rate-burst
+requests are limited per client ip, authenticated ones per user too, unverified tokens are not keys of buckets.
+	RateLimit-Limit: 120
+	RateLimit-Remaining: 37
+	RateLimit-Reset: 42
+rejected requests get 429 with Retry-After in seconds. hits of users and ingest nodes are not limited.
+*/
+
+const (
+	RATE_LIMIT_SWEEP       = time.Minute
+	MAX_RATE_LIMIT_BUCKETS = 100000 //buckets are evicted over it, eg. addresses of a flood
+)
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is token buckets by key, full buckets are swept and the map is capped
+type rateLimiter struct {
+	rate  float64 //tokens per second
+	burst float64
+
+	lock    sync.Mutex
+	buckets map[string]*rateBucket
+	swept   time.Time
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = perMinute
+	}
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*rateBucket),
+		swept:   time.Now(),
+	}
+}
+
+// evict full buckets, then random ones to 90% of the cap if the map is still full
+func (l *rateLimiter) evict(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+	l.swept = now
+	if len(l.buckets) < MAX_RATE_LIMIT_BUCKETS {
+		return
+	}
+	for k := range l.buckets {
+		if len(l.buckets) < MAX_RATE_LIMIT_BUCKETS*9/10 {
+			break
+		}
+		delete(l.buckets, k)
+	}
+}
+
+// take a token of key, remaining tokens and time until the bucket is full, or until next token if denied
+func (l *rateLimiter) take(key string, now time.Time) (bool, int, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.Sub(l.swept) > RATE_LIMIT_SWEEP {
+		l.evict(now)
+	}
+
+	b, exist := l.buckets[key]
+	if !exist {
+		if len(l.buckets) >= MAX_RATE_LIMIT_BUCKETS {
+			l.evict(now)
+		}
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, 0, wait
+	}
+	b.tokens--
+	full := time.Duration((l.burst - b.tokens) / l.rate * float64(time.Second))
+	return true, int(b.tokens), full
+}
+
+// limit take a token of key, RateLimit headers are of the stricter bucket, 429 if denied
+func (self *WebServer) limit(c *gin.Context, kind, key string) {
+	ok, remaining, reset := self.limiter.take(key, time.Now())
+	header := c.Writer.Header()
+	if prev, err := strconv.Atoi(header.Get("RateLimit-Remaining")); err != nil || remaining <= prev {
+		c.Header("RateLimit-Limit", strconv.Itoa(int(self.limiter.burst)))
+		c.Header("RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+	}
+	if ok {
+		return
+	}
+
+	metricRateLimited.inc(kind)
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+	self.resp(c, 429, &CR{
+		Message: "rate limit exceeded",
+		Code:    CodeQuota,
+	})
+	c.Abort()
+}
+
+// rateLimit reject requests of /api and /data over -rate-limit of client ip with 429
+func (self *WebServer) rateLimit(c *gin.Context) {
+	path := c.Request.URL.Path
+	if !strings.HasPrefix(path, "/api/") && !strings.HasPrefix(path, "/data/") {
+		return
+	}
+	//ingest nodes are authenticated by key, they forward records of many users
+	if strings.HasPrefix(path, "/api/ingest/") {
+		return
+	}
+	//tokens are not verified yet, random ones would get fresh buckets
+	self.limit(c, "ip", "i:"+self.TrustedProxies.ClientIP(c.Request))
+}
+
+// rateLimitUser limit authenticated requests per user too, deferred by auth handlers, idKey is key of uid in context
+func (self *WebServer) rateLimitUser(c *gin.Context, idKey string) {
+	if self.limiter == nil || c.IsAborted() {
+		return
+	}
+	if uid := c.GetInt64(idKey); uid != 0 {
+		self.limit(c, "user", fmt.Sprintf("u:%v", uid))
+	}
+}
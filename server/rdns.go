@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Reverse dns enrichment of source addresses, enabled by
+	godnslog serve -rdns
+ptr of source ip is resolved after the record is saved and attached as "ptr" of dns and http records,
+eg. google-public-dns-a.google.com of a resolver, results are cached for RDNS_CACHE
+*/
+
+const (
+	RDNS_TIMEOUT    = 5 * time.Second
+	RDNS_CACHE      = time.Hour
+	RDNS_CONCURRENT = 16 //pending lookups, more records are not enriched
+)
+
+// rdnsLookup resolve hostname of ip, empty if no ptr record
+func (self *WebServer) rdnsLookup(ip string) string {
+	key := "ptr." + ip
+	if v, exist := self.store.Get(key); exist {
+		return v.(string)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), RDNS_TIMEOUT)
+	defer cancel()
+	var host string
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil {
+		if e, ok := err.(*net.DNSError); !ok || !e.IsNotFound {
+			//retry on next record
+			logrus.Debugf("[rdns.go::rdnsLookup] LookupAddr(%v): %v", ip, err)
+			return ""
+		}
+	} else if len(names) > 0 {
+		host = strings.TrimSuffix(names[0], ".")
+	}
+	self.store.Set(key, host, RDNS_CACHE)
+	return host
+}
+
+// rdnsEnrich resolve ptr of source ip and save it to record of table asynchronously
+func (self *WebServer) rdnsEnrich(table interface{}, id int64, ip string) {
+	if !self.Rdns || self.ClickHouse != nil || net.ParseIP(ip) == nil {
+		return
+	}
+	select {
+	case self.rdnsSem <- struct{}{}:
+	default:
+		logrus.Debugf("[rdns.go::rdnsEnrich] too many pending lookups, skip %v", ip)
+		return
+	}
+
+	self.wg.Add(1)
+	go func() {
+		defer self.wg.Done()
+		defer func() { <-self.rdnsSem }()
+
+		host := self.rdnsLookup(ip)
+		if host == "" {
+			return
+		}
+		session := self.orm.NewSession()
+		defer session.Close()
+
+		_, err := session.Table(table).ID(id).Update(map[string]interface{}{"ptr": host})
+		if err != nil {
+			logrus.Errorf("[rdns.go::rdnsEnrich] orm.Update: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+/*
+Push new records by websocket as they are stored, eg.
+	ws://godnslog.com/api/realtime?token=${Access-Token}&type=dns,http
+	ws://${shortId}.godnslog.com/data/realtime?t=${t}&hash=${hash}
+browser can not set header of websocket, web ui pass Access-Token by query.
+server-sent events share the same hub, for websocket blocked
+	curl http://${shortId}.godnslog.com/data/stream?q=${token}&t=${t}&hash=${hash}
+*/
+
+const (
+	REALTIME_QUEUE_SIZE = 256 //events are dropped when subscriber is slow
+	REALTIME_PING       = 30 * time.Second
+)
+
+type realtimeSub struct {
+	uids  []int64
+	types map[string]bool //empty for all types
+	token string          //empty for all tokens
+}
+
+func newRealtimeSub(c *gin.Context, uids []int64) *realtimeSub {
+	sub := &realtimeSub{
+		uids:  uids,
+		types: make(map[string]bool),
+		token: c.Query("q"),
+	}
+	for _, typ := range strings.Split(c.Query("type"), ",") {
+		if typ != "" {
+			sub.types[typ] = true
+		}
+	}
+	return sub
+}
+
+func (sub *realtimeSub) match(uid int64, event *models.SessionEvent) bool {
+	if len(sub.types) > 0 && !sub.types[event.Type] {
+		return false
+	}
+	//variable of http is /token
+	if sub.token != "" && event.Var != sub.token && event.Var != "/"+sub.token {
+		return false
+	}
+	for _, id := range sub.uids {
+		if id == uid {
+			return true
+		}
+	}
+	return false
+}
+
+type realtimeHub struct {
+	lock sync.RWMutex
+	subs map[chan models.SessionEvent]*realtimeSub
+}
+
+func (h *realtimeHub) subscribe(sub *realtimeSub) chan models.SessionEvent {
+	ch := make(chan models.SessionEvent, REALTIME_QUEUE_SIZE)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[chan models.SessionEvent]*realtimeSub)
+	}
+	h.subs[ch] = sub
+	return ch
+}
+
+// count is subscribers of hub
+func (h *realtimeHub) count() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.subs)
+}
+
+func (h *realtimeHub) unsubscribe(ch chan models.SessionEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.subs, ch)
+}
+
+func (h *realtimeHub) publish(uid int64, event models.SessionEvent) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for ch, sub := range h.subs {
+		if !sub.match(uid, &event) {
+			continue
+		}
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// recordAdded index, mirror, push stored record, notify channels decided by alert rules, which are returned for callback
+func (self *WebServer) recordAdded(bean interface{}) *alert {
+	return self.notifyRecord(bean, false)
+}
+
+// notifyRecord is recordAdded, record relayed by federation is not relayed again
+func (self *WebServer) notifyRecord(bean interface{}, relayed bool) *alert {
+	self.indexRecord(bean)
+	uid := reflect.ValueOf(bean).Elem().FieldByName("Uid").Int()
+	event := recordEvent(bean)
+	metricRecords.inc(event.Type)
+	self.mirrorRecord(uid, &event)
+	if self.hideScanner(uid, bean) {
+		//mute all channels
+		return &alert{}
+	}
+	self.realtime.publish(uid, event)
+
+	a := self.recordAlert(uid, event.Type, bean)
+	self.dispatchWebhooks(uid, &event, a, bean, relayed)
+	if a.Allow(ALERT_MAIL) {
+		self.mailAlert(uid, &event)
+	}
+	if a.Allow(ALERT_TELEGRAM) {
+		self.telegramNotify(uid, &event)
+	}
+	return a
+}
+
+// realtimeToken pass token of query as header for authHandler
+func (self *WebServer) realtimeToken(c *gin.Context) {
+	if token := c.Query("token"); token != "" && c.GetHeader("Access-Token") == "" {
+		c.Request.Header.Set("Access-Token", token)
+	}
+}
+
+// realtimeUids is users visible in web ui, members of teams of user, admins also receive records of unknown users
+func (self *WebServer) realtimeUids(c *gin.Context) []int64 {
+	uids := append([]int64{}, self.teamUids(c.GetInt64("id"), false)...)
+	switch c.GetInt("role") {
+	case roleAdmin, roleSuper:
+		uids = append(uids, 0)
+	}
+	return uids
+}
+
+// web ui, GET /api/realtime
+func (self *WebServer) getRealtime(c *gin.Context) {
+	self.serveRealtime(c, self.realtimeUids(c))
+}
+
+// ws://${shortId}.godnslog.com/data/realtime
+func (self *WebServer) queryRealtime(c *gin.Context) {
+	self.serveRealtime(c, []int64{c.GetInt64("uid")})
+}
+
+func (self *WebServer) serveRealtime(c *gin.Context, uids []int64) {
+	if !isWebSocket(c.Request) {
+		self.resp(c, 400, &CR{
+			Message: "websocket required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	sub := newRealtimeSub(c, uids)
+
+	server := websocket.Server{
+		//authorized by token, not cookie
+		Handshake: func(*websocket.Config, *http.Request) error {
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			ch := self.realtime.subscribe(sub)
+			defer self.realtime.unsubscribe(ch)
+
+			//messages of client are ignored, read until closed
+			closed := make(chan struct{})
+			go func() {
+				io.Copy(ioutil.Discard, ws)
+				close(closed)
+			}()
+
+			ticker := time.NewTicker(REALTIME_PING)
+			defer ticker.Stop()
+			for {
+				var err error
+				select {
+				case event := <-ch:
+					ws.SetWriteDeadline(time.Now().Add(WS_IDLE_TIMEOUT))
+					err = websocket.JSON.Send(ws, &event)
+				case <-ticker.C:
+					ws.SetWriteDeadline(time.Now().Add(WS_IDLE_TIMEOUT))
+					ws.PayloadType = websocket.PingFrame
+					_, err = ws.Write(nil)
+				case <-closed:
+					return
+				}
+				if err != nil {
+					ws.Close()
+					return
+				}
+			}
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// web ui, GET /api/stream
+func (self *WebServer) getStream(c *gin.Context) {
+	self.serveStream(c, self.realtimeUids(c))
+}
+
+// curl http://${shortId}.godnslog.com/data/stream
+func (self *WebServer) queryStream(c *gin.Context) {
+	self.serveStream(c, []int64{c.GetInt64("uid")})
+}
+
+// serveStream push events as server-sent events until client gone
+func (self *WebServer) serveStream(c *gin.Context, uids []int64) {
+	ch := self.realtime.subscribe(newRealtimeSub(c, uids))
+	defer self.realtime.unsubscribe(ch)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") //nginx
+	c.Status(200)
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(REALTIME_PING)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case event := <-ch:
+			var data []byte
+			data, err = json.Marshal(&event)
+			if err == nil {
+				_, err = fmt.Fprintf(c.Writer, "id: %v\nevent: %v\ndata: %s\n\n", event.Id, event.Type, data)
+			}
+		case <-ticker.C:
+			_, err = io.WriteString(c.Writer, ": ping\n\n")
+		case <-c.Request.Context().Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Hot reload of settings, listeners and connections are kept
+	kill -HUP $(pidof godnslog)
+	POST /api/admin/reload, by super admin
+users, custom domains, http rules, ip filters, alert rules, webhooks, mail server and retention are read from database again,
+scanner fingerprints from file. OnReload of serve applies notification options of config file and certificate
+*/
+
+// settings of users cached until changed, dropped by Reload
+var reloadUserKeys = []string{"httprules", "ipfilters", "alertrules", "webhooks"}
+
+// notify is options of notifications, may be changed by Reload
+type notify struct {
+	UiUrl         string
+	TelegramToken string
+	TelegramApi   string
+}
+
+// notify get options of notifications
+func (self *WebServer) notify() notify {
+	self.notifyLock.RLock()
+	defer self.notifyLock.RUnlock()
+	return notify{
+		UiUrl:         self.UiUrl,
+		TelegramToken: self.TelegramToken,
+		TelegramApi:   self.TelegramApi,
+	}
+}
+
+// SetNotify change options of notifications while running
+func (self *WebServer) SetNotify(uiUrl, telegramToken, telegramApi string) {
+	self.notifyLock.Lock()
+	defer self.notifyLock.Unlock()
+	self.UiUrl = uiUrl
+	self.TelegramToken = telegramToken
+	self.TelegramApi = telegramApi
+}
+
+// Reload settings cached from database and files, then OnReload
+func (self *WebServer) Reload() error {
+	self.reloadLock.Lock()
+	defer self.reloadLock.Unlock()
+
+	var users []models.TblUser
+	if err := self.orm.Find(&users); err != nil {
+		logrus.Errorf("[reload.go::Reload] orm.Find: %v", err)
+		return err
+	}
+	store := self.store
+	store.Delete(MAIL_SERVER_KEY)
+	store.Delete(LDAP_AUTH_KEY)
+	store.Delete(PASSWORD_HASH_KEY)
+	store.Delete(RETENTION_KEY)
+	store.Delete("0.ipfilters")
+	for i := 0; i < len(users); i++ {
+		user := &users[i]
+		for _, key := range reloadUserKeys {
+			store.Delete(fmt.Sprintf("%v.%v", user.Id, key))
+		}
+		store.Set(fmt.Sprintf("%v.user", user.Id), user, cache.NoExpiration)
+		store.Set(fmt.Sprintf("%v.suser", user.ShortId), user, cache.NoExpiration)
+		if user.Disabled {
+			//logout
+			self.logoutUser(user.Id)
+		}
+	}
+	self.loadCustomDomains()
+	self.loadScanners()
+
+	if self.OnReload != nil {
+		if err := self.OnReload(); err != nil {
+			return err
+		}
+	}
+	logrus.Infof("[reload.go::Reload] settings of %v users reloaded", len(users))
+	return nil
+}
+
+// POST /api/admin/reload
+func (self *WebServer) reloadSettings(c *gin.Context) {
+	if err := self.Reload(); err != nil {
+		self.resp(c, 500, &CR{
+			Message: err.Error(),
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	REPLAY_TIMEOUT = 30 * time.Second
+)
+
+var replayMethodRegexp = regexp.MustCompile(`^[A-Z]{1,16}$`)
+
+// newReplayClient keep raw response, do not follow redirect or verify certificate of target
+func newReplayClient(allowPrivate bool) *http.Client {
+	t := targetTransport(allowPrivate)
+	t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return &http.Client{
+		Timeout: REPLAY_TIMEOUT,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Transport: t,
+	}
+}
+
+// replay send captured request to target, the response is saved whether it fails or not
+func (self *WebServer) replay(rcd *models.TblHttp, req *HttpReplayRequest, max int64) (*models.TblHttpReplay, error) {
+	method := rcd.Method
+	if req.Method != "" {
+		method = strings.ToUpper(req.Method)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		body = []byte(*req.Body)
+	} else {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(rcd.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	item := &models.TblHttpReplay{
+		Uid:    rcd.Uid,
+		Rid:    rcd.Id,
+		Url:    req.Url,
+		Method: method,
+	}
+
+	hreq, err := http.NewRequest(method, req.Url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if rcd.Ua != "" {
+		hreq.Header.Set("User-Agent", rcd.Ua)
+	}
+	if rcd.Ctype != "" {
+		hreq.Header.Set("Content-Type", rcd.Ctype)
+	}
+	for k, v := range req.Headers {
+		if strings.EqualFold(k, "Host") {
+			hreq.Host = v
+			continue
+		}
+		hreq.Header.Set(k, v)
+	}
+
+	start := time.Now()
+	resp, err := self.replayClient.Do(hreq)
+	if err != nil {
+		item.Error = err.Error()
+	} else {
+		data, size, truncated, err := readLimited(resp.Body, max)
+		resp.Body.Close()
+		if err != nil {
+			item.Error = err.Error()
+		}
+		item.Status = resp.StatusCode
+		item.Headers = resp.Header
+		item.Body = base64.StdEncoding.EncodeToString(data)
+		item.Size = size
+		item.Truncated = truncated
+	}
+	item.Elapsed = int64(time.Since(start) / time.Millisecond)
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	_, err = session.InsertOne(item)
+	return item, err
+}
+
+func httpReplay(item *models.TblHttpReplay) *models.HttpReplay {
+	return &models.HttpReplay{
+		Id:        item.Id,
+		Url:       item.Url,
+		Method:    item.Method,
+		Status:    item.Status,
+		Headers:   item.Headers,
+		Body:      item.Body,
+		Size:      item.Size,
+		Truncated: item.Truncated,
+		Elapsed:   item.Elapsed,
+		Error:     item.Error,
+		Ctime:     item.Ctime,
+	}
+}
+
+func (self *WebServer) replayHttpRecord(c *gin.Context) {
+	var req HttpReplayRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		logrus.Infof("[replay.go::replayHttpRecord] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	u, err := url.Parse(req.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		self.resp(c, 400, &CR{
+			Message: "url should be http(s)://host/path",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.Method != "" && !replayMethodRegexp.MatchString(strings.ToUpper(req.Method)) {
+		self.resp(c, 400, &CR{
+			Message: "bad method",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	rcd, ok := self.visibleHttpRecord(c)
+	if !ok {
+		return
+	}
+	user, ok := self.loginUser(c)
+	if !ok {
+		return
+	}
+
+	item, err := self.replay(rcd, &req, self.maxBodySize(user))
+	if err != nil {
+		logrus.Errorf("[replay.go::replayHttpRecord] replay(id=%v): %v", rcd.Id, err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  httpReplay(item),
+	})
+}
+
+func (self *WebServer) getHttpReplays(c *gin.Context) {
+	rcd, ok := self.visibleHttpRecord(c)
+	if !ok {
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblHttpReplay
+	err := session.Where(`rid=?`, rcd.Id).Desc("id").Find(&items)
+	if err != nil {
+		logrus.Errorf("[replay.go::getHttpReplays] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	replays := make([]*models.HttpReplay, len(items))
+	for i := 0; i < len(items); i++ {
+		replays[i] = httpReplay(&items[i])
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  replays,
+	})
+}
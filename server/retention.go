@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Retention of records by type, users keep each type for hours of app setting, cleanHour for the rest
+	POST /api/user/app/setting {"cleanHour": 24, "retention": {"dns": 168, "http": 72}}
+admin sets max hours of types, longer settings of users are cut at clean
+	GET|POST /api/admin/retention {"dns": 720, "http": 168}
+types are dns, http, smtp, ldap, ftp, tcp, icmp, smb and rmi, 0 is unlimited
+*/
+
+const (
+	RETENTION_KEY = "retention.max"
+)
+
+// retentionMax get max seconds by record type, cached until changed by admin
+func (self *WebServer) retentionMax() (map[string]int64, error) {
+	v, exist := self.store.Get(RETENTION_KEY)
+	if exist {
+		return v.(map[string]int64), nil
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblRetention
+	if err := session.Find(&items); err != nil {
+		return nil, err
+	}
+	max := make(map[string]int64)
+	for _, item := range items {
+		if item.MaxInterval > 0 {
+			max[item.Type] = item.MaxInterval
+		}
+	}
+	self.store.Set(RETENTION_KEY, max, cache.NoExpiration)
+	return max, nil
+}
+
+// retention is seconds to keep records of type for user
+func retention(user *models.TblUser, typ string, max map[string]int64) int64 {
+	interval := user.CleanInterval
+	if v := user.Retention[typ]; v > 0 {
+		interval = v
+	}
+	if m := max[typ]; m > 0 && interval > m {
+		interval = m
+	}
+	return interval
+}
+
+// toHours convert seconds by type to hours
+func toHours(seconds map[string]int64) map[string]int64 {
+	hours := make(map[string]int64, len(seconds))
+	for typ, v := range seconds {
+		hours[typ] = v / 3600
+	}
+	return hours
+}
+
+// GET /api/admin/retention, max hours by record type
+func (self *WebServer) getRetention(c *gin.Context) {
+	max, err := self.retentionMax()
+	if err != nil {
+		logrus.Errorf("[retention.go::getRetention] retentionMax: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  toHours(max),
+	})
+}
+
+// POST /api/admin/retention, replace max hours by record type
+func (self *WebServer) setRetention(c *gin.Context) {
+	var req map[string]int64
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		logrus.Infof("[retention.go::setRetention] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	for typ, hours := range req {
+		if !isSearchType(typ) || hours < 0 {
+			self.resp(c, 400, &CR{
+				Message: fmt.Sprintf("invalid retention of %v, types are %v, hours should not be negative", typ, searchTypes),
+				Code:    CodeBadData,
+			})
+			return
+		}
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+	err = session.Begin()
+	if err == nil {
+		_, err = session.Where(`1=1`).Delete(&models.TblRetention{})
+	}
+	for typ, hours := range req {
+		if err != nil {
+			break
+		} else if hours > 0 {
+			_, err = session.InsertOne(&models.TblRetention{Type: typ, MaxInterval: hours * 3600})
+		}
+	}
+	if err == nil {
+		err = session.Commit()
+	}
+	if err != nil {
+		logrus.Errorf("[retention.go::setRetention] orm.Insert: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Delete(RETENTION_KEY)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
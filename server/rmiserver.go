@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+)
+
+/*
+RMI catcher for JNDI lookup
+	${jndi:rmi://token.userXXXX.example.com:1099/token.userXXXX}
+JRMP handshake and call are logged, connection is closed after call, lookup always fail
+*/
+
+const (
+	MAX_RMI_CALL = 4096
+
+	jrmpMagic          = 0x4a524d49 //JRMI
+	jrmpStreamProtocol = 0x4b
+	jrmpSingleOp       = 0x4c
+	jrmpProtocolAck    = 0x4e
+	jrmpCall           = 0x50
+	jrmpPing           = 0x52
+	jrmpPingAck        = 0x53
+	jrmpDgcAck         = 0x54
+
+	javaTcString = 0x74
+)
+
+type RmiServerConfig struct {
+	Listen  []string //eg. :1099
+	Timeout time.Duration
+}
+
+type RmiServer struct {
+	RmiServerConfig
+	tcpServer
+}
+
+func NewRmiServer(cfg *RmiServerConfig, store *cache.Cache) (*RmiServer, error) {
+	s := &RmiServer{
+		RmiServerConfig: *cfg,
+	}
+	s.name = "rmi"
+	s.store = store
+	return s, nil
+}
+
+func (s *RmiServer) Run() {
+	s.run(s.Listen, s.handle)
+}
+
+func (s *RmiServer) Shutdown() {
+	s.shutdown()
+}
+
+func (s *RmiServer) handle(conn net.Conn) {
+	ip, port, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(s.Timeout))
+
+	//magic, version, protocol
+	var head [7]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return
+	}
+	if binary.BigEndian.Uint32(head[:]) != jrmpMagic {
+		return
+	}
+
+	var host string
+	switch head[6] {
+	case jrmpStreamProtocol:
+		//ack with client endpoint, then client send its endpoint
+		var ack bytes.Buffer
+		ack.WriteByte(jrmpProtocolAck)
+		binary.Write(&ack, binary.BigEndian, uint16(len(ip)))
+		ack.WriteString(ip)
+		p, _ := strconv.Atoi(port)
+		binary.Write(&ack, binary.BigEndian, int32(p))
+		if _, err := conn.Write(ack.Bytes()); err != nil {
+			return
+		}
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return
+		}
+		b := make([]byte, int(n)+4)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return
+		}
+		host = string(b[:n])
+	case jrmpSingleOp:
+	default:
+		return
+	}
+
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			s.logCall(ip, host, "")
+			return
+		}
+		switch op {
+		case jrmpPing:
+			conn.Write([]byte{jrmpPingAck})
+		case jrmpDgcAck:
+			if _, err = r.Discard(20); err != nil {
+				return
+			}
+		case jrmpCall:
+			//call data is java serialization stream, object name is the last string
+			buf := make([]byte, MAX_RMI_CALL)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, _ := io.ReadAtLeast(r, buf, len(buf))
+			s.logCall(ip, host, rmiObjectName(buf[:n]))
+			return
+		default:
+			return
+		}
+	}
+}
+
+// rmiObjectName find last TC_STRING in serialized call
+func rmiObjectName(b []byte) string {
+	var name string
+	for i := 0; i+3 <= len(b); i++ {
+		if b[i] != javaTcString {
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(b[i+1:]))
+		if n == 0 || i+3+n > len(b) {
+			continue
+		}
+		str := b[i+3 : i+3+n]
+		printable := true
+		for _, c := range str {
+			if c < 0x20 || c > 0x7e {
+				printable = false
+				break
+			}
+		}
+		if printable {
+			name = string(str)
+			i += 2 + n
+		}
+	}
+	return name
+}
+
+func (s *RmiServer) logCall(ip, host, name string) {
+	variable, user := s.lookupToken(name)
+	if user == nil {
+		variable, user = s.lookupToken(host)
+	}
+	rcd := &RmiRecord{
+		Ip:    ip,
+		Host:  host,
+		Name:  name,
+		Ctime: time.Now(),
+	}
+	if user != nil {
+		rcd.Uid = user.Id
+		rcd.Var = variable
+	}
+	s.log(rcd)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Roles of users, set by admin
+	PUT|POST /api/admin/user {"id":2,"role":3}
+	godnslog user add -name alice -email alice@example.com -role viewer
+viewer reads own records and changes security of own account only, operator manages own records and settings,
+admin manages users as well. only super admin grants or revokes admin, super admin is never changed.
+*/
+
+// RoleName is name of role in api and commands
+func RoleName(role int) string {
+	switch role {
+	case roleSuper:
+		return "super"
+	case roleAdmin:
+		return "admin"
+	case roleViewer:
+		return "viewer"
+	}
+	return "operator"
+}
+
+// ParseRole parse assignable role, normal is operator of older versions
+func ParseRole(name string) (int, error) {
+	switch name {
+	case "admin":
+		return roleAdmin, nil
+	case "operator", "normal":
+		return roleOperator, nil
+	case "viewer":
+		return roleViewer, nil
+	}
+	return 0, fmt.Errorf("role %q should be admin, operator or viewer", name)
+}
+
+// validRole is true if role may be assigned by admin of role by
+func validRole(role, by int) bool {
+	switch role {
+	case roleOperator, roleViewer:
+		return true
+	case roleAdmin:
+		return by == roleSuper
+	}
+	return false
+}
+
+// verifyWritePermission deny changes of viewers, reads are allowed
+func (self *WebServer) verifyWritePermission(c *gin.Context) {
+	if c.GetInt("role") != roleViewer {
+		return
+	}
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	self.resp(c, 403, &CR{
+		Message: "bad permission, viewer is read only",
+		Code:    CodeNoPermission,
+	})
+	c.Abort()
+}
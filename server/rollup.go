@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Daily rollup of hits, records newer than the mark of each type are counted
+into tbl_rollup in clean routine before they are cleaned
+	GET /api/data/rollup?from=2020-05-01&to=2020-05-31&type=dns
+*/
+
+const (
+	DEFAULT_ROLLUP_DAYS = 90
+)
+
+func (self *WebServer) doRollup() {
+	for _, typ := range searchTypes {
+		if self.inClickHouse(typ) {
+			continue
+		}
+		err := self.rollupType(typ)
+		if err != nil {
+			logrus.Errorf("[rollup.go::doRollup] rollupType(%v): %v", typ, err)
+		}
+	}
+}
+
+func (self *WebServer) rollupType(typ string) error {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	err := session.Begin()
+	if err != nil {
+		return err
+	}
+	defer session.Rollback()
+
+	var mark models.TblRollupMark
+	exist, err := session.ID(typ).Get(&mark)
+	if err != nil {
+		return err
+	}
+
+	var rows []struct {
+		Uid    int64
+		Day    string
+		Hits   int64
+		LastId int64
+	}
+	err = session.SQL(fmt.Sprintf(`SELECT uid, %v AS day, count(*) AS hits, max(id) AS last_id FROM tbl_%v WHERE id > ? GROUP BY uid, day`,
+		self.bucketExpr("day"), typ), mark.LastId).Find(&rows)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+
+	lastId := mark.LastId
+	for _, row := range rows {
+		res, err := session.Exec(`UPDATE tbl_rollup SET hits = hits + ? WHERE uid = ? AND day = ? AND type = ?`,
+			row.Hits, row.Uid, row.Day, typ)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			_, err = session.InsertOne(&models.TblRollup{
+				Uid:  row.Uid,
+				Day:  row.Day,
+				Type: typ,
+				Hits: row.Hits,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if row.LastId > lastId {
+			lastId = row.LastId
+		}
+	}
+
+	if exist {
+		_, err = session.ID(typ).Cols("last_id").Update(&models.TblRollupMark{LastId: lastId})
+	} else {
+		_, err = session.InsertOne(&models.TblRollupMark{Type: typ, LastId: lastId})
+	}
+	if err != nil {
+		return err
+	}
+	return session.Commit()
+}
+
+// web ui, GET /api/data/rollup
+func (self *WebServer) getRollup(c *gin.Context) {
+	uid, ok := self.statsUid(c)
+	if !ok {
+		return
+	}
+
+	const layout = "2006-01-02"
+	to := time.Now().Format(layout)
+	from := time.Now().AddDate(0, 0, -DEFAULT_ROLLUP_DAYS).Format(layout)
+	for key, value := range map[string]*string{"from": &from, "to": &to} {
+		s := c.Query(key)
+		if s == "" {
+			continue
+		}
+		if _, err := time.Parse(layout, s); err != nil {
+			self.resp(c, 400, &CR{
+				Message: fmt.Sprintf("invalid %v: %v", key, s),
+				Code:    CodeBadData,
+			})
+			return
+		}
+		*value = s
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	session = session.Where(`uid=?`, uid).And(`day>=?`, from).And(`day<=?`, to)
+	if typ := c.Query("type"); typ != "" {
+		session = session.And(`type=?`, typ)
+	}
+	var items []models.TblRollup
+	err := session.Asc("day", "type").Find(&items)
+	if err != nil {
+		logrus.Errorf("[rollup.go::getRollup] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	resp := make([]models.Rollup, len(items))
+	for i := 0; i < len(items); i++ {
+		resp[i] = models.Rollup{
+			Day:  items[i].Day,
+			Type: items[i].Type,
+			Hits: items[i].Hits,
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  resp,
+	})
+}
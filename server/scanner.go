@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"xorm.io/xorm"
+)
+
+/*
+Known crawlers and internet scanners, dns and http hits of them are marked with "scanner" name.
+built-in fingerprints are extended by a json file, reloaded when modified
+	godnslog serve -scanners scanners.json
+	[{"name": "acme-monitor", "uas": ["AcmeMonitor"], "cidrs": ["192.0.2.0/24"]}]
+a fingerprint of the same name replaces the built-in one. users hide marked hits in app setting
+	{"hideScanners": true}
+hidden hits are not listed or notified, ?scanners=1 of record apis lists them anyway
+*/
+
+const (
+	SCANNERS_KEY = "scanners"
+)
+
+// ScannerFingerprint is user agents and source ranges of a scanner
+type ScannerFingerprint struct {
+	Name  string   `json:"name"`
+	Uas   []string `json:"uas"`   //substrings of http user agent, case insensitive
+	Cidrs []string `json:"cidrs"` //source ip or cidr
+}
+
+var builtinScanners = []ScannerFingerprint{
+	{Name: "googlebot", Uas: []string{"Googlebot"}, Cidrs: []string{"66.249.64.0/19"}},
+	{Name: "bingbot", Uas: []string{"bingbot"}, Cidrs: []string{"157.55.39.0/24", "207.46.13.0/24", "40.77.167.0/24"}},
+	{Name: "yandexbot", Uas: []string{"YandexBot"}},
+	{Name: "baiduspider", Uas: []string{"Baiduspider"}},
+	{Name: "censys", Uas: []string{"CensysInspect"},
+		Cidrs: []string{"162.142.125.0/24", "167.94.138.0/24", "167.94.145.0/24", "167.94.146.0/24", "167.248.133.0/24"}},
+	{Name: "shodan", Cidrs: []string{"66.240.192.138", "66.240.205.34", "66.240.236.119", "71.6.135.131",
+		"71.6.146.185", "71.6.158.166", "71.6.167.142", "82.221.105.6", "82.221.105.7", "93.120.27.62",
+		"198.20.69.74", "198.20.70.114", "198.20.99.130"}},
+	{Name: "expanse", Uas: []string{"Expanse, a Palo Alto Networks company"}},
+	{Name: "internet-measurement", Uas: []string{"InternetMeasurement"}},
+	{Name: "zgrab", Uas: []string{"zgrab"}},
+	{Name: "leakix", Uas: []string{"l9explore", "l9tcpid"}},
+}
+
+type scanner struct {
+	name string
+	uas  []string
+	nets []*net.IPNet
+}
+
+type scannerList struct {
+	mtime time.Time
+	list  []*scanner
+}
+
+func compileScanners(fps []ScannerFingerprint) []*scanner {
+	list := make([]*scanner, 0, len(fps))
+	for _, fp := range fps {
+		s := &scanner{name: fp.Name}
+		for _, ua := range fp.Uas {
+			if ua != "" {
+				s.uas = append(s.uas, strings.ToLower(ua))
+			}
+		}
+		for _, cidr := range fp.Cidrs {
+			ipnet, err := parseCidr(cidr)
+			if err != nil {
+				logrus.Warnf("[scanner.go::compileScanners] %v: %v", fp.Name, err)
+				continue
+			}
+			s.nets = append(s.nets, ipnet)
+		}
+		list = append(list, s)
+	}
+	return list
+}
+
+// loadScanners compile built-in and file fingerprints if file is modified
+func (self *WebServer) loadScanners() *scannerList {
+	var cur *scannerList
+	if v, exist := self.store.Get(SCANNERS_KEY); exist {
+		cur = v.(*scannerList)
+	}
+	var mtime time.Time
+	if self.ScannersFile != "" {
+		st, err := os.Stat(self.ScannersFile)
+		if err != nil {
+			logrus.Errorf("[scanner.go::loadScanners] stat: %v", err)
+		} else {
+			mtime = st.ModTime()
+		}
+	}
+	if cur != nil && cur.mtime.Equal(mtime) {
+		return cur
+	}
+
+	fps := builtinScanners
+	if !mtime.IsZero() {
+		var extra []ScannerFingerprint
+		data, err := ioutil.ReadFile(self.ScannersFile)
+		if err == nil {
+			err = json.Unmarshal(data, &extra)
+		}
+		if err != nil {
+			logrus.Errorf("[scanner.go::loadScanners] %v: %v", self.ScannersFile, err)
+			if cur != nil {
+				return cur
+			}
+		}
+		names := make(map[string]bool)
+		for _, fp := range extra {
+			names[fp.Name] = true
+		}
+		fps = make([]ScannerFingerprint, 0, len(builtinScanners)+len(extra))
+		for _, fp := range builtinScanners {
+			if !names[fp.Name] {
+				fps = append(fps, fp)
+			}
+		}
+		fps = append(fps, extra...)
+	}
+	cur = &scannerList{mtime: mtime, list: compileScanners(fps)}
+	self.store.Set(SCANNERS_KEY, cur, cache.NoExpiration)
+	return cur
+}
+
+// classifyScanner is name of scanner of hit, empty if unknown, ua is empty for dns
+func (self *WebServer) classifyScanner(ip, ua string) string {
+	v, exist := self.store.Get(SCANNERS_KEY)
+	if !exist {
+		v = self.loadScanners()
+	}
+	addr := net.ParseIP(ip)
+	ua = strings.ToLower(ua)
+	for _, s := range v.(*scannerList).list {
+		if addr != nil && matchNets(s.nets, addr) {
+			return s.name
+		}
+		for _, sub := range s.uas {
+			if ua != "" && strings.Contains(ua, sub) {
+				return s.name
+			}
+		}
+	}
+	return ""
+}
+
+// hideScanner is true if bean is a hit of scanner hidden by user
+func (self *WebServer) hideScanner(uid int64, bean interface{}) bool {
+	var name string
+	switch item := bean.(type) {
+	case *models.TblDns:
+		name = item.Scanner
+	case *models.TblHttp:
+		name = item.Scanner
+	}
+	if name == "" || uid == 0 {
+		return false
+	}
+	v, exist := self.store.Get(fmt.Sprintf("%v.user", uid))
+	return exist && v.(*models.TblUser).HideScanners
+}
+
+// scannerCond exclude hits of scanners if user hides them, unless ?scanners=1
+func (self *WebServer) scannerCond(c *gin.Context, session *xorm.Session, uid int64) *xorm.Session {
+	if c.Query("scanners") == "1" {
+		return session
+	}
+	v, exist := self.store.Get(fmt.Sprintf("%v.user", uid))
+	if !exist || !v.(*models.TblUser).HideScanners {
+		return session
+	}
+	return session.And(`(scanner IS NULL OR scanner = '')`)
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/chennqqi/goutils/ginutils"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"xorm.io/xorm"
+)
+
+/*
+Cross-protocol session, hits of all protocols with the same variable(token)
+	dig token.userXXXX.example.com
+	curl http://token.userXXXX.example.com/
+	curl http://userXXXX.example.com/data/session/token
+*/
+
+// querySession build timeline of token in records of users, at most DefaultQueryApiMaxItem records of each protocol
+func (self *WebServer) querySession(uids []int64, token string, blur bool) (*models.Session, error) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	events, err := self.findEvents(func(typ string) *xorm.Session {
+		s := session.In("uid", uids)
+		if blur {
+			s = s.And(`var like ?`, "%"+token+"%")
+		} else if typ == "http" {
+			//variable of http is path after shortId, eg. /token
+			s = s.In(`var`, token, "/"+token)
+		} else {
+			s = s.In(`var`, token)
+		}
+		return s.Desc("id").Limit(self.DefaultQueryApiMaxItem)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Ctime.Before(events[j].Ctime)
+	})
+
+	resp := &models.Session{
+		Token:     token,
+		Count:     len(events),
+		Protocols: []string{},
+		Events:    events,
+	}
+	if len(events) > 0 {
+		resp.First = events[0].Ctime
+		resp.Last = events[len(events)-1].Ctime
+	}
+	seen := make(map[string]bool)
+	for _, event := range events {
+		if !seen[event.Type] {
+			seen[event.Type] = true
+			resp.Protocols = append(resp.Protocols, event.Type)
+		}
+	}
+	if resp.Events == nil {
+		resp.Events = []models.SessionEvent{}
+	}
+	return resp, nil
+}
+
+// findEvents load records of each protocol as events, where build query of the protocol, nil to skip it
+func (self *WebServer) findEvents(where func(typ string) *xorm.Session) ([]models.SessionEvent, error) {
+	var events []models.SessionEvent
+	for _, typ := range searchTypes {
+		s := where(typ)
+		if s == nil {
+			continue
+		}
+		exp := exporters[typ]
+		if typ == "http" {
+			s = s.Omit("body")
+		}
+		err := s.Iterate(exp.bean(), func(idx int, bean interface{}) error {
+			events = append(events, recordEvent(bean))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// recordEvent convert table row to event
+func recordEvent(bean interface{}) models.SessionEvent {
+	var event models.SessionEvent
+	switch item := bean.(type) {
+	case *models.TblDns:
+		event = models.SessionEvent{Type: "dns", Id: item.Id, Var: item.Var, Ip: item.Ip, Ctime: item.Ctime,
+			Data: models.DnsRecord{
+				Id:      item.Id,
+				Domain:  item.Domain,
+				Ip:      item.Ip,
+				Qtype:   item.Qtype,
+				Hits:    item.Hits,
+				Tags:    item.Tags,
+				Note:    item.Note,
+				Ctime:   item.Ctime,
+				Ptr:     item.Ptr,
+				Scanner: item.Scanner,
+				Geo:     item.Geo,
+			}}
+	case *models.TblHttp:
+		event = models.SessionEvent{Type: "http", Id: item.Id, Var: item.Var, Ip: item.Ip, Ctime: item.Ctime,
+			Data: models.HttpRecord{
+				Id:         item.Id,
+				Host:       item.Host,
+				Path:       item.Path,
+				Ip:         item.Ip,
+				Method:     item.Method,
+				Proto:      item.Proto,
+				Data:       item.Data,
+				Ctype:      item.Ctype,
+				Ua:         item.Ua,
+				Size:       item.Size,
+				Truncated:  item.Truncated,
+				Sni:        item.Sni,
+				TlsVersion: item.TlsVersion,
+				TlsCipher:  item.TlsCipher,
+				Ja3:        item.Ja3,
+				Ja3Hash:    item.Ja3Hash,
+				Tags:       item.Tags,
+				Note:       item.Note,
+				Ctime:      item.Ctime,
+				Ptr:        item.Ptr,
+				Scanner:    item.Scanner,
+				Geo:        item.Geo,
+			}}
+	case *models.TblSmtp:
+		event = models.SessionEvent{Type: "smtp", Id: item.Id, Var: item.Var, Ip: item.Ip, Ctime: item.Ctime,
+			Data: smtpRecord(item)}
+	case *models.TblLdap:
+		event = models.SessionEvent{Type: "ldap", Id: item.Id, Var: item.Var, Ip: item.Ip, Ctime: item.Ctime,
+			Data: ldapRecord(item)}
+	case *models.TblFtp:
+		event = models.SessionEvent{Type: "ftp", Id: item.Id, Var: item.Var, Ip: item.Ip, Ctime: item.Ctime,
+			Data: ftpRecord(item)}
+	case *models.TblTcp:
+		event = models.SessionEvent{Type: "tcp", Id: item.Id, Var: item.Var, Ip: item.Ip, Ctime: item.Ctime,
+			Data: tcpRecord(item)}
+	case *models.TblIcmp:
+		event = models.SessionEvent{Type: "icmp", Id: item.Id, Var: item.Var, Ip: item.Ip, Ctime: item.Ctime,
+			Data: icmpRecord(item)}
+	case *models.TblSmb:
+		event = models.SessionEvent{Type: "smb", Id: item.Id, Var: item.Var, Ip: item.Ip, Ctime: item.Ctime,
+			Data: smbRecord(item)}
+	case *models.TblRmi:
+		event = models.SessionEvent{Type: "rmi", Id: item.Id, Var: item.Var, Ip: item.Ip, Ctime: item.Ctime,
+			Data: rmiRecord(item)}
+	}
+	return event
+}
+
+// web ui, GET /api/record/session/:token
+func (self *WebServer) getSession(c *gin.Context) {
+	id := c.GetInt64("id")
+	blur, _ := ginutils.GetQueryInt(c, "blur")
+
+	resp, err := self.querySession(self.teamUids(id, false), c.Param("token"), blur != 0)
+	if err != nil {
+		logrus.Errorf("[session.go::getSession] querySession: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  resp,
+	})
+}
+
+// curl http://${shortId}.godnslog.com/data/session/${token}
+func (self *WebServer) querySessionRecord(c *gin.Context) {
+	id := c.GetInt64("uid")
+	blur, _ := ginutils.GetQueryInt(c, "blur")
+
+	resp, err := self.querySession([]int64{id}, c.Param("token"), blur != 0)
+	if err != nil {
+		logrus.Errorf("[session.go::querySessionRecord] querySession: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  resp,
+	})
+}
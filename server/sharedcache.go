@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/gob"
+
+	"github.com/chennqqi/godnslog/models"
+)
+
+/*
+Share users, login sessions, interactsh registrations, custom domains, callback error counters, quota flags and queued records
+of web/dns processes by redis, queued records are kept in redis over restarts
+	godnslog serve -redis "redis://:PASSWORD@127.0.0.1:6379/0?prefix=godnslog:"
+other keys, eg. rules and rate limits, are cached by each process
+*/
+
+// suffixes of keys kept in shared cache
+var SHARED_CACHE_KEYS = []string{".user", ".suser", ".login", ".interactsh", ".errcount", ".overquota", ".totp", ".totpstep", ".totpfail", ".webauthn", ".oidc", ".pwreset", ".signup", ".teams", ".cdomain"}
+
+func init() {
+	//values of shared keys
+	gob.Register(&models.TblUser{})
+	gob.Register(&models.TblInteractsh{})
+	gob.Register(&webauthnSession{})
+	gob.Register(&oidcLogin{})
+	gob.Register(&signupPending{})
+	gob.Register(&teamAccess{})
+	gob.Register(&loginSession{})
+	gob.Register(&models.TblDomain{})
+
+	//queued records
+	gob.Register(&DnsRecord{})
+	gob.Register(&HttpRecord{})
+	gob.Register(&SmtpRecord{})
+	gob.Register(&LdapRecord{})
+	gob.Register(&FtpRecord{})
+	gob.Register(&TcpRecord{})
+	gob.Register(&IcmpRecord{})
+	gob.Register(&SmbRecord{})
+	gob.Register(&RmiRecord{})
+}
@@ -0,0 +1,430 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Public signup by invite codes of admin, enabled by serve -signup and the mail server of admin
+	GET|PUT|DELETE /api/admin/invites {"note":"ctf team","maxUses":10,"expire":"2026-12-31T00:00:00Z"}
+	GET  /api/auth/signup                   => {"enabled":true}
+	POST /api/auth/signup {"invite":"xxx","username":"alice","email":"alice@example.com","password":"yyy"}
+	GET  /api/auth/signup/verify?code=xxx   => page of mailed link to create the account
+	POST /api/auth/signup/verify {"code":"xxx"}
+the account is created with its own token and subdomain once the email is verified, a use of invite is counted then.
+*/
+
+const (
+	SIGNUP_EXPIRE        = 24 * time.Hour //verification link
+	SIGNUP_MAIL_INTERVAL = time.Minute    //min interval of mails to an address
+	INVITE_CODE_LEN      = 16
+	MAX_INVITE_NOTE      = 255
+)
+
+var (
+	inviteCodeRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{6,64}$`)
+
+	signupPage = template.Must(template.New("signup").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>godnslog - verify email</title></head><body>
+<form id="verify" style="max-width:320px;margin:80px auto;font-family:sans-serif">
+<h3>Verify email</h3>
+<p><button type="submit">Create account</button> <span id="message"></span></p>
+</form>
+<script>
+document.getElementById("verify").onsubmit = function (e) {
+  e.preventDefault();
+  var message = document.getElementById("message");
+  fetch(location.pathname, {method: "POST", headers: {"Content-Type": "application/json"},
+    body: JSON.stringify({code: {{.Code}}})})
+    .then(function (r) { return r.json() })
+    .then(function (r) {
+      message.textContent = r.message;
+      if (r.code === 0) { setTimeout(function () { location.replace({{.Home}}) }, 1000) }
+    });
+};
+</script></body></html>
+`))
+)
+
+// signupPending is account of signup until email is verified
+type signupPending struct {
+	Name     string
+	Email    string
+	Pass     string //hash of makePassword
+	InviteId int64
+}
+
+// signupEnabled is true if -signup is set and mails can be sent
+func (self *WebServer) signupEnabled() (*models.TblMailServer, bool) {
+	if !self.Signup {
+		return nil, false
+	}
+	srv, err := self.mailServer()
+	if err != nil {
+		logrus.Errorf("[signup.go::signupEnabled] mailServer: %v", err)
+		return nil, false
+	}
+	return srv, srv.Host != ""
+}
+
+// validInvite get invite of code which is not expired or used up
+func (self *WebServer) validInvite(code string) (*models.TblInvite, error) {
+	invite := new(models.TblInvite)
+	exist, err := self.orm.Where(`code=?`, code).Get(invite)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, fmt.Errorf("invite code not found")
+	} else if !invite.Expire.IsZero() && time.Now().After(invite.Expire) {
+		return nil, fmt.Errorf("invite code expired")
+	} else if invite.Uses >= invite.MaxUses {
+		return nil, fmt.Errorf("invite code used up")
+	}
+	return invite, nil
+}
+
+// GET /api/auth/signup
+func (self *WebServer) signupInfo(c *gin.Context) {
+	_, enabled := self.signupEnabled()
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  models.SignupInfo{Enabled: enabled},
+	})
+}
+
+// POST /api/auth/signup
+func (self *WebServer) signup(c *gin.Context) {
+	srv, enabled := self.signupEnabled()
+	if !enabled {
+		self.resp(c, 404, &CR{
+			Message: "signup not enabled",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	var req models.SignupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.Username == "" || len(req.Username) > 64 || oidcNameRegexp.MatchString(req.Username) {
+		self.resp(c, 400, &CR{
+			Message: "username should be at most 64 letters, digits, '.', '_' or '-'",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil || addr.Address != req.Email || len(req.Email) > 64 {
+		self.resp(c, 400, &CR{
+			Message: "invalid email",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if isWeakPass(req.Password) {
+		self.resp(c, 400, &CR{
+			Message: "password too weak",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	invite, err := self.validInvite(req.Invite)
+	if err != nil {
+		logrus.Infof("[signup.go::signup] invite %q of %v: %v", req.Invite, req.Email, err)
+		self.resp(c, 403, &CR{
+			Message: "invalid invite code",
+			Code:    CodeNoPermission,
+		})
+		return
+	}
+	exist, err := self.orm.Where(`name=? OR email=?`, req.Username, req.Email).Exist(&models.TblUser{})
+	if err != nil {
+		logrus.Errorf("[signup.go::signup] orm.Exist: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if exist {
+		self.resp(c, 400, &CR{
+			Message: "username or email already registered",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if err = self.store.Add(fmt.Sprintf("%v.signupmail", strings.ToLower(req.Email)), true, SIGNUP_MAIL_INTERVAL); err != nil {
+		self.resp(c, 429, &CR{
+			Message: "verification mail sent, try again later",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	code := genRandomToken()
+	self.store.Set(fmt.Sprintf("%v.signup", code), &signupPending{
+		Name:     req.Username,
+		Email:    req.Email,
+		Pass:     makePassword(req.Password, self.passwordHash()),
+		InviteId: invite.Id,
+	}, SIGNUP_EXPIRE)
+
+	link := self.uiUrl() + "/api/auth/signup/verify?code=" + code
+	body := fmt.Sprintf("Welcome to godnslog on %v.\n\n"+
+		"Open the link in %v to verify your email and create account %v:\n%v\n\n"+
+		"Ignore this mail if you did not sign up.\n",
+		self.Domain, SIGNUP_EXPIRE, req.Username, link)
+	go func() {
+		if err := sendMail(srv, req.Email, "[godnslog] verify email", body); err != nil {
+			logrus.Errorf("[signup.go::signup] sendMail(%v): %v", req.Email, err)
+		}
+	}()
+	reqLog(c).Infof("[signup.go::signup] signup of %v <%v> by invite %v", req.Username, req.Email, invite.Id)
+	self.resp(c, 200, &CR{
+		Message: "OK, check your email to verify it",
+	})
+}
+
+// GET /api/auth/signup/verify
+func (self *WebServer) signupVerifyForm(c *gin.Context) {
+	home := self.uiUrl()
+	if home == "" {
+		home = "/"
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(200)
+	signupPage.Execute(c.Writer, map[string]string{"Code": c.Query("code"), "Home": home})
+}
+
+// POST /api/auth/signup/verify
+func (self *WebServer) signupVerify(c *gin.Context) {
+	var req models.SignupVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		self.resp(c, 400, &CR{
+			Message: "code required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	key := fmt.Sprintf("%v.signup", req.Code)
+	v, exist := self.store.Get(key)
+	if !exist {
+		self.resp(c, 400, &CR{
+			Message: "invalid or expired link",
+			Code:    CodeExpire,
+		})
+		return
+	}
+	self.store.Delete(key)
+	pending := v.(*signupPending)
+
+	session := self.orm.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		logrus.Errorf("[signup.go::signupVerify] session.Begin: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	//count a use unless invite is expired or used up since signup
+	invite := new(models.TblInvite)
+	exist, err := session.ID(pending.InviteId).Get(invite)
+	if err != nil {
+		logrus.Errorf("[signup.go::signupVerify] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if !exist || (!invite.Expire.IsZero() && time.Now().After(invite.Expire)) {
+		self.resp(c, 403, &CR{
+			Message: "invite code expired or deleted",
+			Code:    CodeNoPermission,
+		})
+		return
+	}
+	n, err := session.ID(invite.Id).Where(`uses<max_uses`).Incr("uses").Update(&models.TblInvite{})
+	if err != nil {
+		logrus.Errorf("[signup.go::signupVerify] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if n == 0 {
+		self.resp(c, 403, &CR{
+			Message: "invite code used up",
+			Code:    CodeNoPermission,
+		})
+		return
+	}
+	user := self.newExternalUser(pending.Name, pending.Email, roleOperator)
+	user.Pass = pending.Pass
+	_, err = session.InsertOne(user)
+	if self.IsDuplicate(err) {
+		self.resp(c, 400, &CR{
+			Message: "username or email already registered",
+			Code:    CodeBadData,
+		})
+		return
+	} else if err != nil {
+		logrus.Errorf("[signup.go::signupVerify] orm.InsertOne: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	if err = session.Commit(); err != nil {
+		logrus.Errorf("[signup.go::signupVerify] session.Commit: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	reqLog(c).Infof("[signup.go::signupVerify] user %v <%v> created by invite %v", user.Name, user.Email, pending.InviteId)
+	self.resp(c, 200, &CR{
+		Message: "OK, log in with your password",
+	})
+}
+
+// GET /api/admin/invites
+func (self *WebServer) getInvites(c *gin.Context) {
+	var items []models.TblInvite
+	if err := self.orm.Desc("id").Find(&items); err != nil {
+		logrus.Errorf("[signup.go::getInvites] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	invites := make([]Invite, len(items))
+	for i := 0; i < len(items); i++ {
+		item := &items[i]
+		invites[i] = Invite{
+			Id:      item.Id,
+			Code:    item.Code,
+			Note:    item.Note,
+			MaxUses: item.MaxUses,
+			Uses:    item.Uses,
+			Expire:  item.Expire,
+			Uid:     item.Uid,
+			Atime:   item.Atime,
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  invites,
+	})
+}
+
+// PUT /api/admin/invites
+func (self *WebServer) addInvite(c *gin.Context) {
+	var req Invite
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logrus.Infof("[signup.go::addInvite] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.Code == "" {
+		req.Code = genRandomString(INVITE_CODE_LEN)
+	} else if !inviteCodeRegexp.MatchString(req.Code) {
+		self.resp(c, 400, &CR{
+			Message: "code should be 6 to 64 letters, digits, '_' or '-'",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	} else if req.MaxUses < 0 {
+		self.resp(c, 400, &CR{
+			Message: "maxUses should be positive",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if utf8.RuneCountInString(req.Note) > MAX_INVITE_NOTE {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("note should be at most %v characters", MAX_INVITE_NOTE),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	item := models.TblInvite{
+		Code:    req.Code,
+		Note:    req.Note,
+		MaxUses: req.MaxUses,
+		Expire:  req.Expire,
+		Uid:     c.GetInt64("id"),
+	}
+	_, err := self.orm.InsertOne(&item)
+	if self.IsDuplicate(err) {
+		self.resp(c, 400, &CR{
+			Message: "code exists",
+			Code:    CodeBadData,
+		})
+		return
+	} else if err != nil {
+		logrus.Errorf("[signup.go::addInvite] orm.InsertOne: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  item.Code,
+	})
+}
+
+// DELETE /api/admin/invites
+func (self *WebServer) delInvites(c *gin.Context) {
+	var req DeleteRecordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[signup.go::delInvites] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	params := make([]interface{}, len(req.Ids))
+	for i := 0; i < len(req.Ids); i++ {
+		params[i] = req.Ids[i]
+	}
+	if _, err := self.orm.In("id", params...).Delete(&models.TblInvite{}); err != nil {
+		logrus.Errorf("[signup.go::delInvites] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
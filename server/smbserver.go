@@ -0,0 +1,476 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+)
+
+/*
+SMB catcher for UNC path injection
+	\\token.userXXXX.example.com\share
+only negotiate and NTLM session setup are implemented, logon always fails.
+the connecting host name is taken from target name (cifs/host) of NTLMv2 response
+*/
+
+const (
+	MAX_SMB_PACKET   = 64 * 1024
+	MAX_SMB_MESSAGES = 32
+
+	smb2Negotiate    = 0x0000
+	smb2SessionSetup = 0x0001
+
+	smbStatusSuccess        = 0x00000000
+	smbStatusMoreProcess    = 0xC0000016
+	smbStatusLogonFailure   = 0xC000006D
+	smbStatusNotSupported   = 0xC00000BB
+	smb2HeaderSize          = 64
+	ntlmNegotiateMessage    = 1
+	ntlmChallengeMessage    = 2
+	ntlmAuthenticateMessage = 3
+
+	ntlmFlagUnicode = 0x00000001
+	ntlmFlagVersion = 0x02000000
+	ntlmFlags       = 0xe2898215
+
+	ntlmAvEOL          = 0
+	ntlmAvComputerName = 1
+	ntlmAvDomainName   = 2
+	ntlmAvDnsComputer  = 3
+	ntlmAvDnsDomain    = 4
+	ntlmAvTimestamp    = 7
+	ntlmAvTargetName   = 9
+)
+
+var (
+	errBadSmb = errors.New("bad smb packet")
+
+	oidSpnego = []byte{0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	oidNtlm   = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+)
+
+type SmbServerConfig struct {
+	Domain  string
+	Listen  []string //eg. :445
+	Timeout time.Duration
+}
+
+type SmbServer struct {
+	SmbServerConfig
+	tcpServer
+
+	guid [16]byte
+}
+
+func NewSmbServer(cfg *SmbServerConfig, store *cache.Cache) (*SmbServer, error) {
+	s := &SmbServer{
+		SmbServerConfig: *cfg,
+	}
+	s.name = "smb"
+	s.store = store
+	s.Domain = strings.TrimSuffix(s.Domain, ".")
+	rand.Read(s.guid[:])
+	return s, nil
+}
+
+func (s *SmbServer) Run() {
+	s.run(s.Listen, s.handle)
+}
+
+func (s *SmbServer) Shutdown() {
+	s.shutdown()
+}
+
+// smbSession is state of one smb connection
+type smbSession struct {
+	ip        string
+	sessionId uint64
+	challenge []byte
+	os        string
+	host      string //workstation of NTLM NEGOTIATE
+	logged    bool
+}
+
+func (s *SmbServer) handle(conn net.Conn) {
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	r := bufio.NewReader(conn)
+
+	sess := &smbSession{ip: ip}
+	defer func() {
+		//NTLM NEGOTIATE received, but no AUTHENTICATE
+		if sess.challenge != nil && !sess.logged {
+			s.logSession(sess, &ntlmAuth{Host: sess.host})
+		}
+	}()
+
+	for i := 0; i < MAX_SMB_MESSAGES; i++ {
+		conn.SetReadDeadline(time.Now().Add(s.Timeout))
+		packet, err := smbReadPacket(r)
+		if err != nil {
+			return
+		}
+
+		var resp []byte
+		switch {
+		case len(packet) >= 4 && bytes.Equal(packet[:4], []byte("\xffSMB")):
+			//SMB1 negotiate, upgrade to SMB2
+			if len(packet) < 33 || packet[4] != 0x72 {
+				return
+			}
+			dialect := uint16(0)
+			if bytes.Contains(packet, []byte("SMB 2.???")) {
+				dialect = 0x02ff
+			} else if bytes.Contains(packet, []byte("SMB 2.002")) {
+				dialect = 0x0202
+			} else {
+				return
+			}
+			resp = s.negotiateResponse(make([]byte, smb2HeaderSize), dialect)
+		case len(packet) >= smb2HeaderSize && bytes.Equal(packet[:4], []byte("\xfeSMB")):
+			switch binary.LittleEndian.Uint16(packet[12:]) {
+			case smb2Negotiate:
+				resp = s.negotiateResponse(packet, smbDialect(packet))
+			case smb2SessionSetup:
+				resp = s.sessionSetup(packet, sess)
+			default:
+				resp = smb2Response(packet, smbStatusNotSupported, 0, []byte{0x09, 0, 0, 0, 0, 0, 0, 0, 0})
+			}
+		default:
+			return
+		}
+		if resp == nil {
+			return
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+		head := []byte{0, byte(len(resp) >> 16), byte(len(resp) >> 8), byte(len(resp))}
+		if _, err = conn.Write(append(head, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// smbReadPacket read a netbios session message
+func smbReadPacket(r *bufio.Reader) ([]byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	n := int(head[1])<<16 | int(head[2])<<8 | int(head[3])
+	if head[0] != 0 || n > MAX_SMB_PACKET {
+		return nil, errBadSmb
+	}
+	packet := make([]byte, n)
+	_, err := io.ReadFull(r, packet)
+	return packet, err
+}
+
+// smbDialect select highest dialect offered by client, 3.1.1 is not supported
+func smbDialect(packet []byte) uint16 {
+	body := packet[smb2HeaderSize:]
+	if len(body) < 36 {
+		return 0x0202
+	}
+	count := int(binary.LittleEndian.Uint16(body[2:]))
+	dialect := uint16(0x0202)
+	for i := 0; i < count && 36+2*i+2 <= len(body); i++ {
+		d := binary.LittleEndian.Uint16(body[36+2*i:])
+		if d > dialect && d <= 0x0302 {
+			dialect = d
+		}
+	}
+	return dialect
+}
+
+// smb2Response build response of request with status
+func smb2Response(req []byte, status uint32, sessionId uint64, body []byte) []byte {
+	h := make([]byte, smb2HeaderSize)
+	copy(h, "\xfeSMB")
+	binary.LittleEndian.PutUint16(h[4:], smb2HeaderSize)
+	binary.LittleEndian.PutUint32(h[8:], status)
+	copy(h[12:14], req[12:14])               //command
+	binary.LittleEndian.PutUint16(h[14:], 1) //credits
+	binary.LittleEndian.PutUint32(h[16:], 1) //flags: response
+	copy(h[24:32], req[24:32])               //message id
+	copy(h[32:36], req[32:36])               //process id
+	binary.LittleEndian.PutUint64(h[40:], sessionId)
+	return append(h, body...)
+}
+
+func (s *SmbServer) negotiateResponse(req []byte, dialect uint16) []byte {
+	token := spnegoInit()
+	body := make([]byte, 64)
+	binary.LittleEndian.PutUint16(body[0:], 65)
+	binary.LittleEndian.PutUint16(body[2:], 1) //signing enabled
+	binary.LittleEndian.PutUint16(body[4:], dialect)
+	copy(body[8:24], s.guid[:])
+	binary.LittleEndian.PutUint32(body[28:], MAX_SMB_PACKET) //max transact
+	binary.LittleEndian.PutUint32(body[32:], MAX_SMB_PACKET) //max read
+	binary.LittleEndian.PutUint32(body[36:], MAX_SMB_PACKET) //max write
+	binary.LittleEndian.PutUint64(body[40:], fileTime(time.Now()))
+	binary.LittleEndian.PutUint16(body[56:], smb2HeaderSize+64)
+	binary.LittleEndian.PutUint16(body[58:], uint16(len(token)))
+	return smb2Response(req, smbStatusSuccess, 0, append(body, token...))
+}
+
+func (s *SmbServer) sessionSetup(req []byte, sess *smbSession) []byte {
+	body := req[smb2HeaderSize:]
+	if len(body) < 24 {
+		return nil
+	}
+	offset := int(binary.LittleEndian.Uint16(body[12:]))
+	length := int(binary.LittleEndian.Uint16(body[14:]))
+	if offset+length > len(req) {
+		return nil
+	}
+	blob := req[offset : offset+length]
+	idx := bytes.Index(blob, []byte("NTLMSSP\x00"))
+	if idx < 0 || len(blob) < idx+12 {
+		return nil
+	}
+	msg := blob[idx:]
+
+	switch binary.LittleEndian.Uint32(msg[8:]) {
+	case ntlmNegotiateMessage:
+		sess.challenge = make([]byte, 8)
+		rand.Read(sess.challenge)
+		var id [8]byte
+		rand.Read(id[:])
+		sess.sessionId = binary.LittleEndian.Uint64(id[:]) | 1
+		sess.os, sess.host = ntlmParseNegotiate(msg)
+
+		token := spnegoResp(1, ntlmChallenge(sess.challenge, fileTime(time.Now())))
+		return smb2Response(req, smbStatusMoreProcess, sess.sessionId, smbSetupBody(token))
+	case ntlmAuthenticateMessage:
+		if sess.challenge == nil {
+			return nil
+		}
+		auth, err := ntlmParseAuthenticate(msg, sess.challenge)
+		if err != nil {
+			return nil
+		}
+		s.logSession(sess, auth)
+		return smb2Response(req, smbStatusLogonFailure, sess.sessionId, smbSetupBody(nil))
+	}
+	return nil
+}
+
+func smbSetupBody(token []byte) []byte {
+	body := make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:], 9)
+	if len(token) > 0 {
+		binary.LittleEndian.PutUint16(body[4:], smb2HeaderSize+8)
+		binary.LittleEndian.PutUint16(body[6:], uint16(len(token)))
+	}
+	return append(body, token...)
+}
+
+func (s *SmbServer) logSession(sess *smbSession, auth *ntlmAuth) {
+	sess.logged = true
+	rcd := &SmbRecord{
+		Ip:     sess.ip,
+		Host:   auth.Host,
+		Domain: auth.Domain,
+		User:   auth.User,
+		Target: auth.Target,
+		Os:     auth.Os,
+		Hash:   auth.Hash,
+		Ctime:  time.Now(),
+	}
+	if rcd.Os == "" {
+		rcd.Os = sess.os
+	}
+
+	//target name `cifs/token.userXXXX.example.com` first, then user, domain and host
+	var user *models.TblUser
+	if idx := strings.IndexByte(auth.Target, '/'); idx >= 0 {
+		prefix, shortId, _ := parseUserDomain(s.store, strings.ToLower(auth.Target[idx+1:]), s.Domain)
+		if v, exist := s.store.Get(shortId + ".suser"); exist {
+			user = v.(*models.TblUser)
+			rcd.Var = prefix
+		}
+	}
+	for _, str := range []string{auth.User, auth.Domain, auth.Host} {
+		if user != nil || str == "" {
+			continue
+		}
+		rcd.Var, user = s.lookupToken(str)
+	}
+	if user != nil {
+		rcd.Uid = user.Id
+	} else {
+		rcd.Var = ""
+	}
+	s.log(rcd)
+}
+
+//==============================================================================
+// NTLM
+//==============================================================================
+type ntlmAuth struct {
+	Domain string
+	User   string
+	Host   string
+	Target string //MsvAvTargetName of NTLMv2 response, eg. cifs/host
+	Os     string
+	Hash   string //hashcat NetNTLMv1/v2 format
+}
+
+// ntlmField get payload of fields(len, maxLen, offset) at pos
+func ntlmField(msg []byte, pos int) []byte {
+	if len(msg) < pos+8 {
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint16(msg[pos:]))
+	offset := int(binary.LittleEndian.Uint32(msg[pos+4:]))
+	if n == 0 || offset < 0 || offset+n > len(msg) {
+		return nil
+	}
+	return msg[offset : offset+n]
+}
+
+func ntlmString(b []byte, unicode bool) string {
+	if !unicode {
+		return string(b)
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+func ntlmUnicode(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
+
+func ntlmVersion(b []byte) string {
+	if len(b) < 8 {
+		return ""
+	}
+	return fmt.Sprintf("%v.%v.%v", b[0], b[1], binary.LittleEndian.Uint16(b[2:]))
+}
+
+// ntlmParseNegotiate get os version and workstation of NEGOTIATE
+func ntlmParseNegotiate(msg []byte) (os, host string) {
+	if len(msg) < 16 {
+		return
+	}
+	flags := binary.LittleEndian.Uint32(msg[12:])
+	host = string(ntlmField(msg, 24))
+	if flags&ntlmFlagVersion != 0 && len(msg) >= 40 {
+		os = ntlmVersion(msg[32:40])
+	}
+	return
+}
+
+func ntlmChallenge(challenge []byte, now uint64) []byte {
+	name := ntlmUnicode("GODNSLOG")
+	var info []byte
+	av := func(id uint16, value []byte) {
+		var h [4]byte
+		binary.LittleEndian.PutUint16(h[0:], id)
+		binary.LittleEndian.PutUint16(h[2:], uint16(len(value)))
+		info = append(append(info, h[:]...), value...)
+	}
+	var ts [8]byte
+	binary.LittleEndian.PutUint64(ts[:], now)
+	av(ntlmAvDomainName, name)
+	av(ntlmAvComputerName, name)
+	av(ntlmAvDnsDomain, name)
+	av(ntlmAvDnsComputer, name)
+	av(ntlmAvTimestamp, ts[:])
+	av(ntlmAvEOL, nil)
+
+	msg := make([]byte, 56)
+	copy(msg, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(msg[8:], ntlmChallengeMessage)
+	binary.LittleEndian.PutUint16(msg[12:], uint16(len(name)))
+	binary.LittleEndian.PutUint16(msg[14:], uint16(len(name)))
+	binary.LittleEndian.PutUint32(msg[16:], 56)
+	binary.LittleEndian.PutUint32(msg[20:], ntlmFlags)
+	copy(msg[24:32], challenge)
+	binary.LittleEndian.PutUint16(msg[40:], uint16(len(info)))
+	binary.LittleEndian.PutUint16(msg[42:], uint16(len(info)))
+	binary.LittleEndian.PutUint32(msg[44:], uint32(56+len(name)))
+	copy(msg[48:56], []byte{10, 0, 0x61, 0x4a, 0, 0, 0, 0x0f}) //10.0.19041, NTLM revision 15
+	msg = append(msg, name...)
+	return append(msg, info...)
+}
+
+// ntlmParseAuthenticate parse AUTHENTICATE, hash is built with server challenge
+func ntlmParseAuthenticate(msg, challenge []byte) (*ntlmAuth, error) {
+	if len(msg) < 64 {
+		return nil, errBadSmb
+	}
+	flags := binary.LittleEndian.Uint32(msg[60:])
+	unicode := flags&ntlmFlagUnicode != 0
+	auth := &ntlmAuth{
+		Domain: ntlmString(ntlmField(msg, 28), unicode),
+		User:   ntlmString(ntlmField(msg, 36), unicode),
+		Host:   ntlmString(ntlmField(msg, 44), unicode),
+	}
+	if flags&ntlmFlagVersion != 0 && len(msg) >= 72 {
+		auth.Os = ntlmVersion(msg[64:72])
+	}
+
+	lm := ntlmField(msg, 12)
+	nt := ntlmField(msg, 20)
+	switch {
+	case len(nt) > 24:
+		//NTLMv2: NTProofStr + blob, av pairs start at 28 of blob
+		auth.Hash = fmt.Sprintf("%v::%v:%x:%x:%x", auth.User, auth.Domain, challenge, nt[:16], nt[16:])
+		for avs := nt[16+28:]; len(avs) >= 4; {
+			id := binary.LittleEndian.Uint16(avs)
+			n := int(binary.LittleEndian.Uint16(avs[2:]))
+			if id == ntlmAvEOL || len(avs) < 4+n {
+				break
+			}
+			if id == ntlmAvTargetName {
+				auth.Target = ntlmString(avs[4:4+n], true)
+			}
+			avs = avs[4+n:]
+		}
+	case len(nt) == 24:
+		auth.Hash = fmt.Sprintf("%v::%v:%x:%x:%x", auth.User, auth.Domain, lm, nt, challenge)
+	}
+	return auth, nil
+}
+
+//==============================================================================
+// SPNEGO
+//==============================================================================
+func spnegoInit() []byte {
+	mechs := berTLV(0xa0, berTLV(0x30, berTLV(0x06, oidNtlm)))
+	init := berTLV(0xa0, berTLV(0x30, mechs))
+	return berTLV(0x60, append(berTLV(0x06, oidSpnego), init...))
+}
+
+func spnegoResp(state int, token []byte) []byte {
+	var resp []byte
+	resp = append(resp, berTLV(0xa0, berEncodeInt(0x0a, state))...)
+	resp = append(resp, berTLV(0xa1, berTLV(0x06, oidNtlm))...)
+	resp = append(resp, berTLV(0xa2, berTLV(0x04, token))...)
+	return berTLV(0xa1, berTLV(0x30, resp))
+}
+
+// fileTime is 100ns since 1601-01-01
+func fileTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestNtlmAuthenticate(t *testing.T) {
+	challenge := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	msg := ntlmChallenge(challenge, 0)
+	if !bytes.Equal(msg[24:32], challenge) {
+		t.Fatalf("ntlmChallenge challenge(%x) invalid", msg[24:32])
+	}
+	if name := ntlmString(ntlmField(msg, 12), true); name != "GODNSLOG" {
+		t.Fatalf("ntlmChallenge target name(%v) invalid", name)
+	}
+
+	//NTLMv2 response: NTProofStr, blob header, av pairs
+	nt := make([]byte, 16+28)
+	target := ntlmUnicode("cifs/token.abc.example.com")
+	av := make([]byte, 4)
+	binary.LittleEndian.PutUint16(av[0:], ntlmAvTargetName)
+	binary.LittleEndian.PutUint16(av[2:], uint16(len(target)))
+	nt = append(append(append(nt, av...), target...), 0, 0, 0, 0)
+
+	fields := [][]byte{nil, nt, ntlmUnicode("CORP"), ntlmUnicode("alice"), ntlmUnicode("DESKTOP-1")}
+	auth := make([]byte, 72)
+	copy(auth, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(auth[8:], ntlmAuthenticateMessage)
+	binary.LittleEndian.PutUint32(auth[60:], ntlmFlags)
+	copy(auth[64:], []byte{10, 0, 0x61, 0x4a})
+	for i, field := range fields {
+		binary.LittleEndian.PutUint16(auth[12+8*i:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(auth[16+8*i:], uint32(len(auth)))
+		auth = append(auth, field...)
+	}
+
+	a, err := ntlmParseAuthenticate(auth, challenge)
+	if err != nil {
+		t.Fatalf("ntlmParseAuthenticate: %v", err)
+	}
+	if a.Domain != "CORP" || a.User != "alice" || a.Host != "DESKTOP-1" || a.Os != "10.0.19041" {
+		t.Fatalf("ntlmParseAuthenticate(%+v) invalid", a)
+	}
+	if a.Target != "cifs/token.abc.example.com" {
+		t.Fatalf("target(%v) invalid", a.Target)
+	}
+	if !bytes.HasPrefix([]byte(a.Hash), []byte("alice::CORP:0102030405060708:")) {
+		t.Fatalf("hash(%v) invalid", a.Hash)
+	}
+
+	if _, err = ntlmParseAuthenticate(auth[:40], challenge); err == nil {
+		t.Fatalf("ntlmParseAuthenticate should fail with short message")
+	}
+}
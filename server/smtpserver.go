@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+)
+
+/*
+SMTP catcher, accept any mail for the log domain
+	swaks --to whoami@userXXXX.example.com --server example.com
+	gopher://example.com:25/_HELO...
+*/
+
+const (
+	MAX_SMTP_RCPT = 100
+)
+
+type SmtpServerConfig struct {
+	Domain  string
+	Listen  []string //eg. :25, :587
+	Timeout time.Duration
+	MaxSize int64 //max message size to save
+}
+
+type SmtpServer struct {
+	SmtpServerConfig
+	tcpServer
+}
+
+func NewSmtpServer(cfg *SmtpServerConfig, store *cache.Cache) (*SmtpServer, error) {
+	s := &SmtpServer{
+		SmtpServerConfig: *cfg,
+	}
+	s.name = "smtp"
+	s.store = store
+	s.Domain = strings.TrimSuffix(s.Domain, ".")
+	return s, nil
+}
+
+func (s *SmtpServer) Run() {
+	s.run(s.Listen, s.handle)
+}
+
+func (s *SmtpServer) Shutdown() {
+	s.shutdown()
+}
+
+// smtpSession is state of one mail transaction
+type smtpSession struct {
+	helo string
+	from string
+	to   []string
+	done bool //DATA received
+}
+
+func (s *SmtpServer) handle(conn net.Conn) {
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	tp := textproto.NewConn(conn)
+
+	var sess smtpSession
+	reply := func(format string, args ...interface{}) bool {
+		conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+		return tp.PrintfLine(format, args...) == nil
+	}
+	// mail without DATA is also logged, eg. blind email injection break the transaction
+	defer func() {
+		if len(sess.to) > 0 && !sess.done {
+			s.logMail(ip, &sess, nil, 0, false)
+		}
+	}()
+
+	if !reply("220 %v ESMTP ready", s.Domain) {
+		return
+	}
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.Timeout))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg := line, ""
+		if idx := strings.IndexByte(line, ' '); idx > 0 {
+			cmd, arg = line[:idx], strings.TrimSpace(line[idx+1:])
+		}
+
+		switch strings.ToUpper(cmd) {
+		case "HELO":
+			sess.helo = arg
+			reply("250 %v", s.Domain)
+		case "EHLO":
+			sess.helo = arg
+			reply("250-%v\r\n250-SIZE %v\r\n250 8BITMIME", s.Domain, s.MaxSize)
+		case "MAIL":
+			if len(sess.to) > 0 && !sess.done {
+				s.logMail(ip, &sess, nil, 0, false)
+			}
+			sess = smtpSession{helo: sess.helo, from: smtpAddress(arg, "FROM:")}
+			reply("250 OK")
+		case "RCPT":
+			rcpt := smtpAddress(arg, "TO:")
+			at := strings.LastIndexByte(rcpt, '@')
+			domain := strings.ToLower(rcpt[at+1:])
+			if domain != s.Domain && !strings.HasSuffix(domain, "."+s.Domain) {
+				reply("550 relay not permitted")
+			} else if len(sess.to) >= MAX_SMTP_RCPT {
+				reply("452 too many recipients")
+			} else {
+				sess.to = append(sess.to, rcpt)
+				reply("250 OK")
+			}
+		case "DATA":
+			if len(sess.to) == 0 {
+				reply("503 RCPT first")
+				continue
+			}
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(s.Timeout))
+			data, size, truncated, err := readLimited(tp.DotReader(), s.MaxSize)
+			if err != nil && err != errDiscardLimit {
+				return
+			}
+			sess.done = true
+			s.logMail(ip, &sess, data, size, truncated)
+			if err == errDiscardLimit {
+				//the rest of DATA can not be told from commands
+				reply("552 message size exceeds limit")
+				return
+			}
+			reply("250 OK queued")
+		case "RSET":
+			sess = smtpSession{helo: sess.helo}
+			reply("250 OK")
+		case "NOOP":
+			reply("250 OK")
+		case "VRFY":
+			reply("252 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+// smtpAddress get address from `FROM:<a@b.com> SIZE=100`
+func smtpAddress(arg, prefix string) string {
+	if len(arg) >= len(prefix) && strings.EqualFold(arg[:len(prefix)], prefix) {
+		arg = strings.TrimSpace(arg[len(prefix):])
+	}
+	if strings.HasPrefix(arg, "<") {
+		if idx := strings.IndexByte(arg, '>'); idx > 0 {
+			return arg[1:idx]
+		}
+	}
+	if idx := strings.IndexByte(arg, ' '); idx > 0 {
+		arg = arg[:idx]
+	}
+	return arg
+}
+
+// logMail save mail for each user of recipients
+func (s *SmtpServer) logMail(ip string, sess *smtpSession, data []byte, size int64, truncated bool) {
+	var headers, body, subject string
+	msg := string(data)
+	if idx := strings.Index(msg, "\r\n\r\n"); idx >= 0 {
+		headers, body = msg[:idx+2], msg[idx+4:]
+	} else if idx := strings.Index(msg, "\n\n"); idx >= 0 {
+		headers, body = msg[:idx+1], msg[idx+2:]
+	} else {
+		headers = msg
+	}
+	hr := textproto.NewReader(bufio.NewReader(strings.NewReader(headers + "\r\n")))
+	if h, err := hr.ReadMIMEHeader(); err == nil {
+		subject = h.Get("Subject")
+	}
+
+	logged := make(map[int64]bool)
+	for _, rcpt := range sess.to {
+		at := strings.LastIndexByte(rcpt, '@')
+		prefix, shortId, _ := parseUserDomain(s.store, strings.ToLower(rcpt[at+1:]), s.Domain)
+
+		var uid int64
+		if v, exist := s.store.Get(shortId + ".suser"); exist {
+			uid = v.(*models.TblUser).Id
+		}
+		if logged[uid] {
+			continue
+		}
+		logged[uid] = true
+
+		//token in subdomain first, then local part
+		variable := prefix
+		if variable == "" && at > 0 {
+			variable = rcpt[:at]
+		}
+		s.log(&SmtpRecord{
+			Uid:       uid,
+			Var:       variable,
+			Ip:        ip,
+			Helo:      sess.helo,
+			From:      sess.from,
+			To:        sess.to,
+			Subject:   subject,
+			Headers:   headers,
+			Body:      body,
+			Size:      size,
+			Truncated: truncated,
+			Ctime:     time.Now(),
+		})
+	}
+}
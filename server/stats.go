@@ -0,0 +1,246 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/chennqqi/goutils/ginutils"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Aggregate statistics for dashboard, grouping is done in sql
+	GET /api/data/stats?from=2020-05-01T00:00:00Z&bucket=day&top=10
+admins may add uid=N for other users
+*/
+
+const (
+	DEFAULT_STATS_RANGE = 7 * 24 * time.Hour
+	MAX_STATS_TOP       = 100
+)
+
+// statsUnion select cols of all record types in time range as sub query
+func statsUnion(cols string, uid int64, from, to string) (string, []interface{}) {
+	var selects []string
+	var args []interface{}
+	for _, typ := range searchTypes {
+		selects = append(selects, fmt.Sprintf(`SELECT '%v' AS type, %v FROM tbl_%v WHERE uid = ? AND ctime >= ? AND ctime < ?`,
+			typ, cols, typ))
+		args = append(args, uid, from, to)
+	}
+	return `(` + strings.Join(selects, ` UNION ALL `) + `) t`, args
+}
+
+func (self *WebServer) getStats(c *gin.Context) {
+	uid, ok := self.statsUid(c)
+	if !ok {
+		return
+	}
+
+	to := time.Now()
+	from := to.Add(-DEFAULT_STATS_RANGE)
+	var err error
+	if s := c.Query("from"); s != "" {
+		if from, err = parseTime(s); err != nil {
+			self.resp(c, 400, &CR{
+				Message: fmt.Sprintf("invalid from: %v", s),
+				Code:    CodeBadData,
+			})
+			return
+		}
+	}
+	if s := c.Query("to"); s != "" {
+		if to, err = parseTime(s); err != nil {
+			self.resp(c, 400, &CR{
+				Message: fmt.Sprintf("invalid to: %v", s),
+				Code:    CodeBadData,
+			})
+			return
+		}
+	}
+	bucket := c.DefaultQuery("bucket", "hour")
+	if bucket != "hour" && bucket != "day" {
+		self.resp(c, 400, &CR{
+			Message: "bucket should be hour or day",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	top, err := ginutils.GetQueryInt(c, "top")
+	if err != nil || top <= 0 {
+		top = 10
+	} else if top > MAX_STATS_TOP {
+		top = MAX_STATS_TOP
+	}
+
+	bucketExpr := self.bucketExpr(bucket)
+	fromStr, toStr := statsTime(from), statsTime(to)
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	resp := &models.Stats{
+		From:    from,
+		To:      to,
+		Bucket:  bucket,
+		Buckets: []models.StatsBucket{},
+	}
+
+	resp.Counts, resp.Total, err = self.statsCounts(uid, fromStr, toStr)
+	if err != nil {
+		logrus.Errorf("[stats.go::getStats] statsCounts: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	sub, args := statsUnion(bucketExpr+` AS bucket`, uid, fromStr, toStr)
+	err = session.SQL(`SELECT bucket AS time, type, count(*) AS count FROM `+sub+` GROUP BY bucket, type ORDER BY bucket`,
+		args...).Find(&resp.Buckets)
+	if err != nil {
+		logrus.Errorf("[stats.go::getStats] orm.Find(buckets): %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	resp.TopIps, err = self.statsTop(`ip`, uid, fromStr, toStr, top)
+	if err != nil {
+		logrus.Errorf("[stats.go::getStats] statsTop(ip): %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	resp.TopTokens, err = self.statsTop(self.tokenExpr(), uid, fromStr, toStr, top)
+	if err != nil {
+		logrus.Errorf("[stats.go::getStats] statsTop(token): %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  resp,
+	})
+}
+
+// statsUid is current user, admins may query other users by uid
+func (self *WebServer) statsUid(c *gin.Context) (int64, bool) {
+	uid := c.GetInt64("id")
+	if v, err := ginutils.GetQueryInt(c, "uid"); err == nil {
+		switch c.GetInt("role") {
+		case roleAdmin, roleSuper:
+			uid = int64(v)
+		default:
+			//stats of members of teams
+			for _, member := range self.teamUids(uid, false) {
+				if member == int64(v) {
+					return member, true
+				}
+			}
+			self.resp(c, 403, &CR{
+				Message: "Permission denied",
+				Code:    CodeNoPermission,
+			})
+			return 0, false
+		}
+	}
+	return uid, true
+}
+
+// statsTime format time as ctime column of sqlite, mysql and postgres
+func statsTime(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// tokenExpr is token of var, variable of http is /token
+func (self *WebServer) tokenExpr() string {
+	if self.orm.DriverName() == "mysql" {
+		return `TRIM(LEADING '/' FROM var)`
+	}
+	return `ltrim(var, '/')`
+}
+
+// statsCounts count records of each type
+func (self *WebServer) statsCounts(uid int64, from, to string) (map[string]int64, int64, error) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var counts []struct {
+		Type  string
+		Count int64
+	}
+	sub, args := statsUnion(`id`, uid, from, to)
+	err := session.SQL(`SELECT type, count(*) AS count FROM `+sub+` GROUP BY type`, args...).Find(&counts)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := make(map[string]int64)
+	for _, typ := range searchTypes {
+		result[typ] = 0
+	}
+	var total int64
+	for _, item := range counts {
+		result[item.Type] = item.Count
+		total += item.Count
+	}
+	return result, total, nil
+}
+
+// bucketExpr format ctime as hour or day
+func (self *WebServer) bucketExpr(bucket string) string {
+	switch self.orm.DriverName() {
+	case "mysql":
+		if bucket == "day" {
+			return `DATE_FORMAT(ctime, '%Y-%m-%d')`
+		}
+		return `DATE_FORMAT(ctime, '%Y-%m-%d %H:00')`
+	case "postgres":
+		if bucket == "day" {
+			return `to_char(ctime, 'YYYY-MM-DD')`
+		}
+		return `to_char(ctime, 'YYYY-MM-DD HH24:00')`
+	default:
+		if bucket == "day" {
+			return `strftime('%Y-%m-%d', ctime)`
+		}
+		return `strftime('%Y-%m-%d %H:00', ctime)`
+	}
+}
+
+// statsTop most frequent non-empty value of expr
+func (self *WebServer) statsTop(expr string, uid int64, from, to string, top int) ([]models.StatsTop, error) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	items := []models.StatsTop{}
+	sub, args := statsUnion(expr+` AS k`, uid, from, to)
+	err := session.SQL(fmt.Sprintf(`SELECT k AS value, count(*) AS count FROM %v WHERE k <> '' GROUP BY k ORDER BY count(*) DESC LIMIT %v`,
+		sub, top), args...).Find(&items)
+	return items, err
+}
+
+// statsNewTokens tokens first hit in [from, to), most frequent first
+func (self *WebServer) statsNewTokens(uid int64, from, to string, top int) ([]models.StatsTop, error) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	items := []models.StatsTop{}
+	sub, args := statsUnion(self.tokenExpr()+` AS k, ctime AS t`, uid, statsTime(time.Time{}), to)
+	args = append(args, from)
+	err := session.SQL(fmt.Sprintf(`SELECT k AS value, count(*) AS count FROM %v WHERE k <> '' GROUP BY k HAVING min(t) >= ? ORDER BY count(*) DESC LIMIT %v`,
+		sub, top), args...).Find(&items)
+	return items, err
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"xorm.io/builder"
+	"xorm.io/xorm"
+)
+
+/*
+Store of dns and http hits, queried and cleaned by conditions of xorm builder
+	sqlStore: tables of the database, default
+	chStore: tables of clickhouse, -clickhouse
+set WebServerConfig.Store to plug another backend, eg. a fake in unit tests
+*/
+
+// HitQuery is conditions and order of hits
+type HitQuery struct {
+	Cond   builder.Cond
+	Cols   []string //all columns if empty
+	Asc    bool     //order by id
+	Limit  int      //0 is unlimited
+	Offset int
+}
+
+type Store interface {
+	//insert records, ids are set
+	InsertDNS(items ...*models.TblDns) error
+	InsertHTTP(items ...*models.TblHttp) error
+
+	//find records into *[]models.TblDns or *[]models.TblHttp
+	Query(rowsSlicePtr interface{}, q *HitQuery) error
+	//iterate records of bean type one by one
+	Iterate(bean interface{}, q *HitQuery, fn func(bean interface{}) error) error
+	Count(bean interface{}, cond builder.Cond) (int64, error)
+	//delete records of bean type, count of deleted records is returned
+	Clean(bean interface{}, cond builder.Cond) (int64, error)
+}
+
+type sqlStore struct {
+	orm *xorm.Engine
+}
+
+func NewSqlStore(orm *xorm.Engine) Store {
+	return &sqlStore{orm: orm}
+}
+
+func (s *sqlStore) session(q *HitQuery) *xorm.Session {
+	session := s.orm.Where(q.Cond)
+	if len(q.Cols) > 0 {
+		session = session.Cols(q.Cols...)
+	}
+	if q.Asc {
+		session = session.Asc("id")
+	} else {
+		session = session.Desc("id")
+	}
+	if q.Limit > 0 {
+		session = session.Limit(q.Limit, q.Offset)
+	}
+	return session
+}
+
+func (s *sqlStore) InsertDNS(items ...*models.TblDns) error {
+	beans := make([]interface{}, len(items))
+	for i, item := range items {
+		beans[i] = item
+	}
+	return insertMulti(s.orm, beans)
+}
+
+func (s *sqlStore) InsertHTTP(items ...*models.TblHttp) error {
+	beans := make([]interface{}, len(items))
+	for i, item := range items {
+		beans[i] = item
+	}
+	return insertMulti(s.orm, beans)
+}
+
+func (s *sqlStore) Query(rowsSlicePtr interface{}, q *HitQuery) error {
+	return s.session(q).Find(rowsSlicePtr)
+}
+
+func (s *sqlStore) Iterate(bean interface{}, q *HitQuery, fn func(bean interface{}) error) error {
+	return s.session(q).Iterate(bean, func(idx int, bean interface{}) error {
+		return fn(bean)
+	})
+}
+
+func (s *sqlStore) Count(bean interface{}, cond builder.Cond) (int64, error) {
+	return s.orm.Where(cond).Count(bean)
+}
+
+func (s *sqlStore) Clean(bean interface{}, cond builder.Cond) (int64, error) {
+	return s.orm.Where(cond).Delete(bean)
+}
+
+// chStore keep hits in clickhouse, columns of query are not selected
+type chStore struct {
+	ch  *ClickHouse
+	orm *xorm.Engine //table info of beans
+}
+
+func NewClickHouseStore(ch *ClickHouse, orm *xorm.Engine) Store {
+	return &chStore{ch: ch, orm: orm}
+}
+
+func (s *chStore) InsertDNS(items ...*models.TblDns) error {
+	for _, item := range items {
+		item.Id = s.ch.NextId()
+		item.Atime = time.Now()
+		if err := s.ch.Insert(s.orm, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *chStore) InsertHTTP(items ...*models.TblHttp) error {
+	for _, item := range items {
+		item.Id = s.ch.NextId()
+		item.Atime = time.Now()
+		if err := s.ch.Insert(s.orm, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *chStore) Query(rowsSlicePtr interface{}, q *HitQuery) error {
+	return s.ch.Find(s.orm, rowsSlicePtr, q.Cond, q.Asc, q.Limit, q.Offset)
+}
+
+func (s *chStore) Iterate(bean interface{}, q *HitQuery, fn func(bean interface{}) error) error {
+	rows := reflect.New(reflect.SliceOf(reflect.TypeOf(bean).Elem()))
+	err := s.Query(rows.Interface(), q)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < rows.Elem().Len(); i++ {
+		if err = fn(rows.Elem().Index(i).Addr().Interface()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *chStore) Count(bean interface{}, cond builder.Cond) (int64, error) {
+	return s.ch.Count(s.orm, bean, cond)
+}
+
+func (s *chStore) Clean(bean interface{}, cond builder.Cond) (int64, error) {
+	count, err := s.ch.Count(s.orm, bean, cond)
+	if err != nil || count == 0 {
+		return count, err
+	}
+	return count, s.ch.Delete(s.orm, bean, cond)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"xorm.io/xorm"
+)
+
+/*
+Record tags, for triage of findings, eg. confirmed, noise, follow-up
+	PUT /api/data/dns/:id/tags {"tags":["confirmed"]}
+	GET /api/record/dns?tag=confirmed
+*/
+
+const (
+	MAX_RECORD_TAGS = 16
+)
+
+// no `_` or `%`, tags are matched by like
+var tagRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.:-]{0,31}$`)
+
+// parseTags verify and deduplicate tags
+func parseTags(tags []string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		if !tagRegexp.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag: %v", tag)
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	if len(result) > MAX_RECORD_TAGS {
+		return nil, fmt.Errorf("at most %v tags", MAX_RECORD_TAGS)
+	}
+	return result, nil
+}
+
+// tagCond filter records by tag, tags are saved as json array
+func tagCond(session *xorm.Session, tag string) *xorm.Session {
+	return session.And(`tags like ?`, `%"`+tag+`"%`)
+}
+
+func (self *WebServer) setDnsTags(c *gin.Context) {
+	self.setRecordTags(c, func(tags []string) interface{} {
+		return &models.TblDns{Tags: tags}
+	})
+}
+
+func (self *WebServer) setHttpTags(c *gin.Context) {
+	self.setRecordTags(c, func(tags []string) interface{} {
+		return &models.TblHttp{Tags: tags}
+	})
+}
+
+// setRecordTags replace tags of record, bean is table with tags to update
+func (self *WebServer) setRecordTags(c *gin.Context, bean func([]string) interface{}) {
+	rid, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	var req TagRequest
+	err = c.ShouldBindJSON(&req)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	tags, err := parseTags(req.Tags)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	if !self.updateRecord(c, rid, bean(nil), bean(tags), "tags") {
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  tags,
+	})
+}
+
+// updateRecord update cols of visible record, empty is zero value of table
+func (self *WebServer) updateRecord(c *gin.Context, rid int64, empty, rcd interface{}, cols ...string) bool {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	uids := self.teamUids(c.GetInt64("id"), true)
+	visible := func() *xorm.Session {
+		switch role {
+		case roleAdmin, roleSuper:
+			return session.ID(rid)
+		default:
+			return session.ID(rid).In("uid", uids)
+		}
+	}
+
+	exist, err := visible().Exist(empty)
+	if err != nil {
+		logrus.Errorf("[tag.go::updateRecord] orm.Exist: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return false
+	} else if !exist {
+		self.resp(c, 404, &CR{
+			Message: "No such record",
+			Code:    CodeNoData,
+		})
+		return false
+	}
+
+	_, err = visible().Cols(cols...).Update(rcd)
+	if err != nil {
+		logrus.Errorf("[tag.go::updateRecord] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return false
+	}
+	return true
+}
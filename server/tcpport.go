@@ -0,0 +1,286 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Generic tcp port catcher, for protocols not implemented natively
+	send banner, log first MaxBytes received, then close
+*/
+
+const (
+	MAX_TCP_BYTES  = 64 * 1024
+	MAX_TCP_BANNER = 4096
+)
+
+type TcpPortServerConfig struct {
+	Timeout time.Duration
+}
+
+type tcpPort struct {
+	models.TblTcpPort
+	l net.Listener
+}
+
+type TcpPortServer struct {
+	TcpPortServerConfig
+	tcpServer
+
+	portLock sync.Mutex
+	ports    map[int]*tcpPort
+}
+
+func NewTcpPortServer(cfg *TcpPortServerConfig, store *cache.Cache) (*TcpPortServer, error) {
+	s := &TcpPortServer{
+		TcpPortServerConfig: *cfg,
+		ports:               make(map[int]*tcpPort),
+	}
+	s.name = "tcp"
+	s.store = store
+	return s, nil
+}
+
+// Update listen ports, changed ports are restarted, error of each port is returned
+func (s *TcpPortServer) Update(items []models.TblTcpPort) map[int]error {
+	s.portLock.Lock()
+	defer s.portLock.Unlock()
+
+	errs := make(map[int]error)
+	want := make(map[int]models.TblTcpPort)
+	for _, item := range items {
+		want[item.Port] = item
+	}
+	for port, p := range s.ports {
+		item, exist := want[port]
+		if exist && item.Banner == p.Banner && item.MaxBytes == p.MaxBytes {
+			delete(want, port)
+			continue
+		}
+		p.l.Close()
+		delete(s.ports, port)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.quit {
+		return errs
+	}
+	for port, item := range want {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
+		if err != nil {
+			logrus.Errorf("[tcpport.go::Update] listen(%v): %v", port, err)
+			errs[port] = err
+			continue
+		}
+		p := &tcpPort{TblTcpPort: item, l: l}
+		s.ports[port] = p
+		s.listeners = append(s.listeners, l)
+		go s.serve(s.TrustedProxies.Listener(l), func(conn net.Conn) {
+			s.handle(conn, p)
+		})
+	}
+	return errs
+}
+
+func (s *TcpPortServer) Shutdown() {
+	s.shutdown()
+}
+
+func (s *TcpPortServer) handle(conn net.Conn, p *tcpPort) {
+	ip, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	if p.Banner != "" {
+		conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+		conn.Write([]byte(p.Banner))
+	}
+	conn.SetReadDeadline(time.Now().Add(s.Timeout))
+	buf := make([]byte, p.MaxBytes)
+	n, _ := io.ReadFull(conn, buf)
+	if n == 0 {
+		return
+	}
+	data := buf[:n]
+
+	variable, user := s.lookupToken(string(data))
+	rcd := &TcpRecord{
+		Ip:    ip,
+		Port:  p.Port,
+		Var:   variable,
+		Data:  hex.Dump(data),
+		Size:  int64(n),
+		Ctime: time.Now(),
+	}
+	if user != nil {
+		rcd.Uid = user.Id
+	} else {
+		rcd.Var = ""
+	}
+	s.log(rcd)
+}
+
+//==============================================================================
+// tcp port api
+//==============================================================================
+func (self *WebServer) loadTcpPorts() (map[int]error, error) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblTcpPort
+	err := session.Find(&items)
+	if err != nil {
+		return nil, err
+	}
+	return self.TcpPorts.Update(items), nil
+}
+
+func (self *WebServer) getTcpPorts(c *gin.Context) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblTcpPort
+	err := session.Asc("port").Find(&items)
+	if err != nil {
+		logrus.Errorf("[tcpport.go::getTcpPorts] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	ports := make([]models.TcpPort, len(items))
+	for i := 0; i < len(items); i++ {
+		port := &ports[i]
+		item := &items[i]
+		port.Id = item.Id
+		port.Port = item.Port
+		port.Banner = item.Banner
+		port.MaxBytes = item.MaxBytes
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  ports,
+	})
+}
+
+func (self *WebServer) addTcpPort(c *gin.Context) {
+	if self.TcpPorts == nil {
+		self.resp(c, 400, &CR{
+			Message: "tcp catcher disabled",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	var req TcpPort
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		logrus.Infof("[tcpport.go::addTcpPort] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.MaxBytes == 0 {
+		req.MaxBytes = 1024
+	}
+	if req.Port <= 0 || req.Port > 65535 || req.MaxBytes < 0 || req.MaxBytes > MAX_TCP_BYTES ||
+		len(req.Banner) > MAX_TCP_BANNER {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("port should be in [1, 65535], maxBytes should be less than %v", MAX_TCP_BYTES),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	item := models.TblTcpPort{
+		Port:     req.Port,
+		Banner:   req.Banner,
+		MaxBytes: req.MaxBytes,
+	}
+	_, err = session.InsertOne(&item)
+	if self.IsDuplicate(err) {
+		self.resp(c, 400, &CR{
+			Message: "port exist",
+			Code:    CodeBadData,
+		})
+		return
+	} else if err != nil {
+		logrus.Errorf("[tcpport.go::addTcpPort] orm.InsertOne: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	errs, err := self.loadTcpPorts()
+	if err != nil {
+		logrus.Errorf("[tcpport.go::addTcpPort] loadTcpPorts: %v", err)
+	} else if errs[req.Port] != nil {
+		session.ID(item.Id).Delete(&models.TblTcpPort{})
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("listen failed: %v", errs[req.Port]),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  item.Id,
+	})
+}
+
+func (self *WebServer) delTcpPorts(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[tcpport.go::delTcpPorts] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	params := make([]interface{}, len(req.Ids))
+	for i := 0; i < len(req.Ids); i++ {
+		params[i] = req.Ids[i]
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	_, err = session.In("id", params...).Delete(&models.TblTcpPort{})
+	if err != nil {
+		logrus.Errorf("[tcpport.go::delTcpPorts] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	if self.TcpPorts != nil {
+		_, err = self.loadTcpPorts()
+		if err != nil {
+			logrus.Errorf("[tcpport.go::delTcpPorts] loadTcpPorts: %v", err)
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
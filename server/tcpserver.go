@@ -0,0 +1,119 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/sirupsen/logrus"
+)
+
+// tcpServer serve tcp listeners of catchers, records are sent to store asynchronously
+type tcpServer struct {
+	name  string
+	store *cache.Cache
+
+	//source ip of connections by PROXY protocol header of trusted proxies, disabled if nil
+	TrustedProxies *TrustedProxies
+
+	lock      sync.Mutex
+	listeners []net.Listener
+	quit      bool
+
+	wg sync.WaitGroup
+}
+
+func (s *tcpServer) run(addrs []string, handle func(net.Conn)) {
+	var wg sync.WaitGroup
+
+	for _, addr := range addrs {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			logrus.Errorf("[tcpserver.go::run] %v listen(%v): %v", s.name, addr, err)
+			continue
+		}
+		s.lock.Lock()
+		if s.quit {
+			s.lock.Unlock()
+			l.Close()
+			break
+		}
+		s.listeners = append(s.listeners, l)
+		s.lock.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serve(s.TrustedProxies.Listener(l), handle)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (s *tcpServer) serve(l net.Listener, handle func(net.Conn)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+func (s *tcpServer) shutdown() {
+	s.lock.Lock()
+	s.quit = true
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+}
+
+func (s *tcpServer) log(rcd interface{}) {
+	//wait a while if queue is full, dropped records are counted in queue stats
+	s.store.Push(rcd)
+}
+
+// lookupToken find user by shortId in s, which has no host, eg. ldap dn, ftp path
+func (s *tcpServer) lookupToken(str string) (string, *models.TblUser) {
+	var user *models.TblUser
+	variable, _ := parseToken(str, func(shortId string) bool {
+		v, exist := s.store.Get(shortId + ".suser")
+		if exist {
+			user = v.(*models.TblUser)
+		}
+		return exist
+	})
+	return variable, user
+}
+
+// parseToken find shortId in s, the parts before shortId is variable
+// token.userXXXX => token, userXXXX
+func parseToken(s string, isUser func(string) bool) (variable, shortId string) {
+	parts := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+	})
+	for i, part := range parts {
+		if isUser(part) {
+			return strings.Join(parts[:i], "."), part
+		}
+	}
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	return s, ""
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestParseToken(t *testing.T) {
+	isUser := func(s string) bool { return s == "u3yszl9n" }
+	var tests = []struct {
+		Str     string
+		Var     string
+		ShortId string
+	}{
+		{"token.u3yszl9n", "token", "u3yszl9n"},
+		{"a.B.U3YSZL9N", "a.b", "u3yszl9n"},
+		{"cn=x,dc=u3yszl9n", "cn.x.dc", "u3yszl9n"},
+		{"/u3yszl9n/etc/passwd", "", "u3yszl9n"},
+		{"Basic/Command/whoami", "Basic/Command/whoami", ""},
+	}
+	for _, test := range tests {
+		variable, shortId := parseToken(test.Str, isUser)
+		if variable != test.Var || shortId != test.ShortId {
+			t.Fatalf("parseToken(%v)=(%v, %v)!=expect(%v, %v)", test.Str, variable, shortId, test.Var, test.ShortId)
+		}
+	}
+}
@@ -0,0 +1,627 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Teams of users sharing records, eg. an engagement team instead of a shared account
+	GET|PUT|POST|DELETE /api/setting/teams {"name":"acme-pentest"}
+	PUT    /api/setting/teams/:id/members {"username":"bob","write":true} or {"email":"bob@example.com"}
+	POST   /api/setting/teams/:id/members {"uid":3,"write":false}
+	DELETE /api/setting/teams/:id/members {"ids":[3]}
+	POST   /api/setting/teams/:id/accept
+added users are invited, they are members after they accept, invited users decline by deleting themselves.
+members read records and sessions of each other in web ui, and see payload domains of each other to share tokens.
+members with write delete, tag and note records of other members by ids, deleting all records is for own records only.
+the creator is owner, teams and members are managed by owner or admin, a member may leave by deleting itself.
+*/
+
+const (
+	MAX_USER_TEAMS   = 16
+	MAX_TEAM_MEMBERS = 64
+	MAX_TEAM_NAME    = 64
+)
+
+// teamAccess is users whose records are visible to a user, the user included
+type teamAccess struct {
+	Read  []int64
+	Write []int64
+}
+
+// teamAccessOf get members of teams of uid, cached until members of its teams change
+func (self *WebServer) teamAccessOf(uid int64) (*teamAccess, error) {
+	key := fmt.Sprintf("%v.teams", uid)
+	if v, exist := self.store.Get(key); exist {
+		return v.(*teamAccess), nil
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var own []models.TblTeamMember
+	if err := session.Where(`uid=? AND accepted=?`, uid, true).Find(&own); err != nil {
+		return nil, err
+	}
+	write := make(map[int64]bool)
+	tids := make([]int64, len(own))
+	for i := 0; i < len(own); i++ {
+		tids[i] = own[i].Tid
+		write[own[i].Tid] = own[i].Write
+	}
+	var members []models.TblTeamMember
+	if len(tids) > 0 {
+		if err := session.Where(`accepted=?`, true).In("tid", tids).Find(&members); err != nil {
+			return nil, err
+		}
+	}
+
+	access := &teamAccess{Read: []int64{uid}, Write: []int64{uid}}
+	read, written := map[int64]bool{uid: true}, map[int64]bool{uid: true}
+	for i := 0; i < len(members); i++ {
+		m := &members[i]
+		if !read[m.Uid] {
+			read[m.Uid] = true
+			access.Read = append(access.Read, m.Uid)
+		}
+		if write[m.Tid] && !written[m.Uid] {
+			written[m.Uid] = true
+			access.Write = append(access.Write, m.Uid)
+		}
+	}
+	self.store.Set(key, access, cache.NoExpiration)
+	return access, nil
+}
+
+// teamUids is users whose records uid reads, or changes if write, only uid on error
+func (self *WebServer) teamUids(uid int64, write bool) []int64 {
+	access, err := self.teamAccessOf(uid)
+	if err != nil {
+		logrus.Errorf("[team.go::teamUids] teamAccessOf(%v): %v", uid, err)
+		return []int64{uid}
+	}
+	if write {
+		return access.Write
+	}
+	return access.Read
+}
+
+// teamMemberUids is members of teams
+func (self *WebServer) teamMemberUids(tids ...int64) []int64 {
+	var members []models.TblTeamMember
+	if err := self.orm.In("tid", tids).Cols("uid").Find(&members); err != nil {
+		logrus.Errorf("[team.go::teamMemberUids] orm.Find: %v", err)
+		return nil
+	}
+	uids := make([]int64, len(members))
+	for i := 0; i < len(members); i++ {
+		uids[i] = members[i].Uid
+	}
+	return uids
+}
+
+// resetTeamAccess drop cached access of users after members of their teams changed
+func (self *WebServer) resetTeamAccess(uids ...int64) {
+	for _, uid := range uids {
+		self.store.Delete(fmt.Sprintf("%v.teams", uid))
+	}
+}
+
+// manageableTeam get team of id which user of request owns, or any team for admins
+func (self *WebServer) manageableTeam(c *gin.Context, tid int64) (*models.TblTeam, bool) {
+	team := new(models.TblTeam)
+	exist, err := self.orm.ID(tid).Get(team)
+	if err != nil {
+		logrus.Errorf("[team.go::manageableTeam] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return nil, false
+	}
+	if !exist {
+		self.resp(c, 404, &CR{
+			Message: "team not found",
+			Code:    CodeNoData,
+		})
+		return nil, false
+	}
+	switch c.GetInt("role") {
+	case roleAdmin, roleSuper:
+	default:
+		if team.Uid != c.GetInt64("id") {
+			self.resp(c, 403, &CR{
+				Message: "bad permission, owner of team only",
+				Code:    CodeNoPermission,
+			})
+			return nil, false
+		}
+	}
+	return team, true
+}
+
+func (self *WebServer) teamParam(c *gin.Context) (int64, bool) {
+	tid, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || tid <= 0 {
+		self.resp(c, 400, &CR{
+			Message: "invalid team id",
+			Code:    CodeBadData,
+		})
+		return 0, false
+	}
+	return tid, true
+}
+
+// GET /api/setting/teams, teams of user, all teams for admins
+func (self *WebServer) getTeams(c *gin.Context) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	id := c.GetInt64("id")
+	admin := false
+	switch c.GetInt("role") {
+	case roleAdmin, roleSuper:
+		admin = true
+	default:
+		session = session.Where(`id IN (SELECT tid FROM tbl_team_member WHERE uid=?)`, id)
+	}
+	var teams []models.TblTeam
+	if err := session.Asc("id").Find(&teams); err != nil {
+		logrus.Errorf("[team.go::getTeams] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	resp := make([]Team, len(teams))
+	index := make(map[int64]*Team)
+	tids := make([]int64, len(teams))
+	for i := 0; i < len(teams); i++ {
+		resp[i] = Team{
+			Id:      teams[i].Id,
+			Name:    teams[i].Name,
+			Uid:     teams[i].Uid,
+			Members: []models.TeamMember{},
+			Atime:   teams[i].Atime,
+		}
+		index[teams[i].Id] = &resp[i]
+		tids[i] = teams[i].Id
+	}
+	if len(tids) > 0 {
+		var members []models.TblTeamMember
+		if err := self.orm.In("tid", tids).Asc("id").Find(&members); err != nil {
+			logrus.Errorf("[team.go::getTeams] orm.Find: %v", err)
+			self.resp(c, 502, &CR{
+				Message: "Failed",
+				Code:    CodeServerInternal,
+			})
+			return
+		}
+		//invited users see only themselves until they accept
+		invited := make(map[int64]bool)
+		uids := make([]int64, len(members))
+		for i := 0; i < len(members); i++ {
+			uids[i] = members[i].Uid
+			if members[i].Uid == id && !members[i].Accepted && !admin {
+				invited[members[i].Tid] = true
+			}
+		}
+		users := make(map[int64]*models.TblUser)
+		if len(uids) > 0 {
+			var items []models.TblUser
+			if err := self.orm.In("id", uids).Cols("id", "name", "short_id").Find(&items); err != nil {
+				logrus.Errorf("[team.go::getTeams] orm.Find: %v", err)
+				self.resp(c, 502, &CR{
+					Message: "Failed",
+					Code:    CodeServerInternal,
+				})
+				return
+			}
+			for i := 0; i < len(items); i++ {
+				users[items[i].Id] = &items[i]
+			}
+		}
+		for i := 0; i < len(members); i++ {
+			m := &members[i]
+			user, exist := users[m.Uid]
+			if !exist || (invited[m.Tid] && m.Uid != id) {
+				continue
+			}
+			team := index[m.Tid]
+			team.Members = append(team.Members, models.TeamMember{
+				Uid:      m.Uid,
+				Username: user.Name,
+				Domain:   user.ShortId + "." + self.Domain,
+				Write:    m.Write,
+				Accepted: m.Accepted,
+				Atime:    m.Atime,
+			})
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  resp,
+	})
+}
+
+// PUT /api/setting/teams, creator is owner and a member with write
+func (self *WebServer) addTeam(c *gin.Context) {
+	var req Team
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logrus.Infof("[team.go::addTeam] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > MAX_TEAM_NAME {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("name should be 1 to %v characters", MAX_TEAM_NAME),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	id := c.GetInt64("id")
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	count, err := session.Where(`uid=?`, id).Count(&models.TblTeam{})
+	if err != nil {
+		logrus.Errorf("[team.go::addTeam] orm.Count: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if count >= MAX_USER_TEAMS {
+		self.resp(c, 400, &CR{
+			Message: "Too many teams",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	if err = session.Begin(); err != nil {
+		logrus.Errorf("[team.go::addTeam] session.Begin: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	team := models.TblTeam{Name: req.Name, Uid: id}
+	_, err = session.InsertOne(&team)
+	if self.IsDuplicate(err) {
+		self.resp(c, 400, &CR{
+			Message: "name exists",
+			Code:    CodeBadData,
+		})
+		return
+	} else if err == nil {
+		_, err = session.InsertOne(&models.TblTeamMember{Tid: team.Id, Uid: id, Write: true, Accepted: true})
+	}
+	if err == nil {
+		err = session.Commit()
+	}
+	if err != nil {
+		logrus.Errorf("[team.go::addTeam] orm.InsertOne: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resetTeamAccess(id)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  team.Id,
+	})
+}
+
+// POST /api/setting/teams, rename
+func (self *WebServer) setTeam(c *gin.Context) {
+	var req Team
+	if err := c.ShouldBindJSON(&req); err != nil || req.Id <= 0 {
+		logrus.Infof("[team.go::setTeam] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > MAX_TEAM_NAME {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("name should be 1 to %v characters", MAX_TEAM_NAME),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	team, ok := self.manageableTeam(c, req.Id)
+	if !ok {
+		return
+	}
+	team.Name = req.Name
+	_, err := self.orm.ID(team.Id).Cols("name").Update(team)
+	if self.IsDuplicate(err) {
+		self.resp(c, 400, &CR{
+			Message: "name exists",
+			Code:    CodeBadData,
+		})
+		return
+	} else if err != nil {
+		logrus.Errorf("[team.go::setTeam] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// DELETE /api/setting/teams, records of members are kept
+func (self *WebServer) delTeams(c *gin.Context) {
+	var req DeleteRecordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[team.go::delTeams] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	for _, tid := range req.Ids {
+		if _, ok := self.manageableTeam(c, tid); !ok {
+			return
+		}
+	}
+	uids := self.teamMemberUids(req.Ids...)
+	if err := self.deleteTeams(req.Ids...); err != nil {
+		logrus.Errorf("[team.go::delTeams] deleteTeams: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resetTeamAccess(uids...)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func (self *WebServer) deleteTeams(tids ...int64) error {
+	if _, err := self.orm.In("tid", tids).Delete(&models.TblTeamMember{}); err != nil {
+		return err
+	}
+	_, err := self.orm.In("id", tids).Delete(&models.TblTeam{})
+	return err
+}
+
+// PUT /api/setting/teams/:id/members, user is invited, the response is the same whether the user exists or not
+func (self *WebServer) addTeamMember(c *gin.Context) {
+	tid, ok := self.teamParam(c)
+	if !ok {
+		return
+	}
+	var req TeamMember
+	if err := c.ShouldBindJSON(&req); err != nil || (req.Username == "" && req.Email == "") {
+		self.resp(c, 400, &CR{
+			Message: "username or email required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	team, ok := self.manageableTeam(c, tid)
+	if !ok {
+		return
+	}
+	count, err := self.orm.Where(`tid=?`, team.Id).Count(&models.TblTeamMember{})
+	if err != nil {
+		logrus.Errorf("[team.go::addTeamMember] orm.Count: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if count >= MAX_TEAM_MEMBERS {
+		self.resp(c, 400, &CR{
+			Message: "Too many members",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	user := new(models.TblUser)
+	if req.Username != "" {
+		session = session.Where(`name=?`, req.Username)
+	} else {
+		session = session.Where(`email=?`, req.Email)
+	}
+	exist, err := session.Cols("id").Get(user)
+	if err == nil && exist {
+		_, err = self.orm.InsertOne(&models.TblTeamMember{Tid: team.Id, Uid: user.Id, Write: req.Write})
+		if self.IsDuplicate(err) {
+			//invited or a member already
+			err = nil
+		} else if err == nil {
+			reqLog(c).Infof("[team.go::addTeamMember] user %v invited to team %v, write: %v", user.Id, team.Name, req.Write)
+		}
+	}
+	if err != nil {
+		logrus.Errorf("[team.go::addTeamMember] orm: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// POST /api/setting/teams/:id/accept, invited user joins the team
+func (self *WebServer) acceptTeam(c *gin.Context) {
+	tid, ok := self.teamParam(c)
+	if !ok {
+		return
+	}
+	id := c.GetInt64("id")
+	affected, err := self.orm.Where(`tid=? AND uid=?`, tid, id).Cols("accepted").
+		Update(&models.TblTeamMember{Accepted: true})
+	if err != nil {
+		logrus.Errorf("[team.go::acceptTeam] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if affected == 0 {
+		exist, _ := self.orm.Where(`tid=? AND uid=?`, tid, id).Exist(&models.TblTeamMember{})
+		if !exist {
+			self.resp(c, 404, &CR{
+				Message: "invitation not found",
+				Code:    CodeNoData,
+			})
+			return
+		}
+	}
+	self.resetTeamAccess(self.teamMemberUids(tid)...)
+	reqLog(c).Infof("[team.go::acceptTeam] user %v joined team %v", id, tid)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// POST /api/setting/teams/:id/members, write permission of member
+func (self *WebServer) setTeamMember(c *gin.Context) {
+	tid, ok := self.teamParam(c)
+	if !ok {
+		return
+	}
+	var req TeamMember
+	if err := c.ShouldBindJSON(&req); err != nil || req.Uid <= 0 {
+		self.resp(c, 400, &CR{
+			Message: "uid required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	team, ok := self.manageableTeam(c, tid)
+	if !ok {
+		return
+	}
+	if req.Uid == team.Uid && !req.Write {
+		self.resp(c, 400, &CR{
+			Message: "owner keeps write permission",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	affected, err := self.orm.Where(`tid=? AND uid=?`, team.Id, req.Uid).Cols("write").
+		Update(&models.TblTeamMember{Write: req.Write})
+	if err != nil {
+		logrus.Errorf("[team.go::setTeamMember] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if affected == 0 {
+		exist, _ := self.orm.Where(`tid=? AND uid=?`, team.Id, req.Uid).Exist(&models.TblTeamMember{})
+		if !exist {
+			self.resp(c, 404, &CR{
+				Message: "member not found",
+				Code:    CodeNoData,
+			})
+			return
+		}
+	}
+	self.resetTeamAccess(self.teamMemberUids(team.Id)...)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// DELETE /api/setting/teams/:id/members, by owner or admin, or a member leaves
+func (self *WebServer) delTeamMembers(c *gin.Context) {
+	tid, ok := self.teamParam(c)
+	if !ok {
+		return
+	}
+	var req DeleteRecordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Ids) == 0 {
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	id := c.GetInt64("id")
+	var team *models.TblTeam
+	if len(req.Ids) == 1 && req.Ids[0] == id {
+		team = new(models.TblTeam)
+		exist, err := self.orm.ID(tid).Get(team)
+		if err != nil {
+			logrus.Errorf("[team.go::delTeamMembers] orm.Get: %v", err)
+			self.resp(c, 502, &CR{
+				Message: "Failed",
+				Code:    CodeServerInternal,
+			})
+			return
+		} else if !exist {
+			self.resp(c, 404, &CR{
+				Message: "team not found",
+				Code:    CodeNoData,
+			})
+			return
+		}
+	} else if team, ok = self.manageableTeam(c, tid); !ok {
+		return
+	}
+	for _, uid := range req.Ids {
+		if uid == team.Uid {
+			self.resp(c, 400, &CR{
+				Message: "owner can't leave, delete the team instead",
+				Code:    CodeBadData,
+			})
+			return
+		}
+	}
+
+	_, err := self.orm.Where(`tid=?`, team.Id).In("uid", req.Ids).Delete(&models.TblTeamMember{})
+	if err != nil {
+		logrus.Errorf("[team.go::delTeamMembers] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resetTeamAccess(append(self.teamMemberUids(team.Id), req.Ids...)...)
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
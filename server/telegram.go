@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Telegram notification, bot token is set by
+	godnslog serve -telegram-token ${BOT_TOKEN}
+users start a chat with the bot, then save chat id in security setting
+	POST /api/setting/security {"telegram_chat_id": "123456789"}
+each hit is sent as a message, at most TELEGRAM_CHAT_RATE messages per chat in TELEGRAM_RATE_INTERVAL,
+dropped hits are counted in the next message
+*/
+
+const (
+	DEFAULT_TELEGRAM_API   = "https://api.telegram.org"
+	TELEGRAM_CHAT_RATE     = 20 //bot api allows about 20 messages per minute to a group
+	TELEGRAM_RATE_INTERVAL = time.Minute
+	TELEGRAM_TIMEOUT       = 10 * time.Second
+	MAX_TELEGRAM_TEXT      = 4096
+	TELEGRAM_RETRY_KEY     = "telegram.retry" //set while bot api asks to retry after
+)
+
+// numeric id of user or group, or @username of channel
+var telegramChatIdRe = regexp.MustCompile(`^(-?[0-9]{1,20}|@[A-Za-z][A-Za-z0-9_]{4,31})$`)
+
+var telegramClient = &http.Client{Timeout: TELEGRAM_TIMEOUT}
+
+func validTelegramChatId(id string) bool {
+	return id == "" || telegramChatIdRe.MatchString(id)
+}
+
+type telegramResp struct {
+	Ok          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// sendTelegram send html message to chat, flood control of bot api pause all messages
+func (self *WebServer) sendTelegram(chatId, text string) error {
+	data, _ := json.Marshal(map[string]interface{}{
+		"chat_id":                  chatId,
+		"text":                     text,
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	})
+	n := self.notify()
+	url := fmt.Sprintf("%v/bot%v/sendMessage", strings.TrimSuffix(n.TelegramApi, "/"), n.TelegramToken)
+	resp, err := telegramClient.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		//hide bot token in url
+		return fmt.Errorf("sendMessage: %v", strings.ReplaceAll(err.Error(), n.TelegramToken, "***"))
+	}
+	defer resp.Body.Close()
+
+	var r telegramResp
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("sendMessage: status %v", resp.StatusCode)
+	}
+	if !r.Ok {
+		if r.ErrorCode == 429 && r.Parameters.RetryAfter > 0 {
+			self.store.Set(TELEGRAM_RETRY_KEY, true, time.Duration(r.Parameters.RetryAfter)*time.Second)
+		}
+		return fmt.Errorf("sendMessage: %v %v", r.ErrorCode, r.Description)
+	}
+	return nil
+}
+
+// telegramText format record event as html message
+func telegramText(event *models.SessionEvent, dropped int64) string {
+	token := strings.TrimPrefix(event.Var, "/")
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "<b>%v</b> hit", html.EscapeString(event.Type))
+	if token != "" {
+		fmt.Fprintf(&buf, " of <code>%v</code>", html.EscapeString(token))
+	}
+	fmt.Fprintf(&buf, " from <code>%v</code>\n", html.EscapeString(event.Ip))
+	if dropped > 0 {
+		fmt.Fprintf(&buf, "<i>%v hits dropped by rate limit</i>\n", dropped)
+	}
+
+	data, _ := json.MarshalIndent(event.Data, "", "  ")
+	//escaped record in <pre></pre> should fit MAX_TELEGRAM_TEXT
+	room := MAX_TELEGRAM_TEXT - utf8.RuneCountInString(buf.String()) - len("<pre></pre>")
+	text := html.EscapeString(string(data))
+	if utf8.RuneCountInString(text) > room {
+		room -= len("\n...")
+		var pre strings.Builder
+		for _, r := range string(data) {
+			e := html.EscapeString(string(r))
+			if room -= utf8.RuneCountInString(e); room < 0 {
+				break
+			}
+			pre.WriteString(e)
+		}
+		text = pre.String() + "\n..."
+	}
+	fmt.Fprintf(&buf, "<pre>%v</pre>", text)
+	return buf.String()
+}
+
+// telegramNotify send record event to telegram chat of user
+func (self *WebServer) telegramNotify(uid int64, event *models.SessionEvent) {
+	if uid == 0 || self.notify().TelegramToken == "" {
+		return
+	}
+	v, exist := self.store.Get(fmt.Sprintf("%v.user", uid))
+	if !exist {
+		return
+	}
+	chatId := v.(*models.TblUser).TelegramChatId
+	if chatId == "" {
+		return
+	}
+
+	store := self.store
+	droppedKey := fmt.Sprintf("%v.telegram.dropped", uid)
+	if _, exist := store.Get(TELEGRAM_RETRY_KEY); exist {
+		store.Add(droppedKey, int64(0), cache.NoExpiration)
+		store.IncrementInt64(droppedKey, 1)
+		return
+	}
+	key := fmt.Sprintf("%v.telegram", uid)
+	store.Add(key, int64(0), TELEGRAM_RATE_INTERVAL)
+	if n, err := store.IncrementInt64(key, 1); err != nil || n > TELEGRAM_CHAT_RATE {
+		store.Add(droppedKey, int64(0), cache.NoExpiration)
+		store.IncrementInt64(droppedKey, 1)
+		return
+	}
+	var dropped int64
+	if v, exist := store.Get(droppedKey); exist {
+		store.Delete(droppedKey)
+		dropped = v.(int64)
+	}
+
+	self.wg.Add(1)
+	go func() {
+		defer self.wg.Done()
+		if err := self.sendTelegram(chatId, telegramText(event, dropped)); err != nil {
+			logrus.Infof("[telegram.go::telegramNotify] user(id=%v): %v", uid, err)
+		}
+	}()
+}
+
+// setTelegramChatId save chat id of user, response is sent on failure
+func (self *WebServer) setTelegramChatId(c *gin.Context, chatId string) bool {
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var user models.TblUser
+	exist, err := session.ID(id).Get(&user)
+	if err != nil || !exist {
+		logrus.Errorf("[telegram.go::setTelegramChatId] orm.Get(%v): %v, %v", id, exist, err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return false
+	}
+	user.TelegramChatId = chatId
+	_, err = session.ID(id).Cols("telegram_chat_id").Update(&user)
+	if err != nil {
+		logrus.Errorf("[telegram.go::setTelegramChatId] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return false
+	}
+
+	//update cache
+	if v, exist := self.store.Get(fmt.Sprintf("%v.user", id)); exist {
+		user = *v.(*models.TblUser)
+		user.TelegramChatId = chatId
+	}
+	self.store.Set(fmt.Sprintf("%v.user", id), &user, cache.NoExpiration)
+	self.store.Set(fmt.Sprintf("%v.suser", user.ShortId), &user, cache.NoExpiration)
+	return true
+}
@@ -0,0 +1,508 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Scoped api tokens, a user may create several tokens for scanners and scripts
+	GET|PUT|POST|DELETE /api/setting/tokens
+tokens may expire at etime, rotation issues a new token and keeps the old one valid for grace seconds
+	POST /api/setting/token/rotate {"id": 1, "grace": 3600}, id 0 rotates token of user
+a token is accepted wherever the token of user is, data api hash, grpc and interactsh,
+and as Access-Token of the api routes its scopes allow, eg. DELETE /api/record/dns with scope delete.
+the token of user keeps reading all records, it never deletes nor changes settings
+*/
+
+const (
+	SCOPE_READ_DNS  = "read-dns"
+	SCOPE_READ_HTTP = "read-http"
+	SCOPE_DELETE    = "delete"
+	SCOPE_SETTINGS  = "settings"
+	SCOPE_RELAY     = "relay" //store records relayed by federation of another instance
+
+	MAX_USER_TOKENS      = 32
+	MAX_TOKEN_LABEL      = 64
+	TOKEN_TOUCH_INTERVAL = time.Minute //last used time is saved at most once per interval
+	DEFAULT_TOKEN_GRACE  = 24 * 3600   //seconds
+	MAX_TOKEN_GRACE      = 30 * 24 * 3600
+)
+
+var tokenScopes = map[string]bool{
+	SCOPE_READ_DNS:  true,
+	SCOPE_READ_HTTP: true,
+	SCOPE_DELETE:    true,
+	SCOPE_SETTINGS:  true,
+	SCOPE_RELAY:     true,
+}
+
+// readScopes is scopes of user token, required by records other than dns and http
+var readScopes = []string{SCOPE_READ_DNS, SCOPE_READ_HTTP}
+
+// routeScopes is scopes required by api routes for tokens, routes not listed are denied
+var routeScopes = func() map[string][]string {
+	m := map[string][]string{
+		"GET /api/record/dns":                                      {SCOPE_READ_DNS},
+		"GET /api/record/http":                                     {SCOPE_READ_HTTP},
+		"GET /api/record/http/:id/body":                            {SCOPE_READ_HTTP},
+		"DELETE /api/record/dns":                                   {SCOPE_DELETE},
+		"DELETE /api/record/http":                                  {SCOPE_DELETE},
+		"GET /api/setting/app":                                     {SCOPE_SETTINGS},
+		"POST /api/setting/app":                                    {SCOPE_SETTINGS},
+		"GET /api/setting/httprules":                               {SCOPE_SETTINGS},
+		"PUT /api/setting/httprules":                               {SCOPE_SETTINGS},
+		"POST /api/setting/httprules":                              {SCOPE_SETTINGS},
+		"DELETE /api/setting/httprules":                            {SCOPE_SETTINGS},
+		"POST /api/setting/callback/secret":                        {SCOPE_SETTINGS},
+		"GET /api/setting/webhooks":                                {SCOPE_SETTINGS},
+		"PUT /api/setting/webhooks":                                {SCOPE_SETTINGS},
+		"POST /api/setting/webhooks":                               {SCOPE_SETTINGS},
+		"DELETE /api/setting/webhooks":                             {SCOPE_SETTINGS},
+		"GET /api/setting/webhooks/:id/deliveries":                 {SCOPE_SETTINGS},
+		"POST /api/setting/webhooks/:id/deliveries/:did/redeliver": {SCOPE_SETTINGS},
+		"GET /api/setting/alertrules":                              {SCOPE_SETTINGS},
+		"PUT /api/setting/alertrules":                              {SCOPE_SETTINGS},
+		"POST /api/setting/alertrules":                             {SCOPE_SETTINGS},
+		"DELETE /api/setting/alertrules":                           {SCOPE_SETTINGS},
+		"GET /api/setting/ipfilters":                               {SCOPE_SETTINGS},
+		"PUT /api/setting/ipfilters":                               {SCOPE_SETTINGS},
+		"DELETE /api/setting/ipfilters":                            {SCOPE_SETTINGS},
+
+		"POST /data/relay":          {SCOPE_RELAY},
+		"GET /data/dns":             {SCOPE_READ_DNS},
+		"GET /data/http":            {SCOPE_READ_HTTP},
+		"GET /data/http/:id/body":   {SCOPE_READ_HTTP},
+		"GET /data/http/:id/frames": {SCOPE_READ_HTTP},
+	}
+	for _, typ := range []string{"smtp", "ldap", "ftp", "tcp", "icmp", "smb", "rmi"} {
+		m["GET /api/record/"+typ] = readScopes
+		m["DELETE /api/record/"+typ] = []string{SCOPE_DELETE}
+	}
+	return m
+}()
+
+// grpcScopes is scopes required by grpc methods, others require readScopes
+var grpcScopes = map[string][]string{
+	"/godnslog.GoDnsLog/QueryDns":  {SCOPE_READ_DNS},
+	"/godnslog.GoDnsLog/QueryHttp": {SCOPE_READ_HTTP},
+}
+
+// requiredScopes of route, any data api route is readable by readScopes
+func requiredScopes(c *gin.Context) ([]string, bool) {
+	path := c.FullPath()
+	if scopes, exist := routeScopes[c.Request.Method+" "+path]; exist {
+		return scopes, true
+	}
+	if strings.HasPrefix(path, "/data/") {
+		return readScopes, true
+	}
+	return nil, false
+}
+
+func hasScopes(scopes []string, required ...string) bool {
+	for _, scope := range required {
+		found := false
+		for _, s := range scopes {
+			if s == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func tokenExpired(item *models.TblToken) bool {
+	return !item.Etime.IsZero() && time.Now().After(item.Etime)
+}
+
+// touchToken save last used time of token
+func (self *WebServer) touchToken(item *models.TblToken) {
+	now := time.Now()
+	if now.Sub(item.Ltime) < TOKEN_TOUCH_INTERVAL {
+		return
+	}
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	_, err := session.ID(item.Id).Cols("ltime").Update(&models.TblToken{Ltime: now})
+	if err != nil {
+		logrus.Errorf("[token.go::touchToken] orm.Update: %v", err)
+	}
+}
+
+// hashTokenScopes get scopes of the token signed the hash, user token first, nil if none matches
+func (self *WebServer) hashTokenScopes(uid int64, userToken string, sum func(token string) string, hash string) ([]string, error) {
+	if sum(userToken) == hash {
+		return readScopes, nil
+	}
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblToken
+	if err := session.Where(`uid=?`, uid).Find(&items); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(items); i++ {
+		if sum(items[i].Token) == hash && !tokenExpired(&items[i]) {
+			self.touchToken(&items[i])
+			return items[i].Scopes, nil
+		}
+	}
+	return nil, nil
+}
+
+// tokenAuth authorize api token as Access-Token of route
+func (self *WebServer) tokenAuth(c *gin.Context, token string) {
+	user, scopes, err := self.tokenUser(token)
+	if err != nil {
+		logrus.Errorf("[token.go::tokenAuth] tokenUser: %v", err)
+		c.JSON(502, CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		c.Abort()
+		return
+	} else if user == nil {
+		c.JSON(401, CR{
+			Message: "Token invalid",
+			Code:    CodeNoAuth,
+		})
+		c.Abort()
+		return
+	}
+	if required, exist := requiredScopes(c); !exist || !hasScopes(scopes, required...) {
+		c.JSON(403, CR{
+			Message: "Scope required",
+			Code:    CodeNoPermission,
+		})
+		c.Abort()
+		return
+	}
+	c.Set("id", user.Id)
+	c.Set("username", user.Name)
+	c.Set("email", user.Email)
+	c.Set("role", user.Role)
+	c.Set("scopes", scopes)
+}
+
+// validToken check label and scopes of request
+func validToken(req *ApiToken) bool {
+	if utf8.RuneCountInString(req.Label) > MAX_TOKEN_LABEL || len(req.Scopes) == 0 {
+		return false
+	}
+	if !req.Etime.IsZero() && req.Etime.Before(time.Now()) {
+		return false
+	}
+	for _, scope := range req.Scopes {
+		if !tokenScopes[scope] {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *WebServer) getTokens(c *gin.Context) {
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblToken
+	err := session.Where(`uid=?`, id).Asc("id").Find(&items)
+	if err != nil {
+		logrus.Errorf("[token.go::getTokens] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	tokens := make([]ApiToken, len(items))
+	for i := 0; i < len(items); i++ {
+		tokens[i] = ApiToken{
+			Id:     items[i].Id,
+			Label:  items[i].Label,
+			Token:  items[i].Token,
+			Scopes: items[i].Scopes,
+			Ltime:  items[i].Ltime,
+			Etime:  items[i].Etime,
+			Atime:  items[i].Atime,
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  tokens,
+	})
+}
+
+func (self *WebServer) addToken(c *gin.Context) {
+	var req ApiToken
+	err := c.ShouldBindJSON(&req)
+	if err != nil || !validToken(&req) {
+		logrus.Infof("[token.go::addToken] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	count, err := session.Where(`uid=?`, id).Count(&models.TblToken{})
+	if err != nil {
+		logrus.Errorf("[token.go::addToken] orm.Count: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if count >= MAX_USER_TOKENS {
+		self.resp(c, 400, &CR{
+			Message: "Too many tokens",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	item := models.TblToken{
+		Uid:    id,
+		Label:  req.Label,
+		Token:  genRandomToken(),
+		Scopes: req.Scopes,
+		Etime:  req.Etime,
+	}
+	_, err = session.InsertOne(&item)
+	if err != nil {
+		logrus.Errorf("[token.go::addToken] orm.InsertOne: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result: ApiToken{
+			Id:     item.Id,
+			Label:  item.Label,
+			Token:  item.Token,
+			Scopes: item.Scopes,
+			Etime:  item.Etime,
+			Atime:  item.Atime,
+		},
+	})
+}
+
+// setToken change label, scopes and expire time of token
+func (self *WebServer) setToken(c *gin.Context) {
+	var req ApiToken
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.Id < 1 || !validToken(&req) {
+		logrus.Infof("[token.go::setToken] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	affected, err := session.ID(req.Id).And(`uid=?`, id).Cols("label", "scopes", "etime").
+		Update(&models.TblToken{Label: req.Label, Scopes: req.Scopes, Etime: req.Etime})
+	if err != nil {
+		logrus.Errorf("[token.go::setToken] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if affected == 0 {
+		self.resp(c, 404, &CR{
+			Message: "No such token",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func (self *WebServer) delTokens(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[token.go::delTokens] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	params := make([]interface{}, len(req.Ids))
+	for i := 0; i < len(req.Ids); i++ {
+		params[i] = req.Ids[i]
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	_, err = session.Where(`uid=?`, id).In("id", params...).Delete(&models.TblToken{})
+	if err != nil {
+		logrus.Errorf("[token.go::delTokens] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// rotateToken issue new token of user or scoped token, the old one is kept as a token expiring after grace
+func (self *WebServer) rotateToken(c *gin.Context) {
+	var req RotateTokenRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.Id < 0 || req.Grace < 0 || req.Grace > MAX_TOKEN_GRACE {
+		logrus.Infof("[token.go::rotateToken] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("grace should be in [0, %v]", MAX_TOKEN_GRACE),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.Grace == 0 {
+		req.Grace = DEFAULT_TOKEN_GRACE
+	}
+	etime := time.Now().Add(time.Duration(req.Grace) * time.Second)
+	auditDetail(c, "token=%v grace=%v", req.Id, req.Grace)
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	err = session.Begin()
+	if err != nil {
+		logrus.Errorf("[token.go::rotateToken] orm.Begin: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	defer session.Rollback()
+
+	var user models.TblUser
+	var item models.TblToken
+	var exist bool
+	if req.Id == 0 {
+		exist, err = session.ID(id).Get(&user)
+		item = models.TblToken{Uid: id, Label: "token of user", Token: user.Token, Scopes: readScopes}
+	} else {
+		exist, err = session.ID(req.Id).And(`uid=?`, id).Get(&item)
+	}
+	if err != nil {
+		logrus.Errorf("[token.go::rotateToken] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if !exist || tokenExpired(&item) {
+		self.resp(c, 404, &CR{
+			Message: "No such token",
+			Code:    CodeNoData,
+		})
+		return
+	}
+
+	//old token expires after grace, new token keeps id, label, scopes and expire time
+	old := models.TblToken{
+		Uid:    id,
+		Label:  item.Label + " (rotated)",
+		Token:  item.Token,
+		Scopes: item.Scopes,
+		Ltime:  item.Ltime,
+		Etime:  etime,
+	}
+	if !item.Etime.IsZero() && item.Etime.Before(etime) {
+		old.Etime = item.Etime
+	}
+	item.Token = genRandomToken()
+	if req.Id == 0 {
+		_, err = session.ID(id).Cols("token").Update(&models.TblUser{Token: item.Token})
+	} else {
+		_, err = session.ID(item.Id).Cols("token").Update(&item)
+	}
+	if err == nil {
+		_, err = session.InsertOne(&old)
+	}
+	if err == nil {
+		err = session.Commit()
+	}
+	if err != nil {
+		logrus.Errorf("[token.go::rotateToken] rotate %v: %v", req.Id, err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	//update cache
+	if req.Id == 0 {
+		if v, exist := self.store.Get(fmt.Sprintf("%v.user", id)); exist {
+			user = *v.(*models.TblUser)
+		}
+		user.Token = item.Token
+		self.store.Set(fmt.Sprintf("%v.user", id), &user, cache.NoExpiration)
+		self.store.Set(fmt.Sprintf("%v.suser", user.ShortId), &user, cache.NoExpiration)
+	}
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result: ApiToken{
+			Id:     item.Id,
+			Label:  item.Label,
+			Token:  item.Token,
+			Scopes: item.Scopes,
+			Ltime:  item.Ltime,
+			Etime:  item.Etime,
+			Atime:  item.Atime,
+		},
+	})
+}
+
+// cleanTokens remove expired tokens
+func (self *WebServer) cleanTokens() {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	now := self.sqlTime(time.Now())
+	_, err := session.Where(`etime is not null`).And(`etime<?`, now).And(`etime>?`, time.Unix(0, 0)).
+		Delete(&models.TblToken{})
+	if err != nil {
+		logrus.Errorf("[token.go::cleanTokens] orm.Delete: %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Two-factor login by TOTP (RFC 6238, sha1, 6 digits, 30s), enrolled by each user
+	POST   /api/setting/security/totp                               => {"secret":"BASE32","uri":"otpauth://totp/..."}
+	PUT    /api/setting/security/totp {"code":"123456"}             => {"recovery_codes":["xxxxx-xxxxx",...]}
+	POST   /api/setting/security/totp/recovery {"code":"123456"}    => {"recovery_codes":[...]}
+	DELETE /api/setting/security/totp {"password":"xxx","code":"123456"}
+uri of enrollment is content of the QR code scanned by authenticator apps, the secret is enabled when a code
+of it is confirmed in TOTP_ENROLL_EXPIRE. then login requires "code" of the app or an unused recovery code,
+CodeTotpRequired is returned if it is missing. codes are locked out for TOTP_LOCK after TOTP_MAX_FAILURES
+*/
+
+const (
+	TOTP_PERIOD         = 30
+	TOTP_DIGITS         = 6
+	TOTP_SKEW           = 1 //accepted steps before and after now
+	TOTP_SECRET_SIZE    = 20
+	TOTP_ENROLL_EXPIRE  = 10 * time.Minute
+	TOTP_RECOVERY_CODES = 10
+	TOTP_MAX_FAILURES   = 5
+	TOTP_LOCK           = 15 * time.Minute
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// hotp of counter, RFC 4226
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	v := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, v%1000000)
+}
+
+// verifyTotp return time step of code, steps not after last are refused as replayed
+func verifyTotp(secret, code string, now time.Time, last int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != TOTP_DIGITS {
+		return 0, false
+	}
+	step := now.Unix() / TOTP_PERIOD
+	for i := int64(-TOTP_SKEW); i <= TOTP_SKEW; i++ {
+		s := step + i
+		if s <= last {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(s))), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+func genTotpSecret() string {
+	key := make([]byte, TOTP_SECRET_SIZE)
+	rand.Read(key)
+	return totpEncoding.EncodeToString(key)
+}
+
+// totpUri is otpauth uri of key uri format of google authenticator
+func (self *WebServer) totpUri(user *models.TblUser, secret string) string {
+	issuer := strings.TrimSuffix(self.Domain, ".")
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(TOTP_DIGITS))
+	v.Set("period", fmt.Sprint(TOTP_PERIOD))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+user.Name) + "?" + v.Encode()
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// genRecoveryCodes return codes shown to user and hashes saved
+func genRecoveryCodes() ([]string, []string) {
+	var codes, hashes []string
+	for i := 0; i < TOTP_RECOVERY_CODES; i++ {
+		b := make([]byte, 5)
+		rand.Read(b)
+		s := hex.EncodeToString(b)
+		code := s[:5] + "-" + s[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+// verifySecondFactor check totp or recovery code of user, a recovery code is removed once used
+func (self *WebServer) verifySecondFactor(user *models.TblUser, code string) (bool, error) {
+	failKey := fmt.Sprintf("%v.totpfail", user.Id)
+	if v, exist := self.store.Get(failKey); exist && v.(int64) >= TOTP_MAX_FAILURES {
+		return false, nil
+	}
+	fail := func() {
+		if _, err := self.store.IncrementInt64(failKey, 1); err != nil {
+			self.store.Set(failKey, int64(1), TOTP_LOCK)
+		}
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == TOTP_DIGITS {
+		stepKey := fmt.Sprintf("%v.totpstep", user.Id)
+		var last int64
+		if v, exist := self.store.Get(stepKey); exist {
+			last = v.(int64)
+		}
+		step, ok := verifyTotp(user.TotpSecret, code, time.Now(), last)
+		if !ok {
+			fail()
+			return false, nil
+		}
+		self.store.Set(stepKey, step, 2*(TOTP_SKEW+1)*TOTP_PERIOD*time.Second)
+		self.store.Delete(failKey)
+		return true, nil
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, h := range user.TotpRecovery {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) != 1 {
+			continue
+		}
+		recovery := append(append([]string{}, user.TotpRecovery[:i]...), user.TotpRecovery[i+1:]...)
+		_, err := self.orm.ID(user.Id).Cols("totp_recovery").Update(&models.TblUser{TotpRecovery: recovery})
+		if err != nil {
+			return false, err
+		}
+		user.TotpRecovery = recovery
+		self.store.Delete(failKey)
+		logrus.Infof("[totp.go::verifySecondFactor] recovery code of %v used, %v left", user.Name, len(recovery))
+		return true, nil
+	}
+	fail()
+	return false, nil
+}
+
+// currentUser load current user from database, cache may be stale
+func (self *WebServer) currentUser(c *gin.Context) (*models.TblUser, bool) {
+	id := c.GetInt64("id")
+	user := new(models.TblUser)
+	exist, err := self.orm.ID(id).Get(user)
+	if err != nil || !exist {
+		logrus.Errorf("[totp.go::currentUser] orm.Get(%v): %v, %v", id, exist, err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return nil, false
+	}
+	return user, true
+}
+
+// saveTotp update two-factor settings of user and cached user
+func (self *WebServer) saveTotp(c *gin.Context, user *models.TblUser) bool {
+	_, err := self.orm.ID(user.Id).Cols("totp_secret", "totp_recovery").Update(user)
+	if err != nil {
+		logrus.Errorf("[totp.go::saveTotp] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return false
+	}
+	self.store.Set(fmt.Sprintf("%v.user", user.Id), user, cache.NoExpiration)
+	self.store.Set(fmt.Sprintf("%v.suser", user.ShortId), user, cache.NoExpiration)
+	return true
+}
+
+// POST /api/setting/security/totp
+func (self *WebServer) enrollTotp(c *gin.Context) {
+	user, ok := self.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TotpSecret != "" {
+		self.resp(c, 400, &CR{
+			Message: "two-factor login enabled already",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	secret := genTotpSecret()
+	self.store.Set(fmt.Sprintf("%v.totp", user.Id), secret, TOTP_ENROLL_EXPIRE)
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result: models.TotpEnroll{
+			Secret: secret,
+			Uri:    self.totpUri(user, secret),
+		},
+	})
+}
+
+// PUT /api/setting/security/totp
+func (self *WebServer) enableTotp(c *gin.Context) {
+	var req models.TotpRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		self.resp(c, 400, &CR{
+			Message: "bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	user, ok := self.currentUser(c)
+	if !ok {
+		return
+	}
+	enrollKey := fmt.Sprintf("%v.totp", user.Id)
+	v, exist := self.store.Get(enrollKey)
+	if !exist {
+		self.resp(c, 400, &CR{
+			Message: "no enrollment, or it is expired",
+			Code:    CodeExpire,
+		})
+		return
+	}
+	secret := v.(string)
+	step, ok := verifyTotp(secret, strings.TrimSpace(req.Code), time.Now(), 0)
+	if !ok {
+		self.resp(c, 400, &CR{
+			Message: "code not match",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	codes, hashes := genRecoveryCodes()
+	user.TotpSecret = secret
+	user.TotpRecovery = hashes
+	if !self.saveTotp(c, user) {
+		return
+	}
+	self.store.Delete(enrollKey)
+	self.store.Set(fmt.Sprintf("%v.totpstep", user.Id), step, 2*(TOTP_SKEW+1)*TOTP_PERIOD*time.Second)
+	reqLog(c).Infof("[totp.go::enableTotp] two-factor login of %v enabled", user.Name)
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  models.TotpRecovery{RecoveryCodes: codes},
+	})
+}
+
+// POST /api/setting/security/totp/recovery
+func (self *WebServer) resetTotpRecovery(c *gin.Context) {
+	var req models.TotpRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		self.resp(c, 400, &CR{
+			Message: "bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	user, ok := self.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TotpSecret == "" {
+		self.resp(c, 400, &CR{
+			Message: "two-factor login not enabled",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if !self.checkSecondFactor(c, user, req.Code) {
+		return
+	}
+
+	codes, hashes := genRecoveryCodes()
+	user.TotpRecovery = hashes
+	if !self.saveTotp(c, user) {
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  models.TotpRecovery{RecoveryCodes: codes},
+	})
+}
+
+// DELETE /api/setting/security/totp
+func (self *WebServer) disableTotp(c *gin.Context) {
+	var req models.TotpRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" || req.Password == "" {
+		self.resp(c, 400, &CR{
+			Message: "bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	user, ok := self.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.TotpSecret == "" {
+		self.resp(c, 200, &CR{
+			Message: "OK",
+		})
+		return
+	}
+	if comparePassword(req.Password, user.Pass) != nil {
+		self.resp(c, 400, &CR{
+			Message: "password not match",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if !self.checkSecondFactor(c, user, req.Code) {
+		return
+	}
+
+	user.TotpSecret = ""
+	user.TotpRecovery = nil
+	if !self.saveTotp(c, user) {
+		return
+	}
+	reqLog(c).Infof("[totp.go::disableTotp] two-factor login of %v disabled", user.Name)
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// checkSecondFactor verify code of settings request, response is written if failed
+func (self *WebServer) checkSecondFactor(c *gin.Context, user *models.TblUser, code string) bool {
+	ok, err := self.verifySecondFactor(user, code)
+	if err != nil {
+		logrus.Errorf("[totp.go::checkSecondFactor] verifySecondFactor: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return false
+	} else if !ok {
+		self.resp(c, 400, &CR{
+			Message: "code not match",
+			Code:    CodeBadData,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyTotp(t *testing.T) {
+	//sha1 vectors of RFC 6238 appendix B, seed "12345678901234567890", last 6 digits
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	var tests = []struct {
+		Time       int64
+		Code       string
+		Last       int64
+		ExpectStep int64
+		ExpectOk   bool
+	}{
+		{59, "287082", 0, 1, true},
+		{1111111109, "081804", 0, 37037036, true},
+		{1111111111, "050471", 0, 37037037, true},
+		{1234567890, "005924", 0, 41152263, true},
+		{2000000000, "279037", 0, 66666666, true},
+		{20000000000, "353130", 0, 666666666, true},
+		{59 + TOTP_PERIOD, "287082", 0, 1, true},    //previous step is accepted
+		{59 - TOTP_PERIOD, "287082", 0, 1, true},    //next step is accepted
+		{59 + 2*TOTP_PERIOD, "287082", 0, 0, false}, //out of skew
+		{59, "287082", 1, 0, false},                 //replayed
+		{59, "287083", 0, 0, false},
+		{59, "28708", 0, 0, false},
+		{59, "94287082", 0, 0, false},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		test := &tests[i]
+		step, ok := verifyTotp(secret, test.Code, time.Unix(test.Time, 0), test.Last)
+		if step != test.ExpectStep || ok != test.ExpectOk {
+			t.Fatalf("test %v verifyTotp(%v, %v)=(%v, %v)!=expect(%v, %v)",
+				i, test.Time, test.Code, step, ok, test.ExpectStep, test.ExpectOk)
+		}
+	}
+
+	if _, ok := verifyTotp(strings.ToLower(secret), "287082", time.Unix(59, 0), 0); !ok {
+		t.Fatalf("test lower case secret should be accepted")
+	}
+	if _, ok := verifyTotp("not base32!", "287082", time.Unix(59, 0), 0); ok {
+		t.Fatalf("test invalid secret should be refused")
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-contrib/static"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Web ui is served from embedded assets, or a directory of dist for development
+	godnslog serve -ui-dir frontend/dist ...
+unknown paths are index.html for routes of ui
+*/
+
+const UI_DEFAULT_DIR = "dist"
+
+// assetsFileSystem is static file system of embedded assets
+type assetsFileSystem struct {
+	http.FileSystem
+	assets fs.FS
+}
+
+func newAssetsFileSystem(assets fs.FS) *assetsFileSystem {
+	return &assetsFileSystem{FileSystem: http.FS(assets), assets: assets}
+}
+
+// Exists is like one of static.LocalFile, directory without index.html is not served
+func (a *assetsFileSystem) Exists(prefix string, filepath string) bool {
+	p := strings.TrimPrefix(filepath, prefix)
+	if len(p) == len(filepath) {
+		return false
+	}
+	name := strings.Trim(path.Clean("/"+p), "/")
+	if name == "" {
+		name = "."
+	}
+	st, err := fs.Stat(a.assets, name)
+	if err != nil {
+		return false
+	}
+	if st.IsDir() {
+		_, err = fs.Stat(a.assets, path.Join(name, static.INDEX))
+		return err == nil
+	}
+	return true
+}
+
+// serveUi serve static files of ui, and index.html of routes of ui
+func (self *WebServer) serveUi(r *gin.Engine) {
+	if self.UiAssets == nil {
+		dir := self.UiDir
+		if dir == "" {
+			dir = UI_DEFAULT_DIR
+		}
+		r.Use(static.Serve("/", static.LocalFile(dir, false)))
+		r.NoRoute(func(c *gin.Context) {
+			c.File(path.Join(dir, static.INDEX))
+		})
+		return
+	}
+
+	r.Use(static.Serve("/", newAssetsFileSystem(self.UiAssets)))
+	r.NoRoute(func(c *gin.Context) {
+		index, err := fs.ReadFile(self.UiAssets, static.INDEX)
+		if err != nil {
+			c.String(404, "404 page not found")
+			return
+		}
+		c.Data(200, "text/html; charset=utf-8", index)
+	})
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/chennqqi/godnslog/models"
+	"xorm.io/xorm"
+)
+
+/*
+Manage users on the database without web ui, eg. a locked-out admin or automation
+	godnslog user add -name alice -email alice@example.com -role admin
+	godnslog user resetpass -name admin
+	godnslog user disable -name alice
+users are by name or email. logins and api tokens are checked on the database, sessions and
+api of user domain of a running server are denied after it is reloaded
+*/
+
+type UserManager struct {
+	orm *xorm.Engine
+}
+
+// NewUserManager open database, pending migrations are applied
+func NewUserManager(driver, dsn string) (*UserManager, error) {
+	orm, err := xorm.NewEngine(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err = orm.Ping(); err == nil {
+		err = newMigrate(orm).Migrate()
+	}
+	if err != nil {
+		orm.Close()
+		return nil, err
+	}
+	return &UserManager{orm: orm}, nil
+}
+
+// Add user of Name, Email, Role, Lang and CleanInterval, token and shortId are generated
+func (m *UserManager) Add(user *models.TblUser, password string) error {
+	if isWeakPass(password) {
+		return fmt.Errorf("password too weak")
+	}
+	if user.Role == roleSuper {
+		return fmt.Errorf("super admin can not be added")
+	}
+	user.Token = genRandomToken()
+	user.ShortId = genShortId()
+	user.CallbackSecret = genRandomString(CALLBACK_SECRET_LEN)
+	cfg, err := loadPasswordHash(m.orm)
+	if err != nil {
+		return err
+	}
+	user.Pass = makePassword(password, cfg)
+	_, err = m.orm.InsertOne(user)
+	return err
+}
+
+// Get user by name or email
+func (m *UserManager) Get(name string) (*models.TblUser, error) {
+	var user models.TblUser
+	exist, err := m.orm.Where(`name=? OR email=?`, name, name).Get(&user)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, fmt.Errorf("user %v not found", name)
+	}
+	return &user, nil
+}
+
+func (m *UserManager) List() ([]models.TblUser, error) {
+	var users []models.TblUser
+	err := m.orm.Asc("id").Find(&users)
+	return users, err
+}
+
+func (m *UserManager) ResetPassword(name, password string) error {
+	if isWeakPass(password) {
+		return fmt.Errorf("password too weak")
+	}
+	user, err := m.Get(name)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadPasswordHash(m.orm)
+	if err != nil {
+		return err
+	}
+	_, err = m.orm.ID(user.Id).Cols("pass").SetExpr(`token_version`, `token_version+1`).
+		Update(&models.TblUser{Pass: makePassword(password, cfg)})
+	return err
+}
+
+// SetDisabled disable or enable user, super admin can not be disabled
+func (m *UserManager) SetDisabled(name string, disabled bool) error {
+	user, err := m.Get(name)
+	if err != nil {
+		return err
+	}
+	if user.Role == roleSuper && disabled {
+		return fmt.Errorf("super admin can not be disabled")
+	}
+	_, err = m.orm.ID(user.Id).Cols("disabled").Update(&models.TblUser{Disabled: disabled})
+	return err
+}
+
+// ResetTotp disable two-factor login of user and remove passkeys, eg. authenticator is lost
+func (m *UserManager) ResetTotp(name string) error {
+	user, err := m.Get(name)
+	if err != nil {
+		return err
+	}
+	_, err = m.orm.ID(user.Id).Cols("totp_secret", "totp_recovery").Update(&models.TblUser{})
+	if err != nil {
+		return err
+	}
+	_, err = m.orm.Where(`uid=?`, user.Id).Delete(&models.TblWebauthn{})
+	return err
+}
+
+func (m *UserManager) Close() error {
+	return m.orm.Close()
+}
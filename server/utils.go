@@ -1,14 +1,85 @@
 package server
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	//loopback, private, link-local and other networks not reachable from internet, eg. cloud metadata 169.254.169.254
+	privateNetworks = mustParseCidrs("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+		"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/3",
+		"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8")
 
-	"golang.org/x/crypto/bcrypt"
+	errPrivateAddress = errors.New("private address denied")
+	errDiscardLimit   = errors.New("discard limit exceeded")
 )
 
+// MAX_DISCARD_SIZE bound bytes drained after the limit of readLimited
+const MAX_DISCARD_SIZE = 16 << 20
+
+func mustParseCidrs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = ipnet
+	}
+	return nets
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, ipnet := range privateNetworks {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// denyPrivateAddress is Control of net.Dialer, called with resolved address of every connection, redirects too
+func denyPrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// targetTransport is transport of urls of users, connections to private networks are denied unless allowed.
+// proxy of environment is used only if private targets are allowed, it would connect to any address
+func targetTransport(allowPrivate bool) *http.Transport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	t := &http.Transport{
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if allowPrivate {
+		t.Proxy = http.ProxyFromEnvironment
+	} else {
+		dialer.Control = denyPrivateAddress
+	}
+	return t
+}
+
 func getSecuritySeed() string {
 	var x uint64
 	binary.Read(rand.Reader, binary.BigEndian, &x)
@@ -33,17 +104,41 @@ func genRandomString(n int) string {
 	return string(b)
 }
 
-func makePassword(pass string) string {
-	newpass, _ := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
-	return string(newpass)
+func isWeakPass(pass string) bool {
+	return len(pass) < 6
 }
 
-func comparePassword(pass, hashpass string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashpass), []byte(pass))
+// readLimited read at most max bytes from r, the remains are discarded but counted up to MAX_DISCARD_SIZE,
+// errDiscardLimit is returned with the data if r is not drained, the stream should be closed
+func readLimited(r io.Reader, max int64) (data []byte, size int64, truncated bool, err error) {
+	var buf bytes.Buffer
+	size, err = io.Copy(&buf, io.LimitReader(r, max))
+	if err != nil {
+		return
+	}
+	remain, err := io.CopyN(ioutil.Discard, r, MAX_DISCARD_SIZE+1)
+	if err == io.EOF {
+		err = nil
+	} else if err == nil {
+		remain--
+		err = errDiscardLimit
+	}
+	size += remain
+	return buf.Bytes(), size, remain > 0, err
 }
 
-func isWeakPass(pass string) bool {
-	return len(pass) < 6
+// textPreview is leading max bytes of utf-8 text b, a rune cut by max is dropped, empty if b is binary
+func textPreview(b []byte, max int) string {
+	if len(b) > max {
+		b = b[:max]
+		for i := 0; i < utf8.UTFMax-1 && !utf8.Valid(b); i++ {
+			b = b[:len(b)-1]
+		}
+	}
+	if !utf8.Valid(b) {
+		return ""
+	}
+	return string(b)
 }
 
 func customQuote(s string) string {
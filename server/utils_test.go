@@ -1,6 +1,8 @@
 package server
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -52,3 +54,68 @@ func TestParseDomain(t *testing.T) {
 		}
 	}
 }
+
+func TestReadLimited(t *testing.T) {
+	var tests = []struct {
+		Input           string
+		Max             int64
+		ExpectData      string
+		ExpectSize      int64
+		ExpectTruncated bool
+	}{
+		{"", 16, "", 0, false},
+		{"hello", 16, "hello", 5, false},
+		{"hello", 5, "hello", 5, false},
+		{"hello world", 5, "hello", 11, true},
+		{"\x1f\x8b\x08\x00", 3, "\x1f\x8b\x08", 4, true},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		test := &tests[i]
+		data, size, truncated, err := readLimited(strings.NewReader(test.Input), test.Max)
+		if err != nil {
+			t.Fatalf("readLimited(%q): %v", test.Input, err)
+		}
+		if !bytes.Equal(data, []byte(test.ExpectData)) {
+			t.Fatalf("test data(%q)!=expect(%q)", data, test.ExpectData)
+		}
+		if size != test.ExpectSize {
+			t.Fatalf("test size(%v)!=expect(%v)", size, test.ExpectSize)
+		}
+		if truncated != test.ExpectTruncated {
+			t.Fatalf("test truncated(%v)!=expect(%v)", truncated, test.ExpectTruncated)
+		}
+	}
+
+	input := strings.Repeat("a", MAX_DISCARD_SIZE+8)
+	data, size, truncated, err := readLimited(strings.NewReader(input), 4)
+	if err != errDiscardLimit {
+		t.Fatalf("test discard limit err(%v)!=expect(%v)", err, errDiscardLimit)
+	}
+	if len(data) != 4 || size != MAX_DISCARD_SIZE+4 || !truncated {
+		t.Fatalf("test discard limit data(%v) size(%v) truncated(%v)", len(data), size, truncated)
+	}
+}
+
+func TestTextPreview(t *testing.T) {
+	var tests = []struct {
+		Input  string
+		Max    int
+		Expect string
+	}{
+		{"", 4, ""},
+		{"hello", 8, "hello"},
+		{"hello", 4, "hell"},
+		{"a中b", 3, "a"},
+		{"a中b", 4, "a中"},
+		{"\xff\xfe", 8, ""},
+		{"ab\xff", 2, "ab"},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		test := &tests[i]
+		if r := textPreview([]byte(test.Input), test.Max); r != test.Expect {
+			t.Fatalf("test textPreview(%q, %v)=%q!=expect(%q)", test.Input, test.Max, r, test.Expect)
+		}
+	}
+}
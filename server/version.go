@@ -0,0 +1,78 @@
+package server
+
+import (
+	"runtime"
+	"sort"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+)
+
+/*
+Version and build of instance, set by ldflags when building
+	go build -ldflags "-X github.com/chennqqi/godnslog/server.Version=1.2.0 \
+		-X github.com/chennqqi/godnslog/server.Commit=$(git rev-parse --short HEAD) \
+		-X github.com/chennqqi/godnslog/server.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+	GET /api/version
+	{"result":{"version":"1.2.0","commit":"52a31bc","buildDate":"...","schema":"0004","features":["https","metrics"]}}
+features are optional ones enabled by configuration, clients may detect differences of instances by them
+*/
+
+var (
+	Version   = "0.0.0-dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// BuildInfo is version of binary without features of instance
+func BuildInfo() models.VersionInfo {
+	return models.VersionInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Schema:    migrations[len(migrations)-1].ID,
+		Features:  []string{},
+	}
+}
+
+// features is optional features enabled, sorted
+func (self *WebServer) features() []string {
+	enabled := map[string]bool{
+		"https":         self.HttpsListen != "",
+		"grpc":          self.GrpcListen != "",
+		"swagger":       self.Swagger,
+		"clickhouse":    self.ClickHouse != nil,
+		"elastic":       self.Elastic != nil,
+		"archive":       self.Archive != nil,
+		"tcp_ports":     self.TcpPorts != nil,
+		"durable_queue": self.DurableQueue,
+		"geoip":         self.GeoIP != nil,
+		"rdns":          self.Rdns,
+		"ingest":        self.IngestKey != "",
+		"metrics":       self.MetricsToken != "",
+		"tracing":       self.Tracer != nil,
+		"embedded_ui":   self.UiAssets != nil,
+		"rate_limit":    self.RateLimit > 0,
+		"record_quota":  self.DefaultRecordQuota > 0,
+		"telegram":      self.notify().TelegramToken != "",
+	}
+	features := []string{}
+	for name, on := range enabled {
+		if on {
+			features = append(features, name)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// GET /api/version
+func (self *WebServer) getVersion(c *gin.Context) {
+	info := BuildInfo()
+	info.Features = self.features()
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  info,
+	})
+}
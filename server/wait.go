@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Long poll for hit of token, instead of sleep and poll, eg.
+	curl http://${shortId}.godnslog.com/data/wait?q=${token}&timeout=30s&t=${t}&hash=${hash}
+stored record is returned at once, otherwise first new dns or http record of token,
+result is null when timeout. add type=smtp,ldap for other protocols
+*/
+
+const (
+	DEFAULT_WAIT_TIMEOUT = 30 * time.Second
+	MAX_WAIT_TIMEOUT     = 300 * time.Second
+)
+
+// parseWaitTimeout accept duration(30s) or seconds(30)
+func parseWaitTimeout(s string) (time.Duration, bool) {
+	if s == "" {
+		return DEFAULT_WAIT_TIMEOUT, true
+	}
+	timeout, err := time.ParseDuration(s)
+	if err != nil {
+		secs, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, false
+		}
+		timeout = time.Duration(secs) * time.Second
+	}
+	if timeout <= 0 {
+		return 0, false
+	}
+	if timeout > MAX_WAIT_TIMEOUT {
+		timeout = MAX_WAIT_TIMEOUT
+	}
+	return timeout, true
+}
+
+// curl http://${shortId}.godnslog.com/data/wait?q=${token}&timeout=30s
+func (self *WebServer) queryWait(c *gin.Context) {
+	timeout, ok := parseWaitTimeout(c.Query("timeout"))
+	sub := newRealtimeSub(c, []int64{c.GetInt64("uid")})
+	if len(sub.types) == 0 {
+		sub.types["dns"] = true
+		sub.types["http"] = true
+	}
+	for typ := range sub.types {
+		if _, exist := exporters[typ]; !exist {
+			ok = false
+		}
+	}
+	if !ok || sub.token == "" {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	event, err := self.waitHit(c.Request.Context(), c.GetInt64("uid"), sub, timeout)
+	if err == context.Canceled {
+		return
+	} else if err != nil {
+		logrus.Errorf("[wait.go::queryWait] waitHit: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if event == nil {
+		self.resp(c, 200, &CR{
+			Message: "Timeout",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  event,
+	})
+}
+
+// waitHit return stored or first new record of sub, nil if timeout
+func (self *WebServer) waitHit(ctx context.Context, uid int64, sub *realtimeSub, timeout time.Duration) (*models.SessionEvent, error) {
+	//subscribe before lookup, hit between them is not lost
+	ch := self.realtime.subscribe(sub)
+	defer self.realtime.unsubscribe(ch)
+
+	session := self.orm.NewSession()
+	defer session.Close()
+	for typ := range sub.types {
+		bean := exporters[typ].bean()
+		//variable of http is /token
+		exist, err := session.Where(`uid=?`, uid).In(`var`, sub.token, "/"+sub.token).
+			Desc("id").Get(bean)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", typ, err)
+		}
+		if exist {
+			event := recordEvent(bean)
+			return &event, nil
+		}
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case event := <-ch:
+		return &event, nil
+	case <-timer.C:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
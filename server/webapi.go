@@ -3,10 +3,17 @@ package server
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/hex"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -33,7 +40,7 @@ func (self *WebServer) dataPreHandler(c *gin.Context) {
 		host, _, _ = net.SplitHostPort(host)
 	}
 
-	_, shortId, _ := parseDomain(host, self.Domain)
+	_, shortId, _ := parseUserDomain(self.store, host, self.Domain)
 
 	c.Set("host", host)
 	c.Set("shortId", shortId)
@@ -55,11 +62,20 @@ func (self *WebServer) dataPreHandler(c *gin.Context) {
 		return
 	}
 	user := v.(*models.TblUser)
+	if user.Disabled {
+		self.resp(c, 401, &CR{
+			Message: "User disabled",
+			Code:    CodeNoAuth,
+		})
+		c.Abort()
+		return
+	}
 	c.Set("uid", user.Id)
 	c.Set("token", user.Token)
 }
 
 func (self *WebServer) dataAuthHandler(c *gin.Context) {
+	defer self.rateLimitUser(c, "uid")
 	//authorization 1: t=$timestamp
 	token := c.GetString("token")
 
@@ -100,17 +116,29 @@ func (self *WebServer) dataAuthHandler(c *gin.Context) {
 			keys = append(keys, k)
 		}
 	}
-
-	h := md5.New()
 	sort.Strings(keys)
-	for _, key := range keys {
-		value := querys.Get(key)
-		h.Write([]byte(value))
+
+	sum := func(token string) string {
+		h := md5.New()
+		for _, key := range keys {
+			value := querys.Get(key)
+			h.Write([]byte(value))
+		}
+		h.Write([]byte(token))
+		return hex.EncodeToString(h.Sum(nil))
 	}
-	h.Write([]byte(token))
-	expectHash := hex.EncodeToString(h.Sum(nil))
 
-	if hash != expectHash {
+	//token of user or any scoped token of user
+	scopes, err := self.hashTokenScopes(c.GetInt64("uid"), token, sum, hash)
+	if err != nil {
+		logrus.Errorf("[webapi.go::dataAuthHandler] hashTokenScopes: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		c.Abort()
+		return
+	} else if scopes == nil {
 		self.resp(c, 401, &CR{
 			Message: "Auth failed",
 			Code:    CodeNoAuth,
@@ -118,6 +146,15 @@ func (self *WebServer) dataAuthHandler(c *gin.Context) {
 		c.Abort()
 		return
 	}
+	if required, _ := requiredScopes(c); !hasScopes(scopes, required...) {
+		self.resp(c, 403, &CR{
+			Message: "Scope required",
+			Code:    CodeNoPermission,
+		})
+		c.Abort()
+		return
+	}
+	c.Set("scopes", scopes)
 }
 
 // dig ${q}.${shortId}.godnslog.com
@@ -143,23 +180,81 @@ func (self *WebServer) queryDnsRecord(c *gin.Context) {
 	} else {
 		session = session.And(`var like ?`, "%"+variable+"%")
 	}
-
-	var rcds []models.TblDns
-	err := session.Limit(self.DefaultQueryApiMaxItem).Find(&rcds)
+	if tag, exist := c.GetQuery("tag"); exist {
+		session = tagCond(session, tag)
+	}
+	filter, err := self.parseFilter(c)
 	if err != nil {
-		self.resp(c, 502, &CR{
-			Message: "domain parameter required",
-			Code:    CodeServerInternal,
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
 		})
 		return
 	}
+	session = filter.apply(session, "domain")
+	if filter.qtype != "" {
+		session = session.And(`qtype = ?`, filter.qtype)
+	}
+	session = self.scannerCond(c, session, id)
+	if isCountQuery(c) {
+		self.countRecord(c, session, filter, "dns")
+		return
+	}
+	cur, err := parseCursor(c, self.DefaultQueryApiMaxItem)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	} else if cur == nil {
+		cur = &cursor{limit: self.DefaultQueryApiMaxItem}
+	}
+	cond := session.Conds()
+
+	//scan by id, records filtered in go are skipped
+	var rcds []models.TblDns
+	max := cur.limit
+	batchCur := *cur
+	for n := 0; n < MAX_FILTER_SCAN && len(rcds) < max; n++ {
+		var batch []models.TblDns
+		err = self.findHits(batchCur.after(session.Where(cond)), &batch, false, batchCur.limit, 0)
+		if err != nil {
+			logrus.Errorf("[webapi.go::queryDnsRecord] findHits: %v", err)
+			self.resp(c, 502, &CR{
+				Message: "Failed",
+				Code:    CodeServerInternal,
+			})
+			return
+		}
+		for i := 0; i < len(batch) && len(rcds) < max; i++ {
+			if filter.match(batch[i].Ip, batch[i].Domain) {
+				rcds = append(rcds, batch[i])
+			}
+		}
+		if !filter.post || len(batch) < max {
+			break
+		}
+		batchCur.afterId = batch[len(batch)-1].Id
+	}
+	if len(rcds) > 0 {
+		c.Header("X-Next-Id", strconv.FormatInt(cur.next(len(rcds), rcds[len(rcds)-1].Id), 10))
+	}
 
 	items := make([]models.DnsRecord, len(rcds))
 	for i := 0; i < len(rcds); i++ {
 		item := &items[i]
 		rcd := &rcds[i]
+		item.Id = rcd.Id
 		item.Domain = rcd.Domain
 		item.Ip = rcd.Ip
+		item.Qtype = rcd.Qtype
+		item.Hits = rcd.Hits
+		item.Ptr = rcd.Ptr
+		item.Scanner = rcd.Scanner
+		item.Geo = rcd.Geo
+		item.Tags = rcd.Tags
+		item.Note = rcd.Note
 		item.Ctime = rcd.Ctime
 	}
 
@@ -192,28 +287,92 @@ func (self *WebServer) queryHttpRecord(c *gin.Context) {
 	} else {
 		session = session.And(`var like ?`, "%"+q+"%")
 	}
-
-	var rcds []models.TblHttp
-	err := session.Limit(self.DefaultQueryApiMaxItem).Find(&rcds)
-
+	if tag, exist := c.GetQuery("tag"); exist {
+		session = tagCond(session, tag)
+	}
+	filter, err := self.parseFilter(c)
 	if err != nil {
-		self.resp(c, 502, &CR{
-			Message: "domain parameter required",
-			Code:    CodeServerInternal,
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
 		})
 		return
 	}
+	session = filter.apply(session, "host")
+	if filter.method != "" {
+		session = session.And(`method = ?`, filter.method)
+	}
+	session = self.scannerCond(c, session, id)
+	if isCountQuery(c) {
+		self.countRecord(c, session, filter, "http")
+		return
+	}
+	cur, err := parseCursor(c, self.DefaultQueryApiMaxItem)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	} else if cur == nil {
+		cur = &cursor{limit: self.DefaultQueryApiMaxItem}
+	}
+	cond := session.Conds()
+
+	//scan by id, records filtered in go are skipped
+	var rcds []models.TblHttp
+	max := cur.limit
+	batchCur := *cur
+	for n := 0; n < MAX_FILTER_SCAN && len(rcds) < max; n++ {
+		var batch []models.TblHttp
+		err = self.findHits(batchCur.after(session.Where(cond)), &batch, false, batchCur.limit, 0)
+		if err != nil {
+			logrus.Errorf("[webapi.go::queryHttpRecord] findHits: %v", err)
+			self.resp(c, 502, &CR{
+				Message: "Failed",
+				Code:    CodeServerInternal,
+			})
+			return
+		}
+		for i := 0; i < len(batch) && len(rcds) < max; i++ {
+			if filter.match(batch[i].Ip, batch[i].Host) {
+				rcds = append(rcds, batch[i])
+			}
+		}
+		if !filter.post || len(batch) < max {
+			break
+		}
+		batchCur.afterId = batch[len(batch)-1].Id
+	}
+	if len(rcds) > 0 {
+		c.Header("X-Next-Id", strconv.FormatInt(cur.next(len(rcds), rcds[len(rcds)-1].Id), 10))
+	}
 	items := make([]HttpRecord, len(rcds))
 	for i := 0; i < len(rcds); i++ {
 		item := &items[i]
 		rcd := &rcds[i]
 
 		item.Path = rcd.Path
+		item.Host = rcd.Host
 		item.Ctype = rcd.Ctype
 		item.Ip = rcd.Ip
 		item.Method = rcd.Method
+		item.Proto = rcd.Proto
+		item.Id = rcd.Id
 		item.Ua = rcd.Ua
 		item.Data = rcd.Data
+		item.Size = rcd.Size
+		item.Truncated = rcd.Truncated
+		item.Sni = rcd.Sni
+		item.TlsVersion = rcd.TlsVersion
+		item.TlsCipher = rcd.TlsCipher
+		item.Ja3 = rcd.Ja3
+		item.Ja3Hash = rcd.Ja3Hash
+		item.Ptr = rcd.Ptr
+		item.Scanner = rcd.Scanner
+		item.Geo = rcd.Geo
+		item.Tags = rcd.Tags
+		item.Note = rcd.Note
 		item.Ctime = rcd.Ctime
 	}
 
@@ -223,47 +382,598 @@ func (self *WebServer) queryHttpRecord(c *gin.Context) {
 	})
 }
 
-func (self *WebServer) record(c *gin.Context) {
-	orm := self.orm
-	session := orm.NewSession()
+// curl http://${shortId}.godnslog.com/data/smtp?q=${q}
+func (self *WebServer) querySmtpRecord(c *gin.Context) {
+	session := self.orm.NewSession()
 	defer session.Close()
 
-	var data bytes.Buffer
-	io.Copy(&data, c.Request.Body)
-	c.Request.Body.Close()
+	id := c.GetInt64("uid")
+	q, exist := c.GetQuery("q")
+	if !exist {
+		self.resp(c, 400, &CR{
+			Message: "q parameter required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	session = session.Where(`uid=?`, id)
 
-	path := c.Request.URL.EscapedPath()
+	blur, _ := ginutils.GetQueryInt(c, "blur")
+	if blur == 0 {
+		session = session.And(`var = ?`, q)
+	} else {
+		session = session.And(`var like ?`, "%"+q+"%")
+	}
 
-	var uid int64
-	shortId := c.Param("shortId")
+	var rcds []models.TblSmtp
+	err := session.Limit(self.DefaultQueryApiMaxItem).Find(&rcds)
+	if err != nil {
+		logrus.Errorf("[webapi.go::querySmtpRecord] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	items := make([]models.SmtpRecord, len(rcds))
+	for i := 0; i < len(rcds); i++ {
+		items[i] = smtpRecord(&rcds[i])
+	}
 
-	store := self.store
-	v, exist := store.Get(shortId + ".suser")
-	if exist {
-		user := v.(*models.TblUser)
-		uid = user.Id
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  items,
+	})
+}
+
+// curl http://${shortId}.godnslog.com/data/ldap?q=${q}
+func (self *WebServer) queryLdapRecord(c *gin.Context) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	id := c.GetInt64("uid")
+	q, exist := c.GetQuery("q")
+	if !exist {
+		self.resp(c, 400, &CR{
+			Message: "q parameter required",
+			Code:    CodeBadData,
+		})
+		return
 	}
+	session = session.Where(`uid=?`, id)
 
-	_, err := session.InsertOne(&models.TblHttp{
-		Uid:    uid,
-		Ip:     c.ClientIP(),
-		Path:   path,
-		Ua:     c.GetHeader("User-Agent"),
-		Ctype:  c.GetHeader("Content-Type"),
-		Var:    c.Param("any"),
-		Method: c.Request.Method,
-		Ctime:  time.Now(),
-		Data:   data.String(),
+	blur, _ := ginutils.GetQueryInt(c, "blur")
+	if blur == 0 {
+		session = session.And(`var = ?`, q)
+	} else {
+		session = session.And(`var like ?`, "%"+q+"%")
+	}
+
+	var rcds []models.TblLdap
+	err := session.Limit(self.DefaultQueryApiMaxItem).Find(&rcds)
+	if err != nil {
+		logrus.Errorf("[webapi.go::queryLdapRecord] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	items := make([]models.LdapRecord, len(rcds))
+	for i := 0; i < len(rcds); i++ {
+		items[i] = ldapRecord(&rcds[i])
+	}
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  items,
 	})
+}
+
+// curl http://${shortId}.godnslog.com/data/ftp?q=${q}
+func (self *WebServer) queryFtpRecord(c *gin.Context) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	id := c.GetInt64("uid")
+	q, exist := c.GetQuery("q")
+	if !exist {
+		self.resp(c, 400, &CR{
+			Message: "q parameter required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	session = session.Where(`uid=?`, id)
+
+	blur, _ := ginutils.GetQueryInt(c, "blur")
+	if blur == 0 {
+		session = session.And(`var = ?`, q)
+	} else {
+		session = session.And(`var like ?`, "%"+q+"%")
+	}
+
+	var rcds []models.TblFtp
+	err := session.Limit(self.DefaultQueryApiMaxItem).Find(&rcds)
+	if err != nil {
+		logrus.Errorf("[webapi.go::queryFtpRecord] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	items := make([]models.FtpRecord, len(rcds))
+	for i := 0; i < len(rcds); i++ {
+		items[i] = ftpRecord(&rcds[i])
+	}
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  items,
+	})
+}
+
+// curl http://${shortId}.godnslog.com/data/tcp?q=${q}
+func (self *WebServer) queryTcpRecord(c *gin.Context) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	id := c.GetInt64("uid")
+	q, exist := c.GetQuery("q")
+	if !exist {
+		self.resp(c, 400, &CR{
+			Message: "q parameter required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	session = session.Where(`uid=?`, id)
+
+	blur, _ := ginutils.GetQueryInt(c, "blur")
+	if blur == 0 {
+		session = session.And(`var = ?`, q)
+	} else {
+		session = session.And(`var like ?`, "%"+q+"%")
+	}
+
+	var rcds []models.TblTcp
+	err := session.Limit(self.DefaultQueryApiMaxItem).Find(&rcds)
 	if err != nil {
-		logrus.Errorf("[webapi.go::Record] orm.InsertOne: %v", err)
+		logrus.Errorf("[webapi.go::queryTcpRecord] orm.Find: %v", err)
 		self.resp(c, 502, &CR{
 			Message: "Failed",
 			Code:    CodeServerInternal,
 		})
 		return
 	}
+	items := make([]models.TcpRecord, len(rcds))
+	for i := 0; i < len(rcds); i++ {
+		items[i] = tcpRecord(&rcds[i])
+	}
+
 	self.resp(c, 200, &CR{
 		Message: "OK",
+		Result:  items,
 	})
 }
+
+// curl http://${shortId}.godnslog.com/data/icmp?q=${q}
+func (self *WebServer) queryIcmpRecord(c *gin.Context) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	id := c.GetInt64("uid")
+	q, exist := c.GetQuery("q")
+	if !exist {
+		self.resp(c, 400, &CR{
+			Message: "q parameter required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	session = session.Where(`uid=?`, id)
+
+	blur, _ := ginutils.GetQueryInt(c, "blur")
+	if blur == 0 {
+		session = session.And(`var = ?`, q)
+	} else {
+		session = session.And(`var like ?`, "%"+q+"%")
+	}
+
+	var rcds []models.TblIcmp
+	err := session.Limit(self.DefaultQueryApiMaxItem).Find(&rcds)
+	if err != nil {
+		logrus.Errorf("[webapi.go::queryIcmpRecord] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	items := make([]models.IcmpRecord, len(rcds))
+	for i := 0; i < len(rcds); i++ {
+		items[i] = icmpRecord(&rcds[i])
+	}
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  items,
+	})
+}
+
+// curl http://${shortId}.godnslog.com/data/smb?q=${q}
+func (self *WebServer) querySmbRecord(c *gin.Context) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	id := c.GetInt64("uid")
+	q, exist := c.GetQuery("q")
+	if !exist {
+		self.resp(c, 400, &CR{
+			Message: "q parameter required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	session = session.Where(`uid=?`, id)
+
+	blur, _ := ginutils.GetQueryInt(c, "blur")
+	if blur == 0 {
+		session = session.And(`var = ?`, q)
+	} else {
+		session = session.And(`var like ?`, "%"+q+"%")
+	}
+
+	var rcds []models.TblSmb
+	err := session.Limit(self.DefaultQueryApiMaxItem).Find(&rcds)
+	if err != nil {
+		logrus.Errorf("[webapi.go::querySmbRecord] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	items := make([]models.SmbRecord, len(rcds))
+	for i := 0; i < len(rcds); i++ {
+		items[i] = smbRecord(&rcds[i])
+	}
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  items,
+	})
+}
+
+// curl http://${shortId}.godnslog.com/data/rmi?q=${q}
+func (self *WebServer) queryRmiRecord(c *gin.Context) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	id := c.GetInt64("uid")
+	q, exist := c.GetQuery("q")
+	if !exist {
+		self.resp(c, 400, &CR{
+			Message: "q parameter required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	session = session.Where(`uid=?`, id)
+
+	blur, _ := ginutils.GetQueryInt(c, "blur")
+	if blur == 0 {
+		session = session.And(`var = ?`, q)
+	} else {
+		session = session.And(`var like ?`, "%"+q+"%")
+	}
+
+	var rcds []models.TblRmi
+	err := session.Limit(self.DefaultQueryApiMaxItem).Find(&rcds)
+	if err != nil {
+		logrus.Errorf("[webapi.go::queryRmiRecord] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	items := make([]models.RmiRecord, len(rcds))
+	for i := 0; i < len(rcds); i++ {
+		items[i] = rmiRecord(&rcds[i])
+	}
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  items,
+	})
+}
+
+// curl http://${shortId}.godnslog.com/data/http/${id}/body
+func (self *WebServer) queryHttpRecordBody(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "id parameter required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var rcd models.TblHttp
+	exist, err := self.getHit(session.Where(`uid=?`, c.GetInt64("uid")).And(`id=?`, id), &rcd)
+	if err != nil {
+		logrus.Errorf("[webapi.go::queryHttpRecordBody] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if !exist {
+		self.resp(c, 404, &CR{
+			Message: "No such record",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	self.sendHttpBody(c, &rcd)
+}
+
+func (self *WebServer) sendHttpBody(c *gin.Context, rcd *models.TblHttp) {
+	body, err := base64.StdEncoding.DecodeString(rcd.Body)
+	if err != nil {
+		logrus.Errorf("[webapi.go::sendHttpBody] base64.Decode(id=%v): %v", rcd.Id, err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="http-%v.bin"`, rcd.Id))
+	c.Header("X-Body-Size", strconv.FormatInt(rcd.Size, 10))
+	c.Header("X-Body-Truncated", strconv.FormatBool(rcd.Truncated))
+	c.Data(200, "application/octet-stream", body)
+}
+
+func (self *WebServer) maxBodySize(user *models.TblUser) int64 {
+	if user != nil && user.MaxBodySize > 0 {
+		return user.MaxBodySize
+	}
+	return self.DefaultMaxBodySize
+}
+
+func (self *WebServer) record(c *gin.Context) {
+	var user *models.TblUser
+	shortId := c.Param("shortId")
+	variable := c.Param("any")
+
+	if shortId == "ws" {
+		user, variable = self.wsUser(c)
+	} else {
+		store := self.store
+		v, exist := store.Get(shortId + ".suser")
+		if exist {
+			user = v.(*models.TblUser)
+		}
+	}
+
+	rcd, err := self.logHttp(c, user, variable)
+	if err == ErrRecordQuota {
+		self.resp(c, 507, &CR{
+			Message: err.Error(),
+			Code:    CodeQuota,
+		})
+		return
+	} else if err != nil {
+		reqLog(c).Errorf("[webapi.go::Record] logHttp: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	if shortId == "ws" && isWebSocket(c.Request) {
+		self.serveWebSocket(c, user, rcd)
+		return
+	}
+
+	if self.respHttpRule(c, rcd.Uid, rcd.Var) {
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// requestHost is host of request without port, proxied host first
+func requestHost(c *gin.Context) string {
+	host := c.GetHeader("X-Forwarded-Host")
+	if host == "" {
+		host = c.Request.Host
+	}
+	if strings.Contains(host, ":") {
+		host, _, _ = net.SplitHostPort(host)
+	}
+	return host
+}
+
+// hostUser get user by shortId of request host, nil if not found
+func (self *WebServer) hostUser(c *gin.Context) *models.TblUser {
+	_, shortId, _ := parseUserDomain(self.store, requestHost(c), self.Domain)
+
+	v, exist := self.store.Get(shortId + ".suser")
+	if !exist {
+		return nil
+	}
+	return v.(*models.TblUser)
+}
+
+// tokenUser get user and scopes by token of user or scoped api token, nil if not found or user is disabled
+func (self *WebServer) tokenUser(token string) (*models.TblUser, []string, error) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var user models.TblUser
+	exist, err := session.Where(`token=?`, token).Get(&user)
+	if err != nil || (exist && user.Disabled) {
+		return nil, nil, err
+	} else if exist {
+		return &user, readScopes, nil
+	}
+
+	var item models.TblToken
+	exist, err = session.Where(`token=?`, token).Get(&item)
+	if err != nil || !exist || tokenExpired(&item) {
+		return nil, nil, err
+	}
+	exist, err = session.ID(item.Uid).Get(&user)
+	if err != nil || !exist || user.Disabled {
+		return nil, nil, err
+	}
+	self.touchToken(&item)
+	return &user, item.Scopes, nil
+}
+
+// logHttp save request as http record of user
+func (self *WebServer) logHttp(c *gin.Context, user *models.TblUser, variable string) (*models.TblHttp, error) {
+	var uid int64
+	if user != nil {
+		uid = user.Id
+	}
+	rcd, body := newHttpRecord(c, self.TrustedProxies.ClientIP(c.Request), uid, variable, self.maxBodySize(user))
+	scheme := "http"
+	if state := c.Request.TLS; state != nil {
+		scheme = "https"
+		rcd.Sni = state.ServerName
+		rcd.TlsVersion = tlsVersionName(state.Version)
+		rcd.TlsCipher = tls.CipherSuiteName(state.CipherSuite)
+		rcd.Ja3 = self.lookupJA3(c.Request.RemoteAddr)
+		rcd.Ja3Hash = ja3Hash(rcd.Ja3)
+	}
+	metricHttpHits.inc(scheme)
+	if self.DurableQueue && !isWebSocket(c.Request) {
+		//saved by store routine, id of record is unknown
+		if self.overQuota(uid) {
+			return rcd, ErrRecordQuota
+		}
+		self.store.Push(rcd)
+		return rcd, nil
+	}
+	return rcd, self.addHttp(rcd, body)
+}
+
+// newHttpRecord build http record of request from client ip, body is read up to max bytes
+func newHttpRecord(c *gin.Context, ip string, uid int64, variable string, max int64) (*models.TblHttp, []byte) {
+	body, size, truncated, err := readLimited(c.Request.Body, max)
+	c.Request.Body.Close()
+	if err == errDiscardLimit {
+		//body is not drained, connection can not be reused
+		c.Header("Connection", "close")
+	} else if err != nil {
+		logrus.Infof("[webapi.go::newHttpRecord] read body: %v", err)
+	}
+
+	//binary body only can be downloaded, text body is previewed
+	data := textPreview(body, MAX_DATA_PREVIEW)
+
+	return &models.TblHttp{
+		Uid:       uid,
+		Ip:        ip,
+		Host:      c.Request.Host,
+		Path:      c.Request.URL.EscapedPath(),
+		Ua:        c.GetHeader("User-Agent"),
+		Ctype:     c.GetHeader("Content-Type"),
+		Var:       variable,
+		Method:    c.Request.Method,
+		Proto:     c.Request.Proto,
+		Ctime:     time.Now(),
+		Data:      data,
+		Body:      base64.StdEncoding.EncodeToString(body),
+		Size:      size,
+		Truncated: truncated,
+	}, body
+}
+
+// addHttp save http record, parts of multipart body are saved as files
+func (self *WebServer) addHttp(rcd *models.TblHttp, body []byte) error {
+	return self.saveHttp(rcd, body, false)
+}
+
+// saveHttp is addHttp, record relayed by federation is not relayed again
+func (self *WebServer) saveHttp(rcd *models.TblHttp, body []byte, relayed bool) error {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	if !self.ipRecordable(rcd.Uid, rcd.Ip) {
+		//filtered source is answered, not recorded
+		return nil
+	} else if self.overQuota(rcd.Uid) {
+		return ErrRecordQuota
+	}
+	rcd.Geo = self.GeoIP.Lookup(rcd.Ip)
+	rcd.Scanner = self.classifyScanner(rcd.Ip, rcd.Ua)
+	err := timeInsert("http", func() error {
+		return self.insertHit(session, rcd)
+	})
+	if err != nil {
+		return err
+	}
+	self.notifyRecord(rcd, relayed)
+	self.rdnsEnrich(rcd, rcd.Id, rcd.Ip)
+
+	//files of unowned hits are not kept, they would fill the blob store for nobody
+	mediaType, params, _ := mime.ParseMediaType(rcd.Ctype)
+	if rcd.Uid != 0 && mediaType == "multipart/form-data" && params["boundary"] != "" {
+		self.saveHttpFiles(rcd, body, params["boundary"])
+	}
+	return nil
+}
+
+// saveHttpFiles persist each part of multipart body, a truncated body keep the complete parts
+func (self *WebServer) saveHttpFiles(rcd *models.TblHttp, body []byte, boundary string) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for n := 0; ; n++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			logrus.Infof("[webapi.go::saveHttpFiles] multipart.NextPart(id=%v): %v", rcd.Id, err)
+			return
+		}
+		content, err := ioutil.ReadAll(part)
+		part.Close()
+		if err != nil {
+			logrus.Infof("[webapi.go::saveHttpFiles] read part(id=%v, n=%v): %v", rcd.Id, n, err)
+			return
+		}
+
+		key := httpFileKey(rcd.Uid, rcd.Id, n)
+		err = self.blob.Put(key, content)
+		if err != nil {
+			logrus.Errorf("[webapi.go::saveHttpFiles] blob.Put(%v): %v", key, err)
+			return
+		}
+		_, err = session.InsertOne(&models.TblHttpFile{
+			Uid:      rcd.Uid,
+			Rid:      rcd.Id,
+			N:        n,
+			Field:    part.FormName(),
+			Filename: part.FileName(),
+			Ctype:    part.Header.Get("Content-Type"),
+			Size:     int64(len(content)),
+			Blob:     key,
+		})
+		if err != nil {
+			logrus.Errorf("[webapi.go::saveHttpFiles] orm.InsertOne: %v", err)
+			return
+		}
+	}
+}
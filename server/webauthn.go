@@ -0,0 +1,707 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+WebAuthn security keys and passkeys (W3C Web Authentication level 2), alone or as second factor of login
+	POST   /api/setting/webauthn/challenge                          => options of navigator.credentials.create()
+	PUT    /api/setting/webauthn {"name":"yubikey","credential":{...}}
+	GET    /api/setting/webauthn                                    => [{"id":1,"name":"yubikey",...}]
+	POST   /api/setting/webauthn {"id":1,"name":"phone"}
+	DELETE /api/setting/webauthn {"ids":[1]}
+	POST   /api/auth/webauthn/challenge {"username":"admin"}        => options of navigator.credentials.get()
+	POST   /api/auth/webauthn/login {"credential":{...}}            => same as /api/auth/login
+credentials are PublicKeyCredential in json, binary fields are base64url. username of challenge is optional,
+discoverable credentials are selected by the authenticator. login by a passkey alone requires user verification.
+password login of users with passkeys or totp gets CodeTotpRequired and options of a challenge, then it is
+retried with "code" or "webauthn" credential. rp id is host of -ui-url, or host of request if it is not set.
+attestation is "none", ES256, EdDSA and RS256 keys are supported.
+*/
+
+const (
+	WEBAUTHN_TIMEOUT        = 5 * time.Minute
+	WEBAUTHN_CHALLENGE_SIZE = 32
+	MAX_USER_PASSKEYS       = 16
+
+	COSE_ES256 = -7
+	COSE_EDDSA = -8
+	COSE_RS256 = -257
+
+	WEBAUTHN_FLAG_UP = 0x01 //user present
+	WEBAUTHN_FLAG_UV = 0x04 //user verified
+	WEBAUTHN_FLAG_AT = 0x40 //attested credential data included
+
+	webauthnRegister = "register"
+	webauthnLogin    = "login"
+	webauthnMfa      = "mfa"
+)
+
+var (
+	webauthnEncoding = base64.RawURLEncoding
+
+	errWebauthn = errors.New("invalid webauthn credential")
+)
+
+// webauthnSession is state of an issued challenge, used once
+type webauthnSession struct {
+	Uid     int64 //0 if any user of login
+	RpId    string
+	Origin  string //expected origin if rp is -ui-url, otherwise origin of rp id is accepted
+	Purpose string
+}
+
+type webauthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// webauthnAuthData is authenticator data, credential id and public key of registration only
+type webauthnAuthData struct {
+	rpIdHash  []byte
+	flags     byte
+	signCount uint32
+	credId    []byte
+	publicKey []byte //COSE_Key
+}
+
+func decodeBase64url(s string) ([]byte, error) {
+	return webauthnEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// webauthnUserId is user handle of uid
+func webauthnUserId(uid int64) string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(uid))
+	return webauthnEncoding.EncodeToString(b[:])
+}
+
+// rpIdHash(32) flags(1) signCount(4) [aaguid(16) credIdLen(2) credId publicKey] [extensions]
+func parseAuthData(b []byte) (*webauthnAuthData, error) {
+	if len(b) < 37 {
+		return nil, errWebauthn
+	}
+	ad := &webauthnAuthData{
+		rpIdHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:]),
+	}
+	if ad.flags&WEBAUTHN_FLAG_AT == 0 {
+		return ad, nil
+	}
+	b = b[37:]
+	if len(b) < 18 {
+		return nil, errWebauthn
+	}
+	n := int(binary.BigEndian.Uint16(b[16:]))
+	b = b[18:]
+	if n == 0 || len(b) < n {
+		return nil, errWebauthn
+	}
+	ad.credId = b[:n]
+	_, rest, err := decodeCbor(b[n:])
+	if err != nil {
+		return nil, err
+	}
+	ad.publicKey = b[n : len(b)-len(rest)]
+	return ad, nil
+}
+
+// parseCoseKey public key and algorithm of COSE_Key, RFC 8152
+func parseCoseKey(data []byte) (crypto.PublicKey, int64, error) {
+	v, _, err := decodeCbor(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, errWebauthn
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	switch {
+	case kty == 2 && alg == COSE_ES256:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		y, _ := m[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, errWebauthn
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, errWebauthn
+		}
+		return pub, alg, nil
+	case kty == 1 && alg == COSE_EDDSA:
+		crv, _ := m[int64(-1)].(int64)
+		x, _ := m[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, errWebauthn
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case kty == 3 && alg == COSE_RS256:
+		n, _ := m[int64(-1)].([]byte)
+		e, _ := m[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errWebauthn
+		}
+		var exp int
+		for _, c := range e {
+			exp = exp<<8 | int(c)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported cose key: kty %v alg %v", kty, alg)
+}
+
+// verifyCoseSignature verify signature of data by COSE_Key
+func verifyCoseSignature(key, data, sig []byte) error {
+	pub, _, err := parseCoseKey(key)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(data)
+	ok := false
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(pub, digest[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(pub, data, sig)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil
+	}
+	if !ok {
+		return errors.New("signature not match")
+	}
+	return nil
+}
+
+// webauthnRp is rp id and expected origin, of -ui-url or host of request
+func (self *WebServer) webauthnRp(c *gin.Context) (string, string) {
+	if uiUrl := self.notify().UiUrl; uiUrl != "" {
+		if u, err := url.Parse(uiUrl); err == nil && u.Hostname() != "" {
+			return u.Hostname(), u.Scheme + "://" + u.Host
+		}
+	}
+	host, _, err := net.SplitHostPort(c.Request.Host)
+	if err != nil {
+		host = c.Request.Host
+	}
+	return strings.ToLower(host), ""
+}
+
+// newWebauthnChallenge issue a challenge of purpose for uid
+func (self *WebServer) newWebauthnChallenge(c *gin.Context, uid int64, purpose string) (string, string) {
+	var b [WEBAUTHN_CHALLENGE_SIZE]byte
+	rand.Read(b[:])
+	challenge := webauthnEncoding.EncodeToString(b[:])
+	rpId, origin := self.webauthnRp(c)
+	self.store.Set(challenge+".webauthn", &webauthnSession{
+		Uid:     uid,
+		RpId:    rpId,
+		Origin:  origin,
+		Purpose: purpose,
+	}, WEBAUTHN_TIMEOUT)
+	return challenge, rpId
+}
+
+// verifyClientData check type, challenge and origin of clientDataJSON, session of the challenge is consumed
+func (self *WebServer) verifyClientData(raw []byte, typ, purpose string) (*webauthnSession, error) {
+	var cd webauthnClientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return nil, err
+	}
+	if cd.Type != typ {
+		return nil, fmt.Errorf("unexpected client data type: %v", cd.Type)
+	}
+	key := cd.Challenge + ".webauthn"
+	v, exist := self.store.Get(key)
+	if !exist {
+		return nil, errors.New("challenge not found or expired")
+	}
+	self.store.Delete(key)
+	sess := v.(*webauthnSession)
+	if sess.Purpose != purpose {
+		return nil, fmt.Errorf("challenge of %v used for %v", sess.Purpose, purpose)
+	}
+
+	if sess.Origin != "" {
+		if !strings.EqualFold(cd.Origin, sess.Origin) {
+			return nil, fmt.Errorf("origin not match: %v", cd.Origin)
+		}
+		return sess, nil
+	}
+	u, err := url.Parse(cd.Origin)
+	if err != nil || !strings.EqualFold(u.Hostname(), sess.RpId) {
+		return nil, fmt.Errorf("origin not match: %v", cd.Origin)
+	}
+	//browsers allow http origin of localhost only
+	if u.Scheme != "https" && !(u.Scheme == "http" && sess.RpId == "localhost") {
+		return nil, fmt.Errorf("origin not secure: %v", cd.Origin)
+	}
+	return sess, nil
+}
+
+// verifyAssertion verify credential of navigator.credentials.get(), for uid if it is not 0
+func (self *WebServer) verifyAssertion(cred *models.WebauthnCredential, uid int64, purpose string) (*models.TblWebauthn, error) {
+	if cred == nil || cred.Type != "public-key" {
+		return nil, errWebauthn
+	}
+	clientData, err := decodeBase64url(cred.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	authData, err := decodeBase64url(cred.Response.AuthenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := decodeBase64url(cred.Response.Signature)
+	if err != nil {
+		return nil, err
+	}
+	credId, err := decodeBase64url(cred.RawId)
+	if err != nil || len(credId) == 0 {
+		return nil, errWebauthn
+	}
+
+	sess, err := self.verifyClientData(clientData, "webauthn.get", purpose)
+	if err != nil {
+		return nil, err
+	}
+	if uid == 0 {
+		uid = sess.Uid
+	} else if sess.Uid != uid {
+		return nil, errors.New("challenge of other user")
+	}
+
+	item := new(models.TblWebauthn)
+	exist, err := self.orm.Where(`cred_id=?`, webauthnEncoding.EncodeToString(credId)).Get(item)
+	if err != nil {
+		return nil, err
+	} else if !exist {
+		return nil, errors.New("unknown credential")
+	} else if uid != 0 && item.Uid != uid {
+		return nil, errors.New("credential of other user")
+	}
+	if cred.Response.UserHandle != "" && strings.TrimRight(cred.Response.UserHandle, "=") != webauthnUserId(item.Uid) {
+		return nil, errors.New("user handle not match")
+	}
+
+	ad, err := parseAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+	rpIdHash := sha256.Sum256([]byte(sess.RpId))
+	if !bytes.Equal(ad.rpIdHash, rpIdHash[:]) {
+		return nil, errors.New("rp id not match")
+	}
+	if ad.flags&WEBAUTHN_FLAG_UP == 0 {
+		return nil, errors.New("user not present")
+	}
+	//passkey alone is two factors only if the user is verified by pin or biometrics
+	if purpose == webauthnLogin && ad.flags&WEBAUTHN_FLAG_UV == 0 {
+		return nil, errors.New("user not verified")
+	}
+	clientDataHash := sha256.Sum256(clientData)
+	if err = verifyCoseSignature(item.PublicKey, append(authData, clientDataHash[:]...), sig); err != nil {
+		return nil, err
+	}
+	//counter of cloned authenticator falls behind, 0 if it is not supported
+	if ad.signCount != 0 || item.SignCount != 0 {
+		if int64(ad.signCount) <= item.SignCount {
+			return nil, fmt.Errorf("sign count %v not after %v, cloned authenticator", ad.signCount, item.SignCount)
+		}
+	}
+
+	item.SignCount = int64(ad.signCount)
+	item.Atime = time.Now()
+	_, err = self.orm.ID(item.Id).Cols("sign_count", "atime").Update(item)
+	if err != nil {
+		logrus.Errorf("[webauthn.go::verifyAssertion] orm.Update: %v", err)
+	}
+	return item, nil
+}
+
+// webauthnRequestOptions of a challenge for uid, credentials of uid are allowed, any discoverable one if uid is 0
+func (self *WebServer) webauthnRequestOptions(c *gin.Context, uid int64, purpose string) (*models.WebauthnRequestOptions, error) {
+	allow := []models.WebauthnDescriptor{}
+	if uid != 0 {
+		var items []models.TblWebauthn
+		if err := self.orm.Where(`uid=?`, uid).Find(&items); err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(items); i++ {
+			allow = append(allow, models.WebauthnDescriptor{Type: "public-key", Id: items[i].CredId})
+		}
+	}
+	challenge, rpId := self.newWebauthnChallenge(c, uid, purpose)
+	verification := "preferred"
+	if purpose == webauthnLogin {
+		verification = "required"
+	}
+	return &models.WebauthnRequestOptions{
+		Challenge:        challenge,
+		RpId:             rpId,
+		Timeout:          WEBAUTHN_TIMEOUT.Milliseconds(),
+		AllowCredentials: allow,
+		UserVerification: verification,
+	}, nil
+}
+
+// POST /api/setting/webauthn/challenge
+func (self *WebServer) webauthnChallenge(c *gin.Context) {
+	user, ok := self.currentUser(c)
+	if !ok {
+		return
+	}
+	var items []models.TblWebauthn
+	if err := self.orm.Where(`uid=?`, user.Id).Find(&items); err != nil {
+		logrus.Errorf("[webauthn.go::webauthnChallenge] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	exclude := make([]models.WebauthnDescriptor, 0, len(items))
+	for i := 0; i < len(items); i++ {
+		exclude = append(exclude, models.WebauthnDescriptor{Type: "public-key", Id: items[i].CredId})
+	}
+
+	challenge, rpId := self.newWebauthnChallenge(c, user.Id, webauthnRegister)
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result: models.WebauthnCreationOptions{
+			Challenge: challenge,
+			Rp:        models.WebauthnRp{Id: rpId, Name: "godnslog"},
+			User: models.WebauthnUser{
+				Id:          webauthnUserId(user.Id),
+				Name:        user.Name,
+				DisplayName: user.Name,
+			},
+			PubKeyCredParams: []models.WebauthnParam{
+				{Type: "public-key", Alg: COSE_ES256},
+				{Type: "public-key", Alg: COSE_EDDSA},
+				{Type: "public-key", Alg: COSE_RS256},
+			},
+			Timeout:            WEBAUTHN_TIMEOUT.Milliseconds(),
+			ExcludeCredentials: exclude,
+			AuthenticatorSelection: models.WebauthnSelection{
+				ResidentKey:      "preferred",
+				UserVerification: "preferred",
+			},
+			Attestation: "none",
+		},
+	})
+}
+
+// registerCredential verify credential of navigator.credentials.create(), attestation statement is not verified
+func (self *WebServer) registerCredential(cred *models.WebauthnCredential, uid int64) (*webauthnAuthData, error) {
+	if cred == nil || cred.Type != "public-key" {
+		return nil, errWebauthn
+	}
+	clientData, err := decodeBase64url(cred.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	attestation, err := decodeBase64url(cred.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := self.verifyClientData(clientData, "webauthn.create", webauthnRegister)
+	if err != nil {
+		return nil, err
+	} else if sess.Uid != uid {
+		return nil, errors.New("challenge of other user")
+	}
+
+	v, _, err := decodeCbor(attestation)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errWebauthn
+	}
+	authData, ok := obj["authData"].([]byte)
+	if !ok {
+		return nil, errWebauthn
+	}
+	ad, err := parseAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+	rpIdHash := sha256.Sum256([]byte(sess.RpId))
+	if !bytes.Equal(ad.rpIdHash, rpIdHash[:]) {
+		return nil, errors.New("rp id not match")
+	}
+	if ad.flags&WEBAUTHN_FLAG_UP == 0 {
+		return nil, errors.New("user not present")
+	}
+	if ad.credId == nil {
+		return nil, errors.New("no attested credential data")
+	}
+	if rawId, err := decodeBase64url(cred.RawId); err != nil || !bytes.Equal(rawId, ad.credId) {
+		return nil, errors.New("credential id not match")
+	}
+	if _, _, err = parseCoseKey(ad.publicKey); err != nil {
+		return nil, err
+	}
+	return ad, nil
+}
+
+// PUT /api/setting/webauthn
+func (self *WebServer) addPasskey(c *gin.Context) {
+	var req models.WebauthnRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Credential == nil || len(req.Name) > 64 {
+		self.resp(c, 400, &CR{
+			Message: "bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	id := c.GetInt64("id")
+	ad, err := self.registerCredential(req.Credential, id)
+	if err != nil {
+		reqLog(c).Infof("[webauthn.go::addPasskey] registerCredential: %v", err)
+		self.resp(c, 400, &CR{
+			Message: "invalid credential",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+	count, err := session.Where(`uid=?`, id).Count(&models.TblWebauthn{})
+	if err != nil {
+		logrus.Errorf("[webauthn.go::addPasskey] orm.Count: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if count >= MAX_USER_PASSKEYS {
+		self.resp(c, 400, &CR{
+			Message: "Too many passkeys",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	credId := webauthnEncoding.EncodeToString(ad.credId)
+	if exist, _ := session.Where(`cred_id=?`, credId).Exist(&models.TblWebauthn{}); exist {
+		self.resp(c, 400, &CR{
+			Message: "credential registered already",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = fmt.Sprintf("passkey %v", count+1)
+	}
+	item := models.TblWebauthn{
+		Uid:       id,
+		Name:      name,
+		CredId:    credId,
+		PublicKey: ad.publicKey,
+		SignCount: int64(ad.signCount),
+		Atime:     time.Now(),
+	}
+	_, err = session.InsertOne(&item)
+	if err != nil {
+		logrus.Errorf("[webauthn.go::addPasskey] orm.InsertOne: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	reqLog(c).Infof("[webauthn.go::addPasskey] passkey %v of user %v registered", item.Id, id)
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  item.Id,
+	})
+}
+
+// GET /api/setting/webauthn
+func (self *WebServer) getPasskeys(c *gin.Context) {
+	id := c.GetInt64("id")
+	var items []models.TblWebauthn
+	err := self.orm.Where(`uid=?`, id).Asc("id").Find(&items)
+	if err != nil {
+		logrus.Errorf("[webauthn.go::getPasskeys] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	passkeys := make([]models.Passkey, len(items))
+	for i := 0; i < len(items); i++ {
+		passkeys[i] = models.Passkey{
+			Id:    items[i].Id,
+			Name:  items[i].Name,
+			Ctime: items[i].Ctime,
+			Atime: items[i].Atime,
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  passkeys,
+	})
+}
+
+// POST /api/setting/webauthn
+func (self *WebServer) setPasskey(c *gin.Context) {
+	var req models.WebauthnRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Id < 1 || req.Name == "" || len(req.Name) > 64 {
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	id := c.GetInt64("id")
+	affected, err := self.orm.ID(req.Id).And(`uid=?`, id).Cols("name").
+		Update(&models.TblWebauthn{Name: req.Name})
+	if err != nil {
+		logrus.Errorf("[webauthn.go::setPasskey] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if affected == 0 {
+		self.resp(c, 404, &CR{
+			Message: "No such passkey",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// DELETE /api/setting/webauthn
+func (self *WebServer) delPasskeys(c *gin.Context) {
+	var req DeleteRecordRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[webauthn.go::delPasskeys] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	params := make([]interface{}, len(req.Ids))
+	for i := 0; i < len(req.Ids); i++ {
+		params[i] = req.Ids[i]
+	}
+	id := c.GetInt64("id")
+	_, err := self.orm.Where(`uid=?`, id).In("id", params...).Delete(&models.TblWebauthn{})
+	if err != nil {
+		logrus.Errorf("[webauthn.go::delPasskeys] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	reqLog(c).Infof("[webauthn.go::delPasskeys] passkeys %v of user %v deleted", req.Ids, id)
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+// POST /api/auth/webauthn/challenge
+func (self *WebServer) webauthnLoginChallenge(c *gin.Context) {
+	var req models.WebauthnLogin
+	c.ShouldBindJSON(&req)
+
+	//unknown names get a challenge of no credentials, not distinguished from users without passkeys
+	var uid int64 = -1
+	if req.Username == "" {
+		uid = 0
+	} else {
+		user := new(models.TblUser)
+		exist, err := self.orm.Where(`name=?`, req.Username).Cols("id").Get(user)
+		if err != nil {
+			logrus.Errorf("[webauthn.go::webauthnLoginChallenge] orm.Get: %v", err)
+			self.respData(c, 502, CodeServerInternal, "bad service", nil)
+			return
+		} else if exist {
+			uid = user.Id
+		}
+	}
+	options, err := self.webauthnRequestOptions(c, uid, webauthnLogin)
+	if err != nil {
+		logrus.Errorf("[webauthn.go::webauthnLoginChallenge] webauthnRequestOptions: %v", err)
+		self.respData(c, 502, CodeServerInternal, "bad service", nil)
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  options,
+	})
+}
+
+// POST /api/auth/webauthn/login
+func (self *WebServer) webauthnLogin(c *gin.Context) {
+	var req models.WebauthnLogin
+	if err := c.ShouldBindJSON(&req); err != nil || req.Credential == nil {
+		self.resp(c, 400, &CR{
+			Code:    CodeBadData,
+			Message: "bad input",
+		})
+		return
+	}
+	item, err := self.verifyAssertion(req.Credential, 0, webauthnLogin)
+	if err != nil {
+		logrus.Infof("[webauthn.go::webauthnLogin] verifyAssertion: %v", err)
+		self.respData(c, 401, CodeBadData, "bad request", nil)
+		return
+	}
+	user := new(models.TblUser)
+	exist, err := self.orm.ID(item.Uid).Get(user)
+	if err != nil {
+		logrus.Errorf("[webauthn.go::webauthnLogin] orm.Get: %v", err)
+		self.respData(c, 502, CodeServerInternal, "bad service", nil)
+		return
+	} else if !exist {
+		self.respData(c, 401, CodeBadData, "bad request", nil)
+		return
+	} else if user.Disabled {
+		logrus.Infof("[webauthn.go::webauthnLogin] user %v disabled", user.Name)
+		self.respData(c, 403, CodeNoPermission, "user disabled", nil)
+		return
+	}
+	logrus.Infof("[webauthn.go::webauthnLogin] user %v login by passkey %v", user.Name, item.Id)
+	self.issueToken(c, user)
+}
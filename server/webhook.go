@@ -0,0 +1,394 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chennqqi/godnslog/cache"
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+/*
+Webhooks of user, besides the callback url of app setting
+	GET|PUT|POST|DELETE /api/setting/webhooks
+each webhook receives records matching all of its filters, eg. dns hits to scanner, http hits to team channel
+	{"name": "team", "url": "https://chat.example.com/hook", "types": ["http"], "prefix": "scan-", "cidrs": ["10.0.0.0/8"]}
+body and signature are the same as callback, or message of chat robot by kind, attempts are saved as deliveries.
+kind godnslog relays records to another instance, see federation.go
+*/
+
+const (
+	MAX_USER_WEBHOOKS  = 16
+	MAX_WEBHOOK_NAME   = 64
+	MAX_WEBHOOK_SECRET = 128
+)
+
+type webhook struct {
+	models.TblWebhook
+	nets []*net.IPNet
+}
+
+// parseCidr parse cidr or single ip
+func parseCidr(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip: %v", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err
+}
+
+func compileWebhook(item *models.TblWebhook) (*webhook, error) {
+	h := &webhook{TblWebhook: *item}
+	for _, s := range item.Cidrs {
+		ipnet, err := parseCidr(s)
+		if err != nil {
+			return nil, err
+		}
+		h.nets = append(h.nets, ipnet)
+	}
+	return h, nil
+}
+
+// Match record event by type, token prefix and source ip
+func (h *webhook) Match(event *models.SessionEvent) bool {
+	if h.Disabled {
+		return false
+	}
+	if len(h.Types) > 0 {
+		found := false
+		for _, typ := range h.Types {
+			if typ == event.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if h.Prefix != "" && !strings.HasPrefix(strings.ToLower(strings.TrimPrefix(event.Var, "/")), h.Prefix) {
+		return false
+	}
+	if len(h.nets) > 0 {
+		ip := net.ParseIP(event.Ip)
+		if ip == nil {
+			return false
+		}
+		for _, ipnet := range h.nets {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func validWebhook(req *Webhook) error {
+	if utf8.RuneCountInString(req.Name) > MAX_WEBHOOK_NAME {
+		return fmt.Errorf("name should be at most %v characters", MAX_WEBHOOK_NAME)
+	}
+	u, err := url.Parse(req.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url")
+	}
+	if req.Kind != "" && !chatKinds[req.Kind] && req.Kind != FEDERATION_KIND {
+		return fmt.Errorf("invalid kind: %v", req.Kind)
+	} else if req.Kind == FEDERATION_KIND && req.Secret == "" {
+		return fmt.Errorf("relay token of peer required as secret")
+	}
+	if len(req.Secret) > MAX_WEBHOOK_SECRET {
+		return fmt.Errorf("secret should be at most %v bytes", MAX_WEBHOOK_SECRET)
+	}
+	for _, typ := range req.Types {
+		if _, exist := exporters[typ]; !exist {
+			return fmt.Errorf("invalid type: %v", typ)
+		}
+	}
+	req.Prefix = strings.ToLower(strings.TrimPrefix(req.Prefix, "/"))
+	_, err = compileWebhook(&models.TblWebhook{Cidrs: req.Cidrs})
+	return err
+}
+
+// webhooks get compiled webhooks of user, cached until webhooks changed
+func (self *WebServer) webhooks(uid int64) ([]*webhook, error) {
+	store := self.store
+	key := fmt.Sprintf("%v.webhooks", uid)
+	v, exist := store.Get(key)
+	if exist {
+		return v.([]*webhook), nil
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblWebhook
+	err := session.Where(`uid=?`, uid).Asc("id").Find(&items)
+	if err != nil {
+		return nil, err
+	}
+	hooks := make([]*webhook, 0, len(items))
+	for i := 0; i < len(items); i++ {
+		h, err := compileWebhook(&items[i])
+		if err != nil {
+			logrus.Warnf("[webhook.go::webhooks] compile webhook(id=%v): %v", items[i].Id, err)
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+	store.Set(key, hooks, cache.NoExpiration)
+	return hooks, nil
+}
+
+// dispatchWebhooks deliver record event to matched webhooks of user allowed by alert, bean is relayed to peers
+func (self *WebServer) dispatchWebhooks(uid int64, event *models.SessionEvent, a *alert, bean interface{}, relayed bool) {
+	if uid == 0 {
+		return
+	}
+	hooks, err := self.webhooks(uid)
+	if err != nil {
+		logrus.Errorf("[webhook.go::dispatchWebhooks] webhooks: %v", err)
+		return
+	}
+	for _, h := range hooks {
+		if !a.AllowWebhook(h.Id) || !h.Match(event) {
+			continue
+		}
+		var d *models.TblDelivery
+		if h.Kind == FEDERATION_KIND {
+			if relayed {
+				continue
+			}
+			var body []byte
+			body, err = relayBody(event.Type, bean)
+			d = &models.TblDelivery{Uid: uid, Hid: h.Id, Url: h.Url, Type: event.Type, Rid: event.Id, Body: string(body)}
+		} else if h.Kind != "" {
+			var body []byte
+			body, err = self.chatBody(h.Kind, event)
+			d = &models.TblDelivery{Uid: uid, Hid: h.Id, Url: h.Url, Type: event.Type, Rid: event.Id, Body: string(body)}
+		} else {
+			d, err = self.newDelivery(uid, h.Id, h.Url, event.Type, event.Id, event.Data)
+		}
+		log := logrus.WithFields(logrus.Fields{"uid": uid, "webhook": h.Id, "type": event.Type, "rid": event.Id})
+		if err != nil {
+			log.Infof("[webhook.go::dispatchWebhooks] webhook(id=%v): %v", h.Id, err)
+			continue
+		}
+		self.wg.Add(1)
+		go func() {
+			defer self.wg.Done()
+			if err := self.deliver(d); err != nil {
+				log.Infof("[webhook.go::dispatchWebhooks] webhook(id=%v): %v", d.Hid, err)
+			}
+		}()
+	}
+}
+
+func (self *WebServer) getWebhooks(c *gin.Context) {
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblWebhook
+	err := session.Where(`uid=?`, id).Asc("id").Find(&items)
+	if err != nil {
+		logrus.Errorf("[webhook.go::getWebhooks] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	hooks := make([]Webhook, len(items))
+	for i := 0; i < len(items); i++ {
+		item := &items[i]
+		hooks[i] = Webhook{
+			Id:       item.Id,
+			Name:     item.Name,
+			Url:      item.Url,
+			Types:    item.Types,
+			Prefix:   item.Prefix,
+			Cidrs:    item.Cidrs,
+			Disabled: item.Disabled,
+			Kind:     item.Kind,
+			Secret:   item.Secret,
+		}
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  hooks,
+	})
+}
+
+func (self *WebServer) addWebhook(c *gin.Context) {
+	var req Webhook
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		logrus.Infof("[webhook.go::addWebhook] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if err = validWebhook(&req); err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	count, err := session.Where(`uid=?`, id).Count(&models.TblWebhook{})
+	if err != nil {
+		logrus.Errorf("[webhook.go::addWebhook] orm.Count: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if count >= MAX_USER_WEBHOOKS {
+		self.resp(c, 400, &CR{
+			Message: "Too many webhooks",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	item := models.TblWebhook{
+		Uid:      id,
+		Name:     req.Name,
+		Url:      req.Url,
+		Types:    req.Types,
+		Prefix:   req.Prefix,
+		Cidrs:    req.Cidrs,
+		Disabled: req.Disabled,
+		Kind:     req.Kind,
+		Secret:   req.Secret,
+	}
+	_, err = session.InsertOne(&item)
+	if err != nil {
+		logrus.Errorf("[webhook.go::addWebhook] orm.InsertOne: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.store.Delete(fmt.Sprintf("%v.webhooks", id))
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  item.Id,
+	})
+}
+
+func (self *WebServer) setWebhook(c *gin.Context) {
+	var req Webhook
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.Id < 1 {
+		logrus.Infof("[webhook.go::setWebhook] parameter format invalid")
+		self.resp(c, 400, &CR{
+			Message: "Bad param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if err = validWebhook(&req); err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	affected, err := session.ID(req.Id).And(`uid=?`, id).AllCols().Omit("id", "uid", "atime").
+		Update(&models.TblWebhook{
+			Name:     req.Name,
+			Url:      req.Url,
+			Types:    req.Types,
+			Prefix:   req.Prefix,
+			Cidrs:    req.Cidrs,
+			Disabled: req.Disabled,
+			Kind:     req.Kind,
+			Secret:   req.Secret,
+		})
+	if err != nil {
+		logrus.Errorf("[webhook.go::setWebhook] orm.Update: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if affected == 0 {
+		self.resp(c, 404, &CR{
+			Message: "No such webhook",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	self.store.Delete(fmt.Sprintf("%v.webhooks", id))
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func (self *WebServer) delWebhooks(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil || len(req.Ids) == 0 {
+		logrus.Infof("[webhook.go::delWebhooks] parameter required")
+		self.resp(c, 400, &CR{
+			Message: "param required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	params := make([]interface{}, len(req.Ids))
+	for i := 0; i < len(req.Ids); i++ {
+		params[i] = req.Ids[i]
+	}
+
+	id := c.GetInt64("id")
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	_, err = session.Where(`uid=?`, id).In("id", params...).Delete(&models.TblWebhook{})
+	if err != nil {
+		logrus.Errorf("[webhook.go::delWebhooks] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	session.Where(`uid=?`, id).In("hid", params...).Delete(&models.TblDelivery{})
+	self.store.Delete(fmt.Sprintf("%v.webhooks", id))
+
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
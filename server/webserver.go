@@ -2,11 +2,13 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"fmt"
-	"io"
-	"io/ioutil"
+	"io/fs"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,17 +16,25 @@ import (
 	"github.com/chennqqi/godnslog/models"
 	_ "github.com/chennqqi/godnslog/server/docs" // docs is generated by Swag CLI, you have to import it.
 
-	"github.com/gin-contrib/static"
 	"github.com/gin-gonic/gin"
 	"github.com/go-sql-driver/mysql"
-	"github.com/hashicorp/go-retryablehttp"
+	"github.com/graphql-go/graphql"
+	"github.com/lib/pq"
 	"github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
 	"github.com/swaggo/gin-swagger"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
 	"xorm.io/xorm"
 )
 
+const (
+	MAX_BODY_SIZE    = 12 * 1024 * 1024 // mediumtext(16M) after base64
+	MAX_DATA_PREVIEW = 4096             // text of http body shown and searched as data, body keeps all of it
+)
+
 type WebServerConfig struct {
 	Driver    string
 	Dsn       string
@@ -34,12 +44,117 @@ type WebServerConfig struct {
 	WwwDomain string
 	Listen    string
 	Swagger   bool
+	BlobDir   string
+
+	//web ui, embedded assets if not nil, or files of UiDir, UI_DEFAULT_DIR if empty
+	UiAssets fs.FS
+	UiDir    string
+
+	//https listener, disabled if empty
+	HttpsListen    string
+	GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	//key authorization of acme http-01 challenge token, served on http listener, disabled if nil
+	HTTPChallenge func(token string) (string, bool)
+
+	//peers allowed to forward client ip by headers or PROXY protocol, none if nil
+	TrustedProxies *TrustedProxies
+
+	//tcp port catcher, ports are configured by admin, disabled if nil
+	TcpPorts *TcpPortServer
+
+	//archive records before cleaned, disabled if nil
+	Archive *S3Client
+
+	//grpc listener, disabled if empty
+	GrpcListen string
+
+	//pprof listener without authentication, should be loopback, disabled if empty
+	PprofListen string
+
+	//sink of dns and http records, sql if nil
+	ClickHouse *ClickHouse
+
+	//store of dns and http records, by ClickHouse or sql if nil
+	Store Store
+
+	//batch of records inserted by store routine, DEFAULT_STORE_BATCH and DEFAULT_STORE_FLUSH if 0
+	StoreBatch int
+	StoreFlush time.Duration
+
+	//records are kept in durable queue, eg. NATS or Kafka. http hits are queued too,
+	//failed inserts are retried until database is up instead of dropped
+	DurableQueue bool
+
+	//mirror of records, disabled if nil
+	Elastic *Elastic
+
+	//geoip enrichment of records, disabled if nil
+	GeoIP *GeoIP
+
+	//reverse dns enrichment of records
+	Rdns bool
+
+	//json file of scanner fingerprints, extends built-in ones
+	ScannersFile string
+
+	//base url of web ui in notifications, eg. https://log.example.com, empty: by IP and Listen
+	UiUrl string
+
+	//single sign-on by openid connect provider, disabled if nil
+	Oidc *OidcProvider
+
+	//public signup by invite codes, verification mails are sent by the mail server of admin
+	Signup bool
+
+	//telegram bot of notifications, disabled if token is empty
+	TelegramToken string
+	TelegramApi   string
+
+	//called by Reload after settings of database are reloaded, eg. config file and certificate
+	OnReload func() error
 
 	AuthExpire                   time.Duration
 	DefaultCleanInterval         int64
 	DefaultQueryApiMaxItem       int
 	DefaultMaxCallbackErrorCount int64
 	DefaultLanguage              string
+	DefaultMaxBodySize           int64
+	DefaultPayloadQuota          int64
+	MaxPayloadFileSize           int64
+
+	//max stored records of a user, 0: unlimited. oldest records are evicted, or new ones are rejected
+	DefaultRecordQuota int64
+	RecordQuotaReject  bool
+
+	//shared key of jwt of all processes, stateless jwt is disabled if empty
+	JwtKey    string
+	JwtExpire time.Duration
+
+	//allow replay, webhooks and callbacks to private networks, eg. loopback, 10.0.0.0/8 and 169.254.169.254
+	AllowPrivateTargets bool
+
+	//dns server of custom domain verification, host:port, empty: resolver of system
+	DomainResolver string
+
+	//key of ingest nodes, internal api is disabled if empty
+	IngestKey string
+
+	//bearer token of prometheus /metrics, disabled if empty
+	MetricsToken string
+
+	//allowed origins and methods of cross origin requests to /api and /data, disabled if no origin,
+	//CORS_DEFAULT_METHODS if no method
+	CorsOrigins []string
+	CorsMethods []string
+
+	//requests per minute of a token or client ip to /api and /data, and burst of them, disabled if 0.
+	//burst is RateLimit if 0
+	RateLimit int
+	RateBurst int
+
+	//tracing of requests, store routine and webhooks, disabled if nil
+	Tracer *Tracer
 }
 
 type WebServer struct {
@@ -48,13 +163,33 @@ type WebServer struct {
 	engine *gin.Engine
 	orm    *xorm.Engine
 	store  *cache.Cache
+	blob   *BlobStore
 
 	//internal
-	s         *http.Server
-	client    *http.Client
-	storeQuit chan struct{}
-	wg        sync.WaitGroup
-	verifyKey string //random generate
+	s              *http.Server
+	ts             *http.Server
+	gs             *grpc.Server
+	ps             *http.Server
+	schema         graphql.Schema
+	hellos         sync.Map //remote addr => JA3
+	rdnsSem        chan struct{}
+	realtime       realtimeHub
+	client         *http.Client
+	replayClient   *http.Client //of urls of users
+	deliveryClient *http.Client
+	storeQuit      chan struct{}
+	wg             sync.WaitGroup
+	quotaBusy      int32 //quota routine is running
+	notifyLock     sync.RWMutex
+	reloadLock     sync.Mutex
+	verifyKey      string //random generate
+
+	//context of webhook deliveries, cancelled at deadline of shutdown
+	deliverCtx       context.Context
+	cancelDeliveries context.CancelFunc
+
+	//token buckets of -rate-limit, nil if disabled
+	limiter *rateLimiter
 }
 
 func NewWebServer(cfg *WebServerConfig, store *cache.Cache) (*WebServer, error) {
@@ -71,6 +206,38 @@ func NewWebServer(cfg *WebServerConfig, store *cache.Cache) (*WebServer, error)
 	}
 	app.orm = orm
 	app.store = store
+	if app.StoreBatch <= 0 {
+		app.StoreBatch = DEFAULT_STORE_BATCH
+	} else if app.StoreBatch > MAX_STORE_BATCH {
+		app.StoreBatch = MAX_STORE_BATCH
+	}
+	if app.StoreFlush <= 0 {
+		app.StoreFlush = DEFAULT_STORE_FLUSH
+	}
+	if app.JwtExpire <= 0 {
+		app.JwtExpire = DEFAULT_JWT_EXPIRE
+	}
+	if app.RateLimit > 0 {
+		app.limiter = newRateLimiter(app.RateLimit, app.RateBurst)
+	}
+	app.replayClient = newReplayClient(app.AllowPrivateTargets)
+	app.deliveryClient = newDeliveryClient(app.AllowPrivateTargets)
+	if len(app.CorsMethods) == 0 {
+		app.CorsMethods = strings.Split(CORS_DEFAULT_METHODS, ",")
+	}
+	if app.Store == nil {
+		if cfg.ClickHouse != nil {
+			app.Store = NewClickHouseStore(cfg.ClickHouse, orm)
+		} else {
+			app.Store = NewSqlStore(orm)
+		}
+	}
+
+	blob, err := NewBlobStore(cfg.BlobDir)
+	if err != nil {
+		return nil, err
+	}
+	app.blob = blob
 
 	err = app.initDatabase()
 	if err != nil {
@@ -80,6 +247,8 @@ func NewWebServer(cfg *WebServerConfig, store *cache.Cache) (*WebServer, error)
 
 	app.verifyKey = genRandomString(16)
 	app.storeQuit = make(chan struct{})
+	app.deliverCtx, app.cancelDeliveries = context.WithCancel(context.Background())
+	app.rdnsSem = make(chan struct{}, RDNS_CONCURRENT)
 	return app, nil
 }
 
@@ -94,48 +263,120 @@ func (self *WebServer) doClean() {
 		logrus.Errorf("[webserver.go::doClean] orm.Find: %v", err)
 		return
 	}
-	now := time.Now()
-	if self.orm.DriverName() == "sqlite3" {
-		now = now.Local()
+	max, err := self.retentionMax()
+	if err != nil {
+		logrus.Errorf("[webserver.go::doClean] retentionMax: %v", err)
+		return
 	}
+	//count records before they are cleaned
+	self.doRollup()
+	now := self.sqlTime(time.Now())
 
 	for _, id := range ids {
 		userKey := fmt.Sprintf("%v.user", id)
 		v, exist := cache.Get(userKey)
-		if exist {
-			user := v.(*models.TblUser)
-			t := now.Add(time.Duration(-1) * time.Duration(user.CleanInterval) * time.Second)
-			session.Where(`uid=?`, id).And(`ctime<?`, t).Delete(&models.TblDns{})
-			session.Where(`uid=?`, id).And(`ctime<?`, t).Delete(&models.TblHttp{})
+		if !exist {
+			continue
+		}
+		user := v.(*models.TblUser)
+		for _, typ := range searchTypes {
+			t := now.Add(time.Duration(-1) * time.Duration(retention(user, typ, max)) * time.Second)
+			if self.Archive != nil {
+				err = self.archiveType(id, typ, t)
+				if err != nil {
+					logrus.Errorf("[webserver.go::doClean] archiveType(%v, %v): %v", id, typ, err)
+					continue
+				}
+			}
+			_, err = self.deleteHits(session.Where(`uid=?`, id).And(`ctime<?`, t), exporters[typ].bean())
+			if err != nil {
+				logrus.Errorf("[webserver.go::doClean] orm.Delete(%v, %v): %v", id, typ, err)
+			}
 		}
 	}
+	self.cleanHttpFiles()
+	self.cleanSearch()
+	self.cleanTokens()
+	self.cleanDeliveries()
 }
 
-func (self *WebServer) RunStoreRoutine() {
-	store := self.store
+// remove files, websocket frames and replays whose http record has been deleted
+func (self *WebServer) cleanHttpFiles() {
+	if self.ClickHouse != nil {
+		//http records are not in sql
+		return
+	}
 	session := self.orm.NewSession()
 	defer session.Close()
+
+	var files []models.TblHttpFile
+	err := session.Where(`rid not in (select id from tbl_http)`).Find(&files)
+	if err != nil {
+		logrus.Errorf("[webserver.go::cleanHttpFiles] orm.Find: %v", err)
+		return
+	}
+	for i := 0; i < len(files); i++ {
+		file := &files[i]
+		err = self.blob.Delete(file.Blob)
+		if err != nil {
+			logrus.Errorf("[webserver.go::cleanHttpFiles] blob.Delete(%v): %v", file.Blob, err)
+			continue
+		}
+		session.ID(file.Id).Delete(&models.TblHttpFile{})
+	}
+
+	_, err = session.Where(`rid not in (select id from tbl_http)`).Delete(&models.TblHttpFrame{})
+	if err != nil {
+		logrus.Errorf("[webserver.go::cleanHttpFiles] orm.Delete(frames): %v", err)
+	}
+	_, err = session.Where(`rid not in (select id from tbl_http)`).Delete(&models.TblHttpReplay{})
+	if err != nil {
+		logrus.Errorf("[webserver.go::cleanHttpFiles] orm.Delete(replays): %v", err)
+	}
+}
+
+func (self *WebServer) RunStoreRoutine() {
+	store := self.store
 	ticker := time.NewTicker(1800 * time.Second)
 	defer ticker.Stop()
-
-	var client = retryablehttp.NewClient()
-	client.RetryMax = 3
-	client.RetryWaitMin = 5 * time.Second
-	client.RetryWaitMax = 60 * time.Second
+	flush := time.NewTicker(self.StoreFlush)
+	defer flush.Stop()
+	quota := time.NewTicker(QUOTA_INTERVAL)
+	defer quota.Stop()
+	batch := newRecordBatch(self.StoreBatch)
 
 	dnsCallBack := func(rcd *DnsRecord) {
 		defer self.wg.Done()
-		req, err := retryablehttp.NewRequest("POST", rcd.Callback, nil)
-		resp, err := client.Do(req)
+		log := logrus.WithFields(logrus.Fields{"uid": rcd.Uid, "type": "dns", "rid": rcd.Id})
+		d, err := self.newDelivery(rcd.Uid, 0, rcd.Callback, "dns", rcd.Id, rcd)
+		if err != nil {
+			log.Infof("[webserver.go::RunStoreRoutine] dns callback: %v", err)
+			return
+		}
 		errorCountKey := fmt.Sprintf("%v.errcount", rcd.Uid)
+		if err = self.deliver(d); err != nil {
+			store.IncrementInt64(errorCountKey, 1)
+			log.Infof("[webserver.go::RunStoreRoutine] dns callback: %v", err)
+			return
+		}
+		store.Delete(errorCountKey)
+	}
+
+	ldapCallBack := func(rcd *LdapRecord) {
+		defer self.wg.Done()
+		log := logrus.WithFields(logrus.Fields{"uid": rcd.Uid, "type": "ldap", "rid": rcd.Id})
+		d, err := self.newDelivery(rcd.Uid, 0, rcd.Callback, "ldap", rcd.Id, rcd)
 		if err != nil {
+			log.Infof("[webserver.go::RunStoreRoutine] ldap callback: %v", err)
+			return
+		}
+		errorCountKey := fmt.Sprintf("%v.errcount", rcd.Uid)
+		if err = self.deliver(d); err != nil {
 			store.IncrementInt64(errorCountKey, 1)
-			logrus.Infof("[webserver.go::RunStoreRoutine] dns callback: %v", err)
+			log.Infof("[webserver.go::RunStoreRoutine] ldap callback: %v", err)
 			return
 		}
 		store.Delete(errorCountKey)
-		io.Copy(ioutil.Discard, resp.Body)
-		resp.Body.Close()
 	}
 
 	// httpCallBack := func(rcd *HttpRecord) {
@@ -159,35 +400,205 @@ FOR_LOOP:
 				defer self.wg.Done()
 				self.doClean()
 			}()
+			self.wg.Add(1)
+			go func() {
+				defer self.wg.Done()
+				self.doDigest()
+			}()
+			self.loadScanners()
+
+		case <-flush.C:
+			self.flushRecords(batch)
+
+		case <-quota.C:
+			self.wg.Add(1)
+			go func() {
+				defer self.wg.Done()
+				self.doQuota()
+			}()
 
 		case rcd, ok := <-store.Output():
 			if !ok {
 				break FOR_LOOP
 			}
+			if self.overQuota(recordUid(rcd)) {
+				continue
+			}
 			switch rcd.(type) {
 			case *DnsRecord:
 				d := rcd.(*DnsRecord)
-				_, err := session.InsertOne(&models.TblDns{
+				if !self.ipRecordable(d.Uid, d.Ip) {
+					break
+				}
+				dedupKey, window := self.dnsDedupKey(d)
+				if dedupKey != "" {
+					if dup, exist := batch.dedup[dedupKey]; exist {
+						dup.Hits++
+						break
+					} else if self.dedupHit(dedupKey, &models.TblDns{}) {
+						break
+					}
+				}
+				item := &models.TblDns{
 					Uid:    d.Uid,
 					Domain: d.Domain,
+					Qtype:  d.Qtype,
 					Var:    d.Var,
 					Ip:     d.Ip,
+					Hits:   1,
 					Ctime:  d.Ctime,
+					Geo:    self.GeoIP.Lookup(d.Ip),
+				}
+				item.Scanner = self.classifyScanner(d.Ip, "")
+				if dedupKey != "" {
+					batch.dedup[dedupKey] = item
+				}
+				batch.add(item, func() {
+					if dedupKey != "" {
+						store.Set(dedupKey, item.Id, window)
+					}
+					self.rdnsEnrich(item, item.Id, item.Ip)
+					a := self.recordAdded(item)
+					if d.Callback != "" && d.Uid > 0 && a.Allow(ALERT_CALLBACK) {
+						errorCountKey := fmt.Sprintf("%v.errcount", d.Uid)
+						v, exist := store.Get(errorCountKey)
+						if exist {
+							if v.(int64) >= self.DefaultMaxCallbackErrorCount {
+								return
+							}
+						}
+						d.Id = item.Id
+						self.wg.Add(1)
+						go dnsCallBack(d)
+					}
 				})
-				if err != nil {
-					logrus.Fatalf("[web.go::storeRoutine] orm.InsertOne: %v", err)
+			case *SmtpRecord:
+				m := rcd.(*SmtpRecord)
+				item := &models.TblSmtp{
+					Uid:       m.Uid,
+					Ip:        m.Ip,
+					Var:       m.Var,
+					Helo:      m.Helo,
+					MailFrom:  m.From,
+					RcptTo:    strings.Join(m.To, ","),
+					Subject:   m.Subject,
+					Headers:   m.Headers,
+					Body:      m.Body,
+					Size:      m.Size,
+					Truncated: m.Truncated,
+					Ctime:     m.Ctime,
+				}
+				batch.add(item, func() {
+					self.recordAdded(item)
+				})
+			case *LdapRecord:
+				l := rcd.(*LdapRecord)
+				item := &models.TblLdap{
+					Uid:      l.Uid,
+					Ip:       l.Ip,
+					Var:      l.Var,
+					Op:       l.Op,
+					Dn:       l.Dn,
+					Password: l.Password,
+					Ctime:    l.Ctime,
 				}
-				if d.Callback != "" && d.Uid > 0 {
-					errorCountKey := fmt.Sprintf("%v.errcount", d.Uid)
-					v, exist := store.Get(errorCountKey)
-					if exist {
-						if v.(int64) >= self.DefaultMaxCallbackErrorCount {
-							break
+				batch.add(item, func() {
+					a := self.recordAdded(item)
+					if l.Callback != "" && l.Uid > 0 && a.Allow(ALERT_CALLBACK) {
+						errorCountKey := fmt.Sprintf("%v.errcount", l.Uid)
+						v, exist := store.Get(errorCountKey)
+						if exist && v.(int64) >= self.DefaultMaxCallbackErrorCount {
+							return
 						}
+						l.Id = item.Id
+						self.wg.Add(1)
+						go ldapCallBack(l)
 					}
-					self.wg.Add(1)
-					go dnsCallBack(d)
+				})
+			case *FtpRecord:
+				f := rcd.(*FtpRecord)
+				item := &models.TblFtp{
+					Uid:      f.Uid,
+					Ip:       f.Ip,
+					Var:      f.Var,
+					User:     f.User,
+					Password: f.Password,
+					Paths:    f.Paths,
+					Commands: f.Commands,
+					Ctime:    f.Ctime,
+				}
+				batch.add(item, func() {
+					self.recordAdded(item)
+				})
+			case *TcpRecord:
+				t := rcd.(*TcpRecord)
+				item := &models.TblTcp{
+					Uid:   t.Uid,
+					Ip:    t.Ip,
+					Port:  t.Port,
+					Var:   t.Var,
+					Data:  t.Data,
+					Size:  t.Size,
+					Ctime: t.Ctime,
 				}
+				batch.add(item, func() {
+					self.recordAdded(item)
+				})
+			case *IcmpRecord:
+				i := rcd.(*IcmpRecord)
+				item := &models.TblIcmp{
+					Uid:    i.Uid,
+					Ip:     i.Ip,
+					Var:    i.Var,
+					EchoId: i.EchoId,
+					Seq:    i.Seq,
+					Data:   i.Data,
+					Size:   i.Size,
+					Ctime:  i.Ctime,
+				}
+				batch.add(item, func() {
+					self.recordAdded(item)
+				})
+			case *SmbRecord:
+				m := rcd.(*SmbRecord)
+				item := &models.TblSmb{
+					Uid:    m.Uid,
+					Ip:     m.Ip,
+					Var:    m.Var,
+					Host:   m.Host,
+					Domain: m.Domain,
+					User:   m.User,
+					Target: m.Target,
+					Os:     m.Os,
+					Hash:   m.Hash,
+					Ctime:  m.Ctime,
+				}
+				batch.add(item, func() {
+					self.recordAdded(item)
+				})
+			case *RmiRecord:
+				m := rcd.(*RmiRecord)
+				item := &models.TblRmi{
+					Uid:   m.Uid,
+					Ip:    m.Ip,
+					Var:   m.Var,
+					Host:  m.Host,
+					Name:  m.Name,
+					Ctime: m.Ctime,
+				}
+				batch.add(item, func() {
+					self.recordAdded(item)
+				})
+			case *models.TblHttp:
+				//queued by logHttp or ingest nodes with durable queue
+				body, _ := base64.StdEncoding.DecodeString(rcd.(*models.TblHttp).Body)
+				self.retryStore("addHttp", func() error {
+					err := self.addHttp(rcd.(*models.TblHttp), body)
+					if err == ErrRecordQuota {
+						return nil
+					}
+					return err
+				})
 			case *HttpRecord:
 				// logged in `record` function
 				// 	h := rcd.(*HttpRecord)
@@ -212,13 +623,27 @@ FOR_LOOP:
 				// 		go httpCallBack(h)
 				// 	}
 			}
+			if batch.len() >= self.StoreBatch {
+				self.flushRecords(batch)
+			}
 		}
 	}
+	self.flushRecords(batch)
 	close(self.storeQuit)
 }
 
 func (self *WebServer) Run() error {
-	r := gin.Default()
+	r := gin.New()
+	r.Use(self.accessLog, gin.Recovery())
+	if len(self.CorsOrigins) > 0 {
+		r.Use(self.corsHandler)
+	}
+
+	schema, err := self.newGraphqlSchema()
+	if err != nil {
+		return err
+	}
+	self.schema = schema
 
 	if self.Swagger {
 		// use localhost
@@ -226,40 +651,195 @@ func (self *WebServer) Run() error {
 		r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, url))
 	}
 
+	//before interaction log, validation requests of acme are not records
+	if self.HTTPChallenge != nil {
+		r.GET("/.well-known/acme-challenge/:token", self.acmeChallenge)
+	}
+
+	r.Use(self.traceHandler)
+
+	//subdomain of user is collaborator payload
+	r.Use(self.interactionLog)
+
+	//after interaction log, hits of users are never limited
+	if self.limiter != nil {
+		r.Use(self.rateLimit)
+	}
+
+	if self.MetricsToken != "" {
+		r.GET("/metrics", self.getMetrics)
+	}
+
 	//static handler
-	r.Use(static.Serve("/", static.LocalFile("dist", false)))
-	r.NoRoute(func(c *gin.Context) {
-		c.File("dist/index.html")
-	})
+	self.serveUi(r)
 
 	//api handler
 	api := r.Group("/api")
+	api.Use(self.auditLog, self.csrfHandler)
+
+	api.GET("/version", self.getVersion)
 
 	//auth group
 	auth := api.Group("auth")
 	{
 		auth.POST("/login", self.userLogin)
+		auth.POST("/webauthn/challenge", self.webauthnLoginChallenge)
+		auth.POST("/webauthn/login", self.webauthnLogin)
+		auth.GET("/oidc", self.oidcInfo)
+		auth.GET("/oidc/login", self.oidcLogin)
+		auth.GET("/oidc/callback", self.oidcCallback)
+		auth.POST("/password/forgot", self.forgotPassword)
+		auth.GET("/password/reset", self.passwordResetForm)
+		auth.POST("/password/reset", self.resetPassword)
+		auth.GET("/signup", self.signupInfo)
+		auth.POST("/signup", self.signup)
+		auth.GET("/signup/verify", self.signupVerifyForm)
+		auth.POST("/signup/verify", self.signupVerify)
+		auth.POST("/refresh", self.refreshToken)
 		auth.POST("/logout", self.authHandler, self.userLogout)
 		auth.GET("/info", self.authHandler, self.userInfo)
 		auth.GET("/nav", self.authHandler, self.userNav)
 	}
 
 	//data group
-	data := api.Group("/record", self.authHandler)
+	data := api.Group("/record", self.authHandler, self.verifyWritePermission)
 	{
 		data.GET("/dns", self.getDnsRecord)
 		data.GET("/http", self.getHttpRecord)
+		data.GET("/http/:id/body", self.getHttpRecordBody)
 		data.DELETE("/dns", self.delDnsRecord)
 		data.DELETE("/http", self.delHttpRecord)
+		data.GET("/smtp", self.getSmtpRecord)
+		data.DELETE("/smtp", self.delSmtpRecord)
+		data.GET("/ldap", self.getLdapRecord)
+		data.DELETE("/ldap", self.delLdapRecord)
+		data.GET("/ftp", self.getFtpRecord)
+		data.DELETE("/ftp", self.delFtpRecord)
+		data.GET("/tcp", self.getTcpRecord)
+		data.DELETE("/tcp", self.delTcpRecord)
+		data.GET("/icmp", self.getIcmpRecord)
+		data.DELETE("/icmp", self.delIcmpRecord)
+		data.GET("/smb", self.getSmbRecord)
+		data.DELETE("/smb", self.delSmbRecord)
+		data.GET("/rmi", self.getRmiRecord)
+		data.DELETE("/rmi", self.delRmiRecord)
+		data.GET("/session/:token", self.getSession)
 	}
 
-	setting := api.Group("/setting", self.authHandler)
+	//captured data group
+	capture := api.Group("/data", self.authHandler, self.verifyWritePermission)
+	{
+		capture.GET("/http/:id/files", self.getHttpFiles)
+		capture.GET("/http/:id/files/:n", self.getHttpFile)
+		capture.GET("/http/:id/frames", self.getHttpFrames)
+		capture.GET("/http/:id/replay", self.getHttpReplays)
+		capture.POST("/http/:id/replay", self.replayHttpRecord)
+		capture.GET("/search", self.searchRecord)
+		capture.GET("/stats", self.getStats)
+		capture.GET("/rollup", self.getRollup)
+		capture.GET("/export/:type", self.exportRecord)
+		capture.DELETE("/all", self.purgeData)
+		capture.PUT("/dns/:id/tags", self.setDnsTags)
+		capture.PUT("/http/:id/tags", self.setHttpTags)
+		for typ, bean := range noteTables {
+			capture.PUT("/"+typ+"/:id/note", self.setRecordNote(bean))
+		}
+	}
+
+	//internal api of ingest nodes
+	ingest := api.Group("/ingest", self.verifyIngestKey)
+	{
+		ingest.GET("/users", self.ingestUsers)
+		ingest.POST("/records", self.ingestRecords)
+	}
+
+	api.GET("/realtime", self.realtimeToken, self.authHandler, self.getRealtime)
+	api.GET("/stream", self.realtimeToken, self.authHandler, self.getStream)
+
+	//profiles of go tool pprof, token may be in query
+	pprofAuth := []gin.HandlerFunc{self.realtimeToken, self.authHandler, self.verifySuperPermission, pprofHandler}
+	api.GET("/admin/debug/pprof/*name", pprofAuth...)
+	api.POST("/admin/debug/pprof/*name", pprofAuth...)
+	api.GET("/graphql", self.authHandler, self.graphql)
+	api.POST("/graphql", self.authHandler, self.graphql)
+
+	//security of own account, allowed to viewers
+	security := api.Group("/setting", self.authHandler)
+	{
+		security.GET("/security", self.getSecuritySetting)
+		security.POST("/security", self.setSecuritySetting)
+		security.POST("/security/totp", self.enrollTotp)
+		security.PUT("/security/totp", self.enableTotp)
+		security.DELETE("/security/totp", self.disableTotp)
+		security.POST("/security/totp/recovery", self.resetTotpRecovery)
+		security.POST("/security/oidc", self.oidcLink)
+
+		security.POST("/webauthn/challenge", self.webauthnChallenge)
+		security.GET("/webauthn", self.getPasskeys)
+		security.PUT("/webauthn", self.addPasskey)
+		security.POST("/webauthn", self.setPasskey)
+		security.DELETE("/webauthn", self.delPasskeys)
+
+		security.GET("/sessions", self.getLogins)
+		security.DELETE("/sessions", self.delLogins)
+		security.DELETE("/sessions/others", self.delOtherLogins)
+	}
+
+	setting := api.Group("/setting", self.authHandler, self.verifyWritePermission)
 	{
 		setting.GET("/app", self.getAppSetting)
 		setting.POST("/app", self.setAppSetting)
 
-		setting.GET("/security", self.getSecuritySetting)
-		setting.POST("/security", self.setSecuritySetting)
+		setting.GET("/httprules", self.getHttpRules)
+		setting.PUT("/httprules", self.addHttpRule)
+		setting.POST("/httprules", self.setHttpRule)
+		setting.DELETE("/httprules", self.delHttpRules)
+
+		setting.GET("/files", self.getPayloadFiles)
+		setting.PUT("/files", self.addPayloadFile)
+		setting.DELETE("/files", self.delPayloadFiles)
+
+		setting.GET("/tokens", self.getTokens)
+		setting.PUT("/tokens", self.addToken)
+		setting.POST("/tokens", self.setToken)
+		setting.DELETE("/tokens", self.delTokens)
+		setting.POST("/token/rotate", self.rotateToken)
+
+		setting.POST("/callback/secret", self.resetCallbackSecret)
+
+		setting.GET("/webhooks", self.getWebhooks)
+		setting.PUT("/webhooks", self.addWebhook)
+		setting.POST("/webhooks", self.setWebhook)
+		setting.DELETE("/webhooks", self.delWebhooks)
+		setting.GET("/webhooks/:id/deliveries", self.getDeliveries)
+		setting.POST("/webhooks/:id/deliveries/:did/redeliver", self.redeliver)
+
+		setting.GET("/alertrules", self.getAlertRules)
+		setting.PUT("/alertrules", self.addAlertRule)
+		setting.POST("/alertrules", self.setAlertRule)
+		setting.DELETE("/alertrules", self.delAlertRules)
+
+		setting.GET("/ipfilters", self.getIpFilters)
+		setting.PUT("/ipfilters", self.addIpFilter)
+		setting.DELETE("/ipfilters", self.delIpFilters)
+
+		setting.GET("/burp", self.getBurpClients)
+		setting.POST("/burp/approve", self.approveBurpClient)
+		setting.DELETE("/burp", self.delBurpClients)
+
+		setting.GET("/domains", self.getCustomDomains)
+		setting.PUT("/domains", self.addCustomDomain)
+		setting.POST("/domains/verify", self.verifyDomain)
+		setting.DELETE("/domains", self.delCustomDomains)
+
+		setting.GET("/teams", self.getTeams)
+		setting.PUT("/teams", self.addTeam)
+		setting.POST("/teams", self.setTeam)
+		setting.DELETE("/teams", self.delTeams)
+		setting.PUT("/teams/:id/members", self.addTeamMember)
+		setting.POST("/teams/:id/members", self.setTeamMember)
+		setting.DELETE("/teams/:id/members", self.delTeamMembers)
+		setting.POST("/teams/:id/accept", self.acceptTeam)
 	}
 
 	//admin
@@ -269,6 +849,42 @@ func (self *WebServer) Run() error {
 		admin.PUT("/user", self.addUser)
 		admin.POST("/user", self.setUser)
 		admin.GET("/user/list", self.userList)
+		admin.POST("/user/logout", self.logoutUsers)
+
+		admin.GET("/tcp", self.getTcpPorts)
+		admin.PUT("/tcp", self.addTcpPort)
+		admin.DELETE("/tcp", self.delTcpPorts)
+
+		admin.GET("/mail", self.getMailServer)
+		admin.POST("/mail", self.setMailServer)
+		admin.POST("/mail/test", self.testMailServer)
+
+		admin.GET("/invites", self.getInvites)
+		admin.PUT("/invites", self.addInvite)
+		admin.DELETE("/invites", self.delInvites)
+
+		admin.GET("/ldap", self.verifySuperPermission, self.getLdapAuth)
+		admin.POST("/ldap", self.verifySuperPermission, self.setLdapAuth)
+		admin.POST("/ldap/test", self.verifySuperPermission, self.testLdapAuth)
+
+		admin.GET("/ipfilters", self.getGlobalIpFilters)
+		admin.PUT("/ipfilters", self.addGlobalIpFilter)
+		admin.DELETE("/ipfilters", self.delGlobalIpFilters)
+
+		admin.GET("/queue", self.getQueueStats)
+
+		admin.GET("/retention", self.getRetention)
+		admin.POST("/retention", self.setRetention)
+
+		admin.POST("/reload", self.verifySuperPermission, self.reloadSettings)
+		admin.GET("/audit", self.getAuditLog)
+		admin.GET("/audit/export", self.exportAuditLog)
+
+		admin.GET("/password", self.getPasswordHash)
+		admin.POST("/password", self.setPasswordHash)
+
+		admin.GET("/backup", self.verifySuperPermission, self.getBackup)
+		admin.POST("/restore", self.verifySuperPermission, self.restoreBackup)
 	}
 
 	//record handler
@@ -276,34 +892,126 @@ func (self *WebServer) Run() error {
 	{
 		dataApi.GET("/dns", self.queryDnsRecord)
 		dataApi.GET("/http", self.queryHttpRecord)
+		dataApi.GET("/http/:id/body", self.queryHttpRecordBody)
+		dataApi.GET("/http/:id/frames", self.queryHttpFrames)
+		dataApi.GET("/smtp", self.querySmtpRecord)
+		dataApi.GET("/ldap", self.queryLdapRecord)
+		dataApi.GET("/ftp", self.queryFtpRecord)
+		dataApi.GET("/tcp", self.queryTcpRecord)
+		dataApi.GET("/icmp", self.queryIcmpRecord)
+		dataApi.GET("/smb", self.querySmbRecord)
+		dataApi.GET("/rmi", self.queryRmiRecord)
+		dataApi.GET("/session/:token", self.querySessionRecord)
+		dataApi.GET("/exists/:type", self.queryRecordExists)
+		dataApi.HEAD("/exists/:type", self.queryRecordExists)
+		dataApi.GET("/realtime", self.queryRealtime)
+		dataApi.GET("/stream", self.queryStream)
+		dataApi.GET("/wait", self.queryWait)
+		dataApi.POST("/relay", self.relayRecord)
 	}
 	//http log
+	r.Any("/log/:shortId", self.record)
 	r.Any("/log/:shortId/*any", self.record)
 
-	payload := r.Group("/payload")
+	r.GET("/burpresults", self.burpResults)
+	r.POST("/register", self.interactshRegister)
+	r.POST("/deregister", self.interactshDeregister)
+	r.GET("/poll", self.interactshPoll)
+
+	payload := r.Group("/payload", self.payloadLog)
 	{
 		payload.GET("/xss", self.xss)
 		payload.GET("/phprfi", self.phpRFI)
+		payload.Any("/redirect", self.redirect)
+		payload.GET("/xxe/oob.dtd", self.xxeOob)
+		payload.GET("/xxe/error.dtd", self.xxeError)
+		payload.GET("/xxe/payload.xml", self.xxeDocument)
+		payload.GET("/jndi", self.jndi)
+		payload.Any("/cors", self.cors)
+		payload.GET("/jsonp", self.jsonp)
+		payload.GET("/files/:shortId/:name", self.payloadFile)
+	}
+
+	//listen tcp ports configured by admin
+	if self.TcpPorts != nil {
+		if _, err := self.loadTcpPorts(); err != nil {
+			logrus.Errorf("[webserver.go::Run] loadTcpPorts: %v", err)
+		}
 	}
 
+	//enable h2 over cleartext on the plain listener
+	h2s := &http2.Server{}
 	s := &http.Server{
-		Handler: r,
+		Handler: h2c.NewHandler(r, h2s),
 	}
 	l, err := net.Listen("tcp", self.Listen)
 	if err != nil {
 		return err
 	}
 	self.s = s
-	return s.Serve(l)
-}
 
-func (self *WebServer) Shutdown(ctx context.Context) error {
-	err := self.s.Shutdown(ctx)
-	//important: stop input then call shutdown
+	if self.HttpsListen != "" {
+		ts := &http.Server{
+			Handler: r,
+			TLSConfig: &tls.Config{
+				GetCertificate:     self.GetCertificate,
+				GetConfigForClient: self.getConfigForClient,
+			},
+		}
+		err = http2.ConfigureServer(ts, h2s)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		tl, err := net.Listen("tcp", self.HttpsListen)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		self.ts = ts
+		go func() {
+			err := ts.ServeTLS(&helloListener{self.TrustedProxies.Listener(tl), &self.hellos}, "", "")
+			if err != http.ErrServerClosed {
+				logrus.Errorf("[webserver.go::Run] ServeTLS: %v", err)
+			}
+		}()
+	}
+	if self.GrpcListen != "" {
+		gl, err := net.Listen("tcp", self.GrpcListen)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		self.gs = self.newGrpcServer()
+		go func() {
+			err := self.gs.Serve(gl)
+			if err != nil {
+				logrus.Errorf("[webserver.go::Run] grpc Serve: %v", err)
+			}
+		}()
+	}
+	if self.PprofListen != "" {
+		pl, err := net.Listen("tcp", self.PprofListen)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		self.ps = newPprofServer()
+		go func() {
+			err := self.ps.Serve(pl)
+			if err != http.ErrServerClosed {
+				logrus.Errorf("[webserver.go::Run] pprof Serve: %v", err)
+			}
+		}()
+	}
+	return s.Serve(self.TrustedProxies.Listener(l))
+}
 
-	<-self.storeQuit
-	self.orm.Close()
-	return err
+// Shutdown stop listeners, store routine and workers in stages until ctx is done, then close database
+func (self *WebServer) Shutdown(ctx context.Context) {
+	l := NewLifecycle()
+	self.AddStops(l)
+	l.Shutdown(ctx)
 }
 
 func (self *WebServer) IsDuplicate(err error) bool {
@@ -316,7 +1024,7 @@ func (self *WebServer) IsDuplicate(err error) bool {
 	case "sqlite3":
 		e, ok := err.(sqlite3.Error)
 		if !ok {
-			logrus.Printf("[IsDuplicate] convert sqlite error: typeof(err)")
+			logrus.Warnf("[webserver.go::IsDuplicate] convert sqlite error: %T", err)
 		}
 		if e.Code == sqlite3.ErrConstraint {
 			return true
@@ -326,10 +1034,25 @@ func (self *WebServer) IsDuplicate(err error) bool {
 		if e.Number == 1062 || e.Number == 1169 || e.Number == 1022 {
 			return true
 		}
+	case "postgres":
+		//unique_violation
+		if e, ok := err.(*pq.Error); ok && e.Code == "23505" {
+			return true
+		}
 	}
 	return false
 }
 
+// sqlTime is t as parameter compared with datetime column,
+// sqlite and postgres(timestamp without time zone) compare local time without timezone
+func (self *WebServer) sqlTime(t time.Time) time.Time {
+	switch self.orm.DriverName() {
+	case "sqlite3", "postgres":
+		return t.Local()
+	}
+	return t
+}
+
 func (self *WebServer) ResetPassword(user, password string) error {
 	if isWeakPass(password) {
 		return fmt.Errorf("Password(%v) too weak!", password)
@@ -339,9 +1062,9 @@ func (self *WebServer) ResetPassword(user, password string) error {
 	session := orm.NewSession()
 	defer session.Close()
 
-	_, err := session.Where(`role = ?`, roleSuper).Cols("pass").
+	_, err := session.Where(`role = ?`, roleSuper).Cols("pass").SetExpr(`token_version`, `token_version+1`).
 		Update(&models.TblUser{
-			Pass: makePassword(password),
+			Pass: makePassword(password, self.passwordHash()),
 		})
 	return err
 }
@@ -2,6 +2,9 @@ package server
 
 import (
 	"fmt"
+	"mime"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,7 +18,9 @@ import (
 )
 
 type MyClaims struct {
-	Seed string `json:"seed"`
+	Seed    string `json:"seed"`          //login session, empty of stateless jwt
+	Type    string `json:"typ,omitempty"` //access or refresh of stateless jwt
+	Version int64  `json:"ver,omitempty"` //token version of user of refresh token
 	jwt.StandardClaims
 }
 
@@ -32,6 +37,10 @@ func (self *WebServer) respData(c *gin.Context, status, code int,
 }
 
 func (self *WebServer) resp(c *gin.Context, status int, cr *CR) {
+	if status >= 500 {
+		//logged by accessLog
+		c.Set("error", cr.Message)
+	}
 	cr.Timestamp = time.Now().Unix()
 	c.JSON(status, cr)
 }
@@ -41,9 +50,21 @@ func (self *WebServer) initDatabase() error {
 	orm.SetTZDatabase(time.Local)
 	orm.SetTZLocation(time.Local)
 
-	err := orm.Sync(&models.TblDns{}, &models.TblHttp{}, &models.TblUser{})
+	err := newMigrate(orm).Migrate()
+	if err != nil {
+		logrus.Errorf("[webui.go::initDatabase] Migrate: %v", err)
+		return err
+	}
+	if self.ClickHouse != nil {
+		err = self.ClickHouse.Sync(orm, &models.TblDns{}, &models.TblHttp{})
+		if err != nil {
+			logrus.Errorf("[webui.go::initDatabase] ClickHouse.Sync: %v", err)
+			return err
+		}
+	}
+	err = self.initSearch()
 	if err != nil {
-		logrus.Errorf("[webui.go::initDatabase] orm.Sync: %v", err)
+		logrus.Errorf("[webui.go::initDatabase] initSearch: %v", err)
 		return err
 	}
 	count, err := orm.Count(&models.TblUser{})
@@ -54,14 +75,16 @@ func (self *WebServer) initDatabase() error {
 	if count == 0 {
 		randomPass := genRandomString(12)
 		_, err = orm.InsertOne(&models.TblUser{
-			Name:          "admin",
-			Email:         "admin@godnslog.com",
-			ShortId:       genShortId(),
-			Pass:          makePassword(randomPass),
-			Token:         genRandomToken(),
-			Role:          roleSuper,
-			Lang:          self.DefaultLanguage,
-			CleanInterval: self.DefaultCleanInterval,
+			Name:    "admin",
+			Email:   "admin@godnslog.com",
+			ShortId: genShortId(),
+			Pass:    makePassword(randomPass, self.passwordHash()),
+			Token:   genRandomToken(),
+			Role:    roleSuper,
+
+			CallbackSecret: genRandomString(CALLBACK_SECRET_LEN),
+			Lang:           self.DefaultLanguage,
+			CleanInterval:  self.DefaultCleanInterval,
 		})
 		if err != nil {
 			logrus.Errorf("[webui.go::initDatabase] orm.InsertOne(user): %v", err)
@@ -70,6 +93,19 @@ func (self *WebServer) initDatabase() error {
 		fmt.Printf("Init super admin user with password: %v\n", randomPass)
 	}
 
+	err = self.initCallbackSecrets()
+	if err != nil {
+		logrus.Errorf("[webui.go::initDatabase] initCallbackSecrets: %v", err)
+		return err
+	}
+
+	self.loadCache()
+	return nil
+}
+
+// loadCache cache users, interactsh registrations and custom domains of database
+func (self *WebServer) loadCache() {
+	orm := self.orm
 	store := self.store
 	//sync user
 	orm.Iterate(new(models.TblUser), func(idx int, bean interface{}) error {
@@ -80,11 +116,17 @@ func (self *WebServer) initDatabase() error {
 		store.Set(domainKey, user, cache.NoExpiration)
 		return nil
 	})
-
-	return nil
+	//sync interactsh registrations
+	orm.Iterate(new(models.TblInteractsh), func(idx int, bean interface{}) error {
+		reg := bean.(*models.TblInteractsh)
+		store.Set(reg.CorrelationId+".interactsh", reg, cache.NoExpiration)
+		return nil
+	})
+	self.loadCustomDomains()
 }
 
 func (self *WebServer) authHandler(c *gin.Context) {
+	defer self.rateLimitUser(c, "id")
 	tokenString := c.GetHeader("Access-Token")
 	if tokenString == "" {
 		c.JSON(401, CR{
@@ -94,26 +136,27 @@ func (self *WebServer) authHandler(c *gin.Context) {
 		c.Abort()
 		return
 	}
+	//scoped api token, not jwt
+	if strings.Count(tokenString, ".") != 2 {
+		self.tokenAuth(c, tokenString)
+		return
+	}
 	var claim MyClaims
 	token, err := jwt.ParseWithClaims(tokenString, &claim, func(token *jwt.Token) (interface{}, error) {
 		// since we only use the one private key to sign the tokens,
 		// we also only use its public counter part to verify
-		return []byte(self.verifyKey), nil
+		return self.signKey(), nil
 	})
-	if token.Valid {
+	if token.Valid && claim.Seed == "" {
+		self.statelessAuth(c, &claim)
+		return
+	} else if token.Valid {
 		store := self.store
-		key := fmt.Sprintf("%v.seed", claim.Id)
-		realSeed, exist := store.Get(key)
-		if !exist {
-			logrus.Infof("That's not even a token")
-			c.JSON(401, CR{
-				Message: "not login",
-				Code:    CodeNoAuth,
-			})
-			c.Abort()
-			return
-		} else if realSeed.(string) != claim.Seed {
-			logrus.Infof("That's not even a token")
+		var uid int64
+		fmt.Sscanf(claim.Id, "%d", &uid)
+		login := self.getLogin(claim.Seed)
+		if login == nil || login.Uid != uid {
+			logrus.Infof("[webui.go::authHandler] session of user(id=%v) revoked or expired", claim.Id)
 			c.JSON(401, CR{
 				Message: "Token Expire",
 				Code:    CodeNoAuth,
@@ -130,10 +173,16 @@ func (self *WebServer) authHandler(c *gin.Context) {
 			})
 			c.Abort()
 			return
+		} else if u.(*models.TblUser).Disabled {
+			c.JSON(401, CR{
+				Message: "user disabled",
+				Code:    CodeNoAuth,
+			})
+			c.Abort()
+			return
 		}
+		self.touchLogin(claim.Seed, login)
 
-		var uid int64
-		fmt.Sscanf(claim.Id, "%d", &uid)
 		c.Set("id", uid)
 		c.Set("username", claim.Audience)
 		c.Set("email", claim.Subject)
@@ -172,6 +221,16 @@ func (self *WebServer) authHandler(c *gin.Context) {
 	}
 }
 
+func (self *WebServer) verifySuperPermission(c *gin.Context) {
+	if c.GetInt("role") != roleSuper {
+		self.resp(c, 403, &CR{
+			Message: "bad permission",
+			Code:    CodeNoPermission,
+		})
+		c.Abort()
+	}
+}
+
 func (self *WebServer) verifyAdminPermission(c *gin.Context) {
 	role := c.GetInt("role")
 	switch role {
@@ -212,6 +271,11 @@ func (self *WebServer) userLogin(c *gin.Context) {
 		})
 		return
 	}
+	if req.Username != "" {
+		c.Set(AUDIT_NAME_KEY, req.Username)
+	} else {
+		c.Set(AUDIT_NAME_KEY, req.Email)
+	}
 	session := self.orm.NewSession()
 	defer session.Close()
 	var user = new(models.TblUser)
@@ -222,23 +286,108 @@ func (self *WebServer) userLogin(c *gin.Context) {
 		logrus.Errorf("[webui.go::userLogin] orm.Get: %v", err)
 		self.respData(c, 502, CodeServerInternal, "bad service", nil)
 		return
-	} else if !exist {
-		logrus.Infof("[webui.go::userLogin] not found: %v", req)
-		self.respData(c, 401, CodeBadData, "bad request", nil)
-		return
 	}
-	err = comparePassword(req.Password, user.Pass)
-	if err != nil {
+	//users of directory accounts, or unknown names with ldap login
+	if !exist || user.LdapDn != "" {
+		var local *models.TblUser
+		if exist {
+			local = user
+		}
+		name := req.Username
+		if name == "" {
+			name = req.Email
+		}
+		user, err = self.ldapLogin(local, name, req.Password)
+		if err != nil {
+			logrus.Infof("[webui.go::userLogin] ldapLogin of %v: %v", name, err)
+			self.respData(c, 401, CodeBadData, "bad request", nil)
+			return
+		}
+	} else if err = comparePassword(req.Password, user.Pass); err != nil {
 		logrus.Infof("[webui.go::userLogin] password not match")
 		self.respData(c, 401, CodeBadData, "bad request", nil)
 		return
 	}
+	if user.Disabled {
+		logrus.Infof("[webui.go::userLogin] user %v disabled", user.Name)
+		self.respData(c, 403, CodeNoPermission, "user disabled", nil)
+		return
+	}
+	if !self.loginSecondFactor(c, user, &req) {
+		return
+	}
+	if user.LdapDn == "" {
+		self.rehashPassword(user, req.Password)
+	}
+	if req.Stateless {
+		self.issueStatelessToken(c, user)
+		return
+	}
+	self.issueToken(c, user)
+}
+
+// loginSecondFactor check totp code or webauthn assertion of users with them, options are returned if both are missing
+func (self *WebServer) loginSecondFactor(c *gin.Context, user *models.TblUser, req *LoginRequest) bool {
+	passkeys, err := self.orm.Where(`uid=?`, user.Id).Count(&models.TblWebauthn{})
+	if err != nil {
+		logrus.Errorf("[webui.go::loginSecondFactor] orm.Count: %v", err)
+		self.respData(c, 502, CodeServerInternal, "bad service", nil)
+		return false
+	}
+	if user.TotpSecret == "" && passkeys == 0 {
+		return true
+	}
+
+	if req.Webauthn != nil && passkeys > 0 {
+		if _, err := self.verifyAssertion(req.Webauthn, user.Id, webauthnMfa); err != nil {
+			logrus.Infof("[webui.go::loginSecondFactor] verifyAssertion of %v: %v", user.Name, err)
+			self.respData(c, 401, CodeBadData, "bad request", nil)
+			return false
+		}
+		return true
+	}
+	if req.Code != "" && user.TotpSecret != "" {
+		ok, err := self.verifySecondFactor(user, req.Code)
+		if err != nil {
+			logrus.Errorf("[webui.go::loginSecondFactor] verifySecondFactor: %v", err)
+			self.respData(c, 502, CodeServerInternal, "bad service", nil)
+			return false
+		} else if !ok {
+			logrus.Infof("[webui.go::loginSecondFactor] two-factor code of %v not match", user.Name)
+			self.respData(c, 401, CodeBadData, "bad request", nil)
+			return false
+		}
+		return true
+	}
 
+	var factor models.SecondFactor
+	if user.TotpSecret != "" {
+		factor.Methods = append(factor.Methods, "totp")
+	}
+	if passkeys > 0 {
+		factor.Methods = append(factor.Methods, "webauthn")
+		factor.Webauthn, err = self.webauthnRequestOptions(c, user.Id, webauthnMfa)
+		if err != nil {
+			logrus.Errorf("[webui.go::loginSecondFactor] webauthnRequestOptions: %v", err)
+			self.respData(c, 502, CodeServerInternal, "bad service", nil)
+			return false
+		}
+	}
+	self.resp(c, 401, &CR{
+		Message: "two-factor code required",
+		Code:    CodeTotpRequired,
+		Result:  factor,
+	})
+	return false
+}
+
+// newToken sign a jwt token of user, seed is id of a new login session
+func (self *WebServer) newToken(c *gin.Context, user *models.TblUser) (string, error) {
 	now := time.Now()
 	seed := getSecuritySeed()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS384, MyClaims{
-		seed,
-		jwt.StandardClaims{
+		Seed: seed,
+		StandardClaims: jwt.StandardClaims{
 			Id:        fmt.Sprintf("%v", user.Id),
 			Audience:  user.Name,
 			Subject:   user.Email,
@@ -248,17 +397,27 @@ func (self *WebServer) userLogin(c *gin.Context) {
 		},
 	})
 
-	tokenString, err := token.SignedString([]byte(self.verifyKey))
+	tokenString, err := token.SignedString(self.signKey())
+	if err != nil {
+		return "", err
+	}
+	if err = self.newLogin(c, user.Id, seed); err != nil {
+		return "", err
+	}
+	self.store.Set(fmt.Sprintf("%v.user", user.Id), user, cache.NoExpiration)
+	return tokenString, nil
+}
+
+// issueToken login user with a new jwt token
+func (self *WebServer) issueToken(c *gin.Context, user *models.TblUser) {
+	auditUser(c, user)
+	tokenString, err := self.newToken(c, user)
 	if err != nil {
-		logrus.Errorf("[webui.go::userLogin] token.SignedString: %v", err)
+		logrus.Errorf("[webui.go::issueToken] newToken: %v", err)
 
 		self.respData(c, 502, CodeServerInternal, "bad service", nil)
 		return
 	}
-	store := self.store
-
-	store.Set(fmt.Sprintf("%v.seed", user.Id), seed, self.AuthExpire)
-	store.Set(fmt.Sprintf("%v.user", user.Id), user, cache.NoExpiration)
 
 	self.resp(c, 200, &CR{
 		Message: "OK",
@@ -280,10 +439,14 @@ func (self *WebServer) userLogin(c *gin.Context) {
 // @Failure 401 {object} CR "Unauthorized"
 // @Router /user/logout [post]
 func (self *WebServer) userLogout(c *gin.Context) {
-	store := self.store
-	id := c.GetInt64("id")
-	store.Delete(fmt.Sprintf("%v.seed", id))
-	store.Delete(fmt.Sprintf("%v.user", id))
+	//other sessions of user are kept, refresh tokens are denied on logout by an access token
+	if seed := c.GetString("seed"); seed == "" {
+		if err := self.bumpTokenVersion(c.GetInt64("id")); err != nil {
+			logrus.Errorf("[webui.go::userLogout] bumpTokenVersion: %v", err)
+		}
+	} else if err := self.revokeLogin(seed); err != nil {
+		logrus.Errorf("[webui.go::userLogout] revokeLogin: %v", err)
+	}
 	self.resp(c, 200, &CR{
 		Message: "OK",
 	})
@@ -339,12 +502,12 @@ func (self *WebServer) userInfo(c *gin.Context) {
 	role.Name = "用户"
 	role.Permissions = []models.Permission{
 		models.Permission{
-			RoleId:         roleNormal,
+			RoleId:         roleOperator,
 			PermissionId:   "document",
 			PermissionName: "文档",
 		},
 		models.Permission{
-			RoleId:         roleNormal,
+			RoleId:         roleOperator,
 			PermissionId:   "record",
 			PermissionName: "记录",
 		},
@@ -355,7 +518,7 @@ func (self *WebServer) userInfo(c *gin.Context) {
 		role.Name = "管理员"
 		role.Permissions = append(role.Permissions, []models.Permission{
 			models.Permission{
-				RoleId:         roleNormal,
+				RoleId:         roleOperator,
 				PermissionId:   "setting",
 				PermissionName: "设置",
 			},
@@ -366,9 +529,14 @@ func (self *WebServer) userInfo(c *gin.Context) {
 			},
 		}...)
 
+	case roleViewer:
+		//security of own account only
+		role.Id = "viewer"
+		role.Name = "访客"
+
 	default:
 		role.Permissions = append(role.Permissions, models.Permission{
-			RoleId:         roleNormal,
+			RoleId:         roleOperator,
 			PermissionId:   "setting",
 			PermissionName: "设置",
 		})
@@ -433,6 +601,8 @@ func (self *WebServer) userList(c *gin.Context) {
 		rcd.Id = item.Id
 		rcd.Name = item.Name
 		rcd.Email = item.Email
+		rcd.Role = models.Role{Id: RoleName(item.Role), Name: RoleName(item.Role)}
+		rcd.Disabled = item.Disabled
 		rcd.Utime = item.Utime
 		//TODO: others...
 	}
@@ -499,12 +669,59 @@ func (self *WebServer) delUser(c *gin.Context) {
 		})
 		return
 	}
-	session.In("uid", ids).Delete(&models.TblDns{})
-	session.In("uid", ids).Delete(&models.TblHttp{})
+	self.deleteHits(session.In("uid", ids), &models.TblDns{})
+	self.deleteHits(session.In("uid", ids), &models.TblHttp{})
+	session.In("uid", ids...).Delete(&models.TblSmtp{})
+	session.In("uid", ids...).Delete(&models.TblLdap{})
+	session.In("uid", ids...).Delete(&models.TblFtp{})
+	session.In("uid", ids...).Delete(&models.TblTcp{})
+	session.In("uid", ids...).Delete(&models.TblIcmp{})
+	session.In("uid", ids...).Delete(&models.TblSmb{})
+	session.In("uid", ids...).Delete(&models.TblRmi{})
+	session.In("uid", ids...).Delete(&models.TblRollup{})
+	session.In("uid", ids...).Delete(&models.TblBurpClient{})
+	session.In("uid", ids...).Delete(&models.TblInteractsh{})
+	session.In("uid", ids...).Delete(&models.TblToken{})
+	session.In("uid", ids...).Delete(&models.TblWebhook{})
+	session.In("uid", ids...).Delete(&models.TblAlertRule{})
+	session.In("uid", ids...).Delete(&models.TblIpFilter{})
+	session.In("uid", ids...).Delete(&models.TblDelivery{})
+	session.In("uid", ids...).Delete(&models.TblHttpRule{})
+	session.In("uid", ids...).Delete(&models.TblWebauthn{})
+	self.deleteCustomDomains(ids...)
+
+	var files []models.TblPayloadFile
+	session.In("uid", ids...).Find(&files)
+	for i := 0; i < len(files); i++ {
+		self.blob.Delete(files[i].Blob)
+	}
+	session.In("uid", ids...).Delete(&models.TblPayloadFile{})
+
+	//teams of deleted owners are deleted, other members keep their records
+	var teams []models.TblTeam
+	session.In("uid", ids...).Cols("id").Find(&teams)
+	tids := make([]int64, len(teams))
+	for i := 0; i < len(teams); i++ {
+		tids[i] = teams[i].Id
+	}
+	var members []int64
+	if len(tids) > 0 {
+		members = self.teamMemberUids(tids...)
+		self.deleteTeams(tids...)
+	}
+	var memberships []models.TblTeamMember
+	session.In("uid", ids...).Cols("tid").Find(&memberships)
+	for i := 0; i < len(memberships); i++ {
+		members = append(members, self.teamMemberUids(memberships[i].Tid)...)
+	}
+	session.In("uid", ids...).Delete(&models.TblTeamMember{})
+	self.resetTeamAccess(members...)
+
+	//logout these users
+	self.logoutUser(req.Ids...)
 
 	cache := self.store
 	for i := 0; i < len(req.Ids); i++ {
-		seedKey := fmt.Sprintf("%v.seed", req.Ids[i])
 		userKey := fmt.Sprintf("%v.user", req.Ids[i])
 		v, exist := cache.Get(userKey)
 		if exist {
@@ -512,9 +729,8 @@ func (self *WebServer) delUser(c *gin.Context) {
 			cache.Delete(domainKey)
 		}
 
-		//logout these users
-		cache.Delete(seedKey)
 		cache.Delete(userKey)
+		cache.Delete(fmt.Sprintf("%v.httprules", req.Ids[i]))
 	}
 
 	self.resp(c, 200, &CR{
@@ -533,6 +749,7 @@ func (self *WebServer) addUser(c *gin.Context) {
 		})
 		return
 	}
+	auditUserRequest(c, &req)
 
 	if isWeakPass(req.Password) {
 		self.resp(c, 400, &CR{
@@ -541,20 +758,31 @@ func (self *WebServer) addUser(c *gin.Context) {
 		})
 		return
 	}
+	if req.Role == 0 {
+		req.Role = roleOperator
+	} else if !validRole(req.Role, c.GetInt("role")) {
+		self.resp(c, 403, &CR{
+			Message: "bad permission of role",
+			Code:    CodeNoPermission,
+		})
+		return
+	}
 
 	//random api Token
 	session := self.orm.NewSession()
 	defer session.Close()
 
 	var item = models.TblUser{
-		Name:          req.Name,
-		Email:         req.Email,
-		Role:          roleNormal,
-		Token:         genRandomToken(),
-		ShortId:       genShortId(),
-		Lang:          self.DefaultLanguage,
-		Pass:          makePassword(req.Password),
-		CleanInterval: self.DefaultCleanInterval,
+		Name:    req.Name,
+		Email:   req.Email,
+		Role:    req.Role,
+		Token:   genRandomToken(),
+		ShortId: genShortId(),
+
+		CallbackSecret: genRandomString(CALLBACK_SECRET_LEN),
+		Lang:           self.DefaultLanguage,
+		Pass:           makePassword(req.Password, self.passwordHash()),
+		CleanInterval:  self.DefaultCleanInterval,
 	}
 	_, err = session.InsertOne(&item)
 	if self.IsDuplicate(err) {
@@ -575,6 +803,22 @@ func (self *WebServer) addUser(c *gin.Context) {
 	})
 }
 
+// newExternalUser of single sign-on or ldap account, local password is random until reset by admin
+func (self *WebServer) newExternalUser(name, email string, role int) *models.TblUser {
+	return &models.TblUser{
+		Name:    name,
+		Email:   email,
+		Role:    role,
+		Token:   genRandomToken(),
+		ShortId: genShortId(),
+
+		CallbackSecret: genRandomString(CALLBACK_SECRET_LEN),
+		Lang:           self.DefaultLanguage,
+		Pass:           makePassword(genRandomToken(), self.passwordHash()),
+		CleanInterval:  self.DefaultCleanInterval,
+	}
+}
+
 func (self *WebServer) setUser(c *gin.Context) {
 	var req UserRequest
 	err := c.ShouldBindJSON(&req)
@@ -586,6 +830,7 @@ func (self *WebServer) setUser(c *gin.Context) {
 		})
 		return
 	}
+	auditUserRequest(c, &req)
 	if req.Id < 1 {
 		self.resp(c, 400, &CR{
 			Message: "Can't change",
@@ -605,10 +850,33 @@ func (self *WebServer) setUser(c *gin.Context) {
 	switch role {
 	case roleSuper, roleAdmin:
 		//change other user
+		if req.Role != 0 {
+			//role of super admin is never changed, admins are granted or revoked by super admin
+			target := new(models.TblUser)
+			exist, err := self.orm.ID(req.Id).Cols("role").Get(target)
+			if err != nil {
+				logrus.Errorf("[webapi.go::setUser] orm.Get error: %v", err)
+				self.resp(c, 502, &CR{
+					Message: "failed",
+					Code:    CodeServerInternal,
+				})
+				return
+			} else if !exist || target.Role == roleSuper || !validRole(req.Role, role) ||
+				(target.Role == roleAdmin && role != roleSuper) {
+				self.resp(c, 403, &CR{
+					Message: "bad permission of role",
+					Code:    CodeNoPermission,
+				})
+				return
+			}
+		}
 		session = session.ID(req.Id)
+		if req.Role != 0 {
+			session = session.SetExpr(`role`, req.Role)
+		}
 		if req.Password != "" {
-			newPass := makePassword(req.Password)
-			session = session.SetExpr(`pass`, customQuote(newPass))
+			newPass := makePassword(req.Password, self.passwordHash())
+			session = session.SetExpr(`pass`, customQuote(newPass)).SetExpr(`token_version`, `token_version+1`)
 		}
 		if req.Language != "" {
 			session = session.SetExpr(`lang`, customQuote(req.Language))
@@ -619,6 +887,15 @@ func (self *WebServer) setUser(c *gin.Context) {
 		if req.Name != "" {
 			session = session.SetExpr(`name`, customQuote(req.Name))
 		}
+		if req.PayloadQuota > 0 {
+			session = session.SetExpr(`payload_quota`, req.PayloadQuota)
+		}
+		if req.RecordQuota > 0 || req.RecordQuota == -1 {
+			session = session.SetExpr(`record_quota`, req.RecordQuota)
+		}
+		if req.Disabled != nil {
+			session = session.SetExpr(`disabled`, *req.Disabled)
+		}
 
 		_, err = session.Update(&models.TblUser{})
 		if err != nil {
@@ -631,15 +908,22 @@ func (self *WebServer) setUser(c *gin.Context) {
 			return
 		}
 
+		if req.Disabled != nil {
+			//api of host is by cached user
+			user = new(models.TblUser)
+			if exist, _ := self.orm.ID(req.Id).Get(user); exist {
+				store.Set(fmt.Sprintf("%v.suser", user.ShortId), user, cache.NoExpiration)
+			}
+		}
+
 		//logout req.Id
-		cache := self.store
-		cache.Delete(fmt.Sprintf("%v.seed", req.Id))
-		cache.Delete(fmt.Sprintf("%v.user", req.Id))
+		self.logoutUser(req.Id)
+		self.store.Delete(fmt.Sprintf("%v.user", req.Id))
 		self.resp(c, 200, &CR{
 			Message: "OK",
 		})
 
-	case roleNormal:
+	case roleOperator:
 		//allow change language only
 		userKey := fmt.Sprintf("%v.user", id)
 		v, exist := store.Get(userKey)
@@ -719,13 +1003,37 @@ func (self *WebServer) getAppSetting(c *gin.Context) {
 	} else {
 		user = v.(*models.TblUser)
 	}
+	max, err := self.retentionMax()
+	if err != nil {
+		logrus.Errorf("[webui.go::getAppSetting] retentionMax: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
 
 	self.resp(c, 200, &CR{
 		Message: "OK",
 		Result: AppSetting{
-			Rebind:    user.Rebind,
-			Callback:  user.Callback,
-			CleanHour: user.CleanInterval / 3600,
+			Rebind:      user.Rebind,
+			Callback:    user.Callback,
+			CleanHour:   user.CleanInterval / 3600,
+			MaxBodySize: self.maxBodySize(user),
+
+			CallbackTemplate: user.CallbackTemplate,
+			CallbackSecret:   user.CallbackSecret,
+
+			MailAlert:  user.MailAlert,
+			Digest:     user.Digest,
+			DigestHour: user.DigestHour,
+
+			DedupWindow:  user.DedupWindow,
+			HideScanners: user.HideScanners,
+
+			Retention:    toHours(user.Retention),
+			RetentionMax: toHours(max),
+			RecordQuota:  self.recordQuota(user),
 		},
 	})
 }
@@ -742,6 +1050,76 @@ func (self *WebServer) setAppSetting(c *gin.Context) {
 		return
 	}
 
+	if req.MaxBodySize < 0 || req.MaxBodySize > MAX_BODY_SIZE {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("maxBodySize should be in [0, %v]", MAX_BODY_SIZE),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.MailAlert < MAIL_ALERT_OFF || req.MailAlert > MAIL_ALERT_FIRST {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("mailAlert should be in [%v, %v]", MAIL_ALERT_OFF, MAIL_ALERT_FIRST),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.Digest < DIGEST_OFF || req.Digest > DIGEST_WEEKLY || req.DigestHour < 0 || req.DigestHour > 23 {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("digest should be in [%v, %v], digestHour should be in [0, 23]", DIGEST_OFF, DIGEST_WEEKLY),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if req.DedupWindow < 0 || req.DedupWindow > MAX_DEDUP_WINDOW {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("dedupWindow should be in [0, %v]", MAX_DEDUP_WINDOW),
+			Code:    CodeBadData,
+		})
+		return
+	}
+	if len(req.CallbackTemplate) > MAX_CALLBACK_TEMPLATE {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("callbackTemplate should be at most %v bytes", MAX_CALLBACK_TEMPLATE),
+			Code:    CodeBadData,
+		})
+		return
+	} else if _, err = parseCallbackTemplate(req.CallbackTemplate); err != nil {
+		self.resp(c, 400, &CR{
+			Message: fmt.Sprintf("callbackTemplate: %v", err),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	max, err := self.retentionMax()
+	if err != nil {
+		logrus.Errorf("[webui.go::setAppSetting] retentionMax: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	keep := make(map[string]int64, len(req.Retention))
+	for typ, hours := range req.Retention {
+		if !isSearchType(typ) || hours < 0 {
+			self.resp(c, 400, &CR{
+				Message: fmt.Sprintf("invalid retention of %v, types are %v, hours should not be negative", typ, searchTypes),
+				Code:    CodeBadData,
+			})
+			return
+		} else if max[typ] > 0 && hours*3600 > max[typ] {
+			self.resp(c, 400, &CR{
+				Message: fmt.Sprintf("retention of %v should be at most %v hours", typ, max[typ]/3600),
+				Code:    CodeBadData,
+			})
+			return
+		} else if hours > 0 {
+			keep[typ] = hours * 3600
+		}
+	}
+
 	id := c.GetInt64("id")
 	store := self.store
 	userKey := fmt.Sprintf("%v.user", id)
@@ -780,8 +1158,21 @@ func (self *WebServer) setAppSetting(c *gin.Context) {
 	dupUser.Rebind = req.Rebind
 	dupUser.Callback = req.Callback
 	dupUser.CleanInterval = req.CleanHour * 3600
-
-	_, err = session.ID(id).Cols("rebind", "callback", "clean_iterval").Update(dupUser)
+	dupUser.MaxBodySize = req.MaxBodySize
+	dupUser.CallbackTemplate = req.CallbackTemplate
+	dupUser.MailAlert = req.MailAlert
+	if req.Digest != DIGEST_OFF && (user.Digest == DIGEST_OFF || user.DigestTime.IsZero()) {
+		//first digest covers records after enabled
+		dupUser.DigestTime = time.Now()
+	}
+	dupUser.Digest = req.Digest
+	dupUser.DigestHour = req.DigestHour
+	dupUser.DedupWindow = req.DedupWindow
+	dupUser.HideScanners = req.HideScanners
+	dupUser.Retention = keep
+
+	_, err = session.ID(id).Cols("rebind", "callback", "clean_interval", "max_body_size", "callback_template", "mail_alert",
+		"digest", "digest_hour", "digest_time", "dedup_window", "hide_scanners", "retention").Update(dupUser)
 	if err != nil {
 		logrus.Errorf("[webuig.go::setAppSetting] orm.Update error: %v", err)
 		self.resp(c, 502, &CR{
@@ -838,6 +1229,10 @@ func (self *WebServer) getSecuritySetting(c *gin.Context) {
 	} else {
 		user = v.(*models.TblUser)
 	}
+	passkeys, err := self.orm.Where(`uid=?`, user.Id).Count(&models.TblWebauthn{})
+	if err != nil {
+		logrus.Errorf("[webuig.go::getSecuritySetting] orm.Count: %v", err)
+	}
 
 	self.resp(c, 200, &CR{
 		Message: "OK",
@@ -845,11 +1240,16 @@ func (self *WebServer) getSecuritySetting(c *gin.Context) {
 			HttpAddr: fmt.Sprintf("http://%v/log/%v/", self.IP, user.ShortId),
 			DnsAddr:  user.ShortId + "." + self.Domain,
 			Token:    user.Token,
+
+			TelegramChatId: user.TelegramChatId,
+			Totp:           user.TotpSecret != "",
+			RecoveryCodes:  len(user.TotpRecovery),
+			Passkeys:       int(passkeys),
 		},
 	})
 }
 
-//change self password
+//change self password or telegram chat id
 func (self *WebServer) setSecuritySetting(c *gin.Context) {
 	var req AppSecuritySet
 	err := c.ShouldBindJSON(&req)
@@ -861,6 +1261,24 @@ func (self *WebServer) setSecuritySetting(c *gin.Context) {
 		})
 		return
 	}
+	if req.TelegramChatId != nil {
+		if !validTelegramChatId(*req.TelegramChatId) {
+			self.resp(c, 400, &CR{
+				Message: "invalid telegram chat id",
+				Code:    CodeBadData,
+			})
+			return
+		}
+		if !self.setTelegramChatId(c, *req.TelegramChatId) {
+			return
+		}
+		if req.Password == "" {
+			self.resp(c, 200, &CR{
+				Message: "OK",
+			})
+			return
+		}
+	}
 	if isWeakPass(req.Password) {
 		logrus.Warnf("[webuig.go::setSecuritySetting] weak password data")
 		self.resp(c, 400, &CR{
@@ -874,9 +1292,10 @@ func (self *WebServer) setSecuritySetting(c *gin.Context) {
 	session := self.orm.NewSession()
 	defer session.Close()
 
-	newPass := makePassword(req.Password)
+	newPass := makePassword(req.Password, self.passwordHash())
 	//logrus.Debugf("password:%v, hashpass=%v", req.Password, string(newPass))
-	_, err = session.ID(id).SetExpr(`pass`, customQuote(newPass)).Update(&models.TblUser{})
+	_, err = session.ID(id).SetExpr(`pass`, customQuote(newPass)).SetExpr(`token_version`, `token_version+1`).
+		Update(&models.TblUser{})
 	if err != nil {
 		sql, _ := session.LastSQL()
 		logrus.Errorf("[webuig.go::setSecuritySetting] orm.Update(%v), last SQL: %v", err, sql)
@@ -909,6 +1328,7 @@ func (self *WebServer) getDnsRecord(c *gin.Context) {
 	ip, ipExist := c.GetQuery("ip")
 	domain, domainExist := c.GetQuery("domain")
 	date, dateExist := c.GetQuery("date")
+	tag, tagExist := c.GetQuery("tag")
 
 	pageNo, pageNoErr := ginutils.GetQueryInt(c, "pageNo")
 	if pageNoErr != nil || pageNo <= 0 {
@@ -919,6 +1339,15 @@ func (self *WebServer) getDnsRecord(c *gin.Context) {
 		pageSize = 10
 	}
 
+	cur, err := parseCursor(c, pageSize)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
 	session := self.orm.NewSession()
 	defer session.Close()
 
@@ -928,7 +1357,7 @@ func (self *WebServer) getDnsRecord(c *gin.Context) {
 	case roleAdmin, roleSuper:
 		session = session.In("uid", 0, id)
 	default:
-		session = session.Where(`uid=?`, id)
+		session = session.In("uid", self.teamUids(id, false))
 	}
 
 	if domainExist {
@@ -939,15 +1368,21 @@ func (self *WebServer) getDnsRecord(c *gin.Context) {
 	}
 	if dateExist {
 		t, _ := time.Parse(time.RFC3339, strings.Trim(date, `"`))
-		if self.orm.DriverName() == "sqlite3" { //sqlite not support timezone
-			t = t.Local()
-		}
-		session = session.And(`ctime > ?`, t)
+		session = session.And(`ctime > ?`, self.sqlTime(t))
 		// fmt.Println("QUERYDATE=[", date, "] = ", t)
 	}
+	if tagExist {
+		session = tagCond(session, tag)
+	}
+	session = self.scannerCond(c, session, id)
 
 	var items []models.TblDns
-	count, err := session.Desc("id").Limit(pageSize, (pageNo-1)*pageSize).FindAndCount(&items)
+	var count int64
+	if cur != nil {
+		err = self.findHits(cur.after(session), &items, false, cur.limit, 0)
+	} else {
+		count, err = self.findAndCountHits(session, &items, pageSize, (pageNo-1)*pageSize)
+	}
 	if err != nil {
 		logrus.Errorf("[webui.go::getDnsRecord] orm.FindAndCount: %v", err)
 		self.resp(c, 502, &CR{
@@ -962,6 +1397,14 @@ func (self *WebServer) getDnsRecord(c *gin.Context) {
 	resp.PageSize = pageSize
 	resp.PageNo = pageNo
 	resp.TotalPage = (resp.TotalCount + (pageSize - 1)) / pageSize
+	if cur != nil {
+		resp.PageSize = cur.limit
+		resp.TotalPage = 0
+		resp.AfterId = cur.afterId
+		if len(items) > 0 {
+			resp.NextId = cur.next(len(items), items[len(items)-1].Id)
+		}
+	}
 	resp.Data = make([]models.DnsRecord, len(items))
 	for i := 0; i < len(items); i++ {
 		rcd := &resp.Data[i]
@@ -969,6 +1412,13 @@ func (self *WebServer) getDnsRecord(c *gin.Context) {
 		rcd.Id = item.Id
 		rcd.Domain = item.Domain
 		rcd.Ip = item.Ip
+		rcd.Qtype = item.Qtype
+		rcd.Hits = item.Hits
+		rcd.Ptr = item.Ptr
+		rcd.Scanner = item.Scanner
+		rcd.Geo = item.Geo
+		rcd.Tags = item.Tags
+		rcd.Note = item.Note
 		rcd.Ctime = item.Ctime
 	}
 
@@ -1002,6 +1452,14 @@ func (self *WebServer) delDnsRecord(c *gin.Context) {
 
 	session := self.orm.NewSession()
 	defer session.Close()
+	session, err = self.deleteFilter(session, &req)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
 
 	role := c.GetInt("role")
 	id := c.GetInt64("id")
@@ -1009,7 +1467,7 @@ func (self *WebServer) delDnsRecord(c *gin.Context) {
 	switch role {
 	case roleAdmin, roleSuper:
 		if len(req.Ids) == 0 {
-			_, err := session.In(`uid`, id, 0).Delete(&models.TblDns{})
+			n, err := self.deleteHits(session.In(`uid`, id, 0), &models.TblDns{})
 			if err != nil {
 				//TODO:
 				logrus.Errorf("[webui.go::delDnsRecord] orm.Delete: %v", err)
@@ -1021,6 +1479,7 @@ func (self *WebServer) delDnsRecord(c *gin.Context) {
 			}
 			self.resp(c, 200, &CR{
 				Message: "OK",
+				Result:  n,
 			})
 			return
 		} else {
@@ -1028,7 +1487,7 @@ func (self *WebServer) delDnsRecord(c *gin.Context) {
 			for i := 0; i < len(req.Ids); i++ {
 				params[i] = req.Ids[i]
 			}
-			_, err := session.In(`uid`, id, 0).In("id", params...).Delete(&models.TblDns{})
+			n, err := self.deleteHits(session.In(`uid`, id, 0).In("id", params...), &models.TblDns{})
 			if err != nil {
 				logrus.Errorf("[webui.go::delDnsRecord] orm.Delete: %v", err)
 				self.resp(c, 502, &CR{
@@ -1039,12 +1498,13 @@ func (self *WebServer) delDnsRecord(c *gin.Context) {
 			}
 			self.resp(c, 200, &CR{
 				Message: "OK",
+				Result:  n,
 			})
 			return
 		}
 	default:
 		if len(req.Ids) == 0 {
-			_, err := session.Where(`uid=?`, id).Delete(&models.TblDns{})
+			n, err := self.deleteHits(session.Where(`uid=?`, id), &models.TblDns{})
 			if err != nil {
 				logrus.Errorf("[webui.go::delDnsRecord] orm.Delete: %v", err)
 				self.resp(c, 502, &CR{
@@ -1055,6 +1515,7 @@ func (self *WebServer) delDnsRecord(c *gin.Context) {
 			}
 			self.resp(c, 200, &CR{
 				Message: "OK",
+				Result:  n,
 			})
 			return
 		} else {
@@ -1062,7 +1523,7 @@ func (self *WebServer) delDnsRecord(c *gin.Context) {
 			for i := 0; i < len(req.Ids); i++ {
 				params[i] = req.Ids[i]
 			}
-			_, err := session.Where(`uid=?`, id).In("id", params...).Delete(&models.TblDns{})
+			n, err := self.deleteHits(session.In("uid", self.teamUids(id, true)).In("id", params...), &models.TblDns{})
 			if err != nil {
 				logrus.Errorf("[webui.go::delDnsRecord] orm.Delete: %v", err)
 				self.resp(c, 502, &CR{
@@ -1073,6 +1534,7 @@ func (self *WebServer) delDnsRecord(c *gin.Context) {
 			}
 			self.resp(c, 200, &CR{
 				Message: "OK",
+				Result:  n,
 			})
 			return
 		}
@@ -1083,6 +1545,7 @@ func (self *WebServer) getHttpRecord(c *gin.Context) {
 	ip, ipExist := c.GetQuery("ip")
 	domain, domainExist := c.GetQuery("domain")
 	date, dateExist := c.GetQuery("date")
+	tag, tagExist := c.GetQuery("tag")
 
 	pageNo, pageNoErr := ginutils.GetQueryInt(c, "pageNo")
 	if pageNoErr != nil || pageNo <= 0 {
@@ -1097,6 +1560,15 @@ func (self *WebServer) getHttpRecord(c *gin.Context) {
 	data, dataExist := c.GetQuery("data")
 	method, methodExist := c.GetQuery("method")
 
+	cur, err := parseCursor(c, pageSize)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
+
 	session := self.orm.NewSession()
 	defer session.Close()
 
@@ -1106,21 +1578,18 @@ func (self *WebServer) getHttpRecord(c *gin.Context) {
 	case roleAdmin, roleSuper:
 		session = session.Where(`id>0`)
 	default:
-		session = session.Where(`uid=?`, id)
+		session = session.In("uid", self.teamUids(id, false))
 	}
 
 	if domainExist {
-		session = session.And(`domain like ?`, "%"+domain+"%")
+		session = session.And(`host like ?`, "%"+domain+"%")
 	}
 	if ipExist {
 		session = session.And(`ip like ?`, "%"+ip+"%")
 	}
 	if dateExist {
 		t, _ := time.Parse(time.RFC3339, strings.Trim(date, `"`))
-		if self.orm.DriverName() == "sqlite3" { //sqlite不支持时区
-			t = t.Local()
-		}
-		session = session.And(`ctime > ?`, t)
+		session = session.And(`ctime > ?`, self.sqlTime(t))
 	}
 	if ctypeExist {
 		session = session.And(`ctype like ?`, "%"+ctype+"%")
@@ -1131,9 +1600,18 @@ func (self *WebServer) getHttpRecord(c *gin.Context) {
 	if methodExist {
 		session = session.And(`method = ?`, method)
 	}
+	if tagExist {
+		session = tagCond(session, tag)
+	}
+	session = self.scannerCond(c, session, id)
 
 	var items []models.TblHttp
-	count, err := session.Desc("id").Limit(pageSize, (pageNo-1)*pageSize).FindAndCount(&items)
+	var count int64
+	if cur != nil {
+		err = self.findHits(cur.after(session), &items, false, cur.limit, 0)
+	} else {
+		count, err = self.findAndCountHits(session, &items, pageSize, (pageNo-1)*pageSize)
+	}
 	if err != nil {
 		//TODO:
 		logrus.Errorf("[webui.go::getHttpRecord] orm.FindAndCount: %v", err)
@@ -1149,6 +1627,14 @@ func (self *WebServer) getHttpRecord(c *gin.Context) {
 	resp.PageSize = pageSize
 	resp.PageNo = pageNo
 	resp.TotalPage = (resp.TotalCount + (pageSize - 1)) / pageSize
+	if cur != nil {
+		resp.PageSize = cur.limit
+		resp.TotalPage = 0
+		resp.AfterId = cur.afterId
+		if len(items) > 0 {
+			resp.NextId = cur.next(len(items), items[len(items)-1].Id)
+		}
+	}
 	resp.Data = make([]models.HttpRecord, len(items))
 
 	for i := 0; i < len(items); i++ {
@@ -1156,12 +1642,26 @@ func (self *WebServer) getHttpRecord(c *gin.Context) {
 		item := &items[i]
 		rcd.Id = item.Id
 		rcd.Path = item.Path
+		rcd.Host = item.Host
 		rcd.Ip = item.Ip
 		rcd.Ctime = item.Ctime
 		rcd.Ctype = item.Ctype
 		rcd.Data = item.Data
 		rcd.Method = item.Method
+		rcd.Proto = item.Proto
 		rcd.Ua = item.Ua
+		rcd.Size = item.Size
+		rcd.Truncated = item.Truncated
+		rcd.Sni = item.Sni
+		rcd.TlsVersion = item.TlsVersion
+		rcd.TlsCipher = item.TlsCipher
+		rcd.Ja3 = item.Ja3
+		rcd.Ja3Hash = item.Ja3Hash
+		rcd.Ptr = item.Ptr
+		rcd.Scanner = item.Scanner
+		rcd.Geo = item.Geo
+		rcd.Tags = item.Tags
+		rcd.Note = item.Note
 	}
 	self.resp(c, 200, &CR{
 		Message: "OK",
@@ -1169,21 +1669,168 @@ func (self *WebServer) getHttpRecord(c *gin.Context) {
 	})
 }
 
-func (self *WebServer) delHttpRecord(c *gin.Context) {
-	var req DeleteRecordRequest
-	err := c.ShouldBindJSON(&req)
-	if err != nil {
-		logrus.Errorf("[webui.go::delHttpRecord] orm.Delete: %v", err)
-		self.resp(c, 400, &CR{
-			Message: "invalid Param",
-			Code:    CodeServerInternal,
-			Error:   err,
-		})
+func (self *WebServer) getHttpRecordBody(c *gin.Context) {
+	rcd, ok := self.visibleHttpRecord(c)
+	if !ok {
 		return
 	}
+	self.sendHttpBody(c, rcd)
+}
 
-	session := self.orm.NewSession()
-	defer session.Close()
+func (self *WebServer) getHttpFiles(c *gin.Context) {
+	rcd, ok := self.visibleHttpRecord(c)
+	if !ok {
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblHttpFile
+	err := session.Where(`rid=?`, rcd.Id).Asc("n").Find(&items)
+	if err != nil {
+		logrus.Errorf("[webui.go::getHttpFiles] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	files := make([]models.HttpFile, len(items))
+	for i := 0; i < len(items); i++ {
+		file := &files[i]
+		item := &items[i]
+		file.N = item.N
+		file.Field = item.Field
+		file.Filename = item.Filename
+		file.Ctype = item.Ctype
+		file.Size = item.Size
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  files,
+	})
+}
+
+func (self *WebServer) getHttpFile(c *gin.Context) {
+	n, err := strconv.Atoi(c.Param("n"))
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+	rcd, ok := self.visibleHttpRecord(c)
+	if !ok {
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var file models.TblHttpFile
+	exist, err := session.Where(`rid=?`, rcd.Id).And(`n=?`, n).Get(&file)
+	if err != nil {
+		logrus.Errorf("[webui.go::getHttpFile] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if !exist {
+		self.resp(c, 404, &CR{
+			Message: "No such file",
+			Code:    CodeNoData,
+		})
+		return
+	}
+
+	content, err := self.blob.Get(file.Blob)
+	if err != nil {
+		logrus.Errorf("[webui.go::getHttpFile] blob.Get(%v): %v", file.Blob, err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	filename := file.Filename
+	if filename == "" {
+		filename = fmt.Sprintf("http-%v-%v.bin", rcd.Id, file.N)
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filepath.Base(filename),
+	}))
+	c.Data(200, "application/octet-stream", content)
+}
+
+// visibleHttpRecord get http record by param id which current user can access
+func (self *WebServer) visibleHttpRecord(c *gin.Context) (*models.TblHttp, bool) {
+	rid, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return nil, false
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.Where(`id=?`, rid)
+	default:
+		session = session.Where(`id=?`, rid).In("uid", self.teamUids(id, false))
+	}
+
+	var rcd models.TblHttp
+	exist, err := self.getHit(session, &rcd)
+	if err != nil {
+		logrus.Errorf("[webui.go::visibleHttpRecord] orm.Get: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return nil, false
+	} else if !exist {
+		self.resp(c, 404, &CR{
+			Message: "No such record",
+			Code:    CodeNoData,
+		})
+		return nil, false
+	}
+	return &rcd, true
+}
+
+func (self *WebServer) delHttpRecord(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		logrus.Errorf("[webui.go::delHttpRecord] orm.Delete: %v", err)
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeServerInternal,
+			Error:   err,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+	session, err = self.deleteFilter(session, &req)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: err.Error(),
+			Code:    CodeBadData,
+		})
+		return
+	}
 
 	role := c.GetInt("role")
 	id := c.GetInt64("id")
@@ -1191,7 +1838,7 @@ func (self *WebServer) delHttpRecord(c *gin.Context) {
 	switch role {
 	case roleAdmin, roleSuper:
 		if len(req.Ids) == 0 {
-			_, err := session.In(`uid`, id, 0).Delete(&models.TblHttp{})
+			n, err := self.deleteHits(session.In(`uid`, id, 0), &models.TblHttp{})
 			if err != nil {
 				//TODO:
 				logrus.Errorf("[webui.go::delHttpRecord] orm.Delete: %v", err)
@@ -1203,6 +1850,7 @@ func (self *WebServer) delHttpRecord(c *gin.Context) {
 			}
 			self.resp(c, 200, &CR{
 				Message: "OK",
+				Result:  n,
 			})
 			return
 		} else {
@@ -1210,7 +1858,7 @@ func (self *WebServer) delHttpRecord(c *gin.Context) {
 			for i := 0; i < len(req.Ids); i++ {
 				params[i] = req.Ids[i]
 			}
-			_, err := session.In(`uid`, id, 0).In("id", params...).Delete(&models.TblHttp{})
+			n, err := self.deleteHits(session.In(`uid`, id, 0).In("id", params...), &models.TblHttp{})
 			if err != nil {
 				logrus.Errorf("[webui.go::delHttpRecord] orm.Delete: %v", err)
 				self.resp(c, 502, &CR{
@@ -1221,12 +1869,13 @@ func (self *WebServer) delHttpRecord(c *gin.Context) {
 			}
 			self.resp(c, 200, &CR{
 				Message: "OK",
+				Result:  n,
 			})
 			return
 		}
 	default:
 		if len(req.Ids) == 0 {
-			_, err := session.Where(`uid=?`, id).Delete(&models.TblHttp{})
+			n, err := self.deleteHits(session.Where(`uid=?`, id), &models.TblHttp{})
 			if err != nil {
 				//TODO:
 				logrus.Errorf("[webui.go::delHttpRecord] orm.Delete: %v", err)
@@ -1238,6 +1887,7 @@ func (self *WebServer) delHttpRecord(c *gin.Context) {
 			}
 			self.resp(c, 200, &CR{
 				Message: "OK",
+				Result:  n,
 			})
 			return
 		} else {
@@ -1245,7 +1895,7 @@ func (self *WebServer) delHttpRecord(c *gin.Context) {
 			for i := 0; i < len(req.Ids); i++ {
 				params[i] = req.Ids[i]
 			}
-			_, err := session.Where(`uid=?`, id).In("id", params...).Delete(&models.TblHttp{})
+			n, err := self.deleteHits(session.In("uid", self.teamUids(id, true)).In("id", params...), &models.TblHttp{})
 			if err != nil {
 				logrus.Errorf("[webui.go::delHttpRecord] orm.Delete: %v", err)
 				self.resp(c, 502, &CR{
@@ -1256,8 +1906,868 @@ func (self *WebServer) delHttpRecord(c *gin.Context) {
 			}
 			self.resp(c, 200, &CR{
 				Message: "OK",
+				Result:  n,
 			})
 			return
 		}
 	}
 }
+
+func smtpRecord(item *models.TblSmtp) models.SmtpRecord {
+	var to []string
+	if item.RcptTo != "" {
+		to = strings.Split(item.RcptTo, ",")
+	}
+	return models.SmtpRecord{
+		Id:        item.Id,
+		Ip:        item.Ip,
+		Helo:      item.Helo,
+		From:      item.MailFrom,
+		To:        to,
+		Subject:   item.Subject,
+		Headers:   item.Headers,
+		Body:      item.Body,
+		Size:      item.Size,
+		Truncated: item.Truncated,
+		Note:      item.Note,
+		Ctime:     item.Ctime,
+	}
+}
+
+func (self *WebServer) getSmtpRecord(c *gin.Context) {
+	ip, ipExist := c.GetQuery("ip")
+	from, fromExist := c.GetQuery("from")
+	to, toExist := c.GetQuery("to")
+	data, dataExist := c.GetQuery("data")
+
+	pageNo, pageNoErr := ginutils.GetQueryInt(c, "pageNo")
+	if pageNoErr != nil || pageNo <= 0 {
+		pageNo = 1
+	}
+	pageSize, pageSizeErr := ginutils.GetQueryInt(c, "pageSize")
+	if pageSizeErr != nil || pageSize <= 0 {
+		pageSize = 10
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.Where(`id>0`)
+	default:
+		session = session.In("uid", self.teamUids(id, false))
+	}
+
+	if ipExist {
+		session = session.And(`ip like ?`, "%"+ip+"%")
+	}
+	if fromExist {
+		session = session.And(`mail_from like ?`, "%"+from+"%")
+	}
+	if toExist {
+		session = session.And(`rcpt_to like ?`, "%"+to+"%")
+	}
+	if dataExist {
+		session = session.And(`(subject like ? or body like ?)`, "%"+data+"%", "%"+data+"%")
+	}
+
+	var items []models.TblSmtp
+	count, err := session.Desc("id").Limit(pageSize, (pageNo-1)*pageSize).FindAndCount(&items)
+	if err != nil {
+		logrus.Errorf("[webui.go::getSmtpRecord] orm.FindAndCount: %v", err)
+		self.resp(c, 502, &CR{
+			Code:    CodeServerInternal,
+			Message: "Faild",
+		})
+		return
+	}
+
+	var resp SmtpRecordResp
+	resp.TotalCount = int(count)
+	resp.PageSize = pageSize
+	resp.PageNo = pageNo
+	resp.TotalPage = (resp.TotalCount + (pageSize - 1)) / pageSize
+	resp.Data = make([]models.SmtpRecord, len(items))
+	for i := 0; i < len(items); i++ {
+		resp.Data[i] = smtpRecord(&items[i])
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  &resp,
+	})
+}
+
+func (self *WebServer) delSmtpRecord(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.In(`uid`, id, 0)
+	default:
+		uids := []int64{id}
+		if len(req.Ids) > 0 {
+			uids = self.teamUids(id, true)
+		}
+		session = session.In("uid", uids)
+	}
+	if len(req.Ids) > 0 {
+		params := make([]interface{}, len(req.Ids))
+		for i := 0; i < len(req.Ids); i++ {
+			params[i] = req.Ids[i]
+		}
+		session = session.In("id", params...)
+	}
+
+	_, err = session.Delete(&models.TblSmtp{})
+	if err != nil {
+		logrus.Errorf("[webui.go::delSmtpRecord] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func ldapRecord(item *models.TblLdap) models.LdapRecord {
+	return models.LdapRecord{
+		Id:       item.Id,
+		Ip:       item.Ip,
+		Op:       item.Op,
+		Dn:       item.Dn,
+		Password: item.Password,
+		Note:     item.Note,
+		Ctime:    item.Ctime,
+	}
+}
+
+func (self *WebServer) getLdapRecord(c *gin.Context) {
+	ip, ipExist := c.GetQuery("ip")
+	dn, dnExist := c.GetQuery("dn")
+	op, opExist := c.GetQuery("op")
+
+	pageNo, pageNoErr := ginutils.GetQueryInt(c, "pageNo")
+	if pageNoErr != nil || pageNo <= 0 {
+		pageNo = 1
+	}
+	pageSize, pageSizeErr := ginutils.GetQueryInt(c, "pageSize")
+	if pageSizeErr != nil || pageSize <= 0 {
+		pageSize = 10
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.Where(`id>0`)
+	default:
+		session = session.In("uid", self.teamUids(id, false))
+	}
+
+	if ipExist {
+		session = session.And(`ip like ?`, "%"+ip+"%")
+	}
+	if dnExist {
+		session = session.And(`dn like ?`, "%"+dn+"%")
+	}
+	if opExist {
+		session = session.And(`op = ?`, op)
+	}
+
+	var items []models.TblLdap
+	count, err := session.Desc("id").Limit(pageSize, (pageNo-1)*pageSize).FindAndCount(&items)
+	if err != nil {
+		logrus.Errorf("[webui.go::getLdapRecord] orm.FindAndCount: %v", err)
+		self.resp(c, 502, &CR{
+			Code:    CodeServerInternal,
+			Message: "Faild",
+		})
+		return
+	}
+
+	var resp LdapRecordResp
+	resp.TotalCount = int(count)
+	resp.PageSize = pageSize
+	resp.PageNo = pageNo
+	resp.TotalPage = (resp.TotalCount + (pageSize - 1)) / pageSize
+	resp.Data = make([]models.LdapRecord, len(items))
+	for i := 0; i < len(items); i++ {
+		resp.Data[i] = ldapRecord(&items[i])
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  &resp,
+	})
+}
+
+func (self *WebServer) delLdapRecord(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.In(`uid`, id, 0)
+	default:
+		uids := []int64{id}
+		if len(req.Ids) > 0 {
+			uids = self.teamUids(id, true)
+		}
+		session = session.In("uid", uids)
+	}
+	if len(req.Ids) > 0 {
+		params := make([]interface{}, len(req.Ids))
+		for i := 0; i < len(req.Ids); i++ {
+			params[i] = req.Ids[i]
+		}
+		session = session.In("id", params...)
+	}
+
+	_, err = session.Delete(&models.TblLdap{})
+	if err != nil {
+		logrus.Errorf("[webui.go::delLdapRecord] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func ftpRecord(item *models.TblFtp) models.FtpRecord {
+	return models.FtpRecord{
+		Id:       item.Id,
+		Ip:       item.Ip,
+		User:     item.User,
+		Password: item.Password,
+		Paths:    item.Paths,
+		Commands: item.Commands,
+		Note:     item.Note,
+		Ctime:    item.Ctime,
+	}
+}
+
+func (self *WebServer) getFtpRecord(c *gin.Context) {
+	ip, ipExist := c.GetQuery("ip")
+	user, userExist := c.GetQuery("user")
+	path, pathExist := c.GetQuery("path")
+
+	pageNo, pageNoErr := ginutils.GetQueryInt(c, "pageNo")
+	if pageNoErr != nil || pageNo <= 0 {
+		pageNo = 1
+	}
+	pageSize, pageSizeErr := ginutils.GetQueryInt(c, "pageSize")
+	if pageSizeErr != nil || pageSize <= 0 {
+		pageSize = 10
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.Where(`id>0`)
+	default:
+		session = session.In("uid", self.teamUids(id, false))
+	}
+
+	if ipExist {
+		session = session.And(`ip like ?`, "%"+ip+"%")
+	}
+	if userExist {
+		session = session.And(`user like ?`, "%"+user+"%")
+	}
+	if pathExist {
+		session = session.And(`paths like ?`, "%"+path+"%")
+	}
+
+	var items []models.TblFtp
+	count, err := session.Desc("id").Limit(pageSize, (pageNo-1)*pageSize).FindAndCount(&items)
+	if err != nil {
+		logrus.Errorf("[webui.go::getFtpRecord] orm.FindAndCount: %v", err)
+		self.resp(c, 502, &CR{
+			Code:    CodeServerInternal,
+			Message: "Faild",
+		})
+		return
+	}
+
+	var resp FtpRecordResp
+	resp.TotalCount = int(count)
+	resp.PageSize = pageSize
+	resp.PageNo = pageNo
+	resp.TotalPage = (resp.TotalCount + (pageSize - 1)) / pageSize
+	resp.Data = make([]models.FtpRecord, len(items))
+	for i := 0; i < len(items); i++ {
+		resp.Data[i] = ftpRecord(&items[i])
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  &resp,
+	})
+}
+
+func (self *WebServer) delFtpRecord(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.In(`uid`, id, 0)
+	default:
+		uids := []int64{id}
+		if len(req.Ids) > 0 {
+			uids = self.teamUids(id, true)
+		}
+		session = session.In("uid", uids)
+	}
+	if len(req.Ids) > 0 {
+		params := make([]interface{}, len(req.Ids))
+		for i := 0; i < len(req.Ids); i++ {
+			params[i] = req.Ids[i]
+		}
+		session = session.In("id", params...)
+	}
+
+	_, err = session.Delete(&models.TblFtp{})
+	if err != nil {
+		logrus.Errorf("[webui.go::delFtpRecord] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func tcpRecord(item *models.TblTcp) models.TcpRecord {
+	return models.TcpRecord{
+		Id:    item.Id,
+		Ip:    item.Ip,
+		Port:  item.Port,
+		Data:  item.Data,
+		Size:  item.Size,
+		Note:  item.Note,
+		Ctime: item.Ctime,
+	}
+}
+
+func (self *WebServer) getTcpRecord(c *gin.Context) {
+	ip, ipExist := c.GetQuery("ip")
+	port, portErr := ginutils.GetQueryInt(c, "port")
+
+	pageNo, pageNoErr := ginutils.GetQueryInt(c, "pageNo")
+	if pageNoErr != nil || pageNo <= 0 {
+		pageNo = 1
+	}
+	pageSize, pageSizeErr := ginutils.GetQueryInt(c, "pageSize")
+	if pageSizeErr != nil || pageSize <= 0 {
+		pageSize = 10
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.Where(`id>0`)
+	default:
+		session = session.In("uid", self.teamUids(id, false))
+	}
+
+	if ipExist {
+		session = session.And(`ip like ?`, "%"+ip+"%")
+	}
+	if portErr == nil && port > 0 {
+		session = session.And(`port=?`, port)
+	}
+
+	var items []models.TblTcp
+	count, err := session.Desc("id").Limit(pageSize, (pageNo-1)*pageSize).FindAndCount(&items)
+	if err != nil {
+		logrus.Errorf("[webui.go::getTcpRecord] orm.FindAndCount: %v", err)
+		self.resp(c, 502, &CR{
+			Code:    CodeServerInternal,
+			Message: "Faild",
+		})
+		return
+	}
+
+	var resp TcpRecordResp
+	resp.TotalCount = int(count)
+	resp.PageSize = pageSize
+	resp.PageNo = pageNo
+	resp.TotalPage = (resp.TotalCount + (pageSize - 1)) / pageSize
+	resp.Data = make([]models.TcpRecord, len(items))
+	for i := 0; i < len(items); i++ {
+		resp.Data[i] = tcpRecord(&items[i])
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  &resp,
+	})
+}
+
+func (self *WebServer) delTcpRecord(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.In(`uid`, id, 0)
+	default:
+		uids := []int64{id}
+		if len(req.Ids) > 0 {
+			uids = self.teamUids(id, true)
+		}
+		session = session.In("uid", uids)
+	}
+	if len(req.Ids) > 0 {
+		params := make([]interface{}, len(req.Ids))
+		for i := 0; i < len(req.Ids); i++ {
+			params[i] = req.Ids[i]
+		}
+		session = session.In("id", params...)
+	}
+
+	_, err = session.Delete(&models.TblTcp{})
+	if err != nil {
+		logrus.Errorf("[webui.go::delTcpRecord] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func icmpRecord(item *models.TblIcmp) models.IcmpRecord {
+	return models.IcmpRecord{
+		Id:     item.Id,
+		Ip:     item.Ip,
+		EchoId: item.EchoId,
+		Seq:    item.Seq,
+		Data:   item.Data,
+		Size:   item.Size,
+		Note:   item.Note,
+		Ctime:  item.Ctime,
+	}
+}
+
+func (self *WebServer) getIcmpRecord(c *gin.Context) {
+	ip, ipExist := c.GetQuery("ip")
+
+	pageNo, pageNoErr := ginutils.GetQueryInt(c, "pageNo")
+	if pageNoErr != nil || pageNo <= 0 {
+		pageNo = 1
+	}
+	pageSize, pageSizeErr := ginutils.GetQueryInt(c, "pageSize")
+	if pageSizeErr != nil || pageSize <= 0 {
+		pageSize = 10
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.Where(`id>0`)
+	default:
+		session = session.In("uid", self.teamUids(id, false))
+	}
+
+	if ipExist {
+		session = session.And(`ip like ?`, "%"+ip+"%")
+	}
+
+	var items []models.TblIcmp
+	count, err := session.Desc("id").Limit(pageSize, (pageNo-1)*pageSize).FindAndCount(&items)
+	if err != nil {
+		logrus.Errorf("[webui.go::getIcmpRecord] orm.FindAndCount: %v", err)
+		self.resp(c, 502, &CR{
+			Code:    CodeServerInternal,
+			Message: "Faild",
+		})
+		return
+	}
+
+	var resp IcmpRecordResp
+	resp.TotalCount = int(count)
+	resp.PageSize = pageSize
+	resp.PageNo = pageNo
+	resp.TotalPage = (resp.TotalCount + (pageSize - 1)) / pageSize
+	resp.Data = make([]models.IcmpRecord, len(items))
+	for i := 0; i < len(items); i++ {
+		resp.Data[i] = icmpRecord(&items[i])
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  &resp,
+	})
+}
+
+func (self *WebServer) delIcmpRecord(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.In(`uid`, id, 0)
+	default:
+		uids := []int64{id}
+		if len(req.Ids) > 0 {
+			uids = self.teamUids(id, true)
+		}
+		session = session.In("uid", uids)
+	}
+	if len(req.Ids) > 0 {
+		params := make([]interface{}, len(req.Ids))
+		for i := 0; i < len(req.Ids); i++ {
+			params[i] = req.Ids[i]
+		}
+		session = session.In("id", params...)
+	}
+
+	_, err = session.Delete(&models.TblIcmp{})
+	if err != nil {
+		logrus.Errorf("[webui.go::delIcmpRecord] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func smbRecord(item *models.TblSmb) models.SmbRecord {
+	return models.SmbRecord{
+		Id:     item.Id,
+		Ip:     item.Ip,
+		Host:   item.Host,
+		Domain: item.Domain,
+		User:   item.User,
+		Target: item.Target,
+		Os:     item.Os,
+		Hash:   item.Hash,
+		Note:   item.Note,
+		Ctime:  item.Ctime,
+	}
+}
+
+func (self *WebServer) getSmbRecord(c *gin.Context) {
+	ip, ipExist := c.GetQuery("ip")
+	user, userExist := c.GetQuery("user")
+	host, hostExist := c.GetQuery("host")
+
+	pageNo, pageNoErr := ginutils.GetQueryInt(c, "pageNo")
+	if pageNoErr != nil || pageNo <= 0 {
+		pageNo = 1
+	}
+	pageSize, pageSizeErr := ginutils.GetQueryInt(c, "pageSize")
+	if pageSizeErr != nil || pageSize <= 0 {
+		pageSize = 10
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.Where(`id>0`)
+	default:
+		session = session.In("uid", self.teamUids(id, false))
+	}
+
+	if ipExist {
+		session = session.And(`ip like ?`, "%"+ip+"%")
+	}
+	if userExist {
+		session = session.And(`user like ?`, "%"+user+"%")
+	}
+	if hostExist {
+		session = session.And(`host like ?`, "%"+host+"%")
+	}
+
+	var items []models.TblSmb
+	count, err := session.Desc("id").Limit(pageSize, (pageNo-1)*pageSize).FindAndCount(&items)
+	if err != nil {
+		logrus.Errorf("[webui.go::getSmbRecord] orm.FindAndCount: %v", err)
+		self.resp(c, 502, &CR{
+			Code:    CodeServerInternal,
+			Message: "Faild",
+		})
+		return
+	}
+
+	var resp SmbRecordResp
+	resp.TotalCount = int(count)
+	resp.PageSize = pageSize
+	resp.PageNo = pageNo
+	resp.TotalPage = (resp.TotalCount + (pageSize - 1)) / pageSize
+	resp.Data = make([]models.SmbRecord, len(items))
+	for i := 0; i < len(items); i++ {
+		resp.Data[i] = smbRecord(&items[i])
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  &resp,
+	})
+}
+
+func (self *WebServer) delSmbRecord(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.In(`uid`, id, 0)
+	default:
+		uids := []int64{id}
+		if len(req.Ids) > 0 {
+			uids = self.teamUids(id, true)
+		}
+		session = session.In("uid", uids)
+	}
+	if len(req.Ids) > 0 {
+		params := make([]interface{}, len(req.Ids))
+		for i := 0; i < len(req.Ids); i++ {
+			params[i] = req.Ids[i]
+		}
+		session = session.In("id", params...)
+	}
+
+	_, err = session.Delete(&models.TblSmb{})
+	if err != nil {
+		logrus.Errorf("[webui.go::delSmbRecord] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
+
+func rmiRecord(item *models.TblRmi) models.RmiRecord {
+	return models.RmiRecord{
+		Id:    item.Id,
+		Ip:    item.Ip,
+		Host:  item.Host,
+		Name:  item.Name,
+		Note:  item.Note,
+		Ctime: item.Ctime,
+	}
+}
+
+func (self *WebServer) getRmiRecord(c *gin.Context) {
+	ip, ipExist := c.GetQuery("ip")
+	name, nameExist := c.GetQuery("name")
+	host, hostExist := c.GetQuery("host")
+
+	pageNo, pageNoErr := ginutils.GetQueryInt(c, "pageNo")
+	if pageNoErr != nil || pageNo <= 0 {
+		pageNo = 1
+	}
+	pageSize, pageSizeErr := ginutils.GetQueryInt(c, "pageSize")
+	if pageSizeErr != nil || pageSize <= 0 {
+		pageSize = 10
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.Where(`id>0`)
+	default:
+		session = session.In("uid", self.teamUids(id, false))
+	}
+
+	if ipExist {
+		session = session.And(`ip like ?`, "%"+ip+"%")
+	}
+	if nameExist {
+		session = session.And(`name like ?`, "%"+name+"%")
+	}
+	if hostExist {
+		session = session.And(`host like ?`, "%"+host+"%")
+	}
+
+	var items []models.TblRmi
+	count, err := session.Desc("id").Limit(pageSize, (pageNo-1)*pageSize).FindAndCount(&items)
+	if err != nil {
+		logrus.Errorf("[webui.go::getRmiRecord] orm.FindAndCount: %v", err)
+		self.resp(c, 502, &CR{
+			Code:    CodeServerInternal,
+			Message: "Faild",
+		})
+		return
+	}
+
+	var resp RmiRecordResp
+	resp.TotalCount = int(count)
+	resp.PageSize = pageSize
+	resp.PageNo = pageNo
+	resp.TotalPage = (resp.TotalCount + (pageSize - 1)) / pageSize
+	resp.Data = make([]models.RmiRecord, len(items))
+	for i := 0; i < len(items); i++ {
+		resp.Data[i] = rmiRecord(&items[i])
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  &resp,
+	})
+}
+
+func (self *WebServer) delRmiRecord(c *gin.Context) {
+	var req DeleteRecordRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "invalid Param",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	role := c.GetInt("role")
+	id := c.GetInt64("id")
+	switch role {
+	case roleAdmin, roleSuper:
+		session = session.In(`uid`, id, 0)
+	default:
+		uids := []int64{id}
+		if len(req.Ids) > 0 {
+			uids = self.teamUids(id, true)
+		}
+		session = session.In("uid", uids)
+	}
+	if len(req.Ids) > 0 {
+		params := make([]interface{}, len(req.Ids))
+		for i := 0; i < len(req.Ids); i++ {
+			params[i] = req.Ids[i]
+		}
+		session = session.In("id", params...)
+	}
+
+	_, err = session.Delete(&models.TblRmi{})
+	if err != nil {
+		logrus.Errorf("[webui.go::delRmiRecord] orm.Delete: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+	})
+}
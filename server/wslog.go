@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	MAX_WS_FRAMES   = 1000
+	WS_IDLE_TIMEOUT = 60 * time.Second
+)
+
+type wsFrame struct {
+	data        []byte
+	payloadType byte
+}
+
+// frameCodec receive text and binary frame, keep the frame type
+var frameCodec = websocket.Codec{
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		frame := v.(*wsFrame)
+		frame.data = data
+		frame.payloadType = payloadType
+		return nil
+	},
+}
+
+func isWebSocket(r *http.Request) bool {
+	return r.ProtoMajor == 1 && r.Method == "GET" &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// wsUser get user of /log/ws/*any by request host, or by first path segment
+// ws://${shortId}.godnslog.com/log/ws/${var}
+// ws://godnslog.com/log/ws/${shortId}/${var}
+func (self *WebServer) wsUser(c *gin.Context) (*models.TblUser, string) {
+	variable := c.Param("any")
+	if user := self.hostUser(c); user != nil {
+		return user, variable
+	}
+
+	seg := strings.SplitN(strings.TrimPrefix(variable, "/"), "/", 2)
+	v, exist := self.store.Get(seg[0] + ".suser")
+	if !exist {
+		return nil, variable
+	}
+	if len(seg) > 1 {
+		variable = "/" + seg[1]
+	} else {
+		variable = "/"
+	}
+	return v.(*models.TblUser), variable
+}
+
+// serveWebSocket accept upgrade of handshake rcd, save each frame received as child of rcd
+func (self *WebServer) serveWebSocket(c *gin.Context, user *models.TblUser, rcd *models.TblHttp) {
+	server := websocket.Server{
+		//accept any origin
+		Handshake: func(*websocket.Config, *http.Request) error {
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			session := self.orm.NewSession()
+			defer session.Close()
+
+			ws.MaxPayloadBytes = int(self.maxBodySize(user))
+			for n := 0; n < MAX_WS_FRAMES; n++ {
+				ws.SetReadDeadline(time.Now().Add(WS_IDLE_TIMEOUT))
+
+				var frame wsFrame
+				err := frameCodec.Receive(ws, &frame)
+				if err == websocket.ErrFrameTooLarge {
+					logrus.Infof("[wslog.go::serveWebSocket] frame(id=%v, n=%v) too large", rcd.Id, n)
+					continue
+				} else if err != nil {
+					return
+				}
+
+				item := &models.TblHttpFrame{
+					Uid:  rcd.Uid,
+					Rid:  rcd.Id,
+					N:    n,
+					Size: int64(len(frame.data)),
+				}
+				if frame.payloadType == websocket.BinaryFrame {
+					item.Binary = true
+					item.Data = base64.StdEncoding.EncodeToString(frame.data)
+				} else {
+					item.Data = string(frame.data)
+				}
+				_, err = session.InsertOne(item)
+				if err != nil {
+					logrus.Errorf("[wslog.go::serveWebSocket] orm.InsertOne: %v", err)
+					return
+				}
+			}
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+func (self *WebServer) sendHttpFrames(c *gin.Context, rid int64) {
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	var items []models.TblHttpFrame
+	err := session.Where(`rid=?`, rid).Asc("n").Find(&items)
+	if err != nil {
+		logrus.Errorf("[wslog.go::sendHttpFrames] orm.Find: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	}
+
+	frames := make([]models.HttpFrame, len(items))
+	for i := 0; i < len(items); i++ {
+		frame := &frames[i]
+		item := &items[i]
+		frame.N = item.N
+		frame.Binary = item.Binary
+		frame.Data = item.Data
+		frame.Size = item.Size
+		frame.Ctime = item.Ctime
+	}
+	self.resp(c, 200, &CR{
+		Message: "OK",
+		Result:  frames,
+	})
+}
+
+func (self *WebServer) getHttpFrames(c *gin.Context) {
+	rcd, ok := self.visibleHttpRecord(c)
+	if !ok {
+		return
+	}
+	self.sendHttpFrames(c, rcd.Id)
+}
+
+// curl http://${shortId}.godnslog.com/data/http/${id}/frames
+func (self *WebServer) queryHttpFrames(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		self.resp(c, 400, &CR{
+			Message: "id parameter required",
+			Code:    CodeBadData,
+		})
+		return
+	}
+
+	session := self.orm.NewSession()
+	defer session.Close()
+
+	exist, err := self.existHit(session.Where(`uid=?`, c.GetInt64("uid")).And(`id=?`, id), &models.TblHttp{})
+	if err != nil {
+		logrus.Errorf("[wslog.go::queryHttpFrames] orm.Exist: %v", err)
+		self.resp(c, 502, &CR{
+			Message: "Failed",
+			Code:    CodeServerInternal,
+		})
+		return
+	} else if !exist {
+		self.resp(c, 404, &CR{
+			Message: "No such record",
+			Code:    CodeNoData,
+		})
+		return
+	}
+	self.sendHttpFrames(c, id)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+Minimal xlsx writer, one sheet of inline strings, rows are streamed into zip
+*/
+
+const (
+	MAX_XLSX_CELL = 32767 //excel limit of cell text
+)
+
+const xlsxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+</Types>`
+
+const xlsxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const xlsxWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="{{name}}" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+const xlsxWorkbookRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+</Relationships>`
+
+type xlsxWriter struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	n     int
+	err   error
+}
+
+func newXlsxWriter(w io.Writer, name string) *xlsxWriter {
+	x := &xlsxWriter{zw: zip.NewWriter(w)}
+	x.file("[Content_Types].xml", xlsxContentTypes)
+	x.file("_rels/.rels", xlsxRels)
+	x.file("xl/workbook.xml", strings.Replace(xlsxWorkbook, "{{name}}", xlsxEscape(name), 1))
+	x.file("xl/_rels/workbook.xml.rels", xlsxWorkbookRels)
+	if x.err == nil {
+		x.sheet, x.err = x.zw.Create("xl/worksheets/sheet1.xml")
+	}
+	if x.err == nil {
+		_, x.err = io.WriteString(x.sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n"+
+			`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	}
+	return x
+}
+
+func (x *xlsxWriter) file(name, content string) {
+	if x.err != nil {
+		return
+	}
+	var f io.Writer
+	f, x.err = x.zw.Create(name)
+	if x.err == nil {
+		_, x.err = io.WriteString(f, content)
+	}
+}
+
+// xlsxEscape escape xml text, characters invalid in xml are replaced by U+FFFD
+func xlsxEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// Write add a row, cells are text, never formula
+func (x *xlsxWriter) Write(cells []string) error {
+	if x.err != nil {
+		return x.err
+	}
+	x.n++
+	var buf bytes.Buffer
+	buf.WriteString(`<row r="` + strconv.Itoa(x.n) + `">`)
+	for _, cell := range cells {
+		if len(cell) > MAX_XLSX_CELL {
+			cell = strings.ToValidUTF8(cell[:MAX_XLSX_CELL], "")
+		}
+		buf.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		buf.WriteString(xlsxEscape(cell))
+		buf.WriteString(`</t></is></c>`)
+	}
+	buf.WriteString(`</row>`)
+	_, x.err = x.sheet.Write(buf.Bytes())
+	return x.err
+}
+
+func (x *xlsxWriter) Close() error {
+	if x.err != nil {
+		return x.err
+	}
+	if _, err := io.WriteString(x.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return x.zw.Close()
+}
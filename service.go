@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/subcommands"
+)
+
+/*
+Install godnslog as a service, systemd unit on linux or windows service, arguments are command of service
+	godnslog install -user godnslog -dir /var/lib/godnslog serve -config /etc/godnslog/godnslog.yaml
+	godnslog uninstall
+unit is of Type=notify, ready after listeners are started, systemctl reload sends SIGHUP to reload settings.
+windows service runs in directory of binary, relative paths of dsn, blob and dist are of it
+*/
+
+const SERVICE_DEFAULT_NAME = "godnslog"
+
+type serviceConfig struct {
+	name  string
+	exe   string
+	args  []string
+	user  string
+	dir   string
+	start bool
+}
+
+type installCmd struct {
+	name    string
+	user    string
+	dir     string
+	noStart bool
+	print   bool
+}
+
+func (*installCmd) Name() string     { return "install" }
+func (*installCmd) Synopsis() string { return "Install as systemd unit or windows service." }
+func (*installCmd) Usage() string {
+	return `install [-name godnslog] [-user user] [-dir dir] [-no-start] [-print] command [args...]:
+  install a service running command of this binary, eg. serve -config /etc/godnslog/godnslog.yaml.
+`
+}
+
+func (p *installCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.name, "name", SERVICE_DEFAULT_NAME, "name of service, option")
+	f.StringVar(&p.user, "user", "", "run service as user, root if empty, systemd only, option")
+	f.StringVar(&p.dir, "dir", "", "working directory of service, current directory if empty, systemd only, option")
+	f.BoolVar(&p.noStart, "no-start", false, "install without enabling and starting, option")
+	f.BoolVar(&p.print, "print", false, "print unit without installing, option")
+}
+
+func (p *installCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	args := f.Args()
+	if len(args) == 0 || (args[0] != "serve" && args[0] != "ingest") {
+		fmt.Println("command of service required, serve or ingest, eg. install serve -config /etc/godnslog/godnslog.yaml")
+		return subcommands.ExitUsageError
+	}
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Printf("path of binary: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	dir := p.dir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		fmt.Printf("dir: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	cfg := &serviceConfig{
+		name:  p.name,
+		exe:   exe,
+		args:  args,
+		user:  p.user,
+		dir:   dir,
+		start: !p.noStart,
+	}
+	if p.print {
+		fmt.Print(serviceDefinition(cfg))
+		return subcommands.ExitSuccess
+	}
+	if err := installService(cfg); err != nil {
+		fmt.Printf("install: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	fmt.Printf("service %v installed\n", p.name)
+	return subcommands.ExitSuccess
+}
+
+type uninstallCmd struct {
+	name string
+}
+
+func (*uninstallCmd) Name() string     { return "uninstall" }
+func (*uninstallCmd) Synopsis() string { return "Stop and remove service installed." }
+func (*uninstallCmd) Usage() string {
+	return `uninstall [-name godnslog]:
+  stop, disable and remove service installed by install.
+`
+}
+
+func (p *uninstallCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.name, "name", SERVICE_DEFAULT_NAME, "name of service, option")
+}
+
+func (p *uninstallCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if err := uninstallService(p.name); err != nil {
+		fmt.Printf("uninstall: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	fmt.Printf("service %v removed\n", p.name)
+	return subcommands.ExitSuccess
+}
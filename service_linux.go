@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const SYSTEMD_UNIT_DIR = "/etc/systemd/system"
+
+// systemdQuote quote argument of ExecStart, specifiers and variables are escaped
+func systemdQuote(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// serviceDefinition is systemd unit
+func serviceDefinition(cfg *serviceConfig) string {
+	execStart := []string{systemdQuote(cfg.exe)}
+	for _, arg := range cfg.args {
+		execStart = append(execStart, systemdQuote(arg))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\n")
+	fmt.Fprintf(&b, "Description=godnslog dns and http log server\n")
+	fmt.Fprintf(&b, "Documentation=https://github.com/chennqqi/godnslog\n")
+	fmt.Fprintf(&b, "Wants=network-online.target\n")
+	fmt.Fprintf(&b, "After=network-online.target\n\n")
+	fmt.Fprintf(&b, "[Service]\n")
+	fmt.Fprintf(&b, "Type=notify\n")
+	fmt.Fprintf(&b, "ExecStart=%v\n", strings.Join(execStart, " "))
+	fmt.Fprintf(&b, "ExecReload=/bin/kill -HUP $MAINPID\n")
+	fmt.Fprintf(&b, "WorkingDirectory=%v\n", cfg.dir)
+	if cfg.user != "" {
+		fmt.Fprintf(&b, "User=%v\n", cfg.user)
+		fmt.Fprintf(&b, "AmbientCapabilities=CAP_NET_BIND_SERVICE CAP_NET_RAW\n")
+	}
+	fmt.Fprintf(&b, "Restart=on-failure\n")
+	fmt.Fprintf(&b, "RestartSec=5\n")
+	fmt.Fprintf(&b, "LimitNOFILE=65536\n\n")
+	fmt.Fprintf(&b, "[Install]\n")
+	fmt.Fprintf(&b, "WantedBy=multi-user.target\n")
+	return b.String()
+}
+
+func systemctl(args ...string) error {
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %v: %v %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func installService(cfg *serviceConfig) error {
+	unit := filepath.Join(SYSTEMD_UNIT_DIR, cfg.name+".service")
+	if _, err := os.Stat(unit); err == nil {
+		return fmt.Errorf("%v exists, uninstall first", unit)
+	}
+	if err := ioutil.WriteFile(unit, []byte(serviceDefinition(cfg)), 0644); err != nil {
+		return err
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return err
+	}
+	if !cfg.start {
+		return nil
+	}
+	return systemctl("enable", "--now", cfg.name)
+}
+
+func uninstallService(name string) error {
+	unit := filepath.Join(SYSTEMD_UNIT_DIR, name+".service")
+	if _, err := os.Stat(unit); err != nil {
+		return err
+	}
+	if err := systemctl("disable", "--now", name); err != nil {
+		return err
+	}
+	if err := os.Remove(unit); err != nil {
+		return err
+	}
+	return systemctl("daemon-reload")
+}
+
+// serveService is for windows service, systemd is notified by sdNotify
+func serveService(sig chan<- os.Signal) (stopped func()) {
+	return func() {}
+}
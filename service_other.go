@@ -0,0 +1,27 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+func serviceDefinition(cfg *serviceConfig) string {
+	return cfg.exe + " " + strings.Join(cfg.args, " ") + "\n"
+}
+
+func installService(cfg *serviceConfig) error {
+	return fmt.Errorf("service is not supported on %v", runtime.GOOS)
+}
+
+func uninstallService(name string) error {
+	return fmt.Errorf("service is not supported on %v", runtime.GOOS)
+}
+
+func serveService(sig chan<- os.Signal) (stopped func()) {
+	return func() {}
+}
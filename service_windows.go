@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const SERVICE_STOP_TIMEOUT = 30 * time.Second
+
+// serviceDefinition is command line of service
+func serviceDefinition(cfg *serviceConfig) string {
+	cmdline := []string{windows.EscapeArg(cfg.exe)}
+	for _, arg := range cfg.args {
+		cmdline = append(cmdline, windows.EscapeArg(arg))
+	}
+	return strings.Join(cmdline, " ") + "\n"
+}
+
+func installService(cfg *serviceConfig) error {
+	if cfg.user != "" {
+		return fmt.Errorf("user is not supported by windows service")
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(cfg.name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %v exists, uninstall first", cfg.name)
+	}
+	s, err := m.CreateService(cfg.name, cfg.exe, mgr.Config{
+		DisplayName: cfg.name,
+		Description: "godnslog dns and http log server",
+		StartType:   mgr.StartAutomatic,
+	}, cfg.args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if !cfg.start {
+		return nil
+	}
+	return s.Start()
+}
+
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if status, err := s.Control(svc.Stop); err == nil {
+		for deadline := time.Now().Add(SERVICE_STOP_TIMEOUT); status.State != svc.Stopped && time.Now().Before(deadline); {
+			time.Sleep(300 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				break
+			}
+		}
+	}
+	return s.Delete()
+}
+
+type windowsService struct {
+	sig     chan<- os.Signal
+	stopped chan struct{}
+}
+
+func (w *windowsService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				select {
+				case w.sig <- os.Interrupt:
+				default:
+				}
+				<-w.stopped
+				return false, 0
+			}
+		case <-w.stopped:
+			//stopped by itself
+			return false, 0
+		}
+	}
+}
+
+// serveService run as windows service if started by service manager, stop and shutdown are sent to sig,
+// stopped should be called when server is down
+func serveService(sig chan<- os.Signal) (stopped func()) {
+	interactive, err := svc.IsAnInteractiveSession()
+	if err != nil || interactive {
+		return func() {}
+	}
+	//working directory of service is system32
+	if exe, err := os.Executable(); err == nil {
+		os.Chdir(filepath.Dir(exe))
+	}
+	w := &windowsService{sig: sig, stopped: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := svc.Run(SERVICE_DEFAULT_NAME, w); err != nil {
+			logrus.Errorf("[service_windows.go::serveService] Run: %v", err)
+		}
+	}()
+	return func() {
+		close(w.stopped)
+		<-done
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/chennqqi/godnslog/server"
+	"github.com/google/subcommands"
+	"github.com/slonzok/getpass"
+)
+
+type userCmd struct {
+	driver string
+	dsn    string
+}
+
+func (*userCmd) Name() string     { return "user" }
+func (*userCmd) Synopsis() string { return "Manage users on the database." }
+func (*userCmd) Usage() string {
+	return `user [-driver sqlite3] [-dsn dsn] <add|resetpass|resettotp|list|disable|enable> [options]:
+  add -name name -email email [-role viewer|operator|admin] [-lang en-US] [-password-stdin]
+  resetpass -name name|email [-password-stdin]
+  resettotp -name name|email
+  list [-json]
+  disable -name name|email
+  enable -name name|email
+password is prompted, or read from the first line of stdin by -password-stdin.
+a running server applies disable to sessions after reload, eg. systemctl reload godnslog.
+`
+}
+
+func (p *userCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.dsn, "dsn", "file:godnslog.db?cache=shared&mode=rwc", "set database source name, option")
+	f.StringVar(&p.driver, "driver", "sqlite3", "set database driver, [sqlite3/mysql/postgres], option")
+}
+
+// readPassword read password from first line of stdin, or prompt twice
+func readPassword(stdin bool) (string, error) {
+	if stdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("read password: %v", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+	pass1 := getpass.Prompt("Please input new password")
+	fmt.Println()
+	pass2 := getpass.Prompt("Please input new password again")
+	fmt.Println()
+	if pass1 != pass2 {
+		return "", fmt.Errorf("passwords not same")
+	}
+	return pass1, nil
+}
+
+func (p *userCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if f.NArg() == 0 {
+		fmt.Print(p.Usage())
+		return subcommands.ExitUsageError
+	}
+	action := f.Arg(0)
+	af := flag.NewFlagSet("user "+action, flag.ContinueOnError)
+	name := af.String("name", "", "name or email of user")
+	var (
+		email, role, lang *string
+		passwordStdin     *bool
+		asJson            *bool
+	)
+	switch action {
+	case "add":
+		email = af.String("email", "", "email of user")
+		role = af.String("role", "operator", "role of user, [viewer/operator/admin], option")
+		lang = af.String("lang", DefaultLanguage, "language of user, [en-US/zh-CN], option")
+		passwordStdin = af.Bool("password-stdin", false, "read password from stdin, option")
+	case "resetpass":
+		passwordStdin = af.Bool("password-stdin", false, "read password from stdin, option")
+	case "list":
+		asJson = af.Bool("json", false, "print users in json, option")
+	case "resettotp", "disable", "enable":
+	default:
+		fmt.Printf("unknown action: %v\n", action)
+		fmt.Print(p.Usage())
+		return subcommands.ExitUsageError
+	}
+	if err := af.Parse(f.Args()[1:]); err != nil {
+		return subcommands.ExitUsageError
+	}
+	if action != "list" && *name == "" {
+		fmt.Println("name required")
+		return subcommands.ExitUsageError
+	}
+
+	m, err := server.NewUserManager(p.driver, p.dsn)
+	if err != nil {
+		fmt.Printf("open database: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	defer m.Close()
+
+	switch action {
+	case "add":
+		user := &models.TblUser{
+			Name:          *name,
+			Email:         *email,
+			Lang:          *lang,
+			CleanInterval: DefaultCleanInterval,
+		}
+		if user.Role, err = server.ParseRole(*role); err != nil {
+			fmt.Println(err)
+			return subcommands.ExitUsageError
+		}
+		if *email == "" {
+			fmt.Println("email required")
+			return subcommands.ExitUsageError
+		}
+		var password string
+		if password, err = readPassword(*passwordStdin); err == nil {
+			err = m.Add(user, password)
+		}
+		if err == nil {
+			fmt.Printf("user %v added, id: %v, domain: %v\n", user.Name, user.Id, user.ShortId)
+		}
+
+	case "resetpass":
+		var password string
+		if password, err = readPassword(*passwordStdin); err == nil {
+			err = m.ResetPassword(*name, password)
+		}
+		if err == nil {
+			fmt.Printf("password of %v reset\n", *name)
+		}
+
+	case "resettotp":
+		if err = m.ResetTotp(*name); err == nil {
+			fmt.Printf("two-factor login and passkeys of %v removed, user may enroll again\n", *name)
+		}
+
+	case "list":
+		var users []models.TblUser
+		if users, err = m.List(); err != nil {
+			break
+		}
+		if *asJson {
+			infos := make([]models.UserInfo, len(users))
+			for i := range users {
+				infos[i] = models.UserInfo{
+					Id:       users[i].Id,
+					Name:     users[i].Name,
+					Email:    users[i].Email,
+					Language: users[i].Lang,
+					Role:     models.Role{Id: server.RoleName(users[i].Role), Name: server.RoleName(users[i].Role)},
+					Disabled: users[i].Disabled,
+					Utime:    users[i].Utime,
+				}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(infos)
+			break
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tEMAIL\tROLE\tDOMAIN\tDISABLED\tUPDATED")
+		for _, u := range users {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", u.Id, u.Name, u.Email, server.RoleName(u.Role), u.ShortId, u.Disabled, u.Utime.Format("2006-01-02 15:04:05"))
+		}
+		err = w.Flush()
+
+	case "disable", "enable":
+		if err = m.SetDisabled(*name, action == "disable"); err == nil {
+			fmt.Printf("user %v %vd, reload running server to apply to sessions\n", *name, action)
+		}
+	}
+	if err != nil {
+		fmt.Printf("%v: %v\n", action, err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
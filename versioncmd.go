@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chennqqi/godnslog/models"
+	"github.com/chennqqi/godnslog/server"
+	"github.com/google/subcommands"
+)
+
+type versionCmd struct {
+	server string
+}
+
+func (*versionCmd) Name() string     { return "version" }
+func (*versionCmd) Synopsis() string { return "Print version of binary or a server." }
+func (*versionCmd) Usage() string {
+	return `version [-server url]:
+  print version and build of this binary, or version and features of a running server.
+`
+}
+
+func (p *versionCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.server, "server", "", "url of a running server, eg. https://log.example.com, option")
+}
+
+func (p *versionCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	info := server.BuildInfo()
+	if p.server != "" {
+		var err error
+		if info, err = remoteVersion(p.server); err != nil {
+			fmt.Printf("version of %v: %v\n", p.server, err)
+			return subcommands.ExitFailure
+		}
+	}
+	fmt.Printf("version: %v\ncommit: %v\nbuild date: %v\ngo: %v\nschema: %v\n",
+		info.Version, info.Commit, info.BuildDate, info.GoVersion, info.Schema)
+	if p.server != "" {
+		fmt.Printf("features: %v\n", strings.Join(info.Features, ", "))
+	}
+	return subcommands.ExitSuccess
+}
+
+func remoteVersion(serverUrl string) (models.VersionInfo, error) {
+	var info models.VersionInfo
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(serverUrl, "/") + "/api/version")
+	if err != nil {
+		return info, err
+	}
+	defer resp.Body.Close()
+	txt, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return info, fmt.Errorf("status %v", resp.StatusCode)
+	}
+	cr := models.CR{Result: &info}
+	return info, json.Unmarshal(txt, &cr)
+}